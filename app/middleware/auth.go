@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"rhone_chat/internal/auth"
+)
+
+// RequireAuth wraps a plain net/http handler so it only runs for requests the
+// authenticator accepts. The principal is attached to the request context and
+// can be read with auth.PrincipalFrom.
+func RequireAuth(authenticator auth.Authenticator, next http.Handler) http.Handler {
+	if authenticator == nil {
+		authenticator = auth.Anonymous()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "authentication failed", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
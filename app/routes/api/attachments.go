@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/vango-go/vango"
+)
+
+type AttachmentResponse struct {
+	ID        string `json:"id"`
+	ChatID    string `json:"chat_id"`
+	FileName  string `json:"file_name"`
+	MediaType string `json:"media_type"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// AttachmentsPOST accepts a multipart upload with "chat_id" and "file"
+// fields. The file stays pending until the next message in the chat.
+func AttachmentsPOST(ctx vango.Ctx) (*vango.Response[AttachmentResponse], error) {
+	return serve(ctx, "uploads are not configured", func(c call) (AttachmentResponse, error) {
+		upload, err := readUpload(c)
+		if err != nil {
+			return AttachmentResponse{}, err
+		}
+		attachment, err := c.chat.UploadAttachment(c.request.Context(), c.principal, upload.chatID, upload.fileName, upload.data)
+		if err != nil {
+			return AttachmentResponse{}, err
+		}
+		return AttachmentResponse{
+			ID:        attachment.ID,
+			ChatID:    attachment.ChatID,
+			FileName:  attachment.FileName,
+			MediaType: attachment.MediaType,
+			SizeBytes: attachment.SizeBytes,
+		}, nil
+	})
+}
+
+// uploadFormOverhead is the room left in an upload body for the multipart
+// framing and the "chat_id" field on top of the file itself.
+const uploadFormOverhead = 64 << 10
+
+// upload is a multipart file upload into a chat.
+type upload struct {
+	chatID   string
+	fileName string
+	data     []byte
+}
+
+// readUpload reads the "chat_id" and "file" fields of an upload, enforcing
+// the attachment size limit. Bodies over the limit are cut off while they
+// are read and answered with 413.
+func readUpload(c call) (upload, error) {
+	request := c.request
+	maxBytes := c.chat.AttachmentMaxBytes()
+	request.Body = http.MaxBytesReader(nil, request.Body, int64(maxBytes)+uploadFormOverhead)
+	if err := request.ParseMultipartForm(int64(maxBytes)); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return upload{}, &vango.HTTPError{
+				Code:    http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("upload is larger than %d bytes", maxBytes),
+				Err:     err,
+			}
+		}
+		return upload{}, vango.BadRequest(fmt.Errorf("parse upload: %w", err))
+	}
+	file, header, err := request.FormFile("file")
+	if err != nil {
+		return upload{}, vango.BadRequest(fmt.Errorf("read upload: %w", err))
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+	if err != nil {
+		return upload{}, fmt.Errorf("read upload: %w", err)
+	}
+	return upload{
+		chatID:   request.FormValue("chat_id"),
+		fileName: header.Filename,
+		data:     data,
+	}, nil
+}
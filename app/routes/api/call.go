@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vango-go/vango"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/httpapi"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// call is an authenticated API request.
+type call struct {
+	chat      *chatsvc.Service
+	request   *http.Request
+	principal auth.Principal
+}
+
+// serve authenticates the request, runs handle and answers with its result.
+// unavailable is the message for a server without a chat service. Errors
+// get the status the REST API gives them.
+func serve[T any](ctx vango.Ctx, unavailable string, handle func(call) (T, error)) (*vango.Response[T], error) {
+	dependencies := getDeps()
+	if dependencies.Chat == nil {
+		return nil, vango.ServiceUnavailable(unavailable)
+	}
+	authenticator := dependencies.Auth
+	if authenticator == nil {
+		authenticator = auth.Anonymous()
+	}
+	request := ctx.Request()
+	principal, err := authenticator.Authenticate(request)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	result, err := handle(call{chat: dependencies.Chat, request: request, principal: principal})
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return vango.OK(result), nil
+}
+
+// apiError gives err its HTTP status. Errors that already carry one are
+// kept, and unexpected ones stay internal errors so their text is not shown
+// to clients.
+func apiError(err error) error {
+	var httpErr *vango.HTTPError
+	if errors.As(err, &httpErr) {
+		return err
+	}
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return vango.Unauthorized("authentication required")
+	}
+	status := httpapi.StatusFor(err)
+	if status == http.StatusInternalServerError {
+		return err
+	}
+	return &vango.HTTPError{Code: status, Message: err.Error(), Err: err}
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"github.com/vango-go/vango"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// ComparisonsGET returns the side-by-side comparisons recorded in the chat
+// named by the "chat_id" query parameter, each with both models' answers
+// and run details, for evaluating the models offline.
+func ComparisonsGET(ctx vango.Ctx) (*vango.Response[[]chatsvc.Comparison], error) {
+	return serve(ctx, "comparisons are not configured", func(c call) ([]chatsvc.Comparison, error) {
+		return c.chat.Comparisons(c.request.Context(), c.principal, c.request.URL.Query().Get("chat_id"))
+	})
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"sync"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/health"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// Deps are the services API handlers use. routes.SetDeps forwards them so
+// the server wires both packages in one place.
+type Deps struct {
+	Chat   *chatsvc.Service
+	Auth   auth.Authenticator
+	Health *health.Probe
+}
+
+var (
+	depsMu sync.RWMutex
+	deps   Deps
+)
+
+func SetDeps(next Deps) {
+	depsMu.Lock()
+	defer depsMu.Unlock()
+	deps = next
+}
+
+func getDeps() Deps {
+	depsMu.RLock()
+	defer depsMu.RUnlock()
+	return deps
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/vango-go/vango"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// EvalDatasetResponse is an exported dataset version with its examples.
+type EvalDatasetResponse struct {
+	Name      string                `json:"name"`
+	Version   int                   `json:"version"`
+	SHA256    string                `json:"sha256"`
+	CreatedAt time.Time             `json:"created_at"`
+	Examples  []chatsvc.EvalExample `json:"examples"`
+}
+
+// EvalsGET downloads a dataset version exported from /evals. The "name"
+// query parameter defaults to the default dataset and "version" to the
+// latest one.
+func EvalsGET(ctx vango.Ctx) (*vango.Response[EvalDatasetResponse], error) {
+	return serve(ctx, "eval datasets are not configured", func(c call) (EvalDatasetResponse, error) {
+		query := c.request.URL.Query()
+		version := 0
+		if raw := query.Get("version"); raw != "" {
+			var err error
+			if version, err = strconv.Atoi(raw); err != nil || version < 1 {
+				return EvalDatasetResponse{}, vango.BadRequestf("invalid dataset version %q", raw)
+			}
+		}
+		dataset, err := c.chat.EvalDataset(c.request.Context(), c.principal, query.Get("name"), version)
+		if err != nil {
+			return EvalDatasetResponse{}, err
+		}
+		examples, err := chatsvc.EvalExamples(dataset)
+		if err != nil {
+			return EvalDatasetResponse{}, err
+		}
+		return EvalDatasetResponse{
+			Name:      dataset.Name,
+			Version:   dataset.Version,
+			SHA256:    dataset.SHA256,
+			CreatedAt: dataset.CreatedAt,
+			Examples:  examples,
+		}, nil
+	})
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/vango-go/vango"
+)
+
+type KnowledgeResponse struct {
+	ID         string `json:"id"`
+	ChatID     string `json:"chat_id"`
+	FileName   string `json:"file_name"`
+	MediaType  string `json:"media_type"`
+	SizeBytes  int64  `json:"size_bytes"`
+	ChunkCount int    `json:"chunk_count"`
+}
+
+// KnowledgePOST adds a PDF or text file from a multipart upload with
+// "chat_id" and "file" fields to the chat's knowledge base.
+func KnowledgePOST(ctx vango.Ctx) (*vango.Response[KnowledgeResponse], error) {
+	return serve(ctx, "uploads are not configured", func(c call) (KnowledgeResponse, error) {
+		upload, err := readUpload(c)
+		if err != nil {
+			return KnowledgeResponse{}, err
+		}
+		document, err := c.chat.UploadKnowledge(c.request.Context(), c.principal, upload.chatID, upload.fileName, upload.data)
+		if err != nil {
+			return KnowledgeResponse{}, err
+		}
+		return KnowledgeResponse{
+			ID:         document.ID,
+			ChatID:     document.ChatID,
+			FileName:   document.FileName,
+			MediaType:  document.MediaType,
+			SizeBytes:  document.SizeBytes,
+			ChunkCount: document.ChunkCount,
+		}, nil
+	})
+}
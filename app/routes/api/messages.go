@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/vango-go/vango"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// MessagesGET returns the stored markdown of the message named by the "id"
+// query parameter, which the markdown island copies to the clipboard.
+func MessagesGET(ctx vango.Ctx) (*vango.Response[chatsvc.RawMessage], error) {
+	return serve(ctx, "messages are not configured", func(c call) (chatsvc.RawMessage, error) {
+		return c.chat.RawMessage(c.request.Context(), c.principal, c.request.URL.Query().Get("id"))
+	})
+}
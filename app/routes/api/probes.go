@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/vango-go/vango"
+
+	"rhone_chat/internal/health"
+)
+
+// LivezGET answers while the process is up, whatever its readiness.
+func LivezGET(ctx vango.Ctx) (*vango.Response[health.Report], error) {
+	return vango.OK(health.Report{Status: "ok", Checks: map[string]string{}}), nil
+}
+
+// ReadyzGET answers with the readiness report once the server can take
+// runs, and fails while it starts or drains for shutdown or when a check
+// fails, so load balancers route around it.
+func ReadyzGET(ctx vango.Ctx) (*vango.Response[health.Report], error) {
+	probe := getDeps().Health
+	if probe == nil {
+		return nil, errors.New("readiness is not configured")
+	}
+	report, ready := probe.Ready(ctx.Request().Context())
+	if !ready {
+		reasons := []string{report.Status}
+		for _, name := range slices.Sorted(maps.Keys(report.Checks)) {
+			if result := report.Checks[name]; result != "ok" {
+				reasons = append(reasons, name+": "+result)
+			}
+		}
+		return nil, errors.New("not ready: " + strings.Join(reasons, "; "))
+	}
+	return vango.OK(report), nil
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/vango-go/vango"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// QuotaGET reports the caller's remaining run allowance and usage budgets
+// for the composer.
+func QuotaGET(ctx vango.Ctx) (*vango.Response[chatsvc.QuotaStatus], error) {
+	return serve(ctx, "quota is not configured", func(c call) (chatsvc.QuotaStatus, error) {
+		return c.chat.QuotaStatus(c.request.Context(), c.principal)
+	})
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/vango-go/vango"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// ToolcallsGET returns the stored input and output of the tool call named by
+// the "id" query parameter, pretty-printed, for the "View full" dialog.
+func ToolcallsGET(ctx vango.Ctx) (*vango.Response[chatsvc.ToolCallDetail], error) {
+	return serve(ctx, "tool calls are not configured", func(c call) (chatsvc.ToolCallDetail, error) {
+		return c.chat.ToolCallDetail(c.request.Context(), c.principal, c.request.URL.Query().Get("id"))
+	})
+}
@@ -0,0 +1,23 @@
+package api
+
+import "github.com/vango-go/vango"
+
+// UnsubscribeResponse confirms a one-click unsubscribe.
+type UnsubscribeResponse struct {
+	Unsubscribed bool `json:"unsubscribed"`
+}
+
+// UnsubscribePOST turns off results emails for the signed "token" query
+// parameter. Mail clients post here for one-click unsubscribe (RFC 8058),
+// without a session.
+func UnsubscribePOST(ctx vango.Ctx) (*vango.Response[UnsubscribeResponse], error) {
+	dependencies := getDeps()
+	if dependencies.Chat == nil {
+		return nil, vango.ServiceUnavailable("email is not configured")
+	}
+	request := ctx.Request()
+	if err := dependencies.Chat.Unsubscribe(request.Context(), request.URL.Query().Get("token")); err != nil {
+		return nil, apiError(err)
+	}
+	return vango.OK(UnsubscribeResponse{Unsubscribed: true}), nil
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/vango-go/vango"
+
+	"rhone_chat/internal/auth"
+)
+
+// principalFor authenticates the HTTP request behind ctx with the configured
+// Authenticator. It runs on SSR entry only; interactive session code reads
+// the principal passed down through props.
+func principalFor(ctx vango.Ctx) (auth.Principal, error) {
+	authenticator := getDeps().Auth
+	if authenticator == nil {
+		authenticator = auth.Anonymous()
+	}
+	return authenticator.Authenticate(ctx.Request())
+}
@@ -3,11 +3,23 @@ package routes
 import (
 	"sync"
 
+	api "rhone_chat/app/routes/api"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/health"
 	chatsvc "rhone_chat/internal/services/chat"
+	"rhone_chat/internal/theme"
 )
 
 type Deps struct {
 	Chat *chatsvc.Service
+	// Auth resolves the caller of SSR and API requests. Nil means the app
+	// runs in single-user mode.
+	Auth auth.Authenticator
+	// Health answers /api/livez and /api/readyz.
+	Health *health.Probe
+	// Themes are the UI themes users pick from. Nil offers the built-in
+	// dark and light ones.
+	Themes *theme.Set
 }
 
 var (
@@ -21,6 +33,7 @@ func SetDeps(next Deps) {
 	defer depsMu.Unlock()
 	deps = next
 	depsOnce = true
+	api.SetDeps(api.Deps{Chat: next.Chat, Auth: next.Auth, Health: next.Health})
 }
 
 func getDeps() Deps {
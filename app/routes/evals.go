@@ -0,0 +1,213 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/vango-go/vango"
+	. "github.com/vango-go/vango/el"
+	"github.com/vango-go/vango/setup"
+
+	"rhone_chat/internal/auth"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// EvalsPage curates golden examples, the answers marked golden in chats,
+// and exports them as versioned datasets for offline evaluation.
+func EvalsPage(ctx vango.Ctx) *vango.VNode {
+	principal, err := principalFor(ctx)
+	if err != nil {
+		return evalsPageShell(P(Class("text-sm text-white/60"), Text("Your request did not carry a recognized identity.")))
+	}
+	return evalsPageShell(EvalsRoot(EvalsRootProps{Principal: principal}))
+}
+
+type EvalsRootProps struct {
+	Principal auth.Principal
+}
+
+// evalsState is what loadEvalsAction fetches.
+type evalsState struct {
+	Examples []chatsvc.GoldenExample
+	Datasets []chatsvc.EvalDataset
+}
+
+func EvalsRoot(props EvalsRootProps) vango.Component {
+	return vango.Setup(props, func(s vango.SetupCtx[EvalsRootProps]) vango.RenderFn {
+		chatService := getDeps().Chat
+		principal := s.Props().Get().Principal
+
+		examples := setup.Signal(&s, []chatsvc.GoldenExample{})
+		datasets := setup.Signal(&s, []chatsvc.EvalDataset{})
+		datasetName := setup.Signal(&s, chatsvc.DefaultEvalDataset)
+		errorText := setup.Signal(&s, "")
+
+		load := func(workCtx context.Context) (evalsState, error) {
+			golden, err := chatService.GoldenExamples(workCtx, principal)
+			if err != nil {
+				return evalsState{}, err
+			}
+			exported, err := chatService.EvalDatasets(workCtx, principal)
+			if err != nil {
+				return evalsState{}, err
+			}
+			return evalsState{Examples: golden, Datasets: exported}, nil
+		}
+		onLoaded := vango.ActionOnSuccess(func(value any) {
+			state, ok := value.(evalsState)
+			if !ok {
+				return
+			}
+			examples.Set(state.Examples)
+			datasets.Set(state.Datasets)
+			errorText.Set("")
+		})
+		onError := vango.ActionOnError(func(err error) {
+			errorText.Set(err.Error())
+		})
+
+		loadEvalsAction := setup.Action(&s,
+			func(workCtx context.Context, _ struct{}) (evalsState, error) {
+				return load(workCtx)
+			},
+			vango.CancelLatest(),
+			onLoaded,
+			onError,
+		)
+
+		exportAction := setup.Action(&s,
+			func(workCtx context.Context, name string) (evalsState, error) {
+				if _, err := chatService.ExportEvalDataset(workCtx, principal, name); err != nil {
+					return evalsState{}, err
+				}
+				return load(workCtx)
+			},
+			vango.DropWhileRunning(),
+			onLoaded,
+			onError,
+		)
+
+		unmarkAction := setup.Action(&s,
+			func(workCtx context.Context, messageID string) (evalsState, error) {
+				if err := chatService.UnmarkGolden(workCtx, principal, messageID); err != nil {
+					return evalsState{}, err
+				}
+				return load(workCtx)
+			},
+			vango.DropWhileRunning(),
+			onLoaded,
+			onError,
+		)
+
+		s.OnMount(func() vango.Cleanup {
+			loadEvalsAction.Run(struct{}{})
+			return nil
+		})
+
+		return func() *vango.VNode {
+			exampleList := examples.Get()
+			var errorNode *vango.VNode
+			if message := errorText.Get(); message != "" {
+				errorNode = P(Class("text-sm text-red-300"), Text(message))
+			}
+			var examplesNode *vango.VNode
+			if len(exampleList) == 0 {
+				examplesNode = P(Class("text-sm text-white/60"), Text("No golden examples yet. Use \"Mark golden\" on a completed answer in a chat."))
+			} else {
+				examplesNode = Div(Class("flex flex-col gap-3"),
+					RangeKeyed(exampleList,
+						func(example chatsvc.GoldenExample) any { return example.ID },
+						func(example chatsvc.GoldenExample) *vango.VNode {
+							return renderGoldenExample(example, func() {
+								unmarkAction.Run(example.MessageID)
+							})
+						},
+					),
+				)
+			}
+			return Div(Class("space-y-6"),
+				errorNode,
+				Div(Class("rounded-md border border-white/10 p-4 space-y-3"),
+					Div(Class("text-sm font-medium"), Text("Export")),
+					P(Class("text-xs text-white/50"), Text("Each export freezes the current golden examples as the next version of the dataset.")),
+					Div(Class("flex gap-2"),
+						Input(
+							Class("flex-1 rounded-md bg-white/5 px-2 py-1 text-sm"),
+							Placeholder(chatsvc.DefaultEvalDataset),
+							Value(datasetName.Get()),
+							OnInput(func(value string) {
+								datasetName.Set(value)
+							}),
+						),
+						Button(
+							Class("rounded-md bg-white/10 px-3 py-1 text-sm hover:bg-white/20 disabled:opacity-50"),
+							OnClick(func() {
+								exportAction.Run(datasetName.Get())
+							}),
+							Disabled(len(exampleList) == 0),
+							Text(fmt.Sprintf("Export %d example%s", len(exampleList), plural(len(exampleList)))),
+						),
+					),
+					RangeKeyed(datasets.Get(),
+						func(dataset chatsvc.EvalDataset) any { return dataset.ID },
+						renderEvalDataset,
+					),
+				),
+				Div(Class("space-y-3"),
+					Div(Class("text-sm font-medium"), Text("Golden examples")),
+					examplesNode,
+				),
+			)
+		}
+	})
+}
+
+func evalsPageShell(body any) *vango.VNode {
+	return Div(Class("h-screen overflow-y-auto bg-black text-white/80"),
+		Div(Class("mx-auto max-w-3xl px-6 py-8 space-y-6"),
+			Div(Class("flex items-center justify-between"),
+				H1(Class("text-xl font-semibold"), Text("Golden examples")),
+				A(Class("text-sm text-white/60 hover:text-white"), Href(RouteIndex), Text("Back to chat")),
+			),
+			body,
+		),
+	)
+}
+
+func renderGoldenExample(example chatsvc.GoldenExample, onRemove func()) *vango.VNode {
+	return Div(Class("rounded-md border border-white/10 p-4 space-y-2"),
+		Div(Class("flex items-center justify-between gap-2 text-xs text-white/50"),
+			Span(Text(example.Model+" · marked "+example.CreatedAt.Local().Format("2006-01-02 15:04"))),
+			Button(Class("rounded-md px-2 py-0.5 hover:bg-white/10"), OnClick(onRemove), Text("Remove")),
+		),
+		If(example.Note != "", P(Class("text-xs text-white/60"), Text(example.Note))),
+		Div(Class("text-sm text-white/70"), Text("Prompt: "+truncateText(goldenPrompt(example), 400))),
+		Div(Class("text-sm whitespace-pre-wrap"), Text(truncateText(example.Answer, 1200))),
+	)
+}
+
+func renderEvalDataset(dataset chatsvc.EvalDataset) *vango.VNode {
+	query := url.Values{"name": {dataset.Name}, "version": {fmt.Sprint(dataset.Version)}}
+	return Div(Class("flex items-center justify-between gap-2 text-xs text-white/60"),
+		Span(Text(fmt.Sprintf("%s v%d · %d example%s · %s · sha256 %s",
+			dataset.Name, dataset.Version, dataset.ExampleCount, plural(dataset.ExampleCount),
+			dataset.CreatedAt.Local().Format("2006-01-02 15:04"), dataset.SHA256[:12]))),
+		A(Class("underline hover:text-white"), Href("/api/evals?"+query.Encode()), Attr("download", ""), Text("Download")),
+	)
+}
+
+// goldenPrompt is the last user message of an example's recorded prompt.
+func goldenPrompt(example chatsvc.GoldenExample) string {
+	var history []chatsvc.AIMessage
+	if err := json.Unmarshal([]byte(example.PromptJSON), &history); err != nil {
+		return ""
+	}
+	for index := len(history) - 1; index >= 0; index-- {
+		if history[index].Role == "user" {
+			return history[index].Content
+		}
+	}
+	return ""
+}
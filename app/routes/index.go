@@ -2,7 +2,13 @@ package routes
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,7 +17,11 @@ import (
 	. "github.com/vango-go/vango/el"
 	"github.com/vango-go/vango/setup"
 
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/i18n"
+	"rhone_chat/internal/moderation"
 	chatsvc "rhone_chat/internal/services/chat"
+	"rhone_chat/internal/theme"
 )
 
 type ToolCallView struct {
@@ -24,12 +34,100 @@ type ToolCallView struct {
 }
 
 type MessageView struct {
+	ID          string
+	Role        string
+	Content     string
+	Reasoning   string
+	Status      string
+	Flag        string
+	Golden      bool
+	ToolCalls   []ToolCallView
+	Attachments []AttachmentView
+	Sources     []chatsvc.Source
+	Runs        []chatsvc.RunDetail
+	CreatedAt   time.Time
+	RunTimeout  time.Duration
+	// ComparisonID groups the two answers of a side-by-side comparison
+	// into one row; ComparisonModel labels each answer's column.
+	ComparisonID    string
+	ComparisonModel string
+	// QueuePosition is the reply's place in line while its run waits for a
+	// stream slot, 1 being next; 0 once it streams.
+	QueuePosition int
+}
+
+// NotificationView tells the user a run finished in a chat they are not
+// looking at. Error is set when the run failed.
+type NotificationView struct {
+	ID        string
+	ChatID    string
+	ChatTitle string
+	RunID     string
+	Error     string
+}
+
+type AttachmentView struct {
 	ID        string
-	Role      string
-	Content   string
-	Status    string
-	ToolCalls []ToolCallView
-	CreatedAt time.Time
+	FileName  string
+	MediaType string
+	SizeBytes int64
+}
+
+// messagePage is what loadMessagesAction fetches for a chat.
+type messagePage struct {
+	Messages    []chatsvc.Message
+	Attachments map[string][]chatsvc.Attachment
+	Golden      map[string]bool
+	Sources     map[string][]chatsvc.Source
+	Runs        map[string][]chatsvc.RunDetail
+}
+
+type replayRequest struct {
+	MessageID string
+	Model     string
+}
+
+type replayResult struct {
+	MessageID string
+	Replays   []chatsvc.ReplayRun
+}
+
+type attachmentRequest struct {
+	ChatID       string
+	AttachmentID string
+}
+
+// KnowledgeView is a document in the chat's knowledge base. Searchable is
+// false for documents embedded before the embedder was changed.
+type KnowledgeView struct {
+	ID         string
+	FileName   string
+	SizeBytes  int64
+	ChunkCount int
+	Searchable bool
+}
+
+type knowledgeRequest struct {
+	ChatID     string
+	DocumentID string
+}
+
+type spendCeilingRequest struct {
+	ChatID  string
+	Ceiling string
+}
+
+type keepForeverRequest struct {
+	ChatID string
+	Keep   bool
+}
+
+type scheduledPromptRequest struct {
+	ChatID    string
+	PromptID  string
+	Prompt    string
+	Frequency string
+	TimeOfDay string
 }
 
 type PendingRun struct {
@@ -39,6 +137,63 @@ type PendingRun struct {
 	AssistantMessageID string
 	Model              string
 	UserContent        string
+	ReuseUserMessage   bool
+	RunTimeout         time.Duration
+	StartedAt          time.Time
+	ComparisonID       string
+	// IdempotencyKey is the composer's key for the message this run sends;
+	// empty for retries and compare runs.
+	IdempotencyKey string
+	// Compare is the second model's run in compare mode. It answers the
+	// same user message alongside this run.
+	Compare *PendingRun
+}
+
+// attempts returns the runs to stream for run: itself, then its compare
+// run when there is one.
+func (run PendingRun) attempts() []PendingRun {
+	if run.Compare == nil {
+		return []PendingRun{run}
+	}
+	return []PendingRun{run, *run.Compare}
+}
+
+// hasAttempt reports whether runID is one of run's attempts.
+func (run PendingRun) hasAttempt(runID string) bool {
+	return slices.ContainsFunc(run.attempts(), func(attempt PendingRun) bool {
+		return attempt.RunID == runID
+	})
+}
+
+// livePendingRun is the page's run for a run executing in the background.
+func livePendingRun(live chatsvc.LiveRun) PendingRun {
+	return PendingRun{
+		RunID:              live.RunID,
+		ChatID:             live.ChatID,
+		UserMessageID:      live.UserMessageID,
+		AssistantMessageID: live.AssistantMessageID,
+		Model:              live.Model,
+		ReuseUserMessage:   true,
+		RunTimeout:         live.RunTimeout,
+		StartedAt:          live.StartedAt,
+		ComparisonID:       live.ComparisonID,
+	}
+}
+
+// runFollow is the page's subscription to a run the service executes in
+// the background.
+type runFollow struct {
+	unsubscribe func()
+}
+
+type moreChats struct {
+	Cursor string
+	Page   chatsvc.ChatPage
+}
+
+type draftRequest struct {
+	ChatID  string
+	Content string
 }
 
 type renameChatRequest struct {
@@ -46,83 +201,189 @@ type renameChatRequest struct {
 	Title  string
 }
 
-type runExecution struct {
-	RunID              string
-	AssistantMessageID string
-	Status             string
-	ErrText            string
-}
-
-type themePalette struct {
-	AppRoot          string
-	Sidebar          string
-	SidebarSection   string
-	NewChatButton    string
-	ChatButtonBase   string
-	ChatButtonIdle   string
-	ChatButtonActive string
-	ChatActionButton string
-	ChatDangerButton string
-	ChatInput        string
-	ChatSaveButton   string
-	ChatMeta         string
-	Header           string
-	HeaderTitle      string
-	ModelSelect      string
-	ThemeToggle      string
-	StopButton       string
-	ErrorText        string
-	ChatBody         string
-	AssistantBubble  string
-	UserBubble       string
-	ThinkingText     string
-	StatusText       string
-	RoleText         string
-	ToolCard         string
-	ToolText         string
-	ToolErrorText    string
-	Composer         string
-	Input            string
-	SendButton       string
+type shareLinkRequest struct {
+	ChatID string
+	LinkID string
+}
+
+type transferChatRequest struct {
+	ChatID   string
+	ToUserID string
+}
+
+type chatParamsRequest struct {
+	ChatID string
+	Input  chatsvc.ChatParamsInput
+}
+
+type chatParamsResult struct {
+	ChatID string
+	Params chatsvc.ChatParams
+}
+
+type chatToolRequest struct {
+	ChatID  string
+	Name    string
+	Enabled bool
+}
+
+type flagMessageRequest struct {
+	MessageID string
+	Flag      string
+}
+
+type goldenRequest struct {
+	MessageID string
+	Golden    bool
+}
+
+type previewRequest struct {
+	ChatID  string
+	Content string
+	Model   string
+}
+
+type providerKeyRequest struct {
+	Provider string
+	Key      string
+}
+
+type searchChatRequest struct {
+	ChatID string
+	Query  string
+}
+
+// themePalette is the active theme's classes for each part of the page.
+type themePalette = theme.Palette
+
+type ChatRootProps struct {
+	Principal auth.Principal
+	// Locale selects the system prompt, resolved from the browser's
+	// Accept-Language header when the page loads.
+	Locale string
+	// Language is the UI language negotiated from the same header, used
+	// until the user picks one.
+	Language string
 }
 
 func IndexPage(ctx vango.Ctx) *vango.VNode {
-	return Div(ChatRoot(vango.NoProps{}))
+	acceptLanguage := ctx.Request().Header.Get("Accept-Language")
+	language := i18n.Match(acceptLanguage)
+	principal, err := principalFor(ctx)
+	if err != nil {
+		tr := i18n.For(language)
+		return Div(Class("h-screen flex items-center justify-center bg-black text-white/80"),
+			Div(Class("text-center space-y-2"),
+				H1(Class("text-xl font-semibold"), Text(tr.T("auth.signin_required"))),
+				P(Class("text-sm text-white/60"), Text(tr.T("auth.no_identity"))),
+			),
+		)
+	}
+	locale := ""
+	if chatService := getDeps().Chat; chatService != nil {
+		locale = chatService.ResolveLocale(acceptLanguage)
+	}
+	return Div(ChatRoot(ChatRootProps{Principal: principal, Locale: locale, Language: language}))
 }
 
-func ChatRoot(props vango.NoProps) vango.Component {
-	return vango.Setup(props, func(s vango.SetupCtx[vango.NoProps]) vango.RenderFn {
+func ChatRoot(props ChatRootProps) vango.Component {
+	return vango.Setup(props, func(s vango.SetupCtx[ChatRootProps]) vango.RenderFn {
 		dependencies := getDeps()
 		chatService := dependencies.Chat
 		sessionCtx := s.Ctx()
+		principal := s.Props().Get().Principal
+		locale := s.Props().Get().Locale
+		language := s.Props().Get().Language
+		themes := dependencies.Themes
+		if themes == nil {
+			themes = theme.Defaults()
+		}
 
 		chats := setup.Signal(&s, []chatsvc.Chat{})
+		chatsCursor := setup.Signal(&s, "")
+		chatsHasMore := setup.Signal(&s, false)
 		messages := setup.Signal(&s, []MessageView{})
 		activeChatID := setup.Signal(&s, "")
 		inputText := setup.Signal(&s, "")
+		drafts := setup.Signal(&s, map[string]string{})
+		unsavedDrafts := setup.Signal(&s, map[string]string{})
 		selectedModel := setup.Signal(&s, chatService.DefaultModel())
+		compareOn := setup.Signal(&s, false)
+		compareModel := setup.Signal(&s, "")
 		errorText := setup.Signal(&s, "")
 		isThinking := setup.Signal(&s, false)
 		activeRunID := setup.Signal(&s, "")
 		activeAssistantID := setup.Signal(&s, "")
-		themeMode := setup.Signal(&s, "dark")
+		themeName := setup.Signal(&s, themes.Default)
 		editingChatID := setup.Signal(&s, "")
 		renameTitle := setup.Signal(&s, "")
+		regeneratingChatID := setup.Signal(&s, "")
+		transferChatID := setup.Signal(&s, "")
+		transferTarget := setup.Signal(&s, "")
+		sharingChatID := setup.Signal(&s, "")
+		shareLinks := setup.Signal(&s, []chatsvc.ShareLink{})
+
+		paramsOpen := setup.Signal(&s, false)
+		toolsOpen := setup.Signal(&s, false)
+		chatTools := setup.Signal(&s, []chatsvc.ChatTool{})
+		pendingAttachments := setup.Signal(&s, []AttachmentView{})
+		knowledgeOpen := setup.Signal(&s, false)
+		infoOpen := setup.Signal(&s, false)
+		chatSpend := setup.Signal(&s, chatsvc.ChatSpend{})
+		spendCeilingInput := setup.Signal(&s, "")
+		scheduledPrompts := setup.Signal(&s, []chatsvc.ScheduledPrompt{})
+		newPromptText := setup.Signal(&s, "")
+		newPromptFrequency := setup.Signal(&s, "daily")
+		newPromptTime := setup.Signal(&s, "08:00")
+		knowledge := setup.Signal(&s, []KnowledgeView{})
+		preview := setup.Signal(&s, chatsvc.RunPreview{})
+		previewOpen := setup.Signal(&s, false)
+		toolCallDetail := setup.Signal(&s, chatsvc.ToolCallDetail{})
+		toolCallOpen := setup.Signal(&s, false)
+		replays := setup.Signal(&s, map[string][]chatsvc.ReplayRun{})
+		replayingID := setup.Signal(&s, "")
+		paramTemperature := setup.Signal(&s, "")
+		paramMaxTokens := setup.Signal(&s, "")
+		paramTopP := setup.Signal(&s, "")
+		paramReasoningEffort := setup.Signal(&s, "")
+
+		preferences := setup.Signal(&s, chatsvc.Preferences{})
+		timezoneInput := setup.Signal(&s, "")
+		resultsEmailInput := setup.Signal(&s, "")
+		deleteDataOpen := setup.Signal(&s, false)
+		deleteDataInput := setup.Signal(&s, "")
+		providerKeys := setup.Signal(&s, []chatsvc.ProviderKey{})
+		keyProvider := setup.Signal(&s, chatService.KeyProviders()[0])
+		keyInput := setup.Signal(&s, "")
+
+		visibleMessages := setup.Signal(&s, messageWindow)
+
+		findQuery := setup.Signal(&s, "")
+		findMatches := setup.Signal(&s, []string{})
+		findIndex := setup.Signal(&s, 0)
 
-		runTrigger := setup.Signal(&s, 0)
 		pendingRun := setup.Signal(&s, PendingRun{})
+		// sendKey is the idempotency key of the message being composed. It
+		// changes with every send, so a send repeated after the session
+		// reconnects is not stored twice.
+		sendKey := setup.Signal(&s, uuid.NewString())
+		runUsage := setup.Signal(&s, chatsvc.UsageProgress{})
+		notifications := setup.Signal(&s, []NotificationView{})
 
 		loadChatsAction := setup.Action(&s,
-			func(workCtx context.Context, _ struct{}) ([]chatsvc.Chat, error) {
-				return chatService.ListOrCreateChats(workCtx, 200)
+			func(workCtx context.Context, limit int) (chatsvc.ChatPage, error) {
+				return chatService.ListOrCreateChats(workCtx, principal, limit)
 			},
 			vango.DropWhileRunning(),
 			vango.ActionOnSuccess(func(value any) {
-				chatList, ok := value.([]chatsvc.Chat)
+				page, ok := value.(chatsvc.ChatPage)
 				if !ok {
 					return
 				}
+				chatList := page.Chats
 				chats.Set(chatList)
+				chatsCursor.Set(page.NextCursor)
+				chatsHasMore.Set(page.HasMore)
 				currentActive := activeChatID.Get()
 				if currentActive == "" || !containsChat(chatList, currentActive) {
 					currentActive = chatList[0].ID
@@ -139,80 +400,406 @@ func ChatRoot(props vango.NoProps) vango.Component {
 			}),
 		)
 
+		// reloadChats refreshes the sidebar without shrinking it to the first
+		// page when more have been loaded.
+		reloadChats := func() {
+			loadChatsAction.Run(max(chatPageSize, len(chats.Peek())))
+		}
+
+		loadMoreChatsAction := setup.Action(&s,
+			func(workCtx context.Context, cursor string) (moreChats, error) {
+				page, err := chatService.ListChats(workCtx, principal, cursor, chatPageSize)
+				if err != nil {
+					return moreChats{}, err
+				}
+				return moreChats{Cursor: cursor, Page: page}, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				more, ok := value.(moreChats)
+				// A reload since the request replaced the list it continued.
+				if !ok || more.Cursor != chatsCursor.Get() {
+					return
+				}
+				chats.Set(appendChats(chats.Get(), more.Page.Chats))
+				chatsCursor.Set(more.Page.NextCursor)
+				chatsHasMore.Set(more.Page.HasMore)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		// follows are the background runs the page shows, by run ID. They
+		// are only touched on the session loop, and an update is applied
+		// only while the follow it came through is current, so runs the
+		// page stopped following, as on a chat switch, leave it alone.
+		follows := map[string]*runFollow{}
+		unfollow := func(runID string) {
+			if follow, ok := follows[runID]; ok {
+				follow.unsubscribe()
+				delete(follows, runID)
+			}
+		}
+		unfollowAll := func() {
+			for runID := range follows {
+				unfollow(runID)
+			}
+		}
+
+		// settleRun ends the page's run once none of its attempts is still
+		// followed.
+		settleRun := func() {
+			for _, attempt := range pendingRun.Peek().attempts() {
+				if follows[attempt.RunID] != nil {
+					return
+				}
+			}
+			activeRunID.Set("")
+			activeAssistantID.Set("")
+			isThinking.Set(false)
+			reloadChats()
+		}
+
+		// showOutcome shows how a run ended on its reply.
+		showOutcome := func(assistantID string, outcome *chatsvc.RunOutcome) {
+			if outcome.Err != nil {
+				messages.Set(setAssistantError(messages.Peek(), assistantID, outcome.Err.Error()))
+				return
+			}
+			messages.Set(markAssistantStatus(messages.Peek(), assistantID, outcome.Status))
+			messages.Set(setMessageContent(messages.Peek(), assistantID, outcome.Content))
+			messages.Set(setMessageSources(messages.Peek(), assistantID, outcome.Sources))
+			messages.Set(setMessageRuns(messages.Peek(), assistantID, outcome.Runs))
+			if outcome.Status == "error" {
+				messages.Set(setAssistantError(messages.Peek(), assistantID, outcome.Error))
+			}
+		}
+
+		// followRun subscribes the page to a background run and returns its
+		// state so far. Streamed text reaches the session at the pace the
+		// flush pacer sets; other updates go out as they happen.
+		followRun := func(runID string) (chatsvc.LiveRun, bool) {
+			follow := &runFollow{}
+			dispatch := func(apply func()) {
+				sessionCtx.Dispatch(func() {
+					if follows[runID] == follow {
+						apply()
+					}
+				})
+			}
+			pacer := chatService.NewFlushPacer()
+			pendingText, pendingReasoning := "", ""
+			lastTextFlush, lastReasoningFlush := time.Now(), time.Now()
+			var lastUsageFlush time.Time
+			flush := func(pending *string, last *time.Time, force bool, apply func(string)) {
+				if *pending == "" || (!force && !pacer.Due(len(*pending), time.Since(*last))) {
+					return
+				}
+				chunk := *pending
+				*pending = ""
+				*last = time.Now()
+				applied := pacer.Sent()
+				sessionCtx.Dispatch(func() {
+					applied()
+					if follows[runID] == follow {
+						apply(chunk)
+					}
+				})
+			}
+
+			deliver := func(update chatsvc.RunUpdate) {
+				assistantID := update.AssistantMessageID
+				showText := func(chunk string) {
+					messages.Set(appendAssistantChunk(messages.Peek(), assistantID, chunk))
+					isThinking.Set(false)
+				}
+				showReasoning := func(chunk string) {
+					messages.Set(appendReasoningChunk(messages.Peek(), assistantID, chunk))
+				}
+				switch update.Type {
+				case chatsvc.RunUpdateText:
+					flush(&pendingReasoning, &lastReasoningFlush, true, showReasoning)
+					pendingText += update.Delta
+					flush(&pendingText, &lastTextFlush, false, showText)
+				case chatsvc.RunUpdateReasoning:
+					pendingReasoning += update.Delta
+					flush(&pendingReasoning, &lastReasoningFlush, false, showReasoning)
+				case chatsvc.RunUpdateToolStarted:
+					flush(&pendingText, &lastTextFlush, true, showText)
+					call := toolCallView(update.Tool)
+					dispatch(func() {
+						messages.Set(addToolCall(messages.Peek(), assistantID, call))
+					})
+				case chatsvc.RunUpdateToolFinished:
+					flush(&pendingText, &lastTextFlush, true, showText)
+					call := toolCallView(update.Tool)
+					dispatch(func() {
+						messages.Set(updateToolCall(messages.Peek(), assistantID, call.ID, update.Tool.Status, call.Output, call.ErrText))
+					})
+				case chatsvc.RunUpdateQueued:
+					dispatch(func() {
+						messages.Set(setQueuePosition(messages.Peek(), assistantID, update.Position))
+					})
+				case chatsvc.RunUpdateUsage:
+					// The status line follows the page's run rather than its
+					// comparison; estimates are throttled, reported turn
+					// totals always go out.
+					if update.Usage.Estimated && time.Since(lastUsageFlush) < usageFlushInterval {
+						return
+					}
+					lastUsageFlush = time.Now()
+					dispatch(func() {
+						if activeRunID.Get() == runID {
+							runUsage.Set(update.Usage)
+						}
+					})
+				case chatsvc.RunUpdateFinished:
+					flush(&pendingReasoning, &lastReasoningFlush, true, showReasoning)
+					flush(&pendingText, &lastTextFlush, true, showText)
+					dispatch(func() {
+						unfollow(runID)
+						showOutcome(assistantID, update.Outcome)
+						if update.Outcome.Err != nil {
+							errorText.Set(update.Outcome.Err.Error())
+						} else if update.Outcome.Error != "" {
+							errorText.Set(update.Outcome.Error)
+						}
+						if pendingRun.Peek().hasAttempt(runID) {
+							settleRun()
+						}
+					})
+				}
+			}
+
+			live, unsubscribe, ok := chatService.SubscribeRun(runID, deliver)
+			if !ok {
+				return chatsvc.LiveRun{}, false
+			}
+			follow.unsubscribe = unsubscribe
+			if live.Finished == nil {
+				follows[runID] = follow
+			}
+			return live, true
+		}
+
+		// watches are the page's runs it notifies about, by run ID, with the
+		// function that ends each. Like follows they are only touched on the
+		// session loop.
+		watches := map[string]func(){}
+		unwatchAll := func() {
+			for runID, unsubscribe := range watches {
+				unsubscribe()
+				delete(watches, runID)
+			}
+		}
+
+		// watchRun notifies the user when a run finishes while its chat is
+		// not the active one.
+		watchRun := func(runID, chatID string) {
+			if watches[runID] != nil {
+				return
+			}
+			live, unsubscribe, ok := chatService.SubscribeRun(runID, func(update chatsvc.RunUpdate) {
+				if update.Type != chatsvc.RunUpdateFinished {
+					return
+				}
+				sessionCtx.Dispatch(func() {
+					delete(watches, runID)
+					if activeChatID.Peek() == chatID {
+						return
+					}
+					if notification, ok := runNotification(runID, findChatByID(chats.Peek(), chatID), update.Outcome); ok {
+						notifications.Set(appendNotification(notifications.Peek(), notification))
+					}
+					reloadChats()
+				})
+			})
+			if ok && live.Finished == nil {
+				watches[runID] = unsubscribe
+			}
+		}
+
+		// resumeRuns follows the chat's runs still going in the background,
+		// as when the user comes back to it or the session reconnects, and
+		// shows the first of them as the page's run.
+		resumeRuns := func(chatID string) {
+			unfollowAll()
+			var running []chatsvc.LiveRun
+			for _, started := range chatService.LiveRuns(chatID) {
+				live, ok := followRun(started.RunID)
+				if !ok {
+					continue
+				}
+				messages.Set(applyLiveRun(messages.Peek(), live))
+				if live.Finished != nil {
+					showOutcome(live.AssistantMessageID, live.Finished)
+					continue
+				}
+				watchRun(live.RunID, live.ChatID)
+				running = append(running, live)
+			}
+			if len(running) == 0 {
+				activeRunID.Set("")
+				activeAssistantID.Set("")
+				isThinking.Set(false)
+				return
+			}
+			run := livePendingRun(running[0])
+			if len(running) > 1 && running[1].ComparisonID == run.RunID {
+				compare := livePendingRun(running[1])
+				run.Compare = &compare
+			}
+			pendingRun.Set(run)
+			activeRunID.Set(run.RunID)
+			activeAssistantID.Set(run.AssistantMessageID)
+			isThinking.Set(running[0].Content == "")
+			runUsage.Set(running[0].Usage)
+		}
+
 		loadMessagesAction := setup.Action(&s,
-			func(workCtx context.Context, chatID string) ([]chatsvc.Message, error) {
-				return chatService.ListMessages(workCtx, chatID, 500)
+			func(workCtx context.Context, chatID string) (messagePage, error) {
+				rows, err := chatService.ListMessages(workCtx, chatID, 500)
+				if err != nil {
+					return messagePage{}, err
+				}
+				attachments, err := chatService.MessageAttachments(workCtx, chatID)
+				if err != nil {
+					return messagePage{}, err
+				}
+				golden, err := chatService.GoldenMessageIDs(workCtx, chatID)
+				if err != nil {
+					return messagePage{}, err
+				}
+				sources, err := chatService.ChatSources(workCtx, chatID)
+				if err != nil {
+					return messagePage{}, err
+				}
+				runs, err := chatService.ChatRunDetails(workCtx, chatID)
+				if err != nil {
+					return messagePage{}, err
+				}
+				return messagePage{Messages: rows, Attachments: attachments, Golden: golden, Sources: sources, Runs: runs}, nil
 			},
 			vango.CancelLatest(),
 			vango.ActionOnSuccess(func(value any) {
-				rows, ok := value.([]chatsvc.Message)
+				page, ok := value.(messagePage)
 				if !ok {
 					messages.Set([]MessageView{})
 					return
 				}
-				viewMessages := make([]MessageView, 0, len(rows))
-				for _, row := range rows {
+				viewMessages := make([]MessageView, 0, len(page.Messages))
+				for _, row := range page.Messages {
+					comparisonID, comparisonModel := runComparison(page.Runs[row.ID])
 					viewMessages = append(viewMessages, MessageView{
-						ID:        row.ID,
-						Role:      row.Role,
-						Content:   row.Content,
-						Status:    row.Status,
-						CreatedAt: row.CreatedAt,
+						ID:              row.ID,
+						Role:            row.Role,
+						Content:         row.Content,
+						Reasoning:       row.Reasoning,
+						Status:          row.Status,
+						Flag:            row.Flag,
+						Golden:          page.Golden[row.ID],
+						Attachments:     attachmentViews(page.Attachments[row.ID]),
+						Sources:         page.Sources[row.ID],
+						Runs:            page.Runs[row.ID],
+						CreatedAt:       row.CreatedAt,
+						ComparisonID:    comparisonID,
+						ComparisonModel: comparisonModel,
 					})
 				}
 				messages.Set(viewMessages)
 				errorText.Set("")
+				resumeRuns(activeChatID.Peek())
 			}),
 			vango.ActionOnError(func(err error) {
 				errorText.Set(err.Error())
 			}),
 		)
 
-		createChatAction := setup.Action(&s,
-			func(workCtx context.Context, model string) (chatsvc.Chat, error) {
-				return chatService.CreateChat(workCtx, model)
+		loadChatToolsAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) ([]chatsvc.ChatTool, error) {
+				return chatService.ChatTools(workCtx, chatID)
 			},
-			vango.DropWhileRunning(),
+			vango.CancelLatest(),
 			vango.ActionOnSuccess(func(value any) {
-				chat, ok := value.(chatsvc.Chat)
+				tools, ok := value.([]chatsvc.ChatTool)
 				if !ok {
 					return
 				}
-				current := chats.Get()
-				next := make([]chatsvc.Chat, 0, len(current)+1)
-				next = append(next, chat)
-				next = append(next, current...)
-				chats.Set(next)
-				activeChatID.Set(chat.ID)
-				selectedModel.Set(chat.Model)
-				messages.Set([]MessageView{})
-				errorText.Set("")
+				chatTools.Set(tools)
 			}),
 			vango.ActionOnError(func(err error) {
 				errorText.Set(err.Error())
 			}),
 		)
 
-		renameChatAction := setup.Action(&s,
-			func(workCtx context.Context, request renameChatRequest) (string, error) {
-				if err := chatService.RenameChat(workCtx, request.ChatID, request.Title); err != nil {
-					return "", err
+		loadPendingAttachmentsAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) ([]chatsvc.Attachment, error) {
+				return chatService.PendingAttachments(workCtx, chatID)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				rows, ok := value.([]chatsvc.Attachment)
+				if !ok {
+					return
 				}
-				return strings.TrimSpace(request.Title), nil
+				pendingAttachments.Set(attachmentViews(rows))
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		removeAttachmentAction := setup.Action(&s,
+			func(workCtx context.Context, request attachmentRequest) (attachmentRequest, error) {
+				if err := chatService.RemovePendingAttachment(workCtx, request.ChatID, request.AttachmentID); err != nil {
+					return attachmentRequest{}, err
+				}
+				return request, nil
 			},
 			vango.DropWhileRunning(),
 			vango.ActionOnSuccess(func(value any) {
-				updatedTitle, ok := value.(string)
+				request, ok := value.(attachmentRequest)
+				if !ok || request.ChatID != activeChatID.Get() {
+					return
+				}
+				pendingAttachments.Set(removeAttachmentView(pendingAttachments.Get(), request.AttachmentID))
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		loadSpendAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (chatsvc.ChatSpend, error) {
+				return chatService.ChatSpend(workCtx, chatID)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				spend, ok := value.(chatsvc.ChatSpend)
 				if !ok {
 					return
 				}
-				chatID := editingChatID.Get()
-				if chatID == "" {
+				chatSpend.Set(spend)
+				spendCeilingInput.Set(formatSpendCeiling(spend))
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		setSpendCeilingAction := setup.Action(&s,
+			func(workCtx context.Context, request spendCeilingRequest) (chatsvc.ChatSpend, error) {
+				return chatService.SetChatSpendCeiling(workCtx, principal, request.ChatID, request.Ceiling)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				spend, ok := value.(chatsvc.ChatSpend)
+				if !ok {
 					return
 				}
-				chats.Set(updateChatTitle(chats.Get(), chatID, updatedTitle))
-				editingChatID.Set("")
-				renameTitle.Set("")
+				chatSpend.Set(spend)
+				spendCeilingInput.Set(formatSpendCeiling(spend))
 				errorText.Set("")
 			}),
 			vango.ActionOnError(func(err error) {
@@ -220,37 +807,17 @@ func ChatRoot(props vango.NoProps) vango.Component {
 			}),
 		)
 
-		deleteChatAction := setup.Action(&s,
-			func(workCtx context.Context, chatID string) (string, error) {
-				if err := chatService.DeleteChat(workCtx, chatID); err != nil {
-					return "", err
-				}
-				return chatID, nil
+		setKeepForeverAction := setup.Action(&s,
+			func(workCtx context.Context, request keepForeverRequest) (chatsvc.Chat, error) {
+				return chatService.SetChatKeepForever(workCtx, principal, request.ChatID, request.Keep)
 			},
 			vango.DropWhileRunning(),
 			vango.ActionOnSuccess(func(value any) {
-				deletedChatID, ok := value.(string)
+				chat, ok := value.(chatsvc.Chat)
 				if !ok {
 					return
 				}
-				currentChats := removeChatByID(chats.Get(), deletedChatID)
-				chats.Set(currentChats)
-				if editingChatID.Get() == deletedChatID {
-					editingChatID.Set("")
-					renameTitle.Set("")
-				}
-				if activeChatID.Get() == deletedChatID {
-					if len(currentChats) > 0 {
-						activeChatID.Set(currentChats[0].ID)
-						if chatService.IsAllowedModel(currentChats[0].Model) {
-							selectedModel.Set(currentChats[0].Model)
-						}
-					} else {
-						activeChatID.Set("")
-						messages.Set([]MessageView{})
-						createChatAction.Run(selectedModel.Get())
-					}
-				}
+				chats.Set(updateChatKeepForever(chats.Get(), chat.ID, chat.KeepForever))
 				errorText.Set("")
 			}),
 			vango.ActionOnError(func(err error) {
@@ -258,765 +825,3677 @@ func ChatRoot(props vango.NoProps) vango.Component {
 			}),
 		)
 
-		s.OnMount(func() vango.Cleanup {
-			loadChatsAction.Run(struct{}{})
-			return nil
-		})
-
-		s.Effect(func() vango.Cleanup {
-			chatID := activeChatID.Get()
-			if chatID == "" {
-				messages.Set([]MessageView{})
-				return nil
-			}
-			loadMessagesAction.Run(chatID)
-			return nil
-		})
-
-		s.Effect(func() vango.Cleanup {
-			trigger := runTrigger.Get()
-			if trigger == 0 {
-				return nil
-			}
-			run := pendingRun.Get()
-			if run.RunID == "" {
-				return nil
-			}
-
-			return vango.GoLatest(trigger,
-				func(workCtx context.Context, _ int) (runExecution, error) {
-					if err := chatService.PersistRunStart(workCtx, chatsvc.PendingRun{
-						RunID:              run.RunID,
-						ChatID:             run.ChatID,
-						UserMessageID:      run.UserMessageID,
-						AssistantMessageID: run.AssistantMessageID,
-						Model:              run.Model,
-					}, run.UserContent); err != nil {
-						return runExecution{}, err
-					}
+		loadScheduledPromptsAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) ([]chatsvc.ScheduledPrompt, error) {
+				return chatService.ScheduledPrompts(workCtx, chatID)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				prompts, ok := value.([]chatsvc.ScheduledPrompt)
+				if !ok {
+					return
+				}
+				scheduledPrompts.Set(prompts)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					history, err := chatService.BuildHistory(workCtx, run.ChatID)
-					if err != nil {
-						return runExecution{}, err
+		// Creating and deleting both reload the chat's list.
+		scheduledPromptAction := setup.Action(&s,
+			func(workCtx context.Context, request scheduledPromptRequest) ([]chatsvc.ScheduledPrompt, error) {
+				if request.PromptID != "" {
+					if err := chatService.DeleteScheduledPrompt(workCtx, principal, request.PromptID); err != nil {
+						return nil, err
 					}
+				} else if _, err := chatService.CreateScheduledPrompt(workCtx, principal, request.ChatID, request.Prompt, request.Frequency, request.TimeOfDay); err != nil {
+					return nil, err
+				}
+				return chatService.ScheduledPrompts(workCtx, request.ChatID)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				prompts, ok := value.([]chatsvc.ScheduledPrompt)
+				if !ok {
+					return
+				}
+				scheduledPrompts.Set(prompts)
+				newPromptText.Set("")
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					uiFlushInterval, uiFlushBytes, dbFlushInterval := chatService.FlushConfig()
-					var assistantBuilder strings.Builder
-					pendingDelta := ""
-					lastUIFlush := time.Now().UTC()
-					lastDBFlush := time.Now().UTC()
-					toolCallRowByExternalID := map[string]string{}
-
-					flushUI := func(force bool) {
-						if pendingDelta == "" {
-							return
-						}
-						if !force && len(pendingDelta) < uiFlushBytes && time.Since(lastUIFlush) < uiFlushInterval {
-							return
-						}
-						chunk := pendingDelta
-						pendingDelta = ""
-						assistantBuilder.WriteString(chunk)
-						lastUIFlush = time.Now().UTC()
-						sessionCtx.Dispatch(func() {
-							if activeRunID.Get() != run.RunID {
-								return
-							}
-							messages.Set(appendAssistantChunk(messages.Peek(), run.AssistantMessageID, chunk))
-							isThinking.Set(false)
-						})
-					}
+		loadKnowledgeAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) ([]KnowledgeView, error) {
+				documents, err := chatService.KnowledgeDocuments(workCtx, chatID)
+				if err != nil {
+					return nil, err
+				}
+				views := make([]KnowledgeView, 0, len(documents))
+				for _, document := range documents {
+					views = append(views, KnowledgeView{
+						ID:         document.ID,
+						FileName:   document.FileName,
+						SizeBytes:  document.SizeBytes,
+						ChunkCount: document.ChunkCount,
+						Searchable: chatService.KnowledgeSearchable(document),
+					})
+				}
+				return views, nil
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				views, ok := value.([]KnowledgeView)
+				if !ok {
+					return
+				}
+				knowledge.Set(views)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					flushDB := func(force bool) {
-						if !force && time.Since(lastDBFlush) < dbFlushInterval {
-							return
-						}
-						lastDBFlush = time.Now().UTC()
-						content := assistantBuilder.String() + pendingDelta
-						_ = chatService.UpdateAssistantPartial(workCtx, run.AssistantMessageID, content)
+		removeKnowledgeAction := setup.Action(&s,
+			func(workCtx context.Context, request knowledgeRequest) (knowledgeRequest, error) {
+				if err := chatService.RemoveKnowledge(workCtx, principal, request.ChatID, request.DocumentID); err != nil {
+					return knowledgeRequest{}, err
+				}
+				return request, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				request, ok := value.(knowledgeRequest)
+				if !ok || request.ChatID != activeChatID.Get() {
+					return
+				}
+				next := make([]KnowledgeView, 0, len(knowledge.Get()))
+				for _, document := range knowledge.Get() {
+					if document.ID != request.DocumentID {
+						next = append(next, document)
 					}
+				}
+				knowledge.Set(next)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					streamResult, streamErr := chatService.Stream(workCtx, run.Model, history, chatsvc.StreamCallbacks{
-						OnTextDelta: func(delta string) {
-							pendingDelta += delta
-							flushUI(false)
-							flushDB(false)
-						},
-						OnThinking: func() {
-							sessionCtx.Dispatch(func() {
-								if activeRunID.Get() == run.RunID {
-									isThinking.Set(true)
-								}
-							})
-						},
-						OnToolStart: func(update chatsvc.ToolCallUpdate) {
-							flushUI(true)
-							callID, callErr := chatService.UpsertToolStart(workCtx, run.RunID, update)
-							if callErr == nil && update.ID != "" {
-								toolCallRowByExternalID[update.ID] = callID
-							}
-							sessionCtx.Dispatch(func() {
-								if activeRunID.Get() != run.RunID {
-									return
-								}
-								messages.Set(addToolCall(messages.Peek(), run.AssistantMessageID, ToolCallView{
-									ID:     callID,
-									Name:   update.Name,
-									Status: "running",
-									Input:  truncateText(update.Input, 500),
-								}))
-							})
-						},
-						OnToolResult: func(update chatsvc.ToolCallUpdate) {
-							flushUI(true)
-							callID := toolCallRowByExternalID[update.ID]
-							if callID == "" {
-								callID = uuid.NewString()
-							}
-							_ = chatService.CompleteTool(workCtx, callID, update)
-							sessionCtx.Dispatch(func() {
-								if activeRunID.Get() != run.RunID {
-									return
-								}
-								messages.Set(updateToolCall(messages.Peek(), run.AssistantMessageID, callID, update.Status, truncateText(update.Output, 500), truncateText(update.ErrText, 300)))
-							})
-						},
-					})
+		setChatToolAction := setup.Action(&s,
+			func(workCtx context.Context, request chatToolRequest) (chatToolRequest, error) {
+				if err := chatService.SetChatToolEnabled(workCtx, request.ChatID, request.Name, request.Enabled); err != nil {
+					return chatToolRequest{}, err
+				}
+				return request, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				request, ok := value.(chatToolRequest)
+				if !ok || request.ChatID != activeChatID.Get() {
+					return
+				}
+				chatTools.Set(setChatToolEnabled(chatTools.Get(), request.Name, request.Enabled))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					flushUI(true)
-					flushDB(true)
-					finalContent := assistantBuilder.String() + pendingDelta
-
-					status := "completed"
-					streamErrorText := ""
-					if streamErr != nil {
-						if chatService.IsCancellation(streamErr, workCtx) {
-							status = "cancelled"
-						} else {
-							status = "error"
-							streamErrorText = streamErr.Error()
-						}
-					}
-					if status == "error" && strings.TrimSpace(streamErrorText) == "" {
-						streamErrorText = fmt.Sprintf("Model %s failed without a provider error message.", run.Model)
-					}
+		updateParamsAction := setup.Action(&s,
+			func(workCtx context.Context, request chatParamsRequest) (chatParamsResult, error) {
+				params, err := chatsvc.ParseChatParams(request.Input)
+				if err != nil {
+					return chatParamsResult{}, err
+				}
+				if err := chatService.UpdateChatParams(workCtx, request.ChatID, params); err != nil {
+					return chatParamsResult{}, err
+				}
+				return chatParamsResult{ChatID: request.ChatID, Params: params}, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				result, ok := value.(chatParamsResult)
+				if !ok {
+					return
+				}
+				chats.Set(updateChatParams(chats.Get(), result.ChatID, result.Params))
+				paramsOpen.Set(false)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					if err := chatService.CompleteAssistant(workCtx, run.AssistantMessageID, finalContent, status); err != nil {
-						return runExecution{}, err
-					}
-					if err := chatService.CompleteRun(workCtx, chatsvc.PendingRun{
-						RunID:              run.RunID,
-						ChatID:             run.ChatID,
-						UserMessageID:      run.UserMessageID,
-						AssistantMessageID: run.AssistantMessageID,
-						Model:              run.Model,
-					}, status, streamResult, streamErrorText); err != nil {
-						return runExecution{}, err
-					}
+		searchChatAction := setup.Action(&s,
+			func(workCtx context.Context, request searchChatRequest) ([]chatsvc.Message, error) {
+				return chatService.SearchChat(workCtx, request.ChatID, request.Query)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				rows, ok := value.([]chatsvc.Message)
+				if !ok {
+					findMatches.Set([]string{})
+					return
+				}
+				ids := make([]string, 0, len(rows))
+				for _, row := range rows {
+					ids = append(ids, row.ID)
+				}
+				findMatches.Set(ids)
+				findIndex.Set(0)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					return runExecution{
-						RunID:              run.RunID,
-						AssistantMessageID: run.AssistantMessageID,
-						Status:             status,
-						ErrText:            streamErrorText,
-					}, nil
-				},
-				func(execution runExecution, err error) {
-					if activeRunID.Get() != run.RunID {
-						return
-					}
-					activeRunID.Set("")
-					activeAssistantID.Set("")
-					isThinking.Set(false)
+		flagMessageAction := setup.Action(&s,
+			func(workCtx context.Context, request flagMessageRequest) (flagMessageRequest, error) {
+				if err := chatService.FlagMessage(workCtx, request.MessageID, request.Flag); err != nil {
+					return flagMessageRequest{}, err
+				}
+				return request, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				request, ok := value.(flagMessageRequest)
+				if !ok {
+					return
+				}
+				messages.Set(setMessageFlag(messages.Get(), request.MessageID, request.Flag))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					if err != nil {
-						errorText.Set(err.Error())
-						messages.Set(setAssistantError(messages.Peek(), run.AssistantMessageID, err.Error()))
-						return
+		goldenAction := setup.Action(&s,
+			func(workCtx context.Context, request goldenRequest) (goldenRequest, error) {
+				if request.Golden {
+					if _, err := chatService.MarkGolden(workCtx, principal, request.MessageID, ""); err != nil {
+						return goldenRequest{}, err
 					}
+				} else if err := chatService.UnmarkGolden(workCtx, principal, request.MessageID); err != nil {
+					return goldenRequest{}, err
+				}
+				return request, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				request, ok := value.(goldenRequest)
+				if !ok {
+					return
+				}
+				messages.Set(setMessageGolden(messages.Get(), request.MessageID, request.Golden))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-					messages.Set(markAssistantStatus(messages.Peek(), execution.AssistantMessageID, execution.Status))
-					if execution.Status == "error" {
-						errMessage := execution.ErrText
-						if strings.TrimSpace(errMessage) == "" {
-							errMessage = fmt.Sprintf("Model %s failed without a provider error message.", run.Model)
-						}
-						messages.Set(setAssistantError(messages.Peek(), execution.AssistantMessageID, errMessage))
-					}
-					if execution.ErrText != "" {
-						errorText.Set(execution.ErrText)
-					}
-					loadChatsAction.Run(struct{}{})
-				},
-			)
-		})
+		previewAction := setup.Action(&s,
+			func(workCtx context.Context, request previewRequest) (chatsvc.RunPreview, error) {
+				return chatService.PreviewRun(workCtx, principal, request.ChatID, request.Content, request.Model, locale)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				result, ok := value.(chatsvc.RunPreview)
+				if !ok {
+					return
+				}
+				preview.Set(result)
+				previewOpen.Set(true)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-		onSend := func() {
-			if activeRunID.Get() != "" {
-				return
-			}
-			chatID := activeChatID.Get()
-			if chatID == "" {
-				return
-			}
-			content := strings.TrimSpace(inputText.Get())
-			if content == "" {
-				return
-			}
-			model := selectedModel.Get()
-			if !chatService.IsAllowedModel(model) {
-				model = chatService.DefaultModel()
-				selectedModel.Set(model)
-			}
+		toolCallAction := setup.Action(&s,
+			func(workCtx context.Context, callID string) (chatsvc.ToolCallDetail, error) {
+				return chatService.ToolCallDetail(workCtx, principal, callID)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				result, ok := value.(chatsvc.ToolCallDetail)
+				if !ok {
+					return
+				}
+				toolCallDetail.Set(result)
+				toolCallOpen.Set(true)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-			runID := uuid.NewString()
-			userMessageID := uuid.NewString()
-			assistantMessageID := uuid.NewString()
-			now := time.Now().UTC()
+		replayRunAction := setup.Action(&s,
+			func(workCtx context.Context, request replayRequest) (replayResult, error) {
+				if _, err := chatService.ReplayRun(workCtx, request.MessageID, request.Model); err != nil {
+					return replayResult{}, err
+				}
+				recorded, err := chatService.ListReplays(workCtx, request.MessageID)
+				if err != nil {
+					return replayResult{}, err
+				}
+				return replayResult{MessageID: request.MessageID, Replays: recorded}, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				replayingID.Set("")
+				result, ok := value.(replayResult)
+				if !ok {
+					return
+				}
+				next := make(map[string][]chatsvc.ReplayRun, len(replays.Get())+1)
+				for id, runs := range replays.Get() {
+					next[id] = runs
+				}
+				next[result.MessageID] = result.Replays
+				replays.Set(next)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				replayingID.Set("")
+				errorText.Set(err.Error())
+			}),
+		)
 
-			messages.Set(append(messages.Get(),
-				MessageView{ID: userMessageID, Role: "user", Content: content, Status: "complete", CreatedAt: now},
-				MessageView{ID: assistantMessageID, Role: "assistant", Content: "", Status: "streaming", CreatedAt: now},
-			))
-			inputText.Set("")
-			isThinking.Set(true)
-			errorText.Set("")
-			activeRunID.Set(runID)
-			activeAssistantID.Set(assistantMessageID)
-			pendingRun.Set(PendingRun{
-				RunID:              runID,
-				ChatID:             chatID,
-				UserMessageID:      userMessageID,
-				AssistantMessageID: assistantMessageID,
-				Model:              model,
-				UserContent:        content,
-			})
-			runTrigger.Set(runTrigger.Get() + 1)
-		}
+		createChatAction := setup.Action(&s,
+			func(workCtx context.Context, model string) (chatsvc.Chat, error) {
+				return chatService.CreateChat(workCtx, principal, model)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				chat, ok := value.(chatsvc.Chat)
+				if !ok {
+					return
+				}
+				current := chats.Get()
+				next := make([]chatsvc.Chat, 0, len(current)+1)
+				next = append(next, chat)
+				next = append(next, current...)
+				chats.Set(next)
+				activeChatID.Set(chat.ID)
+				selectedModel.Set(chat.Model)
+				messages.Set([]MessageView{})
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
 
-		onStop := func() {
-			runID := activeRunID.Get()
-			assistantID := activeAssistantID.Get()
-			if runID == "" || assistantID == "" {
+		// duplicateChatAction copies a chat with its model and parameters
+		// and switches to the copy.
+		duplicateChatAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (chatsvc.Chat, error) {
+				return chatService.CloneChat(workCtx, principal, chatID, chatsvc.CloneOptions{KeepSettings: true})
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				chat, ok := value.(chatsvc.Chat)
+				if !ok {
+					return
+				}
+				current := chats.Get()
+				next := make([]chatsvc.Chat, 0, len(current)+1)
+				next = append(next, chat)
+				next = append(next, current...)
+				chats.Set(next)
+				activeChatID.Set(chat.ID)
+				selectedModel.Set(chat.Model)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		renameChatAction := setup.Action(&s,
+			func(workCtx context.Context, request renameChatRequest) (string, error) {
+				if err := chatService.RenameChat(workCtx, request.ChatID, request.Title); err != nil {
+					return "", err
+				}
+				return strings.TrimSpace(request.Title), nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				updatedTitle, ok := value.(string)
+				if !ok {
+					return
+				}
+				chatID := editingChatID.Get()
+				if chatID == "" {
+					return
+				}
+				chats.Set(updateChatTitle(chats.Get(), chatID, updatedTitle))
+				editingChatID.Set("")
+				renameTitle.Set("")
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		regenerateTitleAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (renameChatRequest, error) {
+				title, err := chatService.RegenerateTitle(workCtx, principal, chatID)
+				return renameChatRequest{ChatID: chatID, Title: title}, err
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				regeneratingChatID.Set("")
+				renamed, ok := value.(renameChatRequest)
+				if !ok {
+					return
+				}
+				chats.Set(updateChatTitle(chats.Get(), renamed.ChatID, renamed.Title))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				regeneratingChatID.Set("")
+				errorText.Set(err.Error())
+			}),
+		)
+
+		deleteChatAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (string, error) {
+				if err := chatService.DeleteChat(workCtx, chatID); err != nil {
+					return "", err
+				}
+				return chatID, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				deletedChatID, ok := value.(string)
+				if !ok {
+					return
+				}
+				currentChats := removeChatByID(chats.Get(), deletedChatID)
+				chats.Set(currentChats)
+				drafts.Set(withoutDraft(drafts.Get(), deletedChatID))
+				unsavedDrafts.Set(withoutDraft(unsavedDrafts.Get(), deletedChatID))
+				if editingChatID.Get() == deletedChatID {
+					editingChatID.Set("")
+					renameTitle.Set("")
+				}
+				if activeChatID.Get() == deletedChatID {
+					if len(currentChats) > 0 {
+						activeChatID.Set(currentChats[0].ID)
+						if chatService.IsAllowedModel(currentChats[0].Model) {
+							selectedModel.Set(currentChats[0].Model)
+						}
+					} else {
+						activeChatID.Set("")
+						messages.Set([]MessageView{})
+						createChatAction.Run(selectedModel.Get())
+					}
+				}
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		loadShareLinksAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) ([]chatsvc.ShareLink, error) {
+				return chatService.ShareLinks(workCtx, principal, chatID)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				links, ok := value.([]chatsvc.ShareLink)
+				if !ok {
+					return
+				}
+				shareLinks.Set(links)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		createShareLinkAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (chatsvc.ShareLink, error) {
+				return chatService.CreateShareLink(workCtx, principal, chatID)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				link, ok := value.(chatsvc.ShareLink)
+				if !ok || link.ChatID != sharingChatID.Get() {
+					return
+				}
+				shareLinks.Set(append(slices.Clone(shareLinks.Get()), link))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		revokeShareLinkAction := setup.Action(&s,
+			func(workCtx context.Context, request shareLinkRequest) (string, error) {
+				if err := chatService.RevokeShareLink(workCtx, principal, request.ChatID, request.LinkID); err != nil {
+					return "", err
+				}
+				return request.LinkID, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				linkID, ok := value.(string)
+				if !ok {
+					return
+				}
+				shareLinks.Set(slices.DeleteFunc(slices.Clone(shareLinks.Get()), func(link chatsvc.ShareLink) bool {
+					return link.ID == linkID
+				}))
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		transferChatAction := setup.Action(&s,
+			func(workCtx context.Context, request transferChatRequest) (string, error) {
+				if err := chatService.TransferChat(workCtx, principal, request.ChatID, request.ToUserID); err != nil {
+					return "", err
+				}
+				return request.ChatID, nil
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				transferredChatID, ok := value.(string)
+				if !ok {
+					return
+				}
+				transferChatID.Set("")
+				transferTarget.Set("")
+				currentChats := removeChatByID(chats.Get(), transferredChatID)
+				chats.Set(currentChats)
+				if activeChatID.Get() == transferredChatID {
+					if len(currentChats) > 0 {
+						activeChatID.Set(currentChats[0].ID)
+						if chatService.IsAllowedModel(currentChats[0].Model) {
+							selectedModel.Set(currentChats[0].Model)
+						}
+					} else {
+						activeChatID.Set("")
+						messages.Set([]MessageView{})
+						createChatAction.Run(selectedModel.Get())
+					}
+				}
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		loadDraftAction := setup.Action(&s,
+			func(workCtx context.Context, chatID string) (draftRequest, error) {
+				content, err := chatService.Draft(workCtx, principal, chatID)
+				if err != nil {
+					return draftRequest{}, err
+				}
+				return draftRequest{ChatID: chatID, Content: content}, nil
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				draft, ok := value.(draftRequest)
+				if !ok || draft.ChatID != activeChatID.Get() {
+					return
+				}
+				// Text typed while the draft loaded wins over the stored one.
+				if _, typed := drafts.Get()[draft.ChatID]; typed {
+					return
+				}
+				drafts.Set(withDraft(drafts.Get(), draft.ChatID, draft.Content))
+				inputText.Set(draft.Content)
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		// saveDraftsAction writes every draft not yet stored, after a pause in
+		// typing. Each run carries all of them, so cancelling an earlier run
+		// for a newer one loses nothing.
+		saveDraftsAction := setup.Action(&s,
+			func(workCtx context.Context, pending map[string]string) (map[string]string, error) {
+				select {
+				case <-time.After(draftSaveDelay):
+				case <-workCtx.Done():
+					return nil, workCtx.Err()
+				}
+				for chatID, content := range pending {
+					if err := chatService.SaveDraft(workCtx, principal, chatID, content); err != nil {
+						return nil, err
+					}
+				}
+				return pending, nil
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				saved, ok := value.(map[string]string)
+				if !ok {
+					return
+				}
+				unsavedDrafts.Set(withoutSavedDrafts(unsavedDrafts.Get(), saved))
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		loadPreferencesAction := setup.Action(&s,
+			func(workCtx context.Context, _ struct{}) (chatsvc.Preferences, error) {
+				return chatService.Preferences(workCtx, principal)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				prefs, ok := value.(chatsvc.Preferences)
+				if !ok {
+					return
+				}
+				preferences.Set(prefs)
+				timezoneInput.Set(prefs.Timezone)
+				resultsEmailInput.Set(prefs.ResultsEmail)
+				if themes.Has(prefs.Theme) {
+					themeName.Set(prefs.Theme)
+				}
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		setTimezoneAction := setup.Action(&s,
+			func(workCtx context.Context, timezone string) (chatsvc.Preferences, error) {
+				return chatService.SetTimezone(workCtx, principal, timezone)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				prefs, ok := value.(chatsvc.Preferences)
+				if !ok {
+					return
+				}
+				preferences.Set(prefs)
+				timezoneInput.Set(prefs.Timezone)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		setResultsEmailAction := setup.Action(&s,
+			func(workCtx context.Context, address string) (chatsvc.Preferences, error) {
+				return chatService.SetResultsEmail(workCtx, principal, address)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				prefs, ok := value.(chatsvc.Preferences)
+				if !ok {
+					return
+				}
+				preferences.Set(prefs)
+				resultsEmailInput.Set(prefs.ResultsEmail)
+				errorText.Set("")
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		deleteAllDataAction := setup.Action(&s,
+			func(workCtx context.Context, _ struct{}) (chatsvc.DataDeletion, error) {
+				return chatService.DeleteAllData(workCtx, principal)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(any) {
+				deleteDataOpen.Set(false)
+				deleteDataInput.Set("")
+				chats.Set([]chatsvc.Chat{})
+				chatsCursor.Set("")
+				chatsHasMore.Set(false)
+				drafts.Set(map[string]string{})
+				unsavedDrafts.Set(map[string]string{})
+				activeChatID.Set("")
+				messages.Set([]MessageView{})
+				providerKeys.Set([]chatsvc.ProviderKey{})
+				errorText.Set("")
+				loadPreferencesAction.Run(struct{}{})
+				createChatAction.Run(selectedModel.Get())
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		setThemeAction := setup.Action(&s,
+			func(workCtx context.Context, name string) (chatsvc.Preferences, error) {
+				return chatService.SetTheme(workCtx, principal, name)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				if prefs, ok := value.(chatsvc.Preferences); ok {
+					preferences.Set(prefs)
+				}
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		setLanguageAction := setup.Action(&s,
+			func(workCtx context.Context, tag string) (chatsvc.Preferences, error) {
+				return chatService.SetLanguage(workCtx, principal, tag)
+			},
+			vango.CancelLatest(),
+			vango.ActionOnSuccess(func(value any) {
+				if prefs, ok := value.(chatsvc.Preferences); ok {
+					preferences.Set(prefs)
+				}
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		loadProviderKeysAction := setup.Action(&s,
+			func(workCtx context.Context, _ struct{}) ([]chatsvc.ProviderKey, error) {
+				return chatService.ProviderKeys(workCtx, principal)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				if keys, ok := value.([]chatsvc.ProviderKey); ok {
+					providerKeys.Set(keys)
+				}
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		// The key is cleared from the input once saved; only its last four
+		// characters are ever shown again.
+		setProviderKeyAction := setup.Action(&s,
+			func(workCtx context.Context, request providerKeyRequest) ([]chatsvc.ProviderKey, error) {
+				if request.Key == "" {
+					return chatService.DeleteProviderKey(workCtx, principal, request.Provider)
+				}
+				return chatService.SetProviderKey(workCtx, principal, request.Provider, request.Key)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				if keys, ok := value.([]chatsvc.ProviderKey); ok {
+					providerKeys.Set(keys)
+					keyInput.Set("")
+					errorText.Set("")
+				}
+			}),
+			vango.ActionOnError(func(err error) {
+				errorText.Set(err.Error())
+			}),
+		)
+
+		recordStopAction := setup.Action(&s,
+			func(workCtx context.Context, model string) (struct{}, error) {
+				chatService.RecordStop(workCtx, principal, model)
+				return struct{}{}, nil
+			},
+			vango.DropWhileRunning(),
+		)
+
+		s.OnMount(func() vango.Cleanup {
+			reloadChats()
+			loadPreferencesAction.Run(struct{}{})
+			if chatService.ProviderKeysEnabled() {
+				loadProviderKeysAction.Run(struct{}{})
+			}
+			return func() {
+				unfollowAll()
+				unwatchAll()
+			}
+		})
+
+		s.Effect(func() vango.Cleanup {
+			chatID := activeChatID.Get()
+			// Runs of the chat left behind go on in the background; loading
+			// a chat follows its runs again.
+			unfollowAll()
+			activeRunID.Set("")
+			activeAssistantID.Set("")
+			isThinking.Set(false)
+			notifications.Set(withoutChatNotifications(notifications.Peek(), chatID))
+			visibleMessages.Set(messageWindow)
+			if draft, ok := drafts.Peek()[chatID]; ok {
+				inputText.Set(draft)
+			} else {
+				inputText.Set("")
+				if chatID != "" {
+					loadDraftAction.Run(chatID)
+				}
+			}
+			findQuery.Set("")
+			findMatches.Set([]string{})
+			findIndex.Set(0)
+			toolsOpen.Set(false)
+			knowledgeOpen.Set(false)
+			infoOpen.Set(false)
+			knowledge.Set([]KnowledgeView{})
+			pendingAttachments.Set([]AttachmentView{})
+			replays.Set(map[string][]chatsvc.ReplayRun{})
+			if chatID == "" {
+				messages.Set([]MessageView{})
+				return nil
+			}
+			loadMessagesAction.Run(chatID)
+			loadPendingAttachmentsAction.Run(chatID)
+			return nil
+		})
+
+		startRunsAction := setup.Action(&s,
+			func(workCtx context.Context, run PendingRun) (PendingRun, error) {
+				attempts := make([]chatsvc.PendingRun, 0, 2)
+				for _, attempt := range run.attempts() {
+					attempts = append(attempts, chatsvc.PendingRun{
+						RunID:              attempt.RunID,
+						ChatID:             attempt.ChatID,
+						UserMessageID:      attempt.UserMessageID,
+						AssistantMessageID: attempt.AssistantMessageID,
+						Model:              attempt.Model,
+						ReuseUserMessage:   attempt.ReuseUserMessage,
+						Locale:             locale,
+						ComparisonID:       attempt.ComparisonID,
+						IdempotencyKey:     attempt.IdempotencyKey,
+						RunTimeout:         attempt.RunTimeout,
+					})
+				}
+				// The service executes the runs, so they finish and are
+				// saved even if the user switches chats or the page goes
+				// away; the page only follows them.
+				return run, chatService.StartRuns(workCtx, principal, run.UserContent, attempts...)
+			},
+			vango.DropWhileRunning(),
+			vango.ActionOnSuccess(func(value any) {
+				run, ok := value.(PendingRun)
+				if !ok || activeRunID.Get() != run.RunID {
+					return
+				}
+				for _, attempt := range run.attempts() {
+					watchRun(attempt.RunID, attempt.ChatID)
+					if live, ok := followRun(attempt.RunID); ok {
+						messages.Set(applyLiveRun(messages.Peek(), live))
+						if live.Finished != nil {
+							showOutcome(live.AssistantMessageID, live.Finished)
+						}
+					}
+				}
+				settleRun()
+			}),
+			vango.ActionOnError(func(err error) {
+				run := pendingRun.Get()
+				if activeRunID.Get() != run.RunID {
+					return
+				}
+				activeRunID.Set("")
+				activeAssistantID.Set("")
+				isThinking.Set(false)
+
+				if errors.Is(err, chatsvc.ErrRunExists) {
+					// The message was already sent; show the chat as
+					// stored instead of the copy just added.
+					loadMessagesAction.Run(run.ChatID)
+					return
+				}
+				errorText.Set(err.Error())
+				for _, attempt := range run.attempts() {
+					messages.Set(setAssistantError(messages.Peek(), attempt.AssistantMessageID, err.Error()))
+				}
+			}),
+		)
+
+		startRun := func(run PendingRun) {
+			isThinking.Set(true)
+			runUsage.Set(chatsvc.UsageProgress{})
+			errorText.Set("")
+			activeRunID.Set(run.RunID)
+			activeAssistantID.Set(run.AssistantMessageID)
+			pendingRun.Set(run)
+			startRunsAction.Run(run)
+		}
+
+		setComposerText := func(value string) {
+			inputText.Set(value)
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			drafts.Set(withDraft(drafts.Get(), chatID, value))
+			unsavedDrafts.Set(withDraft(unsavedDrafts.Get(), chatID, value))
+			saveDraftsAction.Run(unsavedDrafts.Get())
+		}
+
+		onSend := func() {
+			if activeRunID.Get() != "" {
+				return
+			}
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			content := strings.TrimSpace(inputText.Get())
+			if content == "" {
+				return
+			}
+			model := selectedModel.Get()
+			if !chatService.IsAllowedModel(model) {
+				model = chatService.DefaultModel()
+				selectedModel.Set(model)
+			}
+			comparing := compareOn.Get()
+			if comparing {
+				if err := chatService.ValidateComparison(model, compareModel.Get()); err != nil {
+					errorText.Set(preferences.Get().Localizer(language).T("compare.same_model"))
+					return
+				}
+			}
+
+			runID := uuid.NewString()
+			userMessageID := uuid.NewString()
+			assistantMessageID := uuid.NewString()
+			now := time.Now().UTC()
+			runTimeout := chatService.RunTimeout()
+
+			run := PendingRun{
+				RunID:              runID,
+				ChatID:             chatID,
+				UserMessageID:      userMessageID,
+				AssistantMessageID: assistantMessageID,
+				Model:              model,
+				UserContent:        content,
+				RunTimeout:         runTimeout,
+				StartedAt:          now,
+				IdempotencyKey:     sendKey.Get(),
+			}
+			views := []MessageView{
+				{ID: userMessageID, Role: "user", Content: content, Status: "complete", Attachments: pendingAttachments.Get(), CreatedAt: now},
+				{ID: assistantMessageID, Role: "assistant", Content: "", Status: "streaming", CreatedAt: now, RunTimeout: runTimeout},
+			}
+			if comparing {
+				// The first run's ID names the comparison, and its answer
+				// is the one later turns build on.
+				run.ComparisonID = runID
+				run.Compare = &PendingRun{
+					RunID:              uuid.NewString(),
+					ChatID:             chatID,
+					UserMessageID:      userMessageID,
+					AssistantMessageID: uuid.NewString(),
+					Model:              compareModel.Get(),
+					ReuseUserMessage:   true,
+					RunTimeout:         runTimeout,
+					StartedAt:          now,
+					ComparisonID:       runID,
+				}
+				views[1].ComparisonID = runID
+				views[1].ComparisonModel = model
+				views = append(views, MessageView{ID: run.Compare.AssistantMessageID, Role: "assistant", Content: "", Status: "streaming", CreatedAt: now, RunTimeout: runTimeout, ComparisonID: runID, ComparisonModel: run.Compare.Model})
+			}
+
+			messages.Set(append(messages.Get(), views...))
+			setComposerText("")
+			pendingAttachments.Set([]AttachmentView{})
+			previewOpen.Set(false)
+			sendKey.Set(uuid.NewString())
+			startRun(run)
+		}
+
+		// onRetry re-runs the user message behind a timed-out or
+		// interrupted reply. Timed-out runs get a longer budget.
+		onRetry := func(message MessageView) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			chatID := activeChatID.Get()
+			userMessage := findPrecedingUserMessage(messages.Get(), message.ID)
+			if chatID == "" || userMessage.ID == "" {
+				return
+			}
+			model := selectedModel.Get()
+			if !chatService.IsAllowedModel(model) {
+				model = chatService.DefaultModel()
+				selectedModel.Set(model)
+			}
+
+			assistantMessageID := uuid.NewString()
+			now := time.Now().UTC()
+			runTimeout := chatService.RunTimeout()
+			if message.Status == "timed_out" {
+				runTimeout = chatService.RetryRunTimeout(message.RunTimeout)
+			}
+
+			messages.Set(append(messages.Get(),
+				MessageView{ID: assistantMessageID, Role: "assistant", Content: "", Status: "streaming", CreatedAt: now, RunTimeout: runTimeout},
+			))
+			startRun(PendingRun{
+				RunID:              uuid.NewString(),
+				ChatID:             chatID,
+				UserMessageID:      userMessage.ID,
+				AssistantMessageID: assistantMessageID,
+				Model:              model,
+				ReuseUserMessage:   true,
+				RunTimeout:         runTimeout,
+				StartedAt:          now,
+			})
+		}
+
+		onStop := func() {
+			runID := activeRunID.Get()
+			assistantID := activeAssistantID.Get()
+			if runID == "" || assistantID == "" {
+				return
+			}
+			chatService.CancelRun(runID)
+			recordStopAction.Run(pendingRun.Get().Model)
+			activeRunID.Set("")
+			activeAssistantID.Set("")
+			isThinking.Set(false)
+			messages.Set(markAssistantStatus(messages.Get(), assistantID, "cancelled"))
+			if run := pendingRun.Get(); run.RunID == runID && run.Compare != nil {
+				chatService.CancelRun(run.Compare.RunID)
+				messages.Set(markAssistantStatus(messages.Get(), run.Compare.AssistantMessageID, "cancelled"))
+			}
+		}
+
+		onNewChat := func() {
+			if activeRunID.Get() != "" {
+				return
+			}
+			editingChatID.Set("")
+			renameTitle.Set("")
+			createChatAction.Run(selectedModel.Get())
+		}
+
+		onStartRename := func(chat chatsvc.Chat) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			sharingChatID.Set("")
+			editingChatID.Set(chat.ID)
+			renameTitle.Set(chat.Title)
+			errorText.Set("")
+		}
+
+		onRegenerateTitle := func(chatID string) {
+			if regeneratingChatID.Get() != "" {
+				return
+			}
+			regeneratingChatID.Set(chatID)
+			errorText.Set("")
+			regenerateTitleAction.Run(chatID)
+		}
+
+		onCancelRename := func() {
+			editingChatID.Set("")
+			renameTitle.Set("")
+		}
+
+		onSaveRename := func(chatID string) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			renameChatAction.Run(renameChatRequest{
+				ChatID: chatID,
+				Title:  renameTitle.Get(),
+			})
+		}
+
+		onStartTransfer := func(chatID string) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			editingChatID.Set("")
+			sharingChatID.Set("")
+			transferChatID.Set(chatID)
+			transferTarget.Set("")
+		}
+
+		onCancelTransfer := func() {
+			transferChatID.Set("")
+			transferTarget.Set("")
+		}
+
+		onConfirmTransfer := func(chatID string) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			transferChatAction.Run(transferChatRequest{
+				ChatID:   chatID,
+				ToUserID: transferTarget.Get(),
+			})
+		}
+
+		onStartShare := func(chatID string) {
+			editingChatID.Set("")
+			transferChatID.Set("")
+			sharingChatID.Set(chatID)
+			shareLinks.Set([]chatsvc.ShareLink{})
+			loadShareLinksAction.Run(chatID)
+		}
+
+		onCloseShare := func() {
+			sharingChatID.Set("")
+			shareLinks.Set([]chatsvc.ShareLink{})
+		}
+
+		onDuplicateChat := func(chatID string) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			duplicateChatAction.Run(chatID)
+		}
+
+		onDeleteChat := func(chatID string) {
+			if activeRunID.Get() != "" {
+				return
+			}
+			deleteChatAction.Run(chatID)
+		}
+
+		onToggleTools := func() {
+			if toolsOpen.Get() {
+				toolsOpen.Set(false)
 				return
 			}
-			activeRunID.Set("")
-			activeAssistantID.Set("")
-			isThinking.Set(false)
-			messages.Set(markAssistantStatus(messages.Get(), assistantID, "cancelled"))
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			loadChatToolsAction.Run(chatID)
+			toolsOpen.Set(true)
+		}
+
+		onToggleKnowledge := func() {
+			if knowledgeOpen.Get() {
+				knowledgeOpen.Set(false)
+				return
+			}
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			loadKnowledgeAction.Run(chatID)
+			knowledgeOpen.Set(true)
+		}
+
+		onToggleInfo := func() {
+			if infoOpen.Get() {
+				infoOpen.Set(false)
+				return
+			}
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			loadSpendAction.Run(chatID)
+			loadScheduledPromptsAction.Run(chatID)
+			infoOpen.Set(true)
+		}
+
+		onSetChatTool := func(name string, enabled bool) {
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			setChatToolAction.Run(chatToolRequest{ChatID: chatID, Name: name, Enabled: enabled})
+		}
+
+		onToggleCompare := func() {
+			if compareOn.Get() {
+				compareOn.Set(false)
+				return
+			}
+			if second := compareModel.Get(); !chatService.IsAllowedModel(second) || second == selectedModel.Get() {
+				compareModel.Set(otherModel(chatService.AllowedModels(), selectedModel.Get()))
+			}
+			compareOn.Set(true)
+		}
+
+		onToggleParams := func() {
+			if paramsOpen.Get() {
+				paramsOpen.Set(false)
+				return
+			}
+			chat := findChatByID(chats.Get(), activeChatID.Get())
+			paramTemperature.Set(formatNullFloat(chat.Temperature))
+			paramMaxTokens.Set(formatNullInt(chat.MaxTokens))
+			paramTopP.Set(formatNullFloat(chat.TopP))
+			paramReasoningEffort.Set(chat.ReasoningEffort.String)
+			paramsOpen.Set(true)
+		}
+
+		onSaveParams := func() {
+			chatID := activeChatID.Get()
+			if chatID == "" {
+				return
+			}
+			updateParamsAction.Run(chatParamsRequest{
+				ChatID: chatID,
+				Input: chatsvc.ChatParamsInput{
+					Temperature:     paramTemperature.Get(),
+					MaxTokens:       paramMaxTokens.Get(),
+					TopP:            paramTopP.Get(),
+					ReasoningEffort: paramReasoningEffort.Get(),
+				},
+			})
+		}
+
+		onFindInput := func(value string) {
+			findQuery.Set(value)
+			if strings.TrimSpace(value) == "" {
+				findMatches.Set([]string{})
+				findIndex.Set(0)
+				return
+			}
+			searchChatAction.Run(searchChatRequest{ChatID: activeChatID.Get(), Query: value})
+		}
+
+		onFindStep := func(delta int) {
+			matches := findMatches.Get()
+			if len(matches) == 0 {
+				return
+			}
+			findIndex.Set((findIndex.Get() + delta + len(matches)) % len(matches))
+		}
+
+		onShortcut := func(shortcut keyboardShortcut) {
+			switch shortcut.Action {
+			case "send":
+				if activeRunID.Get() != "" {
+					return
+				}
+				inputText.Set(shortcut.Text)
+				onSend()
+			case "stop":
+				onStop()
+			case "edit-last":
+				if strings.TrimSpace(inputText.Get()) == "" {
+					setComposerText(lastUserMessage(messages.Get()).Content)
+				}
+			case "rename":
+				if chat := findChatByID(chats.Get(), activeChatID.Get()); chat.ID != "" {
+					onStartRename(chat)
+				}
+			}
+		}
+
+		onToggleTheme := func() {
+			next := nextTheme(themes, themeName.Get())
+			themeName.Set(next.Name)
+			setThemeAction.Run(next.Name)
+		}
+
+		return func() *vango.VNode {
+			chatList := chats.Get()
+			messageList := messages.Get()
+			activeChat := activeChatID.Get()
+			running := activeRunID.Get() != ""
+			multiUser := !principal.IsZero() && principal.UserID != auth.AnonymousUserID
+			thinking := isThinking.Get()
+			selected := selectedModel.Get()
+			errorMessage := errorText.Get()
+			allowedModels := chatService.AllowedModels()
+			currentTheme := themes.Get(themeName.Get())
+			palette := currentTheme.Palette
+			prefs := preferences.Get()
+			tr := prefs.Localizer(language)
+
+			var errorNode *vango.VNode
+			if errorMessage != "" {
+				errorNode = Div(Class("mb-2 text-sm "+palette.ErrorText), Text(errorMessage))
+			}
+
+			matchIDs := findMatches.Get()
+			matchSet := make(map[string]bool, len(matchIDs))
+			for _, id := range matchIDs {
+				matchSet[id] = true
+			}
+			currentMatchID := ""
+			if len(matchIDs) > 0 {
+				currentMatchID = matchIDs[findIndex.Get()%len(matchIDs)]
+			}
+
+			windowStart := messageWindowStart(messageList, visibleMessages.Get(), currentMatchID)
+			visibleList := messageList[windowStart:]
+
+			var runTimerNode, runUsageNode *vango.VNode
+			if running {
+				runTimerNode = renderRunTimer(pendingRun.Get(), tr, palette)
+				runUsageNode = renderRunUsage(runUsage.Get(), tr, palette)
+			}
+
+			renderMessage := func(message MessageView) *vango.VNode {
+				bubbleClass := "rounded-lg px-4 py-3 max-w-3xl whitespace-pre-wrap border"
+				containerClass := "flex"
+				if message.Role == "user" {
+					containerClass += " justify-end"
+					bubbleClass += " " + palette.UserBubble
+				} else {
+					containerClass += " justify-start"
+					bubbleClass += " " + palette.AssistantBubble
+				}
+				if message.ID == currentMatchID {
+					bubbleClass += " " + palette.FindActive
+				} else if matchSet[message.ID] {
+					bubbleClass += " " + palette.FindMatch
+				}
+
+				statusBadge := messageStatusLabel(message.Status, tr)
+				if flagLabel := messageFlagLabel(message.Flag, tr); flagLabel != "" {
+					if statusBadge != "" {
+						statusBadge += " · "
+					}
+					statusBadge += flagLabel
+				}
+				if message.Golden {
+					if statusBadge != "" {
+						statusBadge += " · "
+					}
+					statusBadge += tr.T("status.golden")
+				}
+
+				contentClass := ""
+				if message.Flag == chatsvc.MessageFlagSensitive {
+					contentClass = "message-sensitive"
+				}
+
+				var retryNode *vango.VNode
+				if message.Role == "assistant" && message.Status == "timed_out" {
+					retryNode = Button(
+						Class("mt-2 rounded-md px-2 py-1 text-xs disabled:opacity-50 "+palette.RetryButton),
+						OnClick(func() {
+							onRetry(message)
+						}),
+						Disabled(running),
+						Text(tr.T("message.retry_with_timeout", chatService.RetryRunTimeout(message.RunTimeout))),
+					)
+				}
+				// A rate limit or overloaded provider is worth retrying as is.
+				if message.Role == "assistant" && message.Status == "error" && len(message.Runs) > 0 && chatsvc.RetryableStopReason(message.Runs[len(message.Runs)-1].StopReason) {
+					retryNode = Button(
+						Class("mt-2 rounded-md px-2 py-1 text-xs disabled:opacity-50 "+palette.RetryButton),
+						OnClick(func() {
+							onRetry(message)
+						}),
+						Disabled(running),
+						Text(tr.T("message.retry")),
+					)
+				}
+
+				if message.Role == "assistant" && message.Content == "" && message.Reasoning == "" && thinking {
+					label := tr.T("message.thinking")
+					if message.QueuePosition > 0 {
+						label = tr.T("message.waiting")
+					}
+					return Div(Class(containerClass), ID("msg-"+message.ID),
+						Div(Class(bubbleClass),
+							Div(Class("text-sm "+palette.ThinkingText), Text(label)),
+						),
+					)
+				}
+
+				return Div(Class(containerClass), ID("msg-"+message.ID),
+					Div(Class(bubbleClass),
+						Div(Class("text-[10px] mb-2 flex items-center justify-between gap-3 "+palette.StatusText),
+							Span(
+								Attr("aria-hidden", "true"),
+								If(statusBadge != "", Text(statusBadge)),
+							),
+							renderTimestamp(message, prefs, tr),
+						),
+						renderReasoning(message, tr, palette),
+						Div(Class(contentClass),
+							renderMessageContent(message, currentTheme.Mode, tr, palette),
+						),
+						renderAttachmentChips(message.Attachments, tr, palette, nil),
+						RangeKeyed(message.ToolCalls,
+							func(call ToolCallView) any { return call.ID },
+							func(call ToolCallView) *vango.VNode {
+								return renderToolCall(call, tr, palette, func() {
+									toolCallAction.Run(call.ID)
+								})
+							},
+						),
+						renderSources(message.Sources, tr, palette),
+						renderRunDetails(message.Runs, tr, palette),
+						retryNode,
+						renderReplays(message, replays.Get()[message.ID], chatService.ReplayEnabled(), replayingID.Get() != "", running, tr, palette, func() {
+							replayingID.Set(message.ID)
+							replayRunAction.Run(replayRequest{MessageID: message.ID, Model: selectedModel.Get()})
+						}),
+						renderFlagControls(message, running, tr, palette, func(flag string) {
+							flagMessageAction.Run(flagMessageRequest{MessageID: message.ID, Flag: flag})
+						}, func(golden bool) {
+							goldenAction.Run(goldenRequest{MessageID: message.ID, Golden: golden})
+						}),
+					),
+				)
+			}
+
+			return Div(Class("h-screen chat-shell "+palette.AppRoot), Attr("style", currentTheme.Style()), Attr("lang", tr.Lang()),
+				Div(Class("h-full flex"),
+					Aside(Class("w-80 flex flex-col "+palette.Sidebar),
+						Div(Class("p-4 "+palette.SidebarSection),
+							Button(
+								Class("w-full rounded-md px-3 py-2 text-sm font-medium transition-colors "+palette.NewChatButton),
+								OnClick(onNewChat),
+								Disabled(running),
+								Text(tr.T("sidebar.new_chat")),
+							),
+							renderNotifications(notifications.Get(), tr, palette, func(notification NotificationView) {
+								activeChatID.Set(notification.ChatID)
+								if chat := findChatByID(chats.Get(), notification.ChatID); chatService.IsAllowedModel(chat.Model) {
+									selectedModel.Set(chat.Model)
+								}
+							}, func(notificationID string) {
+								notifications.Set(withoutNotification(notifications.Get(), notificationID))
+							}),
+						),
+						Div(Class("flex-1 overflow-y-auto p-2 space-y-2"), ID(chatListID),
+							RangeKeyed(chatList,
+								func(chat chatsvc.Chat) any { return chat.ID },
+								func(chat chatsvc.Chat) *vango.VNode {
+									buttonClass := palette.ChatButtonBase + " " + palette.ChatButtonIdle
+									if chat.ID == activeChat {
+										buttonClass = palette.ChatButtonBase + " " + palette.ChatButtonActive
+									}
+									isEditing := editingChatID.Get() == chat.ID
+									if isEditing {
+										return Div(Class(buttonClass+" space-y-2"),
+											Input(
+												Class("w-full rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+												ID(renameInputID),
+												Value(renameTitle.Get()),
+												OnInput(func(value string) {
+													renameTitle.Set(value)
+												}),
+											),
+											Div(Class("flex gap-2"),
+												Button(
+													Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+													OnClick(func() {
+														onSaveRename(chat.ID)
+													}),
+													Disabled(running || strings.TrimSpace(renameTitle.Get()) == ""),
+													Text(tr.T("common.save")),
+												),
+												Button(
+													Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+													OnClick(onCancelRename),
+													Disabled(running),
+													Text(tr.T("common.cancel")),
+												),
+											),
+										)
+									}
+									if transferChatID.Get() == chat.ID {
+										return Div(Class(buttonClass+" space-y-2"),
+											Div(Class("truncate text-sm"), Text(tr.T("sidebar.transfer_to", chat.Title))),
+											Input(
+												Class("w-full rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+												Placeholder(tr.T("sidebar.transfer_placeholder")),
+												Value(transferTarget.Get()),
+												OnInput(func(value string) {
+													transferTarget.Set(value)
+												}),
+											),
+											Div(Class("flex gap-2"),
+												Button(
+													Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+													OnClick(func() {
+														onConfirmTransfer(chat.ID)
+													}),
+													Disabled(running || strings.TrimSpace(transferTarget.Get()) == ""),
+													Text(tr.T("sidebar.transfer")),
+												),
+												Button(
+													Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+													OnClick(onCancelTransfer),
+													Disabled(running),
+													Text(tr.T("common.cancel")),
+												),
+											),
+										)
+									}
+									if sharingChatID.Get() == chat.ID {
+										return renderSharePanel(chat, shareLinks.Get(), buttonClass, tr, palette, func() {
+											createShareLinkAction.Run(chat.ID)
+										}, func(linkID string) {
+											revokeShareLinkAction.Run(shareLinkRequest{ChatID: chat.ID, LinkID: linkID})
+										}, onCloseShare)
+									}
+									return Div(Class(buttonClass),
+										Button(
+											Class("w-full text-left"),
+											OnClick(func() {
+												activeChatID.Set(chat.ID)
+												if chatService.IsAllowedModel(chat.Model) {
+													selectedModel.Set(chat.Model)
+												}
+											}),
+											Div(Class("flex items-center gap-2"),
+												Div(Class("truncate font-medium"), Text(chat.Title)),
+												renderNotificationBadge(notifications.Get(), chat.ID, tr, palette),
+											),
+											Div(Class("text-xs truncate mt-1 "+palette.ChatMeta), Text(chat.Model)),
+										),
+										Div(Class("mt-2 flex gap-2"),
+											Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+												OnClick(func() {
+													onStartRename(chat)
+												}),
+												Disabled(running),
+												Text(tr.T("sidebar.rename")),
+											),
+											Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+												OnClick(func() {
+													onRegenerateTitle(chat.ID)
+												}),
+												Disabled(regeneratingChatID.Get() != ""),
+												Attr("title", tr.T("sidebar.regenerate_title_hint")),
+												Text(regenerateTitleLabel(regeneratingChatID.Get() == chat.ID, tr)),
+											),
+											Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+												OnClick(func() {
+													onDuplicateChat(chat.ID)
+												}),
+												Disabled(running),
+												Text(tr.T("sidebar.duplicate")),
+											),
+											If(chatService.SharingEnabled(), Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+												OnClick(func() {
+													onStartShare(chat.ID)
+												}),
+												Text(tr.T("sidebar.share")),
+											)),
+											If(multiUser, Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+												OnClick(func() {
+													onStartTransfer(chat.ID)
+												}),
+												Disabled(running),
+												Text(tr.T("sidebar.transfer")),
+											)),
+											Button(
+												Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+												OnClick(func() {
+													onDeleteChat(chat.ID)
+												}),
+												Disabled(running),
+												Text(tr.T("sidebar.delete")),
+											),
+										),
+									)
+								},
+							),
+							renderLoadMoreChats(chatsHasMore.Get(), chatsCursor.Get(), tr, palette, func() {
+								if cursor := chatsCursor.Get(); chatsHasMore.Get() && cursor != "" {
+									loadMoreChatsAction.Run(cursor)
+								}
+							}),
+						),
+						Div(Class("px-4 py-3 text-xs space-y-1 "+palette.SidebarSection+" "+palette.ChatMeta),
+							Div(Class("truncate"), Text(tr.T("sidebar.signed_in_as", principalName(principal)))),
+							renderLanguageSetting(prefs.Language, tr, palette, func(language string) {
+								setLanguageAction.Run(language)
+							}),
+							renderTimezoneSetting(timezoneInput.Get(), tr, palette, func(value string) {
+								timezoneInput.Set(value)
+							}, func() {
+								setTimezoneAction.Run(timezoneInput.Get())
+							}),
+							If(chatService.EmailEnabled(), renderResultsEmailSetting(resultsEmailInput.Get(), tr, palette, func(value string) {
+								resultsEmailInput.Set(value)
+							}, func() {
+								setResultsEmailAction.Run(resultsEmailInput.Get())
+							})),
+							If(multiUser && chatService.ProviderKeysEnabled(), renderProviderKeys(providerKeys.Get(), chatService.KeyProviders(), keyProvider.Get(), keyInput.Get(), tr, palette, providerKeyHandlers{
+								onProvider: keyProvider.Set,
+								onInput:    keyInput.Set,
+								onSave: func() {
+									if key := strings.TrimSpace(keyInput.Get()); key != "" {
+										setProviderKeyAction.Run(providerKeyRequest{Provider: keyProvider.Get(), Key: key})
+									}
+								},
+								onRemove: func(provider string) {
+									setProviderKeyAction.Run(providerKeyRequest{Provider: provider})
+								},
+							})),
+							A(Class("underline"), Href(RouteEvals), Text(tr.T("sidebar.golden_examples"))),
+							renderDeleteAllData(deleteDataOpen.Get(), deleteDataInput.Get(), running, tr, palette, deleteDataHandlers{
+								onOpen: func() {
+									deleteDataInput.Set("")
+									deleteDataOpen.Set(true)
+								},
+								onInput:  deleteDataInput.Set,
+								onCancel: func() { deleteDataOpen.Set(false) },
+								onConfirm: func() {
+									if activeRunID.Get() == "" && deleteDataConfirmed(deleteDataInput.Get(), tr) {
+										deleteAllDataAction.Run(struct{}{})
+									}
+								},
+							}),
+						),
+					),
+					Div(Class("flex-1 flex flex-col min-w-0"),
+						Div(Class("h-16 px-4 flex items-center justify-between gap-3 "+palette.Header),
+							Div(Class("text-sm truncate "+palette.HeaderTitle), Text(tr.T("header.chat", truncateText(activeChat, 8)))),
+							Div(Class("flex items-center gap-2"),
+								runUsageNode,
+								runTimerNode,
+								Select(
+									Class("rounded-md px-2 py-1 text-sm "+palette.ModelSelect),
+									Value(selected),
+									OnInput(func(value string) {
+										if chatService.IsAllowedModel(value) {
+											selectedModel.Set(value)
+										}
+									}),
+									RangeKeyed(allowedModels,
+										func(model string) any { return model },
+										func(model string) *vango.VNode {
+											return Option(Value(model), Text(model))
+										},
+									),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors disabled:opacity-50 "+palette.ThemeToggle),
+									OnClick(onToggleCompare),
+									Disabled(running || len(allowedModels) < 2),
+									Attr("aria-pressed", strconv.FormatBool(compareOn.Get())),
+									Text(tr.T("header.compare")),
+								),
+								If(compareOn.Get(), Select(
+									Class("rounded-md px-2 py-1 text-sm "+palette.ModelSelect),
+									Attr("aria-label", tr.T("header.compare_model")),
+									Value(compareModel.Get()),
+									OnInput(func(value string) {
+										if chatService.IsAllowedModel(value) {
+											compareModel.Set(value)
+										}
+									}),
+									RangeKeyed(allowedModels,
+										func(model string) any { return model },
+										func(model string) *vango.VNode {
+											return Option(Value(model), Text(model))
+										},
+									),
+								)),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
+									OnClick(onToggleParams),
+									Text(tr.T("header.params")),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
+									OnClick(onToggleTools),
+									Text(tr.T("header.tools")),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
+									OnClick(onToggleKnowledge),
+									Text(tr.T("header.knowledge")),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
+									OnClick(onToggleInfo),
+									Text(tr.T("header.info")),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
+									OnClick(onToggleTheme),
+									Text(themeLabel(nextTheme(themes, currentTheme.Name), tr)),
+								),
+								Button(
+									Class("rounded-md px-3 py-1.5 text-sm border disabled:opacity-50 "+palette.StopButton),
+									OnClick(onStop),
+									Disabled(!running),
+									Text(tr.T("header.stop")),
+								),
+							),
+						),
+						renderSetupBanner(chatService.SetupStatus(), selected, tr, palette, func(model string) {
+							selectedModel.Set(model)
+						}),
+						renderInterruptedBanner(messages.Get(), running, tr, palette, onRetry),
+						If(paramsOpen.Get(), Div(Class("px-4 py-3 flex flex-wrap items-end gap-3 "+palette.FindBar),
+							renderParamInput(tr.T("params.temperature"), "0–2", paramTemperature.Get(), palette, func(value string) {
+								paramTemperature.Set(value)
+							}),
+							renderParamInput(tr.T("params.max_tokens"), tr.T("params.default"), paramMaxTokens.Get(), palette, func(value string) {
+								paramMaxTokens.Set(value)
+							}),
+							renderParamInput(tr.T("params.top_p"), "0–1", paramTopP.Get(), palette, func(value string) {
+								paramTopP.Set(value)
+							}),
+							renderReasoningSelect(paramReasoningEffort.Get(), chatService.ReasoningEfforts(), tr, palette, func(value string) {
+								paramReasoningEffort.Set(value)
+							}),
+							Button(
+								Class("rounded-md px-3 py-1 text-sm "+palette.ChatSaveButton),
+								OnClick(onSaveParams),
+								Text(tr.T("common.save")),
+							),
+							Span(Class("text-xs "+palette.ChatMeta), Text(tr.T("params.hint"))),
+						)),
+						If(toolsOpen.Get(), renderToolsPanel(chatTools.Get(), running, tr, palette, onSetChatTool)),
+						If(infoOpen.Get(), renderInfoPanel(findChatByID(chatList, activeChat), chatSpend.Get(), spendCeilingInput.Get(), chatService.RetentionEnabled(), tr, palette, func(value string) {
+							spendCeilingInput.Set(value)
+						}, func() {
+							setSpendCeilingAction.Run(spendCeilingRequest{ChatID: activeChatID.Get(), Ceiling: spendCeilingInput.Get()})
+						}, func() {
+							chatID := activeChatID.Get()
+							setKeepForeverAction.Run(keepForeverRequest{ChatID: chatID, Keep: !findChatByID(chats.Get(), chatID).KeepForever})
+						})),
+						If(infoOpen.Get(), renderScheduledPrompts(scheduledPrompts.Get(), scheduledPromptRequest{
+							ChatID:    activeChat,
+							Prompt:    newPromptText.Get(),
+							Frequency: newPromptFrequency.Get(),
+							TimeOfDay: newPromptTime.Get(),
+						}, tr, palette, func(draft scheduledPromptRequest) {
+							newPromptText.Set(draft.Prompt)
+							newPromptFrequency.Set(draft.Frequency)
+							newPromptTime.Set(draft.TimeOfDay)
+						}, func(draft scheduledPromptRequest) {
+							scheduledPromptAction.Run(draft)
+						})),
+						If(knowledgeOpen.Get(), renderKnowledgePanel(knowledge.Get(), activeChat, tr, palette, func(documentID string) {
+							removeKnowledgeAction.Run(knowledgeRequest{ChatID: activeChatID.Get(), DocumentID: documentID})
+						}, func() {
+							if chatID := activeChatID.Get(); chatID != "" {
+								loadKnowledgeAction.Run(chatID)
+							}
+						})),
+						renderFindBar(findQuery.Get(), matchIDs, findIndex.Get(), messageList, tr, palette, onFindInput, onFindStep),
+						Div(Class("flex-1 overflow-y-auto p-4 space-y-4 "+palette.ChatBody), ID(chatBodyID),
+							renderFindJump(currentMatchID),
+							renderEarlierMessages(windowStart, tr, palette, func() {
+								visibleMessages.Set(visibleMessages.Get() + messageWindow)
+							}),
+							RangeKeyed(messageRows(visibleList),
+								func(row messageRow) any { return row.Key },
+								func(row messageRow) *vango.VNode {
+									if len(row.Messages) == 1 {
+										return renderMessage(row.Messages[0])
+									}
+									return renderComparisonRow(row, palette, renderMessage)
+								},
+							),
+							renderChatScroll(activeChat, messageList, tr),
+						),
+						If(previewOpen.Get(), renderPreviewPanel(preview.Get(), tr, palette, func() {
+							previewOpen.Set(false)
+						})),
+						If(toolCallOpen.Get(), renderToolCallDialog(toolCallDetail.Get(), tr, palette, func() {
+							toolCallOpen.Set(false)
+						})),
+						Div(Class("p-4 "+palette.Composer),
+							errorNode,
+							renderContextWarning(chatService.DraftContextUsage(selected, locale, inputText.Get()), tr, palette),
+							renderAttachmentChips(pendingAttachments.Get(), tr, palette, func(attachmentID string) {
+								removeAttachmentAction.Run(attachmentRequest{ChatID: activeChatID.Get(), AttachmentID: attachmentID})
+							}),
+							Div(Class("flex items-end gap-2"),
+								renderAttachButton(activeChatID.Get(), running, tr, palette, func() {
+									if chatID := activeChatID.Get(); chatID != "" {
+										loadPendingAttachmentsAction.Run(chatID)
+									}
+								}),
+								Textarea(
+									Class("flex-1 min-h-24 max-h-60 rounded-md px-3 py-2 text-sm resize-y "+palette.Input),
+									ID(composerInputID),
+									Placeholder(tr.T("composer.placeholder")),
+									Value(inputText.Get()),
+									OnInput(func(value string) {
+										setComposerText(value)
+									}),
+								),
+								Button(
+									Class("rounded-md px-3 py-2 text-sm disabled:opacity-50 "+palette.ChatActionButton),
+									OnClick(func() {
+										previewAction.Run(previewRequest{ChatID: activeChatID.Get(), Content: inputText.Get(), Model: selectedModel.Get()})
+									}),
+									Disabled(strings.TrimSpace(inputText.Get()) == ""),
+									Attr("title", tr.T("composer.preview_title")),
+									Text(tr.T("composer.preview")),
+								),
+								Button(
+									Class("rounded-md px-4 py-2 text-sm font-semibold disabled:opacity-50 "+palette.SendButton),
+									OnClick(onSend),
+									Disabled(running || strings.TrimSpace(inputText.Get()) == ""),
+									Text(tr.T("composer.send")),
+								),
+							),
+							If(chatService.RateLimitsEnabled() || chatService.BudgetsEnabled(), renderQuotaStatus(activeRunID.Get(), tr, palette)),
+							renderKeyboardShortcuts(running, tr, palette, onShortcut),
+						),
+					),
+				),
+			)
+		}
+	})
+}
+
+func principalName(principal auth.Principal) string {
+	if principal.Email != "" {
+		return principal.Email
+	}
+	if principal.Name != "" {
+		return principal.Name
+	}
+	return principal.UserID
+}
+
+// nextTheme is the theme the toggle switches to: the one after current in
+// the configured order, wrapping around.
+func nextTheme(themes *theme.Set, current string) theme.Theme {
+	all := themes.Themes()
+	for index, candidate := range all {
+		if candidate.Name == current {
+			return all[(index+1)%len(all)]
+		}
+	}
+	return all[0]
+}
+
+// themeLabel names a theme in the UI language. Built-in themes are
+// translated unless the operator relabelled them.
+func themeLabel(t theme.Theme, tr i18n.Localizer) string {
+	key := "theme." + t.Name
+	if t.Label != i18n.For(i18n.Default).T(key) {
+		return t.Label
+	}
+	if label, ok := tr.Lookup(key); ok {
+		return label
+	}
+	return t.Label
+}
+
+// maxNotifications is how many notifications the sidebar keeps; older ones
+// are dropped.
+const maxNotifications = 5
+
+// runNotification is the notification for a run of chat that ended with
+// outcome. Runs that were stopped need none.
+func runNotification(runID string, chat chatsvc.Chat, outcome *chatsvc.RunOutcome) (NotificationView, bool) {
+	notification := NotificationView{ID: runID, ChatID: chat.ID, ChatTitle: chat.Title, RunID: runID}
+	switch {
+	case outcome.Err != nil:
+		notification.Error = outcome.Err.Error()
+	case outcome.Status == "cancelled":
+		return NotificationView{}, false
+	case outcome.Status != "completed":
+		notification.Error = outcome.Error
+		if strings.TrimSpace(notification.Error) == "" {
+			notification.Error = outcome.Status
+		}
+	}
+	return notification, true
+}
+
+func appendNotification(notifications []NotificationView, notification NotificationView) []NotificationView {
+	next := append(slices.Clone(notifications), notification)
+	if len(next) > maxNotifications {
+		next = next[len(next)-maxNotifications:]
+	}
+	return next
+}
+
+func withoutNotification(notifications []NotificationView, notificationID string) []NotificationView {
+	return slices.DeleteFunc(slices.Clone(notifications), func(notification NotificationView) bool {
+		return notification.ID == notificationID
+	})
+}
+
+func withoutChatNotifications(notifications []NotificationView, chatID string) []NotificationView {
+	return slices.DeleteFunc(slices.Clone(notifications), func(notification NotificationView) bool {
+		return notification.ChatID == chatID
+	})
+}
+
+// renderNotifications lists the runs that finished in other chats, newest
+// first. Opening one switches to its chat, which dismisses it.
+func renderNotifications(notifications []NotificationView, tr i18n.Localizer, palette themePalette, onOpen func(NotificationView), onDismiss func(string)) *vango.VNode {
+	if len(notifications) == 0 {
+		return nil
+	}
+	newestFirst := slices.Clone(notifications)
+	slices.Reverse(newestFirst)
+	return Div(Class("mt-3 space-y-2"), Attr("role", "status"),
+		RangeKeyed(newestFirst,
+			func(notification NotificationView) any { return notification.ID },
+			func(notification NotificationView) *vango.VNode {
+				title := notification.ChatTitle
+				if title == "" {
+					title = tr.T("notify.another_chat")
+				}
+				text := tr.T("notify.finished", title)
+				textClass := palette.ChatMeta
+				if notification.Error != "" {
+					text = tr.T("notify.failed", title, truncateText(notification.Error, 200))
+					textClass = palette.ErrorText
+				}
+				return Div(Class("flex items-start gap-2 rounded-md border px-2 py-1 text-xs "+palette.ToolCard),
+					Button(
+						Class("flex-1 text-left "+textClass),
+						OnClick(func() {
+							onOpen(notification)
+						}),
+						Text(text),
+					),
+					Button(
+						Class("rounded-md px-1 "+palette.ChatActionButton),
+						OnClick(func() {
+							onDismiss(notification.ID)
+						}),
+						Attr("aria-label", tr.T("notify.dismiss")),
+						Text("×"),
+					),
+				)
+			},
+		),
+	)
+}
+
+// renderNotificationBadge counts a chat's notifications next to its title,
+// in the error color when one of them is a failure.
+func renderNotificationBadge(notifications []NotificationView, chatID string, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	count, failed := 0, false
+	for _, notification := range notifications {
+		if notification.ChatID == chatID {
+			count++
+			failed = failed || notification.Error != ""
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	badgeClass := palette.StatusText
+	if failed {
+		badgeClass = palette.ErrorText
+	}
+	return Span(Class("shrink-0 rounded-full border px-1.5 text-xs font-semibold "+badgeClass), Attr("title", tr.T("notify.badge", count)), Text(strconv.Itoa(count)))
+}
+
+func regenerateTitleLabel(regenerating bool, tr i18n.Localizer) string {
+	if regenerating {
+		return tr.T("sidebar.regenerating_title")
+	}
+	return tr.T("sidebar.regenerate_title")
+}
+
+// chatPageSize is how many chats the sidebar loads at a time.
+const chatPageSize = 50
+
+const chatListID = "chat-list"
+
+// appendChats adds a page to the sidebar, skipping chats already shown so a
+// chat bumped by activity between loads is not listed twice.
+func appendChats(chats, page []chatsvc.Chat) []chatsvc.Chat {
+	next := make([]chatsvc.Chat, 0, len(chats)+len(page))
+	next = append(next, chats...)
+	for _, chat := range page {
+		if !containsChat(chats, chat.ID) {
+			next = append(next, chat)
+		}
+	}
+	return next
+}
+
+// renderLoadMoreChats ends the chat list with a sentinel that loads the next
+// page when it scrolls into view, and a button for when it does not.
+func renderLoadMoreChats(hasMore bool, cursor string, tr i18n.Localizer, palette themePalette, onLoadMore func()) *vango.VNode {
+	if !hasMore {
+		return nil
+	}
+	return Div(Class("flex justify-center py-2"),
+		Div(
+			Data("module", "/js/islands/load-more.js"),
+			JSIsland("chat-list-more", map[string]any{
+				"rootId": chatListID,
+				"sinkId": "chat-list-more-sink",
+				"cursor": cursor,
+			}),
+			IslandPlaceholder(Span()),
+		),
+		Button(
+			Class("rounded-md px-3 py-1 text-xs "+palette.ChatActionButton),
+			OnClick(onLoadMore),
+			Text(tr.T("sidebar.load_more")),
+		),
+		Input(
+			Class("hidden"),
+			ID("chat-list-more-sink"),
+			Type("text"),
+			Attr("aria-hidden", "true"),
+			Attr("tabindex", "-1"),
+			OnInput(func(string) {
+				onLoadMore()
+			}),
+		),
+	)
+}
+
+// renderSharePanel lists a chat's working share links in its sidebar row,
+// with controls to create another or revoke one.
+func renderSharePanel(chat chatsvc.Chat, links []chatsvc.ShareLink, buttonClass string, tr i18n.Localizer, palette themePalette, onCreate func(), onRevoke func(string), onClose func()) *vango.VNode {
+	return Div(Class(buttonClass+" space-y-2"),
+		Div(Class("truncate text-sm"), Text(tr.T("sidebar.share_links", chat.Title))),
+		If(len(links) == 0, P(Class("text-xs "+palette.ChatMeta), Text(tr.T("sidebar.share_none")))),
+		RangeKeyed(links,
+			func(link chatsvc.ShareLink) any { return link.ID },
+			func(link chatsvc.ShareLink) *vango.VNode {
+				sharePath := RouteShare + "/" + link.Token
+				return Div(Class("flex items-center gap-2"),
+					A(Class("flex-1 truncate text-xs underline"), Href(sharePath), Attr("target", "_blank"), Attr("rel", "noopener"), Text(sharePath)),
+					Button(
+						Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+						OnClick(func() {
+							onRevoke(link.ID)
+						}),
+						Text(tr.T("sidebar.share_revoke")),
+					),
+				)
+			},
+		),
+		Div(Class("flex gap-2"),
+			Button(
+				Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+				OnClick(onCreate),
+				Text(tr.T("sidebar.share_create")),
+			),
+			Button(
+				Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+				OnClick(onClose),
+				Text(tr.T("common.close")),
+			),
+		),
+	)
+}
+
+func containsChat(chats []chatsvc.Chat, chatID string) bool {
+	for _, chat := range chats {
+		if chat.ID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+func findChatByID(chats []chatsvc.Chat, chatID string) chatsvc.Chat {
+	for _, chat := range chats {
+		if chat.ID == chatID {
+			return chat
+		}
+	}
+	return chatsvc.Chat{}
+}
+
+func updateChatTitle(chats []chatsvc.Chat, chatID, title string) []chatsvc.Chat {
+	next := make([]chatsvc.Chat, len(chats))
+	copy(next, chats)
+	for index := range next {
+		if next[index].ID != chatID {
+			continue
+		}
+		next[index].Title = title
+		next[index].UpdatedAt = time.Now().UTC()
+		break
+	}
+	return next
+}
+
+func updateChatKeepForever(chats []chatsvc.Chat, chatID string, keep bool) []chatsvc.Chat {
+	next := make([]chatsvc.Chat, len(chats))
+	copy(next, chats)
+	for index := range next {
+		if next[index].ID == chatID {
+			next[index].KeepForever = keep
+			break
+		}
+	}
+	return next
+}
+
+func updateChatParams(chats []chatsvc.Chat, chatID string, params chatsvc.ChatParams) []chatsvc.Chat {
+	next := make([]chatsvc.Chat, len(chats))
+	copy(next, chats)
+	for index := range next {
+		if next[index].ID != chatID {
+			continue
+		}
+		next[index].Temperature = params.Temperature
+		next[index].MaxTokens = params.MaxTokens
+		next[index].TopP = params.TopP
+		next[index].ReasoningEffort = params.ReasoningEffort
+		break
+	}
+	return next
+}
+
+func formatNullFloat(value sql.NullFloat64) string {
+	if !value.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(value.Float64, 'f', -1, 64)
+}
+
+func formatNullInt(value sql.NullInt64) string {
+	if !value.Valid {
+		return ""
+	}
+	return strconv.FormatInt(value.Int64, 10)
+}
+
+func removeChatByID(chats []chatsvc.Chat, chatID string) []chatsvc.Chat {
+	next := make([]chatsvc.Chat, 0, len(chats))
+	for _, chat := range chats {
+		if chat.ID == chatID {
+			continue
+		}
+		next = append(next, chat)
+	}
+	return next
+}
+
+func appendAssistantChunk(messages []MessageView, assistantMessageID, chunk string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		next[index].Content += chunk
+		next[index].Status = "streaming"
+		break
+	}
+	return next
+}
+
+func appendReasoningChunk(messages []MessageView, assistantMessageID, chunk string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		next[index].Reasoning += chunk
+		break
+	}
+	return next
+}
+
+// renderReasoning shows a model's thinking as a collapsible section. It stays
+// open while the answer has not started streaming so progress is visible.
+func renderReasoning(message MessageView, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if message.Role != "assistant" || message.Reasoning == "" {
+		return nil
+	}
+	return Details(
+		Class("mb-2 rounded-md border px-2 py-1 text-xs "+palette.ToolCard),
+		Open(message.Status == "streaming" && message.Content == ""),
+		Summary(Class("cursor-pointer select-none font-semibold "+palette.ThinkingText), Text(tr.T("message.reasoning"))),
+		Div(Class("mt-1 whitespace-pre-wrap "+palette.ThinkingText), Text(message.Reasoning)),
+	)
+}
+
+func setMessageFlag(messages []MessageView, messageID, flag string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != messageID {
+			continue
+		}
+		next[index].Flag = flag
+		break
+	}
+	return next
+}
+
+func setMessageGolden(messages []MessageView, messageID string, golden bool) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != messageID {
+			continue
+		}
+		next[index].Golden = golden
+		break
+	}
+	return next
+}
+
+func setMessageContent(messages []MessageView, messageID, content string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != messageID {
+			continue
 		}
+		next[index].Content = content
+		break
+	}
+	return next
+}
 
-		onNewChat := func() {
-			if activeRunID.Get() != "" {
-				return
+func setMessageSources(messages []MessageView, messageID string, sources []chatsvc.Source) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != messageID {
+			continue
+		}
+		next[index].Sources = sources
+		break
+	}
+	return next
+}
+
+func setMessageRuns(messages []MessageView, messageID string, runs []chatsvc.RunDetail) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != messageID {
+			continue
+		}
+		next[index].Runs = runs
+		break
+	}
+	return next
+}
+
+// runComparison returns the comparison a reply's runs belong to and the
+// model that wrote it, or empty strings outside compare mode.
+func runComparison(runs []chatsvc.RunDetail) (string, string) {
+	for _, run := range runs {
+		if run.ComparisonID != "" {
+			return run.ComparisonID, run.Model
+		}
+	}
+	return "", ""
+}
+
+// otherModel returns the first of models that is not model, for seeding
+// the second model when compare mode is turned on.
+func otherModel(models []string, model string) string {
+	for _, candidate := range models {
+		if candidate != model {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// messageRow is one row of the chat body: a single message, or the
+// answers of a side-by-side comparison.
+type messageRow struct {
+	Key      string
+	Messages []MessageView
+}
+
+// messageRows groups consecutive answers sharing a comparison into one
+// row and leaves every other message in a row of its own.
+func messageRows(messages []MessageView) []messageRow {
+	rows := make([]messageRow, 0, len(messages))
+	for _, message := range messages {
+		if last := len(rows) - 1; message.ComparisonID != "" && last >= 0 && rows[last].Messages[0].ComparisonID == message.ComparisonID {
+			rows[last].Messages = append(rows[last].Messages, message)
+			continue
+		}
+		rows = append(rows, messageRow{Key: message.ID, Messages: []MessageView{message}})
+	}
+	return rows
+}
+
+// renderComparisonRow lays a comparison's answers out in columns, each
+// under the model that wrote it.
+func renderComparisonRow(row messageRow, palette themePalette, renderMessage func(MessageView) *vango.VNode) *vango.VNode {
+	return Div(Class("grid grid-cols-2 gap-3"), Data("comparison", row.Messages[0].ComparisonID),
+		RangeKeyed(row.Messages,
+			func(message MessageView) any { return message.ID },
+			func(message MessageView) *vango.VNode {
+				return Div(Class("min-w-0 flex flex-col gap-1"),
+					Div(Class("text-xs font-medium truncate "+palette.ChatMeta), Text(message.ComparisonModel)),
+					renderMessage(message),
+				)
+			},
+		),
+	)
+}
+
+type runDetailRow struct {
+	Label string
+	Value string
+}
+
+// moderationSummary describes a moderation decision in one line, such as
+// "flag · terms: darn", or returns "" for an unscreened message.
+func moderationSummary(decision *moderation.Decision) string {
+	if decision == nil {
+		return ""
+	}
+	summary := string(decision.Action)
+	if decision.Source != "" {
+		summary += " · " + decision.Source
+	}
+	if len(decision.Categories) > 0 {
+		summary += ": " + strings.Join(decision.Categories, ", ")
+	}
+	return summary
+}
+
+// renderRunDetails shows what the runs table recorded for each attempt at
+// a reply, latest first, collapsed by default.
+func renderRunDetails(runs []chatsvc.RunDetail, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if len(runs) == 0 {
+		return nil
+	}
+	latest := make([]chatsvc.RunDetail, len(runs))
+	for index, run := range runs {
+		latest[len(runs)-1-index] = run
+	}
+	return Details(Class("mt-2 text-xs "+palette.ToolText),
+		Summary(Class("cursor-pointer"), Text(tr.T("run.details", len(runs)))),
+		Div(Class("mt-1 space-y-2"),
+			RangeKeyed(latest,
+				func(run chatsvc.RunDetail) any { return run.RunID },
+				func(run chatsvc.RunDetail) *vango.VNode {
+					cost := tr.T("run.cost_unknown")
+					if run.CostUSD != nil {
+						cost = chatsvc.FormatUSD(*run.CostUSD)
+					}
+					duration := tr.T("run.running")
+					if run.Duration > 0 {
+						duration = run.Duration.Round(100 * time.Millisecond).String()
+					}
+					var firstToken, stream, tokenRate string
+					if run.FirstToken > 0 {
+						firstToken = run.FirstToken.Round(10 * time.Millisecond).String()
+					}
+					if run.Stream > 0 {
+						stream = run.Stream.Round(100 * time.Millisecond).String()
+					}
+					if run.OutputTokensPerSecond > 0 {
+						tokenRate = tr.T("run.token_rate_value", run.OutputTokensPerSecond)
+					}
+					toolTimes := make([]string, 0, len(run.Tools))
+					for _, tool := range run.Tools {
+						toolTimes = append(toolTimes, tool.Name+" "+tool.Duration.Round(10*time.Millisecond).String())
+					}
+					rows := []runDetailRow{
+						{tr.T("run.run"), run.RunID},
+						{tr.T("run.started"), run.StartedAt.Local().Format(tr.T("time.timestamp"))},
+						{tr.T("run.model"), run.Model},
+						{tr.T("run.status"), run.Status},
+						{tr.T("run.stop_reason"), run.StopReason},
+						{tr.T("run.duration"), duration},
+						{tr.T("run.first_token"), firstToken},
+						{tr.T("run.stream"), stream},
+						{tr.T("run.token_rate"), tokenRate},
+						{tr.T("run.tool_time"), strings.Join(toolTimes, ", ")},
+						{tr.T("run.turns"), fmt.Sprint(run.TurnCount)},
+						{tr.T("run.tool_calls"), fmt.Sprint(run.ToolCallCount)},
+						{tr.T("run.tokens"), tr.T("run.tokens_value", run.InputTokens, run.OutputTokens)},
+						{tr.T("run.cost"), cost},
+						{tr.T("run.moderation"), moderationSummary(run.Moderation)},
+					}
+					rows = slices.DeleteFunc(rows, func(row runDetailRow) bool { return row.Value == "" })
+					var errNode *vango.VNode
+					if run.Error != "" {
+						errNode = Div(Class("mt-1 whitespace-pre-wrap "+palette.ToolErrorText), Text(truncateText(run.Error, 2000)))
+					}
+					return Div(Class("rounded-md border p-2 space-y-0.5 "+palette.ToolCard),
+						RangeKeyed(rows,
+							func(row runDetailRow) any { return row.Label },
+							func(row runDetailRow) *vango.VNode {
+								return Div(Class("flex gap-2"),
+									Span(Class("w-20 shrink-0 font-semibold"), Text(row.Label)),
+									Span(Class("truncate"), Text(row.Value)),
+								)
+							},
+						),
+						errNode,
+					)
+				},
+			),
+		),
+	)
+}
+
+// renderSources lists the pages tools read or found for a reply, linked to
+// the original URLs.
+func renderSources(sources []chatsvc.Source, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if len(sources) == 0 {
+		return nil
+	}
+	return Details(Class("mt-2 text-xs "+palette.ToolText),
+		Summary(Class("cursor-pointer"), Text(tr.T("message.sources", len(sources)))),
+		Div(Class("mt-1 space-y-1"),
+			RangeKeyed(sources,
+				func(source chatsvc.Source) any { return source.URL },
+				func(source chatsvc.Source) *vango.VNode {
+					label := source.Title
+					if label == "" {
+						label = source.URL
+					}
+					return Div(Class("truncate"),
+						A(Class("underline"), Href(source.URL), Attr("target", "_blank"), Attr("rel", "noopener noreferrer"), Attr("title", source.URL), Text(label)),
+					)
+				},
+			),
+		),
+	)
+}
+
+// messageStatusLabel is the badge for a message status, or "" for a
+// finished message.
+func messageStatusLabel(status string, tr i18n.Localizer) string {
+	switch status {
+	case "streaming", "error", "cancelled", "timed_out", "interrupted":
+		return tr.T("status." + status)
+	default:
+		return ""
+	}
+}
+
+func messageFlagLabel(flag string, tr i18n.Localizer) string {
+	switch flag {
+	case chatsvc.MessageFlagSensitive:
+		return tr.T("status.sensitive")
+	case chatsvc.MessageFlagHidden:
+		return tr.T("status.hidden")
+	default:
+		return ""
+	}
+}
+
+// renderReplays shows the developer replay action for finished assistant
+// messages and the replays recorded so far. Replays use the model selected
+// in the composer.
+func renderReplays(message MessageView, recorded []chatsvc.ReplayRun, enabled, replaying, running bool, tr i18n.Localizer, palette themePalette, onReplay func()) *vango.VNode {
+	if !enabled || message.Role != "assistant" || message.Status == "streaming" {
+		return nil
+	}
+	label := tr.T("replay.run")
+	if replaying {
+		label = tr.T("replay.running")
+	}
+	return Div(Class("mt-2 space-y-2 text-[10px]"),
+		Button(
+			Class("rounded-md px-2 py-0.5 disabled:opacity-50 "+palette.ChatActionButton),
+			OnClick(onReplay),
+			Disabled(running || replaying),
+			Text(label),
+		),
+		RangeKeyed(recorded,
+			func(replay chatsvc.ReplayRun) any { return replay.ID },
+			func(replay chatsvc.ReplayRun) *vango.VNode {
+				body := replay.Output
+				if replay.ErrorText != "" {
+					body = replay.ErrorText
+				}
+				return Details(Class("rounded-md border p-2 "+palette.ToolCard),
+					Summary(Text(tr.T("replay.summary", replay.StartedAt.Local().Format("15:04:05"), replay.Model, replay.Status))),
+					Div(Class("mt-1 whitespace-pre-wrap "+palette.ToolText), Text(truncateText(body, 4000))),
+				)
+			},
+		),
+	)
+}
+
+func renderFlagControls(message MessageView, running bool, tr i18n.Localizer, palette themePalette, onFlag func(string), onGolden func(bool)) *vango.VNode {
+	if message.Status == "streaming" {
+		return nil
+	}
+	actionButton := func(label string, onClick func()) *vango.VNode {
+		return Button(
+			Class("rounded-md px-2 py-0.5 disabled:opacity-50 "+palette.ChatActionButton),
+			OnClick(onClick),
+			Disabled(running),
+			Text(label),
+		)
+	}
+	flagButton := func(label, flag string) *vango.VNode {
+		return actionButton(label, func() {
+			onFlag(flag)
+		})
+	}
+	// Completed answers can be curated into the eval set shown on /evals.
+	var goldenButton *vango.VNode
+	switch {
+	case message.Golden:
+		goldenButton = actionButton(tr.T("flag.unmark_golden"), func() {
+			onGolden(false)
+		})
+	case message.Role == "assistant" && message.Status == "completed":
+		goldenButton = actionButton(tr.T("flag.mark_golden"), func() {
+			onGolden(true)
+		})
+	}
+	if message.Flag != "" {
+		return Div(Class("mt-2 flex gap-2 text-[10px]"), flagButton(tr.T("flag.unflag"), ""), goldenButton)
+	}
+	return Div(Class("mt-2 flex gap-2 text-[10px]"),
+		flagButton(tr.T("flag.mark_sensitive"), chatsvc.MessageFlagSensitive),
+		flagButton(tr.T("flag.hide"), chatsvc.MessageFlagHidden),
+		goldenButton,
+	)
+}
+
+func markAssistantStatus(messages []MessageView, assistantMessageID, status string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		next[index].Status = status
+		break
+	}
+	return next
+}
+
+// applyLiveRun shows what a background run has streamed so far on its
+// reply.
+func applyLiveRun(messages []MessageView, live chatsvc.LiveRun) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != live.AssistantMessageID {
+			continue
+		}
+		calls := make([]ToolCallView, 0, len(live.ToolCalls))
+		for _, call := range live.ToolCalls {
+			calls = append(calls, toolCallView(call))
+		}
+		next[index].Content = live.Content
+		next[index].Reasoning = live.Reasoning
+		next[index].Status = "streaming"
+		next[index].ToolCalls = calls
+		next[index].RunTimeout = live.RunTimeout
+		next[index].QueuePosition = live.QueuePosition
+		break
+	}
+	return next
+}
+
+func toolCallView(call chatsvc.ToolCallUpdate) ToolCallView {
+	status := call.Status
+	if status == "" {
+		status = "completed"
+	}
+	return ToolCallView{
+		ID:      call.ID,
+		Name:    call.Name,
+		Status:  status,
+		Input:   truncateText(call.Input, 500),
+		Output:  truncateText(call.Output, 500),
+		ErrText: truncateText(call.ErrText, 300),
+	}
+}
+
+func setQueuePosition(messages []MessageView, assistantMessageID string, position int) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		next[index].QueuePosition = position
+		break
+	}
+	return next
+}
+
+func setAssistantError(messages []MessageView, assistantMessageID, errMessage string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		next[index].Status = "error"
+		if strings.TrimSpace(errMessage) == "" {
+			errMessage = "Assistant request failed."
+		}
+		if strings.TrimSpace(next[index].Content) == "" {
+			next[index].Content = "Error: " + errMessage
+		}
+		break
+	}
+	return next
+}
+
+func addToolCall(messages []MessageView, assistantMessageID string, call ToolCallView) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for index := range next {
+		if next[index].ID != assistantMessageID {
+			continue
+		}
+		calls := append([]ToolCallView{}, next[index].ToolCalls...)
+		calls = append(calls, call)
+		next[index].ToolCalls = calls
+		break
+	}
+	return next
+}
+
+func updateToolCall(messages []MessageView, assistantMessageID, callID, status, output, errorText string) []MessageView {
+	next := make([]MessageView, len(messages))
+	copy(next, messages)
+	for messageIndex := range next {
+		if next[messageIndex].ID != assistantMessageID {
+			continue
+		}
+		calls := append([]ToolCallView{}, next[messageIndex].ToolCalls...)
+		for callIndex := range calls {
+			if calls[callIndex].ID != callID {
+				continue
 			}
-			editingChatID.Set("")
-			renameTitle.Set("")
-			createChatAction.Run(selectedModel.Get())
+			if status != "" {
+				calls[callIndex].Status = status
+			} else {
+				calls[callIndex].Status = "completed"
+			}
+			calls[callIndex].Output = output
+			calls[callIndex].ErrText = errorText
+			next[messageIndex].ToolCalls = calls
+			return next
+		}
+		if status == "" {
+			status = "completed"
+		}
+		calls = append(calls, ToolCallView{ID: callID, Status: status, Output: output, ErrText: errorText})
+		next[messageIndex].ToolCalls = calls
+		return next
+	}
+	return next
+}
+
+func findPrecedingUserMessage(messages []MessageView, messageID string) MessageView {
+	candidate := MessageView{}
+	for _, message := range messages {
+		if message.ID == messageID {
+			return candidate
+		}
+		if message.Role == "user" {
+			candidate = message
+		}
+	}
+	return MessageView{}
+}
+
+// draftSaveDelay is how long typing must pause before drafts are stored.
+const draftSaveDelay = 750 * time.Millisecond
+
+// withDraft returns a copy of drafts with chatID set to content.
+func withDraft(drafts map[string]string, chatID, content string) map[string]string {
+	next := make(map[string]string, len(drafts)+1)
+	for id, text := range drafts {
+		next[id] = text
+	}
+	next[chatID] = content
+	return next
+}
+
+func withoutDraft(drafts map[string]string, chatID string) map[string]string {
+	next := make(map[string]string, len(drafts))
+	for id, text := range drafts {
+		if id != chatID {
+			next[id] = text
+		}
+	}
+	return next
+}
+
+// withoutSavedDrafts drops the drafts that were stored, keeping any edited
+// since the save began.
+func withoutSavedDrafts(unsaved, saved map[string]string) map[string]string {
+	next := make(map[string]string, len(unsaved))
+	for id, text := range unsaved {
+		if stored, ok := saved[id]; !ok || stored != text {
+			next[id] = text
+		}
+	}
+	return next
+}
+
+func lastUserMessage(messages []MessageView) MessageView {
+	for index := len(messages) - 1; index >= 0; index-- {
+		if messages[index].Role == "user" {
+			return messages[index]
 		}
+	}
+	return MessageView{}
+}
+
+func truncateText(value string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(value) <= maxBytes {
+		return value
+	}
+	if maxBytes <= 3 {
+		return value[:maxBytes]
+	}
+	return value[:maxBytes-3] + "..."
+}
 
-		onStartRename := func(chat chatsvc.Chat) {
-			if activeRunID.Get() != "" {
-				return
-			}
-			editingChatID.Set(chat.ID)
-			renameTitle.Set(chat.Title)
-			errorText.Set("")
-		}
+func attachmentViews(rows []chatsvc.Attachment) []AttachmentView {
+	views := make([]AttachmentView, 0, len(rows))
+	for _, row := range rows {
+		views = append(views, AttachmentView{ID: row.ID, FileName: row.FileName, MediaType: row.MediaType, SizeBytes: row.SizeBytes})
+	}
+	return views
+}
 
-		onCancelRename := func() {
-			editingChatID.Set("")
-			renameTitle.Set("")
+func removeAttachmentView(attachments []AttachmentView, attachmentID string) []AttachmentView {
+	next := make([]AttachmentView, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.ID != attachmentID {
+			next = append(next, attachment)
 		}
+	}
+	return next
+}
 
-		onSaveRename := func(chatID string) {
-			if activeRunID.Get() != "" {
-				return
-			}
-			renameChatAction.Run(renameChatRequest{
-				ChatID: chatID,
-				Title:  renameTitle.Get(),
-			})
-		}
+// renderAttachmentChips lists attachments by name. onRemove is nil for sent
+// messages, whose attachments can no longer be removed.
+func renderAttachmentChips(attachments []AttachmentView, tr i18n.Localizer, palette themePalette, onRemove func(string)) *vango.VNode {
+	if len(attachments) == 0 {
+		return nil
+	}
+	return Div(Class("flex flex-wrap gap-2 mb-2"),
+		RangeKeyed(attachments,
+			func(attachment AttachmentView) any { return attachment.ID },
+			func(attachment AttachmentView) *vango.VNode {
+				var removeNode *vango.VNode
+				if onRemove != nil {
+					attachmentID := attachment.ID
+					removeNode = Button(
+						Class("ml-1 opacity-70 hover:opacity-100"),
+						Attr("aria-label", tr.T("common.remove_named", attachment.FileName)),
+						OnClick(func() { onRemove(attachmentID) }),
+						Text("×"),
+					)
+				}
+				icon := "🖼"
+				if chatsvc.IsDocumentType(attachment.MediaType) {
+					icon = "📄"
+				}
+				return Span(Class("inline-flex items-center rounded-md border px-2 py-0.5 text-xs "+palette.ChatMeta),
+					Text(fmt.Sprintf("%s %s (%s)", icon, attachment.FileName, formatBytes(attachment.SizeBytes))),
+					removeNode,
+				)
+			},
+		),
+	)
+}
 
-		onDeleteChat := func(chatID string) {
-			if activeRunID.Get() != "" {
-				return
-			}
-			deleteChatAction.Run(chatID)
-		}
+// renderAttachButton mounts the upload island. It posts the file to
+// /api/attachments and then writes into the hidden sink input, whose input
+// event tells the session to reload the pending attachments.
+func renderAttachButton(chatID string, running bool, tr i18n.Localizer, palette themePalette, onUploaded func()) *vango.VNode {
+	return Div(Class("flex flex-col"),
+		Div(
+			Class("attachment-upload"),
+			Data("module", "/js/islands/attachment-upload.js"),
+			JSIsland("attachment-upload", map[string]any{
+				"chatId":   chatID,
+				"endpoint": "/api/attachments",
+				"sinkId":   "attachment-sink",
+				"disabled": running || chatID == "",
+				"labels":   uploadLabels(tr),
+			}),
+			IslandPlaceholder(
+				Button(Class("rounded-md px-3 py-2 text-sm "+palette.ChatMeta), Disabled(true), Text("📎")),
+			),
+		),
+		Input(
+			Class("hidden"),
+			ID("attachment-sink"),
+			Type("text"),
+			Attr("aria-hidden", "true"),
+			Attr("tabindex", "-1"),
+			OnInput(func(string) {
+				onUploaded()
+			}),
+		),
+	)
+}
 
-		onToggleTheme := func() {
-			if themeMode.Get() == "dark" {
-				themeMode.Set("light")
-				return
-			}
-			themeMode.Set("dark")
-		}
+// uploadLabels are the attachment-upload island's own strings.
+func uploadLabels(tr i18n.Localizer) map[string]string {
+	return tr.Messages("upload.attach_label", "upload.attach_title", "upload.uploading", "upload.failed")
+}
 
-		return func() *vango.VNode {
-			chatList := chats.Get()
-			messageList := messages.Get()
-			activeChat := activeChatID.Get()
-			running := activeRunID.Get() != ""
-			thinking := isThinking.Get()
-			selected := selectedModel.Get()
-			errorMessage := errorText.Get()
-			allowedModels := chatService.AllowedModels()
-			palette := paletteFor(themeMode.Get())
-			themeLabel := "Dark"
-			if themeMode.Get() == "dark" {
-				themeLabel = "Light"
-			}
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.0f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
 
-			var errorNode *vango.VNode
-			if errorMessage != "" {
-				errorNode = Div(Class("mb-2 text-sm "+palette.ErrorText), Text(errorMessage))
-			}
+func renderMessageContent(message MessageView, theme string, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if message.Role != "assistant" {
+		return Div(Text(message.Content))
+	}
 
-			return Div(Class("h-screen chat-shell "+palette.AppRoot),
-				Div(Class("h-full flex"),
-					Aside(Class("w-80 flex flex-col "+palette.Sidebar),
-						Div(Class("p-4 "+palette.SidebarSection),
-							Button(
-								Class("w-full rounded-md px-3 py-2 text-sm font-medium transition-colors "+palette.NewChatButton),
-								OnClick(onNewChat),
-								Disabled(running),
-								Text("New Chat"),
-							),
-						),
-						Div(Class("flex-1 overflow-y-auto p-2 space-y-2"),
-							RangeKeyed(chatList,
-								func(chat chatsvc.Chat) any { return chat.ID },
-								func(chat chatsvc.Chat) *vango.VNode {
-									buttonClass := palette.ChatButtonBase + " " + palette.ChatButtonIdle
-									if chat.ID == activeChat {
-										buttonClass = palette.ChatButtonBase + " " + palette.ChatButtonActive
-									}
-									isEditing := editingChatID.Get() == chat.ID
-									if isEditing {
-										return Div(Class(buttonClass+" space-y-2"),
-											Input(
-												Class("w-full rounded-md px-2 py-1 text-sm "+palette.ChatInput),
-												Value(renameTitle.Get()),
-												OnInput(func(value string) {
-													renameTitle.Set(value)
-												}),
-											),
-											Div(Class("flex gap-2"),
-												Button(
-													Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
-													OnClick(func() {
-														onSaveRename(chat.ID)
-													}),
-													Disabled(running || strings.TrimSpace(renameTitle.Get()) == ""),
-													Text("Save"),
-												),
-												Button(
-													Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
-													OnClick(onCancelRename),
-													Disabled(running),
-													Text("Cancel"),
-												),
-											),
-										)
-									}
-									return Div(Class(buttonClass),
-										Button(
-											Class("w-full text-left"),
-											OnClick(func() {
-												activeChatID.Set(chat.ID)
-												if chatService.IsAllowedModel(chat.Model) {
-													selectedModel.Set(chat.Model)
-												}
-											}),
-											Div(Class("truncate font-medium"), Text(chat.Title)),
-											Div(Class("text-xs truncate mt-1 "+palette.ChatMeta), Text(chat.Model)),
-										),
-										Div(Class("mt-2 flex gap-2"),
-											Button(
-												Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
-												OnClick(func() {
-													onStartRename(chat)
-												}),
-												Disabled(running),
-												Text("Rename"),
-											),
-											Button(
-												Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
-												OnClick(func() {
-													onDeleteChat(chat.ID)
-												}),
-												Disabled(running),
-												Text("Delete"),
-											),
-										),
-									)
-								},
-							),
-						),
+	islandID := "md-" + message.ID
+	return Div(
+		Class("md-renderer-host"),
+		Data("module", "/js/islands/markdown-renderer.js"),
+		JSIsland(islandID, map[string]any{
+			"markdown":    message.Content,
+			"theme":       theme,
+			"copyable":    message.Status != "streaming",
+			"rawEndpoint": "/api/messages?id=" + url.QueryEscape(message.ID),
+			"labels": tr.Messages("message.copy", "message.copy_message", "message.copy_message_label",
+				"message.copy_code_label", "message.copied", "message.copy_failed"),
+		}),
+		IslandPlaceholder(
+			Div(Class("md-renderer "+palette.ToolText), Text(message.Content)),
+		),
+	)
+}
+
+// renderToolCall shows a tool call collapsed to its name and status. The
+// streamed input and output are previews; "View full" loads what was
+// stored.
+func renderToolCall(call ToolCallView, tr i18n.Localizer, palette themePalette, onViewFull func()) *vango.VNode {
+	var inputNode *vango.VNode
+	var outputNode *vango.VNode
+	var errNode *vango.VNode
+	if call.Input != "" {
+		inputNode = renderToolPayload(tr.T("tool.input"), call.Input, palette)
+	}
+	if call.Output != "" {
+		outputNode = renderToolPayload(tr.T("tool.output"), call.Output, palette)
+	}
+	if call.ErrText != "" {
+		errNode = Div(Class("whitespace-pre-wrap "+palette.ToolErrorText), Text(tr.T("tool.error", call.ErrText)))
+	}
+	return Details(Class("mt-2 rounded-md border p-2 text-xs "+palette.ToolCard),
+		Summary(Class("cursor-pointer font-semibold"), Text(tr.T("tool.summary", call.Name, call.Status))),
+		Div(Class("mt-1 space-y-1"),
+			inputNode,
+			outputNode,
+			errNode,
+			If(call.Status != "running",
+				Button(Class("rounded-md px-2 py-0.5 "+palette.ChatActionButton), OnClick(onViewFull), Text(tr.T("tool.view_full"))),
+			),
+		),
+	)
+}
+
+func renderToolPayload(label, payload string, palette themePalette) *vango.VNode {
+	return Div(
+		Div(Class("font-semibold "+palette.ChatMeta), Text(label)),
+		Pre(Class("mt-0.5 max-h-48 overflow-auto whitespace-pre-wrap break-all "+palette.ToolText), Text(chatsvc.PrettyJSON(payload))),
+	)
+}
+
+// renderToolCallDialog shows a stored tool call over the chat, with a link
+// to the same data as JSON.
+func renderToolCallDialog(call chatsvc.ToolCallDetail, tr i18n.Localizer, palette themePalette, onClose func()) *vango.VNode {
+	var errNode *vango.VNode
+	if call.Error != "" {
+		errNode = Div(Class("whitespace-pre-wrap "+palette.ToolErrorText), Text(tr.T("tool.error", call.Error)))
+	}
+	return Div(Class("fixed inset-0 z-50 flex items-center justify-center bg-black/50 p-4"),
+		Attr("role", "dialog"),
+		Attr("aria-modal", "true"),
+		Div(Class("flex max-h-full w-full max-w-3xl flex-col gap-2 overflow-y-auto rounded-lg p-4 text-xs "+palette.FindBar),
+			Div(Class("flex items-center gap-3"),
+				Div(Class("flex-1 font-semibold "+palette.ChatMeta),
+					Text(tr.T("tool.detail_summary", call.Name, call.Status, call.StartedAt.Local().Format(tr.T("time.timestamp")))),
+				),
+				A(Class("underline "+palette.ChatMeta), Href("/api/toolcalls?id="+url.QueryEscape(call.ID)), Attr("target", "_blank"), Text(tr.T("tool.raw"))),
+				Button(Class("rounded-md px-2 py-0.5 "+palette.ChatActionButton), OnClick(onClose), Text(tr.T("common.close"))),
+			),
+			renderToolPayload(tr.T("tool.input"), call.Input, palette),
+			renderToolPayload(tr.T("tool.output"), call.Output, palette),
+			errNode,
+		),
+	)
+}
+
+func renderParamInput(label, placeholder, value string, palette themePalette, onInput func(string)) *vango.VNode {
+	return Div(Class("flex flex-col gap-1"),
+		Span(Class("text-xs "+palette.ChatMeta), Text(label)),
+		Input(
+			Class("w-28 rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+			Type("text"),
+			Placeholder(placeholder),
+			Value(value),
+			OnInput(onInput),
+		),
+	)
+}
+
+func renderToolsPanel(tools []chatsvc.ChatTool, running bool, tr i18n.Localizer, palette themePalette, onSet func(string, bool)) *vango.VNode {
+	if len(tools) == 0 {
+		return Div(Class("px-4 py-3 text-xs "+palette.FindBar+" "+palette.ChatMeta), Text(tr.T("tools.none")))
+	}
+	return Div(Class("px-4 py-3 flex flex-col gap-2 "+palette.FindBar),
+		RangeKeyed(tools,
+			func(tool chatsvc.ChatTool) any { return tool.Name },
+			func(tool chatsvc.ChatTool) *vango.VNode {
+				label := tr.T("tools.off")
+				buttonClass := palette.ChatActionButton
+				if tool.Enabled {
+					label = tr.T("tools.on")
+					buttonClass = palette.ChatSaveButton
+				}
+				return Div(Class("flex items-center gap-3"),
+					Button(
+						Class("w-12 rounded-md px-2 py-1 text-xs disabled:opacity-50 "+buttonClass),
+						OnClick(func() {
+							onSet(tool.Name, !tool.Enabled)
+						}),
+						Disabled(running),
+						Attr("aria-pressed", strconv.FormatBool(tool.Enabled)),
+						Text(label),
 					),
-					Div(Class("flex-1 flex flex-col min-w-0"),
-						Div(Class("h-16 px-4 flex items-center justify-between gap-3 "+palette.Header),
-							Div(Class("text-sm truncate "+palette.HeaderTitle), Text(fmt.Sprintf("Chat: %s", truncateText(activeChat, 8)))),
-							Div(Class("flex items-center gap-2"),
-								Select(
-									Class("rounded-md px-2 py-1 text-sm "+palette.ModelSelect),
-									Value(selected),
-									OnInput(func(value string) {
-										if chatService.IsAllowedModel(value) {
-											selectedModel.Set(value)
-										}
-									}),
-									RangeKeyed(allowedModels,
-										func(model string) any { return model },
-										func(model string) *vango.VNode {
-											return Option(Value(model), Text(model))
-										},
-									),
-								),
-								Button(
-									Class("rounded-md px-3 py-1.5 text-sm border transition-colors "+palette.ThemeToggle),
-									OnClick(onToggleTheme),
-									Text(themeLabel),
-								),
-								Button(
-									Class("rounded-md px-3 py-1.5 text-sm border disabled:opacity-50 "+palette.StopButton),
-									OnClick(onStop),
-									Disabled(!running),
-									Text("Stop"),
-								),
-							),
-						),
-						Div(Class("flex-1 overflow-y-auto p-4 space-y-4 "+palette.ChatBody),
-							RangeKeyed(messageList,
-								func(message MessageView) any { return message.ID },
-								func(message MessageView) *vango.VNode {
-									bubbleClass := "rounded-lg px-4 py-3 max-w-3xl whitespace-pre-wrap border"
-									containerClass := "flex"
-									if message.Role == "user" {
-										containerClass += " justify-end"
-										bubbleClass += " " + palette.UserBubble
-									} else {
-										containerClass += " justify-start"
-										bubbleClass += " " + palette.AssistantBubble
-									}
+					Div(Class("min-w-0"),
+						Div(Class("text-sm font-medium"), Text(tool.Name)),
+						Div(Class("text-xs truncate "+palette.ChatMeta), Text(tool.Description)),
+					),
+				)
+			},
+		),
+		A(Class("text-xs underline "+palette.ChatMeta), Href(RouteTools), Attr("target", "_blank"), Text(tr.T("tools.catalog"))),
+	)
+}
 
-									statusBadge := ""
-									if message.Status == "streaming" {
-										statusBadge = "Streaming"
-									}
-									if message.Status == "error" {
-										statusBadge = "Error"
-									}
-									if message.Status == "cancelled" {
-										statusBadge = "Cancelled"
-									}
+func setChatToolEnabled(tools []chatsvc.ChatTool, name string, enabled bool) []chatsvc.ChatTool {
+	next := make([]chatsvc.ChatTool, len(tools))
+	copy(next, tools)
+	for index := range next {
+		if next[index].Name == name {
+			next[index].Enabled = enabled
+			break
+		}
+	}
+	return next
+}
+
+// renderKnowledgePanel lists the chat's knowledge base with an upload
+// button. Uploads go through the attachment island against /api/knowledge.
+// renderInfoPanel shows the chat's model and age, and what it has spent
+// against its optional spend ceiling. When retention is on it also offers
+// to keep the chat forever.
+func renderInfoPanel(chat chatsvc.Chat, spend chatsvc.ChatSpend, ceilingInput string, retention bool, tr i18n.Localizer, palette themePalette, onCeilingInput func(string), onSaveCeiling func(), onToggleKeepForever func()) *vango.VNode {
+	spent := tr.T("info.spent", chatsvc.FormatUSD(spend.SpentUSD))
+	if spend.Limited() {
+		spent = tr.T("info.spent_of", chatsvc.FormatUSD(spend.SpentUSD), chatsvc.FormatUSD(spend.CeilingUSD))
+	}
+	spentClass := "text-sm"
+	if spend.Exceeded {
+		spent += " · " + tr.T("info.ceiling_reached")
+		spentClass += " " + palette.ToolErrorText
+	}
+	return Div(Class("px-4 py-3 flex flex-col gap-2 "+palette.FindBar),
+		Div(Class("text-xs "+palette.ChatMeta), Text(tr.T("info.model_created", chat.Model, chat.CreatedAt.Local().Format(tr.T("time.created"))))),
+		Div(Class(spentClass), Text(spent)),
+		Div(Class("flex flex-wrap items-end gap-3"),
+			renderParamInput(tr.T("info.ceiling_label"), tr.T("info.ceiling_placeholder"), ceilingInput, palette, onCeilingInput),
+			Button(
+				Class("rounded-md px-3 py-1 text-sm "+palette.ChatSaveButton),
+				OnClick(onSaveCeiling),
+				Text(tr.T("common.save")),
+			),
+			Span(Class("text-xs "+palette.ChatMeta), Text(tr.T("info.ceiling_hint"))),
+		),
+		If(retention, renderKeepForever(chat.KeepForever, tr, palette, onToggleKeepForever)),
+	)
+}
+
+func renderKeepForever(keep bool, tr i18n.Localizer, palette themePalette, onToggle func()) *vango.VNode {
+	label := tr.T("info.keep_forever")
+	if keep {
+		label = tr.T("info.kept_forever")
+	}
+	return Div(Class("flex flex-wrap items-center gap-3"),
+		Button(
+			Class("rounded-md px-3 py-1 text-sm border transition-colors "+palette.ThemeToggle),
+			OnClick(onToggle),
+			Attr("aria-pressed", strconv.FormatBool(keep)),
+			Text(label),
+		),
+		Span(Class("text-xs "+palette.ChatMeta), Text(tr.T("info.keep_forever_hint"))),
+	)
+}
+
+// renderContextWarning warns that the draft alone is too long for the
+// model, so sending it would be refused.
+func renderContextWarning(usage chatsvc.ContextUsage, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if usage.Fits() {
+		return nil
+	}
+	return Div(Class("mb-2 text-xs "+palette.ErrorText), Text(tr.T("composer.too_long", usage.Model, usage.PromptTokens, usage.ContextWindow, usage.ReserveTokens)))
+}
+
+// renderPreviewPanel shows the request a draft would send: the system
+// prompt, the history that fits, the tools and the raw provider request.
+func renderPreviewPanel(preview chatsvc.RunPreview, tr i18n.Localizer, palette themePalette, onClose func()) *vango.VNode {
+	tools := tr.T("preview.no_tools")
+	if len(preview.Tools) > 0 {
+		tools = strings.Join(preview.Tools, ", ")
+	}
+	return Div(Class("px-4 py-3 flex flex-col gap-2 max-h-96 overflow-y-auto text-xs "+palette.FindBar),
+		Div(Class("flex items-center gap-3"),
+			Div(Class("flex-1 font-semibold "+palette.ChatMeta),
+				Text(tr.T("preview.summary", preview.Model, preview.ProviderModel, preview.EstimatedInputTokens, tools)),
+			),
+			Button(Class("rounded-md px-2 py-0.5 "+palette.ChatActionButton), OnClick(onClose), Text(tr.T("common.close"))),
+		),
+		Div(Class(palette.ChatMeta), Text(tr.T("preview.context", preview.Context.PromptTokens, preview.Context.ContextWindow, preview.Context.ReserveTokens))),
+		If(!preview.Context.Fits(), Div(Class(palette.ErrorText), Text(tr.T("preview.overflow")))),
+		Details(
+			Summary(Class("cursor-pointer "+palette.ChatMeta), Text(tr.T("preview.system"))),
+			Pre(Class("mt-1 whitespace-pre-wrap "+palette.ToolText), Text(preview.System)),
+		),
+		Div(Class("space-y-1"),
+			RangeKeyed(previewMessageRows(preview.Messages),
+				func(row previewMessageRow) any { return row.Index },
+				func(row previewMessageRow) *vango.VNode {
+					label := tr.T("preview.message", row.Message.Role, row.Message.EstimatedTokens)
+					if row.Message.Images > 0 {
+						label += " · " + tr.T("preview.images", row.Message.Images)
+					}
+					return Div(Class("rounded-md border p-2 "+palette.ToolCard),
+						Div(Class("font-semibold"), Text(label)),
+						Div(Class("whitespace-pre-wrap "+palette.ToolText), Text(row.Message.Content)),
+					)
+				},
+			),
+		),
+		Details(
+			Summary(Class("cursor-pointer "+palette.ChatMeta), Text(tr.T("preview.request"))),
+			Pre(Class("mt-1 overflow-x-auto "+palette.ToolText), Text(string(preview.Request))),
+		),
+	)
+}
+
+// previewMessageRow keys preview messages by position, since they have no
+// IDs and the same text can repeat.
+type previewMessageRow struct {
+	Index   int
+	Message chatsvc.PreviewMessage
+}
+
+func previewMessageRows(messages []chatsvc.PreviewMessage) []previewMessageRow {
+	rows := make([]previewMessageRow, len(messages))
+	for i, message := range messages {
+		rows[i] = previewMessageRow{Index: i, Message: message}
+	}
+	return rows
+}
+
+// renderScheduledPrompts lists the chat's recurring prompts and a form to
+// add one. Answers land in the chat like any other run.
+func renderScheduledPrompts(prompts []chatsvc.ScheduledPrompt, draft scheduledPromptRequest, tr i18n.Localizer, palette themePalette, onDraft func(scheduledPromptRequest), onSubmit func(scheduledPromptRequest)) *vango.VNode {
+	return Div(Class("px-4 py-3 flex flex-col gap-2 "+palette.FindBar),
+		Div(Class("text-xs font-semibold "+palette.ChatMeta), Text(tr.T("schedule.title"))),
+		RangeKeyed(prompts,
+			func(prompt chatsvc.ScheduledPrompt) any { return prompt.ID },
+			func(prompt chatsvc.ScheduledPrompt) *vango.VNode {
+				next := tr.T("schedule.next", prompt.NextRunAt.Local().Format(tr.T("time.next_run")))
+				if prompt.LastStatus != "" {
+					next += " · " + tr.T("schedule.last_run", prompt.LastStatus)
+				}
+				return Div(Class("flex items-center gap-3"),
+					Div(Class("min-w-0 flex-1"),
+						Div(Class("text-sm truncate"), Text(prompt.Prompt)),
+						Div(Class("text-xs truncate "+palette.ChatMeta), Text(chatsvc.DescribePromptSchedule(prompt)+" · "+next)),
+						If(prompt.LastError != "", Div(Class("text-xs truncate "+palette.ToolErrorText), Text(prompt.LastError))),
+					),
+					Button(
+						Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+						OnClick(func() {
+							onSubmit(scheduledPromptRequest{ChatID: draft.ChatID, PromptID: prompt.ID})
+						}),
+						Text(tr.T("common.remove")),
+					),
+				)
+			},
+		),
+		Div(Class("flex flex-wrap items-end gap-2"),
+			Input(
+				Class("min-w-0 flex-1 rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+				Placeholder(tr.T("schedule.placeholder")),
+				Value(draft.Prompt),
+				OnInput(func(value string) {
+					next := draft
+					next.Prompt = value
+					onDraft(next)
+				}),
+			),
+			Select(
+				Class("rounded-md px-2 py-1 text-sm "+palette.ModelSelect),
+				Value(draft.Frequency),
+				OnInput(func(value string) {
+					next := draft
+					next.Frequency = value
+					onDraft(next)
+				}),
+				RangeKeyed(chatsvc.PromptFrequencies,
+					func(frequency string) any { return frequency },
+					func(frequency string) *vango.VNode {
+						return Option(Value(frequency), Text(chatsvc.PromptFrequencyLabel(frequency)))
+					},
+				),
+			),
+			Input(
+				Class("w-20 rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+				Placeholder("08:00"),
+				Value(draft.TimeOfDay),
+				OnInput(func(value string) {
+					next := draft
+					next.TimeOfDay = value
+					onDraft(next)
+				}),
+			),
+			Button(
+				Class("rounded-md px-3 py-1 text-sm "+palette.ChatSaveButton),
+				OnClick(func() {
+					onSubmit(draft)
+				}),
+				Disabled(strings.TrimSpace(draft.Prompt) == ""),
+				Text(tr.T("schedule.submit")),
+			),
+		),
+	)
+}
 
-									if message.Role == "assistant" && message.Content == "" && thinking {
-										return Div(Class(containerClass),
-											Div(Class(bubbleClass),
-												Div(Class("text-sm "+palette.ThinkingText), Text("Thinking...")),
-											),
-										)
-									}
+func formatSpendCeiling(spend chatsvc.ChatSpend) string {
+	if !spend.Limited() {
+		return ""
+	}
+	return strconv.FormatFloat(spend.CeilingUSD, 'f', -1, 64)
+}
 
-									return Div(Class(containerClass),
-										Div(Class(bubbleClass),
-											Div(
-												Class("text-[10px] mb-2 "+palette.StatusText),
-												Attr("aria-hidden", "true"),
-												If(statusBadge != "", Text(statusBadge)),
-											),
-											renderMessageContent(message, themeMode.Get(), palette),
-											RangeKeyed(message.ToolCalls,
-												func(call ToolCallView) any { return call.ID },
-												func(call ToolCallView) *vango.VNode {
-													var inputNode *vango.VNode
-													var outputNode *vango.VNode
-													var errNode *vango.VNode
-													if call.Output != "" {
-														outputNode = Div(Class(palette.ToolText), Text("Output: "+call.Output))
-													}
-													if call.ErrText != "" {
-														errNode = Div(Class(palette.ToolErrorText), Text("Error: "+call.ErrText))
-													}
-													if call.Input != "" {
-														inputNode = Div(Class(palette.ToolText), Text("Input: "+call.Input))
-													}
-													return Div(Class("mt-2 rounded-md border p-2 text-xs space-y-1 "+palette.ToolCard),
-														Div(Class("font-semibold"), Text(fmt.Sprintf("Tool: %s (%s)", call.Name, call.Status))),
-														inputNode,
-														outputNode,
-														errNode,
-													)
-												},
-											),
-										),
-									)
-								},
-							),
-						),
-						Div(Class("p-4 "+palette.Composer),
-							errorNode,
-							Div(Class("flex items-end gap-2"),
-								Textarea(
-									Class("flex-1 min-h-24 max-h-60 rounded-md px-3 py-2 text-sm resize-y "+palette.Input),
-									Placeholder("Ask anything..."),
-									Value(inputText.Get()),
-									OnInput(func(value string) {
-										inputText.Set(value)
-									}),
-								),
-								Button(
-									Class("rounded-md px-4 py-2 text-sm font-semibold disabled:opacity-50 "+palette.SendButton),
-									OnClick(onSend),
-									Disabled(running || strings.TrimSpace(inputText.Get()) == ""),
-									Text("Send"),
-								),
-							),
-						),
-					),
+func renderKnowledgePanel(documents []KnowledgeView, chatID string, tr i18n.Localizer, palette themePalette, onRemove func(string), onUploaded func()) *vango.VNode {
+	return Div(Class("px-4 py-3 flex flex-col gap-2 "+palette.FindBar),
+		Div(Class("flex items-center gap-3"),
+			Div(
+				Class("knowledge-upload"),
+				Data("module", "/js/islands/attachment-upload.js"),
+				JSIsland("knowledge-upload", map[string]any{
+					"chatId":   chatID,
+					"endpoint": "/api/knowledge",
+					"sinkId":   "knowledge-sink",
+					"accept":   "application/pdf,text/plain,.md,.csv,.json,.txt",
+					"label":    tr.T("knowledge.add"),
+					"title":    tr.T("knowledge.add_title"),
+					"disabled": chatID == "",
+					"labels":   uploadLabels(tr),
+				}),
+				IslandPlaceholder(
+					Button(Class("rounded-md px-3 py-1 text-sm "+palette.ChatMeta), Disabled(true), Text(tr.T("knowledge.add"))),
 				),
-			)
-		}
-	})
+			),
+			Input(
+				Class("hidden"),
+				ID("knowledge-sink"),
+				Type("text"),
+				Attr("aria-hidden", "true"),
+				Attr("tabindex", "-1"),
+				OnInput(func(string) {
+					onUploaded()
+				}),
+			),
+			Span(Class("text-xs "+palette.ChatMeta), Text(tr.T("knowledge.hint"))),
+		),
+		If(len(documents) == 0, Div(Class("text-xs "+palette.ChatMeta), Text(tr.T("knowledge.empty")))),
+		RangeKeyed(documents,
+			func(document KnowledgeView) any { return document.ID },
+			func(document KnowledgeView) *vango.VNode {
+				detail := tr.T("knowledge.detail", formatBytes(document.SizeBytes), document.ChunkCount)
+				if !document.Searchable {
+					detail += " · " + tr.T("knowledge.not_searchable")
+				}
+				return Div(Class("flex items-center gap-3"),
+					Button(
+						Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+						OnClick(func() {
+							onRemove(document.ID)
+						}),
+						Attr("aria-label", tr.T("common.remove_named", document.FileName)),
+						Text(tr.T("common.remove")),
+					),
+					Div(Class("min-w-0"),
+						Div(Class("text-sm font-medium truncate"), Text("📄 "+document.FileName)),
+						Div(Class("text-xs "+palette.ChatMeta), Text(detail)),
+					),
+				)
+			},
+		),
+	)
 }
 
-func containsChat(chats []chatsvc.Chat, chatID string) bool {
-	for _, chat := range chats {
-		if chat.ID == chatID {
-			return true
+func renderReasoningSelect(value string, efforts []string, tr i18n.Localizer, palette themePalette, onInput func(string)) *vango.VNode {
+	return Div(Class("flex flex-col gap-1"),
+		Span(Class("text-xs "+palette.ChatMeta), Text(tr.T("params.reasoning"))),
+		Select(
+			Class("rounded-md px-2 py-1 text-sm "+palette.ModelSelect),
+			Value(value),
+			OnInput(onInput),
+			Option(Value(""), Text(tr.T("params.default"))),
+			RangeKeyed(efforts,
+				func(effort string) any { return effort },
+				func(effort string) *vango.VNode {
+					return Option(Value(effort), Text(effort))
+				},
+			),
+		),
+	)
+}
+
+func renderFindBar(query string, matchIDs []string, index int, loaded []MessageView, tr i18n.Localizer, palette themePalette, onInput func(string), onStep func(int)) *vango.VNode {
+	summary := ""
+	if strings.TrimSpace(query) != "" {
+		summary = tr.T("find.no_matches")
+		if len(matchIDs) > 0 {
+			summary = tr.T("find.position", index%len(matchIDs)+1, len(matchIDs))
+			if unloaded := countUnloaded(matchIDs, loaded); unloaded > 0 {
+				summary += " " + tr.T("find.unloaded", unloaded)
+			}
 		}
 	}
-	return false
+	return Div(Class("px-4 py-2 flex items-center gap-2 "+palette.FindBar),
+		Input(
+			Class("flex-1 rounded-md px-2 py-1 text-sm "+palette.ChatInput),
+			ID(findInputID),
+			Type("search"),
+			Placeholder(tr.T("find.placeholder")),
+			Attr("aria-keyshortcuts", "Control+K"),
+			Value(query),
+			OnInput(onInput),
+		),
+		Span(Class("text-xs tabular-nums "+palette.ChatMeta), Text(summary)),
+		Button(
+			Class("rounded-md px-2 py-1 text-xs disabled:opacity-50 "+palette.ChatActionButton),
+			OnClick(func() {
+				onStep(-1)
+			}),
+			Disabled(len(matchIDs) == 0),
+			Text(tr.T("find.prev")),
+		),
+		Button(
+			Class("rounded-md px-2 py-1 text-xs disabled:opacity-50 "+palette.ChatActionButton),
+			OnClick(func() {
+				onStep(1)
+			}),
+			Disabled(len(matchIDs) == 0),
+			Text(tr.T("find.next")),
+		),
+	)
 }
 
-func findChatByID(chats []chatsvc.Chat, chatID string) chatsvc.Chat {
-	for _, chat := range chats {
-		if chat.ID == chatID {
-			return chat
-		}
+const chatBodyID = "chat-body"
+
+// renderChatScroll mounts the island that keeps the message list pinned to
+// the bottom while a reply streams. The revision changes with every
+// streamed chunk and the follow key with every message the user sends.
+func renderChatScroll(chatID string, messages []MessageView, tr i18n.Localizer) *vango.VNode {
+	revision := ""
+	followKey := ""
+	if len(messages) > 0 {
+		last := messages[len(messages)-1]
+		revision = fmt.Sprintf("%s:%d:%d:%d:%s", last.ID, len(last.Content), len(last.Reasoning), len(last.ToolCalls), last.Status)
+		followKey = lastUserMessage(messages).ID
 	}
-	return chatsvc.Chat{}
+	return Div(
+		Class("chat-scroll sticky bottom-0 flex justify-center pointer-events-none"),
+		Data("module", "/js/islands/chat-scroll.js"),
+		JSIsland("chat-scroll", map[string]any{
+			"containerId": chatBodyID,
+			"chatId":      chatID,
+			"revision":    revision,
+			"followKey":   followKey,
+			"labels":      tr.Messages("message.jump_to_latest"),
+		}),
+		IslandPlaceholder(Span()),
+	)
 }
 
-func updateChatTitle(chats []chatsvc.Chat, chatID, title string) []chatsvc.Chat {
-	next := make([]chatsvc.Chat, len(chats))
-	copy(next, chats)
-	for index := range next {
-		if next[index].ID != chatID {
-			continue
-		}
-		next[index].Title = title
-		next[index].UpdatedAt = time.Now().UTC()
-		break
+func renderFindJump(messageID string) *vango.VNode {
+	if messageID == "" {
+		return nil
 	}
-	return next
+	return Div(
+		Class("hidden"),
+		Data("module", "/js/islands/scroll-into-view.js"),
+		JSIsland("find-jump", map[string]any{
+			"targetId": "msg-" + messageID,
+		}),
+	)
 }
 
-func removeChatByID(chats []chatsvc.Chat, chatID string) []chatsvc.Chat {
-	next := make([]chatsvc.Chat, 0, len(chats))
-	for _, chat := range chats {
-		if chat.ID == chatID {
-			continue
+// messageWindow is how many of the latest messages a chat renders at first
+// and how many more each "Show earlier" adds. Rendering every message on
+// each streamed chunk made long chats sluggish.
+const messageWindow = 60
+
+// messageWindowStart returns the index of the first message to render: the
+// last visible messages, reaching back further when pinnedID, the current
+// find match, is older.
+func messageWindowStart(messages []MessageView, visible int, pinnedID string) int {
+	start := max(len(messages)-visible, 0)
+	if pinnedID == "" {
+		return start
+	}
+	for index, message := range messages[:start] {
+		if message.ID == pinnedID {
+			return index
 		}
-		next = append(next, chat)
 	}
-	return next
+	return start
 }
 
-func appendAssistantChunk(messages []MessageView, assistantMessageID, chunk string) []MessageView {
-	next := make([]MessageView, len(messages))
-	copy(next, messages)
-	for index := range next {
-		if next[index].ID != assistantMessageID {
-			continue
-		}
-		next[index].Content += chunk
-		next[index].Status = "streaming"
-		break
+func renderEarlierMessages(hidden int, tr i18n.Localizer, palette themePalette, onShowMore func()) *vango.VNode {
+	if hidden <= 0 {
+		return nil
 	}
-	return next
+	return Div(Class("flex justify-center"),
+		Button(
+			Class("rounded-md px-3 py-1 text-xs "+palette.ChatActionButton),
+			OnClick(onShowMore),
+			Text(tr.T("message.show_earlier", hidden)),
+		),
+	)
 }
 
-func markAssistantStatus(messages []MessageView, assistantMessageID, status string) []MessageView {
-	next := make([]MessageView, len(messages))
-	copy(next, messages)
-	for index := range next {
-		if next[index].ID != assistantMessageID {
-			continue
+func countUnloaded(ids []string, loaded []MessageView) int {
+	present := make(map[string]bool, len(loaded))
+	for _, message := range loaded {
+		present[message.ID] = true
+	}
+	missing := 0
+	for _, id := range ids {
+		if !present[id] {
+			missing++
 		}
-		next[index].Status = status
-		break
 	}
-	return next
+	return missing
 }
 
-func setAssistantError(messages []MessageView, assistantMessageID, errMessage string) []MessageView {
-	next := make([]MessageView, len(messages))
-	copy(next, messages)
-	for index := range next {
-		if next[index].ID != assistantMessageID {
-			continue
-		}
-		next[index].Status = "error"
-		if strings.TrimSpace(errMessage) == "" {
-			errMessage = "Assistant request failed."
-		}
-		if strings.TrimSpace(next[index].Content) == "" {
-			next[index].Content = "Error: " + errMessage
-		}
-		break
+// renderSetupBanner explains which provider keys are missing when no real
+// model can be used, and offers the mock model in the meantime.
+func renderSetupBanner(status chatsvc.SetupStatus, selected string, tr i18n.Localizer, palette themePalette, onUseModel func(string)) *vango.VNode {
+	if !status.NeedsSetup {
+		return nil
 	}
-	return next
+	message := tr.T("setup.no_provider")
+	if len(status.MissingKeys) > 0 {
+		message = tr.T("setup.missing_keys", strings.Join(status.MissingKeys, ", "))
+	}
+	return Div(Class("px-4 py-3 flex flex-wrap items-center gap-3 text-sm "+palette.FindBar),
+		Span(Class(palette.ErrorText), Text(message)),
+		If(status.MockModel != "" && selected != status.MockModel, Button(
+			Class("rounded-md px-3 py-1 text-sm "+palette.ChatSaveButton),
+			OnClick(func() { onUseModel(status.MockModel) }),
+			Text(tr.T("setup.use_mock")),
+		)),
+		If(status.MockModel != "" && selected == status.MockModel, Span(
+			Class("text-xs "+palette.ChatMeta),
+			Text(tr.T("setup.using_mock")),
+		)),
+	)
 }
 
-func addToolCall(messages []MessageView, assistantMessageID string, call ToolCallView) []MessageView {
-	next := make([]MessageView, len(messages))
-	copy(next, messages)
-	for index := range next {
-		if next[index].ID != assistantMessageID {
-			continue
+// renderInterruptedBanner offers to retry when the chat's latest reply was
+// cut off by a server restart.
+func renderInterruptedBanner(messages []MessageView, running bool, tr i18n.Localizer, palette themePalette, onRetry func(MessageView)) *vango.VNode {
+	var last MessageView
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" {
+			last = messages[i]
+			break
 		}
-		calls := append([]ToolCallView{}, next[index].ToolCalls...)
-		calls = append(calls, call)
-		next[index].ToolCalls = calls
-		break
 	}
-	return next
+	if last.Status != "interrupted" {
+		return nil
+	}
+	return Div(Class("px-4 py-3 flex flex-wrap items-center gap-3 text-sm "+palette.FindBar),
+		Span(Class(palette.ErrorText), Text(tr.T("interrupted.banner"))),
+		Button(
+			Class("rounded-md px-3 py-1 text-sm disabled:opacity-50 "+palette.RetryButton),
+			OnClick(func() { onRetry(last) }),
+			Disabled(running),
+			Text(tr.T("common.retry")),
+		),
+	)
 }
 
-func updateToolCall(messages []MessageView, assistantMessageID, callID, status, output, errorText string) []MessageView {
-	next := make([]MessageView, len(messages))
-	copy(next, messages)
-	for messageIndex := range next {
-		if next[messageIndex].ID != assistantMessageID {
-			continue
-		}
-		calls := append([]ToolCallView{}, next[messageIndex].ToolCalls...)
-		for callIndex := range calls {
-			if calls[callIndex].ID != callID {
-				continue
-			}
-			if status != "" {
-				calls[callIndex].Status = status
-			} else {
-				calls[callIndex].Status = "completed"
-			}
-			calls[callIndex].Output = output
-			calls[callIndex].ErrText = errorText
-			next[messageIndex].ToolCalls = calls
-			return next
-		}
-		if status == "" {
-			status = "completed"
-		}
-		calls = append(calls, ToolCallView{ID: callID, Status: status, Output: output, ErrText: errorText})
-		next[messageIndex].ToolCalls = calls
-		return next
+// renderQuotaStatus shows the remaining run allowance under the composer
+// once the user nears a rate limit, and what is left of the tightest usage
+// budget. It refetches /api/quota whenever refreshKey changes, i.e. when a
+// run starts or finishes.
+func renderQuotaStatus(refreshKey string, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	return Div(
+		Class("text-xs "+palette.StatusText),
+		Data("module", "/js/islands/quota-status.js"),
+		JSIsland("quota-status", map[string]any{
+			"endpoint":   "/api/quota",
+			"refreshKey": refreshKey,
+			"labels": tr.Messages("quota.exhausted", "quota.exhausted_until", "quota.left_hour",
+				"quota.left_hour_one", "quota.left_day", "quota.left_day_one",
+				"quota.budget_tokens_day", "quota.budget_tokens_month", "quota.budget_usd_day",
+				"quota.budget_usd_month", "quota.budget_exhausted_day", "quota.budget_exhausted_month"),
+		}),
+		IslandPlaceholder(Span()),
+	)
+}
+
+const (
+	composerInputID = "composer-input"
+	findInputID     = "find-input"
+	renameInputID   = "rename-input"
+)
+
+// keyboardShortcut is what the keyboard-shortcuts island writes to its sink:
+// "send" with the composer text, "stop", "edit-last" to recall the last
+// user message into an empty composer, or "rename" to edit the active
+// chat's title.
+type keyboardShortcut struct {
+	Action string `json:"action"`
+	Text   string `json:"text"`
+}
+
+// renderKeyboardShortcuts mounts the island that handles Enter to send,
+// Shift+Enter for a newline, Esc to stop, Ctrl+K to find in the chat, the
+// up arrow to recall the last message and F2 to rename the chat.
+func renderKeyboardShortcuts(running bool, tr i18n.Localizer, palette themePalette, onShortcut func(keyboardShortcut)) *vango.VNode {
+	return Div(
+		Class("mt-1 flex items-center justify-between text-xs "+palette.ChatMeta),
+		Div(
+			Data("module", "/js/islands/keyboard-shortcuts.js"),
+			JSIsland("keyboard-shortcuts", map[string]any{
+				"composerId": composerInputID,
+				"findId":     findInputID,
+				"renameId":   renameInputID,
+				"sinkId":     "keyboard-sink",
+				"running":    running,
+			}),
+			IslandPlaceholder(Span()),
+		),
+		Span(Text(tr.T("composer.shortcuts"))),
+		Input(
+			Class("hidden"),
+			ID("keyboard-sink"),
+			Type("text"),
+			Attr("aria-hidden", "true"),
+			Attr("tabindex", "-1"),
+			OnInput(func(value string) {
+				var shortcut keyboardShortcut
+				if json.Unmarshal([]byte(value), &shortcut) == nil {
+					onShortcut(shortcut)
+				}
+			}),
+		),
+	)
+}
+
+// renderTimestamp shows when a message was created relative to now, with
+// the absolute time in the user's timezone on hover. The island keeps the
+// relative text current.
+func renderTimestamp(message MessageView, prefs chatsvc.Preferences, tr i18n.Localizer) *vango.VNode {
+	if message.CreatedAt.IsZero() {
+		return nil
 	}
-	return next
+	location := prefs.Location()
+	return Div(
+		Class("tabular-nums whitespace-nowrap"),
+		Data("module", "/js/islands/relative-time.js"),
+		JSIsland("ts-"+message.ID, map[string]any{
+			"at":       message.CreatedAt.UnixMilli(),
+			"timezone": prefs.Timezone,
+			"lang":     tr.Lang(),
+			"labels":   tr.Messages("time.just_now", "time.minutes_ago", "time.hours_ago", "time.days_ago"),
+		}),
+		IslandPlaceholder(
+			Span(
+				Attr("title", tr.AbsoluteTime(message.CreatedAt, location)),
+				Text(tr.RelativeTime(message.CreatedAt, time.Now(), location)),
+			),
+		),
+	)
 }
 
-func truncateText(value string, maxBytes int) string {
-	if maxBytes <= 0 {
-		return ""
+func renderTimezoneSetting(value string, tr i18n.Localizer, palette themePalette, onInput func(string), onSave func()) *vango.VNode {
+	return Div(Class("flex items-center gap-1"),
+		Input(
+			Class("min-w-0 flex-1 rounded-md px-2 py-1 text-xs "+palette.ChatInput),
+			Type("text"),
+			Placeholder(tr.T("settings.timezone_placeholder")),
+			Attr("title", tr.T("settings.timezone_title")),
+			Value(value),
+			OnInput(onInput),
+		),
+		Button(
+			Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+			OnClick(onSave),
+			Text(tr.T("common.save")),
+		),
+	)
+}
+
+// renderResultsEmailSetting takes the address scheduled prompt results are
+// emailed to; saving it empty turns the emails off.
+func renderResultsEmailSetting(value string, tr i18n.Localizer, palette themePalette, onInput func(string), onSave func()) *vango.VNode {
+	return Div(Class("flex items-center gap-1"),
+		Input(
+			Class("min-w-0 flex-1 rounded-md px-2 py-1 text-xs "+palette.ChatInput),
+			Type("email"),
+			Placeholder(tr.T("settings.results_email_placeholder")),
+			Attr("title", tr.T("settings.results_email_title")),
+			Value(value),
+			OnInput(onInput),
+		),
+		Button(
+			Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+			OnClick(onSave),
+			Text(tr.T("common.save")),
+		),
+	)
+}
+
+type deleteDataHandlers struct {
+	onOpen    func()
+	onInput   func(string)
+	onCancel  func()
+	onConfirm func()
+}
+
+// renderDeleteAllData offers to delete everything the user has stored.
+// Deleting waits until the user types the confirmation phrase.
+func renderDeleteAllData(open bool, input string, running bool, tr i18n.Localizer, palette themePalette, on deleteDataHandlers) *vango.VNode {
+	if !open {
+		return Button(
+			Class("underline text-left"),
+			OnClick(on.onOpen),
+			Text(tr.T("settings.delete_data")),
+		)
 	}
-	if len(value) <= maxBytes {
-		return value
+	return Div(Class("space-y-2 pt-1"),
+		Div(Text(tr.T("settings.delete_data_warning"))),
+		Div(Text(tr.T("settings.delete_data_prompt", tr.T("settings.delete_data_phrase")))),
+		Input(
+			Class("w-full rounded-md px-2 py-1 text-xs "+palette.ChatInput),
+			Type("text"),
+			Placeholder(tr.T("settings.delete_data_phrase")),
+			Value(input),
+			OnInput(on.onInput),
+		),
+		Div(Class("flex gap-2"),
+			Button(
+				Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+				OnClick(on.onConfirm),
+				Disabled(running || !deleteDataConfirmed(input, tr)),
+				Text(tr.T("settings.delete_data_confirm")),
+			),
+			Button(
+				Class("rounded-md px-2 py-1 text-xs "+palette.ChatActionButton),
+				OnClick(on.onCancel),
+				Text(tr.T("common.cancel")),
+			),
+		),
+	)
+}
+
+// deleteDataConfirmed reports whether input is the confirmation phrase,
+// ignoring case and surrounding space.
+func deleteDataConfirmed(input string, tr i18n.Localizer) bool {
+	return strings.EqualFold(strings.TrimSpace(input), tr.T("settings.delete_data_phrase"))
+}
+
+type providerKeyHandlers struct {
+	onProvider func(string)
+	onInput    func(string)
+	onSave     func()
+	onRemove   func(provider string)
+}
+
+// renderProviderKeys lists the user's own provider API keys by their last
+// four characters and takes a new key for the chosen provider.
+func renderProviderKeys(keys []chatsvc.ProviderKey, providers []string, provider, input string, tr i18n.Localizer, palette themePalette, on providerKeyHandlers) *vango.VNode {
+	return Div(Class("space-y-1"),
+		Div(Attr("title", tr.T("settings.provider_keys_hint")), Text(tr.T("settings.provider_keys"))),
+		RangeKeyed(keys,
+			func(key chatsvc.ProviderKey) any { return key.Provider },
+			func(key chatsvc.ProviderKey) *vango.VNode {
+				return Div(Class("flex items-center justify-between gap-1"),
+					Span(Class("truncate"), Text(tr.T("settings.provider_key_saved", key.Provider, key.Hint))),
+					Button(
+						Class("rounded-md px-2 py-1 text-xs "+palette.ChatDangerButton),
+						OnClick(func() {
+							on.onRemove(key.Provider)
+						}),
+						Text(tr.T("settings.provider_key_remove")),
+					),
+				)
+			},
+		),
+		Div(Class("flex items-center gap-1"),
+			Select(
+				Class("rounded-md px-1 py-1 text-xs "+palette.ModelSelect),
+				Attr("aria-label", tr.T("settings.provider_key_provider")),
+				Value(provider),
+				OnInput(on.onProvider),
+				RangeKeyed(providers,
+					func(name string) any { return name },
+					func(name string) *vango.VNode {
+						return Option(Value(name), Text(name))
+					},
+				),
+			),
+			Input(
+				Class("min-w-0 flex-1 rounded-md px-2 py-1 text-xs "+palette.ChatInput),
+				Type("password"),
+				Attr("autocomplete", "off"),
+				Placeholder(tr.T("settings.provider_key_placeholder")),
+				Value(input),
+				OnInput(on.onInput),
+			),
+			Button(
+				Class("rounded-md px-2 py-1 text-xs "+palette.ChatSaveButton),
+				OnClick(on.onSave),
+				Text(tr.T("common.save")),
+			),
+		),
+	)
+}
+
+// renderLanguageSetting picks the UI language. The empty choice follows the
+// browser's Accept-Language header.
+func renderLanguageSetting(value string, tr i18n.Localizer, palette themePalette, onSelect func(string)) *vango.VNode {
+	return Select(
+		Class("w-full rounded-md px-2 py-1 text-xs "+palette.ModelSelect),
+		Attr("aria-label", tr.T("settings.language")),
+		Value(value),
+		OnInput(onSelect),
+		Option(Value(""), Text(tr.T("settings.language_browser"))),
+		RangeKeyed(i18n.Languages(),
+			func(language i18n.Language) any { return language.Tag },
+			func(language i18n.Language) *vango.VNode {
+				return Option(Value(language.Tag), Attr("lang", language.Tag), Text(language.Name))
+			},
+		),
+	)
+}
+
+// usageFlushInterval spaces out the status line's token count while a
+// turn's output is still being estimated.
+const usageFlushInterval = 500 * time.Millisecond
+
+// renderRunUsage shows the running run's output tokens and rate, marked as
+// approximate until the provider reports the turn's usage.
+func renderRunUsage(progress chatsvc.UsageProgress, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if progress.OutputTokens == 0 {
+		return nil
 	}
-	if maxBytes <= 3 {
-		return value[:maxBytes]
+	tokens := strconv.Itoa(progress.OutputTokens)
+	if progress.Estimated {
+		tokens = "~" + tokens
 	}
-	return value[:maxBytes-3] + "..."
+	return Span(
+		Class("text-xs tabular-nums "+palette.TimerText),
+		Text(tr.T("run.usage_live", tokens, strconv.FormatFloat(progress.TokensPerSecond, 'f', 0, 64))),
+	)
 }
 
-func renderMessageContent(message MessageView, theme string, palette themePalette) *vango.VNode {
-	if message.Role != "assistant" {
-		return Div(Text(message.Content))
+func renderRunTimer(run PendingRun, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	if run.RunID == "" || run.StartedAt.IsZero() {
+		return nil
 	}
-
-	islandID := "md-" + message.ID
 	return Div(
-		Class("md-renderer-host"),
-		Data("module", "/js/islands/markdown-renderer.js"),
-		JSIsland(islandID, map[string]any{
-			"markdown": message.Content,
-			"theme":    theme,
+		Class("text-xs tabular-nums "+palette.TimerText),
+		Data("module", "/js/islands/run-timer.js"),
+		JSIsland("run-timer-"+run.RunID, map[string]any{
+			"startedAt": run.StartedAt.UnixMilli(),
+			"timeoutMs": run.RunTimeout.Milliseconds(),
+			"labels":    tr.Messages("run.time_left"),
 		}),
 		IslandPlaceholder(
-			Div(Class("md-renderer "+palette.ToolText), Text(message.Content)),
+			Span(Text(tr.T("header.run_limit", run.RunTimeout))),
 		),
 	)
 }
-
-func paletteFor(mode string) themePalette {
-	if mode == "light" {
-		return themePalette{
-			AppRoot:          "bg-slate-100 text-slate-900",
-			Sidebar:          "border-r border-slate-300 bg-slate-50",
-			SidebarSection:   "border-b border-slate-300",
-			NewChatButton:    "bg-slate-800 text-white hover:bg-slate-700",
-			ChatButtonBase:   "w-full text-left rounded-md px-3 py-2 text-sm transition-colors border",
-			ChatButtonIdle:   "bg-white border-slate-300 hover:bg-slate-100",
-			ChatButtonActive: "bg-blue-100 border-blue-400",
-			ChatActionButton: "border border-slate-300 bg-white text-slate-700 hover:bg-slate-100",
-			ChatDangerButton: "border border-red-300 bg-white text-red-700 hover:bg-red-100",
-			ChatInput:        "bg-white border border-slate-300 text-slate-900",
-			ChatSaveButton:   "border border-blue-300 bg-blue-600 text-white hover:bg-blue-700",
-			ChatMeta:         "text-slate-500",
-			Header:           "border-b border-slate-300 bg-white",
-			HeaderTitle:      "text-slate-700",
-			ModelSelect:      "bg-white border border-slate-300 text-slate-900",
-			ThemeToggle:      "border-slate-300 text-slate-700 hover:bg-slate-100",
-			StopButton:       "border-red-300 text-red-700 hover:bg-red-100",
-			ErrorText:        "text-red-700",
-			ChatBody:         "bg-white",
-			AssistantBubble:  "bg-transparent border-transparent text-slate-900",
-			UserBubble:       "bg-slate-200 border-[#2445FF] text-slate-900",
-			ThinkingText:     "text-slate-600",
-			StatusText:       "text-slate-500",
-			RoleText:         "text-slate-600",
-			ToolCard:         "border-slate-300 bg-slate-100",
-			ToolText:         "text-slate-700",
-			ToolErrorText:    "text-red-700",
-			Composer:         "border-t border-slate-300 bg-white",
-			Input:            "bg-white border border-slate-300 text-slate-900 placeholder:text-slate-500",
-			SendButton:       "bg-blue-600 text-white hover:bg-blue-700",
-		}
-	}
-
-	return themePalette{
-		AppRoot:          "bg-[#0b1320] text-white",
-		Sidebar:          "border-r border-white/10 bg-black",
-		SidebarSection:   "border-b border-white/10",
-		NewChatButton:    "bg-zinc-900 hover:bg-zinc-800 text-white",
-		ChatButtonBase:   "w-full text-left rounded-md px-3 py-2 text-sm transition-colors border border-transparent",
-		ChatButtonIdle:   "bg-zinc-950 hover:bg-zinc-900",
-		ChatButtonActive: "bg-zinc-900 border-white/20",
-		ChatActionButton: "border border-white/20 bg-zinc-950 text-white/90 hover:bg-zinc-900",
-		ChatDangerButton: "border border-red-500/40 bg-zinc-950 text-red-200 hover:bg-red-500/10",
-		ChatInput:        "bg-zinc-950 border border-white/20 text-white",
-		ChatSaveButton:   "border border-blue-400/50 bg-[#2457d6] text-white hover:bg-[#2e63e0]",
-		ChatMeta:         "text-white/60",
-		Header:           "border-b border-white/10 bg-black",
-		HeaderTitle:      "text-white/80",
-		ModelSelect:      "bg-zinc-950 border border-white/20 text-white",
-		ThemeToggle:      "border-white/30 text-white hover:bg-white/10",
-		StopButton:       "border-red-400/40 text-red-200 hover:bg-red-400/10",
-		ErrorText:        "text-red-300",
-		ChatBody:         "bg-black",
-		AssistantBubble:  "bg-transparent border-transparent text-white",
-		UserBubble:       "bg-zinc-900 border-[#2445FF] text-white",
-		ThinkingText:     "text-white/70",
-		StatusText:       "text-white/50",
-		RoleText:         "text-white/60",
-		ToolCard:         "border-white/10 bg-black/20",
-		ToolText:         "text-white/70",
-		ToolErrorText:    "text-red-200",
-		Composer:         "border-t border-white/10 bg-black",
-		Input:            "bg-zinc-950 border border-white/20 text-white placeholder:text-white/60",
-		SendButton:       "bg-[#2457d6] text-white hover:bg-[#2e63e0]",
-	}
-}
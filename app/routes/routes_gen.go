@@ -16,13 +16,31 @@ func Register(app *vango.App) {
 	// Pages
 	app.Page("/about", AboutPage)
 	app.Page("/", IndexPage)
+	app.Page("/evals", EvalsPage)
+	app.Page("/share/:token", SharePage)
+	app.Page("/tools", ToolsPage)
+	app.Page("/unsubscribe/:token", UnsubscribePage)
 
 	// API routes
+	app.API("POST", "/api/attachments", api.AttachmentsPOST)
+	app.API("GET", "/api/comparisons", api.ComparisonsGET)
+	app.API("GET", "/api/evals", api.EvalsGET)
 	app.API("GET", "/api/health", api.HealthGET)
+	app.API("POST", "/api/knowledge", api.KnowledgePOST)
+	app.API("GET", "/api/livez", api.LivezGET)
+	app.API("GET", "/api/messages", api.MessagesGET)
+	app.API("GET", "/api/quota", api.QuotaGET)
+	app.API("GET", "/api/readyz", api.ReadyzGET)
+	app.API("GET", "/api/toolcalls", api.ToolcallsGET)
+	app.API("POST", "/api/unsubscribe", api.UnsubscribePOST)
 }
 
 // Route path constants for type-safe linking.
 const (
-	RouteIndex = "/"
-	RouteAbout = "/about"
+	RouteIndex       = "/"
+	RouteAbout       = "/about"
+	RouteTools       = "/tools"
+	RouteEvals       = "/evals"
+	RouteShare       = "/share"
+	RouteUnsubscribe = "/unsubscribe"
 )
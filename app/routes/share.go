@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/vango-go/vango"
+	. "github.com/vango-go/vango/el"
+
+	"rhone_chat/internal/i18n"
+	chatsvc "rhone_chat/internal/services/chat"
+	"rhone_chat/internal/theme"
+)
+
+// SharePage shows the chat behind a share link to anyone holding it, read
+// only: there is no composer, sidebar or message controls.
+func SharePage(ctx vango.Ctx) *vango.VNode {
+	request := ctx.Request()
+	tr := i18n.For(i18n.Match(request.Header.Get("Accept-Language")))
+	dependencies := getDeps()
+	themes := dependencies.Themes
+	if themes == nil {
+		themes = theme.Defaults()
+	}
+	current := themes.Get(themes.Default)
+	palette := current.Palette
+
+	shared := chatsvc.SharedChat{}
+	err := chatsvc.ErrSharingDisabled
+	if dependencies.Chat != nil {
+		token := strings.TrimPrefix(request.URL.Path, RouteShare+"/")
+		shared, err = dependencies.Chat.SharedChat(request.Context(), token)
+	}
+	if err != nil {
+		return sharePageShell(current, tr, tr.T("share.read_only"),
+			P(Class("text-sm "+palette.ChatMeta), Text(tr.T("share.invalid"))),
+		)
+	}
+	if len(shared.Messages) == 0 {
+		return sharePageShell(current, tr, shared.Title,
+			P(Class("text-sm "+palette.ChatMeta), Text(tr.T("share.empty"))),
+		)
+	}
+	return sharePageShell(current, tr, shared.Title,
+		RangeKeyed(shared.Messages,
+			func(message chatsvc.Message) any { return message.ID },
+			func(message chatsvc.Message) *vango.VNode {
+				return renderSharedMessage(message, current.Mode, tr, palette)
+			},
+		),
+	)
+}
+
+func sharePageShell(current theme.Theme, tr i18n.Localizer, title string, body any) *vango.VNode {
+	palette := current.Palette
+	return Div(Class("h-screen overflow-y-auto chat-shell "+palette.AppRoot), Attr("style", current.Style()), Attr("lang", tr.Lang()),
+		Div(Class("mx-auto max-w-3xl px-6 py-8 space-y-4 "+palette.ChatBody),
+			Div(Class("space-y-1"),
+				H1(Class("text-xl font-semibold truncate "+palette.HeaderTitle), Text(title)),
+				P(Class("text-xs "+palette.ChatMeta), Text(tr.T("share.read_only"))),
+			),
+			body,
+		),
+	)
+}
+
+func renderSharedMessage(message chatsvc.Message, mode string, tr i18n.Localizer, palette themePalette) *vango.VNode {
+	bubbleClass := "rounded-lg px-4 py-3 max-w-3xl whitespace-pre-wrap border"
+	containerClass := "flex"
+	if message.Role == "user" {
+		containerClass += " justify-end"
+		bubbleClass += " " + palette.UserBubble
+	} else {
+		containerClass += " justify-start"
+		bubbleClass += " " + palette.AssistantBubble
+	}
+	view := MessageView{ID: message.ID, Role: message.Role, Content: message.Content, Status: message.Status, CreatedAt: message.CreatedAt}
+	return Div(Class(containerClass), ID("msg-"+message.ID),
+		Div(Class(bubbleClass),
+			renderMessageContent(view, mode, tr, palette),
+		),
+	)
+}
@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"fmt"
+
+	"github.com/vango-go/vango"
+	. "github.com/vango-go/vango/el"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// ToolsPage is the tool catalog: every registered tool with where it came
+// from, its schemas, whether chats use it, and its calls over the last week.
+func ToolsPage(ctx vango.Ctx) *vango.VNode {
+	if _, err := principalFor(ctx); err != nil {
+		return toolsPageShell(P(Class("text-sm text-white/60"), Text("Your request did not carry a recognized identity.")))
+	}
+	catalog, err := getDeps().Chat.ToolCatalog(ctx.Request().Context(), "")
+	if err != nil {
+		return toolsPageShell(P(Class("text-sm text-red-300"), Text("Could not load the tool catalog: "+err.Error())))
+	}
+	if len(catalog) == 0 {
+		return toolsPageShell(P(Class("text-sm text-white/60"), Text("No tools are configured.")))
+	}
+	return toolsPageShell(
+		Div(Class("flex flex-col gap-3"),
+			RangeKeyed(catalog,
+				func(tool chatsvc.CatalogTool) any { return tool.Name },
+				renderCatalogTool,
+			),
+		),
+	)
+}
+
+func toolsPageShell(body *vango.VNode) *vango.VNode {
+	return Div(Class("h-screen overflow-y-auto bg-black text-white/80"),
+		Div(Class("mx-auto max-w-3xl px-6 py-8 space-y-6"),
+			Div(Class("flex items-center justify-between"),
+				H1(Class("text-xl font-semibold"), Text("Tools")),
+				A(Class("text-sm text-white/60 hover:text-white"), Href(RouteIndex), Text("Back to chat")),
+			),
+			body,
+		),
+	)
+}
+
+func renderCatalogTool(tool chatsvc.CatalogTool) *vango.VNode {
+	state := "Enabled"
+	if tool.DisabledChats > 0 {
+		state = fmt.Sprintf("Enabled by default, off in %d chat%s", tool.DisabledChats, plural(tool.DisabledChats))
+	}
+	usage := "Not used in the last 7 days"
+	if tool.Calls > 0 {
+		usage = fmt.Sprintf("%d call%s in the last 7 days", tool.Calls, plural(tool.Calls))
+		if tool.Errors > 0 {
+			usage += fmt.Sprintf(", %d failed", tool.Errors)
+		}
+	}
+	if !tool.LastUsedAt.IsZero() {
+		usage += " · last used " + tool.LastUsedAt.Local().Format("2006-01-02 15:04")
+	}
+	timeout := "default timeout"
+	if tool.Native {
+		timeout = "runs at the provider"
+	} else if tool.Timeout > 0 {
+		timeout = tool.Timeout.String() + " timeout"
+	}
+	return Div(Class("rounded-md border border-white/10 p-4 space-y-2"),
+		Div(Class("flex items-center gap-2"),
+			Span(Class("text-sm font-medium"), Text(tool.Name)),
+			Span(Class("rounded bg-white/10 px-1.5 py-0.5 text-[11px] uppercase tracking-wide text-white/60"), Text(tool.Source)),
+		),
+		If(tool.Description != "", P(Class("text-sm text-white/70"), Text(tool.Description))),
+		Div(Class("text-xs text-white/50"), Text(state+" · "+timeout)),
+		Div(Class("text-xs text-white/50"), Text(usage)),
+		If(tool.InputSchema != "", renderToolSchema("Input schema", tool.InputSchema)),
+		If(tool.OutputSchema != "", renderToolSchema("Output schema", tool.OutputSchema)),
+	)
+}
+
+func renderToolSchema(label, schema string) *vango.VNode {
+	return Details(Class("text-xs"),
+		Summary(Class("cursor-pointer text-white/60"), Text(label)),
+		Pre(Class("mt-2 overflow-x-auto rounded bg-white/5 p-2 text-white/70"), Text(schema)),
+	)
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
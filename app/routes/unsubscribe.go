@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/vango-go/vango"
+	. "github.com/vango-go/vango/el"
+
+	"rhone_chat/internal/i18n"
+	"rhone_chat/internal/theme"
+)
+
+// UnsubscribePage turns off scheduled prompt results email for the user an
+// email's unsubscribe link was made for. The signed token stands in for a
+// session, so it works from any mail client.
+func UnsubscribePage(ctx vango.Ctx) *vango.VNode {
+	request := ctx.Request()
+	tr := i18n.For(i18n.Match(request.Header.Get("Accept-Language")))
+	dependencies := getDeps()
+	themes := dependencies.Themes
+	if themes == nil {
+		themes = theme.Defaults()
+	}
+	current := themes.Get(themes.Default)
+	palette := current.Palette
+
+	message := tr.T("unsubscribe.invalid")
+	if dependencies.Chat != nil {
+		token := strings.TrimPrefix(request.URL.Path, RouteUnsubscribe+"/")
+		if err := dependencies.Chat.Unsubscribe(request.Context(), token); err == nil {
+			message = tr.T("unsubscribe.done")
+		}
+	}
+	return Div(Class("h-screen overflow-y-auto chat-shell "+palette.AppRoot), Attr("style", current.Style()), Attr("lang", tr.Lang()),
+		Div(Class("mx-auto max-w-3xl px-6 py-8 space-y-4 "+palette.ChatBody),
+			H1(Class("text-xl font-semibold "+palette.HeaderTitle), Text(tr.T("unsubscribe.title"))),
+			P(Class("text-sm "+palette.ChatMeta), Text(message)),
+			A(Class("text-sm underline"), Href(RouteIndex), Text(tr.T("unsubscribe.open_app"))),
+		),
+	)
+}
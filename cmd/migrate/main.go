@@ -0,0 +1,55 @@
+// Command migrate shows the SQLite schema version and moves the database up
+// or down to a given migration. The server applies pending migrations at
+// startup; this is for inspecting them and rolling back.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+)
+
+func main() {
+	_ = godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	dbPath := flag.String("db", cfg.DatabasePath, "path to the SQLite database")
+	to := flag.Int("to", db.LatestSchemaVersion(), "schema version to migrate to; lower than the current version reverts")
+	flag.Parse()
+
+	store, err := db.OpenSQLiteAt(*dbPath, *to)
+	if err != nil {
+		slog.Error("failed to migrate sqlite store", "error", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	states, err := store.Migrations(context.Background())
+	if err != nil {
+		slog.Error("failed to list migrations", "error", err)
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.AppliedAt.Valid {
+			applied = state.AppliedAt.Time.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+	w.Flush()
+}
@@ -0,0 +1,350 @@
+// Command rhonectl talks to the REST/SSE API of a rhone_chat server from the
+// terminal:
+//
+//	rhonectl send [-chat ID] [-model M] [-json] [prompt ...]
+//	rhonectl chats [-limit N] [-json]
+//	rhonectl export [-format markdown|json] [-o FILE] CHAT_ID
+//
+// send streams the answer to stdout as it arrives, reading the prompt from
+// stdin when none is given, and starts a new chat unless -chat names one;
+// the new chat's ID goes to stderr. chats lists chats, most recently updated
+// first. export writes a chat and its messages.
+//
+// The server is -server or RHONE_URL, the base URL of the server's API_ADDR
+// listener. -token or RHONE_TOKEN is sent as a bearer token.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: rhonectl [-server URL] [-token TOKEN] <command> [flags]
+
+commands:
+  send [-chat ID] [-model M] [-json] [prompt ...]
+                 send a prompt and stream the answer; reads stdin without one
+  chats [-limit N] [-json]
+                 list chats, most recently updated first
+  export [-format markdown|json] [-o FILE] CHAT_ID
+                 write a chat and its messages
+`
+
+func main() {
+	global := flag.NewFlagSet("rhonectl", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := global.String("server", os.Getenv("RHONE_URL"), "base URL of the server's API")
+	token := global.String("token", os.Getenv("RHONE_TOKEN"), "bearer token")
+	_ = global.Parse(os.Args[1:])
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	if *server == "" {
+		fail(errors.New("set RHONE_URL or -server to the URL of the server's API_ADDR listener"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &client{baseURL: strings.TrimRight(*server, "/"), token: *token, http: &http.Client{}}
+	command, args := global.Arg(0), global.Args()[1:]
+	var err error
+	switch command {
+	case "send":
+		err = runSend(ctx, c, args)
+	case "chats":
+		err = runChats(ctx, c, args)
+	case "export":
+		err = runExport(ctx, c, args)
+	default:
+		fmt.Fprintf(os.Stderr, "rhonectl: unknown command %q\n\n", command)
+		global.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "rhonectl:", err)
+	os.Exit(1)
+}
+
+// chat is a chat as the API lists it.
+type chat struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// runEvent is the part of a streamed run event the CLI reads.
+type runEvent struct {
+	Type    string `json:"type"`
+	RunID   string `json:"run_id"`
+	ChatID  string `json:"chat_id"`
+	Delta   string `json:"delta"`
+	Content string `json:"content"`
+	Status  string `json:"status"`
+	Error   string `json:"error"`
+}
+
+func runSend(ctx context.Context, c *client, args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	chatID := flags.String("chat", "", "chat to send to; empty starts a new chat")
+	model := flags.String("model", "", "model to answer with; empty uses the chat's")
+	asJSON := flags.Bool("json", false, "print each run event as a JSON line instead of the answer")
+	_ = flags.Parse(args)
+
+	prompt := strings.Join(flags.Args(), " ")
+	if prompt == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("read prompt: %w", err)
+		}
+		prompt = string(data)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return errors.New("prompt is empty")
+	}
+	if *chatID == "" {
+		var created chat
+		if err := c.do(ctx, http.MethodPost, "/api/v1/chats", map[string]string{"model": *model}, &created); err != nil {
+			return err
+		}
+		*chatID = created.ID
+		fmt.Fprintln(os.Stderr, "chat", created.ID)
+	}
+
+	response, err := c.request(ctx, http.MethodPost, "/api/v1/chats/"+url.PathEscape(*chatID)+"/messages", map[string]string{"content": prompt, "model": *model})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	streamed := false
+	var completed *runEvent
+	err = readEvents(response.Body, func(data []byte) error {
+		if *asJSON {
+			out.Write(data)
+			out.WriteByte('\n')
+			return out.Flush()
+		}
+		var event runEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		switch event.Type {
+		case "streaming":
+			streamed = streamed || event.Delta != ""
+			out.WriteString(event.Delta)
+			return out.Flush()
+		case "completed":
+			completed = &event
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return nil
+	}
+	if completed == nil {
+		return errors.New("the stream ended before the run completed; see GET /api/v1/runs/{runID}")
+	}
+	if !streamed {
+		out.WriteString(completed.Content)
+	}
+	out.WriteString("\n")
+	if completed.Status != "completed" {
+		out.Flush()
+		if completed.Error != "" {
+			return fmt.Errorf("run %s: %s", completed.Status, completed.Error)
+		}
+		return fmt.Errorf("run %s", strings.ReplaceAll(completed.Status, "_", " "))
+	}
+	return nil
+}
+
+// readEvents calls handle with the data of each server-sent event in body.
+func readEvents(body io.Reader, handle func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if len(data) > 0 {
+				if err := handle(data); err != nil {
+					return err
+				}
+				data = data[:0]
+			}
+			continue
+		}
+		if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(value, []byte(" "))...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return handle(data)
+	}
+	return nil
+}
+
+func runChats(ctx context.Context, c *client, args []string) error {
+	flags := flag.NewFlagSet("chats", flag.ExitOnError)
+	limit := flags.Int("limit", 50, "most chats to list; 0 lists all")
+	asJSON := flags.Bool("json", false, "print the chats as JSON")
+	_ = flags.Parse(args)
+
+	var chats []chat
+	cursor := ""
+	for {
+		page := 200
+		if *limit > 0 {
+			page = min(page, *limit-len(chats))
+		}
+		query := url.Values{"limit": {strconv.Itoa(page)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		var listed struct {
+			Chats      []chat `json:"chats"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/api/v1/chats?"+query.Encode(), nil, &listed); err != nil {
+			return err
+		}
+		chats = append(chats, listed.Chats...)
+		cursor = listed.NextCursor
+		if cursor == "" || (*limit > 0 && len(chats) >= *limit) {
+			break
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(chats)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUPDATED\tMODEL\tTITLE")
+	for _, chat := range chats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", chat.ID, chat.UpdatedAt.Local().Format("2006-01-02 15:04"), chat.Model, chat.Title)
+	}
+	return w.Flush()
+}
+
+func runExport(ctx context.Context, c *client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "markdown", "markdown or json")
+	output := flags.String("o", "", "file to write; empty writes to stdout")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("export takes one chat ID")
+	}
+
+	response, err := c.request(ctx, http.MethodGet, "/api/v1/chats/"+url.PathEscape(flags.Arg(0))+"/export?format="+url.QueryEscape(*format), nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if *output == "" {
+		_, err = io.Copy(os.Stdout, response.Body)
+		return err
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, response.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// client calls the server's API.
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// do sends body as JSON and decodes the response into out.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	response, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decode response: %w", method, path, err)
+	}
+	return nil
+}
+
+// request sends body, when not nil, as JSON and returns the response, or
+// the API's error when it does not answer with success.
+func (c *client) request(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	response, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	defer response.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, apiErr.Error)
+	}
+	return nil, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(data)))
+}
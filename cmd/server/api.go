@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"rhone_chat/internal/grpcapi"
+)
+
+// startAPIServer serves the REST/SSE API on its own listener, over TLS when
+// tlsConfig is set. WriteTimeout is left unset because run streams last as
+// long as the model does.
+func startAPIServer(ctx context.Context, addr string, handler http.Handler, tlsConfig *tls.Config) {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * time.Second, TLSConfig: tlsConfig}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		slog.Info("starting api server", "addr", addr)
+		serve := server.ListenAndServe
+		if tlsConfig != nil {
+			serve = func() error { return server.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("api server stopped", "error", err)
+		}
+	}()
+}
+
+// startGRPCServer serves the gRPC API on its own listener, stopping it
+// gracefully when ctx ends.
+func startGRPCServer(ctx context.Context, addr string, server *grpc.Server) {
+	go func() {
+		<-ctx.Done()
+		grpcapi.GracefulStop(server, 5*time.Second)
+	}()
+	go func() {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			slog.Error("grpc server stopped", "error", err)
+			return
+		}
+		slog.Info("starting grpc server", "addr", addr)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			slog.Error("grpc server stopped", "error", err)
+		}
+	}()
+}
@@ -2,39 +2,239 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/vango-go/vango"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"rhone_chat/app/middleware"
 	"rhone_chat/app/routes"
 	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/backup"
+	"rhone_chat/internal/blob"
 	"rhone_chat/internal/config"
 	"rhone_chat/internal/db"
+	"rhone_chat/internal/discord"
+	"rhone_chat/internal/grpcapi"
+	"rhone_chat/internal/health"
+	"rhone_chat/internal/httpapi"
+	"rhone_chat/internal/jobs"
+	"rhone_chat/internal/mail"
+	"rhone_chat/internal/mcp"
+	"rhone_chat/internal/metrics"
+	"rhone_chat/internal/pii"
+	"rhone_chat/internal/postprocess"
 	chatsvc "rhone_chat/internal/services/chat"
+	"rhone_chat/internal/telegram"
+	"rhone_chat/internal/theme"
 )
 
 func main() {
 	_ = godotenv.Load()
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	// serveCtx outlives the shutdown signal by the drain window, so the
+	// servers and scheduler keep serving the runs that are finishing.
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+	// The API listener starts first so probes are answered during
+	// migrations; its routes answer 503 until startup finishes.
+	probe := health.New()
+	apiGate := &health.Gate{}
+	serverTLS, err := auth.ServerTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+	if err != nil {
+		slog.Error("invalid TLS settings", "error", err)
+		os.Exit(1)
+	}
+	if cfg.APIAddr != "" {
+		startAPIServer(serveCtx, cfg.APIAddr, probe.Handler(apiGate), serverTLS)
+	}
 
-	store, err := db.OpenSQLite(cfg.DatabasePath)
+	store, err := db.OpenSQLiteWith(cfg.DatabasePath, db.Options{
+		BusyTimeout:       cfg.SQLiteBusyTimeout,
+		Synchronous:       cfg.SQLiteSynchronous,
+		WALAutocheckpoint: cfg.SQLiteWALAutocheckpoint,
+		ReadConns:         cfg.SQLiteReadConns,
+	})
 	if err != nil {
 		slog.Error("failed to open sqlite store", "error", err)
 		os.Exit(1)
 	}
 	defer store.Close()
+	codec, err := db.CodecFromKey(cfg.EncryptionKey, cfg.EncryptionKeyFile)
+	if err != nil {
+		slog.Error("invalid ENCRYPTION_KEY", "error", err)
+		os.Exit(1)
+	}
+	if codec != nil {
+		store.SetCodec(codec)
+		sealed, err := store.SealPlaintext(context.Background())
+		if err != nil {
+			slog.Error("failed to encrypt stored messages", "error", err)
+			os.Exit(1)
+		}
+		if sealed > 0 {
+			slog.Info("encrypted values stored before encryption was enabled", "values", sealed)
+		}
+	}
+
+	tools := ai.DefaultToolRegistry()
+	if cfg.FetchURLEnabled {
+		if err := tools.Register(ai.NewFetchURLTool(ai.FetchConfig{
+			Timeout:      cfg.FetchURLTimeout,
+			MaxBytes:     int64(cfg.FetchURLMaxBytes),
+			MaxChars:     cfg.FetchURLMaxChars,
+			AllowDomains: cfg.FetchURLAllowDomains,
+			DenyDomains:  cfg.FetchURLDenyDomains,
+		})); err != nil {
+			slog.Error("failed to register fetch_url tool", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.MCPConfigPath != "" {
+		servers, err := mcp.LoadConfig(cfg.MCPConfigPath)
+		if err != nil {
+			slog.Error("failed to load mcp config", "error", err)
+			os.Exit(1)
+		}
+		startCtx, cancelStart := context.WithTimeout(context.Background(), 30*time.Second)
+		mcpServers := mcp.Start(startCtx, servers, tools, slog.Default().With("component", "mcp"))
+		cancelStart()
+		defer mcpServers.Close()
+	}
 
+	transport, err := ai.NewTransport(ai.TransportConfig{
+		ProxyURL:           cfg.ProviderProxyURL,
+		BaseURLs:           cfg.ProviderBaseURLs,
+		CAFile:             cfg.ProviderCAFile,
+		ClientCertFile:     cfg.ProviderClientCertFile,
+		ClientKeyFile:      cfg.ProviderClientKeyFile,
+		InsecureSkipVerify: cfg.ProviderTLSInsecure,
+	})
+	if err != nil {
+		slog.Error("invalid provider network settings", "error", err)
+		os.Exit(1)
+	}
+	if cfg.ProviderTLSInsecure {
+		slog.Warn("provider TLS certificates are not verified")
+	}
+	ollamaModels := cfg.OllamaModels
+	if cfg.OllamaURL != "" && len(ollamaModels) == 0 {
+		listCtx, cancelList := context.WithTimeout(context.Background(), 5*time.Second)
+		ollamaModels, err = ai.OllamaModels(listCtx, cfg.OllamaURL)
+		cancelList()
+		if err != nil {
+			slog.Warn("failed to list ollama models", "url", cfg.OllamaURL, "error", err)
+		}
+	}
 	runner := ai.NewRunner(ai.RunnerConfig{
-		MaxTurns:     cfg.MaxTurns,
-		MaxToolCalls: cfg.MaxToolCalls,
-		RunTimeout:   cfg.RunTimeout,
-		ToolTimeout:  cfg.ToolTimeout,
+		MaxTurns:        cfg.MaxTurns,
+		MaxToolCalls:    cfg.MaxToolCalls,
+		RunTimeout:      cfg.RunTimeout,
+		ToolTimeout:     cfg.ToolTimeout,
+		ParallelTools:   cfg.ParallelTools,
+		ContextWindows:  cfg.ContextWindows,
+		ReasoningEffort: cfg.ReasoningEffort,
+		MockModel:       cfg.MockModel,
+		Tools:           tools,
+		ProviderKeys:    store.ProviderKeys,
+		KeyPools:        cfg.ProviderKeyPools,
+		KeyStrategy:     cfg.ProviderKeyStrategy,
+		Transport:       transport,
+		Ollama:          ai.OllamaConfig{URL: cfg.OllamaURL, Models: ollamaModels},
+		Azure: ai.AzureConfig{
+			Endpoint:    cfg.AzureEndpoint,
+			APIKey:      cfg.AzureAPIKey,
+			APIVersion:  cfg.AzureAPIVersion,
+			Deployments: cfg.AzureDeployments,
+		},
+		Breaker: ai.BreakerConfig{
+			Failures:  cfg.BreakerFailures,
+			Window:    cfg.BreakerWindow,
+			Cooldown:  cfg.BreakerCooldown,
+			Fallbacks: cfg.BreakerFallbacks,
+		},
+		ProviderLog: ai.ProviderLogConfig{
+			Enabled:        cfg.ProviderLog,
+			IncludeContent: cfg.ProviderLogContent,
+			Logger:         slog.Default().With("component", "provider"),
+		},
 	})
+	if _, err := postprocess.New(cfg.OutputProcessors); err != nil {
+		slog.Error("invalid AI_OUTPUT_PROCESSORS", "error", err)
+		os.Exit(1)
+	}
+	if _, err := pii.New(cfg.RedactPII); err != nil {
+		slog.Error("invalid REDACT_PII", "error", err)
+		os.Exit(1)
+	}
+	if cfg.SMTPAddr != "" || cfg.SMTPFrom != "" {
+		if _, err := mail.NewSMTP(mail.Config{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom}); err != nil {
+			slog.Error("invalid SMTP_ADDR or SMTP_FROM", "error", err)
+			os.Exit(1)
+		}
+	}
+	var bucket *blob.S3
+	if cfg.BlobStore == "s3" {
+		bucket, err = blob.NewS3(blob.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			Prefix:          cfg.S3Prefix,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+		if err != nil {
+			slog.Error("invalid S3 settings", "error", err)
+			os.Exit(1)
+		}
+	}
 	chatService := chatsvc.NewService(store, runner, cfg)
+	if setup := chatService.SetupStatus(); setup.NeedsSetup {
+		slog.Warn("no model provider configured; only the mock model is available", "missing", setup.MissingKeys)
+	} else if len(setup.MissingKeys) > 0 {
+		slog.Info("some model providers are not configured", "missing", setup.MissingKeys)
+	}
+	if err := runStartupMaintenance(context.Background(), chatService); err != nil {
+		slog.Error("startup maintenance failed", "error", err)
+		os.Exit(1)
+	}
+	if err := chatService.LoadWebhookTools(context.Background()); err != nil {
+		slog.Warn("some webhook tools were not registered", "error", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if serverTLS != nil {
+		clientCAs = serverTLS.ClientCAs
+	}
+	authenticator, err := auth.New(cfg.AuthMode, auth.HeaderConfig{
+		UserHeader:     cfg.AuthUserHeader,
+		EmailHeader:    cfg.AuthEmailHeader,
+		RolesHeader:    cfg.AuthRolesHeader,
+		TrustedProxies: cfg.AuthTrustedProxies,
+	}, auth.MTLSConfig{
+		ClientCertHeader: cfg.AuthClientCertHeader,
+		TrustedProxies:   cfg.AuthTrustedProxies,
+		ClientCAs:        clientCAs,
+	})
+	if err != nil {
+		slog.Error("failed to configure authentication", "error", err)
+		os.Exit(1)
+	}
 
 	app, err := vango.New(vango.Config{
 		Session: vango.SessionConfig{
@@ -51,17 +251,190 @@ func main() {
 		os.Exit(1)
 	}
 
+	probe.AddCheck("database", store.Ping)
+	probe.AddCheck("runs", func(context.Context) error {
+		if chatService.Draining() {
+			return chatsvc.ErrShuttingDown
+		}
+		return nil
+	})
+	themes := theme.Defaults()
+	if cfg.ThemeFile != "" {
+		if themes, err = theme.LoadFile(cfg.ThemeFile); err != nil {
+			slog.Error("failed to load themes", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	routes.SetDeps(routes.Deps{
-		Chat: chatService,
+		Chat:   chatService,
+		Auth:   authenticator,
+		Health: probe,
+		Themes: themes,
 	})
 	routes.Register(app)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	go func() {
+		<-ctx.Done()
+		drainRuns(chatService, cfg.ShutdownDrainTimeout)
+		// Ends the event streams, which would otherwise hold the API
+		// server's shutdown open.
+		chatService.Events().Close()
+		stopServing()
+	}()
+
+	reloadOnHangup(ctx, chatService)
+	if cfg.DebugEndpoints {
+		startDebugServer(ctx, cfg.DebugAddr, chatService.RunMetrics())
+	}
+	chatService.RunMetrics().Publish("runs")
+	alertThresholds := metrics.Thresholds{
+		ActiveRuns:      cfg.AlertActiveRuns,
+		WaitingRuns:     cfg.AlertWaitingRuns,
+		ProviderWaitP95: cfg.AlertProviderWait,
+	}
+	if alertThresholds.Enabled() {
+		alerter := &metrics.Alerter{
+			Runs:       chatService.RunMetrics(),
+			Thresholds: alertThresholds,
+			WebhookURL: cfg.AlertWebhookURL,
+			Logger:     slog.Default().With("component", "alerts"),
+		}
+		go alerter.Run(ctx, cfg.AlertCheckInterval)
+	}
+	// Backups are staged beside where they are kept, or beside the
+	// database when they go to S3.
+	var backups *backup.Manager
+	switch {
+	case bucket != nil:
+		backups = backup.New(store, bucket.Sub("backups/"), filepath.Dir(cfg.DatabasePath), cfg.BackupKeep)
+	case cfg.BackupDir != "":
+		backups = backup.New(store, blob.NewDisk(cfg.BackupDir), cfg.BackupDir, cfg.BackupKeep)
+	}
+	apiAuthenticator := authenticator
+	if cfg.AdminToken != "" {
+		apiAuthenticator = auth.Chain(auth.AdminToken(cfg.AdminToken), authenticator)
+	}
+	apiHandler := middleware.RequireAuth(apiAuthenticator, httpapi.New(chatService, backups))
+	var telegramBot *telegram.Bot
+	if cfg.TelegramToken != "" {
+		telegramBot = telegram.New(chatService, telegram.Config{
+			Token:        cfg.TelegramToken,
+			UserID:       cfg.TelegramUserID,
+			AllowedUsers: cfg.TelegramAllowedUsers,
+			WebhookURL:   cfg.TelegramWebhookURL,
+			EditInterval: cfg.TelegramEditInterval,
+			Logger:       slog.Default().With("component", "telegram"),
+		})
+		if cfg.TelegramWebhookURL != "" {
+			if cfg.APIAddr == "" {
+				slog.Error("TELEGRAM_WEBHOOK_URL needs API_ADDR, whose listener serves the webhook")
+				os.Exit(1)
+			}
+			// Telegram authenticates with the webhook's secret rather than
+			// a user's credentials.
+			mux := http.NewServeMux()
+			mux.Handle("/telegram/webhook", telegramBot.Handler())
+			mux.Handle("/", apiHandler)
+			apiHandler = mux
+		}
+	}
+	apiGate.Open(apiHandler)
+	if cfg.GRPCAddr != "" {
+		var options []grpc.ServerOption
+		if serverTLS != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(serverTLS)))
+		}
+		startGRPCServer(serveCtx, cfg.GRPCAddr, grpcapi.NewServer(chatService, apiAuthenticator, options...))
+	}
+
+	scheduler := jobs.New(store, slog.Default().With("component", "jobs"))
+	if backups != nil && cfg.BackupSchedule != "off" {
+		schedule, err := jobs.Parse(cfg.BackupSchedule, time.Local)
+		if err != nil {
+			slog.Error("invalid backup schedule", "schedule", cfg.BackupSchedule, "error", err)
+			os.Exit(1)
+		}
+		if err := scheduler.Register("sqlite_backup", schedule, backups.Job()); err != nil {
+			slog.Error("failed to register backup job", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.StandupEnabled {
+		location, err := time.LoadLocation(cfg.StandupTimezone)
+		if err != nil {
+			slog.Error("invalid standup timezone", "timezone", cfg.StandupTimezone, "error", err)
+			os.Exit(1)
+		}
+		standup := chatsvc.StandupConfig{
+			Hour:     cfg.StandupHour,
+			Location: location,
+			ChatIDs:  cfg.StandupChatIDs,
+			Model:    cfg.StandupModel,
+		}
+		if err := scheduler.Register("standup_digest", standup, chatService.StandupJob(standup, slog.Default().With("component", "standup"))); err != nil {
+			slog.Error("failed to register standup job", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.SQLiteCheckpointSchedule != "off" {
+		schedule, err := jobs.Parse(cfg.SQLiteCheckpointSchedule, time.Local)
+		if err != nil {
+			slog.Error("invalid checkpoint schedule", "schedule", cfg.SQLiteCheckpointSchedule, "error", err)
+			os.Exit(1)
+		}
+		if err := scheduler.Register("sqlite_checkpoint", schedule, chatService.CheckpointJob(cfg.SQLiteCheckpointMode, slog.Default().With("component", "sqlite_checkpoint"))); err != nil {
+			slog.Error("failed to register checkpoint job", "error", err)
+			os.Exit(1)
+		}
+	}
+	if err := scheduler.Register("scheduled_prompts", jobs.Every(time.Minute), chatService.ScheduledPromptsJob(slog.Default().With("component", "scheduled_prompts"))); err != nil {
+		slog.Error("failed to register scheduled prompts job", "error", err)
+		os.Exit(1)
+	}
+	if err := scheduler.Register("data_exports", jobs.Every(time.Minute), chatService.DataExportsJob(slog.Default().With("component", "data_exports"))); err != nil {
+		slog.Error("failed to register data exports job", "error", err)
+		os.Exit(1)
+	}
+	// Registered even with retention off, so a reload can turn it on.
+	if err := scheduler.Register("data_retention", jobs.Every(time.Hour), chatService.RetentionJob(slog.Default().With("component", "data_retention"))); err != nil {
+		slog.Error("failed to register data retention job", "error", err)
+		os.Exit(1)
+	}
+	scheduler.Start(serveCtx)
+	if cfg.DiscordToken != "" {
+		bot := discord.New(chatService, discord.Config{
+			Token:        cfg.DiscordToken,
+			UserID:       cfg.DiscordUserID,
+			EditInterval: cfg.DiscordEditInterval,
+			Logger:       slog.Default().With("component", "discord"),
+		})
+		go func() {
+			if err := bot.Run(ctx); err != nil {
+				slog.Error("discord bot stopped", "error", err)
+			}
+		}()
+	}
+	if telegramBot != nil {
+		go func() {
+			if err := telegramBot.Run(ctx); err != nil {
+				slog.Error("telegram bot stopped", "error", err)
+			}
+		}()
+	}
+	// Deferred after store.Close, so it runs first: jobs in progress finish
+	// before the store goes away.
+	defer func() {
+		stopServing()
+		scheduler.Wait()
+	}()
 
+	probe.Started()
 	addr := ":" + cfg.Port
-	slog.Info("starting server", "addr", addr)
-	if err := app.Run(ctx, addr); err != nil {
+	slog.Info("starting server", "addr", addr, "app_env", cfg.Env)
+	if err := app.Run(serveCtx, addr); err != nil {
 		slog.Error("server error", "error", err)
 		os.Exit(1)
 	}
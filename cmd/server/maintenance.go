@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// runStartupMaintenance reconciles state a previous process left behind
+// before the server takes traffic: runs, replies and tool calls that were
+// still in flight when it stopped.
+func runStartupMaintenance(ctx context.Context, chatService *chatsvc.Service) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	report, err := chatService.RecoverInterruptedRuns(ctx)
+	if err != nil {
+		return err
+	}
+	if report.Changed() {
+		slog.Warn("reconciled state left over from a previous process",
+			"runs_reconciled", report.RunsReconciled,
+			"runs_interrupted", report.RunsInterrupted,
+			"messages_interrupted", report.MessagesInterrupted,
+			"tool_calls_interrupted", report.ToolCallsInterrupted,
+			"partials_cleared", report.PartialsCleared,
+		)
+	}
+	return nil
+}
+
+// drainRuns stops new runs and gives the ones in flight up to window to
+// finish before they are interrupted.
+func drainRuns(chatService *chatsvc.Service, window time.Duration) {
+	active := chatService.ActiveRuns()
+	if active > 0 {
+		slog.Info("draining active runs", "runs", active, "window", window)
+	}
+	report := chatService.Drain(window)
+	if report.Interrupted > 0 {
+		slog.Warn("interrupted runs still in flight at shutdown",
+			"runs_in_flight", report.InFlight,
+			"runs_interrupted", report.Interrupted,
+			"runs_unsaved", report.Unsaved,
+		)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/pii"
+	"rhone_chat/internal/postprocess"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// reloadOnHangup applies config.Reloadable settings each time the process
+// receives SIGHUP. Settings are re-read from the RHONE_CONFIG file; the
+// environment, including values loaded from .env, is fixed for the life of
+// the process, so tunable settings belong in the file. A config that fails
+// to load leaves the running settings in place.
+func reloadOnHangup(ctx context.Context, chatService *chatsvc.Service) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+			}
+			cfg, err := config.Load()
+			if err == nil {
+				_, err = postprocess.New(cfg.OutputProcessors)
+			}
+			if err == nil {
+				_, err = pii.New(cfg.RedactPII)
+			}
+			if err != nil {
+				slog.Error("config reload failed; keeping the current settings", "error", err)
+				continue
+			}
+			if restart := chatService.Reload(cfg); len(restart) > 0 {
+				slog.Warn("config reloaded; some changed settings apply only after a restart", "settings", restart)
+				continue
+			}
+			slog.Info("config reloaded")
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+// Command storereport prints where the SQLite store spends its space: table
+// and index sizes, the largest chats and tool payloads, and integrity
+// problems.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+)
+
+func main() {
+	_ = godotenv.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	dbPath := flag.String("db", cfg.DatabasePath, "path to the SQLite database")
+	top := flag.Int("top", 10, "number of largest chats and tool calls to list")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	store, err := db.OpenSQLite(*dbPath)
+	if err != nil {
+		slog.Error("failed to open sqlite store", "error", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	report, err := store.Report(context.Background(), *top)
+	if err != nil {
+		slog.Error("failed to build store report", "error", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			slog.Error("failed to encode report", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	printReport(os.Stdout, report)
+	if len(report.IntegrityErrors) > 0 {
+		os.Exit(2)
+	}
+}
+
+func printReport(out io.Writer, report db.StoreReport) {
+	fmt.Fprintf(out, "Database: %s in %d pages, %s reclaimable by VACUUM\n\n",
+		formatBytes(report.FileBytes()), report.PageCount, formatBytes(report.FreeBytes()))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS\tSIZE")
+	for _, table := range report.Tables {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", table.Name, table.Rows, formatBytes(table.Bytes))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "INDEX\tTABLE\tSIZE")
+	for _, index := range report.Indexes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", index.Name, index.Table, formatBytes(index.Bytes))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CHAT\tTITLE\tMESSAGES\tCONTENT\tTOOL PAYLOADS")
+	for _, chat := range report.LargestChats {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", chat.ChatID, chat.Title, chat.Messages, formatBytes(chat.ContentBytes), formatBytes(chat.ToolBytes))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TOOL CALL\tTOOL\tCHAT\tINPUT\tOUTPUT")
+	for _, tool := range report.LargestTools {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", tool.ID, tool.Name, tool.ChatID, formatBytes(tool.InputBytes), formatBytes(tool.OutputBytes))
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	if len(report.IntegrityErrors) == 0 {
+		fmt.Fprintln(out, "Integrity: ok")
+		return
+	}
+	fmt.Fprintln(out, "Integrity problems:")
+	for _, problem := range report.IntegrityErrors {
+		fmt.Fprintln(out, "  "+problem)
+	}
+}
+
+func formatBytes(value int64) string {
+	const unit = 1024
+	if value < unit {
+		return fmt.Sprintf("%d B", value)
+	}
+	div, exp := int64(unit), 0
+	for n := value / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(value)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vango-go/vai-lite/pkg/core"
+	"github.com/vango-go/vai-lite/pkg/core/providers/openai"
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// AzureProvider is the provider prefix of models served from Azure OpenAI
+// deployments, as in "azure/gpt-4o".
+const AzureProvider = "azure"
+
+// DefaultAzureAPIVersion is the Azure OpenAI API version sent when none is
+// configured.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// AzureConfig points the runner at an Azure OpenAI resource. Azure routes
+// requests by deployment rather than by model, so each offered model names
+// the deployment that serves it.
+type AzureConfig struct {
+	// Endpoint is the resource's URL, such as
+	// https://contoso.openai.azure.com; empty leaves Azure out.
+	Endpoint string
+	APIKey   string
+	// APIVersion is the api-version query parameter; empty uses
+	// DefaultAzureAPIVersion.
+	APIVersion string
+	// Deployments maps each offered model, without the "azure/" prefix, to
+	// its deployment name.
+	Deployments map[string]string
+}
+
+// registerAzure adds cfg's deployments to client as AzureProvider, through
+// the Chat Completions endpoint of each. Requests go through transport when
+// it is set, like those to the other hosted providers.
+func registerAzure(client *vai.Client, cfg AzureConfig, transport *Transport) {
+	apiVersion := cfg.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+	base := http.DefaultTransport
+	if transport != nil {
+		base = transport.client.Transport
+	}
+	httpClient := &http.Client{Transport: azureTransport{base: base, apiKey: cfg.APIKey, apiVersion: apiVersion}}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	deployments := make(map[string]*openai.Provider, len(cfg.Deployments))
+	var caps core.ProviderCapabilities
+	for model, deployment := range cfg.Deployments {
+		p := openai.New(cfg.APIKey, openai.WithBaseURL(endpoint+"/openai/deployments/"+url.PathEscape(deployment)), openai.WithHTTPClient(httpClient))
+		deployments[model] = p
+		caps = core.ProviderCapabilities(p.Capabilities())
+	}
+	// Azure serves the models, not OpenAI's native tools.
+	caps.NativeTools = nil
+	deployment := func(model string) (*openai.Provider, error) {
+		p, ok := deployments[model]
+		if !ok {
+			return nil, fmt.Errorf("no azure deployment for model %q", model)
+		}
+		return p, nil
+	}
+	client.Engine().RegisterProvider(&providerAdapter{
+		name: AzureProvider,
+		caps: caps,
+		create: func(ctx context.Context, req *types.MessageRequest) (*types.MessageResponse, error) {
+			p, err := deployment(req.Model)
+			if err != nil {
+				return nil, err
+			}
+			return p.CreateMessage(ctx, req)
+		},
+		stream: func(ctx context.Context, req *types.MessageRequest) (core.EventStream, error) {
+			p, err := deployment(req.Model)
+			if err != nil {
+				return nil, err
+			}
+			events, err := p.StreamMessage(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return events, nil
+		},
+	})
+}
+
+// azureTransport turns OpenAI-style requests into Azure ones: the key goes
+// in the api-key header rather than as a bearer token, and every request
+// names the API version.
+type azureTransport struct {
+	base       http.RoundTripper
+	apiKey     string
+	apiVersion string
+}
+
+func (t azureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	req.Header.Set("api-key", t.apiKey)
+	query := req.URL.Query()
+	query.Set("api-version", t.apiVersion)
+	req.URL.RawQuery = query.Encode()
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRunnerSendsAzureModelsToTheirDeployment(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY", "ANTHROPIC_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	var path, version, key, bearer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, version = r.URL.Path, r.URL.Query().Get("api-version")
+		key, bearer = r.Header.Get("api-key"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"id\":\"1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"}}]}\n\n" +
+			"data: {\"id\":\"1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"From Azure\"}}]}\n\n" +
+			"data: {\"id\":\"1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+			"data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	runner := NewRunner(RunnerConfig{Azure: AzureConfig{
+		Endpoint:    server.URL + "/",
+		APIKey:      "azure-key",
+		Deployments: map[string]string{"gpt-4o": "prod-4o", "gpt-4o-mini": "gpt-4o-mini"},
+	}})
+	if got := runner.Models(); !slices.Equal(got, []string{"azure/gpt-4o", "azure/gpt-4o-mini"}) {
+		t.Fatalf("Models() = %v", got)
+	}
+	var text string
+	_, err := runner.Stream(context.Background(), "azure/gpt-4o", []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{
+		OnTextDelta: func(delta string) { text += delta },
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if text != "From Azure" {
+		t.Fatalf("Stream() text = %q", text)
+	}
+	if path != "/openai/deployments/prod-4o/chat/completions" || version != DefaultAzureAPIVersion || key != "azure-key" || bearer != "" {
+		t.Fatalf("Azure saw %s?api-version=%s with api-key %q and Authorization %q", path, version, key, bearer)
+	}
+}
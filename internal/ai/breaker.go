@@ -0,0 +1,179 @@
+package ai
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the cause of a run refused because its model's
+// circuit breaker is open. The StreamError it comes in is ErrOverloaded.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerConfig trips a model's circuit breaker when Failures of its last
+// Window runs failed at the provider: it was down, overloaded or timed
+// out. While open, runs of the model fail at once, or go to its entry in
+// Fallbacks, for Cooldown; then one run is let through to probe it.
+// Failures <= 0 disables the breakers.
+type BreakerConfig struct {
+	Failures  int
+	Window    int
+	Cooldown  time.Duration
+	Fallbacks map[string]string
+}
+
+// BreakerHealth describes a model's circuit breaker for administrators.
+// RecentRuns and RecentFailures count the runs since it last closed, up to
+// the window.
+type BreakerHealth struct {
+	Model          string     `json:"model"`
+	Open           bool       `json:"open"`
+	OpenUntil      *time.Time `json:"open_until,omitempty"`
+	RecentRuns     int        `json:"recent_runs"`
+	RecentFailures int        `json:"recent_failures"`
+	Trips          int64      `json:"trips"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// breakers holds a circuit breaker per model.
+type breakers struct {
+	cfg BreakerConfig
+
+	mu      sync.Mutex
+	byModel map[string]*breaker
+}
+
+// breaker is one model's circuit. outcomes holds the last runs, true for a
+// failure. It is open until openUntil; after that it is half-open, and
+// probing is set while the one run let through is in flight.
+type breaker struct {
+	outcomes  []bool
+	openUntil time.Time
+	probing   bool
+	trips     int64
+	lastError string
+}
+
+func newBreakers(cfg BreakerConfig) *breakers {
+	return &breakers{cfg: cfg, byModel: map[string]*breaker{}}
+}
+
+func (b *breakers) enabled() bool {
+	return b.cfg.Failures > 0
+}
+
+// allow returns nil when a run of model may be sent, or an ErrCircuitOpen
+// StreamError saying how long until it may.
+func (b *breakers) allow(model string, now time.Time) error {
+	if !b.enabled() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.byModel[model]
+	if state == nil || state.openUntil.IsZero() {
+		return nil
+	}
+	if now.Before(state.openUntil) || state.probing {
+		wait := max(state.openUntil.Sub(now), 0)
+		return &StreamError{
+			Model:         model,
+			ProviderModel: ResolveModel(model),
+			Stage:         "circuit breaker",
+			Kind:          ErrOverloaded,
+			RetryAfter:    wait,
+			Err:           ErrCircuitOpen,
+		}
+	}
+	state.probing = true
+	return nil
+}
+
+// record notes how a run of model that allow let through ended. Errors the
+// provider is not to blame for, such as a cancelled run or an invalid
+// request, count neither way.
+func (b *breakers) record(model string, err error, now time.Time) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.byModel[model]
+	if state == nil {
+		state = &breaker{}
+		b.byModel[model] = state
+	}
+	probe := state.probing
+	state.probing = false
+	failed := breakerFault(err)
+	if !failed && err != nil {
+		return
+	}
+	if failed {
+		state.lastError = err.Error()
+	}
+	switch {
+	case probe && failed:
+		state.openUntil = now.Add(b.cfg.Cooldown)
+		state.trips++
+	case probe:
+		state.openUntil = time.Time{}
+		state.outcomes = nil
+	default:
+		state.outcomes = append(state.outcomes, failed)
+		if len(state.outcomes) > b.cfg.Window {
+			state.outcomes = state.outcomes[len(state.outcomes)-b.cfg.Window:]
+		}
+		if countFailures(state.outcomes) >= b.cfg.Failures {
+			state.openUntil = now.Add(b.cfg.Cooldown)
+			state.outcomes = nil
+			state.trips++
+		}
+	}
+}
+
+func (b *breakers) health(now time.Time) []BreakerHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	health := make([]BreakerHealth, 0, len(b.byModel))
+	for _, model := range slices.Sorted(maps.Keys(b.byModel)) {
+		state := b.byModel[model]
+		entry := BreakerHealth{
+			Model:          model,
+			Open:           !state.openUntil.IsZero(),
+			RecentRuns:     len(state.outcomes),
+			RecentFailures: countFailures(state.outcomes),
+			Trips:          state.trips,
+			LastError:      state.lastError,
+		}
+		if now.Before(state.openUntil) {
+			openUntil := state.openUntil
+			entry.OpenUntil = &openUntil
+		}
+		health = append(health, entry)
+	}
+	return health
+}
+
+func countFailures(outcomes []bool) int {
+	failures := 0
+	for _, failed := range outcomes {
+		if failed {
+			failures++
+		}
+	}
+	return failures
+}
+
+// breakerFault reports whether err says the provider is down or not
+// keeping up, rather than that something was wrong with the request.
+func breakerFault(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrOverloaded) || errors.Is(err, ErrRunTimeout) || errors.As(err, &netErr)
+}
@@ -0,0 +1,73 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+func TestBreakerTripsOnProviderFailuresAndProbesAfterCooldown(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	model := "anthropic/claude-haiku-4-5"
+	b := newBreakers(BreakerConfig{Failures: 3, Window: 5, Cooldown: time.Minute})
+	down := wrapStreamError(model, "claude-haiku-4-5", "process", &vai.Error{Type: vai.ErrOverloaded, Message: "overloaded"})
+
+	b.record(model, down, now)
+	b.record(model, nil, now)
+	b.record(model, errors.New("invalid_request_error: max_tokens too large"), now)
+	b.record(model, context.Canceled, now)
+	b.record(model, down, now)
+	if err := b.allow(model, now); err != nil {
+		t.Fatalf("allow() after 2 provider failures = %v, want the breaker closed", err)
+	}
+	b.record(model, timeoutError(model, 90*time.Second), now)
+	err := b.allow(model, now.Add(time.Second))
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrOverloaded) || RetryAfter(err) != 59*time.Second {
+		t.Fatalf("allow() after 3 provider failures = %v, want it refused for the rest of the cooldown", err)
+	}
+	if health := b.health(now); len(health) != 1 || !health[0].Open || health[0].Trips != 1 || health[0].OpenUntil == nil {
+		t.Fatalf("health() = %+v", health)
+	}
+
+	probe := now.Add(time.Minute)
+	if err := b.allow(model, probe); err != nil {
+		t.Fatalf("allow() after the cooldown = %v, want one probe let through", err)
+	}
+	if err := b.allow(model, probe); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() during the probe = %v, want it refused", err)
+	}
+	b.record(model, down, probe)
+	if err := b.allow(model, probe.Add(30*time.Second)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() after a failed probe = %v, want the breaker open again", err)
+	}
+	if err := b.allow(model, probe.Add(time.Minute)); err != nil {
+		t.Fatalf("allow() after the second cooldown = %v", err)
+	}
+	b.record(model, nil, probe.Add(time.Minute))
+	if err := b.allow(model, probe.Add(time.Minute)); err != nil {
+		t.Fatalf("allow() after a good probe = %v, want the breaker closed", err)
+	}
+	if health := b.health(now); health[0].Open || health[0].Trips != 2 {
+		t.Fatalf("health() after a good probe = %+v", health)
+	}
+}
+
+func TestRunnerFallsBackWhileABreakerIsOpen(t *testing.T) {
+	model := "anthropic/claude-haiku-4-5"
+	runner := NewRunner(RunnerConfig{Breaker: BreakerConfig{Failures: 1, Window: 1, Cooldown: time.Minute}})
+	runner.breakers.record(model, wrapStreamError(model, model, "process", &vai.Error{Type: vai.ErrAPI, Message: "bad gateway"}), time.Now())
+
+	_, err := runner.Stream(context.Background(), model, []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{})
+	if !errors.Is(err, ErrCircuitOpen) || ErrorKind(err) != ErrorKindOverloaded {
+		t.Fatalf("Stream() with an open breaker = %v, want it to fail fast", err)
+	}
+
+	runner.cfg.Breaker.Fallbacks = map[string]string{model: "gemini/gemini-3-flash-preview"}
+	result, err := runner.Stream(context.Background(), model, []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{})
+	if result.Model != "gemini/gemini-3-flash-preview" || !errors.Is(err, ErrProviderNotConfigured) {
+		t.Fatalf("Stream() with a fallback = %+v, %v; want it sent to the fallback", result, err)
+	}
+}
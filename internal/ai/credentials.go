@@ -0,0 +1,69 @@
+package ai
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// KeyProviders are the providers a user can store an API key for, by the
+// name the client reads keys under.
+var KeyProviders = []string{"anthropic", "gemini", "openai"}
+
+// KeyProviderOf returns the KeyProviders name whose key model is sent
+// with; "oai-resp" models use the OpenAI key.
+func KeyProviderOf(model string) string {
+	if provider := ProviderOf(model); provider != "oai-resp" {
+		return provider
+	}
+	return "openai"
+}
+
+// ProviderKeys returns a user's own API keys by KeyProviders name. Providers
+// without one use the server's keys.
+type ProviderKeys func(ctx context.Context, userID string) (map[string]string, error)
+
+// userClients caches a client per user, built with the keys it was built
+// for so a changed key gets a new client.
+type userClients struct {
+	mu      sync.Mutex
+	clients map[string]userClient
+}
+
+type userClient struct {
+	keys   map[string]string
+	client *vai.Client
+}
+
+// clientFor returns the client with userID's provider keys when the user
+// has a key for model's provider, and nil when the server's keys are to be
+// used instead.
+func (r *Runner) clientFor(ctx context.Context, userID, model string) (*vai.Client, error) {
+	if userID == "" || r.cfg.ProviderKeys == nil {
+		return nil, nil
+	}
+	keys, err := r.cfg.ProviderKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	if len(keys) == 0 {
+		delete(r.users.clients, userID)
+		return nil, nil
+	}
+	if keys[KeyProviderOf(model)] == "" {
+		return nil, nil
+	}
+	if cached, ok := r.users.clients[userID]; ok && maps.Equal(cached.keys, keys) {
+		return cached.client, nil
+	}
+	client := newClient(r.cfg.Transport, keys)
+	if r.users.clients == nil {
+		r.users.clients = map[string]userClient{}
+	}
+	r.users.clients[userID] = userClient{keys: keys, client: client}
+	return client, nil
+}
@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// Provider failures the runner tells apart. A failed stream wraps one of
+// them when the provider's error says which it is, so callers check with
+// errors.Is and still get the provider's message from Error.
+var (
+	ErrRateLimited     = errors.New("rate limited by the provider")
+	ErrAuth            = errors.New("provider rejected the API key")
+	ErrOverloaded      = errors.New("provider is overloaded or unavailable")
+	ErrContextTooLong  = errors.New("conversation is too long for the model")
+	ErrContentFiltered = errors.New("blocked by the provider's content filter")
+)
+
+// Kinds of provider failure, as ErrorKind names them.
+const (
+	ErrorKindRateLimited     = "rate_limited"
+	ErrorKindAuth            = "auth"
+	ErrorKindOverloaded      = "overloaded"
+	ErrorKindContextTooLong  = "context_too_long"
+	ErrorKindContentFiltered = "content_filtered"
+)
+
+// StreamError is a stream that failed at the provider. Kind is one of the
+// errors above, or nil when the failure could not be classified;
+// RetryAfter is how long the provider asked callers to back off, if it
+// said.
+type StreamError struct {
+	Model         string
+	ProviderModel string
+	Stage         string
+	Kind          error
+	RetryAfter    time.Duration
+	Err           error
+}
+
+func (e *StreamError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("ai stream failed for model %q (provider model %q) at %s: %v: %v", e.Model, e.ProviderModel, e.Stage, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("ai stream failed for model %q (provider model %q) at %s: %v", e.Model, e.ProviderModel, e.Stage, e.Err)
+}
+
+func (e *StreamError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// ErrorKind names the kind of provider failure err is, or returns "" when
+// it is none of them.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return ErrorKindRateLimited
+	case errors.Is(err, ErrAuth):
+		return ErrorKindAuth
+	case errors.Is(err, ErrOverloaded):
+		return ErrorKindOverloaded
+	case errors.Is(err, ErrContextTooLong):
+		return ErrorKindContextTooLong
+	case errors.Is(err, ErrContentFiltered):
+		return ErrorKindContentFiltered
+	default:
+		return ""
+	}
+}
+
+// RetryableKind reports whether a failure of kind may succeed if the same
+// request is sent again later, unchanged.
+func RetryableKind(kind string) bool {
+	return kind == ErrorKindRateLimited || kind == ErrorKindOverloaded
+}
+
+// RetryAfter returns how long the provider asked to wait before retrying
+// err, or 0 when it did not say.
+func RetryAfter(err error) time.Duration {
+	var streamErr *StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.RetryAfter
+	}
+	return 0
+}
+
+// classifyProviderError says which kind of failure err is, and for how
+// long the provider asked to back off. Providers report errors in their
+// own types, so the message is checked when err is not a vai.Error that
+// says.
+func classifyProviderError(err error) (error, time.Duration) {
+	var apiErr *vai.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case vai.ErrRateLimit:
+			var retryAfter time.Duration
+			if apiErr.RetryAfter != nil {
+				retryAfter = time.Duration(*apiErr.RetryAfter) * time.Second
+			}
+			return ErrRateLimited, retryAfter
+		case vai.ErrOverloaded, vai.ErrAPI:
+			return ErrOverloaded, 0
+		case vai.ErrAuthentication, vai.ErrPermission:
+			return ErrAuth, 0
+		}
+	}
+	message := strings.ToLower(err.Error())
+	switch {
+	case containsAny(message, "context_length_exceeded", "context length", "context window", "prompt is too long", "too many tokens", "maximum number of tokens"):
+		return ErrContextTooLong, 0
+	case containsAny(message, "content_filter", "content filter", "content_policy", "content policy", "content management policy", "responsible ai", "safety settings", "blocked by safety"):
+		return ErrContentFiltered, 0
+	case containsAny(message, "rate_limit", "rate limit", "429", "quota"):
+		return ErrRateLimited, 0
+	case containsAny(message, "authentication", "invalid_api_key", "invalid api key", "401", "permission"):
+		return ErrAuth, 0
+	case containsAny(message, "overloaded", "529", "503", "502"):
+		return ErrOverloaded, 0
+	}
+	return nil, 0
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
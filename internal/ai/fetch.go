@@ -0,0 +1,228 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FetchConfig configures the fetch_url tool.
+type FetchConfig struct {
+	Timeout time.Duration
+	// MaxBytes caps the response body read from the server.
+	MaxBytes int64
+	// MaxChars caps the extracted text returned to the model.
+	MaxChars int
+	// AllowDomains, when set, restricts fetches to these domains and their
+	// subdomains. DenyDomains always wins.
+	AllowDomains []string
+	DenyDomains  []string
+	// AllowPrivateNetworks permits loopback and private addresses. Off by
+	// default so the model cannot probe internal services.
+	AllowPrivateNetworks bool
+}
+
+var ErrFetchBlocked = errors.New("url is not allowed")
+
+type fetchURLInput struct {
+	URL string `json:"url" desc:"Absolute http or https URL of the page to read"`
+}
+
+// NewFetchURLTool returns a tool that downloads a page and returns its
+// readable text.
+func NewFetchURLTool(cfg FetchConfig) Tool {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 15 * time.Second
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 2 << 20
+	}
+	if cfg.MaxChars <= 0 {
+		cfg.MaxChars = 20000
+	}
+	fetcher := &urlFetcher{cfg: cfg}
+	fetcher.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			DialContext:           fetcher.dialer().DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: cfg.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return fetcher.checkURL(req.URL)
+		},
+	}
+	tool := NewTool("fetch_url", "Fetch a web page by URL and return its readable text. Use it to read a specific page the user mentions or a search result.", func(ctx context.Context, input fetchURLInput) (any, error) {
+		return fetcher.fetch(ctx, input.URL)
+	})
+	tool.Timeout = cfg.Timeout
+	tool.Source = ToolSourceConfig
+	return tool
+}
+
+type urlFetcher struct {
+	cfg    FetchConfig
+	client *http.Client
+}
+
+func (f *urlFetcher) dialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if f.cfg.AllowPrivateNetworks {
+		return dialer
+	}
+	// Checking the resolved address at dial time also covers DNS names that
+	// point at internal hosts.
+	dialer.Control = func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+			return fmt.Errorf("%w: %s is a private address", ErrFetchBlocked, host)
+		}
+		return nil
+	}
+	return dialer
+}
+
+func (f *urlFetcher) checkURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%w: only http and https are supported", ErrFetchBlocked)
+	}
+	host := strings.ToLower(strings.TrimSuffix(target.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrFetchBlocked)
+	}
+	if matchesDomain(host, f.cfg.DenyDomains) {
+		return fmt.Errorf("%w: %s is denied", ErrFetchBlocked, host)
+	}
+	if len(f.cfg.AllowDomains) > 0 && !matchesDomain(host, f.cfg.AllowDomains) {
+		return fmt.Errorf("%w: %s is not in the allow list", ErrFetchBlocked, host)
+	}
+	return nil
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *urlFetcher) fetch(ctx context.Context, rawURL string) (string, error) {
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	if err := f.checkURL(target); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "rhone_chat-fetch/1.0")
+	req.Header.Set("Accept", "text/html, text/plain, application/json;q=0.9, */*;q=0.1")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("fetch %s: status %d", target, resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "" && !isTextMediaType(mediaType) {
+		return "", fmt.Errorf("fetch %s: unsupported content type %s", target, mediaType)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", target, err)
+	}
+	truncated := int64(len(body)) > f.cfg.MaxBytes
+	if truncated {
+		body = body[:f.cfg.MaxBytes]
+	}
+
+	title := ""
+	text := string(body)
+	if mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		title, text = htmlToText(text)
+	}
+	if len(text) > f.cfg.MaxChars {
+		text = text[:f.cfg.MaxChars]
+		truncated = true
+	}
+
+	var out strings.Builder
+	out.WriteString("URL: " + resp.Request.URL.String() + "\n")
+	if title != "" {
+		out.WriteString("Title: " + title + "\n")
+	}
+	out.WriteString("\n" + text)
+	if truncated {
+		out.WriteString("\n\n[content truncated]")
+	}
+	return out.String(), nil
+}
+
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xhtml+xml" ||
+		mediaType == "application/xml"
+}
+
+var (
+	htmlDropBlocks = regexp.MustCompile(`(?is)<(script|style|noscript|svg|template|head|title)\b.*?</(script|style|noscript|svg|template|head|title)\s*>`)
+	htmlTitle      = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title\s*>`)
+	htmlComments   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBreaks     = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/h[1-6]|/section|/article|/header|/footer|/blockquote|/pre)\b[^>]*>`)
+	htmlListItems  = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	htmlTags       = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRuns      = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLines     = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// htmlToText extracts the title and readable text of an HTML document. It
+// is a lightweight approximation: scripts, styles and markup are dropped and
+// block elements become line breaks.
+func htmlToText(document string) (string, string) {
+	title := ""
+	if match := htmlTitle.FindStringSubmatch(document); match != nil {
+		title = strings.TrimSpace(spaceRuns.ReplaceAllString(html.UnescapeString(htmlTags.ReplaceAllString(match[1], "")), " "))
+	}
+	text := htmlComments.ReplaceAllString(document, "")
+	text = htmlDropBlocks.ReplaceAllString(text, "")
+	text = htmlListItems.ReplaceAllString(text, "\n- ")
+	text = htmlBreaks.ReplaceAllString(text, "\n")
+	text = htmlTags.ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+	text = spaceRuns.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for index, line := range lines {
+		lines[index] = strings.TrimSpace(line)
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return title, strings.TrimSpace(text)
+}
@@ -0,0 +1,95 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+func TestHTMLToTextDropsMarkupAndScripts(t *testing.T) {
+	title, text := htmlToText(`<html><head><title>Hello &amp; welcome</title><style>p{}</style></head>
+<body><script>alert(1)</script><h1>Heading</h1><p>First   paragraph</p><ul><li>One</li><li>Two</li></ul></body></html>`)
+	if title != "Hello & welcome" {
+		t.Fatalf("title = %q", title)
+	}
+	want := "Heading\nFirst paragraph\n\n- One\n- Two"
+	if text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+}
+
+func TestFetchURLToolReadsPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<title>Doc</title><p>" + strings.Repeat("a", 100) + "</p>"))
+	}))
+	defer server.Close()
+
+	tool := NewFetchURLTool(FetchConfig{MaxChars: 10, AllowPrivateNetworks: true})
+	output, err := tool.Handler(context.Background(), json.RawMessage(`{"url":"`+server.URL+`"}`))
+	if err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+	text := output.(string)
+	if !strings.Contains(text, "Title: Doc") || !strings.Contains(text, "aaaaaaaaaa\n\n[content truncated]") {
+		t.Fatalf("output = %q", text)
+	}
+}
+
+func TestFetchURLToolBlocksPrivateAndDeniedHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	tool := NewFetchURLTool(FetchConfig{})
+	_, err := tool.Handler(context.Background(), json.RawMessage(`{"url":"`+server.URL+`"}`))
+	if !errors.Is(err, ErrFetchBlocked) {
+		t.Fatalf("private fetch error = %v, want ErrFetchBlocked", err)
+	}
+
+	denied := NewFetchURLTool(FetchConfig{DenyDomains: []string{"example.com"}})
+	_, err = denied.Handler(context.Background(), json.RawMessage(`{"url":"https://docs.example.com/page"}`))
+	if !errors.Is(err, ErrFetchBlocked) {
+		t.Fatalf("denied fetch error = %v, want ErrFetchBlocked", err)
+	}
+
+	allowOnly := NewFetchURLTool(FetchConfig{AllowDomains: []string{"go.dev"}})
+	_, err = allowOnly.Handler(context.Background(), json.RawMessage(`{"url":"ftp://go.dev/file"}`))
+	if !errors.Is(err, ErrFetchBlocked) {
+		t.Fatalf("scheme error = %v, want ErrFetchBlocked", err)
+	}
+	_, err = allowOnly.Handler(context.Background(), json.RawMessage(`{"url":"https://example.org"}`))
+	if !errors.Is(err, ErrFetchBlocked) {
+		t.Fatalf("allow list error = %v, want ErrFetchBlocked", err)
+	}
+}
+
+func TestToolSourcesReadsFetchedPagesAndSearchResults(t *testing.T) {
+	fetched := contentBlocksToText([]vai.ContentBlock{vai.Text("URL: https://example.com/doc\nTitle: Doc\n\nbody")})
+	if got := ToolSources(fetched); len(got) != 1 || got[0] != (Source{URL: "https://example.com/doc", Title: "Doc"}) {
+		t.Fatalf("ToolSources(fetch) = %+v", got)
+	}
+
+	searched := `{"type":"web_search_tool_result","tool_use_id":"ws_1","content":[` +
+		`{"type":"web_search_result","url":"https://a.example","title":"A"},` +
+		`{"type":"web_search_result","url":"https://b.example","title":"B"},` +
+		`{"type":"web_search_result","url":"https://a.example","title":"A again"}]}`
+	got := ToolSources(searched)
+	if len(got) != 2 || got[0].URL != "https://a.example" || got[1].Title != "B" {
+		t.Fatalf("ToolSources(search) = %+v", got)
+	}
+
+	if got := ToolSources(`{"type":"text","text":"42"}`); got != nil {
+		t.Fatalf("ToolSources(plain) = %+v, want none", got)
+	}
+	if got := ToolSources(`{"type":"web_search_tool_result","content":[{"url":"https://trunc`); got != nil {
+		t.Fatalf("ToolSources(truncated) = %+v, want none", got)
+	}
+}
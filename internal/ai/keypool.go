@@ -0,0 +1,175 @@
+package ai
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// Key selection strategies for providers with several server keys.
+const (
+	// KeyRoundRobin spreads requests over the healthy keys in turn.
+	KeyRoundRobin = "round-robin"
+	// KeyFailover sends every request with the first healthy key and only
+	// moves down the list when it fails.
+	KeyFailover = "failover"
+)
+
+// How long a key is skipped after a failure that is its fault. A rate
+// limit's Retry-After replaces rateLimitCooldown when the provider sends
+// one.
+const (
+	rateLimitCooldown   = 30 * time.Second
+	unavailableCooldown = 15 * time.Second
+	rejectedCooldown    = 10 * time.Minute
+)
+
+// keyFault is why a request failed, as far as its key is concerned.
+type keyFault int
+
+const (
+	// faultNone is a failure another key would not fix, such as an
+	// invalid request.
+	faultNone keyFault = iota
+	faultRateLimited
+	faultUnavailable
+	faultRejected
+)
+
+// KeyHealth describes one server key of a provider for administrators. Key
+// is the key's last four characters.
+type KeyHealth struct {
+	Provider     string     `json:"provider"`
+	Key          string     `json:"key"`
+	Requests     int64      `json:"requests"`
+	Failures     int64      `json:"failures"`
+	Healthy      bool       `json:"healthy"`
+	CoolingUntil *time.Time `json:"cooling_until,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// keyPool holds the clients for a provider's server keys and tracks how
+// each key is doing.
+type keyPool struct {
+	provider string
+	strategy string
+
+	mu    sync.Mutex
+	next  int
+	slots []*keySlot
+}
+
+type keySlot struct {
+	hint      string
+	client    *vai.Client
+	requests  int64
+	failures  int64
+	coolUntil time.Time
+	lastError string
+}
+
+func newKeyPool(provider, strategy string, keys []string, transport *Transport) *keyPool {
+	pool := &keyPool{provider: provider, strategy: strategy}
+	for _, key := range keys {
+		hint := key
+		if len(hint) > 4 {
+			hint = hint[len(hint)-4:]
+		}
+		pool.slots = append(pool.slots, &keySlot{hint: hint, client: newClient(transport, map[string]string{provider: key})})
+	}
+	return pool
+}
+
+// order returns the slots to try for a request: the healthy ones in
+// strategy order, then the cooling ones, soonest to recover first, so a
+// request still goes out when every key is cooling.
+func (p *keyPool) order(now time.Time) []*keySlot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := 0
+	if p.strategy != KeyFailover {
+		start = p.next % len(p.slots)
+		p.next++
+	}
+	var healthy, cooling []*keySlot
+	for i := range p.slots {
+		slot := p.slots[(start+i)%len(p.slots)]
+		if now.Before(slot.coolUntil) {
+			cooling = append(cooling, slot)
+			continue
+		}
+		healthy = append(healthy, slot)
+	}
+	slices.SortStableFunc(cooling, func(a, b *keySlot) int {
+		return a.coolUntil.Compare(b.coolUntil)
+	})
+	return append(healthy, cooling...)
+}
+
+// record notes the outcome of a request sent with slot.
+func (p *keyPool) record(slot *keySlot, err error, now time.Time) keyFault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slot.requests++
+	if err == nil {
+		slot.coolUntil = time.Time{}
+		return faultNone
+	}
+	fault, retryAfter := classifyKeyError(err)
+	if fault == faultNone {
+		return faultNone
+	}
+	slot.failures++
+	slot.lastError = err.Error()
+	switch fault {
+	case faultRateLimited:
+		if retryAfter <= 0 {
+			retryAfter = rateLimitCooldown
+		}
+		slot.coolUntil = now.Add(retryAfter)
+	case faultUnavailable:
+		slot.coolUntil = now.Add(unavailableCooldown)
+	case faultRejected:
+		slot.coolUntil = now.Add(rejectedCooldown)
+	}
+	return fault
+}
+
+func (p *keyPool) health(now time.Time) []KeyHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	health := make([]KeyHealth, 0, len(p.slots))
+	for _, slot := range p.slots {
+		entry := KeyHealth{
+			Provider:  p.provider,
+			Key:       slot.hint,
+			Requests:  slot.requests,
+			Failures:  slot.failures,
+			Healthy:   !now.Before(slot.coolUntil),
+			LastError: slot.lastError,
+		}
+		if !entry.Healthy {
+			until := slot.coolUntil
+			entry.CoolingUntil = &until
+		}
+		health = append(health, entry)
+	}
+	return health
+}
+
+// classifyKeyError says whether err is one another key could avoid, and
+// for how long the provider asked to back off.
+func classifyKeyError(err error) (keyFault, time.Duration) {
+	kind, retryAfter := classifyProviderError(err)
+	switch kind {
+	case ErrRateLimited:
+		return faultRateLimited, retryAfter
+	case ErrOverloaded:
+		return faultUnavailable, 0
+	case ErrAuth:
+		return faultRejected, 0
+	}
+	return faultNone, 0
+}
@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+func TestKeyPoolRotatesAndSkipsFailingKeys(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	pool := newKeyPool("openai", KeyRoundRobin, []string{"sk-one-1111", "sk-two-2222", "sk-three-3333"}, nil)
+	hints := func(slots []*keySlot) []string {
+		out := make([]string, len(slots))
+		for i, slot := range slots {
+			out[i] = slot.hint
+		}
+		return out
+	}
+	if got := hints(pool.order(now)); got[0] != "1111" || got[1] != "2222" {
+		t.Fatalf("first order = %v", got)
+	}
+	if got := hints(pool.order(now)); got[0] != "2222" || got[2] != "1111" {
+		t.Fatalf("second order = %v, want the next key first", got)
+	}
+
+	two := pool.slots[1]
+	if fault := pool.record(two, vai.NewRateLimitError("slow down", 60), now); fault != faultRateLimited {
+		t.Fatalf("record(rate limit) = %v", fault)
+	}
+	if fault := pool.record(pool.slots[2], errors.New("ai stream failed: authentication_error: invalid x-api-key"), now); fault != faultRejected {
+		t.Fatalf("record(auth) = %v", fault)
+	}
+	if fault := pool.record(pool.slots[0], errors.New("invalid_request_error: max_tokens too large"), now); fault != faultNone {
+		t.Fatalf("record(bad request) = %v, want no fault of the key's", fault)
+	}
+	if got := hints(pool.order(now.Add(time.Second))); got[0] != "1111" || got[1] != "2222" || got[2] != "3333" {
+		t.Fatalf("order with cooling keys = %v, want the healthy key, then the soonest to recover", got)
+	}
+	health := pool.health(now.Add(time.Second))
+	if !health[0].Healthy || health[1].Healthy || health[1].CoolingUntil == nil || !health[1].CoolingUntil.Equal(now.Add(time.Minute)) || health[1].Failures != 1 {
+		t.Fatalf("health = %+v", health)
+	}
+	if got := hints(pool.order(now.Add(2 * time.Minute))); len(got) != 3 || got[2] != "3333" {
+		t.Fatalf("order after the rate limit = %v, want the rejected key still last", got)
+	}
+
+	failover := newKeyPool("anthropic", KeyFailover, []string{"sk-a-aaaa", "sk-b-bbbb"}, nil)
+	for range 2 {
+		if got := hints(failover.order(now)); got[0] != "aaaa" {
+			t.Fatalf("failover order = %v, want the first key every time", got)
+		}
+	}
+}
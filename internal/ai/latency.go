@@ -0,0 +1,87 @@
+package ai
+
+import "time"
+
+// Latency is how a run's output arrived. FirstToken is the wait from
+// sending the request to the first text or reasoning, zero when nothing
+// streamed; Stream runs to the end of the stream, tool calls included.
+type Latency struct {
+	FirstToken time.Duration `json:"first_token_ns"`
+	Stream     time.Duration `json:"stream_ns"`
+	Tools      []ToolLatency `json:"tools,omitempty"`
+}
+
+// ToolLatency is how long one tool call took, in the order they finished.
+type ToolLatency struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// TokensPerSecond is the rate outputTokens streamed at after the first
+// token, or 0 when that is unknown.
+func (l Latency) TokensPerSecond(outputTokens int) float64 {
+	generating := l.Stream - l.FirstToken
+	if l.FirstToken <= 0 || generating <= 0 || outputTokens <= 0 {
+		return 0
+	}
+	return float64(outputTokens) / generating.Seconds()
+}
+
+// latencyClock times a stream through its callbacks, which the runner
+// never calls concurrently.
+type latencyClock struct {
+	start   time.Time
+	first   time.Duration
+	started map[string]time.Time
+	tools   []ToolLatency
+}
+
+func newLatencyClock() *latencyClock {
+	return &latencyClock{start: time.Now(), started: map[string]time.Time{}}
+}
+
+// watch returns callbacks that note when output and tool calls arrive
+// before passing them on.
+func (c *latencyClock) watch(callbacks StreamCallbacks) StreamCallbacks {
+	watched := callbacks
+	firstToken := func() {
+		if c.first == 0 {
+			c.first = time.Since(c.start)
+		}
+	}
+	watched.OnTextDelta = func(delta string) {
+		firstToken()
+		if callbacks.OnTextDelta != nil {
+			callbacks.OnTextDelta(delta)
+		}
+	}
+	watched.OnThinkingDelta = func(delta string) {
+		firstToken()
+		if callbacks.OnThinkingDelta != nil {
+			callbacks.OnThinkingDelta(delta)
+		}
+	}
+	watched.OnToolStart = func(update ToolCallUpdate) {
+		if _, ok := c.started[update.ID]; !ok {
+			c.started[update.ID] = time.Now()
+		}
+		if callbacks.OnToolStart != nil {
+			callbacks.OnToolStart(update)
+		}
+	}
+	watched.OnToolResult = func(update ToolCallUpdate) {
+		if startedAt, ok := c.started[update.ID]; ok {
+			delete(c.started, update.ID)
+			c.tools = append(c.tools, ToolLatency{ID: update.ID, Name: update.Name, Duration: time.Since(startedAt)})
+		}
+		if callbacks.OnToolResult != nil {
+			callbacks.OnToolResult(update)
+		}
+	}
+	return watched
+}
+
+func (c *latencyClock) latency() Latency {
+	return Latency{FirstToken: c.first, Stream: time.Since(c.start), Tools: c.tools}
+}
@@ -0,0 +1,52 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MockModel answers locally without calling a provider. It is offered when
+// RunnerConfig.MockModel is set or when no provider key is configured, so a
+// fresh install can be tried end to end.
+const MockModel = "mock/echo"
+
+// mockChunkDelay paces the mock reply so it streams like a real model.
+const mockChunkDelay = 15 * time.Millisecond
+
+// streamMock echoes the last user message. With an OutputSchema, a message
+// that is JSON comes back as it is, so structured output can be tried
+// offline.
+func (r *Runner) streamMock(ctx context.Context, messages []Message, options StreamOptions, callbacks StreamCallbacks) (StreamResult, error) {
+	lastUser := ""
+	for _, message := range messages {
+		if message.Role == "user" {
+			lastUser = message.Content
+		}
+	}
+	var reply string
+	if options.OutputSchema != nil && json.Valid([]byte(lastUser)) {
+		reply = strings.TrimSpace(lastUser)
+	} else {
+		if len(lastUser) > 500 {
+			lastUser = lastUser[:500] + "..."
+		}
+		reply = fmt.Sprintf("This reply comes from the offline mock model; nothing was sent to a provider.\n\nYou said:\n\n> %s", strings.ReplaceAll(strings.TrimSpace(lastUser), "\n", "\n> "))
+	}
+
+	meter := newUsageMeter(callbacks.OnUsage)
+	for _, word := range strings.SplitAfter(reply, " ") {
+		select {
+		case <-ctx.Done():
+			return StreamResult{}, ctx.Err()
+		case <-time.After(mockChunkDelay):
+		}
+		if callbacks.OnTextDelta != nil {
+			callbacks.OnTextDelta(word)
+		}
+		meter.streamed(word)
+	}
+	return StreamResult{StopReason: "end_turn", TurnCount: 1}, nil
+}
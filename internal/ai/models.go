@@ -1,15 +1,67 @@
 package ai
 
+import "strings"
+
 var AllowedModels = []string{
 	"oai-resp/gpt-5-mini",
 	"gemini/gemini-3-flash-preview",
 	"anthropic/claude-haiku-4-5",
 }
 
+// providerKeyEnv names the environment variable holding each provider's
+// API key, for setup hints.
+var providerKeyEnv = map[string]string{
+	"oai-resp":  "OPENAI_API_KEY",
+	"gemini":    "GEMINI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+}
+
+// ProviderOf returns the provider prefix of a "provider/model" name.
+func ProviderOf(model string) string {
+	provider, _, _ := strings.Cut(model, "/")
+	return provider
+}
+
+// ProviderKeyEnv returns the API key variable model's provider reads.
+func ProviderKeyEnv(model string) string {
+	return providerKeyEnv[ProviderOf(model)]
+}
+
 var canonicalModelMap = map[string]string{
 	"anthropic/claude-haiku-4-5": "anthropic/claude-haiku-4-5-20251001",
 }
 
+// visionModels accept image content blocks.
+var visionModels = map[string]bool{
+	"oai-resp/gpt-5-mini":           true,
+	"gemini/gemini-3-flash-preview": true,
+	"anthropic/claude-haiku-4-5":    true,
+}
+
+// contextWindows is each model's context window in tokens.
+var contextWindows = map[string]int{
+	"oai-resp/gpt-5-mini":           400000,
+	"gemini/gemini-3-flash-preview": 1048576,
+	"anthropic/claude-haiku-4-5":    200000,
+	MockModel:                       8192,
+}
+
+// DefaultContextWindow is assumed for models missing from the catalog.
+const DefaultContextWindow = 128000
+
+// ContextWindow returns model's context window in tokens.
+func ContextWindow(model string) int {
+	if window, ok := contextWindows[model]; ok {
+		return window
+	}
+	return DefaultContextWindow
+}
+
+// SupportsVision reports whether model accepts image input.
+func SupportsVision(model string) bool {
+	return visionModels[model]
+}
+
 func IsAllowedModel(model string) bool {
 	for _, candidate := range AllowedModels {
 		if model == candidate {
@@ -1,6 +1,10 @@
 package ai
 
-import "testing"
+import (
+	"math"
+	"testing"
+	"unicode/utf8"
+)
 
 func TestResolveModelAnthropicAlias(t *testing.T) {
 	got := ResolveModel("anthropic/claude-haiku-4-5")
@@ -17,3 +21,37 @@ func TestResolveModelNoAlias(t *testing.T) {
 		t.Fatalf("ResolveModel() = %q, want %q", got, want)
 	}
 }
+
+func TestEstimateTokensAndTruncate(t *testing.T) {
+	if got := EstimateTokens("abcdefgh"); got != 2 {
+		t.Fatalf("EstimateTokens(ascii) = %d, want 2", got)
+	}
+	if got := EstimateTokens("日本語"); got != 3 {
+		t.Fatalf("EstimateTokens(cjk) = %d, want 3", got)
+	}
+	text := "hello 世界 and more words here"
+	truncated := TruncateToTokens(text, 4)
+	if EstimateTokens(truncated) > 4 || len(truncated) >= len(text) || !utf8.ValidString(truncated) {
+		t.Fatalf("TruncateToTokens() = %q, want a valid prefix within 4 tokens", truncated)
+	}
+	if ContextWindow("unknown/model") != DefaultContextWindow || ContextWindow("anthropic/claude-haiku-4-5") != 200000 {
+		t.Fatalf("ContextWindow() did not use the catalog")
+	}
+}
+
+func TestCostUSDPrefersReportedCostThenListPrice(t *testing.T) {
+	reported := 0.5
+	if cost, ok := CostUSD("anthropic/claude-haiku-4-5", Usage{InputTokens: 1000, CostUSD: &reported}); !ok || cost != 0.5 {
+		t.Fatalf("CostUSD(reported) = %v, %v; want 0.5", cost, ok)
+	}
+	cost, ok := CostUSD("anthropic/claude-haiku-4-5", &Usage{InputTokens: 1_000_000, OutputTokens: 200_000})
+	if !ok || math.Abs(cost-2) > 1e-9 {
+		t.Fatalf("CostUSD(list price) = %v, %v; want 2", cost, ok)
+	}
+	if _, ok := CostUSD("unknown/model", Usage{InputTokens: 10}); ok {
+		t.Fatal("CostUSD(unknown model) ok = true")
+	}
+	if _, ok := CostUSD(MockModel, nil); ok {
+		t.Fatal("CostUSD(nil usage) ok = true")
+	}
+}
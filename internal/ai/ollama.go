@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vango-go/vai-lite/pkg/core"
+	"github.com/vango-go/vai-lite/pkg/core/providers/openai"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// OllamaProvider is the provider prefix of models served by a local Ollama
+// server, as in "ollama/llama3.2". They need no API key and cost nothing.
+const OllamaProvider = "ollama"
+
+// OllamaConfig points the runner at an Ollama server so chats can run on
+// local models without reaching a provider.
+type OllamaConfig struct {
+	// URL is the server's address, such as http://localhost:11434; empty
+	// leaves Ollama out.
+	URL string
+	// Models are the model names offered, without the "ollama/" prefix.
+	Models []string
+}
+
+// OllamaModels lists the models pulled on the Ollama server at baseURL, for
+// offering all of them when none are configured.
+func OllamaModels(ctx context.Context, baseURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama returned %s", resp.Status)
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("decode ollama models: %w", err)
+	}
+	models := make([]string, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		if model.Name != "" {
+			models = append(models, model.Name)
+		}
+	}
+	return models, nil
+}
+
+// registerOllama adds the Ollama server at baseURL to client as
+// OllamaProvider, through its OpenAI-compatible chat endpoint. It goes
+// direct rather than through the runner's Transport, which is for reaching
+// remote providers.
+func registerOllama(client *vai.Client, baseURL string) {
+	httpClient := &http.Client{Transport: ollamaTransport{base: http.DefaultTransport}}
+	p := openai.New(OllamaProvider, openai.WithBaseURL(strings.TrimRight(baseURL, "/")+"/v1"), openai.WithHTTPClient(httpClient))
+	caps := core.ProviderCapabilities(p.Capabilities())
+	// Web search and the other native tools are OpenAI's, not Ollama's.
+	caps.NativeTools = nil
+	client.Engine().RegisterProvider(sdkProvider(OllamaProvider, caps, p.CreateMessage, p.StreamMessage))
+}
+
+// ollamaLeadChunk is an empty first chunk in the shape OpenAI starts its
+// streams with.
+const ollamaLeadChunk = "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"}}]}\n\n"
+
+// ollamaTransport puts ollamaLeadChunk ahead of each stream. The OpenAI
+// stream reader spends the first chunk on starting the message, and Ollama,
+// unlike OpenAI, already puts the first token in it.
+type ollamaTransport struct {
+	base http.RoundTripper
+}
+
+func (t ollamaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(strings.NewReader(ollamaLeadChunk), resp.Body), resp.Body}
+	return resp, nil
+}
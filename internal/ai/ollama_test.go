@@ -0,0 +1,60 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRunnerStreamsFromALocalOllamaServer(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY", "ANTHROPIC_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	var sent struct {
+		Model string `json:"model"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3.2:latest"},{"name":"qwen3:8b"}]}`))
+		case "/v1/chat/completions":
+			json.NewDecoder(r.Body).Decode(&sent)
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"model\":\"qwen3:8b\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hello offline\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"model\":\"qwen3:8b\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	models, err := OllamaModels(context.Background(), server.URL)
+	if err != nil || !slices.Equal(models, []string{"llama3.2:latest", "qwen3:8b"}) {
+		t.Fatalf("OllamaModels() = %v, %v", models, err)
+	}
+	runner := NewRunner(RunnerConfig{Ollama: OllamaConfig{URL: server.URL + "/", Models: models}})
+	if got := runner.Models(); !slices.Equal(got, []string{"ollama/llama3.2:latest", "ollama/qwen3:8b"}) {
+		t.Fatalf("Models() = %v, want the local models and no mock model", got)
+	}
+	if runner.IsAllowedModel("ollama/mistral") {
+		t.Fatal("IsAllowedModel() accepts a local model that is not configured")
+	}
+
+	var text string
+	_, err = runner.Stream(context.Background(), "ollama/qwen3:8b", []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{
+		OnTextDelta: func(delta string) { text += delta },
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if text != "Hello offline" || sent.Model != "qwen3:8b" {
+		t.Fatalf("Stream() text = %q, sent model %q", text, sent.Model)
+	}
+	if cost, ok := CostUSD("ollama/qwen3:8b", Usage{InputTokens: 1000, OutputTokens: 1000}); !ok || cost != 0 {
+		t.Fatalf("CostUSD(local) = %v, %v; want free", cost, ok)
+	}
+}
@@ -0,0 +1,165 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// DefaultParallelTools is how many of a turn's tool calls run at once when
+// RunnerConfig.ParallelTools is not set.
+const DefaultParallelTools = 4
+
+// toolBatch runs the function tool calls of a turn concurrently. The SDK
+// calls a turn's handlers one after another, so the batch starts every call
+// as soon as the model's response is complete and each handler only collects
+// its call's result. Results are reported as the calls finish rather than
+// in the order the SDK gets to them.
+type toolBatch struct {
+	ctx      context.Context
+	handlers map[string]vai.ToolHandler
+	slots    chan struct{}
+	maxCalls int
+	report   func(ToolCallUpdate)
+
+	mu       sync.Mutex
+	calls    int
+	pending  map[string][]*batchCall
+	reported map[string]bool
+}
+
+type batchCall struct {
+	input  string
+	done   chan struct{}
+	output any
+	err    error
+}
+
+// newToolBatch returns a batch running up to parallel calls at once under
+// ctx. maxCalls is the run's tool call limit, past which the SDK ends the
+// run without executing the turn's calls.
+func newToolBatch(ctx context.Context, handlers map[string]vai.ToolHandler, parallel, maxCalls int, report func(ToolCallUpdate)) *toolBatch {
+	if parallel < 1 {
+		parallel = DefaultParallelTools
+	}
+	return &toolBatch{
+		ctx:      ctx,
+		handlers: handlers,
+		slots:    make(chan struct{}, parallel),
+		maxCalls: maxCalls,
+		report:   report,
+		pending:  map[string][]*batchCall{},
+		reported: map[string]bool{},
+	}
+}
+
+// start is the SDK's after-response hook: it starts the calls of a turn
+// that asks for more than one.
+func (b *toolBatch) start(resp *vai.Response) {
+	if resp == nil || resp.MessageResponse == nil || resp.StopReason != types.StopReasonToolUse {
+		return
+	}
+	uses := resp.ToolUses()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls += len(uses)
+	if len(uses) < 2 || cap(b.slots) < 2 || (b.maxCalls > 0 && b.calls > b.maxCalls) {
+		return
+	}
+	for _, use := range uses {
+		handler, ok := b.handlers[use.Name]
+		if !ok {
+			continue
+		}
+		// The SDK hands its handler the input encoded the same way, which
+		// is how the handler finds the call again.
+		input, err := json.Marshal(use.Input)
+		if err != nil {
+			continue
+		}
+		call := &batchCall{input: string(input), done: make(chan struct{})}
+		b.pending[use.Name] = append(b.pending[use.Name], call)
+		go b.run(use, handler, call)
+	}
+}
+
+func (b *toolBatch) run(use types.ToolUseBlock, handler vai.ToolHandler, call *batchCall) {
+	defer close(call.done)
+	select {
+	case b.slots <- struct{}{}:
+		call.output, call.err = handler(b.ctx, json.RawMessage(call.input))
+		<-b.slots
+	case <-b.ctx.Done():
+		call.err = b.ctx.Err()
+	}
+	b.mu.Lock()
+	b.reported[use.ID] = true
+	b.mu.Unlock()
+	if b.report == nil {
+		return
+	}
+	// The same text the SDK would report for the call.
+	update := ToolCallUpdate{ID: use.ID, Name: use.Name, Status: "completed"}
+	if call.err != nil {
+		update.Status = "error"
+		update.ErrText = call.err.Error()
+		update.Output = contentBlocksToText([]vai.ContentBlock{vai.Text(fmt.Sprintf("Error executing tool: %v", call.err))})
+	} else {
+		update.Output = contentBlocksToText(toolOutputBlocks(call.output))
+	}
+	b.report(update)
+}
+
+// handler returns the SDK handler for the named tool, which waits for the
+// batch's call when there is one and runs the tool itself otherwise.
+func (b *toolBatch) handler(name string) vai.ToolHandler {
+	return func(ctx context.Context, input json.RawMessage) (any, error) {
+		if call := b.take(name, string(input)); call != nil {
+			<-call.done
+			return call.output, call.err
+		}
+		return b.handlers[name](ctx, input)
+	}
+}
+
+func (b *toolBatch) take(name, input string) *batchCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, call := range b.pending[name] {
+		if call.input == input {
+			b.pending[name] = append(b.pending[name][:i:i], b.pending[name][i+1:]...)
+			return call
+		}
+	}
+	return nil
+}
+
+// wasReported reports whether the batch already reported the call's result,
+// so the SDK's own events for it are not passed on again.
+func (b *toolBatch) wasReported(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reported[id]
+}
+
+// toolOutputBlocks converts a handler's output the way the SDK does.
+func toolOutputBlocks(output any) []vai.ContentBlock {
+	switch v := output.(type) {
+	case string:
+		return []vai.ContentBlock{vai.Text(v)}
+	case []vai.ContentBlock:
+		return v
+	case vai.ContentBlock:
+		return []vai.ContentBlock{v}
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return []vai.ContentBlock{vai.Text(fmt.Sprintf("%v", v))}
+		}
+		return []vai.ContentBlock{vai.Text(string(encoded))}
+	}
+}
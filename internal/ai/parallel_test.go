@@ -0,0 +1,95 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+func toolUseResponse(uses ...types.ToolUseBlock) *vai.Response {
+	content := make([]types.ContentBlock, 0, len(uses))
+	for _, use := range uses {
+		content = append(content, use)
+	}
+	return &vai.Response{MessageResponse: &types.MessageResponse{Content: content, StopReason: types.StopReasonToolUse}}
+}
+
+func TestToolBatchRunsATurnsCallsConcurrently(t *testing.T) {
+	handlers := map[string]vai.ToolHandler{
+		"sleep": func(_ context.Context, input json.RawMessage) (any, error) {
+			var args struct {
+				Ms int `json:"ms"`
+			}
+			_ = json.Unmarshal(input, &args)
+			time.Sleep(time.Duration(args.Ms) * time.Millisecond)
+			return map[string]int{"slept": args.Ms}, nil
+		},
+	}
+	var (
+		mu       sync.Mutex
+		reported []string
+	)
+	batch := newToolBatch(context.Background(), handlers, 2, 0, func(update ToolCallUpdate) {
+		mu.Lock()
+		defer mu.Unlock()
+		if update.Status != "completed" || !strings.Contains(update.Output, "slept") {
+			t.Errorf("update = %+v", update)
+		}
+		reported = append(reported, update.ID)
+	})
+
+	started := time.Now()
+	batch.start(toolUseResponse(
+		types.ToolUseBlock{Type: "tool_use", ID: "slow", Name: "sleep", Input: map[string]any{"ms": 300}},
+		types.ToolUseBlock{Type: "tool_use", ID: "fast", Name: "sleep", Input: map[string]any{"ms": 100}},
+	))
+	// The SDK then calls the handlers in the model's order.
+	handler := batch.handler("sleep")
+	for _, input := range []string{`{"ms":300}`, `{"ms":100}`} {
+		if _, err := handler(context.Background(), json.RawMessage(input)); err != nil {
+			t.Fatalf("handler(%s) error = %v", input, err)
+		}
+	}
+	if elapsed := time.Since(started); elapsed > 350*time.Millisecond {
+		t.Fatalf("calls took %v, want them to overlap", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"fast", "slow"}
+	if len(reported) != len(want) || reported[0] != want[0] || reported[1] != want[1] {
+		t.Fatalf("reported = %v, want %v in completion order", reported, want)
+	}
+	if !batch.wasReported("slow") || batch.wasReported("other") {
+		t.Fatal("wasReported() does not match the batch's calls")
+	}
+}
+
+func TestToolBatchLeavesSingleCallsAndOverLimitTurnsToTheSDK(t *testing.T) {
+	calls := 0
+	handlers := map[string]vai.ToolHandler{
+		"count": func(context.Context, json.RawMessage) (any, error) {
+			calls++
+			return "ok", nil
+		},
+	}
+	batch := newToolBatch(context.Background(), handlers, 4, 2, nil)
+	batch.start(toolUseResponse(types.ToolUseBlock{Type: "tool_use", ID: "one", Name: "count", Input: map[string]any{}}))
+	// Two more calls take the run past its limit of two, so the SDK stops it.
+	batch.start(toolUseResponse(
+		types.ToolUseBlock{Type: "tool_use", ID: "two", Name: "count", Input: map[string]any{}},
+		types.ToolUseBlock{Type: "tool_use", ID: "three", Name: "count", Input: map[string]any{}},
+	))
+	if calls != 0 || batch.wasReported("two") {
+		t.Fatalf("batch ran %d calls, want none", calls)
+	}
+	if output, err := batch.handler("count")(context.Background(), json.RawMessage(`{}`)); err != nil || output != "ok" || calls != 1 {
+		t.Fatalf("handler() = %v, %v after %d calls", output, err, calls)
+	}
+}
@@ -0,0 +1,91 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RequestPreview is the request Stream would send for a set of messages,
+// built without contacting the provider.
+type RequestPreview struct {
+	Model         string `json:"model"`
+	ProviderModel string `json:"provider_model"`
+	// System is the final system prompt, after system messages are merged.
+	System   string           `json:"system"`
+	Messages []PreviewMessage `json:"messages"`
+	Tools    []string         `json:"tools"`
+	// EstimatedInputTokens counts the system prompt, messages and tool
+	// definitions with EstimateTokens.
+	EstimatedInputTokens int `json:"estimated_input_tokens"`
+	// ContextWindow is the model's context window in tokens.
+	ContextWindow int `json:"context_window"`
+	// Request is the provider request as JSON. Image bytes are left out so
+	// the preview stays small; Images in Messages counts them.
+	Request json.RawMessage `json:"request"`
+}
+
+// PreviewMessage is one conversation message of a preview.
+type PreviewMessage struct {
+	Role            string `json:"role"`
+	Content         string `json:"content"`
+	Images          int    `json:"images,omitempty"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+}
+
+// Preview builds the request Stream would send with the same messages and
+// options. It works for models whose provider is not configured, since
+// nothing is sent.
+func (r *Runner) Preview(model string, messages []Message, options StreamOptions) (RequestPreview, error) {
+	if !r.IsAllowedModel(model) {
+		return RequestPreview{}, fmt.Errorf("unsupported model %q", model)
+	}
+	stripped := make([]Message, len(messages))
+	for i, message := range messages {
+		stripped[i] = message
+		stripped[i].Images = make([]Image, len(message.Images))
+		for j, image := range message.Images {
+			stripped[i].Images[j] = Image{Name: image.Name, MediaType: image.MediaType}
+		}
+	}
+	req, _ := r.buildRequest(model, stripped, options)
+	encoded, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return RequestPreview{}, fmt.Errorf("encode request preview: %w", err)
+	}
+
+	preview := RequestPreview{
+		Model:         model,
+		ProviderModel: req.Model,
+		Messages:      make([]PreviewMessage, 0, len(messages)),
+		Tools:         make([]string, 0, len(req.Tools)),
+		ContextWindow: r.ContextWindow(model),
+		Request:       encoded,
+	}
+	if system, ok := req.System.(string); ok {
+		preview.System = system
+		preview.EstimatedInputTokens += EstimateTokens(system)
+	}
+	for _, message := range messages {
+		if message.Role == "system" {
+			continue
+		}
+		tokens := EstimateMessageTokens(message)
+		preview.Messages = append(preview.Messages, PreviewMessage{
+			Role:            message.Role,
+			Content:         message.Content,
+			Images:          len(message.Images),
+			EstimatedTokens: tokens,
+		})
+		preview.EstimatedInputTokens += tokens
+	}
+	for _, tool := range req.Tools {
+		name := tool.Name
+		if name == "" {
+			name = tool.Type
+		}
+		preview.Tools = append(preview.Tools, name)
+		definition, _ := json.Marshal(tool)
+		preview.EstimatedInputTokens += EstimateTokens(string(definition))
+	}
+	return preview, nil
+}
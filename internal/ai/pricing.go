@@ -0,0 +1,63 @@
+package ai
+
+import vai "github.com/vango-go/vai-lite/sdk"
+
+// Usage is the token usage a provider reports for a run.
+type Usage = vai.Usage
+
+// Price is a model's list price in USD per million tokens.
+type Price struct {
+	InputPerMTok  float64
+	OutputPerMTok float64
+}
+
+// modelPrices is the list price of each allowed model.
+var modelPrices = map[string]Price{
+	"oai-resp/gpt-5-mini":           {InputPerMTok: 0.25, OutputPerMTok: 2.00},
+	"gemini/gemini-3-flash-preview": {InputPerMTok: 0.50, OutputPerMTok: 3.00},
+	"anthropic/claude-haiku-4-5":    {InputPerMTok: 1.00, OutputPerMTok: 5.00},
+	MockModel:                       {},
+}
+
+// CostUSD returns what a run with usage cost on model: the provider's own
+// figure when it reports one, otherwise the tokens at list price. Local
+// Ollama models are free. ok is false when usage is missing or the model
+// has no known price.
+func CostUSD(model string, usage any) (cost float64, ok bool) {
+	reported, ok := reportedUsage(usage)
+	if !ok {
+		return 0, false
+	}
+	if reported.CostUSD != nil {
+		return *reported.CostUSD, true
+	}
+	price, ok := modelPrices[model]
+	if ProviderOf(model) == OllamaProvider {
+		price, ok = Price{}, true
+	}
+	if !ok {
+		return 0, false
+	}
+	return (float64(reported.InputTokens)*price.InputPerMTok + float64(reported.OutputTokens)*price.OutputPerMTok) / 1e6, true
+}
+
+// TokenCounts returns the input and output tokens of a run's usage, or
+// zeros when usage is missing.
+func TokenCounts(usage any) (input, output int) {
+	reported, _ := reportedUsage(usage)
+	return reported.InputTokens, reported.OutputTokens
+}
+
+func reportedUsage(usage any) (Usage, bool) {
+	switch value := usage.(type) {
+	case Usage:
+		return value, true
+	case *Usage:
+		if value == nil {
+			return Usage{}, false
+		}
+		return *value, true
+	default:
+		return Usage{}, false
+	}
+}
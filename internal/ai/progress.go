@@ -0,0 +1,93 @@
+package ai
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// UsageProgress is a run's token usage while it streams.
+type UsageProgress struct {
+	// InputTokens counts the input of the turns that have finished; the
+	// provider reports it with each turn's end.
+	InputTokens  int
+	OutputTokens int
+	// Estimated is set while the current turn's output is counted from the
+	// streamed text, before the provider reports the turn's usage.
+	Estimated bool
+	// TokensPerSecond is the output rate since the first streamed token.
+	TokensPerSecond float64
+}
+
+// usageMeter turns stream events into UsageProgress for
+// StreamCallbacks.OnUsage.
+type usageMeter struct {
+	report func(UsageProgress)
+	now    func() time.Time
+
+	first    time.Time
+	finished Usage
+	turn     tokenCounter
+}
+
+func newUsageMeter(report func(UsageProgress)) *usageMeter {
+	return &usageMeter{report: report, now: time.Now}
+}
+
+// streamed counts a text or reasoning delta of the current turn.
+func (m *usageMeter) streamed(delta string) {
+	if m.report == nil || delta == "" {
+		return
+	}
+	if m.first.IsZero() {
+		m.first = m.now()
+	}
+	m.turn.add(delta)
+	m.send(true)
+}
+
+// turnDone replaces the current turn's estimate with the usage the provider
+// reported for it.
+func (m *usageMeter) turnDone(usage Usage) {
+	if m.report == nil {
+		return
+	}
+	m.finished = m.finished.Add(usage)
+	m.turn = tokenCounter{}
+	m.send(false)
+}
+
+func (m *usageMeter) send(estimated bool) {
+	progress := UsageProgress{
+		InputTokens:  m.finished.InputTokens,
+		OutputTokens: m.finished.OutputTokens + m.turn.tokens(),
+		Estimated:    estimated && m.turn.tokens() > 0,
+	}
+	if !m.first.IsZero() {
+		if elapsed := m.now().Sub(m.first).Seconds(); elapsed > 0 {
+			progress.TokensPerSecond = float64(progress.OutputTokens) / elapsed
+		}
+	}
+	m.report(progress)
+}
+
+// tokenCounter is EstimateTokens over text that arrives in pieces.
+type tokenCounter struct {
+	ascii, other int
+}
+
+func (c *tokenCounter) add(text string) {
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			c.ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		c.other++
+		i += size
+	}
+}
+
+func (c tokenCounter) tokens() int {
+	return (c.ascii+3)/4 + c.other
+}
@@ -0,0 +1,38 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUsageMeterEstimatesUntilTheTurnIsReported(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	now := start
+	var got []UsageProgress
+	meter := newUsageMeter(func(progress UsageProgress) { got = append(got, progress) })
+	meter.now = func() time.Time { return now }
+
+	meter.streamed("Hello there")
+	now = start.Add(2 * time.Second)
+	meter.streamed(", general")
+	if last := got[len(got)-1]; !last.Estimated || last.OutputTokens != 5 || last.TokensPerSecond != 2.5 {
+		t.Fatalf("estimate = %+v, want ~5 tokens at 2.5/s", last)
+	}
+	meter.turnDone(Usage{InputTokens: 40, OutputTokens: 6})
+	if last := got[len(got)-1]; last.Estimated || last.InputTokens != 40 || last.OutputTokens != 6 {
+		t.Fatalf("after the turn = %+v, want the reported usage", last)
+	}
+	meter.streamed("more")
+	if last := got[len(got)-1]; !last.Estimated || last.OutputTokens != 7 {
+		t.Fatalf("second turn = %+v, want the reported usage plus the estimate", last)
+	}
+
+	var updates int
+	runner := NewRunner(RunnerConfig{MockModel: true})
+	if _, err := runner.Stream(context.Background(), MockModel, []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{
+		OnUsage: func(UsageProgress) { updates++ },
+	}); err != nil || updates == 0 {
+		t.Fatalf("mock Stream() error = %v with %d usage updates", err, updates)
+	}
+}
@@ -0,0 +1,65 @@
+package ai
+
+import "strings"
+
+// Reasoning effort levels accepted per chat or per request.
+const (
+	ReasoningLow    = "low"
+	ReasoningMedium = "medium"
+	ReasoningHigh   = "high"
+)
+
+var ReasoningEfforts = []string{ReasoningLow, ReasoningMedium, ReasoningHigh}
+
+func IsReasoningEffort(effort string) bool {
+	for _, candidate := range ReasoningEfforts {
+		if effort == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// reasoningExtensions maps an effort level onto the provider extension that
+// controls it. Providers without an effort knob in vai-lite (currently
+// anthropic, whose thinking budget is configured through beta headers) get
+// no extension and run with their defaults.
+func reasoningExtensions(model, effort string) map[string]any {
+	if !IsReasoningEffort(effort) {
+		return nil
+	}
+	switch providerOf(model) {
+	case "oai-resp", "openai":
+		return map[string]any{
+			"oai_resp": map[string]any{
+				"reasoning": map[string]any{"effort": effort},
+			},
+		}
+	case "gemini":
+		// Gemini 3 only distinguishes low and high thinking levels.
+		level := "high"
+		if effort == ReasoningLow {
+			level = "low"
+		}
+		return map[string]any{
+			"gemini": map[string]any{
+				"thinking": map[string]any{"level": level},
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+func mergeExtensions(dst, src map[string]any) map[string]any {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]any, len(src))
+	}
+	for key, value := range src {
+		dst[strings.ToLower(key)] = value
+	}
+	return dst
+}
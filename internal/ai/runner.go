@@ -5,15 +5,28 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/vango-go/vai-lite/pkg/core/types"
 	vai "github.com/vango-go/vai-lite/sdk"
 )
 
 type Message struct {
-	Role    string
-	Content string
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are sent as image content blocks to vision-capable models.
+	Images []Image `json:"images,omitempty"`
+}
+
+// Image is an inline image attached to a user message.
+type Image struct {
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+	Data      []byte `json:"data"`
 }
 
 type RunnerConfig struct {
@@ -21,11 +34,56 @@ type RunnerConfig struct {
 	MaxToolCalls int
 	RunTimeout   time.Duration
 	ToolTimeout  time.Duration
+	// ReasoningEffort is the default effort for reasoning models when a
+	// request does not set one.
+	ReasoningEffort string
+	// Tools are offered to the model on every run. Nil uses
+	// DefaultToolRegistry.
+	Tools       *ToolRegistry
+	ProviderLog ProviderLogConfig
+	// MockModel offers MockModel even when provider keys are configured.
+	MockModel bool
+	// ProviderKeys, when set, looks up the keys of the user a request is
+	// sent for (StreamOptions.UserID); their keys replace the server's.
+	ProviderKeys ProviderKeys
+	// KeyPools lists several server keys for a provider, by KeyProviders
+	// name, in place of its single environment key. KeyStrategy picks
+	// among them: KeyRoundRobin (the default) or KeyFailover.
+	KeyPools    map[string][]string
+	KeyStrategy string
+	// Transport sends provider requests through a proxy or gateway; nil
+	// uses the client defaults.
+	Transport *Transport
+	// Ollama adds the models of a local Ollama server to the catalog.
+	Ollama OllamaConfig
+	// Azure adds the models of Azure OpenAI deployments to the catalog.
+	Azure AzureConfig
+	// ParallelTools caps how many tool calls of one turn run at once; 1
+	// runs them one after another and 0 uses DefaultParallelTools.
+	ParallelTools int
+	// ContextWindows sets the context window in tokens of models the
+	// catalog does not know or gets wrong, such as Ollama models served
+	// with a custom context length.
+	ContextWindows map[string]int
+	// Breaker fails runs of a model fast while its provider is down.
+	Breaker BreakerConfig
 }
 
+// ErrRunTimeout is returned when a run exceeds its run timeout.
+var ErrRunTimeout = errors.New("ai run timed out")
+
+// ErrProviderNotConfigured is returned when a model's provider has no API
+// key.
+var ErrProviderNotConfigured = errors.New("provider is not configured")
+
 type Runner struct {
-	client *vai.Client
-	cfg    RunnerConfig
+	client      *vai.Client
+	pools       map[string]*keyPool
+	breakers    *breakers
+	users       userClients
+	extra       []string
+	cfg         RunnerConfig
+	mockEnabled bool
 }
 
 type ToolCallUpdate struct {
@@ -38,10 +96,48 @@ type ToolCallUpdate struct {
 }
 
 type StreamCallbacks struct {
-	OnTextDelta  func(string)
-	OnThinking   func()
-	OnToolStart  func(ToolCallUpdate)
-	OnToolResult func(ToolCallUpdate)
+	OnTextDelta func(string)
+	// OnThinkingDelta receives reasoning text streamed by thinking models.
+	OnThinkingDelta func(string)
+	OnToolStart     func(ToolCallUpdate)
+	OnToolResult    func(ToolCallUpdate)
+	// OnUsage receives the run's token usage so far after each delta and
+	// each finished turn.
+	OnUsage func(UsageProgress)
+	// OnQueued is not called by the runner: chat.Service calls it while a
+	// run waits for a stream slot, with its place in line (1 is next), and
+	// with 0 when it leaves the line to stream.
+	OnQueued func(position int)
+}
+
+// GenerationParams are optional sampling parameters forwarded to the
+// provider. Nil/zero values leave the provider defaults in place.
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       int      `json:"max_tokens,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"`
+}
+
+// StreamOptions carries per-request overrides of the runner configuration.
+type StreamOptions struct {
+	// RunID correlates provider call logs with the persisted run.
+	RunID string
+	// RunTimeout replaces RunnerConfig.RunTimeout for this request when > 0.
+	RunTimeout time.Duration
+	Params     GenerationParams
+	// DisableTools sends the request without any tools, for background
+	// jobs such as summarization.
+	DisableTools bool
+	// DisabledTools names registry tools to leave out of this request.
+	DisabledTools []string
+	// UserID sends the request with that user's provider keys, when the
+	// runner has ProviderKeys; empty uses the server's keys.
+	UserID string
+	// OutputSchema asks the provider for a reply that is a JSON object
+	// matching it. Providers do not always honour it, so callers still check
+	// the reply with ParseStructuredOutput.
+	OutputSchema *JSONSchema
 }
 
 type StreamResult struct {
@@ -49,39 +145,226 @@ type StreamResult struct {
 	ToolCallCount int
 	TurnCount     int
 	Usage         any
+	// Latency is set by Stream whether or not the run succeeded.
+	Latency Latency
+	// Model is the fallback model that streamed the reply when the
+	// requested model's circuit breaker was open; empty otherwise.
+	Model string
 }
 
 func NewRunner(cfg RunnerConfig) *Runner {
-	client := vai.NewClient()
-	return &Runner{client: client, cfg: cfg}
+	client := newClient(cfg.Transport, nil)
+	if cfg.Tools == nil {
+		cfg.Tools = DefaultToolRegistry()
+	}
+	runner := &Runner{client: client, cfg: cfg, pools: map[string]*keyPool{}, breakers: newBreakers(cfg.Breaker)}
+	for provider, keys := range cfg.KeyPools {
+		if len(keys) > 0 {
+			runner.pools[provider] = newKeyPool(provider, cfg.KeyStrategy, keys, cfg.Transport)
+		}
+	}
+	if cfg.Ollama.URL != "" {
+		registerOllama(client, cfg.Ollama.URL)
+		for _, model := range cfg.Ollama.Models {
+			runner.extra = append(runner.extra, OllamaProvider+"/"+model)
+		}
+	}
+	if cfg.Azure.Endpoint != "" && len(cfg.Azure.Deployments) > 0 {
+		registerAzure(client, cfg.Azure, cfg.Transport)
+		for _, model := range slices.Sorted(maps.Keys(cfg.Azure.Deployments)) {
+			runner.extra = append(runner.extra, AzureProvider+"/"+model)
+		}
+	}
+	runner.mockEnabled = cfg.MockModel || len(runner.Models()) == 0
+	return runner
+}
+
+func (r *Runner) Tools() *ToolRegistry {
+	return r.cfg.Tools
+}
+
+// ProviderConfigured reports whether model can be sent, i.e. its provider
+// has an API key (or it is the enabled mock model).
+func (r *Runner) ProviderConfigured(model string) bool {
+	if model == MockModel {
+		return r.mockEnabled
+	}
+	return configured(r.client, model) || r.pools[KeyProviderOf(model)] != nil
 }
 
-func (r *Runner) Stream(ctx context.Context, model string, messages []Message, callbacks StreamCallbacks) (StreamResult, error) {
-	if !IsAllowedModel(model) {
+// KeyHealth reports on the server keys of the providers with a key pool,
+// by provider.
+func (r *Runner) KeyHealth() []KeyHealth {
+	providers := slices.Sorted(maps.Keys(r.pools))
+	var health []KeyHealth
+	now := time.Now()
+	for _, provider := range providers {
+		health = append(health, r.pools[provider].health(now)...)
+	}
+	return health
+}
+
+// Breakers reports on the circuit breakers of the models that have run
+// since the runner started, by model.
+func (r *Runner) Breakers() []BreakerHealth {
+	return r.breakers.health(time.Now())
+}
+
+// configured reports whether client has a provider for model.
+func configured(client *vai.Client, model string) bool {
+	_, ok := client.Engine().GetProvider(ProviderOf(model))
+	return ok
+}
+
+// IsAllowedModel reports whether model may be requested from this runner.
+func (r *Runner) IsAllowedModel(model string) bool {
+	return IsAllowedModel(model) || slices.Contains(r.extra, model) || (model == MockModel && r.mockEnabled)
+}
+
+// Models returns the allowed models whose provider is configured, then the
+// Ollama and Azure models, followed by the mock model when it is enabled.
+func (r *Runner) Models() []string {
+	models := make([]string, 0, len(AllowedModels)+len(r.extra)+1)
+	for _, model := range AllowedModels {
+		if r.ProviderConfigured(model) {
+			models = append(models, model)
+		}
+	}
+	models = append(models, r.extra...)
+	if r.mockEnabled {
+		models = append(models, MockModel)
+	}
+	return models
+}
+
+// ContextWindow returns model's context window in tokens, from
+// RunnerConfig.ContextWindows before the catalog.
+func (r *Runner) ContextWindow(model string) int {
+	if window := r.cfg.ContextWindows[model]; window > 0 {
+		return window
+	}
+	return ContextWindow(model)
+}
+
+// MissingProviderKeys lists the API key variables of allowed models whose
+// provider is not configured.
+func (r *Runner) MissingProviderKeys() []string {
+	var missing []string
+	for _, model := range AllowedModels {
+		env := ProviderKeyEnv(model)
+		if env == "" || r.ProviderConfigured(model) || slices.Contains(missing, env) {
+			continue
+		}
+		missing = append(missing, env)
+	}
+	return missing
+}
+
+func (r *Runner) Stream(ctx context.Context, model string, messages []Message, options StreamOptions, callbacks StreamCallbacks) (result StreamResult, err error) {
+	if !r.IsAllowedModel(model) {
 		return StreamResult{}, fmt.Errorf("unsupported model %q", model)
 	}
-	resolvedModel := ResolveModel(model)
+	clock := newLatencyClock()
+	callbacks = clock.watch(callbacks)
+	defer func() {
+		result.Latency = clock.latency()
+	}()
+	if model == MockModel {
+		return r.streamMock(ctx, messages, options, callbacks)
+	}
+	if err := r.breakers.allow(model, time.Now()); err != nil {
+		fallback := r.cfg.Breaker.Fallbacks[model]
+		if fallback == "" || fallback == model || fallback == MockModel || !r.IsAllowedModel(fallback) || r.breakers.allow(fallback, time.Now()) != nil {
+			return StreamResult{}, err
+		}
+		result, err = r.send(ctx, fallback, messages, options, callbacks)
+		result.Model = fallback
+		return result, err
+	}
+	return r.send(ctx, model, messages, options, callbacks)
+}
+
+// send streams a run of model that its circuit breaker let through, and
+// records how it went.
+func (r *Runner) send(ctx context.Context, model string, messages []Message, options StreamOptions, callbacks StreamCallbacks) (result StreamResult, err error) {
+	defer func() {
+		r.breakers.record(model, err, time.Now())
+	}()
+	client, err := r.clientFor(ctx, options.UserID, model)
+	if err != nil {
+		return StreamResult{}, fmt.Errorf("provider keys: %w", err)
+	}
+	if client == nil {
+		if pool := r.pools[KeyProviderOf(model)]; pool != nil {
+			return r.streamPooled(ctx, pool, model, messages, options, callbacks)
+		}
+		client = r.client
+	}
+	if !configured(client, model) {
+		return StreamResult{}, fmt.Errorf("%w: model %q needs %s", ErrProviderNotConfigured, model, ProviderKeyEnv(model))
+	}
+	return r.streamWith(ctx, client, model, messages, options, callbacks)
+}
 
-	requestMessages, systemPrompt := normalizeMessagesForRequest(messages)
+// streamPooled sends the request with the provider's server keys in the
+// pool's order. A failure that is the key's fault moves on to the next key
+// as long as nothing has streamed yet.
+func (r *Runner) streamPooled(ctx context.Context, pool *keyPool, model string, messages []Message, options StreamOptions, callbacks StreamCallbacks) (StreamResult, error) {
+	streamed := false
+	watched := callbacks.watch(func() { streamed = true })
+	var err error
+	for _, slot := range pool.order(time.Now()) {
+		var result StreamResult
+		result, err = r.streamWith(ctx, slot.client, model, messages, options, watched)
+		fault := pool.record(slot, err, time.Now())
+		if err == nil || fault == faultNone || streamed || ctx.Err() != nil {
+			return result, err
+		}
+	}
+	return StreamResult{}, err
+}
 
-	req := &vai.MessageRequest{
-		Model:    resolvedModel,
-		Messages: requestMessages,
-		Tools: []vai.Tool{
-			vai.WebSearch(),
-		},
-		ToolChoice: vai.ToolChoiceAuto(),
+// watch returns callbacks that call seen before passing on any output.
+func (c StreamCallbacks) watch(seen func()) StreamCallbacks {
+	watched := c
+	watched.OnTextDelta = func(delta string) {
+		seen()
+		if c.OnTextDelta != nil {
+			c.OnTextDelta(delta)
+		}
 	}
-	if systemPrompt != "" {
-		req.System = systemPrompt
+	watched.OnThinkingDelta = func(delta string) {
+		seen()
+		if c.OnThinkingDelta != nil {
+			c.OnThinkingDelta(delta)
+		}
 	}
+	watched.OnToolStart = func(update ToolCallUpdate) {
+		seen()
+		if c.OnToolStart != nil {
+			c.OnToolStart(update)
+		}
+	}
+	return watched
+}
 
+func (r *Runner) streamWith(ctx context.Context, client *vai.Client, model string, messages []Message, options StreamOptions, callbacks StreamCallbacks) (result StreamResult, err error) {
+	resolvedModel := ResolveModel(model)
+	req, handlers := r.buildRequest(model, messages, options)
+
+	runTimeout := r.cfg.RunTimeout
+	if options.RunTimeout > 0 {
+		runTimeout = options.RunTimeout
+	}
 	runCtx := ctx
 	cancel := func() {}
-	if r.cfg.RunTimeout > 0 {
-		runCtx, cancel = context.WithTimeout(ctx, r.cfg.RunTimeout)
+	if runTimeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, runTimeout)
 	}
 	defer cancel()
+	timedOut := func() bool {
+		return ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded)
+	}
 
 	opts := []vai.RunOption{}
 	if r.cfg.MaxTurns > 0 {
@@ -94,25 +377,65 @@ func (r *Runner) Stream(ctx context.Context, model string, messages []Message, c
 		opts = append(opts, vai.WithToolTimeout(r.cfg.ToolTimeout))
 	}
 
-	stream, err := r.client.Messages.RunStream(runCtx, req, opts...)
+	// Callbacks come from the stream and from the batch's calls; they are
+	// never called concurrently.
+	var callbackMu sync.Mutex
+	batch := newToolBatch(runCtx, handlers, r.cfg.ParallelTools, r.cfg.MaxToolCalls, func(update ToolCallUpdate) {
+		callbackMu.Lock()
+		defer callbackMu.Unlock()
+		if callbacks.OnToolResult != nil {
+			callbacks.OnToolResult(update)
+		}
+	})
+	for name := range handlers {
+		opts = append(opts, vai.WithToolHandler(name, batch.handler(name)))
+	}
+	callLog := newProviderCallLog(r.cfg.ProviderLog, options.RunID, model)
+	opts = append(opts, callLog.runOptions(batch.start)...)
+	defer func() {
+		callLog.failed(err)
+	}()
+
+	meter := newUsageMeter(callbacks.OnUsage)
+	stream, err := client.Messages.RunStream(runCtx, req, opts...)
 	if err != nil {
+		if timedOut() {
+			return StreamResult{}, timeoutError(model, runTimeout)
+		}
 		return StreamResult{}, wrapStreamError(model, resolvedModel, "start", err)
 	}
 	defer stream.Close()
 
 	_, processErr := stream.Process(vai.StreamCallbacks{
 		OnTextDelta: func(delta string) {
+			callbackMu.Lock()
+			defer callbackMu.Unlock()
 			if callbacks.OnTextDelta != nil {
 				callbacks.OnTextDelta(delta)
 			}
+			meter.streamed(delta)
 		},
 		OnThinkingDelta: func(delta string) {
-			if callbacks.OnThinking != nil && strings.TrimSpace(delta) != "" {
-				callbacks.OnThinking()
+			callbackMu.Lock()
+			defer callbackMu.Unlock()
+			if callbacks.OnThinkingDelta != nil && delta != "" {
+				callbacks.OnThinkingDelta(delta)
+			}
+			meter.streamed(delta)
+		},
+		OnStepComplete: func(_ int, response *vai.Response) {
+			callbackMu.Lock()
+			defer callbackMu.Unlock()
+			if response != nil && response.MessageResponse != nil {
+				meter.turnDone(response.Usage)
 			}
 		},
 		OnToolCallStart: func(id, name string, input map[string]any) {
-			if callbacks.OnToolStart == nil {
+			callbackMu.Lock()
+			defer callbackMu.Unlock()
+			// The SDK announces a call again just before running it, which
+			// for a batched call can be after it finished.
+			if callbacks.OnToolStart == nil || batch.wasReported(id) {
 				return
 			}
 			encoded, _ := json.Marshal(input)
@@ -124,7 +447,9 @@ func (r *Runner) Stream(ctx context.Context, model string, messages []Message, c
 			})
 		},
 		OnToolResult: func(id, name string, content []vai.ContentBlock, toolErr error) {
-			if callbacks.OnToolResult == nil {
+			callbackMu.Lock()
+			defer callbackMu.Unlock()
+			if callbacks.OnToolResult == nil || batch.wasReported(id) {
 				return
 			}
 			update := ToolCallUpdate{
@@ -140,6 +465,9 @@ func (r *Runner) Stream(ctx context.Context, model string, messages []Message, c
 			callbacks.OnToolResult(update)
 		},
 	})
+	if timedOut() {
+		return StreamResult{}, timeoutError(model, runTimeout)
+	}
 	if processErr != nil {
 		return StreamResult{}, wrapStreamError(model, resolvedModel, "process", processErr)
 	}
@@ -161,6 +489,59 @@ func (r *Runner) Stream(ctx context.Context, model string, messages []Message, c
 	}, nil
 }
 
+// buildRequest converts messages and options into the provider request and
+// the handlers that execute its function tools.
+func (r *Runner) buildRequest(model string, messages []Message, options StreamOptions) (*vai.MessageRequest, map[string]vai.ToolHandler) {
+	requestMessages, systemPrompt := normalizeMessagesForRequest(messages, SupportsVision(model))
+
+	var (
+		tools    []vai.Tool
+		handlers map[string]vai.ToolHandler
+	)
+	if !options.DisableTools {
+		tools, handlers = r.cfg.Tools.requestTools(r.cfg.ToolTimeout, options.DisabledTools)
+	}
+	req := &vai.MessageRequest{
+		Model:    ResolveModel(model),
+		Messages: requestMessages,
+		Tools:    tools,
+	}
+	if len(tools) > 0 {
+		req.ToolChoice = vai.ToolChoiceAuto()
+	}
+	if systemPrompt != "" {
+		req.System = systemPrompt
+	}
+	if options.OutputSchema != nil {
+		req.OutputFormat = &types.OutputFormat{Type: "json_schema", JSONSchema: options.OutputSchema}
+	}
+	applyGenerationParams(req, options.Params)
+	reasoningEffort := options.Params.ReasoningEffort
+	if reasoningEffort == "" {
+		reasoningEffort = r.cfg.ReasoningEffort
+	}
+	req.Extensions = mergeExtensions(req.Extensions, reasoningExtensions(model, reasoningEffort))
+	return req, handlers
+}
+
+func applyGenerationParams(req *vai.MessageRequest, params GenerationParams) {
+	if params.Temperature != nil {
+		temperature := *params.Temperature
+		req.Temperature = &temperature
+	}
+	if params.TopP != nil {
+		topP := *params.TopP
+		req.TopP = &topP
+	}
+	if params.MaxTokens > 0 {
+		req.MaxTokens = params.MaxTokens
+	}
+}
+
+func timeoutError(model string, timeout time.Duration) error {
+	return fmt.Errorf("ai stream for model %q exceeded %s: %w", model, timeout, ErrRunTimeout)
+}
+
 func wrapStreamError(selectedModel, providerModel, stage string, err error) error {
 	if err == nil {
 		return fmt.Errorf("ai stream failed for model %q at %s", selectedModel, stage)
@@ -168,11 +549,11 @@ func wrapStreamError(selectedModel, providerModel, stage string, err error) erro
 	if errors.Is(err, context.Canceled) {
 		return err
 	}
-	message := strings.TrimSpace(err.Error())
-	if message == "" {
-		message = "provider returned an empty error"
+	if strings.TrimSpace(err.Error()) == "" {
+		return fmt.Errorf("ai stream failed for model %q (provider model %q) at %s: provider returned an empty error", selectedModel, providerModel, stage)
 	}
-	return fmt.Errorf("ai stream failed for model %q (provider model %q) at %s: %s", selectedModel, providerModel, stage, message)
+	kind, retryAfter := classifyProviderError(err)
+	return &StreamError{Model: selectedModel, ProviderModel: providerModel, Stage: stage, Kind: kind, RetryAfter: retryAfter, Err: err}
 }
 
 func contentBlocksToText(blocks []vai.ContentBlock) string {
@@ -190,7 +571,10 @@ func contentBlocksToText(blocks []vai.ContentBlock) string {
 	return strings.Join(parts, "\n")
 }
 
-func normalizeMessagesForRequest(messages []Message) ([]vai.Message, string) {
+// normalizeMessagesForRequest splits out the system prompt and builds the
+// request messages. Images become content blocks when vision is true and a
+// short placeholder otherwise.
+func normalizeMessagesForRequest(messages []Message, vision bool) ([]vai.Message, string) {
 	requestMessages := make([]vai.Message, 0, len(messages))
 	systemParts := make([]string, 0, 1)
 	for _, message := range messages {
@@ -201,9 +585,18 @@ func normalizeMessagesForRequest(messages []Message) ([]vai.Message, string) {
 			}
 			continue
 		}
+		content := make([]vai.ContentBlock, 0, 1+len(message.Images))
+		content = append(content, vai.Text(message.Content))
+		for _, image := range message.Images {
+			if vision {
+				content = append(content, vai.Image(image.Data, image.MediaType))
+				continue
+			}
+			content = append(content, vai.Text(fmt.Sprintf("[image %q omitted: model does not accept images]", image.Name)))
+		}
 		requestMessages = append(requestMessages, vai.Message{
 			Role:    message.Role,
-			Content: []vai.ContentBlock{vai.Text(message.Content)},
+			Content: content,
 		})
 	}
 	return requestMessages, strings.Join(systemParts, "\n\n")
@@ -1,6 +1,14 @@
 package ai
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
 
 func TestNormalizeMessagesForRequest_ExtractsSystemPrompt(t *testing.T) {
 	input := []Message{
@@ -10,7 +18,7 @@ func TestNormalizeMessagesForRequest_ExtractsSystemPrompt(t *testing.T) {
 		{Role: "system", Content: "Use web search if needed."},
 	}
 
-	requestMessages, systemPrompt := normalizeMessagesForRequest(input)
+	requestMessages, systemPrompt := normalizeMessagesForRequest(input, true)
 
 	if systemPrompt != "You are helpful.\n\nUse web search if needed." {
 		t.Fatalf("systemPrompt = %q", systemPrompt)
@@ -25,3 +33,208 @@ func TestNormalizeMessagesForRequest_ExtractsSystemPrompt(t *testing.T) {
 		t.Fatalf("requestMessages[1].Role = %q, want assistant", requestMessages[1].Role)
 	}
 }
+
+func TestNormalizeMessagesForRequest_Images(t *testing.T) {
+	input := []Message{{
+		Role:    "user",
+		Content: "What is this?",
+		Images:  []Image{{Name: "cat.png", MediaType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}}},
+	}}
+
+	requestMessages, _ := normalizeMessagesForRequest(input, true)
+	blocks := requestMessages[0].Content.([]vai.ContentBlock)
+	if len(blocks) != 2 || blocks[1].BlockType() != "image" {
+		t.Fatalf("vision blocks = %+v, want text then image", blocks)
+	}
+
+	requestMessages, _ = normalizeMessagesForRequest(input, false)
+	blocks = requestMessages[0].Content.([]vai.ContentBlock)
+	if len(blocks) != 2 || blocks[1].BlockType() != "text" {
+		t.Fatalf("non-vision blocks = %+v, want text placeholder", blocks)
+	}
+}
+
+func TestReasoningExtensionsByProvider(t *testing.T) {
+	openai := reasoningExtensions("oai-resp/gpt-5-mini", ReasoningMedium)
+	effort := openai["oai_resp"].(map[string]any)["reasoning"].(map[string]any)["effort"]
+	if effort != "medium" {
+		t.Fatalf("oai_resp effort = %v, want medium", effort)
+	}
+
+	gemini := reasoningExtensions("gemini/gemini-3-flash-preview", ReasoningMedium)
+	level := gemini["gemini"].(map[string]any)["thinking"].(map[string]any)["level"]
+	if level != "high" {
+		t.Fatalf("gemini level = %v, want high", level)
+	}
+
+	if ext := reasoningExtensions("anthropic/claude-sonnet-4-5", ReasoningHigh); ext != nil {
+		t.Fatalf("anthropic extensions = %v, want nil", ext)
+	}
+	if ext := reasoningExtensions("oai-resp/gpt-5-mini", ""); ext != nil {
+		t.Fatalf("empty effort extensions = %v, want nil", ext)
+	}
+}
+
+func TestRunnerFallsBackToMockModelWithoutProviderKeys(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY", "ANTHROPIC_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	runner := NewRunner(RunnerConfig{})
+
+	if models := runner.Models(); len(models) != 1 || models[0] != MockModel {
+		t.Fatalf("Models() = %v, want only the mock model", models)
+	}
+	if missing := runner.MissingProviderKeys(); len(missing) != 3 {
+		t.Fatalf("MissingProviderKeys() = %v, want three keys", missing)
+	}
+
+	_, err := runner.Stream(context.Background(), "oai-resp/gpt-5-mini", []Message{{Role: "user", Content: "hi"}}, StreamOptions{}, StreamCallbacks{})
+	if !errors.Is(err, ErrProviderNotConfigured) || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Fatalf("Stream() without key error = %v, want ErrProviderNotConfigured naming OPENAI_API_KEY", err)
+	}
+
+	var reply strings.Builder
+	result, err := runner.Stream(context.Background(), MockModel, []Message{{Role: "user", Content: "ping"}}, StreamOptions{}, StreamCallbacks{
+		OnTextDelta: func(delta string) { reply.WriteString(delta) },
+	})
+	if err != nil || result.StopReason != "end_turn" {
+		t.Fatalf("mock Stream() = %+v, %v", result, err)
+	}
+	if !strings.Contains(reply.String(), "> ping") {
+		t.Fatalf("mock reply = %q, want it to quote the user message", reply.String())
+	}
+	if latency := result.Latency; latency.FirstToken <= 0 || latency.Stream < latency.FirstToken {
+		t.Fatalf("mock Stream() latency = %+v, want the first token inside the stream", latency)
+	}
+}
+
+func TestLatencyClockTimesOutputAndToolCalls(t *testing.T) {
+	clock := newLatencyClock()
+	var results []ToolCallUpdate
+	callbacks := clock.watch(StreamCallbacks{
+		OnToolResult: func(update ToolCallUpdate) { results = append(results, update) },
+	})
+	time.Sleep(10 * time.Millisecond)
+	callbacks.OnThinkingDelta("Hmm")
+	callbacks.OnToolStart(ToolCallUpdate{ID: "call-1", Name: "fetch_url"})
+	callbacks.OnToolStart(ToolCallUpdate{ID: "call-1", Name: "fetch_url"}) // announced again before running
+	time.Sleep(20 * time.Millisecond)
+	callbacks.OnToolResult(ToolCallUpdate{ID: "call-1", Name: "fetch_url", Status: "completed"})
+	callbacks.OnTextDelta("Done")
+
+	latency := clock.latency()
+	if latency.FirstToken < 10*time.Millisecond || latency.FirstToken > latency.Stream {
+		t.Fatalf("latency = %+v, want the first token at the reasoning", latency)
+	}
+	if len(latency.Tools) != 1 || latency.Tools[0].Name != "fetch_url" || latency.Tools[0].Duration < 20*time.Millisecond {
+		t.Fatalf("latency.Tools = %+v, want the one call timed from its first announcement", latency.Tools)
+	}
+	if len(results) != 1 {
+		t.Fatalf("OnToolResult called %d times, want the result passed on", len(results))
+	}
+
+	rate := Latency{FirstToken: time.Second, Stream: 3 * time.Second}.TokensPerSecond(100)
+	if rate != 50 || (Latency{Stream: time.Second}).TokensPerSecond(100) != 0 {
+		t.Fatalf("TokensPerSecond() = %v, want 50 tokens/s after the first token and 0 without one", rate)
+	}
+}
+
+func TestPreviewBuildsTheRequestWithoutAProvider(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	runner := NewRunner(RunnerConfig{Tools: DefaultToolRegistry(), ContextWindows: map[string]int{"oai-resp/gpt-5-mini": 32000}})
+	messages := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What is this?", Images: []Image{{Name: "a.png", MediaType: "image/png", Data: []byte("png-bytes")}}},
+	}
+	preview, err := runner.Preview("oai-resp/gpt-5-mini", messages, StreamOptions{})
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if preview.System != "Be brief." || len(preview.Messages) != 1 || preview.Messages[0].Images != 1 {
+		t.Fatalf("Preview() = %+v, want the system prompt and one user message with an image", preview)
+	}
+	if len(preview.Tools) != 1 || preview.Tools[0] != "web_search" || preview.EstimatedInputTokens <= imageTokens {
+		t.Fatalf("Preview() tools = %v, tokens = %d", preview.Tools, preview.EstimatedInputTokens)
+	}
+	if preview.ContextWindow != 32000 || runner.ContextWindow("anthropic/claude-haiku-4-5") != 200000 {
+		t.Fatalf("Preview().ContextWindow = %d, want the configured window over the catalog's", preview.ContextWindow)
+	}
+	if strings.Contains(string(preview.Request), "cG5nLWJ5dGVz") || !strings.Contains(string(preview.Request), `"What is this?"`) {
+		t.Fatalf("Preview().Request = %s, want the message without image bytes", preview.Request)
+	}
+	if len(messages[1].Images[0].Data) == 0 {
+		t.Fatal("Preview() cleared the caller's image data")
+	}
+	if _, err := runner.Preview("nope", messages, StreamOptions{}); err == nil {
+		t.Fatal("Preview() of an unknown model succeeded")
+	}
+}
+
+func TestRunnerSendsWithTheUsersOwnProviderKeys(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY", "ANTHROPIC_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	keys := map[string]map[string]string{"ada": {"anthropic": "sk-ada"}}
+	runner := NewRunner(RunnerConfig{ProviderKeys: func(_ context.Context, userID string) (map[string]string, error) {
+		return keys[userID], nil
+	}})
+	ctx := context.Background()
+
+	if client, err := runner.clientFor(ctx, "bob", "anthropic/claude-haiku-4-5"); err != nil || client != nil {
+		t.Fatalf("clientFor(bob) = %p, %v; want the server's keys for a user without keys", client, err)
+	}
+	if client, err := runner.clientFor(ctx, "ada", "oai-resp/gpt-5-mini"); err != nil || client != nil {
+		t.Fatalf("clientFor(ada, openai) = %p, %v; want the server's keys for a provider she has no key for", client, err)
+	}
+	own, err := runner.clientFor(ctx, "ada", "anthropic/claude-haiku-4-5")
+	if err != nil || own == nil || !configured(own, "anthropic/claude-haiku-4-5") || configured(own, "oai-resp/gpt-5-mini") {
+		t.Fatalf("clientFor(ada) = %p, %v; want a client with only her Anthropic key", own, err)
+	}
+	if again, _ := runner.clientFor(ctx, "ada", "anthropic/claude-haiku-4-5"); again != own {
+		t.Fatal("clientFor(ada) built a new client for unchanged keys")
+	}
+	keys["ada"] = map[string]string{"anthropic": "sk-ada-2"}
+	if rotated, _ := runner.clientFor(ctx, "ada", "anthropic/claude-haiku-4-5"); rotated == own {
+		t.Fatal("clientFor(ada) kept the client of a replaced key")
+	}
+
+	_, err = runner.Stream(ctx, "anthropic/claude-haiku-4-5", []Message{{Role: "user", Content: "hi"}}, StreamOptions{UserID: "bob"}, StreamCallbacks{})
+	if !errors.Is(err, ErrProviderNotConfigured) {
+		t.Fatalf("Stream() for a user without keys error = %v, want ErrProviderNotConfigured", err)
+	}
+	if KeyProviderOf("oai-resp/gpt-5-mini") != "openai" || KeyProviderOf("gemini/gemini-3-flash-preview") != "gemini" {
+		t.Fatal("KeyProviderOf() does not map models to their key's provider")
+	}
+}
+
+func TestWrapStreamErrorClassifiesProviderFailures(t *testing.T) {
+	retryAfter := 30
+	cases := []struct {
+		err  error
+		kind error
+		name string
+	}{
+		{&vai.Error{Type: vai.ErrRateLimit, Message: "slow down", RetryAfter: &retryAfter}, ErrRateLimited, ErrorKindRateLimited},
+		{&vai.Error{Type: vai.ErrAuthentication, Message: "bad key"}, ErrAuth, ErrorKindAuth},
+		{&vai.Error{Type: vai.ErrOverloaded, Message: "busy"}, ErrOverloaded, ErrorKindOverloaded},
+		{&vai.Error{Type: vai.ErrInvalidRequest, Message: "prompt is too long: 210000 tokens > 200000 maximum"}, ErrContextTooLong, ErrorKindContextTooLong},
+		{errors.New(`openai: 400 {"code":"content_filter"}`), ErrContentFiltered, ErrorKindContentFiltered},
+		{errors.New("connection reset by peer"), nil, ""},
+	}
+	for _, tc := range cases {
+		err := wrapStreamError("anthropic/claude-sonnet-4-5", "claude-sonnet-4-5", "process", tc.err)
+		if tc.kind != nil && !errors.Is(err, tc.kind) {
+			t.Fatalf("wrapStreamError(%v) = %v, want it to wrap %v", tc.err, err, tc.kind)
+		}
+		if kind := ErrorKind(err); kind != tc.name {
+			t.Fatalf("ErrorKind(%v) = %q, want %q", err, kind, tc.name)
+		}
+		if !errors.Is(err, tc.err) || !strings.Contains(err.Error(), tc.err.Error()) {
+			t.Fatalf("wrapStreamError(%v) = %v, want the provider's error kept", tc.err, err)
+		}
+	}
+	err := wrapStreamError("m", "m", "start", cases[0].err)
+	if RetryAfter(err) != 30*time.Second || !RetryableKind(ErrorKind(err)) || RetryableKind(ErrorKindAuth) {
+		t.Fatalf("RetryAfter() = %s, want the provider's 30s and a retryable rate limit", RetryAfter(err))
+	}
+}
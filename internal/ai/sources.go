@@ -0,0 +1,83 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Source is a web page a tool read or found while answering.
+type Source struct {
+	URL   string
+	Title string
+}
+
+// ToolSources extracts the pages behind a tool result: the page a fetch_url
+// call read and the results a native web search returned. output is the
+// tool result as reported in ToolCallUpdate.Output. Sources are returned in
+// result order without duplicate URLs.
+func ToolSources(output string) []Source {
+	var sources []Source
+	seen := map[string]bool{}
+	add := func(rawURL, title string) {
+		rawURL = strings.TrimSpace(rawURL)
+		if !isWebURL(rawURL) || seen[rawURL] {
+			return
+		}
+		seen[rawURL] = true
+		sources = append(sources, Source{URL: rawURL, Title: strings.TrimSpace(title)})
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		var block struct {
+			Type    string `json:"type"`
+			Text    string `json:"text"`
+			Content []struct {
+				Type  string `json:"type"`
+				URL   string `json:"url"`
+				Title string `json:"title"`
+			} `json:"content"`
+		}
+		if err := decoder.Decode(&block); err != nil {
+			if !errors.Is(err, io.EOF) && len(sources) == 0 {
+				return nil
+			}
+			return sources
+		}
+		switch block.Type {
+		case "text":
+			if rawURL, title, ok := fetchedPage(block.Text); ok {
+				add(rawURL, title)
+			}
+		case "web_search_tool_result":
+			for _, entry := range block.Content {
+				if entry.Type == "" || entry.Type == "web_search_result" {
+					add(entry.URL, entry.Title)
+				}
+			}
+		}
+	}
+}
+
+// fetchedPage reads the URL and Title header lines fetch_url puts before
+// the page text.
+func fetchedPage(text string) (string, string, bool) {
+	first, rest, _ := strings.Cut(text, "\n")
+	rawURL, ok := strings.CutPrefix(first, "URL: ")
+	if !ok {
+		return "", "", false
+	}
+	second, _, _ := strings.Cut(rest, "\n")
+	title, _ := strings.CutPrefix(second, "Title: ")
+	if title == second {
+		title = ""
+	}
+	return rawURL, title, true
+}
+
+// isWebURL keeps sources to http and https links, which are safe to render.
+func isWebURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "https://") || strings.HasPrefix(rawURL, "http://")
+}
@@ -0,0 +1,105 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+)
+
+// JSONSchema is the schema subset providers accept for structured output:
+// type, properties, required, description, enum, items and
+// additionalProperties.
+type JSONSchema = types.JSONSchema
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// ParseOutputSchema decodes a client's structured-output schema. The root
+// must describe an object, which is what every provider requires, and
+// keywords outside JSONSchema are refused rather than silently dropped.
+func ParseOutputSchema(raw []byte) (*JSONSchema, error) {
+	return parseObjectSchema(raw)
+}
+
+func parseObjectSchema(raw []byte) (*JSONSchema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var schema JSONSchema
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("decode schema: %w", err)
+	}
+	if schema.Type != "object" {
+		return nil, errors.New(`schema root must have "type": "object"`)
+	}
+	if err := checkSchema(&schema, "$"); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func checkSchema(schema *JSONSchema, path string) error {
+	if schema.Type == "" {
+		return fmt.Errorf("%s: schema needs a type", path)
+	}
+	if !slices.Contains(schemaTypes, schema.Type) {
+		return fmt.Errorf("%s: unknown type %q", path, schema.Type)
+	}
+	for _, name := range schema.Required {
+		if _, ok := schema.Properties[name]; !ok {
+			return fmt.Errorf("%s: required property %q is not defined", path, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(schema.Properties)) {
+		property := schema.Properties[name]
+		if err := checkSchema(&property, path+"."+name); err != nil {
+			return err
+		}
+	}
+	if schema.Items != nil {
+		return checkSchema(schema.Items, path+"[]")
+	}
+	return nil
+}
+
+// StructuredOutputError reports a reply that does not match the requested
+// output schema.
+type StructuredOutputError struct {
+	Problems []string
+}
+
+func (e *StructuredOutputError) Error() string {
+	return "reply does not match the output schema: " + strings.Join(e.Problems, "; ")
+}
+
+// ParseStructuredOutput checks a model's reply against schema and returns
+// it as compact JSON. A Markdown code fence around the reply is ignored,
+// since some models add one even when asked for bare JSON.
+func ParseStructuredOutput(schema *JSONSchema, reply string) (json.RawMessage, error) {
+	text := strings.TrimSpace(reply)
+	if fenced, ok := strings.CutPrefix(text, "```"); ok {
+		if body, ok := strings.CutSuffix(fenced, "```"); ok {
+			// The fence's first line may name the language.
+			if newline := strings.IndexByte(body, '\n'); newline >= 0 {
+				body = body[newline+1:]
+			}
+			text = strings.TrimSpace(body)
+		}
+	}
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, &StructuredOutputError{Problems: []string{fmt.Sprintf("not valid JSON: %v", err)}}
+	}
+	if problems := validateSchemaValue(schema, value, "$"); len(problems) > 0 {
+		return nil, &StructuredOutputError{Problems: problems}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(text)); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
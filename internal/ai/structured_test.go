@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStructuredOutputIsRequestedAndChecked(t *testing.T) {
+	if _, err := ParseOutputSchema([]byte(`{"type":"object","properties":{"n":{"type":"integer","minimum":1}}}`)); err == nil {
+		t.Fatal("ParseOutputSchema() accepted an unsupported keyword")
+	}
+	if _, err := ParseOutputSchema([]byte(`{"type":"array","items":{"type":"string"}}`)); err == nil {
+		t.Fatal("ParseOutputSchema() accepted a non-object root")
+	}
+	if _, err := ParseOutputSchema([]byte(`{"type":"object","required":["n"]}`)); err == nil {
+		t.Fatal("ParseOutputSchema() accepted an undefined required property")
+	}
+	schema, err := ParseOutputSchema([]byte(`{"type":"object","properties":{"title":{"type":"string"},"tags":{"type":"array","items":{"type":"string"}}},"required":["title"],"additionalProperties":false}`))
+	if err != nil {
+		t.Fatalf("ParseOutputSchema() error = %v", err)
+	}
+
+	runner := NewRunner(RunnerConfig{})
+	preview, err := runner.Preview("oai-resp/gpt-5-mini", []Message{{Role: "user", Content: "hi"}}, StreamOptions{OutputSchema: schema})
+	if err != nil {
+		t.Fatalf("Preview() error = %v", err)
+	}
+	if !strings.Contains(string(preview.Request), `"output_format"`) || !strings.Contains(string(preview.Request), `"json_schema"`) {
+		t.Fatalf("Preview().Request = %s, want the output format", preview.Request)
+	}
+
+	parsed, err := ParseStructuredOutput(schema, "```json\n{\"title\": \"Notes\", \"tags\": [\"a\"]}\n```")
+	if err != nil {
+		t.Fatalf("ParseStructuredOutput() error = %v", err)
+	}
+	if string(parsed) != `{"title":"Notes","tags":["a"]}` {
+		t.Fatalf("ParseStructuredOutput() = %s", parsed)
+	}
+	_, err = ParseStructuredOutput(schema, `{"tags":[1],"extra":true}`)
+	var outputErr *StructuredOutputError
+	if !errors.As(err, &outputErr) || len(outputErr.Problems) != 3 {
+		t.Fatalf("ParseStructuredOutput() error = %v, want the missing title, the bad tag and the extra property", err)
+	}
+	if _, err := ParseStructuredOutput(schema, "Sure! Here it is."); !errors.As(err, &outputErr) {
+		t.Fatalf("ParseStructuredOutput() of prose error = %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// ProviderLogConfig controls the per-provider-call debug log. Attribute names
+// follow the OpenTelemetry GenAI semantic conventions so the records can be
+// shipped through any slog -> OTLP bridge unchanged.
+type ProviderLogConfig struct {
+	Enabled bool
+	// IncludeContent adds request and response text to each record. Off by
+	// default because prompts and answers may contain user data.
+	IncludeContent bool
+	Logger         *slog.Logger
+}
+
+type providerCallLog struct {
+	cfg           ProviderLogConfig
+	runID         string
+	selectedModel string
+	turn          int
+	callStarted   time.Time
+}
+
+func newProviderCallLog(cfg ProviderLogConfig, runID, selectedModel string) *providerCallLog {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &providerCallLog{cfg: cfg, runID: runID, selectedModel: selectedModel}
+}
+
+// runOptions returns the hooks that log each provider call. The SDK keeps a
+// single after-response hook, so next, when set, is called from it too.
+func (l *providerCallLog) runOptions(next func(*vai.Response)) []vai.RunOption {
+	if l == nil {
+		if next == nil {
+			return nil
+		}
+		return []vai.RunOption{vai.WithAfterResponse(next)}
+	}
+	return []vai.RunOption{
+		vai.WithBeforeCall(l.beforeCall),
+		vai.WithAfterResponse(func(resp *vai.Response) {
+			l.afterResponse(resp)
+			if next != nil {
+				next(resp)
+			}
+		}),
+	}
+}
+
+func (l *providerCallLog) beforeCall(req *vai.MessageRequest) {
+	l.turn++
+	l.callStarted = time.Now()
+	attrs := []any{
+		"run_id", l.runID,
+		"gen_ai.system", providerOf(req.Model),
+		"gen_ai.request.model", req.Model,
+		"gen_ai.request.message_count", len(req.Messages),
+		"gen_ai.request.tool_count", len(req.Tools),
+		"turn", l.turn,
+	}
+	if req.Temperature != nil {
+		attrs = append(attrs, "gen_ai.request.temperature", *req.Temperature)
+	}
+	if req.MaxTokens > 0 {
+		attrs = append(attrs, "gen_ai.request.max_tokens", req.MaxTokens)
+	}
+	if l.cfg.IncludeContent {
+		attrs = append(attrs, "gen_ai.prompt", requestText(req))
+	}
+	l.cfg.Logger.Info("provider call started", attrs...)
+}
+
+func (l *providerCallLog) afterResponse(resp *vai.Response) {
+	if resp == nil || resp.MessageResponse == nil {
+		return
+	}
+	attrs := []any{
+		"run_id", l.runID,
+		"gen_ai.system", providerOf(l.selectedModel),
+		"gen_ai.request.model", l.selectedModel,
+		"gen_ai.response.model", resp.Model,
+		"gen_ai.response.id", resp.ID,
+		"gen_ai.response.finish_reasons", []string{string(resp.StopReason)},
+		"gen_ai.usage.input_tokens", resp.Usage.InputTokens,
+		"gen_ai.usage.output_tokens", resp.Usage.OutputTokens,
+		"turn", l.turn,
+		"duration_ms", time.Since(l.callStarted).Milliseconds(),
+	}
+	if resp.Usage.CostUSD != nil {
+		attrs = append(attrs, "gen_ai.usage.cost_usd", *resp.Usage.CostUSD)
+	}
+	if l.cfg.IncludeContent {
+		attrs = append(attrs, "gen_ai.completion", resp.TextContent())
+	}
+	l.cfg.Logger.Info("provider call finished", attrs...)
+}
+
+func (l *providerCallLog) failed(err error) {
+	if l == nil || err == nil {
+		return
+	}
+	level := slog.LevelWarn
+	if errors.Is(err, context.Canceled) {
+		level = slog.LevelInfo
+	}
+	attrs := []any{
+		"run_id", l.runID,
+		"gen_ai.system", providerOf(l.selectedModel),
+		"gen_ai.request.model", l.selectedModel,
+		"turn", l.turn,
+		"error.type", errorType(err),
+	}
+	if !l.callStarted.IsZero() {
+		attrs = append(attrs, "duration_ms", time.Since(l.callStarted).Milliseconds())
+	}
+	l.cfg.Logger.Log(context.Background(), level, "provider call failed", attrs...)
+}
+
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrRunTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	if kind := ErrorKind(err); kind != "" {
+		return kind
+	}
+	return "provider_error"
+}
+
+func providerOf(model string) string {
+	provider, _, found := strings.Cut(model, "/")
+	if !found {
+		return "unknown"
+	}
+	return provider
+}
+
+func requestText(req *vai.MessageRequest) string {
+	parts := make([]string, 0, len(req.Messages)+1)
+	if system, ok := req.System.(string); ok && system != "" {
+		parts = append(parts, "system: "+system)
+	}
+	for _, message := range req.Messages {
+		parts = append(parts, message.Role+": "+message.TextContent())
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,47 @@
+package ai
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+func TestProviderCallLogOmitsContentByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	callLog := newProviderCallLog(ProviderLogConfig{
+		Enabled: true,
+		Logger:  slog.New(slog.NewTextHandler(&buf, nil)),
+	}, "run-1", "oai-resp/gpt-5-mini")
+
+	callLog.beforeCall(&vai.MessageRequest{
+		Model:    "oai-resp/gpt-5-mini",
+		System:   "secret system prompt",
+		Messages: []vai.Message{{Role: "user", Content: []vai.ContentBlock{vai.Text("secret question")}}},
+	})
+	callLog.afterResponse(&vai.Response{MessageResponse: &types.MessageResponse{
+		Model:      "gpt-5-mini",
+		StopReason: types.StopReasonEndTurn,
+		Usage:      types.Usage{InputTokens: 12, OutputTokens: 34},
+		Content:    []types.ContentBlock{types.TextBlock{Type: "text", Text: "secret answer"}},
+	}})
+
+	out := buf.String()
+	if strings.Contains(out, "secret") {
+		t.Fatalf("log output contains content: %s", out)
+	}
+	for _, want := range []string{"gen_ai.usage.input_tokens=12", "gen_ai.usage.output_tokens=34", "run_id=run-1", "gen_ai.system=oai-resp"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("log output missing %q: %s", want, out)
+		}
+	}
+}
+
+func TestProviderCallLogDisabledIsNil(t *testing.T) {
+	if callLog := newProviderCallLog(ProviderLogConfig{}, "run-1", "oai-resp/gpt-5-mini"); callLog != nil {
+		t.Fatalf("newProviderCallLog() = %v, want nil when disabled", callLog)
+	}
+}
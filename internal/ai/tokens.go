@@ -0,0 +1,51 @@
+package ai
+
+import "unicode/utf8"
+
+const (
+	// messageOverheadTokens covers the role and framing of each message.
+	messageOverheadTokens = 4
+	// imageTokens is a conservative per-image cost; providers charge
+	// roughly this much for a typical screenshot or photo.
+	imageTokens = 1600
+)
+
+// EstimateTokens approximates the token count of text without a provider
+// tokenizer: about four ASCII bytes per token, and one token per non-ASCII
+// character, which keeps CJK and other scripts from being undercounted.
+func EstimateTokens(text string) int {
+	var counter tokenCounter
+	counter.add(text)
+	return counter.tokens()
+}
+
+// EstimateMessageTokens approximates the tokens message takes in a request.
+func EstimateMessageTokens(message Message) int {
+	return messageOverheadTokens + EstimateTokens(message.Content) + len(message.Images)*imageTokens
+}
+
+// TruncateToTokens cuts text so EstimateTokens stays within limit, at a rune
+// boundary.
+func TruncateToTokens(text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if EstimateTokens(text) <= limit {
+		return text
+	}
+	ascii, other := 0, 0
+	for i := 0; i < len(text); {
+		size := 1
+		if text[i] < utf8.RuneSelf {
+			ascii++
+		} else {
+			_, size = utf8.DecodeRuneInString(text[i:])
+			other++
+		}
+		if (ascii+3)/4+other > limit {
+			return text[:i]
+		}
+		i += size
+	}
+	return text
+}
@@ -0,0 +1,194 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// ToolHandler executes a tool call. The returned value is JSON encoded (or
+// used as-is when it is a string) and sent back to the model.
+type ToolHandler func(ctx context.Context, input json.RawMessage) (any, error)
+
+// Tool is a tool the model may call during a run. Function tools carry a
+// Handler that the runner executes; native tools (such as provider web
+// search) are executed by the provider and have no handler.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema *types.JSONSchema
+	// OutputSchema, when set, is checked against every handler result;
+	// results that do not match are returned as a ToolOutputError.
+	OutputSchema *types.JSONSchema
+	Handler      ToolHandler
+	// Timeout overrides RunnerConfig.ToolTimeout for this tool when > 0.
+	Timeout time.Duration
+	// Source records where the tool came from (ToolSourceBuiltin when
+	// empty), for the tool catalog.
+	Source string
+
+	native *vai.Tool
+}
+
+// Tool sources.
+const (
+	ToolSourceBuiltin = "builtin"
+	ToolSourceConfig  = "config"
+	ToolSourceMCP     = "mcp"
+	ToolSourceWebhook = "webhook"
+)
+
+// IsNative reports whether the provider executes the tool itself.
+func (t Tool) IsNative() bool {
+	return t.native != nil
+}
+
+// NewTool builds a function tool whose input schema is derived from T.
+func NewTool[T any](name, description string, handler func(ctx context.Context, input T) (any, error)) Tool {
+	return Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: vai.SchemaFromStruct[T](),
+		Handler: func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var input T
+			if len(raw) > 0 {
+				if err := json.Unmarshal(raw, &input); err != nil {
+					return nil, fmt.Errorf("decode %s input: %w", name, err)
+				}
+			}
+			return handler(ctx, input)
+		},
+	}
+}
+
+// NativeTool registers a provider-executed tool under name.
+func NativeTool(name, description string, tool vai.Tool) Tool {
+	return Tool{Name: name, Description: description, native: &tool}
+}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolRegistry holds the tools offered to the model. It is safe for
+// concurrent use; tools registered after startup apply to the next run.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool)}
+}
+
+// DefaultToolRegistry returns a registry with the built-in tools.
+func DefaultToolRegistry() *ToolRegistry {
+	registry := NewToolRegistry()
+	_ = registry.Register(NativeTool("web_search", "Search the web for current information.", vai.WebSearch()))
+	return registry
+}
+
+func (r *ToolRegistry) Register(tool Tool) error {
+	if !toolNamePattern.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+	if !tool.IsNative() && tool.Handler == nil {
+		return fmt.Errorf("tool %q has no handler", tool.Name)
+	}
+	if tool.Source == "" {
+		tool.Source = ToolSourceBuiltin
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool %q is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+// Unregister removes the named tool and reports whether it was registered.
+func (r *ToolRegistry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.tools[name]
+	delete(r.tools, name)
+	return ok
+}
+
+func (r *ToolRegistry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Tools returns the registered tools sorted by name.
+func (r *ToolRegistry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		tools = append(tools, tool)
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+// requestTools converts the registry, minus the disabled tools, into request
+// tool definitions and the handlers that execute function tools, by name.
+func (r *ToolRegistry) requestTools(defaultTimeout time.Duration, disabled []string) ([]vai.Tool, map[string]vai.ToolHandler) {
+	tools := r.Tools()
+	definitions := make([]vai.Tool, 0, len(tools))
+	handlers := make(map[string]vai.ToolHandler, len(tools))
+	for _, tool := range tools {
+		if slices.Contains(disabled, tool.Name) {
+			continue
+		}
+		if tool.IsNative() {
+			definitions = append(definitions, *tool.native)
+			continue
+		}
+		definitions = append(definitions, vai.Tool{
+			Type:        types.ToolTypeFunction,
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+		handlers[tool.Name] = tool.handlerWithTimeout(defaultTimeout)
+	}
+	return definitions, handlers
+}
+
+func (t Tool) handlerWithTimeout(defaultTimeout time.Duration) vai.ToolHandler {
+	timeout := defaultTimeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	return func(ctx context.Context, input json.RawMessage) (any, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		result, err := t.Handler(ctx, input)
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("tool %q timed out after %s: %w", t.Name, timeout, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := validateToolOutput(t.Name, t.OutputSchema, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
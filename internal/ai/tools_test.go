@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+)
+
+func TestToolRegistryRejectsInvalidAndDuplicateTools(t *testing.T) {
+	registry := NewToolRegistry()
+	echo := NewTool("echo", "Echo the input.", func(ctx context.Context, input struct {
+		Text string `json:"text"`
+	}) (any, error) {
+		return input.Text, nil
+	})
+
+	if err := registry.Register(echo); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := registry.Register(echo); err == nil {
+		t.Fatalf("Register() duplicate expected error")
+	}
+	if err := registry.Register(Tool{Name: "bad name", Handler: echo.Handler}); err == nil {
+		t.Fatalf("Register() invalid name expected error")
+	}
+	if err := registry.Register(Tool{Name: "no_handler"}); err == nil {
+		t.Fatalf("Register() missing handler expected error")
+	}
+}
+
+func TestToolRegistryRequestToolsIncludesNativeAndFunctionTools(t *testing.T) {
+	registry := DefaultToolRegistry()
+	if err := registry.Register(NewTool("echo", "Echo the input.", func(ctx context.Context, input struct {
+		Text string `json:"text"`
+	}) (any, error) {
+		return input.Text, nil
+	})); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	definitions, opts := registry.requestTools(time.Second, nil)
+	if len(definitions) != 2 {
+		t.Fatalf("len(definitions) = %d, want 2", len(definitions))
+	}
+	if definitions[0].Type != types.ToolTypeFunction || definitions[0].Name != "echo" {
+		t.Fatalf("definitions[0] = %+v, want echo function tool", definitions[0])
+	}
+	if definitions[0].InputSchema == nil || definitions[0].InputSchema.Properties["text"].Type != "string" {
+		t.Fatalf("echo schema = %+v, want text property", definitions[0].InputSchema)
+	}
+	if len(opts) != 1 {
+		t.Fatalf("len(opts) = %d, want one handler for the function tool", len(opts))
+	}
+
+	definitions, opts = registry.requestTools(time.Second, []string{"echo"})
+	if len(definitions) != 1 || len(opts) != 0 {
+		t.Fatalf("with echo disabled got %d definitions, %d handlers; want 1, 0", len(definitions), len(opts))
+	}
+}
+
+func TestToolHandlerTimeout(t *testing.T) {
+	tool := Tool{
+		Name:    "slow",
+		Timeout: 10 * time.Millisecond,
+		Handler: func(ctx context.Context, _ json.RawMessage) (any, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	_, err := tool.handlerWithTimeout(time.Minute)(context.Background(), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("handler error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestToolHandlerValidatesOutputSchema(t *testing.T) {
+	schema := &types.JSONSchema{
+		Type:     "object",
+		Required: []string{"status", "count"},
+		Properties: map[string]types.JSONSchema{
+			"status": {Type: "string", Enum: []string{"ok", "failed"}},
+			"count":  {Type: "integer"},
+		},
+	}
+	output := any(map[string]any{"status": "ok", "count": 3})
+	tool := Tool{
+		Name:         "report",
+		OutputSchema: schema,
+		Handler: func(ctx context.Context, _ json.RawMessage) (any, error) {
+			return output, nil
+		},
+	}
+	handler := tool.handlerWithTimeout(time.Second)
+
+	if _, err := handler(context.Background(), nil); err != nil {
+		t.Fatalf("valid output error = %v", err)
+	}
+	output = `{"status":"ok","count":3}`
+	if _, err := handler(context.Background(), nil); err != nil {
+		t.Fatalf("valid JSON string output error = %v", err)
+	}
+
+	output = map[string]any{"status": "maybe", "count": 1.5}
+	_, err := handler(context.Background(), nil)
+	var outputErr *ToolOutputError
+	if !errors.As(err, &outputErr) {
+		t.Fatalf("invalid output error = %v, want ToolOutputError", err)
+	}
+	if len(outputErr.Problems) != 2 {
+		t.Fatalf("problems = %v, want enum and integer violations", outputErr.Problems)
+	}
+	var decoded struct {
+		Error string `json:"error"`
+		Tool  string `json:"tool"`
+	}
+	if err := json.Unmarshal([]byte(outputErr.Error()), &decoded); err != nil || decoded.Error != "invalid_tool_output" || decoded.Tool != "report" {
+		t.Fatalf("error message = %q, want structured JSON", outputErr.Error())
+	}
+
+	output = "not json"
+	if _, err := handler(context.Background(), nil); !errors.As(err, &outputErr) {
+		t.Fatalf("non-JSON output error = %v, want ToolOutputError", err)
+	}
+}
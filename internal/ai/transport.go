@@ -0,0 +1,201 @@
+package ai
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/vango-go/vai-lite/pkg/core"
+	"github.com/vango-go/vai-lite/pkg/core/providers/anthropic"
+	"github.com/vango-go/vai-lite/pkg/core/providers/gemini"
+	"github.com/vango-go/vai-lite/pkg/core/providers/oai_resp"
+	"github.com/vango-go/vai-lite/pkg/core/providers/openai"
+	"github.com/vango-go/vai-lite/pkg/core/types"
+	vai "github.com/vango-go/vai-lite/sdk"
+)
+
+// TransportConfig routes provider requests through an egress proxy or an
+// API gateway. The zero value leaves the client's defaults: the system
+// roots and the HTTPS_PROXY and NO_PROXY environment variables.
+type TransportConfig struct {
+	// ProxyURL is the http, https or socks5 proxy for provider requests.
+	ProxyURL string
+	// BaseURLs replace a provider's API endpoint, by KeyProviders name. The
+	// OpenAI one also serves "oai-resp" models.
+	BaseURLs map[string]string
+	// CAFile is a PEM bundle trusted on top of the system roots, for a
+	// proxy or gateway that signs with a private CA.
+	CAFile string
+	// ClientCertFile and ClientKeyFile present a client certificate to a
+	// gateway that requires mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+	// InsecureSkipVerify accepts any server certificate. It is meant for
+	// trying out a gateway, never for production.
+	InsecureSkipVerify bool
+}
+
+// Transport is a checked TransportConfig, ready for the runner's clients.
+type Transport struct {
+	client   *http.Client
+	baseURLs map[string]string
+}
+
+// NewTransport checks cfg and loads its certificates. A zero cfg returns a
+// nil Transport: the clients keep their defaults.
+func NewTransport(cfg TransportConfig) (*Transport, error) {
+	if cfg.ProxyURL == "" && len(cfg.BaseURLs) == 0 && cfg.CAFile == "" && cfg.ClientCertFile == "" && cfg.ClientKeyFile == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+	for provider, base := range cfg.BaseURLs {
+		if !slices.Contains(KeyProviders, provider) {
+			return nil, fmt.Errorf("base URL for unknown provider %q", provider)
+		}
+		if err := checkURL(base, "http", "https"); err != nil {
+			return nil, fmt.Errorf("%s base URL: %w", provider, err)
+		}
+	}
+
+	base, _ := http.DefaultTransport.(*http.Transport)
+	transport := base.Clone()
+	if cfg.ProxyURL != "" {
+		if err := checkURL(cfg.ProxyURL, "http", "https", "socks5"); err != nil {
+			return nil, fmt.Errorf("proxy URL: %w", err)
+		}
+		proxy, _ := url.Parse(cfg.ProxyURL)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s has no PEM certificates", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, errors.New("a client certificate needs both the certificate and the key file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return &Transport{client: &http.Client{Transport: transport}, baseURLs: cfg.BaseURLs}, nil
+}
+
+func checkURL(raw string, schemes ...string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(schemes, parsed.Scheme) || parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute %s URL", raw, strings.Join(schemes, ", "))
+	}
+	return nil
+}
+
+// newClient builds a client with keys by KeyProviders name, falling back to
+// the environment keys like vai.NewClient. With a transport, the providers
+// the client found keys for are registered again to send through it.
+func newClient(transport *Transport, keys map[string]string) *vai.Client {
+	options := make([]vai.ClientOption, 0, len(keys))
+	for provider, key := range keys {
+		options = append(options, vai.WithProviderKey(provider, key))
+	}
+	client := vai.NewClient(options...)
+	if transport == nil {
+		return client
+	}
+	engine := client.Engine()
+	key := func(provider string) string {
+		if key := engine.GetAPIKey(provider); key != "" || provider != "gemini" {
+			return key
+		}
+		return os.Getenv("GOOGLE_API_KEY")
+	}
+	if key := key("anthropic"); key != "" {
+		options := []anthropic.Option{anthropic.WithHTTPClient(transport.client)}
+		if base := transport.baseURLs["anthropic"]; base != "" {
+			options = append(options, anthropic.WithBaseURL(base))
+		}
+		p := anthropic.New(key, options...)
+		engine.RegisterProvider(sdkProvider(p.Name(), core.ProviderCapabilities(p.Capabilities()), p.CreateMessage, p.StreamMessage))
+	}
+	if key := key("openai"); key != "" {
+		chatOptions := []openai.Option{openai.WithHTTPClient(transport.client)}
+		respOptions := []oai_resp.Option{oai_resp.WithHTTPClient(transport.client)}
+		if base := transport.baseURLs["openai"]; base != "" {
+			chatOptions = append(chatOptions, openai.WithBaseURL(base))
+			respOptions = append(respOptions, oai_resp.WithBaseURL(base))
+		}
+		chat := openai.New(key, chatOptions...)
+		engine.RegisterProvider(sdkProvider(chat.Name(), core.ProviderCapabilities(chat.Capabilities()), chat.CreateMessage, chat.StreamMessage))
+		resp := oai_resp.New(key, respOptions...)
+		engine.RegisterProvider(sdkProvider(resp.Name(), core.ProviderCapabilities(resp.Capabilities()), resp.CreateMessage, resp.StreamMessage))
+	}
+	if key := key("gemini"); key != "" {
+		options := []gemini.Option{gemini.WithHTTPClient(transport.client)}
+		if base := transport.baseURLs["gemini"]; base != "" {
+			options = append(options, gemini.WithBaseURL(base))
+		}
+		p := gemini.New(key, options...)
+		engine.RegisterProvider(sdkProvider(p.Name(), core.ProviderCapabilities(p.Capabilities()), p.CreateMessage, p.StreamMessage))
+	}
+	return client
+}
+
+// providerAdapter is the core.Provider the SDK builds for each provider,
+// which it does not export.
+type providerAdapter struct {
+	name   string
+	caps   core.ProviderCapabilities
+	create func(context.Context, *types.MessageRequest) (*types.MessageResponse, error)
+	stream func(context.Context, *types.MessageRequest) (core.EventStream, error)
+}
+
+// sdkProvider adapts a provider package's Provider, whose stream type has
+// the methods of core.EventStream under its own name.
+func sdkProvider[S core.EventStream](name string, caps core.ProviderCapabilities, create func(context.Context, *types.MessageRequest) (*types.MessageResponse, error), stream func(context.Context, *types.MessageRequest) (S, error)) *providerAdapter {
+	return &providerAdapter{
+		name:   name,
+		caps:   caps,
+		create: create,
+		stream: func(ctx context.Context, req *types.MessageRequest) (core.EventStream, error) {
+			events, err := stream(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return events, nil
+		},
+	}
+}
+
+func (p *providerAdapter) Name() string { return p.name }
+
+func (p *providerAdapter) Capabilities() core.ProviderCapabilities { return p.caps }
+
+func (p *providerAdapter) CreateMessage(ctx context.Context, req *types.MessageRequest) (*types.MessageResponse, error) {
+	return p.create(ctx, req)
+}
+
+func (p *providerAdapter) StreamMessage(ctx context.Context, req *types.MessageRequest) (core.EventStream, error) {
+	return p.stream(ctx, req)
+}
@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunnerSendsThroughTheConfiguredGateway(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "sk-server")
+	var path, key string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, key = r.URL.Path, r.Header.Get("x-api-key")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"gateway says no"}}`))
+	}))
+	defer gateway.Close()
+
+	transport, err := NewTransport(TransportConfig{BaseURLs: map[string]string{"anthropic": gateway.URL}})
+	if err != nil {
+		t.Fatalf("NewTransport() error = %v", err)
+	}
+	runner := NewRunner(RunnerConfig{Transport: transport})
+	_, err = runner.Stream(context.Background(), "anthropic/claude-haiku-4-5", []Message{{Role: "user", Content: "hi"}}, StreamOptions{DisableTools: true}, StreamCallbacks{})
+	if err == nil || !strings.Contains(err.Error(), "gateway says no") {
+		t.Fatalf("Stream() error = %v, want the gateway's error", err)
+	}
+	if path != "/v1/messages" || key != "sk-server" {
+		t.Fatalf("gateway saw %q with key %q", path, key)
+	}
+}
+
+func TestNewTransportChecksTheConfig(t *testing.T) {
+	if transport, err := NewTransport(TransportConfig{}); transport != nil || err != nil {
+		t.Fatalf("NewTransport(zero) = %v, %v; want nil, nil", transport, err)
+	}
+	for name, cfg := range map[string]TransportConfig{
+		"unknown provider": {BaseURLs: map[string]string{"groq": "https://gateway.example"}},
+		"relative base":    {BaseURLs: map[string]string{"openai": "gateway.example/v1"}},
+		"proxy scheme":     {ProxyURL: "ftp://proxy.example:21"},
+		"missing CA":       {CAFile: "/nonexistent/ca.pem"},
+		"cert without key": {ClientCertFile: "/etc/client.pem"},
+	} {
+		if _, err := NewTransport(cfg); err == nil {
+			t.Errorf("NewTransport(%s) error = nil", name)
+		}
+	}
+	if transport, err := NewTransport(TransportConfig{ProxyURL: "http://proxy.example:3128"}); err != nil || transport == nil {
+		t.Fatalf("NewTransport(proxy) = %v, %v", transport, err)
+	}
+}
@@ -0,0 +1,163 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+)
+
+// ToolOutputError reports a tool result that does not match the tool's
+// OutputSchema. Its message is a JSON object so the model receives a
+// structured error instead of the invalid output.
+type ToolOutputError struct {
+	Tool     string
+	Problems []string
+}
+
+func (e *ToolOutputError) Error() string {
+	encoded, _ := json.Marshal(struct {
+		Error    string   `json:"error"`
+		Tool     string   `json:"tool"`
+		Problems []string `json:"problems"`
+	}{
+		Error:    "invalid_tool_output",
+		Tool:     e.Tool,
+		Problems: e.Problems,
+	})
+	return string(encoded)
+}
+
+// validateToolOutput checks output against schema. String outputs are
+// parsed as JSON unless the schema itself expects a string.
+func validateToolOutput(name string, schema *types.JSONSchema, output any) error {
+	if schema == nil {
+		return nil
+	}
+	value, err := normalizeToolOutput(schema, output)
+	if err != nil {
+		return &ToolOutputError{Tool: name, Problems: []string{err.Error()}}
+	}
+	if problems := validateSchemaValue(schema, value, "$"); len(problems) > 0 {
+		return &ToolOutputError{Tool: name, Problems: problems}
+	}
+	return nil
+}
+
+func normalizeToolOutput(schema *types.JSONSchema, output any) (any, error) {
+	var raw []byte
+	switch v := output.(type) {
+	case string:
+		if schema.Type == "string" {
+			return v, nil
+		}
+		raw = []byte(v)
+	case json.RawMessage:
+		raw = v
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("output is not JSON encodable: %v", err)
+		}
+		raw = encoded
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("output is not valid JSON: %v", err)
+	}
+	return value, nil
+}
+
+// validateSchemaValue covers the JSONSchema subset vai supports: type,
+// properties, required, enum, items and additionalProperties.
+func validateSchemaValue(schema *types.JSONSchema, value any, path string) []string {
+	if schema.Type != "" && !matchesSchemaType(schema.Type, value) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, schema.Type, jsonTypeName(value))}
+	}
+	var problems []string
+	if len(schema.Enum) > 0 {
+		text, ok := value.(string)
+		if !ok || !slices.Contains(schema.Enum, text) {
+			problems = append(problems, fmt.Sprintf("%s: must be one of %v", path, schema.Enum))
+		}
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property %q", path, key))
+				}
+				continue
+			}
+			problems = append(problems, validateSchemaValue(&property, v[key], path+"."+key)...)
+		}
+	case []any:
+		if schema.Items != nil {
+			for i, item := range v {
+				problems = append(problems, validateSchemaValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+func matchesSchemaType(schemaType string, value any) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
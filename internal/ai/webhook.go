@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WebhookTool is an operator-defined tool served by an HTTP endpoint.
+type WebhookTool struct {
+	Name        string
+	Description string
+	// InputSchema describes the arguments the model sends; its root must be
+	// an object. Empty accepts any object.
+	InputSchema json.RawMessage
+	URL         string
+	// AuthHeader is sent with every call, as "Name: value" such as
+	// "Authorization: Bearer secret"; empty sends none.
+	AuthHeader string
+}
+
+// webhookMaxResponseBytes caps the response read from a webhook tool.
+const webhookMaxResponseBytes = 1 << 20
+
+// webhookClient does not follow redirects, so the auth header is never sent
+// anywhere but the configured URL.
+var webhookClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// NewWebhookTool checks cfg and returns a tool that POSTs the model's input
+// as JSON to cfg.URL. A JSON response goes back to the model as it is, any
+// other body as text; a status outside 2xx is a tool error. Calls run with
+// the runner's ToolTimeout.
+func NewWebhookTool(cfg WebhookTool) (Tool, error) {
+	if !toolNamePattern.MatchString(cfg.Name) {
+		return Tool{}, fmt.Errorf("invalid tool name %q", cfg.Name)
+	}
+	if err := checkURL(cfg.URL, "http", "https"); err != nil {
+		return Tool{}, fmt.Errorf("tool %s URL: %w", cfg.Name, err)
+	}
+	schema := &JSONSchema{Type: "object"}
+	if len(cfg.InputSchema) > 0 {
+		parsed, err := parseObjectSchema(cfg.InputSchema)
+		if err != nil {
+			return Tool{}, fmt.Errorf("tool %s input schema: %w", cfg.Name, err)
+		}
+		schema = parsed
+	}
+	var headerName, headerValue string
+	if cfg.AuthHeader != "" {
+		name, value, ok := strings.Cut(cfg.AuthHeader, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" || strings.ContainsAny(name, " \t") {
+			return Tool{}, fmt.Errorf("tool %s auth header must look like \"Name: value\"", cfg.Name)
+		}
+		headerName, headerValue = name, value
+	}
+	return Tool{
+		Name:        cfg.Name,
+		Description: cfg.Description,
+		InputSchema: schema,
+		Source:      ToolSourceWebhook,
+		Handler: func(ctx context.Context, input json.RawMessage) (any, error) {
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(input))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			if headerName != "" {
+				req.Header.Set(headerName, headerValue)
+			}
+			resp, err := webhookClient.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBytes+1))
+			if err != nil {
+				return nil, fmt.Errorf("read %s response: %w", cfg.Name, err)
+			}
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return nil, fmt.Errorf("%s returned %s: %s", cfg.Name, resp.Status, truncateForError(body))
+			}
+			if len(body) > webhookMaxResponseBytes {
+				return nil, errors.New(cfg.Name + " response is larger than 1 MiB")
+			}
+			if json.Valid(body) {
+				return json.RawMessage(body), nil
+			}
+			return string(body), nil
+		},
+	}, nil
+}
+
+func truncateForError(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 {
+		text = strings.ToValidUTF8(text[:200], "") + "..."
+	}
+	return text
+}
@@ -0,0 +1,66 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookToolPostsTheInputWithItsAuthHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case `{"city":"Lyon"}`:
+			_, _ = io.WriteString(w, `{"temp_c": 21}`)
+		case `{"city":"slow"}`:
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.Error(w, "unknown city", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := NewWebhookTool(WebhookTool{Name: "weather", URL: server.URL, AuthHeader: "secret"}); err == nil {
+		t.Fatal("NewWebhookTool() accepted an auth header without a name")
+	}
+	if _, err := NewWebhookTool(WebhookTool{Name: "weather", URL: "ftp://example.com"}); err == nil {
+		t.Fatal("NewWebhookTool() accepted a non-http URL")
+	}
+	tool, err := NewWebhookTool(WebhookTool{
+		Name:        "weather",
+		Description: "Current weather for a city.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+		URL:         server.URL,
+		AuthHeader:  "X-Api-Key: secret",
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookTool() error = %v", err)
+	}
+	if tool.Source != ToolSourceWebhook || tool.InputSchema.Required[0] != "city" {
+		t.Fatalf("NewWebhookTool() = %+v", tool)
+	}
+
+	handler := tool.handlerWithTimeout(50 * time.Millisecond)
+	result, err := handler(context.Background(), json.RawMessage(`{"city":"Lyon"}`))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if raw, ok := result.(json.RawMessage); !ok || string(raw) != `{"temp_c": 21}` {
+		t.Fatalf("handler() = %#v, want the JSON response", result)
+	}
+	if _, err := handler(context.Background(), json.RawMessage(`{"city":"Atlantis"}`)); err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "unknown city") {
+		t.Fatalf("handler() error = %v, want the status and body", err)
+	}
+	if _, err := handler(context.Background(), json.RawMessage(`{"city":"slow"}`)); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("handler() error = %v, want the tool timeout", err)
+	}
+}
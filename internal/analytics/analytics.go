@@ -0,0 +1,214 @@
+// Package analytics records anonymized product events, such as chats
+// created, runs completed, models switched and runs stopped, to show how
+// the app is used. Events never carry message content, titles or IDs: the
+// user is a salted hash, and each event keeps only its own few properties.
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+// Event names.
+const (
+	ChatCreated   = "chat_created"
+	RunCompleted  = "run_completed"
+	ModelSwitched = "model_switched"
+	StopPressed   = "stop_pressed"
+)
+
+// properties lists the properties each event keeps; any others are dropped
+// before the event is stored.
+var properties = map[string][]string{
+	ChatCreated:   {"model"},
+	RunCompleted:  {"model", "status", "turns", "tool_calls"},
+	ModelSwitched: {"from", "to"},
+	StopPressed:   {"model"},
+}
+
+// saltSetting is the app setting holding the key of actor hashes, created
+// on first use so the same user hashes the same way across restarts.
+const saltSetting = "analytics_salt"
+
+// Event is a recorded event.
+type Event struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Actor is the salted hash of the user, empty when there was none.
+	Actor      string         `json:"actor,omitempty"`
+	Properties map[string]any `json:"properties,omitempty"`
+	At         time.Time      `json:"at"`
+}
+
+// Count is how often an event happened on a UTC day, and for how many
+// distinct users.
+type Count struct {
+	Name   string `json:"name"`
+	Day    string `json:"day"`
+	Events int64  `json:"events"`
+	Actors int64  `json:"actors"`
+}
+
+// Query selects events: by Name when set, at or after Since, before Until
+// when set, at most Limit of them when it is positive.
+type Query struct {
+	Name  string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// Recorder stores events. A nil Recorder records nothing, so analytics can
+// be turned off without checks at every call site. It is safe for
+// concurrent use.
+type Recorder struct {
+	store *db.Store
+	now   func() time.Time
+
+	mu   sync.Mutex
+	salt []byte
+}
+
+// New returns a recorder storing events in store.
+func New(store *db.Store) *Recorder {
+	return &Recorder{store: store, now: time.Now}
+}
+
+// Known reports whether name is an event the recorder stores.
+func Known(name string) bool {
+	_, ok := properties[name]
+	return ok
+}
+
+// Record stores an event for userID with props, keeping only the
+// properties the event allows. Analytics must never get in a user's way,
+// so failures are logged rather than returned. Unknown events are dropped.
+func (r *Recorder) Record(ctx context.Context, name, userID string, props map[string]any) {
+	if r == nil {
+		return
+	}
+	allowed, ok := properties[name]
+	if !ok {
+		slog.Warn("unknown analytics event dropped", "event", name)
+		return
+	}
+	kept := map[string]any{}
+	for _, key := range allowed {
+		if value, ok := props[key]; ok {
+			kept[key] = value
+		}
+	}
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		slog.Warn("analytics event not recorded", "event", name, "error", err)
+		return
+	}
+	actor := ""
+	if userID != "" {
+		if actor, err = r.actor(ctx, userID); err != nil {
+			slog.Warn("analytics event not recorded", "event", name, "error", err)
+			return
+		}
+	}
+	if err := r.store.InsertAnalyticsEvent(ctx, db.AnalyticsEvent{
+		Name:           name,
+		Actor:          actor,
+		PropertiesJSON: string(encoded),
+		CreatedAt:      r.now().UTC(),
+	}); err != nil {
+		slog.Warn("analytics event not recorded", "event", name, "error", err)
+	}
+}
+
+// Events returns the events matching query, newest first.
+func (r *Recorder) Events(ctx context.Context, query Query) ([]Event, error) {
+	if r == nil {
+		return []Event{}, nil
+	}
+	rows, err := r.store.ListAnalyticsEvents(ctx, query.filter())
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		event := Event{ID: row.ID, Name: row.Name, Actor: row.Actor, At: row.CreatedAt}
+		if err := json.Unmarshal([]byte(row.PropertiesJSON), &event.Properties); err != nil {
+			return nil, fmt.Errorf("decode analytics event %d: %w", row.ID, err)
+		}
+		if len(event.Properties) == 0 {
+			event.Properties = nil
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Counts counts the events matching query by name and UTC day, in day
+// then name order. The query's Limit is ignored.
+func (r *Recorder) Counts(ctx context.Context, query Query) ([]Count, error) {
+	if r == nil {
+		return []Count{}, nil
+	}
+	rows, err := r.store.CountAnalyticsEvents(ctx, query.filter())
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]Count, 0, len(rows))
+	for _, row := range rows {
+		counts = append(counts, Count{Name: row.Name, Day: row.Day, Events: row.Events, Actors: row.Actors})
+	}
+	return counts, nil
+}
+
+func (q Query) filter() db.AnalyticsFilter {
+	return db.AnalyticsFilter{Name: q.Name, Since: q.Since, Until: q.Until, Limit: q.Limit}
+}
+
+// actor hashes userID with the stored salt, creating the salt first if
+// there is none yet.
+func (r *Recorder) actor(ctx context.Context, userID string) (string, error) {
+	salt, err := r.loadSalt(ctx)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil)[:12]), nil
+}
+
+func (r *Recorder) loadSalt(ctx context.Context) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.salt != nil {
+		return r.salt, nil
+	}
+	stored, err := r.store.GetSetting(ctx, saltSetting)
+	if errors.Is(err, db.ErrNotFound) {
+		fresh := make([]byte, 32)
+		if _, err := rand.Read(fresh); err != nil {
+			return nil, fmt.Errorf("generate analytics salt: %w", err)
+		}
+		stored = hex.EncodeToString(fresh)
+		err = r.store.SetSetting(ctx, saltSetting, stored, r.now().UTC())
+	}
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(stored)
+	if err != nil {
+		return nil, fmt.Errorf("decode analytics salt: %w", err)
+	}
+	r.salt = salt
+	return salt, nil
+}
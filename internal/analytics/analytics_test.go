@@ -0,0 +1,71 @@
+package analytics
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+func TestRecorderStoresAnonymizedEventsAndCountsThem(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	recorder := New(store)
+	clock := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return clock }
+	recorder.Record(ctx, ChatCreated, "alice@example.com", map[string]any{"model": "mock/echo", "title": "Secret plans"})
+	recorder.Record(ctx, RunCompleted, "alice@example.com", map[string]any{"model": "mock/echo", "status": "completed"})
+	clock = clock.Add(2 * time.Hour)
+	recorder.Record(ctx, RunCompleted, "bob@example.com", map[string]any{"model": "mock/echo", "status": "error"})
+	recorder.Record(ctx, RunCompleted, "alice@example.com", map[string]any{"model": "mock/echo", "status": "completed"})
+	recorder.Record(ctx, "page_viewed", "bob@example.com", nil)
+
+	events, err := recorder.Events(ctx, Query{})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	if len(events) != 4 || events[0].Name != RunCompleted || events[3].Name != ChatCreated {
+		t.Fatalf("Events() = %+v, want the four known events newest first", events)
+	}
+	created := events[3]
+	if _, ok := created.Properties["title"]; ok || created.Properties["model"] != "mock/echo" {
+		t.Fatalf("chat_created properties = %v, want only the model", created.Properties)
+	}
+	if created.Actor == "" || strings.Contains(created.Actor, "alice") || created.Actor != events[0].Actor || created.Actor == events[1].Actor {
+		t.Fatalf("actors = %q, %q, %q, want one stable hash per user", created.Actor, events[0].Actor, events[1].Actor)
+	}
+
+	// A new recorder reuses the stored salt.
+	again := New(store)
+	again.Record(ctx, StopPressed, "alice@example.com", map[string]any{"model": "mock/echo"})
+	stops, err := again.Events(ctx, Query{Name: StopPressed, Limit: 1})
+	if err != nil || len(stops) != 1 || stops[0].Actor != created.Actor {
+		t.Fatalf("Events(stop_pressed) = %+v, %v, want alice's hash", stops, err)
+	}
+
+	counts, err := recorder.Counts(ctx, Query{Name: RunCompleted, Since: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Counts() error = %v", err)
+	}
+	want := []Count{
+		{Name: RunCompleted, Day: "2026-03-01", Events: 1, Actors: 1},
+		{Name: RunCompleted, Day: "2026-03-02", Events: 2, Actors: 2},
+	}
+	if len(counts) != len(want) || counts[0] != want[0] || counts[1] != want[1] {
+		t.Fatalf("Counts() = %+v, want %+v", counts, want)
+	}
+
+	var disabled *Recorder
+	disabled.Record(ctx, ChatCreated, "alice@example.com", nil)
+	if events, err := disabled.Events(ctx, Query{}); err != nil || len(events) != 0 {
+		t.Fatalf("nil Recorder Events() = %v, %v", events, err)
+	}
+}
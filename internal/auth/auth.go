@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthenticated is returned by an Authenticator when the request carries
+// no identity it recognizes.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// AnonymousUserID identifies the implicit single user when auth is disabled.
+const AnonymousUserID = "anonymous"
+
+// Principal is the application-level identity of the caller.
+type Principal struct {
+	UserID string
+	Email  string
+	Name   string
+	Roles  []string
+}
+
+func (p Principal) IsZero() bool {
+	return p.UserID == ""
+}
+
+func (p Principal) HasRole(role string) bool {
+	for _, candidate := range p.Roles {
+		if strings.EqualFold(candidate, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the caller of an HTTP request. Implementations return
+// ErrUnauthenticated when the request is not theirs to accept so they can be
+// chained; any other error aborts authentication.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, error) {
+	return f(r)
+}
+
+// Chain tries each authenticator in order and returns the first principal.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(r)
+			if errors.Is(err, ErrUnauthenticated) {
+				continue
+			}
+			return principal, err
+		}
+		return Principal{}, ErrUnauthenticated
+	})
+}
+
+// Anonymous accepts every request as the single local user.
+func Anonymous() Authenticator {
+	return AuthenticatorFunc(func(*http.Request) (Principal, error) {
+		return Principal{UserID: AnonymousUserID, Name: "Local user"}, nil
+	})
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok && !principal.IsZero()
+}
+
+// New builds the authenticator for a configured mode: "none" (single local
+// user), "header" (trusted reverse-proxy headers) or "mtls" (client
+// certificates, checked by this process or forwarded by a proxy).
+// Deployments with other schemes can implement Authenticator directly and
+// pass it to the routes layer instead.
+func New(mode string, header HeaderConfig, mtls MTLSConfig) (Authenticator, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "none":
+		return Anonymous(), nil
+	case "header":
+		return NewHeaderAuthenticator(header)
+	case "mtls":
+		return NewMTLSAuthenticator(mtls)
+	default:
+		return nil, errors.New("unknown auth mode " + mode)
+	}
+}
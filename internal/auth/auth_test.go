@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHeaderAuthenticatorTrustsOnlyConfiguredProxies(t *testing.T) {
+	authenticator, err := NewHeaderAuthenticator(HeaderConfig{
+		EmailHeader:    "X-Forwarded-Email",
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatalf("NewHeaderAuthenticator() error = %v", err)
+	}
+
+	trusted := httptest.NewRequest("GET", "/", nil)
+	trusted.RemoteAddr = "10.1.2.3:5000"
+	trusted.Header.Set("X-Forwarded-User", "alice")
+	trusted.Header.Set("X-Forwarded-Email", "alice@example.com")
+	principal, err := authenticator.Authenticate(trusted)
+	if err != nil {
+		t.Fatalf("Authenticate(trusted) error = %v", err)
+	}
+	if principal.UserID != "alice" || principal.Email != "alice@example.com" {
+		t.Fatalf("principal = %+v", principal)
+	}
+
+	spoofed := httptest.NewRequest("GET", "/", nil)
+	spoofed.RemoteAddr = "203.0.113.9:5000"
+	spoofed.Header.Set("X-Forwarded-User", "alice")
+	if _, err := authenticator.Authenticate(spoofed); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("Authenticate(spoofed) error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestChainFallsThroughUnauthenticated(t *testing.T) {
+	reject := AuthenticatorFunc(func(*http.Request) (Principal, error) {
+		return Principal{}, ErrUnauthenticated
+	})
+	chain := Chain(reject, Anonymous())
+
+	principal, err := chain.Authenticate(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if principal.UserID != AnonymousUserID {
+		t.Fatalf("principal.UserID = %q, want %q", principal.UserID, AnonymousUserID)
+	}
+}
+
+func TestAdminTokenAcceptsOnlyTheConfiguredBearer(t *testing.T) {
+	authenticator := AdminToken("s3cret")
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	principal, err := authenticator.Authenticate(request)
+	if err != nil || principal.UserID != AdminTokenUserID || !principal.HasRole("admin") {
+		t.Fatalf("Authenticate(token) = %+v, %v; want the admin principal", principal, err)
+	}
+
+	for _, header := range []string{"", "Bearer wrong", "Basic s3cret"} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Authorization", header)
+		if _, err := authenticator.Authenticate(request); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("Authenticate(%q) error = %v, want ErrUnauthenticated", header, err)
+		}
+	}
+	if _, err := AdminToken("").Authenticate(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("empty token accepted a request without a bearer: %v", err)
+	}
+}
+
+func TestMTLSAuthenticatorReadsForwardedCertificatesFromTrustedProxies(t *testing.T) {
+	if _, err := NewMTLSAuthenticator(MTLSConfig{TrustedProxies: []string{"10.0.0.0/8"}}); err == nil {
+		t.Fatal("NewMTLSAuthenticator() without a certificate header succeeded")
+	}
+	ca, caKey := newTestCertificate(t, "Test CA", nil, nil)
+	leaf, _ := newTestCertificate(t, "alice", ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	authenticator, err := NewMTLSAuthenticator(MTLSConfig{
+		ClientCertHeader: "X-SSL-Client-Cert",
+		TrustedProxies:   []string{"10.0.0.0/8"},
+		ClientCAs:        roots,
+	})
+	if err != nil {
+		t.Fatalf("NewMTLSAuthenticator() error = %v", err)
+	}
+	forwarded := url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})))
+
+	trusted := httptest.NewRequest("GET", "/", nil)
+	trusted.RemoteAddr = "10.1.2.3:5000"
+	trusted.Header.Set("X-SSL-Client-Cert", forwarded)
+	principal, err := authenticator.Authenticate(trusted)
+	if err != nil || principal.UserID != "alice" {
+		t.Fatalf("Authenticate(trusted) = %+v, %v; want alice", principal, err)
+	}
+
+	spoofed := httptest.NewRequest("GET", "/", nil)
+	spoofed.RemoteAddr = "203.0.113.9:5000"
+	spoofed.Header.Set("X-SSL-Client-Cert", forwarded)
+	if _, err := authenticator.Authenticate(spoofed); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("Authenticate(spoofed) error = %v, want ErrUnauthenticated", err)
+	}
+
+	stranger, _ := newTestCertificate(t, "mallory", nil, nil)
+	untrusted := httptest.NewRequest("GET", "/", nil)
+	untrusted.RemoteAddr = "10.1.2.3:5000"
+	untrusted.Header.Set("X-SSL-Client-Cert", url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: stranger.Raw}))))
+	if _, err := authenticator.Authenticate(untrusted); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("Authenticate(certificate from another CA) error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+// newTestCertificate issues a client certificate for commonName, signed by
+// parent or self-signed as a CA when parent is nil.
+func newTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HeaderConfig configures trust of identity headers set by a reverse proxy
+// (oauth2-proxy, Pomerium, an LDAP-backed gateway, ...).
+type HeaderConfig struct {
+	UserHeader  string
+	EmailHeader string
+	RolesHeader string
+	// TrustedProxies lists the CIDRs allowed to set the headers. Requests from
+	// other peers are treated as unauthenticated.
+	TrustedProxies []string
+}
+
+type headerAuthenticator struct {
+	cfg     HeaderConfig
+	trusted []*net.IPNet
+}
+
+func NewHeaderAuthenticator(cfg HeaderConfig) (Authenticator, error) {
+	if cfg.UserHeader == "" {
+		cfg.UserHeader = "X-Forwarded-User"
+	}
+	trusted, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("header auth requires at least one trusted proxy CIDR")
+	}
+	return &headerAuthenticator{cfg: cfg, trusted: trusted}, nil
+}
+
+func (a *headerAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	if !a.fromTrustedProxy(r) {
+		return Principal{}, ErrUnauthenticated
+	}
+	userID := strings.TrimSpace(r.Header.Get(a.cfg.UserHeader))
+	if userID == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	principal := Principal{UserID: userID, Name: userID}
+	if a.cfg.EmailHeader != "" {
+		principal.Email = strings.TrimSpace(r.Header.Get(a.cfg.EmailHeader))
+	}
+	if a.cfg.RolesHeader != "" {
+		principal.Roles = splitList(r.Header.Get(a.cfg.RolesHeader))
+	}
+	return principal, nil
+}
+
+func (a *headerAuthenticator) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if strings.Contains(value, ":") {
+				value += "/128"
+			} else {
+				value += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted proxy %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func splitList(value string) []string {
+	parts := strings.Split(value, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
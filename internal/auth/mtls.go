@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MTLSConfig configures client certificate authentication. Certificates are
+// taken from a TLS connection this process terminated and verified, or else
+// from ClientCertHeader, which a TLS-terminating proxy sets to the PEM of
+// the certificate it verified, URL-escaped as nginx's
+// $ssl_client_escaped_cert is.
+type MTLSConfig struct {
+	ClientCertHeader string
+	// TrustedProxies lists the CIDRs allowed to set ClientCertHeader.
+	TrustedProxies []string
+	// ClientCAs, when set, must also have issued a forwarded certificate.
+	ClientCAs *x509.CertPool
+}
+
+type mtlsAuthenticator struct {
+	cfg   MTLSConfig
+	proxy *headerAuthenticator
+}
+
+// NewMTLSAuthenticator identifies callers by their client certificate. The
+// subject common name becomes the user ID, the organizational units the
+// roles and the first email SAN, if any, the email. The web listener serves
+// plain HTTP, so it fails without a forwarded certificate header and the
+// proxies trusted to set it.
+func NewMTLSAuthenticator(cfg MTLSConfig) (Authenticator, error) {
+	if cfg.ClientCertHeader == "" {
+		return nil, fmt.Errorf("mtls auth requires a client certificate header set by a TLS-terminating proxy")
+	}
+	trusted, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("mtls auth requires at least one trusted proxy CIDR")
+	}
+	return &mtlsAuthenticator{cfg: cfg, proxy: &headerAuthenticator{trusted: trusted}}, nil
+}
+
+func (a *mtlsAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return certificatePrincipal(r.TLS.VerifiedChains[0][0])
+	}
+	if !a.proxy.fromTrustedProxy(r) {
+		return Principal{}, ErrUnauthenticated
+	}
+	value := strings.TrimSpace(r.Header.Get(a.cfg.ClientCertHeader))
+	if value == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	leaf, err := parseForwardedCertificate(value)
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	if a.cfg.ClientCAs != nil {
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:     a.cfg.ClientCAs,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return Principal{}, ErrUnauthenticated
+		}
+	}
+	return certificatePrincipal(leaf)
+}
+
+// parseForwardedCertificate reads the first certificate of a forwarded
+// PEM, URL-escaped or not.
+func parseForwardedCertificate(value string) (*x509.Certificate, error) {
+	if unescaped, err := url.PathUnescape(value); err == nil && strings.Contains(unescaped, "-----BEGIN") {
+		value = unescaped
+	}
+	block, _ := pem.Decode([]byte(value))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func certificatePrincipal(leaf *x509.Certificate) (Principal, error) {
+	if leaf.Subject.CommonName == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	principal := Principal{
+		UserID: leaf.Subject.CommonName,
+		Name:   leaf.Subject.CommonName,
+		Roles:  leaf.Subject.OrganizationalUnit,
+	}
+	if len(leaf.EmailAddresses) > 0 {
+		principal.Email = leaf.EmailAddresses[0]
+	}
+	return principal, nil
+}
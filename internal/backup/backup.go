@@ -0,0 +1,158 @@
+// Package backup takes timestamped copies of the SQLite store and keeps the
+// most recent ones in a blob store.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/blob"
+	"rhone_chat/internal/db"
+)
+
+const (
+	filePrefix = "rhone_chat-"
+	fileSuffix = ".sqlite"
+	// stampLayout sorts lexically in time order.
+	stampLayout = "20060102T150405Z"
+)
+
+// Backup is one backup, stored in the blob store under its name.
+type Backup struct {
+	Name      string    `json:"name"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Manager writes backups into a blob store and prunes all but the keep
+// newest. Each backup is first written to a file in the staging directory,
+// since SQLite can only copy a database into a file. It is safe for
+// concurrent use; backups run one at a time.
+type Manager struct {
+	store *db.Store
+	blobs blob.Store
+	stage string
+	keep  int
+	now   func() time.Time
+
+	mu sync.Mutex
+}
+
+// New returns a manager keeping keep backups (at least one) in blobs,
+// staging them in the directory stage.
+func New(store *db.Store, blobs blob.Store, stage string, keep int) *Manager {
+	return &Manager{store: store, blobs: blobs, stage: stage, keep: max(keep, 1), now: time.Now}
+}
+
+// Run takes a backup now and prunes the oldest ones beyond the retention.
+// A failed prune is returned with the backup that was written.
+func (m *Manager) Run(ctx context.Context) (Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	createdAt := m.now().UTC().Truncate(time.Second)
+	name := filePrefix + createdAt.Format(stampLayout) + fileSuffix
+	existing, err := m.List(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, backup := range existing {
+		if backup.Name == name {
+			return Backup{}, fmt.Errorf("backup %s already exists", name)
+		}
+	}
+	size, err := m.write(ctx, name)
+	if err != nil {
+		return Backup{}, err
+	}
+	backup := Backup{Name: name, SizeBytes: size, CreatedAt: createdAt}
+	return backup, m.prune(ctx)
+}
+
+// write copies the database into a staging file and stores it as name.
+func (m *Manager) write(ctx context.Context, name string) (int64, error) {
+	if err := os.MkdirAll(m.stage, 0o700); err != nil {
+		return 0, fmt.Errorf("create backup staging dir: %w", err)
+	}
+	dir, err := os.MkdirTemp(m.stage, ".backup-*")
+	if err != nil {
+		return 0, fmt.Errorf("create backup staging dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	if err := m.store.Backup(ctx, path); err != nil {
+		return 0, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open backup: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat backup: %w", err)
+	}
+	if err := m.blobs.Put(ctx, name, file, info.Size()); err != nil {
+		return 0, fmt.Errorf("store backup: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// List returns the stored backups, newest first.
+func (m *Manager) List(ctx context.Context) ([]Backup, error) {
+	objects, err := m.blobs.List(ctx, filePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list backups: %w", err)
+	}
+	backups := make([]Backup, 0, len(objects))
+	for _, object := range objects {
+		stamp, ok := strings.CutPrefix(object.Key, filePrefix)
+		if !ok {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, fileSuffix)
+		if !ok {
+			continue
+		}
+		createdAt, err := time.Parse(stampLayout, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Name:      object.Key,
+			SizeBytes: object.SizeBytes,
+			CreatedAt: createdAt,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// Job adapts Run to the job scheduler.
+func (m *Manager) Job() func(ctx context.Context, scheduled time.Time) error {
+	return func(ctx context.Context, _ time.Time) error {
+		_, err := m.Run(ctx)
+		return err
+	}
+}
+
+func (m *Manager) prune(ctx context.Context) error {
+	backups, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, old := range backups[min(m.keep, len(backups)):] {
+		if err := m.blobs.Delete(ctx, old.Name); err != nil {
+			errs = append(errs, fmt.Errorf("remove old backup: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
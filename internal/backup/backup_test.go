@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/blob"
+	"rhone_chat/internal/db"
+)
+
+func TestRunWritesUsableBackupsAndKeepsTheNewest(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "Kept", "mock", time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "backups")
+	manager := New(store, blob.NewDisk(dir), t.TempDir(), 2)
+	clock := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return clock }
+	for range 3 {
+		if _, err := manager.Run(ctx); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		clock = clock.Add(time.Hour)
+	}
+	if _, err := manager.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	clock = clock.Add(-time.Hour)
+	if _, err := manager.Run(ctx); err == nil {
+		t.Fatal("Run() over an existing backup succeeded")
+	}
+
+	backups, err := manager.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(backups) != 2 || backups[0].Name != "rhone_chat-20260301T050000Z.sqlite" || backups[1].Name != "rhone_chat-20260301T040000Z.sqlite" {
+		t.Fatalf("List() = %+v, want the two newest backups", backups)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("backup dir has %d entries, want 2", len(entries))
+	}
+
+	restored, err := db.OpenSQLite(filepath.Join(dir, backups[0].Name))
+	if err != nil {
+		t.Fatalf("OpenSQLite(backup) error = %v", err)
+	}
+	defer restored.Close()
+	if chat, err := restored.GetChat(ctx, "chat-1"); err != nil || chat.Title != "Kept" {
+		t.Fatalf("backup GetChat() = %+v, %v; want the chat", chat, err)
+	}
+}
@@ -0,0 +1,46 @@
+// Package blob keeps large payloads — attachments, data export archives and
+// database backups — outside SQLite, in a directory on local disk or in an
+// S3-compatible bucket.
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ErrNotExist is returned for a key that holds no blob. It is
+// fs.ErrNotExist, so errors.Is(err, os.ErrNotExist) matches it too.
+var ErrNotExist = fs.ErrNotExist
+
+// Object describes one stored blob.
+type Object struct {
+	Key       string
+	SizeBytes int64
+	ModTime   time.Time
+}
+
+// Store holds blobs under slash-separated keys such as "chat-1/a.png".
+// Implementations are safe for concurrent use.
+type Store interface {
+	// Put stores size bytes read from body under key, replacing any blob
+	// there. Readers never see a partly written blob.
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	// Get opens the blob under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. A missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the blobs whose keys start with prefix, in key order.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// checkKey rejects keys that are empty or would escape the store: absolute
+// paths, "." and ".." elements, and empty elements.
+func checkKey(key string) error {
+	if key == "." || !fs.ValidPath(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
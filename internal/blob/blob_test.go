@@ -0,0 +1,179 @@
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoresPutGetListAndDelete(t *testing.T) {
+	bucket := newFakeBucket(t)
+	s3, err := NewS3(S3Config{
+		Endpoint:        bucket.server.URL,
+		Bucket:          "rhone",
+		Prefix:          "prod/",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	stores := map[string]Store{
+		"disk": NewDisk(filepath.Join(t.TempDir(), "blobs")),
+		"s3":   s3.Sub("attachments/"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := store.List(ctx, ""); err != nil {
+				t.Fatalf("List() before any Put error = %v", err)
+			}
+			for key, body := range map[string]string{"chat-1/a b.png": "image", "chat-1/notes.txt": "notes", "chat-2/c.pdf": "pdf"} {
+				if err := store.Put(ctx, key, strings.NewReader(body), int64(len(body))); err != nil {
+					t.Fatalf("Put(%q) error = %v", key, err)
+				}
+			}
+			if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1); err == nil {
+				t.Fatal("Put() outside the store succeeded")
+			}
+
+			reader, err := store.Get(ctx, "chat-1/a b.png")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != "image" {
+				t.Fatalf("Get() = %q, want the stored bytes", data)
+			}
+			if _, err := store.Get(ctx, "chat-1/missing.png"); !errors.Is(err, ErrNotExist) {
+				t.Fatalf("Get(missing) error = %v, want ErrNotExist", err)
+			}
+
+			objects, err := store.List(ctx, "chat-1/")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(objects) != 2 || objects[0].Key != "chat-1/a b.png" || objects[0].SizeBytes != 5 || objects[1].Key != "chat-1/notes.txt" {
+				t.Fatalf("List(chat-1/) = %+v", objects)
+			}
+
+			for _, key := range []string{"chat-1/a b.png", "chat-1/notes.txt", "chat-1/notes.txt"} {
+				if err := store.Delete(ctx, key); err != nil {
+					t.Fatalf("Delete(%q) error = %v", key, err)
+				}
+			}
+			objects, err = store.List(ctx, "")
+			if err != nil || len(objects) != 1 || objects[0].Key != "chat-2/c.pdf" {
+				t.Fatalf("List() after Delete = %+v, %v", objects, err)
+			}
+		})
+	}
+
+	if _, ok := bucket.objects["prod/attachments/chat-2/c.pdf"]; !ok {
+		t.Fatalf("bucket objects = %v, want keys under the prefix", bucket.keys())
+	}
+	if dir := stores["disk"].(*Disk).dir; dirExists(filepath.Join(dir, "chat-1")) {
+		t.Fatal("Delete() left the emptied chat-1 directory")
+	}
+}
+
+func TestNewS3RejectsIncompleteSettings(t *testing.T) {
+	for _, cfg := range []S3Config{
+		{AccessKeyID: "a", SecretAccessKey: "b"},
+		{Bucket: "rhone"},
+		{Bucket: "rhone", AccessKeyID: "a", SecretAccessKey: "b", Endpoint: "minio:9000"},
+	} {
+		if _, err := NewS3(cfg); err == nil {
+			t.Fatalf("NewS3(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// fakeBucket serves the S3 calls the store makes for one path-style
+// bucket, refusing requests that are not signed.
+type fakeBucket struct {
+	server  *httptest.Server
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func newFakeBucket(t *testing.T) *fakeBucket {
+	bucket := &fakeBucket{objects: map[string]string{}}
+	bucket.server = httptest.NewServer(http.HandlerFunc(bucket.serve))
+	t.Cleanup(bucket.server.Close)
+	return bucket
+}
+
+func (b *fakeBucket) keys() []string {
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *fakeBucket) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key, ok := strings.CutPrefix(r.URL.Path, "/rhone/")
+	if !ok {
+		if r.URL.Path != "/rhone" || r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		type content struct {
+			Key          string
+			Size         int
+			LastModified time.Time
+		}
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}
+		for _, name := range b.keys() {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				result.Contents = append(result.Contents, content{Key: name, Size: len(b.objects[name]), LastModified: time.Now().UTC()})
+			}
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		b.objects[key] = string(data)
+	case http.MethodGet:
+		data, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		_, _ = io.WriteString(w, data)
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
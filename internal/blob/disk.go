@@ -0,0 +1,163 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Disk stores each blob as a file under a directory, at the path its key
+// names.
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a store rooted at dir, which is created on the first
+// Put.
+func NewDisk(dir string) *Disk {
+	return &Disk{dir: dir}
+}
+
+func (d *Disk) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob beside its final name and renames it into place.
+func (d *Disk) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	target, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+	// Hidden, so List skips it until it is renamed.
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-*")
+	if err != nil {
+		return fmt.Errorf("create blob: %w", err)
+	}
+	written, err := io.Copy(file, contextReader{ctx: ctx, r: body})
+	if err == nil && written != size {
+		err = fmt.Errorf("blob %s: wrote %d of %d bytes", key, written, size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), target)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("write blob: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	target, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if err != nil {
+		return nil, fmt.Errorf("open blob: %w", err)
+	}
+	return file, nil
+}
+
+// Delete removes the blob's file, then its parent directories up to the
+// root as long as they are empty.
+func (d *Disk) Delete(_ context.Context, key string) error {
+	target, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove blob: %w", err)
+	}
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(d.dir, filepath.FromSlash(dir))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (d *Disk) List(_ context.Context, prefix string) ([]Object, error) {
+	// Only the directory the prefix names can hold matching keys.
+	start := d.dir
+	if dir, _, ok := cutLast(prefix, "/"); ok {
+		if err := checkKey(dir); err != nil {
+			return []Object{}, nil
+		}
+		start = filepath.Join(d.dir, filepath.FromSlash(dir))
+	}
+	objects := []Object{}
+	err := filepath.WalkDir(start, func(name string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && name == start {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if name != start && strings.HasPrefix(entry.Name(), ".") {
+			// Blobs being written, and anything else hidden.
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.dir, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, SizeBytes: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// contextReader stops a copy once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
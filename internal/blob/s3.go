@@ -0,0 +1,310 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket of an S3-compatible service and the
+// credentials to use it.
+type S3Config struct {
+	// Endpoint is the service's base URL, such as
+	// "https://s3.eu-west-3.amazonaws.com" or a MinIO server's address.
+	// Empty uses AWS in Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is put in front of every key, such as "rhone/".
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket as a path of the endpoint rather than
+	// as a subdomain; most self-hosted servers want it.
+	PathStyle bool
+	// Client sends the requests; nil uses http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores blobs as objects in a bucket, signing requests with AWS
+// Signature Version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 returns a store for the bucket in cfg, or an error when the bucket,
+// credentials or endpoint are missing or invalid.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3 access key id and secret access key are required")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %q: want an http or https URL", cfg.Endpoint)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+// Sub returns a store for the keys under prefix in the same bucket.
+func (s *S3) Sub(prefix string) *S3 {
+	sub := *s
+	sub.cfg.Prefix += prefix
+	return &sub
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	response, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+key, nil, body, size)
+	if err != nil {
+		return fmt.Errorf("put blob: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("put blob %s: %w", key, responseError(response))
+	}
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	response, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+key, nil, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get blob: %w", err)
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, fmt.Errorf("get blob %s: %w", key, ErrNotExist)
+	default:
+		defer response.Body.Close()
+		return nil, fmt.Errorf("get blob %s: %w", key, responseError(response))
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	response, err := s.do(ctx, http.MethodDelete, s.cfg.Prefix+key, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("delete blob: %w", err)
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("delete blob %s: %w", key, responseError(response))
+	}
+}
+
+// listResult is the part of a ListObjectsV2 response List reads.
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List pages through ListObjectsV2 until the listing is complete.
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		response, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("list blobs: %w", err)
+		}
+		var page listResult
+		if response.StatusCode != http.StatusOK {
+			err = responseError(response)
+		} else {
+			err = xml.NewDecoder(response.Body).Decode(&page)
+		}
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list blobs: %w", err)
+		}
+		for _, item := range page.Contents {
+			objects = append(objects, Object{
+				Key:       strings.TrimPrefix(item.Key, s.cfg.Prefix),
+				SizeBytes: item.Size,
+				ModTime:   item.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// do sends a signed request for the object key, or for the bucket itself
+// when key is empty.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	target := *s.endpoint
+	objectPath := ""
+	if key != "" {
+		objectPath = "/" + escapePath(key)
+	}
+	if s.cfg.PathStyle {
+		objectPath = target.Path + "/" + escapePath(s.cfg.Bucket) + objectPath
+	} else {
+		target.Host = s.cfg.Bucket + "." + target.Host
+		objectPath = target.Path + objectPath
+		if objectPath == "" {
+			objectPath = "/"
+		}
+	}
+	target.RawPath = objectPath
+	target.Path, _ = url.PathUnescape(objectPath)
+	target.RawQuery = canonicalQuery(query)
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.ContentLength = size
+		if size == 0 {
+			request.Body = http.NoBody
+		}
+	}
+	s.sign(request, objectPath)
+	return s.client.Do(request)
+}
+
+// unsignedPayload skips hashing bodies, which S3 accepts for requests
+// signed in a header.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sign adds the Signature Version 4 Authorization header to request.
+func (s *S3) sign(request *http.Request, canonicalPath string) {
+	now := s.now().UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", stamp)
+	request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		request.Method,
+		canonicalPath,
+		request.URL.RawQuery,
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + stamp,
+		"",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query the way Signature Version 4 signs it:
+// sorted by name, with everything but unreserved characters escaped.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, escape(name, false)+"="+escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(key string) string {
+	return escape(key, true)
+}
+
+// escape percent-encodes s except for unreserved characters and, with
+// keepSlash, slashes.
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// responseError reads the code and message of an S3 error response.
+func responseError(response *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("s3 %s: %s: %s", response.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("s3 %s", response.Status)
+}
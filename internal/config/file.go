@@ -0,0 +1,301 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the variable holding the path of an optional config
+// file. The file is YAML (JSON works too, as a subset) and sets the same
+// settings as the environment variables, named in lowercase and optionally
+// nested: ai_max_turns: 8 and ai: {max_turns: 8} both set AI_MAX_TURNS.
+// Lists may be YAML sequences. A non-empty environment variable overrides
+// the file. The server re-reads the file on SIGHUP to apply Reloadable
+// settings.
+const ConfigFileEnv = "RHONE_CONFIG"
+
+// fileValue is one setting read from the config file.
+type fileValue struct {
+	key   string
+	value string
+}
+
+// source resolves settings from the environment and then the config file,
+// and collects every invalid value so Load can report them together.
+type source struct {
+	path  string
+	file  map[string]fileValue
+	known map[string]bool
+	// prefixes are the name prefixes read with getenvLocales.
+	prefixes []string
+	errs     []error
+	reported map[string]bool
+}
+
+func newSource(path string) (*source, error) {
+	src := &source{path: path, file: map[string]fileValue{}, known: map[string]bool{}, reported: map[string]bool{}}
+	if path == "" {
+		return src, nil
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format; use a .yaml, .yml or .json file", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var root any
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	if root == nil {
+		return src, nil
+	}
+	settings, ok := root.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("config file %s: want a mapping of settings at the top level", path)
+	}
+	if err := src.flatten("", settings); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return src, nil
+}
+
+// flatten records the leaves of settings under their variable names,
+// joining nested keys with underscores.
+func (s *source) flatten(prefix string, settings map[string]any) error {
+	for key, value := range settings {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			if err := s.flatten(path, nested); err != nil {
+				return err
+			}
+			continue
+		}
+		text, err := scalarText(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+		if earlier, ok := s.file[name]; ok {
+			return fmt.Errorf("%s and %s both set %s", earlier.key, path, name)
+		}
+		s.file[name] = fileValue{key: path, value: text}
+	}
+	return nil
+}
+
+// scalarText renders a YAML value the way it would be written in an
+// environment variable. Sequences become comma-separated lists.
+func scalarText(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	case []any:
+		parts := make([]string, 0, len(value))
+		for _, item := range value {
+			text, err := scalarText(item)
+			if err != nil {
+				return "", err
+			}
+			if _, ok := item.([]any); ok || strings.Contains(text, ",") {
+				return "", fmt.Errorf("list items must be plain values without commas")
+			}
+			parts = append(parts, text)
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// lookup returns the value of name and a description of where it came
+// from for error messages.
+func (s *source) lookup(name string) (value, origin string) {
+	s.known[name] = true
+	if value := os.Getenv(name); value != "" {
+		return value, "environment variable " + name
+	}
+	if setting, ok := s.file[name]; ok && setting.value != "" {
+		return setting.value, fmt.Sprintf("%s in %s", setting.key, s.path)
+	}
+	return "", ""
+}
+
+func (s *source) getenv(name, fallback string) string {
+	if value, _ := s.lookup(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (s *source) getenvInt(name string, fallback int) int {
+	value, origin := s.lookup(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		s.invalid(origin, "want a whole number, got %q", value)
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) getenvFloat(name string, fallback float64) float64 {
+	value, origin := s.lookup(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+		s.invalid(origin, "want a number, got %q", value)
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) getenvBool(name string, fallback bool) bool {
+	value, origin := s.lookup(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		s.invalid(origin, "want true or false, got %q", value)
+		return fallback
+	}
+	return parsed
+}
+
+// invalid records a value that does not parse, once per origin since some
+// settings are read more than once.
+func (s *source) invalid(origin, format string, args ...any) {
+	if s.reported[origin] {
+		return
+	}
+	s.reported[origin] = true
+	s.errs = append(s.errs, fmt.Errorf("%s: %s", origin, fmt.Sprintf(format, args...)))
+}
+
+func (s *source) getenvList(name string) []string {
+	value, _ := s.lookup(name)
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
+// getenvLocales collects the non-empty settings named prefix+LOCALE into a
+// map keyed by lowercase language tag, turning "PT_BR" into "pt-br". The
+// environment wins over the file for the same locale.
+func (s *source) getenvLocales(prefix string) map[string]string {
+	s.prefixes = append(s.prefixes, prefix)
+	values := map[string]string{}
+	add := func(name, value string) {
+		locale, ok := strings.CutPrefix(name, prefix)
+		if !ok || locale == "" || strings.TrimSpace(value) == "" {
+			return
+		}
+		values[strings.ToLower(strings.ReplaceAll(locale, "_", "-"))] = value
+	}
+	for name, setting := range s.file {
+		add(name, setting.value)
+	}
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		add(name, value)
+	}
+	return values
+}
+
+// err reports invalid values and config file keys that are not settings,
+// suggesting the closest setting for likely typos.
+func (s *source) err() error {
+	errs := s.errs
+	names := make([]string, 0, len(s.file))
+	for name := range s.file {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s.known[name] || s.hasLocalePrefix(name) {
+			continue
+		}
+		key := s.file[name].key
+		if suggestion := s.closestKnown(name); suggestion != "" {
+			errs = append(errs, fmt.Errorf("%s in %s is not a setting; did you mean %s?", key, s.path, strings.ToLower(suggestion)))
+		} else {
+			errs = append(errs, fmt.Errorf("%s in %s is not a setting", key, s.path))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+}
+
+func (s *source) hasLocalePrefix(name string) bool {
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestKnown returns the known setting nearest to name by edit distance,
+// or "" when none is close enough to be a typo.
+func (s *source) closestKnown(name string) string {
+	best, bestDistance := "", len(name)/3+1
+	for known := range s.known {
+		if distance := editDistance(name, known); distance < bestDistance || (distance == bestDistance && best != "" && known < best) {
+			best, bestDistance = known, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadWith runs Load with a config file holding content, named name, and
+// the given environment. Empty content means no config file.
+func loadWith(t *testing.T, name, content string, env map[string]string) (Config, error) {
+	t.Helper()
+	for _, variable := range []string{ConfigFileEnv, "APP_ENV", "VANGO_DEV", "PORT", "AI_MAX_TURNS", "AUTH_TRUSTED_PROXIES"} {
+		t.Setenv(variable, "")
+	}
+	if content != "" {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		t.Setenv(ConfigFileEnv, path)
+	}
+	for variable, value := range env {
+		t.Setenv(variable, value)
+	}
+	return Load()
+}
+
+func TestLoadReadsConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		check   func(Config) bool
+	}{
+		{
+			name:    "flat keys",
+			file:    "config.yaml",
+			content: "ai_max_turns: 5\nport: \"4000\"\n",
+			check:   func(cfg Config) bool { return cfg.MaxTurns == 5 && cfg.Port == "4000" },
+		},
+		{
+			name:    "nested keys",
+			file:    "config.yml",
+			content: "ai:\n  max_turns: 6\n",
+			check:   func(cfg Config) bool { return cfg.MaxTurns == 6 },
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"ai": {"max_turns": 7}}`,
+			check:   func(cfg Config) bool { return cfg.MaxTurns == 7 },
+		},
+		{
+			name:    "sequences become lists",
+			file:    "config.yaml",
+			content: "auth:\n  trusted_proxies:\n    - 10.0.0.0/8\n    - 127.0.0.1/32\n",
+			check: func(cfg Config) bool {
+				return slices.Equal(cfg.AuthTrustedProxies, []string{"10.0.0.0/8", "127.0.0.1/32"})
+			},
+		},
+		{
+			name:    "environment overrides the file",
+			file:    "config.yaml",
+			content: "ai_max_turns: 5\nport: \"4000\"\n",
+			env:     map[string]string{"AI_MAX_TURNS": "9"},
+			check:   func(cfg Config) bool { return cfg.MaxTurns == 9 && cfg.Port == "4000" },
+		},
+		{
+			name:    "empty file keeps defaults",
+			file:    "config.yaml",
+			content: "# nothing set\n",
+			check:   func(cfg Config) bool { return cfg.MaxTurns == 8 && cfg.Port == "3000" },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadWith(t, test.file, test.content, test.env)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !test.check(cfg) {
+				t.Fatalf("Load() = %+v, does not match %s", cfg, test.name)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    []string
+	}{
+		{
+			name:    "unknown key with a close setting",
+			file:    "config.yaml",
+			content: "ai_max_turn: 5\n",
+			want:    []string{"ai_max_turn in ", "is not a setting; did you mean ai_max_turns?"},
+		},
+		{
+			name:    "unknown key",
+			file:    "config.yaml",
+			content: "completely_unrelated: true\n",
+			want:    []string{"completely_unrelated in ", "is not a setting"},
+		},
+		{
+			name:    "value that does not parse",
+			file:    "config.yaml",
+			content: "ai_max_turns: many\n",
+			want:    []string{"ai_max_turns in ", `want a whole number, got "many"`},
+		},
+		{
+			name: "environment value that does not parse",
+			env:  map[string]string{"AI_MAX_TURNS": "many"},
+			want: []string{"environment variable AI_MAX_TURNS", `want a whole number, got "many"`},
+		},
+		{
+			name:    "every problem is reported",
+			file:    "config.yaml",
+			content: "ai_max_turns: many\nai_max_turn: 5\n",
+			want:    []string{"want a whole number", "did you mean ai_max_turns?"},
+		},
+		{
+			name:    "same setting twice",
+			file:    "config.yaml",
+			content: "ai_max_turns: 5\nai:\n  max_turns: 6\n",
+			want:    []string{"both set AI_MAX_TURNS"},
+		},
+		{
+			name:    "nested lists",
+			file:    "config.yaml",
+			content: "auth_trusted_proxies:\n  - [10.0.0.0/8]\n",
+			want:    []string{"list items must be plain values"},
+		},
+		{
+			name:    "not a mapping",
+			file:    "config.yaml",
+			content: "- port\n",
+			want:    []string{"want a mapping of settings at the top level"},
+		},
+		{
+			name:    "malformed yaml",
+			file:    "config.yaml",
+			content: "port: [\n",
+			want:    []string{"config file "},
+		},
+		{
+			name:    "unsupported format",
+			file:    "config.toml",
+			content: "port = 4000\n",
+			want:    []string{"unsupported format"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadWith(t, test.file, test.content, test.env)
+			if err == nil {
+				t.Fatalf("Load() error = nil, want %q", test.want)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("Load() error = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+// Profile bundles the defaults for one deployment environment. Explicit
+// environment variables still override every field.
+type Profile struct {
+	Name                 string
+	DevMode              bool
+	RunTimeoutSeconds    int
+	MaxRunTimeoutSeconds int
+	ToolTimeoutSeconds   int
+	ProviderLog          bool
+	// MockModel makes the offline mock model available for local work.
+	MockModel bool
+	// DebugEndpoints serves pprof and expvar on DebugAddr.
+	DebugEndpoints bool
+	DebugAddr      string
+}
+
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+var profiles = map[string]Profile{
+	ProfileDev: {
+		Name:                 ProfileDev,
+		DevMode:              true,
+		RunTimeoutSeconds:    300,
+		MaxRunTimeoutSeconds: 900,
+		ToolTimeoutSeconds:   60,
+		ProviderLog:          true,
+		MockModel:            true,
+		DebugEndpoints:       true,
+		DebugAddr:            "127.0.0.1:6060",
+	},
+	ProfileStaging: {
+		Name:                 ProfileStaging,
+		RunTimeoutSeconds:    120,
+		MaxRunTimeoutSeconds: 600,
+		ToolTimeoutSeconds:   30,
+		ProviderLog:          true,
+		DebugEndpoints:       true,
+		DebugAddr:            "127.0.0.1:6060",
+	},
+	ProfileProd: {
+		Name:                 ProfileProd,
+		RunTimeoutSeconds:    90,
+		MaxRunTimeoutSeconds: 600,
+		ToolTimeoutSeconds:   30,
+		DebugAddr:            "127.0.0.1:6060",
+	},
+}
+
+// LookupProfile returns the named profile. ok is false for unknown names, in
+// which case the prod profile is returned.
+func LookupProfile(name string) (profile Profile, ok bool) {
+	profile, ok = profiles[name]
+	if !ok {
+		return profiles[ProfileProd], false
+	}
+	return profile, true
+}
+
+// defaultProfileName keeps VANGO_DEV=1 working without APP_ENV.
+func defaultProfileName(src *source) string {
+	if src.getenvBool("VANGO_DEV", false) {
+		return ProfileDev
+	}
+	return ProfileProd
+}
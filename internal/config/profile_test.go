@@ -0,0 +1,47 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAppliesProfileDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantEnv     string
+		devMode     bool
+		runTimeout  time.Duration
+		toolTimeout time.Duration
+		mockModel   bool
+		debug       bool
+	}{
+		{name: "prod by default", wantEnv: ProfileProd, runTimeout: 90 * time.Second, toolTimeout: 30 * time.Second},
+		{name: "dev", env: map[string]string{"APP_ENV": "dev"}, wantEnv: ProfileDev, devMode: true, runTimeout: 300 * time.Second, toolTimeout: 60 * time.Second, mockModel: true, debug: true},
+		{name: "staging", env: map[string]string{"APP_ENV": "staging"}, wantEnv: ProfileStaging, runTimeout: 120 * time.Second, toolTimeout: 30 * time.Second, debug: true},
+		{name: "VANGO_DEV without APP_ENV", env: map[string]string{"VANGO_DEV": "true"}, wantEnv: ProfileDev, devMode: true, runTimeout: 300 * time.Second, toolTimeout: 60 * time.Second, mockModel: true, debug: true},
+		{name: "variables override the profile", env: map[string]string{"APP_ENV": "dev", "AI_RUN_TIMEOUT_SECONDS": "45", "AI_MOCK_MODEL": "false"}, wantEnv: ProfileDev, devMode: true, runTimeout: 45 * time.Second, toolTimeout: 60 * time.Second, debug: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("AI_RUN_TIMEOUT_SECONDS", "")
+			t.Setenv("AI_MOCK_MODEL", "")
+			cfg, err := loadWith(t, "", "", test.env)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Env != test.wantEnv || cfg.DevMode != test.devMode || cfg.RunTimeout != test.runTimeout ||
+				cfg.ToolTimeout != test.toolTimeout || cfg.MockModel != test.mockModel || cfg.DebugEndpoints != test.debug {
+				t.Fatalf("Load() env=%s dev=%v run=%s tool=%s mock=%v debug=%v", cfg.Env, cfg.DevMode, cfg.RunTimeout, cfg.ToolTimeout, cfg.MockModel, cfg.DebugEndpoints)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownProfile(t *testing.T) {
+	_, err := loadWith(t, "", "", map[string]string{"APP_ENV": "production"})
+	if err == nil || !strings.Contains(err.Error(), `environment variable APP_ENV: want dev, staging or prod, got "production"`) {
+		t.Fatalf("Load() error = %v, want unknown APP_ENV", err)
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+)
+
+// Reloadable lists the settings a running server applies when it reloads
+// its configuration on SIGHUP. Everything else is read once at startup.
+var Reloadable = []string{
+	"DefaultModel",
+	"Models",
+	"SystemPrompt",
+	"SystemPrompts",
+	"MaxHistory",
+	"ResponseReserveTokens",
+	"RunTimeout",
+	"MaxRunTimeout",
+	"SummaryEnabled",
+	"SummaryModel",
+	"TitleModel",
+	"OutputProcessors",
+	"UIFlushInterval",
+	"UIFlushBytes",
+	"UIFlushMaxInterval",
+	"UIFlushMaxBytes",
+	"DBFlushInterval",
+	"MaxConcurrentStreams",
+	"MaxStreamsPerUser",
+	"MaxQueuedRuns",
+	"RateLimitRunsPerHour",
+	"RateLimitRunsPerDay",
+	"RateLimitWarnPercent",
+	"BudgetScope",
+	"BudgetTokensPerDay",
+	"BudgetTokensPerMonth",
+	"BudgetUSDPerDay",
+	"BudgetUSDPerMonth",
+	"ModerationBlockTerms",
+	"ModerationFlagTerms",
+	"ModerationModel",
+	"ModerationFailClosed",
+	"RedactPII",
+	"RedactModel",
+	"RetentionChatIdle",
+	"RetentionToolPayloads",
+}
+
+// Reload returns current with the Reloadable settings taken from next,
+// and the names of the other settings that differ in next and so need a
+// restart to apply.
+func Reload(current, next Config) (Config, []string) {
+	reloaded := current
+	from := reflect.ValueOf(next)
+	to := reflect.ValueOf(&reloaded).Elem()
+	for _, name := range Reloadable {
+		to.FieldByName(name).Set(from.FieldByName(name))
+	}
+
+	var restart []string
+	kind := from.Type()
+	for i := range kind.NumField() {
+		name := kind.Field(i).Name
+		if slices.Contains(Reloadable, name) {
+			continue
+		}
+		if !reflect.DeepEqual(to.Field(i).Interface(), from.Field(i).Interface()) {
+			restart = append(restart, name)
+		}
+	}
+	return reloaded, restart
+}
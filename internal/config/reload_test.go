@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	current := Config{Port: "3000", DefaultModel: "openai/gpt-4o", MaxHistory: 20, RunTimeout: time.Minute, DatabasePath: "db/a.sqlite"}
+	tests := []struct {
+		name        string
+		change      func(*Config)
+		want        func(*Config)
+		wantRestart []string
+	}{
+		{name: "nothing changed", change: func(*Config) {}, want: func(*Config) {}},
+		{
+			name:   "reloadable settings apply",
+			change: func(cfg *Config) { cfg.DefaultModel = "anthropic/claude-sonnet-4"; cfg.MaxHistory = 40 },
+			want:   func(cfg *Config) { cfg.DefaultModel = "anthropic/claude-sonnet-4"; cfg.MaxHistory = 40 },
+		},
+		{
+			name:        "other settings need a restart",
+			change:      func(cfg *Config) { cfg.Port = "4000"; cfg.DatabasePath = "db/b.sqlite" },
+			want:        func(*Config) {},
+			wantRestart: []string{"Port", "DatabasePath"},
+		},
+		{
+			name: "mixed",
+			change: func(cfg *Config) {
+				cfg.RunTimeout = 2 * time.Minute
+				cfg.Models = []string{"openai/gpt-4o"}
+				cfg.Port = "4000"
+			},
+			want: func(cfg *Config) {
+				cfg.RunTimeout = 2 * time.Minute
+				cfg.Models = []string{"openai/gpt-4o"}
+			},
+			wantRestart: []string{"Port"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := current
+			test.change(&next)
+			want := current
+			test.want(&want)
+
+			reloaded, restart := Reload(current, next)
+			if !reflect.DeepEqual(reloaded, want) {
+				t.Fatalf("Reload() config = %+v, want %+v", reloaded, want)
+			}
+			slices.Sort(restart)
+			wantRestart := slices.Clone(test.wantRestart)
+			slices.Sort(wantRestart)
+			if !slices.Equal(restart, wantRestart) {
+				t.Fatalf("Reload() restart = %v, want %v", restart, wantRestart)
+			}
+		})
+	}
+}
+
+func TestReloadableNamesConfigFields(t *testing.T) {
+	kind := reflect.TypeOf(Config{})
+	for _, name := range Reloadable {
+		if _, ok := kind.FieldByName(name); !ok {
+			t.Fatalf("Reloadable names %s, which is not a Config field", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AnalyticsEvent is one anonymized product event.
+type AnalyticsEvent struct {
+	ID             int64
+	Name           string
+	Actor          string
+	PropertiesJSON string
+	CreatedAt      time.Time
+}
+
+// AnalyticsCount counts one event's occurrences on a UTC day and the
+// distinct actors behind them.
+type AnalyticsCount struct {
+	Name   string
+	Day    string
+	Events int64
+	Actors int64
+}
+
+// AnalyticsFilter selects events. Zero fields select everything; Limit
+// caps the rows returned.
+type AnalyticsFilter struct {
+	Name  string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// InsertAnalyticsEvent stores an event.
+func (s *Store) InsertAnalyticsEvent(ctx context.Context, event AnalyticsEvent) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO analytics_events (name, actor, properties_json, created_at)
+VALUES (?, ?, ?, ?)`, event.Name, event.Actor, event.PropertiesJSON, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert analytics event: %w", err)
+	}
+	return nil
+}
+
+// ListAnalyticsEvents returns the events matching filter, newest first.
+func (s *Store) ListAnalyticsEvents(ctx context.Context, filter AnalyticsFilter) ([]AnalyticsEvent, error) {
+	where, args := filter.where()
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, name, actor, properties_json, created_at
+FROM analytics_events`+where+`
+ORDER BY created_at DESC, id DESC
+LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("list analytics events: %w", err)
+	}
+	defer rows.Close()
+	var events []AnalyticsEvent
+	for rows.Next() {
+		var event AnalyticsEvent
+		if err := rows.Scan(&event.ID, &event.Name, &event.Actor, &event.PropertiesJSON, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan analytics event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// CountAnalyticsEvents counts the events matching filter by name and UTC
+// day, in day then name order. Limit is ignored.
+func (s *Store) CountAnalyticsEvents(ctx context.Context, filter AnalyticsFilter) ([]AnalyticsCount, error) {
+	where, args := filter.where()
+	rows, err := s.db.QueryContext(ctx, `
+SELECT name, substr(created_at, 1, 10) AS day, COUNT(*), COUNT(DISTINCT NULLIF(actor, ''))
+FROM analytics_events`+where+`
+GROUP BY name, day
+ORDER BY day ASC, name ASC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("count analytics events: %w", err)
+	}
+	defer rows.Close()
+	var counts []AnalyticsCount
+	for rows.Next() {
+		var count AnalyticsCount
+		if err := rows.Scan(&count.Name, &count.Day, &count.Events, &count.Actors); err != nil {
+			return nil, fmt.Errorf("scan analytics count: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func (f AnalyticsFilter) where() (string, []any) {
+	where := " WHERE 1 = 1"
+	var args []any
+	if f.Name != "" {
+		where += " AND name = ?"
+		args = append(args, f.Name)
+	}
+	if !f.Since.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, f.Until)
+	}
+	return where, args
+}
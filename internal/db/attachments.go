@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment is a file uploaded into a chat. It is pending until the next
+// user message claims it. The bytes live either in Data or in the
+// attachment blob store under StoragePath. Documents also carry the text
+// extracted at upload.
+type Attachment struct {
+	ID            string
+	ChatID        string
+	MessageID     sql.NullString
+	FileName      string
+	MediaType     string
+	SizeBytes     int64
+	Data          []byte
+	StoragePath   string
+	ExtractedText string
+	CreatedAt     time.Time
+}
+
+const attachmentColumns = `id, chat_id, message_id, file_name, media_type, size_bytes, COALESCE(storage_path, ''), created_at`
+
+func (s *Store) scanAttachment(row rowScanner, withData bool) (Attachment, error) {
+	var attachment Attachment
+	dest := []any{&attachment.ID, &attachment.ChatID, &attachment.MessageID, &attachment.FileName, &attachment.MediaType, &attachment.SizeBytes, &attachment.StoragePath, &attachment.CreatedAt}
+	if withData {
+		dest = append(dest, &attachment.Data, &attachment.ExtractedText)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return Attachment{}, fmt.Errorf("scan attachment: %w", err)
+	}
+	if withData {
+		var err error
+		if attachment.Data, err = s.openBytes(attachment.Data); err != nil {
+			return Attachment{}, fmt.Errorf("open attachment %s: %w", attachment.ID, err)
+		}
+		if err := s.openAll(&attachment.ExtractedText); err != nil {
+			return Attachment{}, fmt.Errorf("open attachment %s: %w", attachment.ID, err)
+		}
+	}
+	return attachment, nil
+}
+
+func (s *Store) InsertAttachment(ctx context.Context, attachment Attachment) error {
+	storagePath := sql.NullString{String: attachment.StoragePath, Valid: attachment.StoragePath != ""}
+	data, err := s.sealBytes(attachment.Data)
+	if err != nil {
+		return err
+	}
+	extractedText, err := s.seal(attachment.ExtractedText)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO attachments (id, chat_id, file_name, media_type, size_bytes, data, storage_path, extracted_text, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, attachment.ID, attachment.ChatID, attachment.FileName, attachment.MediaType, attachment.SizeBytes, data, storagePath, extractedText, attachment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert attachment: %w", err)
+	}
+	return nil
+}
+
+// GetAttachment returns an attachment with its inline data.
+func (s *Store) GetAttachment(ctx context.Context, attachmentID string) (Attachment, error) {
+	attachment, err := s.scanAttachment(s.db.QueryRowContext(ctx, `
+SELECT `+attachmentColumns+`, data, COALESCE(extracted_text, '')
+FROM attachments
+WHERE id = ?`, attachmentID), true)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, ErrNotFound
+	}
+	if err != nil {
+		return Attachment{}, fmt.Errorf("get attachment: %w", err)
+	}
+	return attachment, nil
+}
+
+// ListPendingAttachments returns the chat's attachments not yet claimed by
+// a message, without their data.
+func (s *Store) ListPendingAttachments(ctx context.Context, chatID string) ([]Attachment, error) {
+	return s.queryAttachments(ctx, false, `
+SELECT `+attachmentColumns+`
+FROM attachments
+WHERE chat_id = ? AND message_id IS NULL
+ORDER BY created_at ASC, id ASC`, chatID)
+}
+
+// ListChatAttachments returns the chat's claimed attachments without their
+// data, for rendering message history.
+func (s *Store) ListChatAttachments(ctx context.Context, chatID string) ([]Attachment, error) {
+	return s.queryAttachments(ctx, false, `
+SELECT `+attachmentColumns+`
+FROM attachments
+WHERE chat_id = ? AND message_id IS NOT NULL
+ORDER BY created_at ASC, id ASC`, chatID)
+}
+
+// ListMessageAttachments returns the attachments of the given messages with
+// their inline data and extracted text.
+func (s *Store) ListMessageAttachments(ctx context.Context, messageIDs []string) ([]Attachment, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+	return s.queryAttachments(ctx, true, `
+SELECT `+attachmentColumns+`, data, COALESCE(extracted_text, '')
+FROM attachments
+WHERE message_id IN (`+placeholders+`)
+ORDER BY created_at ASC, id ASC`, args...)
+}
+
+// ListChatStoragePaths returns where a chat's attachments are stored
+// outside the database so they can be removed along with the chat.
+func (s *Store) ListChatStoragePaths(ctx context.Context, chatID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT storage_path
+FROM attachments
+WHERE chat_id = ? AND storage_path IS NOT NULL`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list attachment paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan attachment path: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func (s *Store) queryAttachments(ctx context.Context, withData bool, query string, args ...any) ([]Attachment, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		attachment, err := s.scanAttachment(rows, withData)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+// DeletePendingAttachment removes an attachment that no message has claimed
+// and returns its storage path, if any.
+func (s *Store) DeletePendingAttachment(ctx context.Context, chatID, attachmentID string) (string, error) {
+	var storagePath sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+DELETE FROM attachments
+WHERE id = ? AND chat_id = ? AND message_id IS NULL
+RETURNING storage_path`, attachmentID, chatID).Scan(&storagePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("delete attachment: %w", err)
+	}
+	return storagePath.String, nil
+}
+
+// ClaimAttachmentsTx attaches the chat's pending attachments to messageID.
+func ClaimAttachmentsTx(ctx context.Context, tx *sql.Tx, chatID, messageID string) error {
+	_, err := tx.ExecContext(ctx, `
+UPDATE attachments
+SET message_id = ?
+WHERE chat_id = ? AND message_id IS NULL`, messageID, chatID)
+	if err != nil {
+		return fmt.Errorf("claim attachments: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Backup writes a consistent, compacted copy of the database to destPath
+// with VACUUM INTO. The copy is written next to destPath and renamed into
+// place, so destPath is either absent or complete.
+func (s *Store) Backup(ctx context.Context, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		return fmt.Errorf("create backup dir: %w", err)
+	}
+	partial := destPath + ".partial"
+	// VACUUM INTO refuses to overwrite, so clear what an earlier crash left.
+	if err := os.Remove(partial); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove partial backup: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, partial); err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("backup sqlite: %w", err)
+	}
+	if err := os.Rename(partial, destPath); err != nil {
+		_ = os.Remove(partial)
+		return fmt.Errorf("backup sqlite: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrSealed is returned when a stored value is sealed and the Store has no
+// codec, or the codec's key is not the one that sealed it.
+var ErrSealed = errors.New("stored value is sealed with another key")
+
+// Codec seals sensitive column values before the Store writes them and
+// opens them after it reads them: message content and reasoning, tool call
+// input, output and error text, run error text and request snapshots, chat
+// summaries, replay output, knowledge chunks, drafts, golden examples, eval
+// datasets, attachment bytes and extracted text, scheduled prompts, and
+// provider API keys.
+type Codec interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+// sealedPrefix marks a column value written through a Codec. Values without
+// it were written in the clear and are read as they are.
+const sealedPrefix = "enc:v1:"
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns a Codec that seals values with AES-256-GCM under key,
+// which must be 32 bytes. Each value gets a random nonce stored ahead of
+// the ciphertext.
+func NewAESGCM(key []byte) (Codec, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key is %d bytes; want 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM{aead: aead}, nil
+}
+
+func (c aesGCM) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c aesGCM) Open(sealed []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, ErrSealed
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return plaintext, nil
+}
+
+// CodecFromKey builds the AES-GCM codec for a base64 key given inline or in
+// keyFile, such as one a KMS or secrets agent writes. Neither set returns a
+// nil Codec: values are stored in the clear.
+func CodecFromKey(key, keyFile string) (Codec, error) {
+	if keyFile != "" {
+		if key != "" {
+			return nil, errors.New("set either an encryption key or a key file, not both")
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key: %w", err)
+		}
+		key = string(data)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decode encryption key: %w", err)
+	}
+	return NewAESGCM(raw)
+}
+
+// SetCodec makes the Store seal sensitive columns with codec from now on;
+// nil stores them in the clear. Values sealed earlier still need the codec
+// to be read. It is meant for startup, before the Store is in use.
+func (s *Store) SetCodec(codec Codec) {
+	s.codec = codec
+}
+
+// Sealing reports whether the Store seals sensitive columns.
+func (s *Store) Sealing() bool {
+	return s.codec != nil
+}
+
+func (s *Store) seal(value string) (string, error) {
+	if s.codec == nil || value == "" {
+		return value, nil
+	}
+	sealed, err := s.codec.Seal([]byte(value))
+	if err != nil {
+		return "", fmt.Errorf("seal: %w", err)
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Store) open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if s.codec == nil {
+		return "", ErrSealed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrSealed
+	}
+	plaintext, err := s.codec.Open(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// sealBytes seals a BLOB column value. Sealed, it is stored as text like
+// other sealed values; without a codec the bytes are stored as they are.
+func (s *Store) sealBytes(value []byte) (any, error) {
+	if s.codec == nil || len(value) == 0 {
+		return value, nil
+	}
+	return s.seal(string(value))
+}
+
+// openBytes opens a BLOB column value written by sealBytes.
+func (s *Store) openBytes(value []byte) ([]byte, error) {
+	if !strings.HasPrefix(string(value), sealedPrefix) {
+		return value, nil
+	}
+	opened, err := s.open(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(opened), nil
+}
+
+// openAll opens each value in place, stopping at the first failure.
+func (s *Store) openAll(values ...*string) error {
+	for _, value := range values {
+		opened, err := s.open(*value)
+		if err != nil {
+			return err
+		}
+		*value = opened
+	}
+	return nil
+}
+
+// sealedColumns are the columns the codec covers, by table.
+var sealedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"messages", []string{"content", "reasoning", "structured_json"}},
+	{"tool_calls", []string{"input_json", "output_json", "error_text"}},
+	{"runs", []string{"error_text", "request_json"}},
+	{"run_snapshots", []string{"data"}},
+	{"chat_summaries", []string{"content"}},
+	{"replay_runs", []string{"output", "reasoning", "tool_calls_json", "error_text"}},
+	{"knowledge_chunks", []string{"content"}},
+	{"drafts", []string{"content"}},
+	{"golden_examples", []string{"prompt_json", "answer"}},
+	{"eval_datasets", []string{"content"}},
+	{"attachments", []string{"extracted_text", "data"}},
+	{"scheduled_prompts", []string{"prompt"}},
+	{"webhook_tools", []string{"auth_header"}},
+}
+
+// SealPlaintext seals the values of the codec's columns that were written in
+// the clear, such as before a key was configured, and returns how many it
+// sealed. It works in batches so a large database is not held in memory.
+func (s *Store) SealPlaintext(ctx context.Context) (int, error) {
+	if s.codec == nil {
+		return 0, nil
+	}
+	const batch = 500
+	sealed := 0
+	for _, table := range sealedColumns {
+		for _, column := range table.columns {
+			for {
+				count, err := s.sealBatch(ctx, table.table, column, batch)
+				sealed += count
+				if err != nil {
+					return sealed, fmt.Errorf("seal %s.%s: %w", table.table, column, err)
+				}
+				if count < batch {
+					break
+				}
+			}
+		}
+	}
+	return sealed, nil
+}
+
+func (s *Store) sealBatch(ctx context.Context, table, column string, limit int) (int, error) {
+	count := 0
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+SELECT rowid, `+column+`
+FROM `+table+`
+WHERE `+column+` != '' AND `+column+` NOT LIKE ?
+LIMIT ?`, sealedPrefix+"%", limit)
+		if err != nil {
+			return err
+		}
+		type pending struct {
+			rowID int64
+			value string
+		}
+		var values []pending
+		for rows.Next() {
+			var value pending
+			if err := rows.Scan(&value.rowID, &value.value); err != nil {
+				rows.Close()
+				return err
+			}
+			values = append(values, value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, value := range values {
+			sealed, err := s.seal(value.value)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET `+column+` = ? WHERE rowid = ?`, sealed, value.rowID); err != nil {
+				return err
+			}
+		}
+		count = len(values)
+		return nil
+	})
+	return count, err
+}
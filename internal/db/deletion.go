@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UserDataDeletion counts what DeleteUserData removed.
+type UserDataDeletion struct {
+	Chats           int64
+	Messages        int64
+	Runs            int64
+	ToolCalls       int64
+	Attachments     int64
+	KnowledgeChunks int64
+	AuditEntries    int64
+}
+
+// ownedChatIDs selects the IDs of the chats owned by the owner given twice
+// as arguments; an empty owner selects the unowned chats, as in
+// ListOwnedChats.
+const ownedChatIDs = `SELECT id FROM chats WHERE (? = '' AND owner_id IS NULL) OR owner_id = ?`
+
+// DeleteUserData removes, in one transaction, the chats owned by ownerID
+// with everything hanging off them (messages, runs, tool calls,
+// attachments, knowledge documents and their embeddings, share links,
+// scheduled prompts), the audit entries about those chats or made by
+// userID, and every row keyed by the user: settings, drafts, provider keys,
+// share links they made, golden examples, eval datasets, data exports and
+// usage totals. Anonymized analytics events are kept. Files on disk are the
+// caller's to remove.
+func (s *Store) DeleteUserData(ctx context.Context, userID, ownerID string) (UserDataDeletion, error) {
+	var deleted UserDataDeletion
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, count := range []struct {
+			into  *int64
+			query string
+		}{
+			{&deleted.Messages, `SELECT COUNT(*) FROM messages WHERE chat_id IN (` + ownedChatIDs + `)`},
+			{&deleted.Runs, `SELECT COUNT(*) FROM runs WHERE chat_id IN (` + ownedChatIDs + `)`},
+			{&deleted.ToolCalls, `SELECT COUNT(*) FROM tool_calls t JOIN runs r ON r.id = t.run_id WHERE r.chat_id IN (` + ownedChatIDs + `)`},
+			{&deleted.Attachments, `SELECT COUNT(*) FROM attachments WHERE chat_id IN (` + ownedChatIDs + `)`},
+			{&deleted.KnowledgeChunks, `SELECT COUNT(*) FROM knowledge_chunks WHERE chat_id IN (` + ownedChatIDs + `)`},
+		} {
+			if err := tx.QueryRowContext(ctx, count.query, ownerID, ownerID).Scan(count.into); err != nil {
+				return fmt.Errorf("count user data: %w", err)
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `
+DELETE FROM audit_log
+WHERE actor_id = ? OR (target_type = 'chat' AND target_id IN (`+ownedChatIDs+`))`, userID, ownerID, ownerID)
+		if err != nil {
+			return fmt.Errorf("delete audit entries: %w", err)
+		}
+		deleted.AuditEntries, _ = result.RowsAffected()
+
+		// Everything keyed by a chat goes with it through ON DELETE CASCADE.
+		result, err = tx.ExecContext(ctx, `DELETE FROM chats WHERE id IN (`+ownedChatIDs+`)`, ownerID, ownerID)
+		if err != nil {
+			return fmt.Errorf("delete chats: %w", err)
+		}
+		deleted.Chats, _ = result.RowsAffected()
+
+		for _, statement := range []struct {
+			query string
+			arg   string
+		}{
+			{`DELETE FROM user_settings WHERE user_id = ?`, userID},
+			{`DELETE FROM drafts WHERE user_id = ?`, userID},
+			{`DELETE FROM provider_keys WHERE user_id = ?`, userID},
+			{`DELETE FROM share_links WHERE created_by = ?`, userID},
+			{`DELETE FROM data_exports WHERE user_id = ?`, userID},
+			{`DELETE FROM golden_examples WHERE owner_id = ?`, ownerID},
+			{`DELETE FROM eval_datasets WHERE owner_id = ?`, ownerID},
+			{`DELETE FROM usage_rollups WHERE owner_id = ?`, ownerID},
+		} {
+			if _, err := tx.ExecContext(ctx, statement.query, statement.arg); err != nil {
+				return fmt.Errorf("delete user data: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return UserDataDeletion{}, err
+	}
+	return deleted, nil
+}
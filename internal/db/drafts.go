@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetDraft returns the user's unsent text in a chat, or ErrNotFound.
+func (s *Store) GetDraft(ctx context.Context, chatID, userID string) (string, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, `SELECT content FROM drafts WHERE chat_id = ? AND user_id = ?`, chatID, userID).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get draft: %w", err)
+	}
+	if err := s.openAll(&content); err != nil {
+		return "", fmt.Errorf("open draft: %w", err)
+	}
+	return content, nil
+}
+
+// SaveDraft stores the user's unsent text in a chat. Empty content deletes
+// the draft.
+func (s *Store) SaveDraft(ctx context.Context, chatID, userID, content string, now time.Time) error {
+	var err error
+	if content == "" {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM drafts WHERE chat_id = ? AND user_id = ?`, chatID, userID)
+	} else if content, err = s.seal(content); err == nil {
+		_, err = s.db.ExecContext(ctx, `
+INSERT INTO drafts (chat_id, user_id, content, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(chat_id, user_id) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at`, chatID, userID, content, now)
+	}
+	if err != nil {
+		return fmt.Errorf("save draft: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GoldenExample is an assistant answer curated as the expected output for
+// the exact prompt its run sent. OwnerID is the chat owner, "" for unowned
+// chats.
+type GoldenExample struct {
+	ID         string
+	MessageID  string
+	ChatID     string
+	RunID      string
+	OwnerID    string
+	Model      string
+	PromptJSON string
+	Answer     string
+	Note       string
+	CreatedAt  time.Time
+}
+
+// EvalDataset is a frozen, versioned export of an owner's golden examples.
+// Content is JSON Lines, one example per line; it is left empty by
+// ListEvalDatasets.
+type EvalDataset struct {
+	ID           string
+	OwnerID      string
+	Name         string
+	Version      int
+	ExampleCount int
+	SHA256       string
+	Content      string
+	CreatedAt    time.Time
+}
+
+const goldenColumns = `id, message_id, chat_id, run_id, owner_id, model, prompt_json, answer, note, created_at`
+
+func (s *Store) scanGolden(row rowScanner) (GoldenExample, error) {
+	var example GoldenExample
+	if err := row.Scan(&example.ID, &example.MessageID, &example.ChatID, &example.RunID, &example.OwnerID, &example.Model, &example.PromptJSON, &example.Answer, &example.Note, &example.CreatedAt); err != nil {
+		return GoldenExample{}, err
+	}
+	if err := s.openAll(&example.PromptJSON, &example.Answer); err != nil {
+		return GoldenExample{}, fmt.Errorf("open golden example %s: %w", example.ID, err)
+	}
+	return example, nil
+}
+
+// SaveGoldenExample marks a message golden, replacing the prompt, answer and
+// note when it already is.
+func (s *Store) SaveGoldenExample(ctx context.Context, example GoldenExample) error {
+	promptJSON, err := s.seal(example.PromptJSON)
+	if err != nil {
+		return err
+	}
+	answer, err := s.seal(example.Answer)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO golden_examples (`+goldenColumns+`)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(message_id) DO UPDATE SET
+  run_id = excluded.run_id,
+  owner_id = excluded.owner_id,
+  model = excluded.model,
+  prompt_json = excluded.prompt_json,
+  answer = excluded.answer,
+  note = excluded.note`,
+		example.ID, example.MessageID, example.ChatID, example.RunID, example.OwnerID, example.Model, promptJSON, answer, example.Note, example.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("save golden example: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) DeleteGoldenExample(ctx context.Context, messageID string) error {
+	result, err := s.db.ExecContext(ctx, `
+DELETE FROM golden_examples
+WHERE message_id = ?`, messageID)
+	if err != nil {
+		return fmt.Errorf("delete golden example: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) GetGoldenExample(ctx context.Context, messageID string) (GoldenExample, error) {
+	example, err := s.scanGolden(s.db.QueryRowContext(ctx, `
+SELECT `+goldenColumns+`
+FROM golden_examples
+WHERE message_id = ?`, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return GoldenExample{}, ErrNotFound
+	}
+	if err != nil {
+		return GoldenExample{}, fmt.Errorf("get golden example: %w", err)
+	}
+	return example, nil
+}
+
+// ListGoldenExamples returns an owner's golden examples, oldest first.
+func (s *Store) ListGoldenExamples(ctx context.Context, ownerID string) ([]GoldenExample, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+goldenColumns+`
+FROM golden_examples
+WHERE owner_id = ?
+ORDER BY created_at, id`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list golden examples: %w", err)
+	}
+	defer rows.Close()
+
+	var examples []GoldenExample
+	for rows.Next() {
+		example, err := s.scanGolden(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan golden example: %w", err)
+		}
+		examples = append(examples, example)
+	}
+	return examples, rows.Err()
+}
+
+// ListAllGoldenExamples returns every owner's golden examples, oldest
+// first, for administrative exports.
+func (s *Store) ListAllGoldenExamples(ctx context.Context) ([]GoldenExample, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+goldenColumns+`
+FROM golden_examples
+ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list all golden examples: %w", err)
+	}
+	defer rows.Close()
+
+	var examples []GoldenExample
+	for rows.Next() {
+		example, err := s.scanGolden(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan golden example: %w", err)
+		}
+		examples = append(examples, example)
+	}
+	return examples, rows.Err()
+}
+
+// GoldenMessageIDs returns the ids of a chat's golden messages.
+func (s *Store) GoldenMessageIDs(ctx context.Context, chatID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT message_id
+FROM golden_examples
+WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list golden messages: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan golden message: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// InsertEvalDataset stores dataset as the next version of its name for its
+// owner and returns it with the assigned version.
+func (s *Store) InsertEvalDataset(ctx context.Context, dataset EvalDataset) (EvalDataset, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return EvalDataset{}, fmt.Errorf("begin eval dataset: %w", err)
+	}
+	defer tx.Rollback()
+
+	content, err := s.seal(dataset.Content)
+	if err != nil {
+		return EvalDataset{}, err
+	}
+	if err := tx.QueryRowContext(ctx, `
+SELECT COALESCE(MAX(version), 0) + 1
+FROM eval_datasets
+WHERE owner_id = ? AND name = ?`, dataset.OwnerID, dataset.Name).Scan(&dataset.Version); err != nil {
+		return EvalDataset{}, fmt.Errorf("next eval dataset version: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO eval_datasets (id, owner_id, name, version, example_count, sha256, content, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		dataset.ID, dataset.OwnerID, dataset.Name, dataset.Version, dataset.ExampleCount, dataset.SHA256, content, dataset.CreatedAt); err != nil {
+		return EvalDataset{}, fmt.Errorf("insert eval dataset: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return EvalDataset{}, fmt.Errorf("commit eval dataset: %w", err)
+	}
+	return dataset, nil
+}
+
+// ListEvalDatasets returns an owner's dataset versions, newest first,
+// without their content.
+func (s *Store) ListEvalDatasets(ctx context.Context, ownerID string) ([]EvalDataset, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, owner_id, name, version, example_count, sha256, created_at
+FROM eval_datasets
+WHERE owner_id = ?
+ORDER BY created_at DESC, name, version DESC`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list eval datasets: %w", err)
+	}
+	defer rows.Close()
+
+	var datasets []EvalDataset
+	for rows.Next() {
+		var dataset EvalDataset
+		if err := rows.Scan(&dataset.ID, &dataset.OwnerID, &dataset.Name, &dataset.Version, &dataset.ExampleCount, &dataset.SHA256, &dataset.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan eval dataset: %w", err)
+		}
+		datasets = append(datasets, dataset)
+	}
+	return datasets, rows.Err()
+}
+
+// GetEvalDataset returns one version of an owner's dataset, or the latest
+// when version is 0.
+func (s *Store) GetEvalDataset(ctx context.Context, ownerID, name string, version int) (EvalDataset, error) {
+	var dataset EvalDataset
+	err := s.db.QueryRowContext(ctx, `
+SELECT id, owner_id, name, version, example_count, sha256, content, created_at
+FROM eval_datasets
+WHERE owner_id = ? AND name = ? AND (? = 0 OR version = ?)
+ORDER BY version DESC
+LIMIT 1`, ownerID, name, version, version).Scan(&dataset.ID, &dataset.OwnerID, &dataset.Name, &dataset.Version, &dataset.ExampleCount, &dataset.SHA256, &dataset.Content, &dataset.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EvalDataset{}, ErrNotFound
+	}
+	if err != nil {
+		return EvalDataset{}, fmt.Errorf("get eval dataset: %w", err)
+	}
+	if err := s.openAll(&dataset.Content); err != nil {
+		return EvalDataset{}, fmt.Errorf("open eval dataset %s: %w", dataset.ID, err)
+	}
+	return dataset, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DataExport is a request for an archive of everything a user has stored.
+// Status is "pending" until the export job builds it, "running" while it
+// does, then "ready" or "failed". A finished export, and its archive, is
+// kept until ExpiresAt.
+type DataExport struct {
+	ID         string
+	UserID     string
+	Status     string
+	SizeBytes  int64
+	ErrorText  string
+	CreatedAt  time.Time
+	FinishedAt sql.NullTime
+	ExpiresAt  sql.NullTime
+}
+
+const dataExportColumns = `id, user_id, status, size_bytes, error_text, created_at, finished_at, expires_at`
+
+func scanDataExport(row rowScanner) (DataExport, error) {
+	var export DataExport
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.SizeBytes, &export.ErrorText, &export.CreatedAt, &export.FinishedAt, &export.ExpiresAt)
+	return export, err
+}
+
+func (s *Store) InsertDataExport(ctx context.Context, export DataExport) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO data_exports (id, user_id, status, created_at)
+VALUES (?, ?, ?, ?)`, export.ID, export.UserID, export.Status, export.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert data export: %w", err)
+	}
+	return nil
+}
+
+// GetDataExport returns an export, or ErrNotFound.
+func (s *Store) GetDataExport(ctx context.Context, id string) (DataExport, error) {
+	export, err := scanDataExport(s.db.QueryRowContext(ctx, `SELECT `+dataExportColumns+` FROM data_exports WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return DataExport{}, ErrNotFound
+	}
+	if err != nil {
+		return DataExport{}, fmt.Errorf("get data export: %w", err)
+	}
+	return export, nil
+}
+
+// ListDataExports returns up to limit of a user's exports, newest first. A
+// negative limit returns them all.
+func (s *Store) ListDataExports(ctx context.Context, userID string, limit int) ([]DataExport, error) {
+	return s.queryDataExports(ctx, `
+SELECT `+dataExportColumns+`
+FROM data_exports
+WHERE user_id = ?
+ORDER BY created_at DESC, id DESC
+LIMIT ?`, userID, limit)
+}
+
+// UnfinishedDataExports returns up to limit exports that are pending or
+// were left running, oldest first.
+func (s *Store) UnfinishedDataExports(ctx context.Context, limit int) ([]DataExport, error) {
+	return s.queryDataExports(ctx, `
+SELECT `+dataExportColumns+`
+FROM data_exports
+WHERE status IN ('pending', 'running')
+ORDER BY created_at ASC, id ASC
+LIMIT ?`, limit)
+}
+
+// ExpiredDataExports returns the exports whose archive expired at or
+// before now.
+func (s *Store) ExpiredDataExports(ctx context.Context, now time.Time) ([]DataExport, error) {
+	return s.queryDataExports(ctx, `
+SELECT `+dataExportColumns+`
+FROM data_exports
+WHERE expires_at IS NOT NULL AND expires_at <= ?
+ORDER BY expires_at ASC, id ASC`, now)
+}
+
+func (s *Store) queryDataExports(ctx context.Context, query string, args ...any) ([]DataExport, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list data exports: %w", err)
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan data export: %w", err)
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (s *Store) StartDataExport(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE data_exports SET status = 'running' WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("start data export: %w", err)
+	}
+	return nil
+}
+
+// FinishDataExport records how building an export ended and when the
+// export is to be deleted.
+func (s *Store) FinishDataExport(ctx context.Context, id, status string, sizeBytes int64, errorText string, finishedAt, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE data_exports
+SET status = ?, size_bytes = ?, error_text = ?, finished_at = ?, expires_at = ?
+WHERE id = ?`, status, sizeBytes, errorText, finishedAt, expiresAt, id)
+	if err != nil {
+		return fmt.Errorf("finish data export: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) DeleteDataExport(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM data_exports WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete data export: %w", err)
+	}
+	return nil
+}
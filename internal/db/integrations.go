@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IntegrationChat links a conversation in an outside service, such as a
+// Discord channel, to the chat that holds it.
+type IntegrationChat struct {
+	Integration string
+	ExternalID  string
+	ChatID      string
+	CreatedAt   time.Time
+}
+
+// GetIntegrationChat returns the link for a conversation, or ErrNotFound.
+func (s *Store) GetIntegrationChat(ctx context.Context, integration, externalID string) (IntegrationChat, error) {
+	link := IntegrationChat{Integration: integration, ExternalID: externalID}
+	err := s.db.QueryRowContext(ctx, `
+SELECT chat_id, created_at
+FROM integration_chats
+WHERE integration = ? AND external_id = ?`, integration, externalID).Scan(&link.ChatID, &link.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IntegrationChat{}, ErrNotFound
+	}
+	if err != nil {
+		return IntegrationChat{}, fmt.Errorf("get integration chat: %w", err)
+	}
+	return link, nil
+}
+
+// CreateIntegrationChat creates chat and links it to a conversation in one
+// transaction, replacing any earlier link.
+func (s *Store) CreateIntegrationChat(ctx context.Context, chat Chat, link IntegrationChat) error {
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO chats (id, title, model, owner_id, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)`, chat.ID, chat.Title, chat.Model, chat.OwnerID, chat.CreatedAt, chat.UpdatedAt); err != nil {
+			return fmt.Errorf("create chat: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO integration_chats (integration, external_id, chat_id, created_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(integration, external_id) DO UPDATE SET chat_id = excluded.chat_id, created_at = excluded.created_at`,
+			link.Integration, link.ExternalID, chat.ID, link.CreatedAt); err != nil {
+			return fmt.Errorf("link integration chat: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobState is the durable record of a scheduled job: when it runs next and
+// how its last run went.
+type JobState struct {
+	Name           string
+	Schedule       string
+	NextRunAt      sql.NullTime
+	LastStartedAt  sql.NullTime
+	LastFinishedAt sql.NullTime
+	LastStatus     string
+	LastError      string
+	RunCount       int
+	UpdatedAt      time.Time
+}
+
+const jobColumns = `name, schedule, next_run_at, last_started_at, last_finished_at, last_status, last_error, run_count, updated_at`
+
+func scanJob(row rowScanner) (JobState, error) {
+	var job JobState
+	err := row.Scan(&job.Name, &job.Schedule, &job.NextRunAt, &job.LastStartedAt, &job.LastFinishedAt, &job.LastStatus, &job.LastError, &job.RunCount, &job.UpdatedAt)
+	return job, err
+}
+
+// GetJobState returns the job's state, or ErrNotFound for a job that never
+// ran.
+func (s *Store) GetJobState(ctx context.Context, name string) (JobState, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `
+SELECT `+jobColumns+`
+FROM jobs
+WHERE name = ?`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return JobState{}, ErrNotFound
+	}
+	if err != nil {
+		return JobState{}, fmt.Errorf("get job: %w", err)
+	}
+	return job, nil
+}
+
+func (s *Store) SaveJobState(ctx context.Context, job JobState) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO jobs (`+jobColumns+`)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(name) DO UPDATE SET
+  schedule = excluded.schedule,
+  next_run_at = excluded.next_run_at,
+  last_started_at = excluded.last_started_at,
+  last_finished_at = excluded.last_finished_at,
+  last_status = excluded.last_status,
+  last_error = excluded.last_error,
+  run_count = excluded.run_count,
+  updated_at = excluded.updated_at`,
+		job.Name, job.Schedule, job.NextRunAt, job.LastStartedAt, job.LastFinishedAt, job.LastStatus, job.LastError, job.RunCount, job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("save job: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ListJobStates(ctx context.Context) ([]JobState, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+jobColumns+`
+FROM jobs
+ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []JobState
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// KnowledgeDocument is a document in a chat's knowledge base. Its text is
+// stored as embedded chunks; Embedder names the embedding space they use.
+type KnowledgeDocument struct {
+	ID         string
+	ChatID     string
+	FileName   string
+	MediaType  string
+	SizeBytes  int64
+	Embedder   string
+	ChunkCount int
+	CreatedAt  time.Time
+}
+
+// KnowledgeChunk is one embedded piece of a knowledge document. FileName is
+// filled in when chunks are listed for retrieval.
+type KnowledgeChunk struct {
+	ID         string
+	DocumentID string
+	ChatID     string
+	Ordinal    int
+	Content    string
+	Embedding  []byte
+	FileName   string
+}
+
+// InsertKnowledgeDocument stores a document and its chunks atomically.
+func (s *Store) InsertKnowledgeDocument(ctx context.Context, document KnowledgeDocument, chunks []KnowledgeChunk) error {
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO knowledge_documents (id, chat_id, file_name, media_type, size_bytes, embedder, chunk_count, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, document.ID, document.ChatID, document.FileName, document.MediaType, document.SizeBytes, document.Embedder, len(chunks), document.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("insert knowledge document: %w", err)
+		}
+		for _, chunk := range chunks {
+			content, err := s.seal(chunk.Content)
+			if err != nil {
+				return fmt.Errorf("insert knowledge chunk: %w", err)
+			}
+			_, err = tx.ExecContext(ctx, `
+INSERT INTO knowledge_chunks (id, document_id, chat_id, ordinal, content, embedding)
+VALUES (?, ?, ?, ?, ?, ?)`, chunk.ID, document.ID, document.ChatID, chunk.Ordinal, content, chunk.Embedding)
+			if err != nil {
+				return fmt.Errorf("insert knowledge chunk: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) ListKnowledgeDocuments(ctx context.Context, chatID string) ([]KnowledgeDocument, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, chat_id, file_name, media_type, size_bytes, embedder, chunk_count, created_at
+FROM knowledge_documents
+WHERE chat_id = ?
+ORDER BY created_at ASC, id ASC`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list knowledge documents: %w", err)
+	}
+	defer rows.Close()
+
+	var documents []KnowledgeDocument
+	for rows.Next() {
+		var document KnowledgeDocument
+		if err := rows.Scan(&document.ID, &document.ChatID, &document.FileName, &document.MediaType, &document.SizeBytes, &document.Embedder, &document.ChunkCount, &document.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan knowledge document: %w", err)
+		}
+		documents = append(documents, document)
+	}
+	return documents, rows.Err()
+}
+
+// ListKnowledgeChunks returns the chat's chunks embedded by embedder, in
+// document order.
+func (s *Store) ListKnowledgeChunks(ctx context.Context, chatID, embedder string) ([]KnowledgeChunk, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT c.id, c.document_id, c.chat_id, c.ordinal, c.content, c.embedding, d.file_name
+FROM knowledge_chunks c
+JOIN knowledge_documents d ON d.id = c.document_id
+WHERE c.chat_id = ? AND d.embedder = ?
+ORDER BY d.created_at ASC, c.document_id ASC, c.ordinal ASC`, chatID, embedder)
+	if err != nil {
+		return nil, fmt.Errorf("list knowledge chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []KnowledgeChunk
+	for rows.Next() {
+		var chunk KnowledgeChunk
+		if err := rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.ChatID, &chunk.Ordinal, &chunk.Content, &chunk.Embedding, &chunk.FileName); err != nil {
+			return nil, fmt.Errorf("scan knowledge chunk: %w", err)
+		}
+		if err := s.openAll(&chunk.Content); err != nil {
+			return nil, fmt.Errorf("open knowledge chunk %s: %w", chunk.ID, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// DeleteKnowledgeDocument removes a document and, through the foreign key,
+// its chunks.
+func (s *Store) DeleteKnowledgeDocument(ctx context.Context, chatID, documentID string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM knowledge_documents WHERE id = ? AND chat_id = ?`, documentID, chatID)
+	if err != nil {
+		return fmt.Errorf("delete knowledge document: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes are numbered SQL files in migrations/, named
+// NNNN_name.up.sql with an optional NNNN_name.down.sql that reverts it.
+// Each file runs in its own transaction and applied versions are recorded
+// in schema_migrations. Add a new file for every change; never edit one
+// that has shipped.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a known migration and when it was applied, if it was.
+type MigrationState struct {
+	Migration
+	AppliedAt sql.NullTime
+}
+
+var migrations, migrationsErr = loadMigrations(migrationFiles)
+
+// baselineColumns were added with ALTER TABLE before versioned migrations
+// existed. The baseline creates them for new databases; older ones get any
+// they are missing when the baseline is applied.
+var baselineColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"chats", "temperature", "REAL"},
+	{"chats", "max_tokens", "INTEGER"},
+	{"chats", "top_p", "REAL"},
+	{"chats", "reasoning_effort", "TEXT"},
+	{"chats", "owner_id", "TEXT"},
+	{"chats", "max_spend_usd", "REAL"},
+	{"messages", "reasoning", "TEXT"},
+	{"messages", "flag", "TEXT"},
+	{"runs", "request_json", "TEXT"},
+	{"runs", "cost_usd", "REAL"},
+	{"attachments", "extracted_text", "TEXT"},
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		number, label, hasLabel := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || !hasLabel || err != nil || version < 1 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.up.sql or NNNN_name.down.sql", base)
+		}
+		body, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", base, err)
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: label}
+			byVersion[version] = migration
+		}
+		if migration.Name != label {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, migration.Name, label)
+		}
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+	loaded := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		loaded = append(loaded, *migration)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+	for i, migration := range loaded {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if strings.TrimSpace(migration.Up) == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+	}
+	return loaded, nil
+}
+
+// LatestSchemaVersion is the version the migrations bring a database to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// MigrateTo applies or reverts migrations until the database is at version
+// target. Reverting needs the down file of every migration above target.
+func (s *Store) MigrateTo(ctx context.Context, target int) error {
+	if migrationsErr != nil {
+		return fmt.Errorf("load migrations: %w", migrationsErr)
+	}
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("schema version %d is unknown; this build knows 0 to %d", target, len(migrations))
+	}
+	if _, err := s.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at DATETIME NOT NULL
+)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", current, len(migrations))
+	}
+	for version := current + 1; version <= target; version++ {
+		if err := s.migrateUp(ctx, migrations[version-1]); err != nil {
+			return err
+		}
+	}
+	for version := current; version > target; version-- {
+		if err := s.migrateDown(ctx, migrations[version-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) migrateUp(ctx context.Context, migration Migration) error {
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return err
+		}
+		if migration.Version == 1 {
+			for _, column := range baselineColumns {
+				if err := ensureColumnTx(ctx, tx, column.table, column.column, column.definition); err != nil {
+					return err
+				}
+			}
+		}
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO schema_migrations (version, name, applied_at)
+VALUES (?, ?, ?)`, migration.Version, migration.Name, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("apply migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+func (s *Store) migrateDown(ctx context.Context, migration Migration) error {
+	if strings.TrimSpace(migration.Down) == "" {
+		return fmt.Errorf("migration %04d_%s cannot be reverted: it has no down file", migration.Version, migration.Name)
+	}
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, migration.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("revert migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for an empty
+// database.
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrations lists every known migration with when it was applied.
+func (s *Store) Migrations(ctx context.Context) ([]MigrationState, error) {
+	if migrationsErr != nil {
+		return nil, fmt.Errorf("load migrations: %w", migrationsErr)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("list schema migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list schema migrations: %w", err)
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			state.AppliedAt = sql.NullTime{Time: appliedAt, Valid: true}
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ensureColumnTx adds a column to an existing table when it is missing, so
+// databases created before the column existed keep working.
+func ensureColumnTx(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("scan %s column: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
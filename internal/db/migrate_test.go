@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateToRoundTripsEveryMigration(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteAt(filepath.Join(t.TempDir(), "chat.sqlite"), 0)
+	if err != nil {
+		t.Fatalf("OpenSQLiteAt(0) error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	latest := LatestSchemaVersion()
+	schemas := make([]string, latest+1)
+	schemas[0] = schemaOf(t, store)
+	for version := 1; version <= latest; version++ {
+		if err := store.MigrateTo(ctx, version); err != nil {
+			t.Fatalf("MigrateTo(%d) up error = %v", version, err)
+		}
+		assertSchemaVersion(t, store, version)
+		schemas[version] = schemaOf(t, store)
+		if schemas[version] == schemas[version-1] {
+			t.Fatalf("migration %d did not change the schema", version)
+		}
+	}
+	for version := latest - 1; version >= 0; version-- {
+		if err := store.MigrateTo(ctx, version); err != nil {
+			t.Fatalf("MigrateTo(%d) down error = %v", version, err)
+		}
+		assertSchemaVersion(t, store, version)
+		if got := schemaOf(t, store); got != schemas[version] {
+			t.Fatalf("schema after reverting to %d differs from the way up:\ngot:\n%s\nwant:\n%s", version, got, schemas[version])
+		}
+	}
+	if err := store.MigrateTo(ctx, latest); err != nil {
+		t.Fatalf("MigrateTo(%d) again error = %v", latest, err)
+	}
+	if got := schemaOf(t, store); got != schemas[latest] {
+		t.Fatalf("schema after migrating up again differs:\ngot:\n%s\nwant:\n%s", got, schemas[latest])
+	}
+}
+
+func TestMigrateToRejectsUnknownVersions(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	for _, version := range []int{-1, LatestSchemaVersion() + 1} {
+		if err := store.MigrateTo(context.Background(), version); err == nil {
+			t.Fatalf("MigrateTo(%d) error = nil, want unknown version", version)
+		}
+	}
+}
+
+func TestOpenSQLiteUpgradesBaselineDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chat.sqlite")
+
+	// A database from before versioned migrations: the baseline tables
+	// without the columns later added by ALTER TABLE, and no
+	// schema_migrations.
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := legacy.ExecContext(ctx, migrations[0].Up); err != nil {
+		t.Fatalf("create baseline tables error = %v", err)
+	}
+	for _, column := range baselineColumns {
+		if _, err := legacy.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", column.table, column.column)); err != nil {
+			t.Fatalf("drop %s.%s error = %v", column.table, column.column, err)
+		}
+	}
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := legacy.ExecContext(ctx, `
+INSERT INTO chats (id, title, model, created_at, updated_at)
+VALUES ('chat-1', 'Old chat', 'openai/gpt-4o', ?, ?)`, now, now); err != nil {
+		t.Fatalf("insert legacy chat error = %v", err)
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	assertSchemaVersion(t, store, LatestSchemaVersion())
+
+	for _, column := range baselineColumns {
+		var count int
+		if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, column.table, column.column).Scan(&count); err != nil {
+			t.Fatalf("inspect %s.%s error = %v", column.table, column.column, err)
+		}
+		if count != 1 {
+			t.Fatalf("%s.%s was not added to the baseline database", column.table, column.column)
+		}
+	}
+	chat, err := store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("GetChat() error = %v", err)
+	}
+	if chat.Title != "Old chat" || chat.OwnerID.Valid {
+		t.Fatalf("chat = %+v, want the legacy chat without an owner", chat)
+	}
+}
+
+func assertSchemaVersion(t *testing.T, store *Store, want int) {
+	t.Helper()
+	got, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatalf("SchemaVersion() error = %v", err)
+	}
+	if got != want {
+		t.Fatalf("SchemaVersion() = %d, want %d", got, want)
+	}
+}
+
+// schemaOf describes the tables, columns and indexes of the database apart
+// from schema_migrations, in a stable order.
+func schemaOf(t *testing.T, store *Store) string {
+	t.Helper()
+	ctx := context.Background()
+	rows, err := store.db.QueryContext(ctx, `
+SELECT type, name, tbl_name
+FROM sqlite_master
+WHERE name NOT LIKE 'sqlite_%' AND tbl_name != 'schema_migrations'
+ORDER BY type, name`)
+	if err != nil {
+		t.Fatalf("read sqlite_master error = %v", err)
+	}
+	type object struct{ kind, name, table string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.name, &o.table); err != nil {
+			t.Fatalf("scan sqlite_master error = %v", err)
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("read sqlite_master error = %v", err)
+	}
+
+	var out strings.Builder
+	for _, o := range objects {
+		fmt.Fprintf(&out, "%s %s on %s\n", o.kind, o.name, o.table)
+		if o.kind != "table" {
+			continue
+		}
+		columns, err := store.db.QueryContext(ctx, `SELECT name, type, "notnull", COALESCE(dflt_value, ''), pk FROM pragma_table_info(?) ORDER BY name`, o.name)
+		if err != nil {
+			t.Fatalf("read %s columns error = %v", o.name, err)
+		}
+		for columns.Next() {
+			var name, kind, dflt string
+			var notNull, pk int
+			if err := columns.Scan(&name, &kind, &notNull, &dflt, &pk); err != nil {
+				t.Fatalf("scan %s column error = %v", o.name, err)
+			}
+			fmt.Fprintf(&out, "  %s %s notnull=%d default=%q pk=%d\n", name, kind, notNull, dflt, pk)
+		}
+		if err := columns.Close(); err != nil {
+			t.Fatalf("read %s columns error = %v", o.name, err)
+		}
+	}
+	return out.String()
+}
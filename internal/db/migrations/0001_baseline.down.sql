@@ -0,0 +1,20 @@
+-- Drops every table, children before parents.
+
+DROP TABLE IF EXISTS app_settings;
+DROP TABLE IF EXISTS chat_summaries;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS message_sources;
+DROP TABLE IF EXISTS scheduled_prompts;
+DROP TABLE IF EXISTS eval_datasets;
+DROP TABLE IF EXISTS golden_examples;
+DROP TABLE IF EXISTS knowledge_chunks;
+DROP TABLE IF EXISTS knowledge_documents;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS chat_tools;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS replay_runs;
+DROP TABLE IF EXISTS run_snapshots;
+DROP TABLE IF EXISTS tool_calls;
+DROP TABLE IF EXISTS runs;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chats;
@@ -0,0 +1,240 @@
+-- The schema as it stood when versioned migrations were introduced. Older
+-- databases already have these tables; migrateUp adds the columns they may
+-- be missing after running this file.
+
+CREATE TABLE IF NOT EXISTS chats (
+  id TEXT PRIMARY KEY,
+  title TEXT NOT NULL,
+  model TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  temperature REAL,
+  max_tokens INTEGER,
+  top_p REAL,
+  reasoning_effort TEXT,
+  owner_id TEXT,
+  max_spend_usd REAL
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  role TEXT NOT NULL,
+  content TEXT NOT NULL,
+  status TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  reasoning TEXT,
+  flag TEXT,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_created ON messages(chat_id, created_at, id);
+
+CREATE TABLE IF NOT EXISTS runs (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  user_message_id TEXT NOT NULL,
+  assistant_message_id TEXT NOT NULL,
+  model TEXT NOT NULL,
+  status TEXT NOT NULL,
+  stop_reason TEXT,
+  error_text TEXT,
+  tool_call_count INTEGER NOT NULL DEFAULT 0,
+  turn_count INTEGER NOT NULL DEFAULT 0,
+  usage_json TEXT,
+  started_at DATETIME NOT NULL,
+  finished_at DATETIME,
+  request_json TEXT,
+  cost_usd REAL,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+  FOREIGN KEY(user_message_id) REFERENCES messages(id) ON DELETE RESTRICT,
+  FOREIGN KEY(assistant_message_id) REFERENCES messages(id) ON DELETE RESTRICT
+);
+CREATE INDEX IF NOT EXISTS idx_runs_chat_started ON runs(chat_id, started_at, id);
+
+CREATE TABLE IF NOT EXISTS tool_calls (
+  id TEXT PRIMARY KEY,
+  run_id TEXT NOT NULL,
+  tool_call_id TEXT,
+  name TEXT NOT NULL,
+  status TEXT NOT NULL,
+  input_json TEXT,
+  output_json TEXT,
+  error_text TEXT,
+  started_at DATETIME NOT NULL,
+  finished_at DATETIME,
+  FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_tool_calls_run_started ON tool_calls(run_id, started_at, id);
+CREATE INDEX IF NOT EXISTS idx_tool_calls_name_started ON tool_calls(name, started_at);
+
+CREATE TABLE IF NOT EXISTS run_snapshots (
+  run_id TEXT PRIMARY KEY,
+  sha256 TEXT NOT NULL,
+  encoding TEXT NOT NULL,
+  data BLOB NOT NULL,
+  size_bytes INTEGER NOT NULL,
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS replay_runs (
+  id TEXT PRIMARY KEY,
+  source_run_id TEXT NOT NULL,
+  model TEXT NOT NULL,
+  status TEXT NOT NULL,
+  output TEXT,
+  reasoning TEXT,
+  tool_calls_json TEXT,
+  stop_reason TEXT,
+  error_text TEXT,
+  usage_json TEXT,
+  started_at DATETIME NOT NULL,
+  finished_at DATETIME,
+  FOREIGN KEY(source_run_id) REFERENCES runs(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_replay_runs_source ON replay_runs(source_run_id, started_at);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id TEXT PRIMARY KEY,
+  actor_id TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target_type TEXT NOT NULL,
+  target_id TEXT NOT NULL,
+  detail_json TEXT,
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id, created_at);
+
+CREATE TABLE IF NOT EXISTS chat_tools (
+  chat_id TEXT NOT NULL,
+  tool_name TEXT NOT NULL,
+  enabled INTEGER NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (chat_id, tool_name),
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  message_id TEXT,
+  file_name TEXT NOT NULL,
+  media_type TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL,
+  data BLOB,
+  storage_path TEXT,
+  created_at DATETIME NOT NULL,
+  extracted_text TEXT,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+  FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_chat_message ON attachments(chat_id, message_id, created_at);
+
+CREATE TABLE IF NOT EXISTS knowledge_documents (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  file_name TEXT NOT NULL,
+  media_type TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL,
+  embedder TEXT NOT NULL,
+  chunk_count INTEGER NOT NULL,
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_knowledge_documents_chat ON knowledge_documents(chat_id, created_at);
+
+CREATE TABLE IF NOT EXISTS knowledge_chunks (
+  id TEXT PRIMARY KEY,
+  document_id TEXT NOT NULL,
+  chat_id TEXT NOT NULL,
+  ordinal INTEGER NOT NULL,
+  content TEXT NOT NULL,
+  embedding BLOB NOT NULL,
+  FOREIGN KEY(document_id) REFERENCES knowledge_documents(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_knowledge_chunks_chat ON knowledge_chunks(chat_id, document_id, ordinal);
+
+CREATE TABLE IF NOT EXISTS golden_examples (
+  id TEXT PRIMARY KEY,
+  message_id TEXT NOT NULL UNIQUE,
+  chat_id TEXT NOT NULL,
+  run_id TEXT NOT NULL,
+  owner_id TEXT NOT NULL DEFAULT '',
+  model TEXT NOT NULL,
+  prompt_json TEXT NOT NULL,
+  answer TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_golden_examples_owner ON golden_examples(owner_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_golden_examples_chat ON golden_examples(chat_id);
+
+CREATE TABLE IF NOT EXISTS eval_datasets (
+  id TEXT PRIMARY KEY,
+  owner_id TEXT NOT NULL DEFAULT '',
+  name TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  example_count INTEGER NOT NULL,
+  sha256 TEXT NOT NULL,
+  content TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  UNIQUE(owner_id, name, version)
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_prompts (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  owner_id TEXT NOT NULL DEFAULT '',
+  prompt TEXT NOT NULL,
+  frequency TEXT NOT NULL,
+  time_of_day TEXT NOT NULL,
+  next_run_at DATETIME NOT NULL,
+  last_run_at DATETIME,
+  last_run_id TEXT NOT NULL DEFAULT '',
+  last_status TEXT NOT NULL DEFAULT '',
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_chat ON scheduled_prompts(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_next ON scheduled_prompts(next_run_at);
+
+CREATE TABLE IF NOT EXISTS message_sources (
+  message_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  title TEXT NOT NULL DEFAULT '',
+  run_id TEXT NOT NULL,
+  tool_call_id TEXT NOT NULL DEFAULT '',
+  retrieved_at DATETIME NOT NULL,
+  PRIMARY KEY (message_id, url),
+  FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+  name TEXT PRIMARY KEY,
+  schedule TEXT NOT NULL,
+  next_run_at DATETIME,
+  last_started_at DATETIME,
+  last_finished_at DATETIME,
+  last_status TEXT NOT NULL DEFAULT '',
+  last_error TEXT NOT NULL DEFAULT '',
+  run_count INTEGER NOT NULL DEFAULT 0,
+  updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_summaries (
+  chat_id TEXT PRIMARY KEY,
+  through_message_id TEXT NOT NULL,
+  content TEXT NOT NULL,
+  model TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS app_settings (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,9 @@
+-- Per-user preferences, such as the timezone timestamps are shown in.
+
+CREATE TABLE IF NOT EXISTS user_settings (
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
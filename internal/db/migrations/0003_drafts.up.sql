@@ -0,0 +1,10 @@
+-- Unsent composer text, one draft per user and chat.
+
+CREATE TABLE IF NOT EXISTS drafts (
+  chat_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  content TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (chat_id, user_id),
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_runs_comparison;
+ALTER TABLE runs DROP COLUMN comparison_id;
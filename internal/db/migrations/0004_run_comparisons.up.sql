@@ -0,0 +1,5 @@
+-- Side-by-side comparisons: both runs answering the same user message carry
+-- the ID of the first run.
+
+ALTER TABLE runs ADD COLUMN comparison_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_runs_comparison ON runs(comparison_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_share_links_chat;
+DROP TABLE IF EXISTS share_links;
@@ -0,0 +1,13 @@
+-- Read-only public links to chats. A link's token is its id signed with
+-- the server's share key, so only the id is stored.
+
+CREATE TABLE IF NOT EXISTS share_links (
+  id TEXT PRIMARY KEY,
+  chat_id TEXT NOT NULL,
+  created_by TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  revoked_at DATETIME,
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_chat ON share_links(chat_id, created_at);
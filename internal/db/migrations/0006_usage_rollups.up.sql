@@ -0,0 +1,28 @@
+-- Token and cost totals per chat owner ('' for unowned chats) and UTC day
+-- or month, added to as runs finish so budget checks need not scan runs.
+-- Runs that finished before the table existed are rolled up here.
+
+CREATE TABLE IF NOT EXISTS usage_rollups (
+  owner_id TEXT NOT NULL,
+  period TEXT NOT NULL,
+  period_start TEXT NOT NULL,
+  runs INTEGER NOT NULL DEFAULT 0,
+  input_tokens INTEGER NOT NULL DEFAULT 0,
+  output_tokens INTEGER NOT NULL DEFAULT 0,
+  cost_usd REAL NOT NULL DEFAULT 0,
+  PRIMARY KEY (owner_id, period, period_start)
+);
+
+INSERT INTO usage_rollups (owner_id, period, period_start, runs, input_tokens, output_tokens, cost_usd)
+SELECT owner_id, period.name, substr(finished_at, 1, period.length), COUNT(*), SUM(input_tokens), SUM(output_tokens), SUM(cost_usd)
+FROM (
+  SELECT COALESCE(c.owner_id, '') AS owner_id, r.finished_at,
+    CASE WHEN json_valid(r.usage_json) THEN COALESCE(json_extract(r.usage_json, '$.input_tokens'), 0) ELSE 0 END AS input_tokens,
+    CASE WHEN json_valid(r.usage_json) THEN COALESCE(json_extract(r.usage_json, '$.output_tokens'), 0) ELSE 0 END AS output_tokens,
+    COALESCE(r.cost_usd, 0) AS cost_usd
+  FROM runs r
+  JOIN chats c ON c.id = r.chat_id
+  WHERE r.finished_at IS NOT NULL
+) finished,
+(SELECT 'day' AS name, 10 AS length UNION ALL SELECT 'month', 7) period
+GROUP BY owner_id, period.name, substr(finished_at, 1, period.length);
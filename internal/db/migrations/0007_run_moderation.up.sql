@@ -0,0 +1,5 @@
+-- The moderation decision on the user message a run answers, as JSON; null
+-- when moderation was off or the message was not screened again, as on a
+-- retry.
+
+ALTER TABLE runs ADD COLUMN moderation_json TEXT;
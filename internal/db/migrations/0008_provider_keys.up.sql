@@ -0,0 +1,9 @@
+-- Users' own provider API keys, sealed with the store's codec.
+
+CREATE TABLE IF NOT EXISTS provider_keys (
+  user_id TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  api_key TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, provider)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE runs DROP COLUMN output_tokens;
+ALTER TABLE runs DROP COLUMN input_tokens;
@@ -0,0 +1,11 @@
+-- Each run's token usage in typed columns, so totals need not parse
+-- usage_json. Runs finished before the columns existed are filled in from
+-- their usage_json.
+
+ALTER TABLE runs ADD COLUMN input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE runs ADD COLUMN output_tokens INTEGER NOT NULL DEFAULT 0;
+
+UPDATE runs
+SET input_tokens = COALESCE(json_extract(usage_json, '$.input_tokens'), 0),
+    output_tokens = COALESCE(json_extract(usage_json, '$.output_tokens'), 0)
+WHERE json_valid(usage_json);
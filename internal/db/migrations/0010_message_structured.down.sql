@@ -0,0 +1 @@
+ALTER TABLE messages DROP COLUMN structured_json;
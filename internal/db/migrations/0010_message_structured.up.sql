@@ -0,0 +1,5 @@
+-- The parsed reply of an assistant message sent with an output schema, as
+-- compact JSON; null for ordinary replies and for replies that did not
+-- match their schema.
+
+ALTER TABLE messages ADD COLUMN structured_json TEXT;
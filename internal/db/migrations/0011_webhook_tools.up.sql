@@ -0,0 +1,12 @@
+-- Operator-defined tools served by HTTP endpoints. auth_header is sealed
+-- with the store's codec when one is configured.
+
+CREATE TABLE IF NOT EXISTS webhook_tools (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  input_schema_json TEXT NOT NULL DEFAULT '',
+  url TEXT NOT NULL,
+  auth_header TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_analytics_events_name_created;
+DROP TABLE IF EXISTS analytics_events;
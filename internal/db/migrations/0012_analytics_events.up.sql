@@ -0,0 +1,12 @@
+-- Anonymized product events. actor is a salted hash of the user ID, and
+-- properties never hold message content, titles or IDs.
+
+CREATE TABLE IF NOT EXISTS analytics_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  properties_json TEXT NOT NULL DEFAULT '{}',
+  created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_analytics_events_name_created ON analytics_events(name, created_at, id);
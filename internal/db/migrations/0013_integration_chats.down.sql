@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_integration_chats_chat;
+DROP TABLE IF EXISTS integration_chats;
@@ -0,0 +1,13 @@
+-- Chats that hold a conversation from an outside service, such as a
+-- Discord channel or thread, keyed by the service's own ID for it.
+
+CREATE TABLE IF NOT EXISTS integration_chats (
+  integration TEXT NOT NULL,
+  external_id TEXT NOT NULL,
+  chat_id TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  PRIMARY KEY(integration, external_id),
+  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_integration_chats_chat ON integration_chats(chat_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_data_exports_status_created;
+DROP INDEX IF EXISTS idx_data_exports_user_created;
+DROP TABLE IF EXISTS data_exports;
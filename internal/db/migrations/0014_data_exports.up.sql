@@ -0,0 +1,17 @@
+-- Archives of everything a user has stored, built by a background job on
+-- request. The zip itself is a file named after the export's ID in the
+-- export directory; expires_at is when it is deleted.
+
+CREATE TABLE IF NOT EXISTS data_exports (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  status TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL DEFAULT 0,
+  error_text TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  finished_at DATETIME,
+  expires_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_created ON data_exports(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_status_created ON data_exports(status, created_at, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chats_keep_updated;
+ALTER TABLE chats DROP COLUMN keep_forever;
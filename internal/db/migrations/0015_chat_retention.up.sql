@@ -0,0 +1,5 @@
+-- Chats marked to keep forever are exempt from the retention job, which
+-- otherwise deletes idle chats and purges old tool call payloads.
+
+ALTER TABLE chats ADD COLUMN keep_forever INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_chats_keep_updated ON chats(keep_forever, updated_at);
@@ -0,0 +1,3 @@
+ALTER TABLE runs DROP COLUMN tool_latency_json;
+ALTER TABLE runs DROP COLUMN stream_ms;
+ALTER TABLE runs DROP COLUMN first_token_ms;
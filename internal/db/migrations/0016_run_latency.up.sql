@@ -0,0 +1,7 @@
+-- How each run's output arrived: the wait for the first token and the
+-- whole stream in milliseconds, NULL when unknown, and each tool call's
+-- duration as the JSON list ai.Latency records.
+
+ALTER TABLE runs ADD COLUMN first_token_ms INTEGER;
+ALTER TABLE runs ADD COLUMN stream_ms INTEGER;
+ALTER TABLE runs ADD COLUMN tool_latency_json TEXT;
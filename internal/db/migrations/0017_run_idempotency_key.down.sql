@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_runs_idempotency_key;
+ALTER TABLE runs DROP COLUMN idempotency_key;
@@ -0,0 +1,6 @@
+-- The key a client sent a message with, so a resent request finds the run
+-- the first one started instead of storing the message again. Keys are
+-- unique within a chat; runs sent without one leave it NULL.
+
+ALTER TABLE runs ADD COLUMN idempotency_key TEXT;
+CREATE UNIQUE INDEX idx_runs_idempotency_key ON runs(chat_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
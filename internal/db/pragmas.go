@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options tune the SQLite connection. Start from DefaultOptions.
+//
+// To replicate the database with Litestream, set WALAutocheckpoint to 0 so
+// only Litestream checkpoints the WAL, and keep BusyTimeout long enough to
+// outlast its checkpoints. LiteFS works with the defaults; a scheduled
+// Checkpoint keeps the WAL short when automatic checkpoints are off and no
+// tool runs them.
+type Options struct {
+	// BusyTimeout is how long a statement waits for a lock held by another
+	// connection or process before failing with SQLITE_BUSY.
+	BusyTimeout time.Duration
+	// Synchronous is OFF, NORMAL, FULL or EXTRA. NORMAL is durable across
+	// application crashes in WAL mode, and may lose the last transactions on
+	// power loss.
+	Synchronous string
+	// WALAutocheckpoint is the WAL size in pages at which a commit
+	// checkpoints it; 0 turns automatic checkpoints off.
+	WALAutocheckpoint int
+	// ReadConns sizes the pool of read-only connections that serve reads
+	// alongside the single writer; 0 sends reads through the writer.
+	ReadConns int
+}
+
+// DefaultOptions are SQLite's own settings but for a five second busy
+// timeout and NORMAL synchronous, with four read connections.
+func DefaultOptions() Options {
+	return Options{BusyTimeout: 5 * time.Second, Synchronous: "NORMAL", WALAutocheckpoint: 1000, ReadConns: 4}
+}
+
+// dsn adds the pragmas of opts to path. The driver runs them on every
+// connection it opens. Read-only connections refuse writes, and leave the
+// journal mode and checkpoints to the writer.
+func (opts Options) dsn(path string, readOnly bool) string {
+	pragmas := []string{
+		fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()),
+		"foreign_keys(1)",
+		"synchronous(" + opts.Synchronous + ")",
+	}
+	if readOnly {
+		pragmas = append(pragmas, "query_only(1)")
+	} else {
+		pragmas = append(pragmas, "journal_mode(WAL)", fmt.Sprintf("wal_autocheckpoint(%d)", opts.WALAutocheckpoint))
+	}
+	return path + "?" + url.Values{"_pragma": pragmas}.Encode()
+}
+
+func (opts Options) validate() error {
+	switch strings.ToUpper(opts.Synchronous) {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return fmt.Errorf("sqlite synchronous %q: want OFF, NORMAL, FULL or EXTRA", opts.Synchronous)
+	}
+	if opts.BusyTimeout < 0 || opts.WALAutocheckpoint < 0 || opts.ReadConns < 0 {
+		return fmt.Errorf("sqlite busy timeout, wal autocheckpoint and read connections must not be negative")
+	}
+	return nil
+}
+
+// Checkpoint modes; see https://sqlite.org/pragma.html#pragma_wal_checkpoint.
+const (
+	CheckpointPassive  = "PASSIVE"
+	CheckpointFull     = "FULL"
+	CheckpointRestart  = "RESTART"
+	CheckpointTruncate = "TRUNCATE"
+)
+
+// ErrCheckpointMode is returned for a checkpoint mode SQLite does not know.
+var ErrCheckpointMode = errors.New("checkpoint mode must be PASSIVE, FULL, RESTART or TRUNCATE")
+
+// CheckpointResult reports a WAL checkpoint. Busy is set when another
+// connection kept it from completing; WALFrames is the WAL's size in
+// frames and Checkpointed how many of them are now in the database.
+type CheckpointResult struct {
+	Mode         string `json:"mode"`
+	Busy         bool   `json:"busy"`
+	WALFrames    int64  `json:"wal_frames"`
+	Checkpointed int64  `json:"checkpointed_frames"`
+}
+
+// Checkpoint copies the WAL into the database file. PASSIVE never waits
+// for readers or writers; TRUNCATE waits for them and empties the WAL,
+// which replication tools that track the WAL themselves may not expect.
+func (s *Store) Checkpoint(ctx context.Context, mode string) (CheckpointResult, error) {
+	mode = strings.ToUpper(strings.TrimSpace(mode))
+	switch mode {
+	case "":
+		mode = CheckpointPassive
+	case CheckpointPassive, CheckpointFull, CheckpointRestart, CheckpointTruncate:
+	default:
+		return CheckpointResult{}, fmt.Errorf("%w, not %q", ErrCheckpointMode, mode)
+	}
+	result := CheckpointResult{Mode: mode}
+	var busy int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(`+mode+`)`).Scan(&busy, &result.WALFrames, &result.Checkpointed); err != nil {
+		return CheckpointResult{}, fmt.Errorf("checkpoint sqlite: %w", err)
+	}
+	result.Busy = busy != 0
+	return result, nil
+}
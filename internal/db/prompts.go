@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ScheduledPrompt is a prompt sent to a chat on a recurring schedule.
+// Frequency and TimeOfDay are kept as the user entered them; NextRunAt is
+// derived from them.
+type ScheduledPrompt struct {
+	ID         string
+	ChatID     string
+	OwnerID    string
+	Prompt     string
+	Frequency  string
+	TimeOfDay  string
+	NextRunAt  time.Time
+	LastRunAt  sql.NullTime
+	LastRunID  string
+	LastStatus string
+	LastError  string
+	CreatedAt  time.Time
+}
+
+const scheduledPromptColumns = `id, chat_id, owner_id, prompt, frequency, time_of_day, next_run_at, last_run_at, last_run_id, last_status, last_error, created_at`
+
+func (s *Store) scanScheduledPrompt(row rowScanner) (ScheduledPrompt, error) {
+	var prompt ScheduledPrompt
+	if err := row.Scan(&prompt.ID, &prompt.ChatID, &prompt.OwnerID, &prompt.Prompt, &prompt.Frequency, &prompt.TimeOfDay, &prompt.NextRunAt, &prompt.LastRunAt, &prompt.LastRunID, &prompt.LastStatus, &prompt.LastError, &prompt.CreatedAt); err != nil {
+		return ScheduledPrompt{}, err
+	}
+	if err := s.openAll(&prompt.Prompt); err != nil {
+		return ScheduledPrompt{}, fmt.Errorf("open scheduled prompt %s: %w", prompt.ID, err)
+	}
+	return prompt, nil
+}
+
+func (s *Store) InsertScheduledPrompt(ctx context.Context, prompt ScheduledPrompt) error {
+	text, err := s.seal(prompt.Prompt)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO scheduled_prompts (id, chat_id, owner_id, prompt, frequency, time_of_day, next_run_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		prompt.ID, prompt.ChatID, prompt.OwnerID, text, prompt.Frequency, prompt.TimeOfDay, prompt.NextRunAt, prompt.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert scheduled prompt: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) GetScheduledPrompt(ctx context.Context, id string) (ScheduledPrompt, error) {
+	prompt, err := s.scanScheduledPrompt(s.db.QueryRowContext(ctx, `
+SELECT `+scheduledPromptColumns+`
+FROM scheduled_prompts
+WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledPrompt{}, ErrNotFound
+	}
+	if err != nil {
+		return ScheduledPrompt{}, fmt.Errorf("get scheduled prompt: %w", err)
+	}
+	return prompt, nil
+}
+
+func (s *Store) ListScheduledPrompts(ctx context.Context, chatID string) ([]ScheduledPrompt, error) {
+	return s.queryScheduledPrompts(ctx, `
+SELECT `+scheduledPromptColumns+`
+FROM scheduled_prompts
+WHERE chat_id = ?
+ORDER BY created_at, id`, chatID)
+}
+
+// DueScheduledPrompts returns up to limit prompts whose next run is at or
+// before now, the longest overdue first.
+func (s *Store) DueScheduledPrompts(ctx context.Context, now time.Time, limit int) ([]ScheduledPrompt, error) {
+	return s.queryScheduledPrompts(ctx, `
+SELECT `+scheduledPromptColumns+`
+FROM scheduled_prompts
+WHERE next_run_at <= ?
+ORDER BY next_run_at, id
+LIMIT ?`, now, limit)
+}
+
+func (s *Store) queryScheduledPrompts(ctx context.Context, query string, args ...any) ([]ScheduledPrompt, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list scheduled prompts: %w", err)
+	}
+	defer rows.Close()
+
+	var prompts []ScheduledPrompt
+	for rows.Next() {
+		prompt, err := s.scanScheduledPrompt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan scheduled prompt: %w", err)
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, rows.Err()
+}
+
+// ClaimScheduledPrompt moves a due prompt's next run from due to next and
+// records the run about to start. It returns false when another worker
+// already claimed this occurrence.
+func (s *Store) ClaimScheduledPrompt(ctx context.Context, id string, due, next time.Time, runID string, now time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE scheduled_prompts
+SET next_run_at = ?, last_run_at = ?, last_run_id = ?, last_status = 'running', last_error = ''
+WHERE id = ? AND next_run_at = ?`, next, now, runID, id, due)
+	if err != nil {
+		return false, fmt.Errorf("claim scheduled prompt: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("claim scheduled prompt: %w", err)
+	}
+	return affected == 1, nil
+}
+
+func (s *Store) FinishScheduledPrompt(ctx context.Context, id, runID, status, errorText string) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE scheduled_prompts
+SET last_status = ?, last_error = ?
+WHERE id = ? AND last_run_id = ?`, status, errorText, id, runID)
+	if err != nil {
+		return fmt.Errorf("finish scheduled prompt: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) DeleteScheduledPrompt(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+DELETE FROM scheduled_prompts
+WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete scheduled prompt: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
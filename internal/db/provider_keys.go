@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoCodec is returned when a value that is only ever stored sealed is
+// written to a Store without a codec.
+var ErrNoCodec = errors.New("no encryption key is configured")
+
+// ProviderKey describes a stored provider API key without revealing it.
+// Hint is the key's last four characters.
+type ProviderKey struct {
+	Provider  string
+	Hint      string
+	UpdatedAt time.Time
+}
+
+// SetProviderKey stores userID's API key for provider, sealed.
+func (s *Store) SetProviderKey(ctx context.Context, userID, provider, key string, now time.Time) error {
+	if s.codec == nil {
+		return fmt.Errorf("set provider key: %w", ErrNoCodec)
+	}
+	sealed, err := s.seal(key)
+	if err != nil {
+		return fmt.Errorf("set provider key: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO provider_keys (user_id, provider, api_key, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id, provider) DO UPDATE SET api_key = excluded.api_key, updated_at = excluded.updated_at`, userID, provider, sealed, now)
+	if err != nil {
+		return fmt.Errorf("set provider key: %w", err)
+	}
+	return nil
+}
+
+// DeleteProviderKey removes userID's API key for provider.
+func (s *Store) DeleteProviderKey(ctx context.Context, userID, provider string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM provider_keys WHERE user_id = ? AND provider = ?`, userID, provider)
+	if err != nil {
+		return fmt.Errorf("delete provider key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ProviderKeys returns userID's API keys by provider, opened. A user
+// without keys gets an empty map.
+func (s *Store) ProviderKeys(ctx context.Context, userID string) (map[string]string, error) {
+	keys := map[string]string{}
+	if userID == "" {
+		return keys, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT provider, api_key FROM provider_keys WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("provider keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var provider, key string
+		if err := rows.Scan(&provider, &key); err != nil {
+			return nil, fmt.Errorf("scan provider key: %w", err)
+		}
+		if key, err = s.open(key); err != nil {
+			return nil, fmt.Errorf("open %s provider key: %w", provider, err)
+		}
+		keys[provider] = key
+	}
+	return keys, rows.Err()
+}
+
+// ListProviderKeys describes userID's stored API keys, by provider name.
+func (s *Store) ListProviderKeys(ctx context.Context, userID string) ([]ProviderKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT provider, api_key, updated_at
+FROM provider_keys
+WHERE user_id = ?
+ORDER BY provider ASC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list provider keys: %w", err)
+	}
+	defer rows.Close()
+	var listed []ProviderKey
+	for rows.Next() {
+		var key ProviderKey
+		var sealed string
+		if err := rows.Scan(&key.Provider, &sealed, &key.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan provider key: %w", err)
+		}
+		opened, err := s.open(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("open %s provider key: %w", key.Provider, err)
+		}
+		if len(opened) > 4 {
+			key.Hint = opened[len(opened)-4:]
+		}
+		listed = append(listed, key)
+	}
+	return listed, rows.Err()
+}
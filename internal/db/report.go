@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StoreReport summarizes where the database spends its space so operators
+// can tune retention and offloading.
+type StoreReport struct {
+	GeneratedAt   time.Time
+	PageSize      int64
+	PageCount     int64
+	FreelistCount int64
+	Tables        []TableStats
+	Indexes       []IndexStats
+	LargestChats  []ChatSize
+	LargestTools  []ToolPayloadSize
+	// IntegrityErrors lists PRAGMA quick_check findings; empty means healthy.
+	IntegrityErrors []string
+}
+
+func (r StoreReport) FileBytes() int64 {
+	return r.PageSize * r.PageCount
+}
+
+// FreeBytes is space held by deleted rows that VACUUM would return.
+func (r StoreReport) FreeBytes() int64 {
+	return r.PageSize * r.FreelistCount
+}
+
+type TableStats struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+type IndexStats struct {
+	Name  string
+	Table string
+	Bytes int64
+}
+
+type ChatSize struct {
+	ChatID       string
+	Title        string
+	Messages     int64
+	ContentBytes int64
+	ToolBytes    int64
+}
+
+type ToolPayloadSize struct {
+	ID          string
+	RunID       string
+	ChatID      string
+	Name        string
+	InputBytes  int64
+	OutputBytes int64
+}
+
+// Report builds a StoreReport listing the top largest chats and tool calls.
+func (s *Store) Report(ctx context.Context, top int) (StoreReport, error) {
+	if top < 1 {
+		top = 10
+	}
+	report := StoreReport{GeneratedAt: time.Now().UTC()}
+	for pragma, dest := range map[string]*int64{
+		"page_size":      &report.PageSize,
+		"page_count":     &report.PageCount,
+		"freelist_count": &report.FreelistCount,
+	} {
+		if err := s.db.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(dest); err != nil {
+			return StoreReport{}, fmt.Errorf("read %s: %w", pragma, err)
+		}
+	}
+
+	sizes, err := s.objectSizes(ctx)
+	if err != nil {
+		return StoreReport{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT type, name, tbl_name
+FROM sqlite_master
+WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'
+ORDER BY type DESC, name ASC`)
+	if err != nil {
+		return StoreReport{}, fmt.Errorf("list schema objects: %w", err)
+	}
+	var tableNames []string
+	for rows.Next() {
+		var kind, name, table string
+		if err := rows.Scan(&kind, &name, &table); err != nil {
+			rows.Close()
+			return StoreReport{}, fmt.Errorf("scan schema object: %w", err)
+		}
+		if kind == "table" {
+			tableNames = append(tableNames, name)
+			continue
+		}
+		report.Indexes = append(report.Indexes, IndexStats{Name: name, Table: table, Bytes: sizes[name]})
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return StoreReport{}, fmt.Errorf("list schema objects: %w", err)
+	}
+	rows.Close()
+
+	for _, name := range tableNames {
+		stats := TableStats{Name: name, Bytes: sizes[name]}
+		quoted := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoted).Scan(&stats.Rows); err != nil {
+			return StoreReport{}, fmt.Errorf("count %s: %w", name, err)
+		}
+		report.Tables = append(report.Tables, stats)
+	}
+
+	if report.LargestChats, err = s.largestChats(ctx, top); err != nil {
+		return StoreReport{}, err
+	}
+	if report.LargestTools, err = s.largestToolPayloads(ctx, top); err != nil {
+		return StoreReport{}, err
+	}
+	if report.IntegrityErrors, err = s.quickCheck(ctx); err != nil {
+		return StoreReport{}, err
+	}
+	return report, nil
+}
+
+// objectSizes returns the on-disk bytes per table and index from the dbstat
+// virtual table, or an empty map when SQLite was built without it.
+func (s *Store) objectSizes(ctx context.Context) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	rows, err := s.db.QueryContext(ctx, `SELECT name, SUM(pgsize) FROM dbstat GROUP BY name`)
+	if err != nil {
+		return sizes, nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var bytes int64
+		if err := rows.Scan(&name, &bytes); err != nil {
+			return nil, fmt.Errorf("scan object size: %w", err)
+		}
+		sizes[name] = bytes
+	}
+	return sizes, rows.Err()
+}
+
+func (s *Store) largestChats(ctx context.Context, top int) ([]ChatSize, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT c.id, c.title,
+  (SELECT COUNT(*) FROM messages m WHERE m.chat_id = c.id),
+  (SELECT COALESCE(SUM(LENGTH(m.content) + LENGTH(COALESCE(m.reasoning, ''))), 0) FROM messages m WHERE m.chat_id = c.id) AS content_bytes,
+  (SELECT COALESCE(SUM(LENGTH(COALESCE(t.input_json, '')) + LENGTH(COALESCE(t.output_json, ''))), 0)
+     FROM tool_calls t JOIN runs r ON r.id = t.run_id WHERE r.chat_id = c.id) AS tool_bytes
+FROM chats c
+ORDER BY content_bytes + tool_bytes DESC, c.id ASC
+LIMIT ?`, top)
+	if err != nil {
+		return nil, fmt.Errorf("list largest chats: %w", err)
+	}
+	defer rows.Close()
+
+	chats := make([]ChatSize, 0, top)
+	for rows.Next() {
+		var chat ChatSize
+		if err := rows.Scan(&chat.ChatID, &chat.Title, &chat.Messages, &chat.ContentBytes, &chat.ToolBytes); err != nil {
+			return nil, fmt.Errorf("scan chat size: %w", err)
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+func (s *Store) largestToolPayloads(ctx context.Context, top int) ([]ToolPayloadSize, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT t.id, t.run_id, r.chat_id, t.name,
+  LENGTH(COALESCE(t.input_json, '')) AS input_bytes,
+  LENGTH(COALESCE(t.output_json, '')) AS output_bytes
+FROM tool_calls t
+JOIN runs r ON r.id = t.run_id
+ORDER BY input_bytes + output_bytes DESC, t.id ASC
+LIMIT ?`, top)
+	if err != nil {
+		return nil, fmt.Errorf("list largest tool payloads: %w", err)
+	}
+	defer rows.Close()
+
+	payloads := make([]ToolPayloadSize, 0, top)
+	for rows.Next() {
+		var payload ToolPayloadSize
+		if err := rows.Scan(&payload.ID, &payload.RunID, &payload.ChatID, &payload.Name, &payload.InputBytes, &payload.OutputBytes); err != nil {
+			return nil, fmt.Errorf("scan tool payload size: %w", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, rows.Err()
+}
+
+func (s *Store) quickCheck(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `PRAGMA quick_check`)
+	if err != nil {
+		return nil, fmt.Errorf("quick check: %w", err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("scan quick check: %w", err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
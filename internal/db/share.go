@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShareLink is a read-only public link to a chat. CreatedBy is the user
+// who made it; RevokedAt is set once the link stops working.
+type ShareLink struct {
+	ID        string
+	ChatID    string
+	CreatedBy string
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+}
+
+const shareLinkColumns = `id, chat_id, created_by, created_at, revoked_at`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(&link.ID, &link.ChatID, &link.CreatedBy, &link.CreatedAt, &link.RevokedAt)
+	return link, err
+}
+
+func (s *Store) CreateShareLink(ctx context.Context, link ShareLink) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO share_links (id, chat_id, created_by, created_at)
+VALUES (?, ?, ?, ?)`, link.ID, link.ChatID, link.CreatedBy, link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create share link: %w", err)
+	}
+	return nil
+}
+
+// GetShareLink returns a link, revoked or not, or ErrNotFound.
+func (s *Store) GetShareLink(ctx context.Context, id string) (ShareLink, error) {
+	link, err := scanShareLink(s.db.QueryRowContext(ctx, `SELECT `+shareLinkColumns+` FROM share_links WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareLink{}, ErrNotFound
+	}
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("get share link: %w", err)
+	}
+	return link, nil
+}
+
+// ListShareLinks returns a chat's links that have not been revoked, oldest
+// first.
+func (s *Store) ListShareLinks(ctx context.Context, chatID string) ([]ShareLink, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+shareLinkColumns+`
+FROM share_links
+WHERE chat_id = ? AND revoked_at IS NULL
+ORDER BY created_at ASC, id ASC`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list share links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan share link: %w", err)
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// RevokeShareLink stops a chat's link from working. It returns ErrNotFound
+// when the chat has no such link or it was already revoked.
+func (s *Store) RevokeShareLink(ctx context.Context, chatID, id string, now time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE share_links
+SET revoked_at = ?
+WHERE id = ? AND chat_id = ? AND revoked_at IS NULL`, now, id, chatID)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MessageSource is a web page an assistant message drew on, recorded from
+// the tool call that read or found it. A message lists each URL once.
+type MessageSource struct {
+	MessageID   string
+	URL         string
+	Title       string
+	RunID       string
+	ToolCallID  string
+	RetrievedAt time.Time
+}
+
+const messageSourceColumns = `message_id, url, title, run_id, tool_call_id, retrieved_at`
+
+func scanMessageSource(row rowScanner) (MessageSource, error) {
+	var source MessageSource
+	err := row.Scan(&source.MessageID, &source.URL, &source.Title, &source.RunID, &source.ToolCallID, &source.RetrievedAt)
+	return source, err
+}
+
+// AddToolCallSources records sources found by the tool call with row id
+// callID against its run's assistant message. A URL the message already
+// lists keeps its first retrieval.
+func (s *Store) AddToolCallSources(ctx context.Context, callID string, sources []MessageSource, retrievedAt time.Time) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, source := range sources {
+			_, err := tx.ExecContext(ctx, `
+INSERT INTO message_sources (message_id, url, title, run_id, tool_call_id, retrieved_at)
+SELECT r.assistant_message_id, ?, ?, r.id, COALESCE(t.tool_call_id, ''), ?
+FROM tool_calls t
+JOIN runs r ON r.id = t.run_id
+WHERE t.id = ?
+ON CONFLICT(message_id, url) DO NOTHING`, source.URL, source.Title, retrievedAt, callID)
+			if err != nil {
+				return fmt.Errorf("add message source: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteMessageSourcesTx clears a message's sources, for a retry that
+// rewrites the message.
+func DeleteMessageSourcesTx(ctx context.Context, tx *sql.Tx, messageID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_sources WHERE message_id = ?`, messageID); err != nil {
+		return fmt.Errorf("delete message sources: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ListMessageSources(ctx context.Context, messageID string) ([]MessageSource, error) {
+	return s.queryMessageSources(ctx, `
+SELECT `+messageSourceColumns+`
+FROM message_sources
+WHERE message_id = ?
+ORDER BY retrieved_at, rowid`, messageID)
+}
+
+// ListChatSources returns the sources of every message in a chat, grouped
+// by message in retrieval order.
+func (s *Store) ListChatSources(ctx context.Context, chatID string) ([]MessageSource, error) {
+	return s.queryMessageSources(ctx, `
+SELECT `+messageSourceColumns+`
+FROM message_sources
+WHERE message_id IN (SELECT id FROM messages WHERE chat_id = ?)
+ORDER BY message_id, retrieved_at, rowid`, chatID)
+}
+
+func (s *Store) queryMessageSources(ctx context.Context, query string, args ...any) ([]MessageSource, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list message sources: %w", err)
+	}
+	defer rows.Close()
+
+	sources := make([]MessageSource, 0)
+	for rows.Next() {
+		source, err := scanMessageSource(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message source: %w", err)
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
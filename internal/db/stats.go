@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ModelRunStats counts one model's runs by outcome with their token usage
+// and known cost.
+type ModelRunStats struct {
+	Model        string
+	Runs         int64
+	Running      int64
+	Completed    int64
+	Errors       int64
+	TimedOut     int64
+	Cancelled    int64
+	Interrupted  int64
+	InputTokens  int64
+	OutputTokens int64
+	CostUSD      float64
+}
+
+// RunStatsByModel aggregates the runs started at or after since, per model
+// in name order.
+func (s *Store) RunStatsByModel(ctx context.Context, since time.Time) ([]ModelRunStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT model,
+  COUNT(*),
+  SUM(status = 'running'),
+  SUM(status = 'completed'),
+  SUM(status = 'error'),
+  SUM(status = 'timed_out'),
+  SUM(status = 'cancelled'),
+  SUM(status = 'interrupted'),
+  COALESCE(SUM(input_tokens), 0),
+  COALESCE(SUM(output_tokens), 0),
+  COALESCE(SUM(cost_usd), 0)
+FROM runs
+WHERE started_at >= ?
+GROUP BY model
+ORDER BY model ASC`, since)
+	if err != nil {
+		return nil, fmt.Errorf("run stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []ModelRunStats
+	for rows.Next() {
+		var model ModelRunStats
+		if err := rows.Scan(&model.Model, &model.Runs, &model.Running, &model.Completed, &model.Errors, &model.TimedOut,
+			&model.Cancelled, &model.Interrupted, &model.InputTokens, &model.OutputTokens, &model.CostUSD); err != nil {
+			return nil, fmt.Errorf("scan run stats: %w", err)
+		}
+		stats = append(stats, model)
+	}
+	return stats, rows.Err()
+}
+
+// SizeBytes returns the size of the database file from its page count.
+func (s *Store) SizeBytes(ctx context.Context) (int64, error) {
+	var size int64
+	if err := s.db.QueryRowContext(ctx, `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size); err != nil {
+		return 0, fmt.Errorf("database size: %w", err)
+	}
+	return size, nil
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -15,28 +16,85 @@ import (
 
 var ErrNotFound = errors.New("not found")
 
+// Store is the SQLite database. Writes go through db, a single
+// connection, so they are serialized; read holds a pool of read-only
+// connections that, in WAL mode, serve reads while a write is in progress.
+// Both see every committed write.
 type Store struct {
-	db *sql.DB
+	db   *sql.DB
+	read *sql.DB
+	// codec seals sensitive columns; nil stores them in the clear.
+	codec Codec
 }
 
 type Chat struct {
-	ID        string
-	Title     string
-	Model     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID          string
+	Title       string
+	Model       string
+	Temperature sql.NullFloat64
+	MaxTokens   sql.NullInt64
+	TopP        sql.NullFloat64
+	// ReasoningEffort is "low", "medium" or "high"; null uses the default.
+	ReasoningEffort sql.NullString
+	// OwnerID is the user that owns the chat in multi-user mode. Null marks a
+	// chat created before ownership existed, visible to every user.
+	OwnerID sql.NullString
+	// MaxSpendUSD is the chat's spend ceiling; null leaves it unlimited.
+	MaxSpendUSD sql.NullFloat64
+	// KeepForever exempts the chat from retention.
+	KeepForever bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ChatParams holds the per-chat generation parameters. Invalid (null) fields
+// fall back to the provider defaults.
+type ChatParams struct {
+	Temperature     sql.NullFloat64
+	MaxTokens       sql.NullInt64
+	TopP            sql.NullFloat64
+	ReasoningEffort sql.NullString
+}
+
+// AuditEntry records an administrative change such as an ownership transfer.
+type AuditEntry struct {
+	ID         string
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	DetailJSON string
+	CreatedAt  time.Time
 }
 
 type Message struct {
-	ID        string
-	ChatID    string
-	Role      string
-	Content   string
+	ID      string
+	ChatID  string
+	Role    string
+	Content string
+	// Reasoning holds the thinking text streamed alongside an assistant
+	// answer. It is shown to the user but never sent back to the model.
+	Reasoning string
 	Status    string
+	// Flag is empty, MessageFlagSensitive or MessageFlagHidden.
+	Flag      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Message flags. Flagged messages stay in the owner's history and in model
+// context but are left out of exports and shared views.
+const (
+	MessageFlagSensitive = "sensitive"
+	MessageFlagHidden    = "hidden"
+)
+
+// Shareable reports whether the message may appear in exports and shared
+// views.
+func (m Message) Shareable() bool {
+	return m.Flag == ""
+}
+
 type Run struct {
 	ID                 string
 	ChatID             string
@@ -49,8 +107,64 @@ type Run struct {
 	ToolCallCount      int
 	TurnCount          int
 	UsageJSON          string
-	StartedAt          time.Time
-	FinishedAt         sql.NullTime
+	// InputTokens and OutputTokens are the usage_json token counts.
+	InputTokens  int
+	OutputTokens int
+	// CostUSD is null when the run's cost is unknown.
+	CostUSD sql.NullFloat64
+	// RequestJSON is the uncompressed request snapshot written before
+	// run_snapshots existed; newer runs leave it empty.
+	RequestJSON string
+	// ComparisonID is set on both runs of a side-by-side comparison to the
+	// first run's ID.
+	ComparisonID string
+	// ModerationJSON is the moderation decision on the user message, empty
+	// when it was not screened.
+	ModerationJSON string
+	// IdempotencyKey is the key the client sent the message with, unique
+	// within the chat; empty when it sent none.
+	IdempotencyKey string
+	Latency        RunLatency
+	StartedAt      time.Time
+	FinishedAt     sql.NullTime
+}
+
+// RunLatency is how a run's output arrived, zero when it is unknown: the
+// wait for the first token, the whole stream, and ToolsJSON listing each
+// tool call's duration.
+type RunLatency struct {
+	FirstToken time.Duration
+	Stream     time.Duration
+	ToolsJSON  string
+}
+
+// RunSnapshot is the compressed request a run sent to the model. SHA256 is
+// the hex digest of the uncompressed snapshot, so audits can prove the
+// stored prompt is the one that produced the answer.
+type RunSnapshot struct {
+	RunID     string
+	SHA256    string
+	Encoding  string
+	Data      []byte
+	SizeBytes int64
+	CreatedAt time.Time
+}
+
+// ReplayRun is a debug re-execution of a recorded run's request. It is kept
+// apart from runs so replays never add messages to the chat.
+type ReplayRun struct {
+	ID            string
+	SourceRunID   string
+	Model         string
+	Status        string
+	Output        string
+	Reasoning     string
+	ToolCallsJSON string
+	StopReason    string
+	ErrorText     string
+	UsageJSON     string
+	StartedAt     time.Time
+	FinishedAt    sql.NullTime
 }
 
 type ToolCall struct {
@@ -67,105 +181,112 @@ type ToolCall struct {
 }
 
 func OpenSQLite(path string) (*Store, error) {
+	return OpenSQLiteWith(path, DefaultOptions())
+}
+
+// OpenSQLiteWith opens the database with opts and migrates it to the
+// latest version.
+func OpenSQLiteWith(path string, opts Options) (*Store, error) {
+	return openSQLite(path, opts, LatestSchemaVersion())
+}
+
+// OpenSQLiteAt opens the database and migrates it up or down to version.
+func OpenSQLiteAt(path string, version int) (*Store, error) {
+	return openSQLite(path, DefaultOptions(), version)
+}
+
+func openSQLite(path string, opts Options, version int) (*Store, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return nil, fmt.Errorf("create db dir: %w", err)
 	}
 
-	database, err := sql.Open("sqlite", path)
+	database, err := sql.Open("sqlite", opts.dsn(path, false))
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite: %w", err)
 	}
 	database.SetMaxOpenConns(1)
 	database.SetConnMaxLifetime(0)
 
-	store := &Store{db: database}
-	if err := store.migrate(context.Background()); err != nil {
+	store := &Store{db: database, read: database}
+	if err := store.MigrateTo(context.Background(), version); err != nil {
 		database.Close()
 		return nil, err
 	}
+	// The readers open after the migrations, once the database is in WAL
+	// mode.
+	if opts.ReadConns > 0 {
+		read, err := sql.Open("sqlite", opts.dsn(path, true))
+		if err != nil {
+			database.Close()
+			return nil, fmt.Errorf("open sqlite readers: %w", err)
+		}
+		read.SetMaxOpenConns(opts.ReadConns)
+		read.SetMaxIdleConns(opts.ReadConns)
+		read.SetConnMaxLifetime(0)
+		store.read = read
+	}
 	return store, nil
 }
 
 func (s *Store) Close() error {
-	return s.db.Close()
-}
-
-func (s *Store) migrate(ctx context.Context) error {
-	const schema = `
-PRAGMA journal_mode=WAL;
-PRAGMA foreign_keys=ON;
-
-CREATE TABLE IF NOT EXISTS chats (
-  id TEXT PRIMARY KEY,
-  title TEXT NOT NULL,
-  model TEXT NOT NULL,
-  created_at DATETIME NOT NULL,
-  updated_at DATETIME NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS messages (
-  id TEXT PRIMARY KEY,
-  chat_id TEXT NOT NULL,
-  role TEXT NOT NULL,
-  content TEXT NOT NULL,
-  status TEXT NOT NULL,
-  created_at DATETIME NOT NULL,
-  updated_at DATETIME NOT NULL,
-  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE
-);
-CREATE INDEX IF NOT EXISTS idx_messages_chat_created ON messages(chat_id, created_at, id);
-
-CREATE TABLE IF NOT EXISTS runs (
-  id TEXT PRIMARY KEY,
-  chat_id TEXT NOT NULL,
-  user_message_id TEXT NOT NULL,
-  assistant_message_id TEXT NOT NULL,
-  model TEXT NOT NULL,
-  status TEXT NOT NULL,
-  stop_reason TEXT,
-  error_text TEXT,
-  tool_call_count INTEGER NOT NULL DEFAULT 0,
-  turn_count INTEGER NOT NULL DEFAULT 0,
-  usage_json TEXT,
-  started_at DATETIME NOT NULL,
-  finished_at DATETIME,
-  FOREIGN KEY(chat_id) REFERENCES chats(id) ON DELETE CASCADE,
-  FOREIGN KEY(user_message_id) REFERENCES messages(id) ON DELETE RESTRICT,
-  FOREIGN KEY(assistant_message_id) REFERENCES messages(id) ON DELETE RESTRICT
-);
-CREATE INDEX IF NOT EXISTS idx_runs_chat_started ON runs(chat_id, started_at, id);
-
-CREATE TABLE IF NOT EXISTS tool_calls (
-  id TEXT PRIMARY KEY,
-  run_id TEXT NOT NULL,
-  tool_call_id TEXT,
-  name TEXT NOT NULL,
-  status TEXT NOT NULL,
-  input_json TEXT,
-  output_json TEXT,
-  error_text TEXT,
-  started_at DATETIME NOT NULL,
-  finished_at DATETIME,
-  FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
-);
-CREATE INDEX IF NOT EXISTS idx_tool_calls_run_started ON tool_calls(run_id, started_at, id);
-`
-	_, err := s.db.ExecContext(ctx, schema)
-	if err != nil {
-		return fmt.Errorf("migrate sqlite schema: %w", err)
+	var err error
+	if s.read != s.db {
+		err = s.read.Close()
+	}
+	return errors.Join(s.db.Close(), err)
+}
+
+// Ping checks that the database answers.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping sqlite: %w", err)
 	}
 	return nil
 }
 
-func (s *Store) ListChats(ctx context.Context, limit int) ([]Chat, error) {
+// ChatCursor marks the last chat of a page of ListChats. The zero cursor
+// starts at the most recently updated chat.
+type ChatCursor struct {
+	UpdatedAt time.Time
+	ID        string
+}
+
+// IsZero reports whether the cursor starts from the beginning.
+func (c ChatCursor) IsZero() bool {
+	return c.ID == "" && c.UpdatedAt.IsZero()
+}
+
+// CursorAfter returns the cursor that continues after chat.
+func CursorAfter(chat Chat) ChatCursor {
+	return ChatCursor{UpdatedAt: chat.UpdatedAt, ID: chat.ID}
+}
+
+// ListChats returns the chats visible to ownerID: the chats it owns, plus
+// unowned ones with withUnowned. An empty ownerID lists every chat. Chats
+// come most recently updated first, starting after the cursor.
+func (s *Store) ListChats(ctx context.Context, ownerID string, withUnowned bool, after ChatCursor, limit int) ([]Chat, error) {
 	if limit < 1 {
 		limit = 100
 	}
-	rows, err := s.db.QueryContext(ctx, `
-SELECT id, title, model, created_at, updated_at
+	query := `
+SELECT ` + chatColumns + `
 FROM chats
+WHERE (? = '' OR (? AND owner_id IS NULL) OR owner_id = ?)`
+	args := []any{ownerID, withUnowned, ownerID}
+	if !after.IsZero() {
+		query += `
+  AND (updated_at < ? OR (updated_at = ? AND id < ?))`
+		updatedAt := after.UpdatedAt.UTC()
+		args = append(args, updatedAt, updatedAt, after.ID)
+	}
+	query += `
 ORDER BY updated_at DESC, id DESC
-LIMIT ?`, limit)
+LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.read.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list chats: %w", err)
 	}
@@ -173,21 +294,81 @@ LIMIT ?`, limit)
 
 	chats := make([]Chat, 0, limit)
 	for rows.Next() {
-		var chat Chat
-		if err := rows.Scan(&chat.ID, &chat.Title, &chat.Model, &chat.CreatedAt, &chat.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("scan chat: %w", err)
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
 		}
 		chats = append(chats, chat)
 	}
 	return chats, rows.Err()
 }
 
-func (s *Store) GetChat(ctx context.Context, chatID string) (Chat, error) {
+// ListOwnedChats returns every chat owned by ownerID, oldest first. An
+// empty ownerID lists the unowned chats.
+func (s *Store) ListOwnedChats(ctx context.Context, ownerID string) ([]Chat, error) {
+	rows, err := s.read.QueryContext(ctx, `
+SELECT `+chatColumns+`
+FROM chats
+WHERE (? = '' AND owner_id IS NULL) OR owner_id = ?
+ORDER BY created_at ASC, id ASC`, ownerID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list owned chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []Chat
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// ListChatOwners returns every distinct chat owner, with "" standing for
+// unowned chats.
+func (s *Store) ListChatOwners(ctx context.Context) ([]string, error) {
+	rows, err := s.read.QueryContext(ctx, `
+SELECT DISTINCT COALESCE(owner_id, '')
+FROM chats
+ORDER BY 1`)
+	if err != nil {
+		return nil, fmt.Errorf("list chat owners: %w", err)
+	}
+	defer rows.Close()
+
+	var owners []string
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+	return owners, rows.Err()
+}
+
+const chatColumns = `id, title, model, temperature, max_tokens, top_p, reasoning_effort, owner_id, max_spend_usd, keep_forever, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChat(row rowScanner) (Chat, error) {
 	var chat Chat
-	err := s.db.QueryRowContext(ctx, `
-SELECT id, title, model, created_at, updated_at
+	if err := row.Scan(&chat.ID, &chat.Title, &chat.Model, &chat.Temperature, &chat.MaxTokens, &chat.TopP, &chat.ReasoningEffort, &chat.OwnerID, &chat.MaxSpendUSD, &chat.KeepForever, &chat.CreatedAt, &chat.UpdatedAt); err != nil {
+		return Chat{}, fmt.Errorf("scan chat: %w", err)
+	}
+	return chat, nil
+}
+
+func (s *Store) GetChat(ctx context.Context, chatID string) (Chat, error) {
+	chat, err := scanChat(s.read.QueryRowContext(ctx, `
+SELECT `+chatColumns+`
 FROM chats
-WHERE id = ?`, chatID).Scan(&chat.ID, &chat.Title, &chat.Model, &chat.CreatedAt, &chat.UpdatedAt)
+WHERE id = ?`, chatID))
 	if errors.Is(err, sql.ErrNoRows) {
 		return Chat{}, ErrNotFound
 	}
@@ -198,13 +379,113 @@ WHERE id = ?`, chatID).Scan(&chat.ID, &chat.Title, &chat.Model, &chat.CreatedAt,
 }
 
 func (s *Store) CreateChat(ctx context.Context, id, title, model string, now time.Time) (Chat, error) {
+	return s.CreateOwnedChat(ctx, id, title, model, "", now)
+}
+
+// CreateOwnedChat creates a chat owned by ownerID; an empty ownerID leaves
+// the chat unowned.
+func (s *Store) CreateOwnedChat(ctx context.Context, id, title, model, ownerID string, now time.Time) (Chat, error) {
+	owner := sql.NullString{String: ownerID, Valid: ownerID != ""}
 	_, err := s.db.ExecContext(ctx, `
-INSERT INTO chats (id, title, model, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?)`, id, title, model, now, now)
+INSERT INTO chats (id, title, model, owner_id, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)`, id, title, model, owner, now, now)
 	if err != nil {
 		return Chat{}, fmt.Errorf("create chat: %w", err)
 	}
-	return Chat{ID: id, Title: title, Model: model, CreatedAt: now, UpdatedAt: now}, nil
+	return Chat{ID: id, Title: title, Model: model, OwnerID: owner, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// TransferChat moves a chat to a new owner and records entry in the audit
+// log in the same transaction. Runs, messages and tool calls hang off the
+// chat and move with it.
+func (s *Store) TransferChat(ctx context.Context, chatID, ownerID string, entry AuditEntry) error {
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+UPDATE chats
+SET owner_id = ?, updated_at = ?
+WHERE id = ?`, ownerID, entry.CreatedAt, chatID)
+		if err != nil {
+			return fmt.Errorf("transfer chat: %w", err)
+		}
+		affected, err := result.RowsAffected()
+		if err == nil && affected == 0 {
+			return ErrNotFound
+		}
+		return InsertAuditEntryTx(ctx, tx, entry)
+	})
+}
+
+// CloneChat creates chat, with its owner and generation parameters, and
+// inserts messages into it in one transaction, so a copy is never left
+// half made.
+func (s *Store) CloneChat(ctx context.Context, chat Chat, messages []Message) error {
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO chats (id, title, model, temperature, max_tokens, top_p, reasoning_effort, owner_id, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, chat.ID, chat.Title, chat.Model, chat.Temperature, chat.MaxTokens, chat.TopP, chat.ReasoningEffort, chat.OwnerID, chat.CreatedAt, chat.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("clone chat: %w", err)
+		}
+		for _, message := range messages {
+			content, err := s.seal(message.Content)
+			if err != nil {
+				return err
+			}
+			reasoning, err := s.seal(message.Reasoning)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+INSERT INTO messages (id, chat_id, role, content, reasoning, status, flag, created_at, updated_at)
+VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?, ?)`, message.ID, chat.ID, message.Role, content, reasoning, message.Status, message.Flag, message.CreatedAt, message.UpdatedAt)
+			if err != nil {
+				return fmt.Errorf("clone message: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) InsertAuditEntry(ctx context.Context, entry AuditEntry) error {
+	return s.Transaction(ctx, func(tx *sql.Tx) error {
+		return InsertAuditEntryTx(ctx, tx, entry)
+	})
+}
+
+func InsertAuditEntryTx(ctx context.Context, tx *sql.Tx, entry AuditEntry) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO audit_log (id, actor_id, action, target_type, target_id, detail_json, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`, entry.ID, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, entry.DetailJSON, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert audit entry tx: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ListAuditEntries(ctx context.Context, targetType, targetID string, limit int) ([]AuditEntry, error) {
+	if limit < 1 {
+		limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, actor_id, action, target_type, target_id, COALESCE(detail_json, ''), created_at
+FROM audit_log
+WHERE target_type = ? AND target_id = ?
+ORDER BY created_at ASC, id ASC
+LIMIT ?`, targetType, targetID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetType, &entry.TargetID, &entry.DetailJSON, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
 }
 
 func (s *Store) RenameChat(ctx context.Context, chatID, title string, now time.Time) error {
@@ -251,15 +532,100 @@ WHERE id = ?`, model, now, chatID)
 	return nil
 }
 
+func (s *Store) UpdateChatParams(ctx context.Context, chatID string, params ChatParams, now time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE chats
+SET temperature = ?, max_tokens = ?, top_p = ?, reasoning_effort = ?, updated_at = ?
+WHERE id = ?`, params.Temperature, params.MaxTokens, params.TopP, params.ReasoningEffort, now, chatID)
+	if err != nil {
+		return fmt.Errorf("update chat params: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetChatMaxSpend sets or clears (null) the chat's spend ceiling.
+func (s *Store) SetChatMaxSpend(ctx context.Context, chatID string, maxSpendUSD sql.NullFloat64, now time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE chats
+SET max_spend_usd = ?, updated_at = ?
+WHERE id = ?`, maxSpendUSD, now, chatID)
+	if err != nil {
+		return fmt.Errorf("set chat max spend: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetChatKeepForever marks the chat as exempt from retention, or not. It
+// leaves updated_at alone, so the chat keeps its place in the list.
+func (s *Store) SetChatKeepForever(ctx context.Context, chatID string, keep bool) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE chats SET keep_forever = ? WHERE id = ?`, keep, chatID)
+	if err != nil {
+		return fmt.Errorf("set chat keep forever: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IdleChats returns up to limit chats last updated before cutoff that are
+// not kept forever, the longest idle first.
+func (s *Store) IdleChats(ctx context.Context, cutoff time.Time, limit int) ([]Chat, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+chatColumns+`
+FROM chats
+WHERE keep_forever = 0 AND updated_at < ?
+ORDER BY updated_at ASC, id ASC
+LIMIT ?`, cutoff, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list idle chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []Chat
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// ChatSpendUSD sums the recorded cost of the chat's runs. Runs without a
+// known cost count as free.
+func (s *Store) ChatSpendUSD(ctx context.Context, chatID string) (float64, error) {
+	var spent float64
+	if err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(cost_usd), 0)
+FROM runs
+WHERE chat_id = ?`, chatID).Scan(&spent); err != nil {
+		return 0, fmt.Errorf("chat spend: %w", err)
+	}
+	return spent, nil
+}
+
+// ListMessages returns a chat's messages in conversation order. A prompt and
+// its reply are saved with the same timestamp, so ties go by insertion.
 func (s *Store) ListMessages(ctx context.Context, chatID string, limit int) ([]Message, error) {
 	if limit < 1 {
 		limit = 300
 	}
-	rows, err := s.db.QueryContext(ctx, `
-SELECT id, chat_id, role, content, status, created_at, updated_at
+	rows, err := s.read.QueryContext(ctx, `
+SELECT `+messageColumns+`
 FROM messages
 WHERE chat_id = ?
-ORDER BY created_at ASC, id ASC
+ORDER BY created_at ASC, rowid ASC
 LIMIT ?`, chatID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("list messages: %w", err)
@@ -268,150 +634,1099 @@ LIMIT ?`, chatID, limit)
 
 	messages := make([]Message, 0, limit)
 	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.Role, &msg.Content, &msg.Status, &msg.CreatedAt, &msg.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("scan message: %w", err)
+		msg, err := s.scanMessage(rows)
+		if err != nil {
+			return nil, err
 		}
 		messages = append(messages, msg)
 	}
 	return messages, rows.Err()
 }
 
-func (s *Store) InsertMessage(ctx context.Context, message Message) error {
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO messages (id, chat_id, role, content, status, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?, ?, ?)`, message.ID, message.ChatID, message.Role, message.Content, message.Status, message.CreatedAt, message.UpdatedAt)
-	if err != nil {
-		return fmt.Errorf("insert message: %w", err)
-	}
-	return nil
-}
+const messageColumns = `id, chat_id, role, content, COALESCE(reasoning, ''), status, COALESCE(flag, ''), created_at, updated_at`
 
-func (s *Store) UpdateMessageContent(ctx context.Context, messageID, content, status string, now time.Time) error {
-	_, err := s.db.ExecContext(ctx, `
-UPDATE messages
-SET content = ?, status = ?, updated_at = ?
-WHERE id = ?`, content, status, now, messageID)
-	if err != nil {
-		return fmt.Errorf("update message content: %w", err)
+// scanMessage scans a row of messageColumns and opens its sealed columns.
+func (s *Store) scanMessage(row rowScanner) (Message, error) {
+	var msg Message
+	if err := row.Scan(&msg.ID, &msg.ChatID, &msg.Role, &msg.Content, &msg.Reasoning, &msg.Status, &msg.Flag, &msg.CreatedAt, &msg.UpdatedAt); err != nil {
+		return Message{}, fmt.Errorf("scan message: %w", err)
 	}
-	return nil
-}
-
-func (s *Store) UpsertRunStart(ctx context.Context, run Run) error {
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO runs (id, chat_id, user_message_id, assistant_message_id, model, status, started_at, tool_call_count, turn_count)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-status = excluded.status,
-model = excluded.model,
-chat_id = excluded.chat_id,
-user_message_id = excluded.user_message_id,
-assistant_message_id = excluded.assistant_message_id,
-started_at = excluded.started_at`,
-		run.ID, run.ChatID, run.UserMessageID, run.AssistantMessageID, run.Model, run.Status, run.StartedAt, run.ToolCallCount, run.TurnCount)
-	if err != nil {
-		return fmt.Errorf("upsert run start: %w", err)
+	if err := s.openAll(&msg.Content, &msg.Reasoning); err != nil {
+		return Message{}, fmt.Errorf("open message %s: %w", msg.ID, err)
 	}
-	return nil
+	return msg, nil
 }
 
-func (s *Store) CompleteRun(ctx context.Context, runID, status, stopReason, errorText string, toolCallCount, turnCount int, usage any, finishedAt time.Time) error {
-	usageBytes, err := json.Marshal(usage)
-	if err != nil {
-		usageBytes = []byte("{}")
+func (s *Store) GetMessage(ctx context.Context, messageID string) (Message, error) {
+	msg, err := s.scanMessage(s.read.QueryRowContext(ctx, `
+SELECT `+messageColumns+`
+FROM messages
+WHERE id = ?`, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Message{}, ErrNotFound
 	}
-	_, err = s.db.ExecContext(ctx, `
-UPDATE runs
-SET status = ?, stop_reason = ?, error_text = ?, tool_call_count = ?, turn_count = ?, usage_json = ?, finished_at = ?
-WHERE id = ?`, status, stopReason, errorText, toolCallCount, turnCount, string(usageBytes), finishedAt, runID)
 	if err != nil {
-		return fmt.Errorf("complete run: %w", err)
+		return Message{}, fmt.Errorf("get message: %w", err)
 	}
-	return nil
+	return msg, nil
 }
 
-func (s *Store) UpsertToolCallStart(ctx context.Context, call ToolCall) error {
-	_, err := s.db.ExecContext(ctx, `
-INSERT INTO tool_calls (id, run_id, tool_call_id, name, status, input_json, started_at)
-VALUES (?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-status = excluded.status,
-input_json = excluded.input_json,
-name = excluded.name,
-tool_call_id = excluded.tool_call_id`,
-		call.ID, call.RunID, call.ToolCallID, call.Name, call.Status, call.InputJSON, call.StartedAt)
-	if err != nil {
-		return fmt.Errorf("upsert tool call start: %w", err)
+// SearchMessages returns the messages in a chat whose content, or the URL or
+// title of a source they cite, contains query, case-insensitively, in
+// conversation order.
+func (s *Store) SearchMessages(ctx context.Context, chatID, query string, limit int) ([]Message, error) {
+	if limit < 1 {
+		limit = 200
 	}
-	return nil
-}
-
-func (s *Store) CompleteToolCall(ctx context.Context, callID, status, outputJSON, errorText string, finishedAt time.Time) error {
-	_, err := s.db.ExecContext(ctx, `
-UPDATE tool_calls
-SET status = ?, output_json = ?, error_text = ?, finished_at = ?
-WHERE id = ?`, status, outputJSON, errorText, finishedAt, callID)
+	pattern := "%" + escapeLike(query) + "%"
+	if s.codec != nil {
+		return s.searchSealedMessages(ctx, chatID, query, pattern, limit)
+	}
+	rows, err := s.read.QueryContext(ctx, `
+SELECT `+messageColumns+`
+FROM messages
+WHERE chat_id = ? AND (content LIKE ? ESCAPE '\' OR id IN (
+  SELECT message_id FROM message_sources WHERE url LIKE ? ESCAPE '\' OR title LIKE ? ESCAPE '\'))
+ORDER BY created_at ASC, rowid ASC
+LIMIT ?`, chatID, pattern, pattern, pattern, limit)
 	if err != nil {
-		return fmt.Errorf("complete tool call: %w", err)
+		return nil, fmt.Errorf("search messages: %w", err)
 	}
-	return nil
-}
+	defer rows.Close()
 
-func (s *Store) TouchChat(ctx context.Context, chatID string, at time.Time) error {
-	_, err := s.db.ExecContext(ctx, `
-UPDATE chats
-SET updated_at = ?
-WHERE id = ?`, at, chatID)
-	if err != nil {
-		return fmt.Errorf("touch chat: %w", err)
+	messages := make([]Message, 0)
+	for rows.Next() {
+		msg, err := s.scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
 	}
-	return nil
+	return messages, rows.Err()
 }
 
-func (s *Store) Transaction(ctx context.Context, fn func(*sql.Tx) error) error {
-	tx, err := s.db.BeginTx(ctx, nil)
+// searchSealedMessages is SearchMessages for a Store that seals content,
+// which SQL cannot match: the chat's messages are opened and matched here.
+func (s *Store) searchSealedMessages(ctx context.Context, chatID, query, pattern string, limit int) ([]Message, error) {
+	cited := map[string]bool{}
+	rows, err := s.read.QueryContext(ctx, `
+SELECT DISTINCT message_id
+FROM message_sources
+WHERE message_id IN (SELECT id FROM messages WHERE chat_id = ?) AND (url LIKE ? ESCAPE '\' OR title LIKE ? ESCAPE '\')`, chatID, pattern, pattern)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return nil, fmt.Errorf("search messages: %w", err)
 	}
-	if err := fn(tx); err != nil {
-		_ = tx.Rollback()
-		return err
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan message source: %w", err)
+		}
+		cited[id] = true
 	}
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
 	}
-	return nil
-}
 
-func InsertMessageTx(ctx context.Context, tx *sql.Tx, message Message) error {
-	_, err := tx.ExecContext(ctx, `
-INSERT INTO messages (id, chat_id, role, content, status, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?, ?, ?)`, message.ID, message.ChatID, message.Role, message.Content, message.Status, message.CreatedAt, message.UpdatedAt)
+	rows, err = s.read.QueryContext(ctx, `
+SELECT `+messageColumns+`
+FROM messages
+WHERE chat_id = ?
+ORDER BY created_at ASC, rowid ASC`, chatID)
 	if err != nil {
-		return fmt.Errorf("insert message tx: %w", err)
+		return nil, fmt.Errorf("search messages: %w", err)
 	}
-	return nil
-}
+	defer rows.Close()
 
-func UpsertRunStartTx(ctx context.Context, tx *sql.Tx, run Run) error {
+	needle := strings.ToLower(query)
+	messages := make([]Message, 0)
+	for rows.Next() && len(messages) < limit {
+		msg, err := s.scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if cited[msg.ID] || strings.Contains(strings.ToLower(msg.Content), needle) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, rows.Err()
+}
+
+// ListActivity returns the completed user and assistant messages created in
+// [from, to), oldest first. An empty chatIDs covers every chat; excludeChatID
+// is skipped either way.
+func (s *Store) ListActivity(ctx context.Context, chatIDs []string, excludeChatID string, from, to time.Time, limit int) ([]Message, error) {
+	if limit < 1 {
+		limit = 2000
+	}
+	query := `
+SELECT ` + messageColumns + `
+FROM messages
+WHERE created_at >= ? AND created_at < ? AND chat_id != ?
+  AND role IN ('user', 'assistant') AND content != '' AND status IN ('complete', 'completed')`
+	args := []any{from.UTC(), to.UTC(), excludeChatID}
+	if len(chatIDs) > 0 {
+		query += ` AND chat_id IN (?` + strings.Repeat(`, ?`, len(chatIDs)-1) + `)`
+		for _, chatID := range chatIDs {
+			args = append(args, chatID)
+		}
+	}
+	query += `
+ORDER BY chat_id ASC, created_at ASC, rowid ASC
+LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list activity: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]Message, 0)
+	for rows.Next() {
+		msg, err := s.scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// ChatToolSettings returns the explicit per-chat tool toggles. Tools without
+// a row use the default (enabled).
+func (s *Store) ChatToolSettings(ctx context.Context, chatID string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT tool_name, enabled
+FROM chat_tools
+WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list chat tools: %w", err)
+	}
+	defer rows.Close()
+
+	settings := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, fmt.Errorf("scan chat tool: %w", err)
+		}
+		settings[name] = enabled
+	}
+	return settings, rows.Err()
+}
+
+// ToolUsage is how often a tool was called in a window, and when it was
+// last called at all.
+type ToolUsage struct {
+	Name       string
+	Calls      int
+	Errors     int
+	LastUsedAt time.Time
+}
+
+// ToolUsageSince returns usage for every tool that has ever been called,
+// keyed by tool name, with Calls and Errors counted from since.
+func (s *Store) ToolUsageSince(ctx context.Context, since time.Time) (map[string]ToolUsage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT t.name, t.started_at,
+  (SELECT COUNT(*) FROM tool_calls c WHERE c.name = t.name AND c.started_at >= ?),
+  (SELECT COUNT(*) FROM tool_calls c WHERE c.name = t.name AND c.started_at >= ? AND c.status = 'error')
+FROM tool_calls t
+WHERE t.id = (SELECT l.id FROM tool_calls l WHERE l.name = t.name ORDER BY l.started_at DESC, l.id DESC LIMIT 1)`, since, since)
+	if err != nil {
+		return nil, fmt.Errorf("tool usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := map[string]ToolUsage{}
+	for rows.Next() {
+		var tool ToolUsage
+		if err := rows.Scan(&tool.Name, &tool.LastUsedAt, &tool.Calls, &tool.Errors); err != nil {
+			return nil, fmt.Errorf("scan tool usage: %w", err)
+		}
+		usage[tool.Name] = tool
+	}
+	return usage, rows.Err()
+}
+
+// CountDisabledChatTools returns, per tool name, how many chats switched the
+// tool off.
+func (s *Store) CountDisabledChatTools(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT tool_name, COUNT(*)
+FROM chat_tools
+WHERE enabled = 0
+GROUP BY tool_name`)
+	if err != nil {
+		return nil, fmt.Errorf("count disabled chat tools: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, fmt.Errorf("scan disabled chat tool: %w", err)
+		}
+		counts[name] = count
+	}
+	return counts, rows.Err()
+}
+
+func (s *Store) SetChatTool(ctx context.Context, chatID, toolName string, enabled bool, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO chat_tools (chat_id, tool_name, enabled, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(chat_id, tool_name) DO UPDATE SET enabled = excluded.enabled, updated_at = excluded.updated_at`, chatID, toolName, enabled, now)
+	if err != nil {
+		return fmt.Errorf("set chat tool: %w", err)
+	}
+	return nil
+}
+
+// GetSetting returns the stored value for key, or ErrNotFound.
+func (s *Store) GetSetting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM app_settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (s *Store) SetSetting(ctx context.Context, key, value string, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO app_settings (key, value, updated_at)
+VALUES (?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, key, value, now)
+	if err != nil {
+		return fmt.Errorf("set setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// GetUserSetting returns a user's stored value for key, or ErrNotFound.
+func (s *Store) GetUserSetting(ctx context.Context, userID, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM user_settings WHERE user_id = ? AND key = ?`, userID, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get user setting %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// SetUserSetting stores a user's value for key. An empty value removes it.
+func (s *Store) SetUserSetting(ctx context.Context, userID, key, value string, now time.Time) error {
+	var err error
+	if value == "" {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM user_settings WHERE user_id = ? AND key = ?`, userID, key)
+	} else {
+		_, err = s.db.ExecContext(ctx, `
+INSERT INTO user_settings (user_id, key, value, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, userID, key, value, now)
+	}
+	if err != nil {
+		return fmt.Errorf("set user setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// ListUserSettings returns every value a user has stored, by key.
+func (s *Store) ListUserSettings(ctx context.Context, userID string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM user_settings WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list user settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("scan user setting: %w", err)
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
+
+// ChatSummary is the running summary of a chat's messages up to and
+// including ThroughMessageID, used once they fall out of the history window.
+type ChatSummary struct {
+	ChatID           string
+	ThroughMessageID string
+	Content          string
+	Model            string
+	UpdatedAt        time.Time
+}
+
+// GetChatSummary returns the chat's summary, or ErrNotFound.
+func (s *Store) GetChatSummary(ctx context.Context, chatID string) (ChatSummary, error) {
+	summary := ChatSummary{ChatID: chatID}
+	err := s.db.QueryRowContext(ctx, `
+SELECT through_message_id, content, model, updated_at
+FROM chat_summaries
+WHERE chat_id = ?`, chatID).Scan(&summary.ThroughMessageID, &summary.Content, &summary.Model, &summary.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChatSummary{}, ErrNotFound
+	}
+	if err != nil {
+		return ChatSummary{}, fmt.Errorf("get chat summary: %w", err)
+	}
+	if err := s.openAll(&summary.Content); err != nil {
+		return ChatSummary{}, fmt.Errorf("open chat summary: %w", err)
+	}
+	return summary, nil
+}
+
+func (s *Store) SaveChatSummary(ctx context.Context, summary ChatSummary) error {
+	content, err := s.seal(summary.Content)
+	if err != nil {
+		return fmt.Errorf("save chat summary: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO chat_summaries (chat_id, through_message_id, content, model, updated_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(chat_id) DO UPDATE SET
+  through_message_id = excluded.through_message_id,
+  content = excluded.content,
+  model = excluded.model,
+  updated_at = excluded.updated_at`, summary.ChatID, summary.ThroughMessageID, content, summary.Model, summary.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("save chat summary: %w", err)
+	}
+	return nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func (s *Store) InsertMessage(ctx context.Context, message Message) error {
+	content, err := s.seal(message.Content)
+	if err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO messages (id, chat_id, role, content, status, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)`, message.ID, message.ChatID, message.Role, content, message.Status, message.CreatedAt, message.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) UpdateMessageContent(ctx context.Context, messageID, content, status string, now time.Time) error {
+	content, err := s.seal(content)
+	if err != nil {
+		return fmt.Errorf("update message content: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+UPDATE messages
+SET content = ?, status = ?, updated_at = ?
+WHERE id = ?`, content, status, now, messageID)
+	if err != nil {
+		return fmt.Errorf("update message content: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) UpdateMessageReasoning(ctx context.Context, messageID, reasoning string, now time.Time) error {
+	reasoning, err := s.seal(reasoning)
+	if err != nil {
+		return fmt.Errorf("update message reasoning: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+UPDATE messages
+SET reasoning = ?, updated_at = ?
+WHERE id = ?`, reasoning, now, messageID)
+	if err != nil {
+		return fmt.Errorf("update message reasoning: %w", err)
+	}
+	return nil
+}
+
+// PartialMessage is the output a streaming assistant message has so far.
+// A null Content or Reasoning leaves that column as it is.
+type PartialMessage struct {
+	ID        string
+	Content   sql.NullString
+	Reasoning sql.NullString
+}
+
+// SavePartialMessages writes the output of streaming messages in one
+// transaction. Messages that are no longer streaming are left alone, so a
+// late write cannot undo a reply's final content.
+func (s *Store) SavePartialMessages(ctx context.Context, partials []PartialMessage, now time.Time) error {
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, `
+UPDATE messages
+SET content = COALESCE(?, content), reasoning = COALESCE(?, reasoning), updated_at = ?
+WHERE id = ? AND status = 'streaming'`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, partial := range partials {
+			if partial.Content.String, err = s.seal(partial.Content.String); err != nil {
+				return err
+			}
+			if partial.Reasoning.String, err = s.seal(partial.Reasoning.String); err != nil {
+				return err
+			}
+			if _, err := stmt.ExecContext(ctx, partial.Content, partial.Reasoning, now, partial.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save partial messages: %w", err)
+	}
+	return nil
+}
+
+// SetMessageStructured stores the parsed reply of an assistant message sent
+// with an output schema.
+func (s *Store) SetMessageStructured(ctx context.Context, messageID, structured string, now time.Time) error {
+	structured, err := s.seal(structured)
+	if err != nil {
+		return fmt.Errorf("set message structured output: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+UPDATE messages
+SET structured_json = ?, updated_at = ?
+WHERE id = ?`, structured, now, messageID)
+	if err != nil {
+		return fmt.Errorf("set message structured output: %w", err)
+	}
+	return nil
+}
+
+// MessageStructured returns the parsed reply stored with
+// SetMessageStructured, or "" when the message has none.
+func (s *Store) MessageStructured(ctx context.Context, messageID string) (string, error) {
+	var structured string
+	err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(structured_json, '')
+FROM messages
+WHERE id = ?`, messageID).Scan(&structured)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get message structured output: %w", err)
+	}
+	return s.open(structured)
+}
+
+func (s *Store) SetMessageFlag(ctx context.Context, messageID, flag string, now time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE messages
+SET flag = ?, updated_at = ?
+WHERE id = ?`, sql.NullString{String: flag, Valid: flag != ""}, now, messageID)
+	if err != nil {
+		return fmt.Errorf("set message flag: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) UpsertRunStart(ctx context.Context, run Run) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO runs (id, chat_id, user_message_id, assistant_message_id, model, status, started_at, tool_call_count, turn_count, comparison_id, moderation_json, idempotency_key)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+ON CONFLICT(id) DO UPDATE SET
+status = excluded.status,
+model = excluded.model,
+chat_id = excluded.chat_id,
+user_message_id = excluded.user_message_id,
+assistant_message_id = excluded.assistant_message_id,
+started_at = excluded.started_at,
+comparison_id = excluded.comparison_id,
+moderation_json = excluded.moderation_json`,
+		run.ID, run.ChatID, run.UserMessageID, run.AssistantMessageID, run.Model, run.Status, run.StartedAt, run.ToolCallCount, run.TurnCount, run.ComparisonID, run.ModerationJSON, run.IdempotencyKey)
+	if err != nil {
+		return fmt.Errorf("upsert run start: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) CompleteRun(ctx context.Context, runID, status, stopReason, errorText string, toolCallCount, turnCount int, usage any, costUSD sql.NullFloat64, latency RunLatency, finishedAt time.Time) error {
+	usageBytes, err := json.Marshal(usage)
+	if err != nil {
+		usageBytes = []byte("{}")
+	}
+	var tokens struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	}
+	_ = json.Unmarshal(usageBytes, &tokens)
+	errorText, err = s.seal(errorText)
+	if err != nil {
+		return fmt.Errorf("complete run: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+UPDATE runs
+SET status = ?, stop_reason = ?, error_text = ?, tool_call_count = ?, turn_count = ?, usage_json = ?, input_tokens = ?, output_tokens = ?, cost_usd = ?,
+    first_token_ms = NULLIF(?, 0), stream_ms = NULLIF(?, 0), tool_latency_json = NULLIF(?, ''), finished_at = ?
+WHERE id = ?`, status, stopReason, errorText, toolCallCount, turnCount, string(usageBytes), tokens.InputTokens, tokens.OutputTokens, costUSD,
+		latency.FirstToken.Milliseconds(), latency.Stream.Milliseconds(), latency.ToolsJSON, finishedAt, runID)
+	if err != nil {
+		return fmt.Errorf("complete run: %w", err)
+	}
+	return nil
+}
+
+// OrphanReport counts what ReconcileOrphans changed.
+type OrphanReport struct {
+	// RunsReconciled finished runs whose assistant message was already
+	// saved with a final status, i.e. the process died between the two
+	// writes.
+	RunsReconciled       int
+	RunsInterrupted      int
+	MessagesInterrupted  int
+	ToolCallsInterrupted int
+	// PartialsCleared counts interrupted messages whose partial content
+	// was dropped because keepPartial was false.
+	PartialsCleared int
+}
+
+func (r OrphanReport) Changed() bool {
+	return r != OrphanReport{}
+}
+
+// ReconcileOrphans settles runs, assistant messages and tool calls left in
+// a non-terminal status by a previous process. Runs whose assistant message
+// already has a final status take that status; everything else still in
+// flight is marked "interrupted" with errorText. Interrupted messages keep
+// the content of their last flush unless keepPartial is false. It is meant
+// for startup, before any run can be in flight.
+func (s *Store) ReconcileOrphans(ctx context.Context, errorText string, keepPartial bool, now time.Time) (OrphanReport, error) {
+	var report OrphanReport
+	errorText, err := s.seal(errorText)
+	if err != nil {
+		return OrphanReport{}, fmt.Errorf("reconcile orphans: %w", err)
+	}
+	exec := func(tx *sql.Tx, count *int, what, query string, args ...any) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		*count = int(affected)
+		return nil
+	}
+	err = s.Transaction(ctx, func(tx *sql.Tx) error {
+		if err := exec(tx, &report.RunsReconciled, "reconcile finished runs", `
+UPDATE runs
+SET status = (SELECT m.status FROM messages m WHERE m.id = runs.assistant_message_id),
+  finished_at = (SELECT m.updated_at FROM messages m WHERE m.id = runs.assistant_message_id)
+WHERE status = 'running' AND EXISTS (
+  SELECT 1 FROM messages m
+  WHERE m.id = runs.assistant_message_id AND m.status IN ('completed', 'error', 'cancelled', 'timed_out')
+)`); err != nil {
+			return err
+		}
+		if err := exec(tx, &report.RunsInterrupted, "interrupt runs", `
+UPDATE runs
+SET status = 'interrupted', error_text = ?, finished_at = ?
+WHERE status = 'running'`, errorText, now); err != nil {
+			return err
+		}
+		if err := exec(tx, &report.ToolCallsInterrupted, "interrupt tool calls", `
+UPDATE tool_calls
+SET status = 'interrupted', error_text = ?, finished_at = ?
+WHERE status = 'running'`, errorText, now); err != nil {
+			return err
+		}
+		if !keepPartial {
+			if err := exec(tx, &report.PartialsCleared, "clear partial messages", `
+UPDATE messages
+SET content = '', reasoning = NULL
+WHERE status = 'streaming' AND (content != '' OR COALESCE(reasoning, '') != '')`); err != nil {
+				return err
+			}
+		}
+		return exec(tx, &report.MessagesInterrupted, "interrupt messages", `
+UPDATE messages
+SET status = 'interrupted', updated_at = ?
+WHERE status = 'streaming'`, now)
+	})
+	if err != nil {
+		return OrphanReport{}, err
+	}
+	return report, nil
+}
+
+// CancelStaleRun marks a run that is still recorded as running, but that
+// nothing is executing, as cancelled along with its streaming assistant
+// message and running tool calls. It reports whether the run was running.
+func (s *Store) CancelStaleRun(ctx context.Context, runID string, now time.Time) (bool, error) {
+	cancelled := false
+	err := s.Transaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+UPDATE runs
+SET status = 'cancelled', finished_at = ?
+WHERE id = ? AND status = 'running'`, now, runID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+		cancelled = true
+		if _, err := tx.ExecContext(ctx, `
+UPDATE messages
+SET status = 'cancelled', updated_at = ?
+WHERE status = 'streaming' AND id = (SELECT assistant_message_id FROM runs WHERE id = ?)`, now, runID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE tool_calls
+SET status = 'cancelled', finished_at = ?
+WHERE run_id = ? AND status = 'running'`, now, runID)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("cancel stale run: %w", err)
+	}
+	return cancelled, nil
+}
+
+func (s *Store) SaveRunSnapshot(ctx context.Context, snapshot RunSnapshot) error {
+	data, err := s.sealBytes(snapshot.Data)
+	if err != nil {
+		return fmt.Errorf("save run snapshot: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO run_snapshots (run_id, sha256, encoding, data, size_bytes, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(run_id) DO UPDATE SET
+sha256 = excluded.sha256,
+encoding = excluded.encoding,
+data = excluded.data,
+size_bytes = excluded.size_bytes,
+created_at = excluded.created_at`,
+		snapshot.RunID, snapshot.SHA256, snapshot.Encoding, data, snapshot.SizeBytes, snapshot.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("save run snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) GetRunSnapshot(ctx context.Context, runID string) (RunSnapshot, error) {
+	var snapshot RunSnapshot
+	err := s.db.QueryRowContext(ctx, `
+SELECT run_id, sha256, encoding, data, size_bytes, created_at
+FROM run_snapshots
+WHERE run_id = ?`, runID).Scan(&snapshot.RunID, &snapshot.SHA256, &snapshot.Encoding, &snapshot.Data, &snapshot.SizeBytes, &snapshot.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RunSnapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return RunSnapshot{}, fmt.Errorf("get run snapshot: %w", err)
+	}
+	if snapshot.Data, err = s.openBytes(snapshot.Data); err != nil {
+		return RunSnapshot{}, fmt.Errorf("open run snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+const runColumns = `id, chat_id, user_message_id, assistant_message_id, model, status, COALESCE(stop_reason, ''), COALESCE(error_text, ''), tool_call_count, turn_count, COALESCE(usage_json, ''), input_tokens, output_tokens, cost_usd, COALESCE(request_json, ''), COALESCE(comparison_id, ''), COALESCE(moderation_json, ''), COALESCE(first_token_ms, 0), COALESCE(stream_ms, 0), COALESCE(tool_latency_json, ''), COALESCE(idempotency_key, ''), started_at, finished_at`
+
+func (s *Store) scanRun(row rowScanner) (Run, error) {
+	var run Run
+	var firstTokenMS, streamMS int64
+	if err := row.Scan(&run.ID, &run.ChatID, &run.UserMessageID, &run.AssistantMessageID, &run.Model, &run.Status, &run.StopReason, &run.ErrorText, &run.ToolCallCount, &run.TurnCount, &run.UsageJSON, &run.InputTokens, &run.OutputTokens, &run.CostUSD, &run.RequestJSON, &run.ComparisonID, &run.ModerationJSON, &firstTokenMS, &streamMS, &run.Latency.ToolsJSON, &run.IdempotencyKey, &run.StartedAt, &run.FinishedAt); err != nil {
+		return Run{}, fmt.Errorf("scan run: %w", err)
+	}
+	run.Latency.FirstToken = time.Duration(firstTokenMS) * time.Millisecond
+	run.Latency.Stream = time.Duration(streamMS) * time.Millisecond
+	if err := s.openAll(&run.ErrorText, &run.RequestJSON); err != nil {
+		return Run{}, fmt.Errorf("open run %s: %w", run.ID, err)
+	}
+	return run, nil
+}
+
+func (s *Store) GetRun(ctx context.Context, runID string) (Run, error) {
+	run, err := s.scanRun(s.read.QueryRowContext(ctx, `
+SELECT `+runColumns+`
+FROM runs
+WHERE id = ?`, runID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Run{}, ErrNotFound
+	}
+	if err != nil {
+		return Run{}, fmt.Errorf("get run: %w", err)
+	}
+	return run, nil
+}
+
+// GetRunByIdempotencyKey returns the run of a chat sent with key.
+func (s *Store) GetRunByIdempotencyKey(ctx context.Context, chatID, key string) (Run, error) {
+	run, err := s.scanRun(s.db.QueryRowContext(ctx, `
+SELECT `+runColumns+`
+FROM runs
+WHERE chat_id = ? AND idempotency_key = ?`, chatID, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Run{}, ErrNotFound
+	}
+	if err != nil {
+		return Run{}, fmt.Errorf("get run by idempotency key: %w", err)
+	}
+	return run, nil
+}
+
+// GetRunByAssistantMessage returns the run that produced an assistant
+// message. Retried messages keep the most recent run.
+func (s *Store) GetRunByAssistantMessage(ctx context.Context, messageID string) (Run, error) {
+	run, err := s.scanRun(s.db.QueryRowContext(ctx, `
+SELECT `+runColumns+`
+FROM runs
+WHERE assistant_message_id = ?
+ORDER BY started_at DESC, id DESC
+LIMIT 1`, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Run{}, ErrNotFound
+	}
+	if err != nil {
+		return Run{}, fmt.Errorf("get run: %w", err)
+	}
+	return run, nil
+}
+
+// ListComparisonRuns returns the runs of a chat's side-by-side
+// comparisons, oldest first.
+func (s *Store) ListComparisonRuns(ctx context.Context, chatID string) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+runColumns+`
+FROM runs
+WHERE chat_id = ? AND comparison_id IS NOT NULL
+ORDER BY started_at ASC, id ASC`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list comparison runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		run, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// ListRunsForChat returns a chat's runs, oldest first.
+func (s *Store) ListRunsForChat(ctx context.Context, chatID string) ([]Run, error) {
+	rows, err := s.read.QueryContext(ctx, `
+SELECT `+runColumns+`
+FROM runs
+WHERE chat_id = ?
+ORDER BY started_at ASC, id ASC`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		run, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// CountOwnerRunsSince counts the runs started at or after since in chats
+// owned by owner ("" for unowned chats) and returns the oldest of them.
+func (s *Store) CountOwnerRunsSince(ctx context.Context, owner string, since time.Time) (int, time.Time, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*)
+FROM runs r
+JOIN chats c ON c.id = r.chat_id
+WHERE COALESCE(c.owner_id, '') = ? AND r.started_at >= ?`, owner, since).Scan(&count)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("count runs: %w", err)
+	}
+	if count == 0 {
+		return 0, time.Time{}, nil
+	}
+	var oldest time.Time
+	err = s.db.QueryRowContext(ctx, `
+SELECT r.started_at
+FROM runs r
+JOIN chats c ON c.id = r.chat_id
+WHERE COALESCE(c.owner_id, '') = ? AND r.started_at >= ?
+ORDER BY r.started_at ASC
+LIMIT 1`, owner, since).Scan(&oldest)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("oldest run: %w", err)
+	}
+	return count, oldest, nil
+}
+
+func (s *Store) InsertReplayRun(ctx context.Context, replay ReplayRun) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO replay_runs (id, source_run_id, model, status, started_at)
+VALUES (?, ?, ?, ?, ?)`, replay.ID, replay.SourceRunID, replay.Model, replay.Status, replay.StartedAt)
+	if err != nil {
+		return fmt.Errorf("insert replay run: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) CompleteReplayRun(ctx context.Context, replay ReplayRun) error {
+	sealed := []*string{&replay.Output, &replay.Reasoning, &replay.ToolCallsJSON, &replay.ErrorText}
+	for _, value := range sealed {
+		var err error
+		if *value, err = s.seal(*value); err != nil {
+			return fmt.Errorf("complete replay run: %w", err)
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `
+UPDATE replay_runs
+SET status = ?, output = ?, reasoning = ?, tool_calls_json = ?, stop_reason = ?, error_text = ?, usage_json = ?, finished_at = ?
+WHERE id = ?`, replay.Status, replay.Output, replay.Reasoning, replay.ToolCallsJSON, replay.StopReason, replay.ErrorText, replay.UsageJSON, replay.FinishedAt, replay.ID)
+	if err != nil {
+		return fmt.Errorf("complete replay run: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ListReplayRuns(ctx context.Context, sourceRunID string) ([]ReplayRun, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, source_run_id, model, status, COALESCE(output, ''), COALESCE(reasoning, ''), COALESCE(tool_calls_json, ''), COALESCE(stop_reason, ''), COALESCE(error_text, ''), COALESCE(usage_json, ''), started_at, finished_at
+FROM replay_runs
+WHERE source_run_id = ?
+ORDER BY started_at ASC, id ASC`, sourceRunID)
+	if err != nil {
+		return nil, fmt.Errorf("list replay runs: %w", err)
+	}
+	defer rows.Close()
+
+	var replays []ReplayRun
+	for rows.Next() {
+		var replay ReplayRun
+		if err := rows.Scan(&replay.ID, &replay.SourceRunID, &replay.Model, &replay.Status, &replay.Output, &replay.Reasoning, &replay.ToolCallsJSON, &replay.StopReason, &replay.ErrorText, &replay.UsageJSON, &replay.StartedAt, &replay.FinishedAt); err != nil {
+			return nil, fmt.Errorf("scan replay run: %w", err)
+		}
+		if err := s.openAll(&replay.Output, &replay.Reasoning, &replay.ToolCallsJSON, &replay.ErrorText); err != nil {
+			return nil, fmt.Errorf("open replay run %s: %w", replay.ID, err)
+		}
+		replays = append(replays, replay)
+	}
+	return replays, rows.Err()
+}
+
+func (s *Store) UpsertToolCallStart(ctx context.Context, call ToolCall) error {
+	inputJSON, err := s.seal(call.InputJSON)
+	if err != nil {
+		return fmt.Errorf("upsert tool call start: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO tool_calls (id, run_id, tool_call_id, name, status, input_json, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+status = excluded.status,
+input_json = excluded.input_json,
+name = excluded.name,
+tool_call_id = excluded.tool_call_id`,
+		call.ID, call.RunID, call.ToolCallID, call.Name, call.Status, inputJSON, call.StartedAt)
+	if err != nil {
+		return fmt.Errorf("upsert tool call start: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) CompleteToolCall(ctx context.Context, callID, status, outputJSON, errorText string, finishedAt time.Time) error {
+	outputJSON, err := s.seal(outputJSON)
+	if err != nil {
+		return fmt.Errorf("complete tool call: %w", err)
+	}
+	errorText, err = s.seal(errorText)
+	if err != nil {
+		return fmt.Errorf("complete tool call: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+UPDATE tool_calls
+SET status = ?, output_json = ?, error_text = ?, finished_at = ?
+WHERE id = ?`, status, outputJSON, errorText, finishedAt, callID)
+	if err != nil {
+		return fmt.Errorf("complete tool call: %w", err)
+	}
+	return nil
+}
+
+// GetToolCall returns a tool call as stored, with the ID of the chat its
+// run belongs to.
+func (s *Store) GetToolCall(ctx context.Context, callID string) (ToolCall, string, error) {
+	var call ToolCall
+	var chatID string
+	err := s.db.QueryRowContext(ctx, `
+SELECT t.id, t.run_id, COALESCE(t.tool_call_id, ''), t.name, t.status, COALESCE(t.input_json, ''), COALESCE(t.output_json, ''), COALESCE(t.error_text, ''), t.started_at, t.finished_at, r.chat_id
+FROM tool_calls t
+JOIN runs r ON r.id = t.run_id
+WHERE t.id = ?`, callID).Scan(&call.ID, &call.RunID, &call.ToolCallID, &call.Name, &call.Status, &call.InputJSON, &call.OutputJSON, &call.ErrorText, &call.StartedAt, &call.FinishedAt, &chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ToolCall{}, "", ErrNotFound
+	}
+	if err != nil {
+		return ToolCall{}, "", fmt.Errorf("get tool call: %w", err)
+	}
+	if err := s.openAll(&call.InputJSON, &call.OutputJSON, &call.ErrorText); err != nil {
+		return ToolCall{}, "", fmt.Errorf("open tool call %s: %w", call.ID, err)
+	}
+	return call, chatID, nil
+}
+
+// PurgeToolCallPayloads clears the input and output of tool calls that
+// finished before cutoff, outside chats kept forever, and returns how many
+// it cleared. The calls themselves stay, so run histories still show which
+// tools ran.
+func (s *Store) PurgeToolCallPayloads(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+UPDATE tool_calls
+SET input_json = NULL, output_json = NULL
+WHERE finished_at < ?
+  AND (input_json IS NOT NULL OR output_json IS NOT NULL)
+  AND run_id IN (
+    SELECT r.id FROM runs r JOIN chats c ON c.id = r.chat_id WHERE c.keep_forever = 0
+  )`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge tool call payloads: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ListChatToolCalls returns the tool calls of a chat's runs, oldest first.
+func (s *Store) ListChatToolCalls(ctx context.Context, chatID string) ([]ToolCall, error) {
+	rows, err := s.read.QueryContext(ctx, `
+SELECT t.id, t.run_id, COALESCE(t.tool_call_id, ''), t.name, t.status, COALESCE(t.input_json, ''), COALESCE(t.output_json, ''), COALESCE(t.error_text, ''), t.started_at, t.finished_at
+FROM tool_calls t
+JOIN runs r ON r.id = t.run_id
+WHERE r.chat_id = ?
+ORDER BY t.started_at ASC, t.id ASC`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("list tool calls: %w", err)
+	}
+	defer rows.Close()
+
+	var calls []ToolCall
+	for rows.Next() {
+		var call ToolCall
+		if err := rows.Scan(&call.ID, &call.RunID, &call.ToolCallID, &call.Name, &call.Status, &call.InputJSON, &call.OutputJSON, &call.ErrorText, &call.StartedAt, &call.FinishedAt); err != nil {
+			return nil, fmt.Errorf("scan tool call: %w", err)
+		}
+		if err := s.openAll(&call.InputJSON, &call.OutputJSON, &call.ErrorText); err != nil {
+			return nil, fmt.Errorf("open tool call %s: %w", call.ID, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+func (s *Store) TouchChat(ctx context.Context, chatID string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE chats
+SET updated_at = ?
+WHERE id = ?`, at, chatID)
+	if err != nil {
+		return fmt.Errorf("touch chat: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// InsertMessageTx inserts message in tx. It is a Store method, unlike the
+// other Tx helpers, because the content is sealed with the Store's codec.
+func (s *Store) InsertMessageTx(ctx context.Context, tx *sql.Tx, message Message) error {
+	content, err := s.seal(message.Content)
+	if err != nil {
+		return fmt.Errorf("insert message tx: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO messages (id, chat_id, role, content, status, flag, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)`, message.ID, message.ChatID, message.Role, content, message.Status, message.Flag, message.CreatedAt, message.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert message tx: %w", err)
+	}
+	return nil
+}
+
+func UpsertRunStartTx(ctx context.Context, tx *sql.Tx, run Run) error {
 	_, err := tx.ExecContext(ctx, `
-INSERT INTO runs (id, chat_id, user_message_id, assistant_message_id, model, status, started_at, tool_call_count, turn_count)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+INSERT INTO runs (id, chat_id, user_message_id, assistant_message_id, model, status, started_at, tool_call_count, turn_count, comparison_id, moderation_json, idempotency_key)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
 ON CONFLICT(id) DO UPDATE SET
 status = excluded.status,
 model = excluded.model,
 chat_id = excluded.chat_id,
 user_message_id = excluded.user_message_id,
 assistant_message_id = excluded.assistant_message_id,
-started_at = excluded.started_at`,
-		run.ID, run.ChatID, run.UserMessageID, run.AssistantMessageID, run.Model, run.Status, run.StartedAt, run.ToolCallCount, run.TurnCount)
+started_at = excluded.started_at,
+comparison_id = excluded.comparison_id,
+moderation_json = excluded.moderation_json`,
+		run.ID, run.ChatID, run.UserMessageID, run.AssistantMessageID, run.Model, run.Status, run.StartedAt, run.ToolCallCount, run.TurnCount, run.ComparisonID, run.ModerationJSON, run.IdempotencyKey)
 	if err != nil {
 		return fmt.Errorf("upsert run start tx: %w", err)
 	}
 	return nil
 }
 
+// RunIDByIdempotencyKeyTx returns the ID of the run of a chat sent with
+// key, or ErrNotFound.
+func RunIDByIdempotencyKeyTx(ctx context.Context, tx *sql.Tx, chatID, key string) (string, error) {
+	var runID string
+	err := tx.QueryRowContext(ctx, `
+SELECT id FROM runs WHERE chat_id = ? AND idempotency_key = ?`, chatID, key).Scan(&runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("run by idempotency key tx: %w", err)
+	}
+	return runID, nil
+}
+
 func TouchChatTx(ctx context.Context, tx *sql.Tx, chatID string, at time.Time) error {
 	_, err := tx.ExecContext(ctx, `
 UPDATE chats SET updated_at = ? WHERE id = ?`, at, chatID)
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Usage rollup periods. Days and months are UTC calendar periods.
+const (
+	UsagePeriodDay   = "day"
+	UsagePeriodMonth = "month"
+)
+
+// UsageTotals is what finished runs used over one rollup period.
+type UsageTotals struct {
+	Runs         int
+	InputTokens  int
+	OutputTokens int
+	CostUSD      float64
+}
+
+func (u UsageTotals) Tokens() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// usagePeriodStart keys the period containing at, matching the date prefix
+// SQLite stores times with.
+func usagePeriodStart(period string, at time.Time) string {
+	if period == UsagePeriodMonth {
+		return at.UTC().Format("2006-01")
+	}
+	return at.UTC().Format("2006-01-02")
+}
+
+// AddRunUsage adds a run that finished at finishedAt to the day and month
+// rollups of its chat's owner. Runs whose cost is unknown add no cost.
+func (s *Store) AddRunUsage(ctx context.Context, chatID string, finishedAt time.Time, inputTokens, outputTokens int, costUSD float64) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO usage_rollups (owner_id, period, period_start, runs, input_tokens, output_tokens, cost_usd)
+SELECT COALESCE(c.owner_id, ''), period.name, period.start, 1, ?, ?, ?
+FROM chats c, (SELECT ? AS name, ? AS start UNION ALL SELECT ?, ?) period
+WHERE c.id = ?
+ON CONFLICT (owner_id, period, period_start) DO UPDATE SET
+runs = runs + 1,
+input_tokens = input_tokens + excluded.input_tokens,
+output_tokens = output_tokens + excluded.output_tokens,
+cost_usd = cost_usd + excluded.cost_usd`,
+		inputTokens, outputTokens, costUSD,
+		UsagePeriodDay, usagePeriodStart(UsagePeriodDay, finishedAt),
+		UsagePeriodMonth, usagePeriodStart(UsagePeriodMonth, finishedAt),
+		chatID)
+	if err != nil {
+		return fmt.Errorf("add run usage: %w", err)
+	}
+	return nil
+}
+
+// OwnerUsage returns what runs in chats owned by owner ("" for unowned
+// chats) used in the period containing at.
+func (s *Store) OwnerUsage(ctx context.Context, owner, period string, at time.Time) (UsageTotals, error) {
+	return s.usage(ctx, `AND owner_id = ?`, period, at, owner)
+}
+
+// TotalUsage returns what every run used in the period containing at.
+func (s *Store) TotalUsage(ctx context.Context, period string, at time.Time) (UsageTotals, error) {
+	return s.usage(ctx, ``, period, at)
+}
+
+func (s *Store) usage(ctx context.Context, filter, period string, at time.Time, args ...any) (UsageTotals, error) {
+	var totals UsageTotals
+	err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(runs), 0), COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0), COALESCE(SUM(cost_usd), 0)
+FROM usage_rollups
+WHERE period = ? AND period_start = ? `+filter,
+		append([]any{period, usagePeriodStart(period, at)}, args...)...).Scan(&totals.Runs, &totals.InputTokens, &totals.OutputTokens, &totals.CostUSD)
+	if err != nil {
+		return UsageTotals{}, fmt.Errorf("usage: %w", err)
+	}
+	return totals, nil
+}
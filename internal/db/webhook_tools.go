@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WebhookTool is an operator-defined tool served by an HTTP endpoint.
+// AuthHeader is opened when read and sealed when written.
+type WebhookTool struct {
+	Name            string
+	Description     string
+	InputSchemaJSON string
+	URL             string
+	AuthHeader      string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// SaveWebhookTool creates the named tool or replaces its definition,
+// keeping its creation time.
+func (s *Store) SaveWebhookTool(ctx context.Context, tool WebhookTool) error {
+	authHeader, err := s.seal(tool.AuthHeader)
+	if err != nil {
+		return fmt.Errorf("save webhook tool: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO webhook_tools (name, description, input_schema_json, url, auth_header, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(name) DO UPDATE SET
+description = excluded.description,
+input_schema_json = excluded.input_schema_json,
+url = excluded.url,
+auth_header = excluded.auth_header,
+updated_at = excluded.updated_at`,
+		tool.Name, tool.Description, tool.InputSchemaJSON, tool.URL, authHeader, tool.CreatedAt, tool.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("save webhook tool: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhookTool removes the named tool.
+func (s *Store) DeleteWebhookTool(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_tools WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("delete webhook tool: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListWebhookTools returns every webhook tool by name.
+func (s *Store) ListWebhookTools(ctx context.Context) ([]WebhookTool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT name, description, input_schema_json, url, auth_header, created_at, updated_at
+FROM webhook_tools
+ORDER BY name ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook tools: %w", err)
+	}
+	defer rows.Close()
+	var tools []WebhookTool
+	for rows.Next() {
+		var tool WebhookTool
+		if err := rows.Scan(&tool.Name, &tool.Description, &tool.InputSchemaJSON, &tool.URL, &tool.AuthHeader, &tool.CreatedAt, &tool.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan webhook tool: %w", err)
+		}
+		if tool.AuthHeader, err = s.open(tool.AuthHeader); err != nil {
+			return nil, fmt.Errorf("open %s auth header: %w", tool.Name, err)
+		}
+		tools = append(tools, tool)
+	}
+	return tools, rows.Err()
+}
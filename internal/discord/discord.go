@@ -0,0 +1,306 @@
+// Package discord runs a Discord bot backed by the chat service. Each
+// channel, thread or direct message conversation the bot answers in gets its
+// own chat, so the app keeps the history and the model sees it, and replies
+// stream into Discord by editing the bot's message as tokens arrive.
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"rhone_chat/internal/auth"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+const (
+	// Integration names the bot's chats among the integration chats.
+	Integration = "discord"
+
+	DefaultGatewayURL   = "wss://gateway.discord.gg/?v=10&encoding=json"
+	DefaultAPIURL       = "https://discord.com/api/v10"
+	DefaultUserID       = "discord"
+	DefaultEditInterval = time.Second
+)
+
+// Config configures the bot.
+type Config struct {
+	// Token is the bot token from the Discord developer portal.
+	Token string
+	// UserID owns the bot's chats; their runs count against its quota.
+	UserID string
+	// EditInterval is the least time between two edits of a streaming
+	// reply, which keeps the bot under Discord's rate limits.
+	EditInterval time.Duration
+	// GatewayURL and APIURL default to Discord's.
+	GatewayURL string
+	APIURL     string
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Bot answers Discord messages that mention it, and every direct message,
+// in the chat of their channel.
+type Bot struct {
+	chats     *chatsvc.Service
+	rest      *restClient
+	principal auth.Principal
+	cfg       Config
+	logger    *slog.Logger
+	// answers runs each channel's answers in order, so every run sees the
+	// previous one's reply.
+	answers queue
+
+	mu sync.Mutex
+	// self is the bot's user ID, known once the gateway is ready.
+	self string
+	// titles caches chat titles by channel.
+	titles map[string]string
+}
+
+// New returns a bot answering in chats of chats.
+func New(chats *chatsvc.Service, cfg Config) *Bot {
+	if cfg.UserID == "" {
+		cfg.UserID = DefaultUserID
+	}
+	if cfg.EditInterval <= 0 {
+		cfg.EditInterval = DefaultEditInterval
+	}
+	if cfg.GatewayURL == "" {
+		cfg.GatewayURL = DefaultGatewayURL
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = DefaultAPIURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 15 * time.Second}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Bot{
+		chats:     chats,
+		rest:      &restClient{baseURL: strings.TrimRight(cfg.APIURL, "/"), token: cfg.Token, client: cfg.HTTPClient},
+		principal: auth.Principal{UserID: cfg.UserID, Name: "Discord"},
+		cfg:       cfg,
+		logger:    cfg.Logger,
+		titles:    map[string]string{},
+	}
+}
+
+// Run connects to the gateway and answers messages until ctx is done,
+// reconnecting with backoff when the connection drops. It returns after
+// the answers in progress are sent, or early when Discord refuses the bot
+// for good, such as for an invalid token.
+func (b *Bot) Run(ctx context.Context) error {
+	defer b.answers.wait()
+	var state session
+	backoff := time.Second
+	for {
+		ready, err := b.connect(ctx, &state)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var closed *websocket.CloseError
+		if errors.As(err, &closed) && fatalCloseCodes[closed.Code] {
+			return fmt.Errorf("discord gateway refused the bot: %w", err)
+		}
+		if ready {
+			backoff = time.Second
+		}
+		b.logger.Warn("discord gateway disconnected", "error", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// message is the part of a Discord message the bot reads.
+type message struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	// GuildID is empty for direct messages.
+	GuildID string `json:"guild_id"`
+	Content string `json:"content"`
+	Author  struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Bot      bool   `json:"bot"`
+	} `json:"author"`
+	Mentions []struct {
+		ID string `json:"id"`
+	} `json:"mentions"`
+}
+
+func (m message) mentions(userID string) bool {
+	for _, mention := range m.Mentions {
+		if mention.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// prompt is the message's content without the mentions of the bot.
+func (m message) prompt(self string) string {
+	content := strings.ReplaceAll(m.Content, "<@"+self+">", "")
+	content = strings.ReplaceAll(content, "<@!"+self+">", "")
+	return strings.TrimSpace(content)
+}
+
+// receive queues an answer to m when it is meant for the bot: a direct
+// message, or one that mentions it. Other bots, the bot itself included,
+// are ignored.
+func (b *Bot) receive(ctx context.Context, m message) {
+	b.mu.Lock()
+	self := b.self
+	b.mu.Unlock()
+	if self == "" || m.Author.Bot || m.Author.ID == self {
+		return
+	}
+	if m.GuildID != "" && !m.mentions(self) {
+		return
+	}
+	prompt := m.prompt(self)
+	if prompt == "" {
+		return
+	}
+	b.answers.add(m.ChannelID, func() {
+		b.answer(ctx, m, prompt)
+	})
+}
+
+// answer runs prompt in the chat of m's channel and streams the reply into
+// Discord. The reply is finished even when ctx ends mid-run, since the run
+// itself saves its outcome.
+func (b *Bot) answer(ctx context.Context, m message, prompt string) {
+	logger := b.logger.With("channel_id", m.ChannelID, "message_id", m.ID)
+	reply := &reply{rest: b.rest, channelID: m.ChannelID, replyTo: m.ID}
+	finish := func(text string) {
+		finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+		defer cancel()
+		if err := reply.update(finishCtx, text); err != nil {
+			logger.Warn("discord reply not sent", "error", err)
+		}
+	}
+
+	chat, err := b.chats.IntegrationChat(ctx, b.principal, Integration, m.ChannelID, b.title(ctx, m))
+	if err != nil {
+		logger.Warn("discord chat not found", "error", err)
+		finish("Sorry, I can't answer right now.")
+		return
+	}
+	if err := b.rest.triggerTyping(ctx, m.ChannelID); err != nil {
+		logger.Debug("discord typing indicator not sent", "error", err)
+	}
+
+	// Edits run beside the stream so a slow Discord never holds up the
+	// model; each one sends the latest text.
+	var (
+		textMu  sync.Mutex
+		text    strings.Builder
+		changed = make(chan struct{}, 1)
+		done    = make(chan struct{})
+		edited  = make(chan struct{})
+	)
+	go func() {
+		defer close(edited)
+		for {
+			select {
+			case <-done:
+				return
+			case <-changed:
+			}
+			textMu.Lock()
+			partial := text.String()
+			textMu.Unlock()
+			if err := reply.update(ctx, partial); err != nil {
+				logger.Debug("discord reply not edited", "error", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(b.cfg.EditInterval):
+			}
+		}
+	}()
+
+	var completed chatsvc.RunEvent
+	runErr := b.chats.ExecuteRun(ctx, b.principal, chatsvc.APIRunRequest{ChatID: chat.ID, Content: prompt}, func(event chatsvc.RunEvent) {
+		switch event.Type {
+		case chatsvc.RunEventStreaming:
+			textMu.Lock()
+			text.WriteString(event.Delta)
+			textMu.Unlock()
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		case chatsvc.RunEventCompleted:
+			completed = event
+		}
+	})
+	close(done)
+	<-edited
+
+	if completed.Type == "" {
+		logger.Warn("discord message not answered", "chat_id", chat.ID, "error", runErr)
+		finish("Sorry, I can't answer right now: " + runErr.Error())
+		return
+	}
+	finish(replyText(completed))
+}
+
+// replyText is the final text of a run's reply, with the reason when the
+// run did not complete.
+func replyText(event chatsvc.RunEvent) string {
+	content := strings.TrimSpace(event.Content)
+	note := ""
+	switch event.Status {
+	case "completed":
+		if content == "" {
+			return "_(empty reply)_"
+		}
+		return content
+	case "error":
+		note = "_Reply failed: " + event.Error + "_"
+	default:
+		note = "_Reply " + strings.ReplaceAll(event.Status, "_", " ") + "._"
+	}
+	if content == "" {
+		return note
+	}
+	return content + "\n\n" + note
+}
+
+// title names the chat of m's channel: the channel's name when Discord
+// gives it, the author for direct messages.
+func (b *Bot) title(ctx context.Context, m message) string {
+	b.mu.Lock()
+	title, ok := b.titles[m.ChannelID]
+	b.mu.Unlock()
+	if ok {
+		return title
+	}
+	if m.GuildID == "" {
+		title = "Discord DM with " + m.Author.Username
+	} else if name, err := b.rest.channelName(ctx, m.ChannelID); err == nil && name != "" {
+		title = "Discord #" + name
+	} else {
+		title = "Discord channel " + m.ChannelID
+	}
+	b.mu.Lock()
+	b.titles[m.ChannelID] = title
+	b.mu.Unlock()
+	return title
+}
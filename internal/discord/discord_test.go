@@ -0,0 +1,205 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// fakeDiscord serves a scripted gateway and records the messages the bot
+// posts and edits.
+type fakeDiscord struct {
+	t      *testing.T
+	events []payload
+
+	mu       sync.Mutex
+	identify map[string]any
+	nextID   int
+	messages map[string]string
+	replyTo  map[string]string
+	channels map[string]string
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/gateway" {
+		f.serveGateway(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bot token-1" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Content   string `json:"content"`
+		Reference struct {
+			MessageID string `json:"message_id"`
+		} `json:"message_reference"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2:
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": parts[1], "name": "general"})
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "typing":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && len(parts) == 3:
+		f.nextID++
+		id := fmt.Sprintf("reply-%d", f.nextID)
+		f.messages[id] = body.Content
+		f.replyTo[id] = body.Reference.MessageID
+		f.channels[id] = parts[1]
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": id})
+	case r.Method == http.MethodPatch && len(parts) == 4:
+		f.messages[parts[3]] = body.Content
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": parts[3]})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDiscord) serveGateway(w http.ResponseWriter, r *http.Request) {
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	_ = ws.WriteJSON(payload{Op: opHello, Data: json.RawMessage(`{"heartbeat_interval":45000}`)})
+	var identify payload
+	if err := ws.ReadJSON(&identify); err != nil || identify.Op != opIdentify {
+		f.t.Errorf("first payload = %+v, %v, want identify", identify, err)
+		return
+	}
+	f.mu.Lock()
+	_ = json.Unmarshal(identify.Data, &f.identify)
+	f.mu.Unlock()
+	for i, event := range f.events {
+		seq := int64(i + 1)
+		event.Op, event.Seq = opDispatch, &seq
+		_ = ws.WriteJSON(event)
+	}
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeDiscord) replies() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	replies := map[string]string{}
+	for id, content := range f.messages {
+		replies[f.replyTo[id]] = content
+	}
+	return replies
+}
+
+func dispatchEvent(eventType string, data any) payload {
+	encoded, _ := json.Marshal(data)
+	return payload{Type: eventType, Data: encoded}
+}
+
+func TestBotAnswersMentionsAndDirectMessagesInTheirChannelsChats(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer store.Close()
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+
+	author := map[string]any{"id": "user-1", "username": "alice"}
+	fake := &fakeDiscord{
+		t:        t,
+		messages: map[string]string{},
+		replyTo:  map[string]string{},
+		channels: map[string]string{},
+		events: []payload{
+			dispatchEvent("READY", map[string]any{"session_id": "session-1", "user": map[string]string{"id": "bot-1"}}),
+			dispatchEvent("MESSAGE_CREATE", map[string]any{"id": "m-ignored", "channel_id": "c-1", "guild_id": "g-1", "content": "not for the bot", "author": author}),
+			dispatchEvent("MESSAGE_CREATE", map[string]any{"id": "m-bot", "channel_id": "dm-2", "content": "from a bot", "author": map[string]any{"id": "bot-2", "bot": true}}),
+			dispatchEvent("MESSAGE_CREATE", map[string]any{"id": "m-1", "channel_id": "c-1", "guild_id": "g-1", "content": "<@bot-1> first question", "author": author, "mentions": []map[string]string{{"id": "bot-1"}}}),
+			dispatchEvent("MESSAGE_CREATE", map[string]any{"id": "m-2", "channel_id": "c-1", "guild_id": "g-1", "content": "<@bot-1> second question", "author": author, "mentions": []map[string]string{{"id": "bot-1"}}}),
+			dispatchEvent("MESSAGE_CREATE", map[string]any{"id": "m-3", "channel_id": "dm-1", "content": "a direct message", "author": author}),
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	bot := New(service, Config{
+		Token:        "token-1",
+		EditInterval: 20 * time.Millisecond,
+		GatewayURL:   "ws" + strings.TrimPrefix(server.URL, "http") + "/gateway",
+		APIURL:       server.URL,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- bot.Run(ctx) }()
+
+	want := map[string]string{"m-1": "first question", "m-2": "second question", "m-3": "a direct message"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		replies := fake.replies()
+		done := len(replies) == len(want)
+		for id, said := range want {
+			if !strings.HasSuffix(replies[id], "> "+said) {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replies = %v, want an answer to each of %v", replies, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if fake.identify["token"] != "token-1" || fake.identify["intents"] != float64(intents) {
+		t.Fatalf("identify = %v", fake.identify)
+	}
+	principal := bot.principal
+	channel, err := service.IntegrationChat(context.Background(), principal, Integration, "c-1", "")
+	if err != nil || channel.Title != "Discord #general" {
+		t.Fatalf("IntegrationChat(c-1) = %+v, %v", channel, err)
+	}
+	messages, err := service.ListMessages(context.Background(), channel.ID, 10)
+	if err != nil || len(messages) != 4 || messages[0].Content != "first question" || messages[2].Content != "second question" {
+		t.Fatalf("channel chat messages = %+v, %v, want both questions and answers", messages, err)
+	}
+	direct, err := service.IntegrationChat(context.Background(), principal, Integration, "dm-1", "")
+	if err != nil || direct.ID == channel.ID || direct.Title != "Discord DM with alice" {
+		t.Fatalf("IntegrationChat(dm-1) = %+v, %v", direct, err)
+	}
+}
+
+func TestSplitMessagePrefersLineBreaks(t *testing.T) {
+	text := strings.Repeat("a", 6) + "\n" + strings.Repeat("b", 6)
+	chunks := splitMessage(text, 10)
+	if len(chunks) != 2 || chunks[0] != "aaaaaa" || chunks[1] != "bbbbbb" {
+		t.Fatalf("splitMessage() = %q", chunks)
+	}
+	chunks = splitMessage(strings.Repeat("é", 25), 10)
+	if len(chunks) != 3 || chunks[2] != strings.Repeat("é", 5) {
+		t.Fatalf("splitMessage(runes) = %q", chunks)
+	}
+}
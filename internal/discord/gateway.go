@@ -0,0 +1,229 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// intents asks for guild and direct messages and, as a privileged intent
+// enabled in the developer portal, their content.
+const intents = 1<<9 | 1<<12 | 1<<15
+
+// fatalCloseCodes end the bot instead of reconnecting: the token, shard or
+// intents are wrong, and retrying cannot fix them.
+var fatalCloseCodes = map[int]bool{4004: true, 4010: true, 4011: true, 4012: true, 4013: true, 4014: true}
+
+// staleCloseCodes mean the session cannot be resumed.
+var staleCloseCodes = map[int]bool{4007: true, 4009: true}
+
+var (
+	errReconnect      = errors.New("gateway asked to reconnect")
+	errInvalidSession = errors.New("gateway invalidated the session")
+	errZombie         = errors.New("gateway stopped acknowledging heartbeats")
+)
+
+type payload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  *int64          `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+// session is what the bot needs to resume after a dropped connection.
+type session struct {
+	id        string
+	resumeURL string
+	seq       atomic.Int64
+}
+
+func (s *session) reset() {
+	s.id, s.resumeURL = "", ""
+	s.seq.Store(0)
+}
+
+// conn serializes writes to the gateway, which the read loop and the
+// heartbeat share.
+type conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *conn) send(op int, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return c.ws.WriteJSON(payload{Op: op, Data: encoded})
+}
+
+// connect runs one gateway connection: it identifies, or resumes state,
+// then heartbeats and dispatches messages until the connection ends. ready
+// reports whether the gateway accepted the session.
+func (b *Bot) connect(ctx context.Context, state *session) (ready bool, err error) {
+	url := b.cfg.GatewayURL
+	if state.id != "" && state.resumeURL != "" {
+		url = state.resumeURL + "/?v=10&encoding=json"
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("dial gateway: %w", err)
+	}
+	gateway := &conn{ws: ws}
+	defer ws.Close()
+	stop := context.AfterFunc(ctx, func() {
+		gateway.mu.Lock()
+		_ = ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		gateway.mu.Unlock()
+		_ = ws.Close()
+	})
+	defer stop()
+
+	var hello payload
+	if err := ws.ReadJSON(&hello); err != nil {
+		return false, fmt.Errorf("read hello: %w", err)
+	}
+	var heartbeat struct {
+		Interval int `json:"heartbeat_interval"`
+	}
+	if hello.Op != opHello || json.Unmarshal(hello.Data, &heartbeat) != nil || heartbeat.Interval <= 0 {
+		return false, fmt.Errorf("gateway sent op %d instead of hello", hello.Op)
+	}
+
+	if state.id != "" {
+		err = gateway.send(opResume, map[string]any{"token": b.cfg.Token, "session_id": state.id, "seq": state.seq.Load()})
+	} else {
+		err = gateway.send(opIdentify, map[string]any{
+			"token":   b.cfg.Token,
+			"intents": intents,
+			"properties": map[string]string{
+				"os":      runtime.GOOS,
+				"browser": "rhone_chat",
+				"device":  "rhone_chat",
+			},
+		})
+	}
+	if err != nil {
+		return false, fmt.Errorf("send identify: %w", err)
+	}
+
+	var acked atomic.Bool
+	acked.Store(true)
+	beats := make(chan struct{})
+	defer close(beats)
+	var zombie atomic.Bool
+	go func() {
+		ticker := time.NewTicker(time.Duration(heartbeat.Interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beats:
+				return
+			case <-ticker.C:
+			}
+			if !acked.Swap(false) {
+				zombie.Store(true)
+				_ = ws.Close()
+				return
+			}
+			if err := gateway.send(opHeartbeat, state.seq.Load()); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var event payload
+		if err := ws.ReadJSON(&event); err != nil {
+			if zombie.Load() {
+				return ready, errZombie
+			}
+			var closed *websocket.CloseError
+			if errors.As(err, &closed) && staleCloseCodes[closed.Code] {
+				state.reset()
+			}
+			return ready, err
+		}
+		if event.Seq != nil {
+			state.seq.Store(*event.Seq)
+		}
+		switch event.Op {
+		case opDispatch:
+			if b.dispatch(ctx, state, event) {
+				ready = true
+			}
+		case opHeartbeat:
+			if err := gateway.send(opHeartbeat, state.seq.Load()); err != nil {
+				return ready, err
+			}
+		case opHeartbeatACK:
+			acked.Store(true)
+		case opReconnect:
+			return ready, errReconnect
+		case opInvalidSession:
+			var resumable bool
+			_ = json.Unmarshal(event.Data, &resumable)
+			if !resumable {
+				state.reset()
+			}
+			return ready, errInvalidSession
+		}
+	}
+}
+
+// dispatch handles a gateway event and reports whether it started or
+// resumed the session.
+func (b *Bot) dispatch(ctx context.Context, state *session, event payload) bool {
+	switch event.Type {
+	case "READY":
+		var ready struct {
+			SessionID string `json:"session_id"`
+			ResumeURL string `json:"resume_gateway_url"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(event.Data, &ready); err != nil {
+			b.logger.Warn("discord ready event not understood", "error", err)
+			return false
+		}
+		state.id, state.resumeURL = ready.SessionID, ready.ResumeURL
+		b.mu.Lock()
+		b.self = ready.User.ID
+		b.mu.Unlock()
+		b.logger.Info("discord bot connected", "user_id", ready.User.ID)
+		return true
+	case "RESUMED":
+		return true
+	case "MESSAGE_CREATE":
+		var m message
+		if err := json.Unmarshal(event.Data, &m); err != nil {
+			b.logger.Warn("discord message not understood", "error", err)
+			return false
+		}
+		b.receive(ctx, m)
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxMessageRunes is the most characters Discord accepts in a message.
+const maxMessageRunes = 2000
+
+// maxRateLimitWait bounds how long a request waits out a rate limit before
+// giving up.
+const maxRateLimitWait = 10 * time.Second
+
+// noMentions keeps replies from pinging anyone, whatever the model writes.
+var noMentions = map[string]any{"parse": []string{}}
+
+// restClient calls the Discord HTTP API as the bot.
+type restClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// do sends a request, waiting out rate limits a few times, and decodes the
+// response into out when it is not nil.
+func (c *restClient) do(ctx context.Context, method, path string, body, out any) error {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(encoded))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bot "+c.token)
+		req.Header.Set("User-Agent", "DiscordBot (rhone_chat, 1.0)")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("discord %s %s: %w", method, path, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("discord %s %s: %w", method, path, err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			_ = json.Unmarshal(data, &limit)
+			wait := min(time.Duration(limit.RetryAfter*float64(time.Second)), maxRateLimitWait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("discord %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data[:min(len(data), 300)])))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(data, out)
+	}
+}
+
+// createMessage posts content to a channel, as a reply to replyTo when set,
+// and returns the new message's ID.
+func (c *restClient) createMessage(ctx context.Context, channelID, content, replyTo string) (string, error) {
+	body := map[string]any{"content": content, "allowed_mentions": noMentions}
+	if replyTo != "" {
+		body["message_reference"] = map[string]any{"message_id": replyTo, "fail_if_not_exists": false}
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/channels/"+channelID+"/messages", body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (c *restClient) editMessage(ctx context.Context, channelID, messageID, content string) error {
+	return c.do(ctx, http.MethodPatch, "/channels/"+channelID+"/messages/"+messageID, map[string]any{
+		"content":          content,
+		"allowed_mentions": noMentions,
+	}, nil)
+}
+
+func (c *restClient) deleteMessage(ctx context.Context, channelID, messageID string) error {
+	return c.do(ctx, http.MethodDelete, "/channels/"+channelID+"/messages/"+messageID, nil, nil)
+}
+
+func (c *restClient) triggerTyping(ctx context.Context, channelID string) error {
+	return c.do(ctx, http.MethodPost, "/channels/"+channelID+"/typing", nil, nil)
+}
+
+func (c *restClient) channelName(ctx context.Context, channelID string) (string, error) {
+	var channel struct {
+		Name string `json:"name"`
+	}
+	err := c.do(ctx, http.MethodGet, "/channels/"+channelID, nil, &channel)
+	return channel.Name, err
+}
+
+// reply is the bot's answer to a message, spread over as many Discord
+// messages as its length needs. Updating it edits the messages that changed
+// and posts the ones that are new.
+type reply struct {
+	rest      *restClient
+	channelID string
+	replyTo   string
+	ids       []string
+	sent      []string
+}
+
+func (r *reply) update(ctx context.Context, text string) error {
+	chunks := splitMessage(strings.TrimSpace(text), maxMessageRunes)
+	for i, chunk := range chunks {
+		if i < len(r.ids) {
+			if r.sent[i] == chunk {
+				continue
+			}
+			if err := r.rest.editMessage(ctx, r.channelID, r.ids[i], chunk); err != nil {
+				return err
+			}
+			r.sent[i] = chunk
+			continue
+		}
+		replyTo := ""
+		if i == 0 {
+			replyTo = r.replyTo
+		}
+		id, err := r.rest.createMessage(ctx, r.channelID, chunk, replyTo)
+		if err != nil {
+			return err
+		}
+		r.ids = append(r.ids, id)
+		r.sent = append(r.sent, chunk)
+	}
+	// Output filters can leave the final reply shorter than the streamed
+	// text.
+	for len(r.ids) > len(chunks) && len(chunks) > 0 {
+		last := len(r.ids) - 1
+		if err := r.rest.deleteMessage(ctx, r.channelID, r.ids[last]); err != nil {
+			return err
+		}
+		r.ids, r.sent = r.ids[:last], r.sent[:last]
+	}
+	return nil
+}
+
+// splitMessage cuts text into pieces of at most limit characters, breaking
+// at the last line break of each piece when one falls in its second half.
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	for text != "" {
+		if utf8.RuneCountInString(text) <= limit {
+			chunks = append(chunks, text)
+			break
+		}
+		cut := 0
+		for i := 0; i < limit; i++ {
+			_, size := utf8.DecodeRuneInString(text[cut:])
+			cut += size
+		}
+		if newline := strings.LastIndexByte(text[:cut], '\n'); newline >= cut/2 {
+			cut = newline + 1
+		}
+		chunks = append(chunks, strings.TrimRight(text[:cut], "\n"))
+		text = text[cut:]
+	}
+	return chunks
+}
@@ -0,0 +1,165 @@
+// Package events is an in-process log of application events: chats
+// created, runs started and finished, tools executed, budget warnings. It
+// keeps the most recent events so a subscriber that reconnects can resume
+// from the last one it saw.
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	ChatCreated   = "chat.created"
+	ChatDeleted   = "chat.deleted"
+	RunStarted    = "run.started"
+	RunFinished   = "run.finished"
+	ToolExecuted  = "tool.executed"
+	BudgetWarning = "budget.warning"
+)
+
+// Event is one entry of the log. IDs increase by one per event for the
+// lifetime of the process.
+type Event struct {
+	ID     uint64         `json:"id"`
+	Type   string         `json:"type"`
+	Time   time.Time      `json:"time"`
+	ChatID string         `json:"chat_id,omitempty"`
+	RunID  string         `json:"run_id,omitempty"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 256
+
+// Bus fans events out to subscribers. Publishing never blocks: a
+// subscriber that falls behind is closed and must resubscribe from the
+// last event it read. It is safe for concurrent use.
+type Bus struct {
+	mu     sync.Mutex
+	lastID uint64
+	recent []Event
+	keep   int
+	subs   map[*Subscription]struct{}
+	closed bool
+	now    func() time.Time
+}
+
+// NewBus returns a bus that keeps the keep most recent events for resuming
+// subscribers.
+func NewBus(keep int) *Bus {
+	return &Bus{keep: max(keep, 0), subs: map[*Subscription]struct{}{}, now: time.Now}
+}
+
+// Publish assigns the event its ID and time and delivers it.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.lastID++
+	event.ID = b.lastID
+	event.Time = b.now().UTC()
+	if b.keep > 0 {
+		if len(b.recent) == b.keep {
+			b.recent = slices.Delete(b.recent, 0, 1)
+		}
+		b.recent = append(b.recent, event)
+	}
+	for sub := range b.subs {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.overflowed = true
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe delivers events of the given types, or of every type when none
+// are given. Kept events after afterID are delivered first; pass 0 for new
+// events only.
+func (b *Bus) Subscribe(afterID uint64, types ...string) *Subscription {
+	sub := &Subscription{bus: b, types: types}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var backlog []Event
+	if afterID > 0 {
+		for _, event := range b.recent {
+			if event.ID > afterID && sub.wants(event.Type) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	sub.events = make(chan Event, subscriberBuffer+len(backlog))
+	for _, event := range backlog {
+		sub.events <- event
+	}
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close ends every subscription and drops later events.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.unsubscribe(sub)
+	}
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.events)
+}
+
+// Subscription receives events on Events until it is closed.
+type Subscription struct {
+	bus        *Bus
+	types      []string
+	events     chan Event
+	overflowed bool
+}
+
+// Events is closed when the subscription ends: on Close, when the bus
+// closes, or when the subscriber fell behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Overflowed reports whether the subscription ended because the
+// subscriber fell behind.
+func (s *Subscription) Overflowed() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.overflowed
+}
+
+// Close stops delivery.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.unsubscribe(s)
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	return len(s.types) == 0 || slices.Contains(s.types, eventType)
+}
@@ -0,0 +1,61 @@
+package events
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSubscribersResumeFromTheLastEventTheySaw(t *testing.T) {
+	bus := NewBus(3)
+	for _, runID := range []string{"run-1", "run-2", "run-3", "run-4"} {
+		bus.Publish(Event{Type: RunStarted, RunID: runID})
+	}
+
+	sub := bus.Subscribe(1)
+	defer sub.Close()
+	bus.Publish(Event{Type: RunFinished, RunID: "run-4"})
+	var got []uint64
+	for len(got) < 4 {
+		got = append(got, (<-sub.Events()).ID)
+	}
+	// Event 1 fell out of the kept window; 2-4 replay, then 5 is live.
+	if want := []uint64{2, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Fatalf("event ids = %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeFiltersByType(t *testing.T) {
+	bus := NewBus(10)
+	sub := bus.Subscribe(0, ToolExecuted)
+	bus.Publish(Event{Type: RunStarted})
+	bus.Publish(Event{Type: ToolExecuted, Data: map[string]any{"tool": "web_search"}})
+	event := <-sub.Events()
+	if event.Type != ToolExecuted || event.ID != 2 || event.Time.IsZero() {
+		t.Fatalf("event = %+v, want the tool event with its id and time", event)
+	}
+	sub.Close()
+	if _, open := <-sub.Events(); open {
+		t.Fatal("Events() still open after Close")
+	}
+}
+
+func TestSlowSubscribersAreDroppedWithoutBlockingPublish(t *testing.T) {
+	bus := NewBus(0)
+	slow := bus.Subscribe(0)
+	for i := 0; i <= subscriberBuffer; i++ {
+		bus.Publish(Event{Type: RunStarted})
+	}
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	if received != subscriberBuffer || !slow.Overflowed() {
+		t.Fatalf("received %d events, overflowed %v; want %d and dropped", received, slow.Overflowed(), subscriberBuffer)
+	}
+
+	bus.Close()
+	late := bus.Subscribe(0)
+	if _, open := <-late.Events(); open {
+		t.Fatal("subscription to a closed bus is open")
+	}
+}
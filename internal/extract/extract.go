@@ -0,0 +1,47 @@
+// Package extract pulls plain text out of uploaded documents so it can be
+// given to the model as context.
+package extract
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnsupported is returned for media types Text cannot read.
+	ErrUnsupported = errors.New("unsupported document type")
+	// ErrNoText is returned when a document has no extractable text, such as
+	// a scanned PDF.
+	ErrNoText = errors.New("document has no extractable text")
+	// ErrEncrypted is returned for password-protected PDFs.
+	ErrEncrypted = errors.New("document is encrypted")
+)
+
+// Text returns the text of a document. mediaType is the sniffed type without
+// parameters: "application/pdf" or "text/plain".
+func Text(mediaType string, data []byte) (string, error) {
+	var (
+		text string
+		err  error
+	)
+	switch mediaType {
+	case "application/pdf":
+		text, err = pdfText(data)
+	case "text/plain":
+		if !utf8.Valid(data) {
+			return "", ErrUnsupported
+		}
+		text = string(data)
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
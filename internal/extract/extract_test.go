@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func buildPDF(t *testing.T, streams ...[]byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	for i, stream := range streams {
+		dict := fmt.Sprintf("<< /Length %d >>", len(stream))
+		if i%2 == 1 {
+			var compressed bytes.Buffer
+			writer := zlib.NewWriter(&compressed)
+			if _, err := writer.Write(stream); err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			writer.Close()
+			stream = compressed.Bytes()
+			dict = fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(stream))
+		}
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nstream\n", i+4, dict)
+		out.Write(stream)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+	out.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return out.Bytes()
+}
+
+func TestPDFTextExtractsPlainAndFlateStreams(t *testing.T) {
+	data := buildPDF(t,
+		[]byte("BT /F1 12 Tf 72 720 Td (Hello \\(PDF\\) World) Tj 0 -14 Td [(Sec)-20(ond) -300 (line)] TJ ET"),
+		[]byte("BT /F1 12 Tf 72 700 Td <FEFF00500061006700650020003200> Tj ET"),
+	)
+
+	text, err := Text("application/pdf", data)
+	if err != nil {
+		t.Fatalf("Text() error = %v", err)
+	}
+	want := "Hello (PDF) World\nSecond line\nPage 2"
+	if text != want {
+		t.Fatalf("Text() = %q, want %q", text, want)
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	if _, err := Text("application/pdf", buildPDF(t, []byte("0 0 m 10 10 l S"))); !errors.Is(err, ErrNoText) {
+		t.Fatalf("image-only PDF error = %v, want ErrNoText", err)
+	}
+	encrypted := append(buildPDF(t, []byte("BT (x) Tj ET")), []byte("trailer << /Encrypt 9 0 R >>")...)
+	if _, err := Text("application/pdf", encrypted); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("encrypted PDF error = %v, want ErrEncrypted", err)
+	}
+	if _, err := Text("image/png", []byte("x")); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("png error = %v, want ErrUnsupported", err)
+	}
+	if text, err := Text("text/plain", []byte("  notes\r\nline 2 ")); err != nil || text != "notes\nline 2" {
+		t.Fatalf("Text(text/plain) = %q, %v", text, err)
+	}
+}
@@ -0,0 +1,348 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// pdfText extracts the text shown by the content streams of a PDF. It
+// handles uncompressed and FlateDecode streams and the common text operators
+// (Tj, TJ, ' and "). Fonts that need a ToUnicode CMap to map glyphs back to
+// characters produce no text and are skipped.
+func pdfText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return "", ErrUnsupported
+	}
+	if pdfEncryptPattern.Match(data) {
+		return "", ErrEncrypted
+	}
+
+	var out strings.Builder
+	for _, match := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[match[2]:match[3]]
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := bytes.TrimRight(data[start:start+end], "\r\n")
+		if pdfSkipPattern.Match(dict) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			inflated, ok := inflate(body)
+			if !ok {
+				continue
+			}
+			body = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		if text := contentStreamText(body); text != "" {
+			out.WriteString(text)
+			out.WriteString("\n")
+		}
+	}
+	return out.String(), nil
+}
+
+var (
+	pdfEncryptPattern = regexp.MustCompile(`/Encrypt\s+\d+\s+\d+\s+R`)
+	// pdfStreamPattern matches a stream dictionary and the stream keyword
+	// with its end-of-line marker.
+	pdfStreamPattern = regexp.MustCompile(`(?s)<<((?:[^<>]|<[^<]|<<[^<>]*>>)*)>>\s*stream\r?\n`)
+	pdfSkipPattern   = regexp.MustCompile(`/Subtype\s*/Image|/Type\s*/(?:XRef|ObjStm|Metadata)|/Length1|/FontFile`)
+)
+
+func inflate(body []byte) ([]byte, bool) {
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+	inflated, err := io.ReadAll(reader)
+	if len(inflated) == 0 && err != nil {
+		return nil, false
+	}
+	return inflated, true
+}
+
+// contentStreamText walks the operators of a content stream and collects the
+// strings inside BT/ET text objects.
+func contentStreamText(stream []byte) string {
+	var (
+		out      strings.Builder
+		line     strings.Builder
+		operands []pdfToken
+		inText   bool
+	)
+	endLine := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			out.WriteString(text)
+			out.WriteString("\n")
+		}
+		line.Reset()
+	}
+	scanner := pdfScanner{data: stream}
+	for {
+		token, ok := scanner.next()
+		if !ok {
+			break
+		}
+		if token.kind != pdfOperator {
+			operands = append(operands, token)
+			continue
+		}
+		switch token.value {
+		case "BT":
+			inText = true
+		case "ET":
+			inText = false
+			endLine()
+		case "Td", "TD", "T*", "Tm":
+			if inText {
+				endLine()
+			}
+		case "Tj":
+			if inText {
+				line.WriteString(lastString(operands))
+			}
+		case "'", "\"":
+			if inText {
+				endLine()
+				line.WriteString(lastString(operands))
+			}
+		case "TJ":
+			if inText {
+				for _, operand := range operands {
+					switch operand.kind {
+					case pdfString:
+						line.WriteString(operand.value)
+					case pdfNumber:
+						// Large negative kerning separates words.
+						if strings.HasPrefix(operand.value, "-") && len(strings.TrimLeft(operand.value, "-")) >= 3 {
+							line.WriteString(" ")
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	endLine()
+	return strings.TrimSpace(out.String())
+}
+
+func lastString(operands []pdfToken) string {
+	for i := len(operands) - 1; i >= 0; i-- {
+		if operands[i].kind == pdfString {
+			return operands[i].value
+		}
+	}
+	return ""
+}
+
+type pdfTokenKind int
+
+const (
+	pdfOperator pdfTokenKind = iota
+	pdfString
+	pdfNumber
+	pdfOther
+)
+
+type pdfToken struct {
+	kind  pdfTokenKind
+	value string
+}
+
+type pdfScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *pdfScanner) next() (pdfToken, bool) {
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case isPDFSpace(c):
+			s.pos++
+		case c == '%':
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' && s.data[s.pos] != '\r' {
+				s.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: pdfString, value: decodePDFString(s.literal())}, true
+		case c == '<' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '<':
+			s.pos += 2
+			return pdfToken{kind: pdfOther, value: "<<"}, true
+		case c == '<':
+			return pdfToken{kind: pdfString, value: decodePDFString(s.hex())}, true
+		case c == '>' && s.pos+1 < len(s.data) && s.data[s.pos+1] == '>':
+			s.pos += 2
+			return pdfToken{kind: pdfOther, value: ">>"}, true
+		case c == '[' || c == ']' || c == '{' || c == '}':
+			s.pos++
+			return pdfToken{kind: pdfOther, value: string(c)}, true
+		case c == '/':
+			start := s.pos
+			s.pos++
+			s.word()
+			return pdfToken{kind: pdfOther, value: string(s.data[start:s.pos])}, true
+		default:
+			start := s.pos
+			s.word()
+			if s.pos == start {
+				s.pos++
+				continue
+			}
+			value := string(s.data[start:s.pos])
+			if strings.IndexFunc(value, func(r rune) bool { return !strings.ContainsRune("+-.0123456789", r) }) < 0 {
+				return pdfToken{kind: pdfNumber, value: value}, true
+			}
+			return pdfToken{kind: pdfOperator, value: value}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+func (s *pdfScanner) word() {
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		if isPDFSpace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0 {
+			return
+		}
+		s.pos++
+	}
+}
+
+// literal reads a (...) string, resolving escapes and balanced parentheses.
+func (s *pdfScanner) literal() []byte {
+	s.pos++
+	var out []byte
+	depth := 1
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		s.pos++
+		switch c {
+		case '\\':
+			if s.pos >= len(s.data) {
+				return out
+			}
+			e := s.data[s.pos]
+			s.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if s.pos < len(s.data) && s.data[s.pos] == '\n' {
+					s.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					value := int(e - '0')
+					for i := 0; i < 2 && s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '7'; i++ {
+						value = value*8 + int(s.data[s.pos]-'0')
+						s.pos++
+					}
+					out = append(out, byte(value))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (s *pdfScanner) hex() []byte {
+	s.pos++
+	var digits []byte
+	for s.pos < len(s.data) && s.data[s.pos] != '>' {
+		if c := s.data[s.pos]; isHexDigit(c) {
+			digits = append(digits, c)
+		}
+		s.pos++
+	}
+	s.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		out[i] = hexValue(digits[2*i])<<4 | hexValue(digits[2*i+1])
+	}
+	return out
+}
+
+// decodePDFString turns string bytes into text: UTF-16BE with a byte order
+// mark, otherwise single-byte (PDFDocEncoding is Latin-1 for printable
+// text). Strings that are mostly control bytes come from fonts with custom
+// encodings and are dropped.
+func decodePDFString(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, 0, len(raw))
+	control := 0
+	for _, b := range raw {
+		r := rune(b)
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			control++
+			continue
+		}
+		runes = append(runes, r)
+	}
+	if control*2 > len(raw) {
+		return ""
+	}
+	return string(runes)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
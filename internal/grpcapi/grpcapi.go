@@ -0,0 +1,333 @@
+// Package grpcapi is the gRPC API for programmatic clients, defined in
+// rhonev1/rhone.proto. It offers what the REST/SSE API of httpapi does for
+// chats, messages and runs, over one HTTP/2 connection and without JSON:
+// RunStream streams a run's events as httpapi's message endpoint does.
+//
+// Callers authenticate as they do with the REST API. The request metadata
+// stands in for the HTTP headers, so a bearer token goes in the
+// "authorization" metadata and proxy headers in theirs.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rhonev1/rhone.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/grpcapi/rhonev1"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// maxChats bounds the chats one ListChats page returns.
+const maxChats = 200
+
+// NewServer returns a gRPC server for the API. authenticator identifies
+// callers, as it does for the REST API; options add to the server's, such
+// as its TLS credentials.
+func NewServer(chat *chatsvc.Service, authenticator auth.Authenticator, options ...grpc.ServerOption) *grpc.Server {
+	if authenticator == nil {
+		authenticator = auth.Anonymous()
+	}
+	gate := authGate{authenticator: authenticator}
+	server := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(gate.unary),
+		grpc.ChainStreamInterceptor(gate.stream),
+	}, options...)...)
+	rhonev1.RegisterChatsServer(server, &chatsServer{chat: chat})
+	rhonev1.RegisterMessagesServer(server, &messagesServer{chat: chat})
+	rhonev1.RegisterRunsServer(server, &runsServer{chat: chat})
+	return server
+}
+
+type chatsServer struct {
+	rhonev1.UnimplementedChatsServer
+	chat *chatsvc.Service
+}
+
+func (s *chatsServer) ListChats(ctx context.Context, request *rhonev1.ListChatsRequest) (*rhonev1.ListChatsResponse, error) {
+	principal, _ := auth.PrincipalFrom(ctx)
+	limit := int(request.GetLimit())
+	if limit < 0 || limit > maxChats {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid limit %d; want 1 to %d", limit, maxChats)
+	}
+	page, err := s.chat.ListChats(ctx, principal, request.GetCursor(), limit)
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	response := &rhonev1.ListChatsResponse{NextCursor: page.NextCursor}
+	for _, chat := range page.Chats {
+		response.Chats = append(response.Chats, chatProto(chat))
+	}
+	return response, nil
+}
+
+func (s *chatsServer) CreateChat(ctx context.Context, request *rhonev1.CreateChatRequest) (*rhonev1.Chat, error) {
+	principal, _ := auth.PrincipalFrom(ctx)
+	chat, err := s.chat.CreateChat(ctx, principal, request.GetModel())
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return chatProto(chat), nil
+}
+
+type messagesServer struct {
+	rhonev1.UnimplementedMessagesServer
+	chat *chatsvc.Service
+}
+
+func (s *messagesServer) ListMessages(ctx context.Context, request *rhonev1.ListMessagesRequest) (*rhonev1.ListMessagesResponse, error) {
+	principal, _ := auth.PrincipalFrom(ctx)
+	messages, err := s.chat.ChatMessages(ctx, principal, request.GetChatId(), int(request.GetLimit()))
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	response := &rhonev1.ListMessagesResponse{}
+	for _, message := range messages {
+		response.Messages = append(response.Messages, &rhonev1.Message{
+			Id:        message.ID,
+			Role:      message.Role,
+			Content:   message.Content,
+			Status:    message.Status,
+			CreatedAt: timestamppb.New(message.CreatedAt),
+		})
+	}
+	return response, nil
+}
+
+type runsServer struct {
+	rhonev1.UnimplementedRunsServer
+	chat *chatsvc.Service
+}
+
+func (s *runsServer) RunStream(request *rhonev1.RunRequest, stream grpc.ServerStreamingServer[rhonev1.RunEvent]) error {
+	ctx := stream.Context()
+	principal, _ := auth.PrincipalFrom(ctx)
+	locale := request.GetLocale()
+	if locale == "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		locale = s.chat.ResolveLocale(strings.Join(md.Get("accept-language"), ","))
+	}
+	var schema json.RawMessage
+	if request.GetSchemaJson() != "" {
+		schema = json.RawMessage(request.GetSchemaJson())
+	}
+
+	accepted := false
+	err := s.chat.ExecuteRun(ctx, principal, chatsvc.APIRunRequest{
+		ChatID:  request.GetChatId(),
+		Content: request.GetContent(),
+		Model:   request.GetModel(),
+		RunID:   request.GetRunId(),
+		Locale:  locale,
+		Schema:  schema,
+	}, func(event chatsvc.RunEvent) {
+		accepted = true
+		// A send error means the client left; the run still finishes and
+		// its receipt records the outcome.
+		_ = stream.Send(runEventProto(event))
+	})
+	if accepted {
+		if err != nil {
+			slog.Warn("grpc run failed after it was accepted", "chat_id", request.GetChatId(), "error", err)
+		}
+		return nil
+	}
+	return statusFor(err)
+}
+
+func (s *runsServer) GetRun(ctx context.Context, request *rhonev1.GetRunRequest) (*rhonev1.RunReceipt, error) {
+	principal, _ := auth.PrincipalFrom(ctx)
+	receipt, err := s.chat.RunReceipt(ctx, principal, request.GetRunId())
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return receiptProto(receipt), nil
+}
+
+func (s *runsServer) CancelRun(ctx context.Context, request *rhonev1.CancelRunRequest) (*rhonev1.RunReceipt, error) {
+	principal, _ := auth.PrincipalFrom(ctx)
+	receipt, err := s.chat.StopRun(ctx, principal, request.GetRunId())
+	if err != nil {
+		return nil, statusFor(err)
+	}
+	return receiptProto(receipt), nil
+}
+
+func chatProto(chat chatsvc.Chat) *rhonev1.Chat {
+	return &rhonev1.Chat{
+		Id:        chat.ID,
+		Title:     chat.Title,
+		Model:     chat.Model,
+		CreatedAt: timestamppb.New(chat.CreatedAt),
+		UpdatedAt: timestamppb.New(chat.UpdatedAt),
+	}
+}
+
+func runEventProto(event chatsvc.RunEvent) *rhonev1.RunEvent {
+	return &rhonev1.RunEvent{
+		Id:                 event.ID,
+		Seq:                int32(event.Seq),
+		Type:               event.Type,
+		RunId:              event.RunID,
+		ChatId:             event.ChatID,
+		UserMessageId:      event.UserMessageID,
+		AssistantMessageId: event.AssistantMessageID,
+		Delta:              event.Delta,
+		Content:            event.Content,
+		Status:             event.Status,
+		Error:              event.Error,
+		Sources:            sourcesProto(event.Sources),
+		At:                 timestamppb.New(event.At),
+		StructuredJson:     string(event.Structured),
+	}
+}
+
+func receiptProto(receipt chatsvc.RunReceipt) *rhonev1.RunReceipt {
+	proto := &rhonev1.RunReceipt{
+		RunId:              receipt.RunID,
+		ChatId:             receipt.ChatID,
+		UserMessageId:      receipt.UserMessageID,
+		AssistantMessageId: receipt.AssistantMessageID,
+		Model:              receipt.Model,
+		Status:             receipt.Status,
+		Content:            receipt.Content,
+		Error:              receipt.Error,
+		Sources:            sourcesProto(receipt.Sources),
+		StartedAt:          timestamppb.New(receipt.StartedAt),
+		StructuredJson:     string(receipt.Structured),
+	}
+	if receipt.FinishedAt != nil {
+		proto.FinishedAt = timestamppb.New(*receipt.FinishedAt)
+	}
+	return proto
+}
+
+func sourcesProto(sources []chatsvc.Source) []*rhonev1.Source {
+	var protos []*rhonev1.Source
+	for _, source := range sources {
+		protos = append(protos, &rhonev1.Source{
+			Url:         source.URL,
+			Title:       source.Title,
+			ToolCallId:  source.ToolCallID,
+			RetrievedAt: timestamppb.New(source.RetrievedAt),
+		})
+	}
+	return protos
+}
+
+// authGate authenticates each call with the REST API's authenticator and
+// puts the principal on its context.
+type authGate struct {
+	authenticator auth.Authenticator
+}
+
+func (g authGate) unary(ctx context.Context, request any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := g.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+func (g authGate) stream(server any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := g.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(server, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate presents the call to the authenticator as an HTTP request
+// carrying its metadata as headers, its peer address and its TLS state.
+func (g authGate) authenticate(ctx context.Context) (context.Context, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if !strings.HasPrefix(key, ":") {
+			request.Header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	if caller, ok := peer.FromContext(ctx); ok {
+		request.RemoteAddr = caller.Addr.String()
+		if info, ok := caller.AuthInfo.(credentials.TLSInfo); ok {
+			request.TLS = &info.State
+		}
+	}
+	principal, err := g.authenticator.Authenticate(request)
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// statusFor maps service errors to gRPC status codes, as httpapi maps them
+// to HTTP statuses.
+func statusFor(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, chatsvc.ErrChatForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, chatsvc.ErrInvalidRun), errors.Is(err, chatsvc.ErrInvalidCursor):
+		code = codes.InvalidArgument
+	case errors.Is(err, chatsvc.ErrRunExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, chatsvc.ErrRunFinished), errors.Is(err, chatsvc.ErrContentBlocked):
+		code = codes.FailedPrecondition
+	case errors.Is(err, chatsvc.ErrRateLimited), errors.Is(err, chatsvc.ErrSpendCeilingReached), errors.Is(err, chatsvc.ErrBudgetExhausted):
+		code = codes.ResourceExhausted
+	case errors.Is(err, chatsvc.ErrShuttingDown):
+		code = codes.Unavailable
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// GracefulStop stops server, letting calls in flight finish for up to
+// timeout before ending them.
+func GracefulStop(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		server.Stop()
+	}
+}
@@ -0,0 +1,118 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/grpcapi/rhonev1"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+func TestRunStreamAnswersAndChatsAreListed(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "grpc.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	// Callers name themselves in x-user, as a proxy's header would.
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (auth.Principal, error) {
+		if user := r.Header.Get("X-User"); user != "" {
+			return auth.Principal{UserID: user}, nil
+		}
+		return auth.Principal{}, auth.ErrUnauthenticated
+	})
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(service, authenticator)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	chats := rhonev1.NewChatsClient(conn)
+	runs := rhonev1.NewRunsClient(conn)
+
+	if _, err := chats.ListChats(context.Background(), &rhonev1.ListChatsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("ListChats() without a user error = %v, want Unauthenticated", err)
+	}
+	alice := metadata.AppendToOutgoingContext(context.Background(), "x-user", "alice")
+	chat, err := chats.CreateChat(alice, &rhonev1.CreateChatRequest{})
+	if err != nil || chat.GetModel() != ai.MockModel {
+		t.Fatalf("CreateChat() = %v, %v", chat, err)
+	}
+
+	stream, err := runs.RunStream(alice, &rhonev1.RunRequest{ChatId: chat.GetId(), Content: "Hello there", RunId: "run-1"})
+	if err != nil {
+		t.Fatalf("RunStream() error = %v", err)
+	}
+	var events []*rhonev1.RunEvent
+	for {
+		event, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		events = append(events, event)
+	}
+	if len(events) < 4 || events[0].GetType() != chatsvc.RunEventAccepted {
+		t.Fatalf("events = %v, want accepted first", events)
+	}
+	last := events[len(events)-1]
+	if last.GetType() != chatsvc.RunEventCompleted || last.GetStatus() != "completed" || !strings.Contains(last.GetContent(), "Hello there") {
+		t.Fatalf("last event = %v, want a completed reply echoing the message", last)
+	}
+
+	// Resending the run is refused once the first attempt was accepted.
+	stream, err = runs.RunStream(alice, &rhonev1.RunRequest{ChatId: chat.GetId(), Content: "Hello there", RunId: "run-1"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("RunStream() again error = %v, want AlreadyExists", err)
+	}
+	receipt, err := runs.GetRun(alice, &rhonev1.GetRunRequest{RunId: "run-1"})
+	if err != nil || receipt.GetStatus() != "completed" || receipt.GetFinishedAt() == nil {
+		t.Fatalf("GetRun() = %v, %v", receipt, err)
+	}
+	if _, err := runs.CancelRun(alice, &rhonev1.CancelRunRequest{RunId: "run-1"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("CancelRun() of a finished run error = %v, want FailedPrecondition", err)
+	}
+
+	messages, err := rhonev1.NewMessagesClient(conn).ListMessages(alice, &rhonev1.ListMessagesRequest{ChatId: chat.GetId()})
+	if err != nil || len(messages.GetMessages()) != 2 || messages.GetMessages()[0].GetContent() != "Hello there" {
+		t.Fatalf("ListMessages() = %v, %v", messages, err)
+	}
+	bob := metadata.AppendToOutgoingContext(context.Background(), "x-user", "bob")
+	if _, err := rhonev1.NewMessagesClient(conn).ListMessages(bob, &rhonev1.ListMessagesRequest{ChatId: chat.GetId()}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("ListMessages() of another user's chat error = %v, want PermissionDenied", err)
+	}
+	listed, err := chats.ListChats(alice, &rhonev1.ListChatsRequest{Limit: 10})
+	if err != nil || len(listed.GetChats()) != 1 || listed.GetChats()[0].GetId() != chat.GetId() {
+		t.Fatalf("ListChats() = %v, %v", listed, err)
+	}
+}
@@ -0,0 +1,479 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: rhonev1/rhone.proto
+
+// rhone.v1 is the gRPC API for programmatic clients. It mirrors the REST/SSE
+// API: a run streams its lifecycle events, and a client that loses the
+// stream reads the run's receipt.
+
+package rhonev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Chats_ListChats_FullMethodName  = "/rhone.v1.Chats/ListChats"
+	Chats_CreateChat_FullMethodName = "/rhone.v1.Chats/CreateChat"
+)
+
+// ChatsClient is the client API for Chats service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Chats lists and creates the caller's chats.
+type ChatsClient interface {
+	// ListChats returns a page of chats, most recently updated first.
+	ListChats(ctx context.Context, in *ListChatsRequest, opts ...grpc.CallOption) (*ListChatsResponse, error)
+	// CreateChat starts an empty chat.
+	CreateChat(ctx context.Context, in *CreateChatRequest, opts ...grpc.CallOption) (*Chat, error)
+}
+
+type chatsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChatsClient(cc grpc.ClientConnInterface) ChatsClient {
+	return &chatsClient{cc}
+}
+
+func (c *chatsClient) ListChats(ctx context.Context, in *ListChatsRequest, opts ...grpc.CallOption) (*ListChatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListChatsResponse)
+	err := c.cc.Invoke(ctx, Chats_ListChats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatsClient) CreateChat(ctx context.Context, in *CreateChatRequest, opts ...grpc.CallOption) (*Chat, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Chat)
+	err := c.cc.Invoke(ctx, Chats_CreateChat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChatsServer is the server API for Chats service.
+// All implementations must embed UnimplementedChatsServer
+// for forward compatibility.
+//
+// Chats lists and creates the caller's chats.
+type ChatsServer interface {
+	// ListChats returns a page of chats, most recently updated first.
+	ListChats(context.Context, *ListChatsRequest) (*ListChatsResponse, error)
+	// CreateChat starts an empty chat.
+	CreateChat(context.Context, *CreateChatRequest) (*Chat, error)
+	mustEmbedUnimplementedChatsServer()
+}
+
+// UnimplementedChatsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChatsServer struct{}
+
+func (UnimplementedChatsServer) ListChats(context.Context, *ListChatsRequest) (*ListChatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChats not implemented")
+}
+func (UnimplementedChatsServer) CreateChat(context.Context, *CreateChatRequest) (*Chat, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateChat not implemented")
+}
+func (UnimplementedChatsServer) mustEmbedUnimplementedChatsServer() {}
+func (UnimplementedChatsServer) testEmbeddedByValue()               {}
+
+// UnsafeChatsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChatsServer will
+// result in compilation errors.
+type UnsafeChatsServer interface {
+	mustEmbedUnimplementedChatsServer()
+}
+
+func RegisterChatsServer(s grpc.ServiceRegistrar, srv ChatsServer) {
+	// If the following call pancis, it indicates UnimplementedChatsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Chats_ServiceDesc, srv)
+}
+
+func _Chats_ListChats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatsServer).ListChats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Chats_ListChats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatsServer).ListChats(ctx, req.(*ListChatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Chats_CreateChat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateChatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatsServer).CreateChat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Chats_CreateChat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatsServer).CreateChat(ctx, req.(*CreateChatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Chats_ServiceDesc is the grpc.ServiceDesc for Chats service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Chats_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rhone.v1.Chats",
+	HandlerType: (*ChatsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListChats",
+			Handler:    _Chats_ListChats_Handler,
+		},
+		{
+			MethodName: "CreateChat",
+			Handler:    _Chats_CreateChat_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rhonev1/rhone.proto",
+}
+
+const (
+	Messages_ListMessages_FullMethodName = "/rhone.v1.Messages/ListMessages"
+)
+
+// MessagesClient is the client API for Messages service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Messages reads the messages of the caller's chats.
+type MessagesClient interface {
+	// ListMessages returns a chat's messages in conversation order.
+	ListMessages(ctx context.Context, in *ListMessagesRequest, opts ...grpc.CallOption) (*ListMessagesResponse, error)
+}
+
+type messagesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessagesClient(cc grpc.ClientConnInterface) MessagesClient {
+	return &messagesClient{cc}
+}
+
+func (c *messagesClient) ListMessages(ctx context.Context, in *ListMessagesRequest, opts ...grpc.CallOption) (*ListMessagesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMessagesResponse)
+	err := c.cc.Invoke(ctx, Messages_ListMessages_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MessagesServer is the server API for Messages service.
+// All implementations must embed UnimplementedMessagesServer
+// for forward compatibility.
+//
+// Messages reads the messages of the caller's chats.
+type MessagesServer interface {
+	// ListMessages returns a chat's messages in conversation order.
+	ListMessages(context.Context, *ListMessagesRequest) (*ListMessagesResponse, error)
+	mustEmbedUnimplementedMessagesServer()
+}
+
+// UnimplementedMessagesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMessagesServer struct{}
+
+func (UnimplementedMessagesServer) ListMessages(context.Context, *ListMessagesRequest) (*ListMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMessages not implemented")
+}
+func (UnimplementedMessagesServer) mustEmbedUnimplementedMessagesServer() {}
+func (UnimplementedMessagesServer) testEmbeddedByValue()                  {}
+
+// UnsafeMessagesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessagesServer will
+// result in compilation errors.
+type UnsafeMessagesServer interface {
+	mustEmbedUnimplementedMessagesServer()
+}
+
+func RegisterMessagesServer(s grpc.ServiceRegistrar, srv MessagesServer) {
+	// If the following call pancis, it indicates UnimplementedMessagesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Messages_ServiceDesc, srv)
+}
+
+func _Messages_ListMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).ListMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Messages_ListMessages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).ListMessages(ctx, req.(*ListMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Messages_ServiceDesc is the grpc.ServiceDesc for Messages service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Messages_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rhone.v1.Messages",
+	HandlerType: (*MessagesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMessages",
+			Handler:    _Messages_ListMessages_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rhonev1/rhone.proto",
+}
+
+const (
+	Runs_RunStream_FullMethodName = "/rhone.v1.Runs/RunStream"
+	Runs_GetRun_FullMethodName    = "/rhone.v1.Runs/GetRun"
+	Runs_CancelRun_FullMethodName = "/rhone.v1.Runs/CancelRun"
+)
+
+// RunsClient is the client API for Runs service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Runs sends messages and follows the runs that answer them.
+type RunsClient interface {
+	// RunStream sends a message and streams the run's events: accepted,
+	// persisted, streaming once per delta, and completed. Resending with the
+	// same run_id fails with ALREADY_EXISTS once the first attempt was
+	// accepted.
+	RunStream(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RunEvent], error)
+	// GetRun returns how a run stands or ended.
+	GetRun(ctx context.Context, in *GetRunRequest, opts ...grpc.CallOption) (*RunReceipt, error)
+	// CancelRun stops a run wherever it was started. It fails with
+	// FAILED_PRECONDITION when the run already ended.
+	CancelRun(ctx context.Context, in *CancelRunRequest, opts ...grpc.CallOption) (*RunReceipt, error)
+}
+
+type runsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRunsClient(cc grpc.ClientConnInterface) RunsClient {
+	return &runsClient{cc}
+}
+
+func (c *runsClient) RunStream(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RunEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Runs_ServiceDesc.Streams[0], Runs_RunStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RunRequest, RunEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Runs_RunStreamClient = grpc.ServerStreamingClient[RunEvent]
+
+func (c *runsClient) GetRun(ctx context.Context, in *GetRunRequest, opts ...grpc.CallOption) (*RunReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RunReceipt)
+	err := c.cc.Invoke(ctx, Runs_GetRun_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runsClient) CancelRun(ctx context.Context, in *CancelRunRequest, opts ...grpc.CallOption) (*RunReceipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RunReceipt)
+	err := c.cc.Invoke(ctx, Runs_CancelRun_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RunsServer is the server API for Runs service.
+// All implementations must embed UnimplementedRunsServer
+// for forward compatibility.
+//
+// Runs sends messages and follows the runs that answer them.
+type RunsServer interface {
+	// RunStream sends a message and streams the run's events: accepted,
+	// persisted, streaming once per delta, and completed. Resending with the
+	// same run_id fails with ALREADY_EXISTS once the first attempt was
+	// accepted.
+	RunStream(*RunRequest, grpc.ServerStreamingServer[RunEvent]) error
+	// GetRun returns how a run stands or ended.
+	GetRun(context.Context, *GetRunRequest) (*RunReceipt, error)
+	// CancelRun stops a run wherever it was started. It fails with
+	// FAILED_PRECONDITION when the run already ended.
+	CancelRun(context.Context, *CancelRunRequest) (*RunReceipt, error)
+	mustEmbedUnimplementedRunsServer()
+}
+
+// UnimplementedRunsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRunsServer struct{}
+
+func (UnimplementedRunsServer) RunStream(*RunRequest, grpc.ServerStreamingServer[RunEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method RunStream not implemented")
+}
+func (UnimplementedRunsServer) GetRun(context.Context, *GetRunRequest) (*RunReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRun not implemented")
+}
+func (UnimplementedRunsServer) CancelRun(context.Context, *CancelRunRequest) (*RunReceipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelRun not implemented")
+}
+func (UnimplementedRunsServer) mustEmbedUnimplementedRunsServer() {}
+func (UnimplementedRunsServer) testEmbeddedByValue()              {}
+
+// UnsafeRunsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RunsServer will
+// result in compilation errors.
+type UnsafeRunsServer interface {
+	mustEmbedUnimplementedRunsServer()
+}
+
+func RegisterRunsServer(s grpc.ServiceRegistrar, srv RunsServer) {
+	// If the following call pancis, it indicates UnimplementedRunsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Runs_ServiceDesc, srv)
+}
+
+func _Runs_RunStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RunRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RunsServer).RunStream(m, &grpc.GenericServerStream[RunRequest, RunEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Runs_RunStreamServer = grpc.ServerStreamingServer[RunEvent]
+
+func _Runs_GetRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunsServer).GetRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Runs_GetRun_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunsServer).GetRun(ctx, req.(*GetRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Runs_CancelRun_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRunRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunsServer).CancelRun(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Runs_CancelRun_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunsServer).CancelRun(ctx, req.(*CancelRunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Runs_ServiceDesc is the grpc.ServiceDesc for Runs service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Runs_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rhone.v1.Runs",
+	HandlerType: (*RunsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRun",
+			Handler:    _Runs_GetRun_Handler,
+		},
+		{
+			MethodName: "CancelRun",
+			Handler:    _Runs_CancelRun_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RunStream",
+			Handler:       _Runs_RunStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rhonev1/rhone.proto",
+}
@@ -0,0 +1,137 @@
+// Package health answers liveness and readiness probes. Liveness only says
+// the process is up; readiness says it should be sent runs: startup,
+// including migrations, has finished, the server is not draining for
+// shutdown and every check passes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds each readiness check.
+const checkTimeout = 2 * time.Second
+
+// Check reports why the server cannot serve runs, or nil.
+type Check func(ctx context.Context) error
+
+// Probe tracks the server's readiness. It is safe for concurrent use.
+type Probe struct {
+	started atomic.Bool
+	mu      sync.Mutex
+	names   []string
+	checks  map[string]Check
+}
+
+// New returns a probe that is not ready until Started is called.
+func New() *Probe {
+	return &Probe{checks: map[string]Check{}}
+}
+
+// AddCheck registers a readiness check under name.
+func (p *Probe) AddCheck(name string, check Check) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.checks[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.checks[name] = check
+}
+
+// Started marks startup as finished.
+func (p *Probe) Started() {
+	p.started.Store(true)
+}
+
+// Report is the body of a readiness answer. Checks maps each check to "ok"
+// or the reason it failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready runs the checks and reports whether the server is ready.
+func (p *Probe) Ready(ctx context.Context) (Report, bool) {
+	report := Report{Status: "ready", Checks: map[string]string{}}
+	if !p.started.Load() {
+		report.Status = "starting"
+		return report, false
+	}
+	p.mu.Lock()
+	names := append([]string(nil), p.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = p.checks[name]
+	}
+	p.mu.Unlock()
+
+	ready := true
+	for i, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			ready = false
+			report.Checks[names[i]] = err.Error()
+			continue
+		}
+		report.Checks[names[i]] = "ok"
+	}
+	if !ready {
+		report.Status = "not_ready"
+	}
+	return report, ready
+}
+
+// Handler serves GET /api/livez and GET /api/readyz, answering everything
+// else with next. Neither probe requires authentication. next may be nil.
+func (p *Probe) Handler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/livez", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{Status: "ok", Checks: map[string]string{}})
+	})
+	mux.HandleFunc("GET /api/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report, ready := p.Ready(r.Context())
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+	if next != nil {
+		mux.Handle("/", next)
+	}
+	return mux
+}
+
+// Gate answers 503 until Open gives it the handler to serve, so a listener
+// can take probes while the server starts.
+type Gate struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+// Open starts serving handler.
+func (g *Gate) Open(handler http.Handler) {
+	g.handler.Store(&handler)
+}
+
+func (g *Gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := g.handler.Load()
+	if handler == nil {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "server is starting", http.StatusServiceUnavailable)
+		return
+	}
+	(*handler).ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
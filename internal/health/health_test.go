@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessFollowsStartupAndChecks(t *testing.T) {
+	probe := New()
+	draining := false
+	probe.AddCheck("runs", func(context.Context) error {
+		if draining {
+			return errors.New("server is shutting down")
+		}
+		return nil
+	})
+	gate := &Gate{}
+	handler := probe.Handler(gate)
+	get := func(path string) (int, Report) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report Report
+		_ = json.NewDecoder(recorder.Body).Decode(&report)
+		return recorder.Code, report
+	}
+
+	if code, _ := get("/api/livez"); code != http.StatusOK {
+		t.Fatalf("livez while starting = %d, want 200", code)
+	}
+	if code, report := get("/api/readyz"); code != http.StatusServiceUnavailable || report.Status != "starting" {
+		t.Fatalf("readyz while starting = %d %+v, want 503 starting", code, report)
+	}
+	if code, _ := get("/api/v1/runs/run-1"); code != http.StatusServiceUnavailable {
+		t.Fatalf("API while starting = %d, want 503", code)
+	}
+
+	gate.Open(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }))
+	probe.Started()
+	if code, report := get("/api/readyz"); code != http.StatusOK || report.Checks["runs"] != "ok" {
+		t.Fatalf("readyz once started = %d %+v, want 200", code, report)
+	}
+	if code, _ := get("/api/v1/runs/run-1"); code != http.StatusTeapot {
+		t.Fatalf("API once open = %d, want the wrapped handler", code)
+	}
+
+	draining = true
+	code, report := get("/api/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != "not_ready" || report.Checks["runs"] != "server is shutting down" {
+		t.Fatalf("readyz while draining = %d %+v, want 503 with the failed check", code, report)
+	}
+	if code, _ := get("/api/livez"); code != http.StatusOK {
+		t.Fatalf("livez while draining = %d, want 200", code)
+	}
+}
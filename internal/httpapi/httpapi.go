@@ -0,0 +1,858 @@
+// Package httpapi is the REST/SSE API for external clients, served under
+// /api/v1 on the API listener. Handlers expect the caller's principal on the
+// request context (see middleware.RequireAuth) and answer errors as
+// {"error": "..."} with the status StatusFor gives them. Routes under
+// /api/v1/admin, and the event stream, are for administrators only: callers
+// with the admin role, as callers presenting ADMIN_TOKEN as a bearer token
+// have, and not the single user of a server without authentication.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/backup"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/events"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+const (
+	maxRequestBytes = 1 << 20
+	// defaultAnalyticsEvents and maxAnalyticsEvents bound the events one
+	// request lists.
+	defaultAnalyticsEvents = 100
+	maxAnalyticsEvents     = 1000
+	// eventHeartbeat is how often an idle event stream sends a comment so
+	// proxies keep the connection open.
+	eventHeartbeat = 15 * time.Second
+	// defaultChats and maxChats bound the chats one page lists.
+	defaultChats = 50
+	maxChats     = 200
+)
+
+// sendMessageRequest is a message to send. The idempotency key can also
+// come in the Idempotency-Key header.
+type sendMessageRequest struct {
+	Content        string          `json:"content"`
+	Model          string          `json:"model"`
+	RunID          string          `json:"run_id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Schema         json.RawMessage `json:"schema"`
+}
+
+type createChatRequest struct {
+	Model string `json:"model"`
+}
+
+// chatResponse is a chat as the API lists it.
+type chatResponse struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type chatListResponse struct {
+	Chats      []chatResponse `json:"chats"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func newChatResponse(chat chatsvc.Chat) chatResponse {
+	return chatResponse{ID: chat.ID, Title: chat.Title, Model: chat.Model, CreatedAt: chat.CreatedAt, UpdatedAt: chat.UpdatedAt}
+}
+
+// dataExportResponse is a data export as the API reports it.
+type dataExportResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	SizeBytes   int64      `json:"size_bytes,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func newDataExportResponse(export chatsvc.DataExport) dataExportResponse {
+	response := dataExportResponse{
+		ID:        export.ID,
+		Status:    export.Status,
+		SizeBytes: export.SizeBytes,
+		Error:     export.ErrorText,
+		CreatedAt: export.CreatedAt,
+	}
+	if export.FinishedAt.Valid {
+		response.FinishedAt = &export.FinishedAt.Time
+	}
+	if export.ExpiresAt.Valid {
+		response.ExpiresAt = &export.ExpiresAt.Time
+	}
+	if export.Status == chatsvc.DataExportReady {
+		response.DownloadURL = "/api/v1/me/exports/" + export.ID + "/download"
+	}
+	return response
+}
+
+// dataDeletionResponse counts what deleting a user's data removed.
+type dataDeletionResponse struct {
+	Chats           int64 `json:"chats"`
+	Messages        int64 `json:"messages"`
+	Runs            int64 `json:"runs"`
+	ToolCalls       int64 `json:"tool_calls"`
+	Attachments     int64 `json:"attachments"`
+	KnowledgeChunks int64 `json:"knowledge_chunks"`
+	AuditEntries    int64 `json:"audit_entries"`
+}
+
+type errorResponse struct {
+	Error   string              `json:"error"`
+	Receipt *chatsvc.RunReceipt `json:"receipt,omitempty"`
+}
+
+// New returns the API handler. backups may be nil when backups are not
+// configured.
+func New(chat *chatsvc.Service, backups *backup.Manager) http.Handler {
+	api := &handler{chat: chat, backups: backups}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/chats", api.listChats)
+	mux.HandleFunc("POST /api/v1/chats", api.createChat)
+	mux.HandleFunc("GET /api/v1/chats/{chatID}/export", api.exportChat)
+	mux.HandleFunc("POST /api/v1/chats/{chatID}/messages", api.sendMessage)
+	mux.HandleFunc("POST /api/v1/chats/{chatID}/preview", api.previewMessage)
+	mux.HandleFunc("DELETE /api/v1/me", api.deleteAllData)
+	mux.HandleFunc("POST /api/v1/me/exports", api.requestDataExport)
+	mux.HandleFunc("GET /api/v1/me/exports", api.listDataExports)
+	mux.HandleFunc("GET /api/v1/me/exports/{exportID}", api.getDataExport)
+	mux.HandleFunc("GET /api/v1/me/exports/{exportID}/download", api.downloadDataExport)
+	mux.HandleFunc("GET /api/v1/runs/{runID}", api.getRun)
+	mux.HandleFunc("POST /api/v1/runs/{runID}/cancel", api.cancelRun)
+	mux.HandleFunc("GET /api/v1/events", api.streamEvents)
+	mux.HandleFunc("GET /api/v1/admin/backups", api.listBackups)
+	mux.HandleFunc("POST /api/v1/admin/backups", api.createBackup)
+	mux.HandleFunc("POST /api/v1/admin/checkpoint", api.checkpoint)
+	mux.HandleFunc("GET /api/v1/admin/stats", api.adminStats)
+	mux.HandleFunc("GET /api/v1/admin/finetune", api.exportFineTune)
+	mux.HandleFunc("GET /api/v1/admin/analytics", api.analyticsCounts)
+	mux.HandleFunc("GET /api/v1/admin/analytics/events", api.analyticsEvents)
+	mux.HandleFunc("GET /api/v1/admin/tools", api.listWebhookTools)
+	mux.HandleFunc("POST /api/v1/admin/tools", api.saveWebhookTool)
+	mux.HandleFunc("DELETE /api/v1/admin/tools/{name}", api.deleteWebhookTool)
+	return mux
+}
+
+type handler struct {
+	chat    *chatsvc.Service
+	backups *backup.Manager
+}
+
+// listChats answers GET /api/v1/chats with the caller's chats, most
+// recently updated first, up to ?limit= (default 50, at most 200) per page.
+// A page that is not the last carries a next_cursor to pass as ?cursor=.
+func (h *handler) listChats(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultChats
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxChats {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q; want 1 to %d", value, maxChats))
+			return
+		}
+		limit = parsed
+	}
+	page, err := h.chat.ListChats(r.Context(), principal, query.Get("cursor"), limit)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	response := chatListResponse{Chats: make([]chatResponse, 0, len(page.Chats)), NextCursor: page.NextCursor}
+	for _, chat := range page.Chats {
+		response.Chats = append(response.Chats, newChatResponse(chat))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// createChat answers POST /api/v1/chats {"model": "..."} with a new chat,
+// on the default model when the model is empty or not offered.
+func (h *handler) createChat(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	var body createChatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+	chat, err := h.chat.CreateChat(r.Context(), principal, body.Model)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newChatResponse(chat))
+}
+
+// exportChat answers GET /api/v1/chats/{chatID}/export with the chat and its
+// messages as JSON, or as Markdown with ?format=markdown. Sensitive and
+// hidden messages are left out.
+func (h *handler) exportChat(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "markdown" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q; want json or markdown", format))
+		return
+	}
+	export, err := h.chat.ExportChat(r.Context(), principal, r.PathValue("chatID"))
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	if format == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="chat-`+export.ID+`.md"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, export.Markdown())
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="chat-`+export.ID+`.json"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+// deleteAllData answers DELETE /api/v1/me?confirm=true by permanently
+// deleting everything the caller has stored, in one transaction, and
+// reporting what was removed. Runs in flight in their chats are stopped
+// first. Without confirm=true it answers 400 and deletes nothing.
+func (h *handler) deleteAllData(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	if r.URL.Query().Get("confirm") != "true" {
+		writeError(w, http.StatusBadRequest, errors.New("deleting all data is permanent; repeat the request with ?confirm=true"))
+		return
+	}
+	deleted, err := h.chat.DeleteAllData(r.Context(), principal)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dataDeletionResponse(deleted))
+}
+
+// requestDataExport answers POST /api/v1/me/exports, which asks for a zip of
+// everything the caller has stored: their chats with messages, runs, tool
+// calls and attachments, and their settings. A background job builds it, so
+// it answers 202 with the pending export, or 409 while another is built.
+func (h *handler) requestDataExport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	export, err := h.chat.RequestDataExport(r.Context(), principal)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newDataExportResponse(export))
+}
+
+// listDataExports answers GET /api/v1/me/exports with the caller's recent
+// exports.
+func (h *handler) listDataExports(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	exports, err := h.chat.DataExports(r.Context(), principal)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	response := make([]dataExportResponse, 0, len(exports))
+	for _, export := range exports {
+		response = append(response, newDataExportResponse(export))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getDataExport answers GET /api/v1/me/exports/{exportID}. A ready export
+// carries its download_url.
+func (h *handler) getDataExport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	export, err := h.chat.DataExport(r.Context(), principal, r.PathValue("exportID"))
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newDataExportResponse(export))
+}
+
+// downloadDataExport answers GET /api/v1/me/exports/{exportID}/download
+// with the archive until the export's expires_at.
+func (h *handler) downloadDataExport(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	export, archive, err := h.chat.OpenDataExport(r.Context(), principal, r.PathValue("exportID"))
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	defer archive.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="rhone-export-`+export.CreatedAt.UTC().Format("20060102")+`.zip"`)
+	// Archives on disk support range requests; those streamed from S3 are
+	// sent whole.
+	if file, ok := archive.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", export.FinishedAt.Time, file)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(export.SizeBytes, 10))
+	w.Header().Set("Last-Modified", export.FinishedAt.Time.UTC().Format(http.TimeFormat))
+	_, _ = io.Copy(w, archive)
+}
+
+// sendMessage answers POST /api/v1/chats/{chatID}/messages {"content":
+// "...", "model": "...", "run_id": "..."} by streaming the run as
+// server-sent events. Each event's SSE id is stable ("<run_id>:<seq>") and
+// its type is one of accepted, persisted, streaming or completed. A client
+// that loses the stream reads GET /api/v1/runs/{runID} to see how the run
+// ended; resending with the same run_id is safe and answers 409 with the
+// receipt once the first attempt was accepted.
+//
+// An optional "schema", a JSON schema whose root is an object, asks for
+// structured output. The completed event and the receipt carry the parsed
+// object as "structured", and a reply that does not match ends the run with
+// status error.
+func (h *handler) sendMessage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	var body sendMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	if body.IdempotencyKey == "" {
+		body.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+
+	streaming := false
+	err := h.chat.ExecuteRun(r.Context(), principal, chatsvc.APIRunRequest{
+		ChatID:         r.PathValue("chatID"),
+		Content:        body.Content,
+		Model:          body.Model,
+		RunID:          body.RunID,
+		IdempotencyKey: body.IdempotencyKey,
+		Schema:         body.Schema,
+		Locale:         h.chat.ResolveLocale(r.Header.Get("Accept-Language")),
+	}, func(event chatsvc.RunEvent) {
+		if !streaming {
+			streaming = true
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+		}
+		data, _ := json.Marshal(event)
+		// Write errors mean the client left; the run still finishes and
+		// its receipt records the outcome.
+		_, _ = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		flusher.Flush()
+	})
+	if streaming {
+		if err != nil {
+			slog.Warn("api run failed after it was accepted", "chat_id", r.PathValue("chatID"), "error", err)
+		}
+		return
+	}
+	if errors.Is(err, chatsvc.ErrRunExists) {
+		runID := body.RunID
+		var exists *chatsvc.RunExistsError
+		if errors.As(err, &exists) {
+			runID = exists.RunID
+		}
+		receipt, receiptErr := h.chat.RunReceipt(r.Context(), principal, runID)
+		if receiptErr != nil {
+			writeError(w, StatusFor(receiptErr), receiptErr)
+			return
+		}
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Receipt: &receipt})
+		return
+	}
+	writeError(w, StatusFor(err), err)
+}
+
+// previewMessage answers POST /api/v1/chats/{chatID}/preview, which takes
+// the sendMessage body less the schema, with the request the message would
+// send to the provider, without running it.
+func (h *handler) previewMessage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	var body sendMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+	locale := h.chat.ResolveLocale(r.Header.Get("Accept-Language"))
+	preview, err := h.chat.PreviewRun(r.Context(), principal, r.PathValue("chatID"), body.Content, body.Model, locale)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// getRun answers GET /api/v1/runs/{runID} with the run's receipt.
+func (h *handler) getRun(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	receipt, err := h.chat.RunReceipt(r.Context(), principal, r.PathValue("runID"))
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// cancelRun answers POST /api/v1/runs/{runID}/cancel by stopping the run
+// wherever it was started and returning its receipt, or 409 with the
+// receipt if it already ended.
+func (h *handler) cancelRun(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return
+	}
+	receipt, err := h.chat.StopRun(r.Context(), principal, r.PathValue("runID"))
+	if errors.Is(err, chatsvc.ErrRunFinished) {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Receipt: &receipt})
+		return
+	}
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// streamEvents answers GET /api/v1/events by streaming the application
+// event log (chat.created, chat.deleted, run.started, run.finished,
+// tool.executed, budget.warning) to administrators as server-sent events.
+// ?types= takes a comma-separated list to filter on. Each event's SSE id is
+// its log id, so a client reconnecting with Last-Event-ID receives the
+// recent events it missed. A client that falls too far behind is dropped.
+func (h *handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	var afterID uint64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		parsed, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %q", lastID))
+			return
+		}
+		afterID = parsed
+	}
+	var types []string
+	for _, eventType := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types = append(types, eventType)
+		}
+	}
+
+	sub := h.chat.Events().Subscribe(afterID, types...)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, open := <-sub.Events():
+			if !open {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// listBackups answers GET /api/v1/admin/backups with the SQLite backups.
+func (h *handler) listBackups(w http.ResponseWriter, r *http.Request) {
+	if !h.requireBackups(w, r) {
+		return
+	}
+	backups, err := h.backups.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+// createBackup answers POST /api/v1/admin/backups by taking a backup now.
+func (h *handler) createBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.requireBackups(w, r) {
+		return
+	}
+	created, err := h.backups.Run(r.Context())
+	if err != nil && created.Name == "" {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		// The backup was written; only pruning older ones failed.
+		slog.Warn("prune backups failed", "error", err)
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// checkpoint answers POST /api/v1/admin/checkpoint by checkpointing the
+// SQLite WAL in ?mode= (PASSIVE, the default, FULL, RESTART or TRUNCATE),
+// for replication tooling.
+func (h *handler) checkpoint(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	result, err := h.chat.Checkpoint(r.Context(), r.URL.Query().Get("mode"))
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// adminStats answers GET /api/v1/admin/stats with run counts, error rates
+// and token usage by model for the runs started in the last ?window= (a
+// duration, default 24h, or "all"), the database size and the streams in
+// flight.
+func (h *handler) adminStats(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	since, err := windowStart(r, 24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats, err := h.chat.AdminStats(r.Context(), since)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// windowStart reads ?window=, a duration or "all", as the time it starts;
+// an absent window covers fallback. "all" is the zero time.
+func windowStart(r *http.Request, fallback time.Duration) (time.Time, error) {
+	switch window := r.URL.Query().Get("window"); window {
+	case "all":
+		return time.Time{}, nil
+	case "":
+		return time.Now().UTC().Add(-fallback), nil
+	default:
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration <= 0 {
+			return time.Time{}, fmt.Errorf("invalid window %q; want a duration such as 1h or 168h, or all", window)
+		}
+		return time.Now().UTC().Add(-duration), nil
+	}
+}
+
+// analyticsResponse counts product events by day.
+type analyticsResponse struct {
+	Since  time.Time         `json:"since"`
+	Counts []analytics.Count `json:"counts"`
+}
+
+// analyticsCounts answers GET /api/v1/admin/analytics with anonymized
+// product events (chat_created, run_completed, model_switched,
+// stop_pressed) counted by UTC day, with the distinct users behind them,
+// over the last ?window= (default 168h). ?name= counts one event. It
+// answers with no events when ANALYTICS_ENABLED is off.
+func (h *handler) analyticsCounts(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	query, ok := analyticsQuery(w, r, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+	counts, err := h.chat.Analytics().Counts(r.Context(), query)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, analyticsResponse{Since: query.Since, Counts: counts})
+}
+
+// analyticsEvents answers GET /api/v1/admin/analytics/events with the
+// events themselves, newest first, over the last ?window= (default 24h), up
+// to ?limit= (default 100, at most 1000). Users appear only as salted
+// hashes.
+func (h *handler) analyticsEvents(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	query, ok := analyticsQuery(w, r, 24*time.Hour)
+	if !ok {
+		return
+	}
+	query.Limit = defaultAnalyticsEvents
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxAnalyticsEvents {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q; want 1 to %d", limit, maxAnalyticsEvents))
+			return
+		}
+		query.Limit = parsed
+	}
+	events, err := h.chat.Analytics().Events(r.Context(), query)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// analyticsQuery reads the ?window= and ?name= of an analytics request,
+// answering 400 when either is invalid.
+func analyticsQuery(w http.ResponseWriter, r *http.Request, window time.Duration) (analytics.Query, bool) {
+	since, err := windowStart(r, window)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return analytics.Query{}, false
+	}
+	name := r.URL.Query().Get("name")
+	if name != "" && !analytics.Known(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown event %q", name))
+		return analytics.Query{}, false
+	}
+	return analytics.Query{Name: name, Since: since}, true
+}
+
+// exportFineTune answers GET /api/v1/admin/finetune with OpenAI-style
+// fine-tuning JSON Lines from the chats named by repeated ?chat_id= and,
+// with ?golden=true, from the answers marked golden. ?scrub= takes a
+// comma-separated list of pii kinds to mask, or "all".
+func (h *handler) exportFineTune(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	opts := chatsvc.FineTuneOptions{ChatIDs: query["chat_id"]}
+	if golden := query.Get("golden"); golden != "" {
+		parsed, err := strconv.ParseBool(golden)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid golden %q; want true or false", golden))
+			return
+		}
+		opts.Golden = parsed
+	}
+	if scrub := query.Get("scrub"); scrub != "" {
+		opts.Scrub = strings.Split(scrub, ",")
+	}
+	examples, err := h.chat.FineTuneExamples(r.Context(), opts)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", `attachment; filename="finetune.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, example := range examples {
+		if err := encoder.Encode(example); err != nil {
+			return
+		}
+	}
+}
+
+// listWebhookTools answers GET /api/v1/admin/tools with the webhook tools,
+// tools the model calls by POSTing its input as JSON to an HTTP endpoint.
+// Their auth header values are never returned.
+func (h *handler) listWebhookTools(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	tools, err := h.chat.WebhookTools(r.Context())
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tools)
+}
+
+// saveWebhookTool answers POST /api/v1/admin/tools {"name": "...",
+// "description": "...", "input_schema": {...}, "url": "...",
+// "auth_header": "Authorization: Bearer ..."} by creating or replacing the
+// webhook tool.
+func (h *handler) saveWebhookTool(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	var body chatsvc.WebhookToolInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decode request: %w", err))
+		return
+	}
+	tool, err := h.chat.SaveWebhookTool(r.Context(), body)
+	if err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tool)
+}
+
+// deleteWebhookTool answers DELETE /api/v1/admin/tools/{name} by removing
+// the webhook tool.
+func (h *handler) deleteWebhookTool(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if err := h.chat.DeleteWebhookTool(r.Context(), r.PathValue("name")); err != nil {
+		writeError(w, StatusFor(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireBackups answers the request itself unless the caller is an
+// administrator and backups are configured.
+func (h *handler) requireBackups(w http.ResponseWriter, r *http.Request) bool {
+	if !h.requireAdmin(w, r) {
+		return false
+	}
+	if h.backups == nil {
+		writeError(w, http.StatusNotFound, errors.New("backups are not configured; set BACKUP_DIR or BLOB_STORE=s3"))
+		return false
+	}
+	return true
+}
+
+// requireAdmin answers the request itself unless the caller is an
+// administrator.
+func (h *handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+		return false
+	}
+	if !principal.HasRole("admin") {
+		writeError(w, http.StatusForbidden, errors.New("administrator role required"))
+		return false
+	}
+	return true
+}
+
+// StatusFor returns the HTTP status the API answers a service error with.
+func StatusFor(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, chatsvc.ErrChatForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, chatsvc.ErrInvalidRun), errors.Is(err, chatsvc.ErrInvalidExport), errors.Is(err, chatsvc.ErrInvalidTool), errors.Is(err, chatsvc.ErrInvalidCursor),
+		errors.Is(err, db.ErrCheckpointMode):
+		return http.StatusBadRequest
+	case errors.Is(err, chatsvc.ErrRunExists), errors.Is(err, chatsvc.ErrRunFinished), errors.Is(err, chatsvc.ErrToolExists),
+		errors.Is(err, chatsvc.ErrExportInProgress), errors.Is(err, chatsvc.ErrExportNotReady):
+		return http.StatusConflict
+	case errors.Is(err, chatsvc.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, chatsvc.ErrSpendCeilingReached), errors.Is(err, chatsvc.ErrBudgetExhausted):
+		return http.StatusPaymentRequired
+	case errors.Is(err, chatsvc.ErrContentBlocked):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, chatsvc.ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, chatsvc.ErrAttachmentType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, chatsvc.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
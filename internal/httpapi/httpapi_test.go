@@ -0,0 +1,649 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/backup"
+	"rhone_chat/internal/blob"
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+func TestSendMessageStreamsLifecycleEventsAndIsIdempotent(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.CreateChat(context.Background(), "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	api := New(service, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: auth.AnonymousUserID})))
+	}))
+	defer server.Close()
+
+	send := func() *http.Response {
+		t.Helper()
+		response, err := http.Post(server.URL+"/api/v1/chats/chat-1/messages", "application/json", strings.NewReader(`{"content":"Hello there","run_id":"run-1"}`))
+		if err != nil {
+			t.Fatalf("POST messages error = %v", err)
+		}
+		return response
+	}
+
+	response := send()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("POST messages = %d %s, want an event stream", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	var events []chatsvc.RunEvent
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event chatsvc.RunEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("decode event %q: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	response.Body.Close()
+
+	if len(events) < 4 {
+		t.Fatalf("events = %+v, want accepted, persisted, streaming and completed", events)
+	}
+	if events[0].Type != chatsvc.RunEventAccepted || events[1].Type != chatsvc.RunEventPersisted || events[2].Type != chatsvc.RunEventStreaming {
+		t.Fatalf("event types start %s, %s, %s", events[0].Type, events[1].Type, events[2].Type)
+	}
+	last := events[len(events)-1]
+	if last.Type != chatsvc.RunEventCompleted || last.Status != "completed" || !strings.Contains(last.Content, "Hello there") {
+		t.Fatalf("last event = %+v, want a completed reply echoing the message", last)
+	}
+	for i, event := range events {
+		if event.RunID != "run-1" || event.Seq != i+1 || event.ID != fmt.Sprintf("run-1:%d", i+1) || event.AssistantMessageID != last.AssistantMessageID {
+			t.Fatalf("event %d = %+v, want stable run and message ids", i, event)
+		}
+	}
+
+	// A retry with the same run id does not start another run.
+	response = send()
+	var conflict errorResponse
+	_ = json.NewDecoder(response.Body).Decode(&conflict)
+	response.Body.Close()
+	if response.StatusCode != http.StatusConflict || conflict.Receipt == nil || conflict.Receipt.Status != "completed" {
+		t.Fatalf("retry = %d %+v, want 409 with the completed receipt", response.StatusCode, conflict)
+	}
+
+	response, err = http.Get(server.URL + "/api/v1/runs/run-1")
+	if err != nil {
+		t.Fatalf("GET run error = %v", err)
+	}
+	var receipt chatsvc.RunReceipt
+	_ = json.NewDecoder(response.Body).Decode(&receipt)
+	response.Body.Close()
+	if receipt.Content != last.Content || receipt.AssistantMessageID != last.AssistantMessageID || receipt.FinishedAt == nil {
+		t.Fatalf("receipt = %+v, want the completed reply", receipt)
+	}
+
+	response, err = http.Post(server.URL+"/api/v1/runs/run-1/cancel", "", nil)
+	if err != nil {
+		t.Fatalf("POST cancel error = %v", err)
+	}
+	var cancelled errorResponse
+	_ = json.NewDecoder(response.Body).Decode(&cancelled)
+	response.Body.Close()
+	if response.StatusCode != http.StatusConflict || cancelled.Receipt == nil || cancelled.Receipt.Status != "completed" {
+		t.Fatalf("POST cancel = %d %+v, want 409 with the finished receipt", response.StatusCode, cancelled)
+	}
+
+	response, err = http.Post(server.URL+"/api/v1/chats/chat-1/preview", "application/json", strings.NewReader(`{"content":"And now?"}`))
+	if err != nil {
+		t.Fatalf("POST preview error = %v", err)
+	}
+	var preview chatsvc.RunPreview
+	_ = json.NewDecoder(response.Body).Decode(&preview)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || len(preview.Messages) != 3 || preview.Messages[2].Content != "And now?" {
+		t.Fatalf("preview = %d %+v, want the history plus the draft", response.StatusCode, preview)
+	}
+
+	response, err = http.Post(server.URL+"/api/v1/chats/missing/messages", "application/json", strings.NewReader(`{"content":"Hi"}`))
+	if err != nil {
+		t.Fatalf("POST missing chat error = %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("POST missing chat = %d, want 404", response.StatusCode)
+	}
+}
+
+func TestSendMessageWithAnIdempotencyKeyIsStoredOnce(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.CreateChat(context.Background(), "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	api := New(service, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: auth.AnonymousUserID})))
+	}))
+	defer server.Close()
+
+	send := func() *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/chats/chat-1/messages", strings.NewReader(`{"content":"Hello there"}`))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Idempotency-Key", "send-7f3a")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("POST messages error = %v", err)
+		}
+		return response
+	}
+
+	response := send()
+	_, _ = io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("POST messages = %d, want the run streamed", response.StatusCode)
+	}
+
+	response = send()
+	var conflict errorResponse
+	_ = json.NewDecoder(response.Body).Decode(&conflict)
+	response.Body.Close()
+	if response.StatusCode != http.StatusConflict || conflict.Receipt == nil || conflict.Receipt.IdempotencyKey != "send-7f3a" || conflict.Receipt.Status != "completed" {
+		t.Fatalf("resend = %d %+v, want 409 with the first run's receipt", response.StatusCode, conflict)
+	}
+	messages, err := store.ListMessages(context.Background(), "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want one user message and one reply", len(messages))
+	}
+}
+
+func TestAdminBackupsRequireTheAdminRole(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	api := New(service, backup.New(store, blob.NewDisk(filepath.Join(t.TempDir(), "backups")), t.TempDir(), 3))
+
+	call := func(method string, principal auth.Principal) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, "/api/v1/admin/backups", nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+		return recorder
+	}
+
+	if response := call(http.MethodPost, auth.Principal{UserID: "user-1"}); response.Code != http.StatusForbidden {
+		t.Fatalf("POST backups as a user = %d, want 403", response.Code)
+	}
+	admin := auth.Principal{UserID: "admin-1", Roles: []string{"admin"}}
+	response := call(http.MethodPost, admin)
+	var created backup.Backup
+	_ = json.NewDecoder(response.Body).Decode(&created)
+	if response.Code != http.StatusCreated || created.Name == "" || created.SizeBytes == 0 {
+		t.Fatalf("POST backups = %d %+v, want a new backup", response.Code, created)
+	}
+	response = call(http.MethodGet, admin)
+	var listed []backup.Backup
+	_ = json.NewDecoder(response.Body).Decode(&listed)
+	if response.Code != http.StatusOK || len(listed) != 1 || listed[0].Name != created.Name {
+		t.Fatalf("GET backups = %d %+v, want the new backup", response.Code, listed)
+	}
+
+	api = New(service, nil)
+	if response := call(http.MethodGet, admin); response.Code != http.StatusNotFound {
+		t.Fatalf("GET backups without BACKUP_DIR = %d, want 404", response.Code)
+	}
+}
+
+func TestAdminCheckpointTruncatesTheWAL(t *testing.T) {
+	store, err := db.OpenSQLiteWith(filepath.Join(t.TempDir(), "api.sqlite"), db.Options{
+		BusyTimeout:       time.Second,
+		Synchronous:       "FULL",
+		WALAutocheckpoint: 0,
+	})
+	if err != nil {
+		t.Fatalf("OpenSQLiteWith() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	admin := auth.Principal{UserID: "admin-1", Roles: []string{"admin"}}
+	if _, err := service.CreateChat(context.Background(), admin, ai.MockModel); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	api := New(service, nil)
+	call := func(query string, principal auth.Principal) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/admin/checkpoint"+query, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+		return recorder
+	}
+
+	if response := call("", auth.Principal{UserID: "user-1"}); response.Code != http.StatusForbidden {
+		t.Fatalf("POST checkpoint as a user = %d, want 403", response.Code)
+	}
+	if response := call("?mode=sideways", admin); response.Code != http.StatusBadRequest {
+		t.Fatalf("POST checkpoint with an unknown mode = %d, want 400", response.Code)
+	}
+	// With automatic checkpoints off, the chat is still only in the WAL.
+	response := call("?mode=passive", admin)
+	var result db.CheckpointResult
+	_ = json.NewDecoder(response.Body).Decode(&result)
+	if response.Code != http.StatusOK || result.Mode != db.CheckpointPassive || result.WALFrames == 0 || result.Checkpointed != result.WALFrames {
+		t.Fatalf("POST checkpoint = %d %+v, want the WAL checkpointed", response.Code, result)
+	}
+	response = call("?mode=truncate", admin)
+	result = db.CheckpointResult{}
+	_ = json.NewDecoder(response.Body).Decode(&result)
+	if response.Code != http.StatusOK || result.Busy || result.WALFrames != 0 {
+		t.Fatalf("POST checkpoint?mode=truncate = %d %+v, want an empty WAL", response.Code, result)
+	}
+}
+
+func TestEventStreamResumesFromLastEventIDForAdmins(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	admin := auth.Principal{UserID: "admin-1", Roles: []string{"admin"}}
+	api := New(service, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), admin)))
+	}))
+	defer server.Close()
+
+	first, err := service.CreateChat(context.Background(), admin, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	second, err := service.CreateChat(context.Background(), admin, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if err := service.DeleteChat(context.Background(), first.ID); err != nil {
+		t.Fatalf("DeleteChat() error = %v", err)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/events?types=chat.created", nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET events error = %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET events = %d %s, want an event stream", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	scanner := bufio.NewScanner(response.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || lines[0] != "id: 2" || lines[1] != "event: chat.created" || !strings.Contains(lines[2], second.ID) {
+		t.Fatalf("first event = %q, want chat %s created as event 2", lines, second.ID)
+	}
+
+	forbidden := httptest.NewRequest(http.MethodGet, "/api/v1/events", nil)
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, forbidden.WithContext(auth.WithPrincipal(forbidden.Context(), auth.Principal{UserID: "user-1"})))
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("GET events as a user = %d, want 403", recorder.Code)
+	}
+}
+
+func TestAdminStatsCountRunsByModel(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.CreateChat(context.Background(), "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	anonymous := auth.Principal{UserID: auth.AnonymousUserID}
+	if err := service.ExecuteRun(context.Background(), anonymous, chatsvc.APIRunRequest{ChatID: "chat-1", Content: "Hello"}, func(chatsvc.RunEvent) {}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+
+	// The single user of a server without authentication is not an
+	// administrator.
+	api := New(service, nil)
+	call := func(path string, principal auth.Principal) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+		return recorder
+	}
+	if response := call("/api/v1/admin/stats", anonymous); response.Code != http.StatusForbidden {
+		t.Fatalf("GET stats as anonymous = %d, want 403", response.Code)
+	}
+	admin := auth.Principal{UserID: auth.AdminTokenUserID, Roles: []string{"admin"}}
+	if response := call("/api/v1/admin/stats?window=soon", admin); response.Code != http.StatusBadRequest {
+		t.Fatalf("GET stats with a bad window = %d, want 400", response.Code)
+	}
+	response := call("/api/v1/admin/stats?window=1h", admin)
+	var stats chatsvc.AdminStats
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if response.Code != http.StatusOK || stats.Runs.Total != 1 || stats.Runs.Completed != 1 || stats.Runs.ErrorRate != 0 {
+		t.Fatalf("GET stats = %d %+v, want one completed run", response.Code, stats.Runs)
+	}
+	if len(stats.Models) != 1 || stats.Models[0].Model != ai.MockModel || stats.DatabaseBytes == 0 || stats.Live.ActiveStreams != 0 {
+		t.Fatalf("stats = %+v, want the mock model, the database size and no live streams", stats)
+	}
+}
+
+func TestAdminFineTuneExportWritesScrubbedJSONLines(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.CreateChat(context.Background(), "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10, SystemPrompt: "Be brief."})
+	anonymous := auth.Principal{UserID: auth.AnonymousUserID}
+	if err := service.ExecuteRun(context.Background(), anonymous, chatsvc.APIRunRequest{ChatID: "chat-1", Content: "Write to ada@example.com"}, func(chatsvc.RunEvent) {}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+
+	api := New(service, nil)
+	admin := auth.Principal{UserID: auth.AdminTokenUserID, Roles: []string{"admin"}}
+	call := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), admin)))
+		return recorder
+	}
+	if response := call("/api/v1/admin/finetune"); response.Code != http.StatusBadRequest {
+		t.Fatalf("GET finetune without a selection = %d, want 400", response.Code)
+	}
+	if response := call("/api/v1/admin/finetune?chat_id=chat-1&scrub=ssn"); response.Code != http.StatusBadRequest {
+		t.Fatalf("GET finetune with an unknown scrub kind = %d, want 400", response.Code)
+	}
+
+	response := call("/api/v1/admin/finetune?chat_id=chat-1&scrub=email")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/jsonl" {
+		t.Fatalf("GET finetune = %d %q, want JSON Lines", response.Code, response.Header().Get("Content-Type"))
+	}
+	if strings.Contains(response.Body.String(), "ada@example.com") {
+		t.Fatalf("export = %s, want the email scrubbed", response.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("export has %d lines, want one per chat", len(lines))
+	}
+	var example chatsvc.FineTuneExample
+	if err := json.Unmarshal([]byte(lines[0]), &example); err != nil {
+		t.Fatalf("decode example: %v", err)
+	}
+	messages := example.Messages
+	if len(messages) != 3 || messages[0].Role != "system" || messages[0].Content != "Be brief." || messages[1].Content != "Write to [email]" || messages[2].Role != "assistant" {
+		t.Fatalf("example = %+v, want the system prompt, the scrubbed prompt and the answer", messages)
+	}
+}
+
+func TestAdminWebhookToolsHideTheAuthHeader(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	api := New(service, nil)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), auth.Principal{UserID: auth.AdminTokenUserID, Roles: []string{"admin"}})))
+		return recorder
+	}
+
+	if response := call(http.MethodPost, "/api/v1/admin/tools", `{"name":"web_search","url":"https://tools.example.com"}`); response.Code != http.StatusConflict {
+		t.Fatalf("POST tools over a built-in = %d, want 409", response.Code)
+	}
+	if response := call(http.MethodPost, "/api/v1/admin/tools", `{"name":"weather","url":"https://tools.example.com","input_schema":{"type":"string"}}`); response.Code != http.StatusBadRequest {
+		t.Fatalf("POST tools with a non-object schema = %d, want 400", response.Code)
+	}
+	response := call(http.MethodPost, "/api/v1/admin/tools", `{"name":"weather","url":"https://tools.example.com","auth_header":"Authorization: Bearer secret"}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "secret") {
+		t.Fatalf("POST tools = %d %s, want the tool without the header value", response.Code, response.Body.String())
+	}
+	response = call(http.MethodGet, "/api/v1/admin/tools", "")
+	var tools []chatsvc.WebhookToolInfo
+	if err := json.Unmarshal(response.Body.Bytes(), &tools); err != nil || len(tools) != 1 || tools[0].AuthHeaderName != "Authorization" {
+		t.Fatalf("GET tools = %s, %v", response.Body.String(), err)
+	}
+	if response := call(http.MethodDelete, "/api/v1/admin/tools/weather", ""); response.Code != http.StatusNoContent {
+		t.Fatalf("DELETE tool = %d, want 204", response.Code)
+	}
+	if response := call(http.MethodDelete, "/api/v1/admin/tools/weather", ""); response.Code != http.StatusNotFound {
+		t.Fatalf("DELETE missing tool = %d, want 404", response.Code)
+	}
+}
+
+func TestAdminAnalyticsCountsProductEvents(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, AnalyticsEnabled: true})
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob", "alice"} {
+		if _, err := service.CreateChat(ctx, auth.Principal{UserID: user}, ai.MockModel); err != nil {
+			t.Fatalf("CreateChat() error = %v", err)
+		}
+	}
+	api := New(service, nil)
+	call := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), auth.Principal{UserID: auth.AdminTokenUserID, Roles: []string{"admin"}})))
+		return recorder
+	}
+
+	if response := call("/api/v1/admin/analytics?name=page_viewed"); response.Code != http.StatusBadRequest {
+		t.Fatalf("GET analytics for an unknown event = %d, want 400", response.Code)
+	}
+	response := call("/api/v1/admin/analytics?name=chat_created")
+	var summary struct {
+		Counts []analytics.Count `json:"counts"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &summary); err != nil || len(summary.Counts) != 1 || summary.Counts[0].Events != 3 || summary.Counts[0].Actors != 2 {
+		t.Fatalf("GET analytics = %d %s, want 3 chats by 2 users", response.Code, response.Body.String())
+	}
+	response = call("/api/v1/admin/analytics/events?limit=2")
+	var events []analytics.Event
+	if err := json.Unmarshal(response.Body.Bytes(), &events); err != nil || len(events) != 2 || strings.Contains(response.Body.String(), "alice") {
+		t.Fatalf("GET analytics events = %d %s, want two anonymized events", response.Code, response.Body.String())
+	}
+}
+
+func TestChatsAreListedCreatedAndExported(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	api := New(service, nil)
+	call := func(userID, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), auth.Principal{UserID: userID})))
+		return recorder
+	}
+
+	response := call("alice", http.MethodPost, "/api/v1/chats", `{"model":"no-such-model"}`)
+	var created chatResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &created); err != nil || response.Code != http.StatusCreated || created.Model != ai.MockModel {
+		t.Fatalf("POST chats = %d %s, want a chat on the default model", response.Code, response.Body.String())
+	}
+	if response := call("alice", http.MethodPost, "/api/v1/chats/"+created.ID+"/messages", `{"content":"Hello there"}`); response.Code != http.StatusOK {
+		t.Fatalf("POST messages = %d %s", response.Code, response.Body.String())
+	}
+	call("alice", http.MethodPost, "/api/v1/chats", `{}`)
+
+	response = call("alice", http.MethodGet, "/api/v1/chats?limit=1", "")
+	var page chatListResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil || len(page.Chats) != 1 || page.NextCursor == "" {
+		t.Fatalf("GET chats?limit=1 = %s, want one chat and a cursor", response.Body.String())
+	}
+	response = call("alice", http.MethodGet, "/api/v1/chats?cursor="+page.NextCursor, "")
+	var rest chatListResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &rest); err != nil || len(rest.Chats) != 1 || rest.NextCursor != "" || rest.Chats[0].ID == page.Chats[0].ID {
+		t.Fatalf("GET chats second page = %s, want the other chat", response.Body.String())
+	}
+	if response := call("alice", http.MethodGet, "/api/v1/chats?cursor=nonsense", ""); response.Code != http.StatusBadRequest {
+		t.Fatalf("GET chats with a bad cursor = %d, want 400", response.Code)
+	}
+
+	response = call("alice", http.MethodGet, "/api/v1/chats/"+created.ID+"/export", "")
+	var export chatsvc.ChatExport
+	if err := json.Unmarshal(response.Body.Bytes(), &export); err != nil || len(export.Messages) != 2 || export.Messages[0].Content != "Hello there" {
+		t.Fatalf("GET export = %s, want the prompt and its reply", response.Body.String())
+	}
+	response = call("alice", http.MethodGet, "/api/v1/chats/"+created.ID+"/export?format=markdown", "")
+	if !strings.HasPrefix(response.Header().Get("Content-Type"), "text/markdown") || !strings.Contains(response.Body.String(), "## User") || !strings.Contains(response.Body.String(), "Hello there") {
+		t.Fatalf("GET export?format=markdown = %s", response.Body.String())
+	}
+	if response := call("bob", http.MethodGet, "/api/v1/chats/"+created.ID+"/export", ""); response.Code != http.StatusForbidden {
+		t.Fatalf("GET another user's export = %d, want 403", response.Code)
+	}
+}
+
+func TestDataExportIsDownloadableOnceBuilt(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:  ai.MockModel,
+		MaxHistory:    10,
+		DataExportDir: t.TempDir(),
+		DataExportTTL: time.Hour,
+	})
+	api := New(service, nil)
+	call := func(userID, method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		request := httptest.NewRequest(method, path, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), auth.Principal{UserID: userID})))
+		return recorder
+	}
+	response := call("alice", http.MethodPost, "/api/v1/me/exports")
+	var requested dataExportResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &requested); err != nil || response.Code != http.StatusAccepted || requested.Status != chatsvc.DataExportPending {
+		t.Fatalf("POST exports = %d %s, want a pending export", response.Code, response.Body.String())
+	}
+	if response := call("alice", http.MethodPost, "/api/v1/me/exports"); response.Code != http.StatusConflict {
+		t.Fatalf("POST exports again = %d, want 409", response.Code)
+	}
+	if response := call("alice", http.MethodGet, "/api/v1/me/exports/"+requested.ID+"/download"); response.Code != http.StatusConflict {
+		t.Fatalf("GET download before it is built = %d, want 409", response.Code)
+	}
+	if err := service.BuildDataExports(context.Background(), time.Now().UTC(), nil); err != nil {
+		t.Fatalf("BuildDataExports() error = %v", err)
+	}
+
+	response = call("alice", http.MethodGet, "/api/v1/me/exports/"+requested.ID)
+	var ready dataExportResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &ready); err != nil || ready.Status != chatsvc.DataExportReady || ready.DownloadURL == "" || ready.ExpiresAt == nil {
+		t.Fatalf("GET export = %s, want ready with a download URL", response.Body.String())
+	}
+	response = call("alice", http.MethodGet, ready.DownloadURL)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("GET download = %d %s", response.Code, response.Header())
+	}
+	if _, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len())); err != nil {
+		t.Fatalf("downloaded archive: %v", err)
+	}
+	if response := call("bob", http.MethodGet, ready.DownloadURL); response.Code != http.StatusNotFound {
+		t.Fatalf("GET another user's download = %d, want 404", response.Code)
+	}
+}
+
+func TestDeleteAllDataNeedsConfirmation(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "api.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	api := New(service, nil)
+	alice := auth.Principal{UserID: "alice"}
+	chat, err := service.CreateChat(context.Background(), alice, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodDelete, path, nil)
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request.WithContext(auth.WithPrincipal(request.Context(), alice)))
+		return recorder
+	}
+
+	if response := call("/api/v1/me"); response.Code != http.StatusBadRequest {
+		t.Fatalf("DELETE me without confirm = %d, want 400", response.Code)
+	}
+	if _, err := store.GetChat(context.Background(), chat.ID); err != nil {
+		t.Fatalf("GetChat() after an unconfirmed delete error = %v", err)
+	}
+	response := call("/api/v1/me?confirm=true")
+	var deleted dataDeletionResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &deleted); err != nil || response.Code != http.StatusOK || deleted.Chats != 1 {
+		t.Fatalf("DELETE me = %d %s, want one chat deleted", response.Code, response.Body.String())
+	}
+}
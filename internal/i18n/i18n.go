@@ -0,0 +1,233 @@
+// Package i18n holds the chat UI's strings as locale bundles, one JSON file
+// per language under locales/. English is the reference bundle: a key
+// missing from another language falls back to its English text.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the language used when neither the user nor the browser asks
+// for a supported one.
+const Default = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Language is a bundled language, named in that language for the selector.
+type Language struct {
+	Tag  string
+	Name string
+}
+
+type bundle struct {
+	Name     string            `json:"name"`
+	Messages map[string]string `json:"messages"`
+}
+
+var (
+	languages  []Language
+	localizers = map[string]Localizer{}
+)
+
+func init() {
+	bundles, err := loadBundles()
+	if err != nil {
+		panic(err)
+	}
+	reference := bundles[Default]
+	for tag, b := range bundles {
+		messages := make(map[string]string, len(reference.Messages))
+		for key, text := range reference.Messages {
+			messages[key] = text
+		}
+		for key, text := range b.Messages {
+			messages[key] = text
+		}
+		localizers[tag] = Localizer{lang: tag, messages: messages}
+		languages = append(languages, Language{Tag: tag, Name: b.Name})
+	}
+	// English first, then the rest by tag.
+	sort.Slice(languages, func(i, j int) bool {
+		if (languages[i].Tag == Default) != (languages[j].Tag == Default) {
+			return languages[i].Tag == Default
+		}
+		return languages[i].Tag < languages[j].Tag
+	})
+}
+
+func loadBundles() (map[string]bundle, error) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	bundles := make(map[string]bundle, len(entries))
+	for _, entry := range entries {
+		tag := strings.TrimSuffix(entry.Name(), ".json")
+		raw, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var b bundle
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("locale %s: %w", tag, err)
+		}
+		if b.Name == "" {
+			return nil, fmt.Errorf("locale %s: missing name", tag)
+		}
+		bundles[tag] = b
+	}
+	if _, ok := bundles[Default]; !ok {
+		return nil, fmt.Errorf("locale %s is missing", Default)
+	}
+	return bundles, nil
+}
+
+// Languages returns the bundled languages, English first.
+func Languages() []Language {
+	return append([]Language(nil), languages...)
+}
+
+// Supported reports whether tag names a bundled language.
+func Supported(tag string) bool {
+	_, ok := localizers[tag]
+	return ok
+}
+
+// For returns the localizer for tag, or the English one when tag is not
+// bundled.
+func For(tag string) Localizer {
+	if localizer, ok := localizers[strings.ToLower(tag)]; ok {
+		return localizer
+	}
+	return localizers[Default]
+}
+
+// Match picks the bundled language for an Accept-Language header: the
+// first preference that is bundled, tried exactly and then by its base
+// language so "fr-CA" gets French. Unlike system prompts, later
+// preferences count here, since any bundled language beats the fallback.
+func Match(acceptLanguage string) string {
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		if Supported(tag) {
+			return tag
+		}
+		if base, _, found := strings.Cut(tag, "-"); found && Supported(base) {
+			return base
+		}
+	}
+	return Default
+}
+
+// ParseAcceptLanguage returns the lowercase language tags of an
+// Accept-Language header, most preferred first. Wildcards and tags with
+// q=0 are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = parsed
+				}
+			}
+		}
+		if weight <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: strings.ReplaceAll(tag, "_", "-"), weight: weight})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].weight > tags[j].weight
+	})
+	out := make([]string, len(tags))
+	for i, tag := range tags {
+		out[i] = tag.tag
+	}
+	return out
+}
+
+// Localizer looks up UI strings in one language.
+type Localizer struct {
+	lang     string
+	messages map[string]string
+}
+
+// Lang returns the localizer's language tag.
+func (l Localizer) Lang() string {
+	return l.lang
+}
+
+// T returns the text for key, formatted with args as by fmt.Sprintf when
+// any are given. An unknown key comes back as itself so a missing string
+// shows up on the page instead of an empty label.
+func (l Localizer) T(key string, args ...any) string {
+	text, ok := l.messages[key]
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Lookup returns the text for key and whether the bundle has it.
+func (l Localizer) Lookup(key string) (string, bool) {
+	text, ok := l.messages[key]
+	return text, ok
+}
+
+// Messages returns the texts for keys, for handing to islands that render
+// their own labels.
+func (l Localizer) Messages(keys ...string) map[string]string {
+	out := make(map[string]string, len(keys))
+	for _, key := range keys {
+		out[key] = l.T(key)
+	}
+	return out
+}
+
+// RelativeTime describes t as seen at now: "just now", "5m ago", "3h ago"
+// or "2d ago" within a week, and the date in location after that. The
+// relative-time island formats the same way in the browser.
+func (l Localizer) RelativeTime(t, now time.Time, location *time.Location) string {
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < time.Minute:
+		return l.T("time.just_now")
+	case elapsed < time.Hour:
+		return l.T("time.minutes_ago", int(elapsed/time.Minute))
+	case elapsed < 24*time.Hour:
+		return l.T("time.hours_ago", int(elapsed/time.Hour))
+	case elapsed < 7*24*time.Hour:
+		return l.T("time.days_ago", int(elapsed/(24*time.Hour)))
+	}
+	local := t.In(location)
+	if local.Year() != now.In(location).Year() {
+		return local.Format(l.T("time.date_with_year"))
+	}
+	return local.Format(l.T("time.date"))
+}
+
+// AbsoluteTime formats t in location for a timestamp's tooltip.
+func (l Localizer) AbsoluteTime(t time.Time, location *time.Location) string {
+	return t.In(location).Format(l.T("time.absolute"))
+}
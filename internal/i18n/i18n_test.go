@@ -0,0 +1,107 @@
+package i18n
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+var verbPattern = regexp.MustCompile(`%[a-z]`)
+
+func TestBundlesMatchTheEnglishKeysAndVerbs(t *testing.T) {
+	bundles, err := loadBundles()
+	if err != nil {
+		t.Fatalf("loadBundles() error = %v", err)
+	}
+	reference := bundles[Default].Messages
+	for tag, b := range bundles {
+		for key, text := range b.Messages {
+			english, ok := reference[key]
+			if !ok {
+				t.Errorf("%s: key %q is not in the English bundle", tag, key)
+				continue
+			}
+			// Translations take the same arguments in the same order.
+			if got, want := verbPattern.FindAllString(text, -1), verbPattern.FindAllString(english, -1); !slices.Equal(got, want) {
+				t.Errorf("%s: %q uses %v, want %v", tag, key, got, want)
+			}
+		}
+		for key := range reference {
+			if _, ok := b.Messages[key]; !ok {
+				t.Errorf("%s: missing %q", tag, key)
+			}
+		}
+	}
+}
+
+func TestLocalizerFallsBack(t *testing.T) {
+	if got := For("fr").T("sidebar.new_chat"); got != "Nouvelle discussion" {
+		t.Fatalf("fr new chat = %q", got)
+	}
+	if got := For("xx").T("sidebar.signed_in_as", "ada"); got != "Signed in as ada" {
+		t.Fatalf("unknown language = %q, want English", got)
+	}
+	if got := For("en").T("no.such.key"); got != "no.such.key" {
+		t.Fatalf("unknown key = %q, want the key", got)
+	}
+	if For("FR").Lang() != "fr" {
+		t.Fatalf("For(FR).Lang() = %q", For("FR").Lang())
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := map[string]string{
+		"":                        "en",
+		"fr-CA,fr;q=0.9,en;q=0.8": "fr",
+		"de-DE,es;q=0.5":          "es",
+		"de, ja;q=0.8":            "en",
+		"es;q=0, fr;q=0.4":        "fr",
+		"*":                       "en",
+	}
+	for header, want := range tests {
+		if got := Match(header); got != want {
+			t.Errorf("Match(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestLanguagesListEnglishFirst(t *testing.T) {
+	languages := Languages()
+	if len(languages) < 2 || languages[0].Tag != Default || languages[0].Name != "English" {
+		t.Fatalf("Languages() = %+v", languages)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	en := For("en")
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{now.Add(-20 * time.Second), "just now"},
+		{now.Add(time.Minute), "just now"},
+		{now.Add(-2 * time.Minute), "2m ago"},
+		{now.Add(-5 * time.Hour), "5h ago"},
+		{now.Add(-3 * 24 * time.Hour), "3d ago"},
+		{time.Date(2026, 2, 1, 20, 0, 0, 0, time.UTC), "Feb 2"},
+		{time.Date(2025, 12, 31, 16, 0, 0, 0, time.UTC), "Jan 1"},
+		{time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), "Jun 1, 2025"},
+	}
+	for _, test := range tests {
+		if got := en.RelativeTime(test.at, now, tokyo); got != test.want {
+			t.Errorf("RelativeTime(%s) = %q, want %q", test.at, got, test.want)
+		}
+	}
+	if got := For("fr").RelativeTime(now.Add(-2*time.Minute), now, tokyo); got != "il y a 2 min" {
+		t.Errorf("fr RelativeTime = %q", got)
+	}
+	if got := For("fr").RelativeTime(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), now, tokyo); got != "01/06/2025" {
+		t.Errorf("fr RelativeTime = %q", got)
+	}
+}
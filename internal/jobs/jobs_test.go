@@ -0,0 +1,162 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+func TestParseNext(t *testing.T) {
+	after := time.Date(2026, 1, 30, 10, 17, 42, 0, time.UTC) // a Friday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 1, 30, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 1, 30, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2026, 2, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2026, 1, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7)},
+		{"@every 90m", after.Add(90 * time.Minute)},
+	}
+	for _, tc := range cases {
+		schedule, err := Parse(tc.spec, nil)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tc.spec, err)
+		}
+		if got := schedule.Next(after); !got.Equal(tc.want) {
+			t.Errorf("Parse(%q).Next() = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+
+	if got := mustParse(t, "0 0 30 2 *").Next(after); !got.IsZero() {
+		t.Errorf("impossible schedule Next() = %s, want zero", got)
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@every 10ms"} {
+		if _, err := Parse(spec, nil); err == nil {
+			t.Errorf("Parse(%q) error = nil, want an error", spec)
+		}
+	}
+}
+
+func TestSchedulerCatchesUpMissedRunOnceAndPersistsState(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	missed := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Second)
+	if err := store.SaveJobState(ctx, db.JobState{
+		Name:      "rollup",
+		Schedule:  "@every 1h0m0s",
+		NextRunAt: sql.NullTime{Time: missed, Valid: true},
+		UpdatedAt: time.Now().UTC(),
+	}); err != nil {
+		t.Fatalf("SaveJobState() error = %v", err)
+	}
+
+	scheduler := New(store, nil)
+	ran := make(chan time.Time, 4)
+	if err := scheduler.Register("rollup", Every(time.Hour), func(ctx context.Context, scheduled time.Time) error {
+		ran <- scheduled
+		return errors.New("partial rollup")
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := scheduler.Register("rollup", Every(time.Hour), func(context.Context, time.Time) error { return nil }); err == nil {
+		t.Fatal("Register() duplicate error = nil")
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	scheduler.Start(runCtx)
+	select {
+	case scheduled := <-ran:
+		if !scheduled.Equal(missed) {
+			t.Fatalf("scheduled = %s, want the missed time %s", scheduled, missed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("missed run was not caught up")
+	}
+	waitFor(t, func() bool {
+		state, err := store.GetJobState(ctx, "rollup")
+		return err == nil && state.RunCount == 1 && state.NextRunAt.Time.After(time.Now())
+	})
+	cancel()
+	scheduler.Wait()
+	if len(ran) != 0 {
+		t.Fatalf("job ran %d extra times, want one catch-up run", len(ran))
+	}
+
+	states, err := scheduler.Jobs(ctx)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Jobs() = %+v, %v; want one job", states, err)
+	}
+	state := states[0]
+	if state.LastStatus != StatusError || state.LastError != "partial rollup" || !state.LastFinishedAt.Valid {
+		t.Fatalf("state = %+v, want the failed run recorded", state)
+	}
+}
+
+func TestSchedulerRecoversFromPanics(t *testing.T) {
+	store := newTestStore(t)
+	scheduler := New(store, nil)
+	if err := scheduler.Register("boom", Every(time.Hour), func(context.Context, time.Time) error {
+		panic("nil map")
+	}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := store.SaveJobState(ctx, db.JobState{
+		Name:      "boom",
+		Schedule:  "@every 1h0m0s",
+		NextRunAt: sql.NullTime{Time: time.Now().Add(-time.Minute).UTC(), Valid: true},
+		UpdatedAt: time.Now().UTC(),
+	}); err != nil {
+		t.Fatalf("SaveJobState() error = %v", err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	scheduler.Start(runCtx)
+	waitFor(t, func() bool {
+		state, err := store.GetJobState(ctx, "boom")
+		return err == nil && state.LastStatus == StatusError && state.LastError == "panic: nil map"
+	})
+	cancel()
+	scheduler.Wait()
+}
+
+func mustParse(t *testing.T, spec string) Schedule {
+	t.Helper()
+	schedule, err := Parse(spec, nil)
+	if err != nil {
+		t.Fatalf("Parse(%q) error = %v", spec, err)
+	}
+	return schedule
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestStore(t *testing.T) *db.Store {
+	t.Helper()
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the first run time strictly after a given time.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Every runs a job at a fixed interval.
+type Every time.Duration
+
+func (e Every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+func (e Every) String() string {
+	return "@every " + time.Duration(e).String()
+}
+
+// cronSchedule is a parsed five-field cron expression.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a day field starting with "*": when both
+	// day fields are restricted, a day matching either runs, as in cron.
+	domAny, dowAny bool
+	location       *time.Location
+	spec           string
+}
+
+// searchLimit bounds how far ahead Next looks for a matching time, so an
+// impossible expression such as "0 0 30 2 *" ends instead of spinning.
+const searchLimit = 5 * 366 * 24 * time.Hour
+
+// Parse reads a cron expression ("minute hour day-of-month month
+// day-of-week", with *, lists, ranges and /steps) or one of @hourly,
+// @daily, @weekly, @monthly and "@every <duration>". Times are matched in
+// location, or UTC when it is nil.
+func Parse(spec string, location *time.Location) (Schedule, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	spec = strings.TrimSpace(spec)
+	original := spec
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+	if value, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("schedule %q: @every needs a duration of at least 1s", spec)
+		}
+		return Every(interval), nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields, got %d", spec, len(fields))
+	}
+	schedule := &cronSchedule{location: location, spec: original}
+	bounds := []struct {
+		name        string
+		first, last int
+		bits        *uint64
+	}{
+		{"minute", 0, 59, &schedule.minute},
+		{"hour", 0, 23, &schedule.hour},
+		{"day of month", 1, 31, &schedule.dom},
+		{"month", 1, 12, &schedule.month},
+		{"day of week", 0, 7, &schedule.dow},
+	}
+	for i, bound := range bounds {
+		bits, err := parseField(fields[i], bound.first, bound.last)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %s: %w", spec, bound.name, err)
+		}
+		*bound.bits = bits
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1 // 7 is Sunday too
+	}
+	schedule.domAny = strings.HasPrefix(fields[2], "*")
+	schedule.dowAny = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// parseField returns the values a cron field allows as a bit set.
+func parseField(field string, first, last int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("bad step %q", stepPart)
+			}
+			step = parsed
+		}
+		low, high := first, last
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("bad value %q", from)
+			}
+			if high, err = strconv.Atoi(to); err != nil {
+				return 0, fmt.Errorf("bad value %q", to)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", rangePart)
+			}
+			low = value
+			high = value
+			if hasStep {
+				high = last
+			}
+		}
+		if low < first || high > last || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, first, last)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(searchLimit)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.location)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) String() string {
+	if c.location == time.UTC {
+		return c.spec
+	}
+	return c.spec + " " + c.location.String()
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
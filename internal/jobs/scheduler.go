@@ -0,0 +1,191 @@
+// Package jobs runs background work on cron-style schedules. Each job's next
+// run time and last outcome are kept in the jobs table, so a run missed while
+// the server was down happens once at startup instead of being skipped.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+// Func does a job's work. scheduled is the time the run was due, which is in
+// the past when a missed run is caught up.
+type Func func(ctx context.Context, scheduled time.Time) error
+
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusError     = "error"
+)
+
+type job struct {
+	name     string
+	schedule Schedule
+	run      Func
+}
+
+// Scheduler runs registered jobs until its context is cancelled.
+type Scheduler struct {
+	store  *db.Store
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	jobs    []job
+	started bool
+	wg      sync.WaitGroup
+}
+
+func New(store *db.Store, logger *slog.Logger) *Scheduler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Scheduler{store: store, logger: logger, now: time.Now}
+}
+
+// Register adds a job. Names identify the stored state, so they must be
+// unique and stable across restarts.
+func (s *Scheduler) Register(name string, schedule Schedule, run Func) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("register job %q: scheduler already started", name)
+	}
+	if name == "" || schedule == nil || run == nil {
+		return fmt.Errorf("register job %q: name, schedule and func are required", name)
+	}
+	for _, existing := range s.jobs {
+		if existing.name == name {
+			return fmt.Errorf("register job %q: already registered", name)
+		}
+	}
+	s.jobs = append(s.jobs, job{name: name, schedule: schedule, run: run})
+	return nil
+}
+
+// Start runs every registered job in its own goroutine until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+}
+
+// Wait blocks until every job goroutine has returned, letting runs in
+// progress finish during shutdown.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Jobs returns the stored state of every job that has been scheduled.
+func (s *Scheduler) Jobs(ctx context.Context) ([]db.JobState, error) {
+	return s.store.ListJobStates(ctx)
+}
+
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	logger := s.logger.With("job", j.name)
+	spec := describe(j.schedule)
+	state, err := s.store.GetJobState(ctx, j.name)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		logger.Warn("load job state failed", "error", err)
+	}
+	state.Name = j.name
+	if !state.NextRunAt.Valid || state.Schedule != spec {
+		state.NextRunAt = nullTime(j.schedule.Next(s.now()))
+	}
+	state.Schedule = spec
+	s.save(ctx, logger, &state)
+
+	for {
+		if !state.NextRunAt.Valid {
+			logger.Warn("job has no upcoming run time; disabled")
+			return
+		}
+		scheduled := state.NextRunAt.Time
+		timer := time.NewTimer(scheduled.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.execute(ctx, logger, j, &state, scheduled)
+		if ctx.Err() != nil {
+			// Leave next_run_at in the past so the interrupted run is
+			// retried at the next startup.
+			return
+		}
+		state.NextRunAt = nullTime(j.schedule.Next(s.now()))
+		s.save(ctx, logger, &state)
+	}
+}
+
+func (s *Scheduler) execute(ctx context.Context, logger *slog.Logger, j job, state *db.JobState, scheduled time.Time) {
+	state.LastStartedAt = nullTime(s.now())
+	state.LastStatus = StatusRunning
+	state.LastError = ""
+	s.save(ctx, logger, state)
+
+	started := time.Now()
+	err := runSafely(ctx, j.run, scheduled)
+	state.LastFinishedAt = nullTime(s.now())
+	state.RunCount++
+	if err != nil {
+		state.LastStatus = StatusError
+		state.LastError = err.Error()
+		logger.Warn("job failed", "scheduled", scheduled, "error", err)
+	} else {
+		state.LastStatus = StatusCompleted
+		logger.Debug("job completed", "scheduled", scheduled, "duration", time.Since(started))
+	}
+	s.save(ctx, logger, state)
+}
+
+func runSafely(ctx context.Context, run Func, scheduled time.Time) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return run(ctx, scheduled)
+}
+
+// save persists state even while shutting down, so the outcome of a run
+// that was cut short is still recorded.
+func (s *Scheduler) save(ctx context.Context, logger *slog.Logger, state *db.JobState) {
+	state.UpdatedAt = s.now().UTC()
+	if err := s.store.SaveJobState(context.WithoutCancel(ctx), *state); err != nil {
+		logger.Warn("save job state failed", "error", err)
+	}
+}
+
+func describe(schedule Schedule) string {
+	if stringer, ok := schedule.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", schedule)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
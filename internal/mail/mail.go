@@ -0,0 +1,167 @@
+// Package mail sends plain text email over SMTP.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is an email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	// Body is plain text.
+	Body string
+	// UnsubscribeURL, when set, is advertised in the List-Unsubscribe
+	// headers so mail clients can offer one-click unsubscribe.
+	UnsubscribeURL string
+}
+
+// Sender sends email.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Config locates the SMTP server and the sender address.
+type Config struct {
+	// Addr is the server's host:port. Port 465 speaks TLS from the start;
+	// other ports upgrade with STARTTLS when the server offers it.
+	Addr     string
+	Username string
+	Password string
+	// From is the sender, such as "Rhone <rhone@example.com>".
+	From string
+}
+
+// SMTP sends email through an SMTP server, one connection per message.
+type SMTP struct {
+	cfg  Config
+	from *mail.Address
+}
+
+// NewSMTP returns a sender for cfg, or an error when its addresses do not
+// parse.
+func NewSMTP(cfg Config) (*SMTP, error) {
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("smtp address %q: %w", cfg.Addr, err)
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("smtp from address %q: %w", cfg.From, err)
+	}
+	return &SMTP{cfg: cfg, from: from}, nil
+}
+
+// Send delivers message. The context bounds connecting and the whole
+// exchange with the server.
+func (s *SMTP) Send(ctx context.Context, message Message) error {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("recipient %q: %w", message.To, err)
+	}
+	data, err := format(s.from, to, message, time.Now())
+	if err != nil {
+		return err
+	}
+	host, port, _ := net.SplitHostPort(s.cfg.Addr)
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.cfg.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.cfg.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect to smtp server: %w", err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	_ = conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return client.Quit()
+}
+
+// format renders message as an RFC 5322 message with a quoted-printable
+// UTF-8 body.
+func format(from, to *mail.Address, message Message, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(message.Subject, "\r\n") || strings.ContainsAny(message.UnsubscribeURL, "\r\n<>") {
+		return nil, errors.New("mail headers must not contain line breaks")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndexByte(from.Address, '@')+1:]
+
+	var out bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&out, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain+">")
+	if message.UnsubscribeURL != "" {
+		header("List-Unsubscribe", "<"+message.UnsubscribeURL+">")
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	out.WriteString("\r\n")
+	body := quotedprintable.NewWriter(&out)
+	if _, err := body.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBuildsAPlainTextMessage(t *testing.T) {
+	from := &mail.Address{Name: "Rhone", Address: "rhone@example.com"}
+	to := &mail.Address{Address: "alice@example.com"}
+	data, err := format(from, to, Message{
+		Subject:        "Résumé du jour",
+		Body:           "Première ligne\nSecond line",
+		UnsubscribeURL: "https://chat.example.com/unsubscribe/abc",
+	}, time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("format() error = %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Résumé du jour" {
+		t.Fatalf("Subject = %q, %v", subject, err)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe"); got != "<https://chat.example.com/unsubscribe/abc>" {
+		t.Fatalf("List-Unsubscribe = %q", got)
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>") || parsed.Header.Get("From") != `"Rhone" <rhone@example.com>` {
+		t.Fatalf("headers = %v", parsed.Header)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if string(body) != "Première ligne\r\nSecond line" {
+		t.Fatalf("body = %q", body)
+	}
+
+	if _, err := format(from, to, Message{Subject: "Hi\r\nBcc: mallory@example.com"}, time.Now()); err == nil {
+		t.Fatal("format() accepted a subject with a line break")
+	}
+}
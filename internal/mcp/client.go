@@ -0,0 +1,183 @@
+// Package mcp connects to Model Context Protocol servers and exposes their
+// tools to the AI runner.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the MCP revision the client negotiates.
+const ProtocolVersion = "2025-06-18"
+
+// transport carries JSON-RPC messages to one server.
+type transport interface {
+	call(ctx context.Context, method string, params, result any) error
+	notify(ctx context.Context, method string, params any) error
+	close() error
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+func decodeResult(response rpcResponse, result any) error {
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// ToolInfo is a tool advertised by a server.
+type ToolInfo struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	// OutputSchema describes the tool's structuredContent, when declared.
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+}
+
+// ToolResult is the outcome of a tools/call request.
+type ToolResult struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+	IsError           bool            `json:"isError"`
+}
+
+// Text joins the text content blocks of the result, falling back to the
+// structured content when the server sent no text.
+func (r ToolResult) Text() string {
+	parts := make([]string, 0, len(r.Content))
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	if len(parts) == 0 && len(r.StructuredContent) > 0 {
+		return string(r.StructuredContent)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Client is an initialized session with one MCP server.
+type Client struct {
+	name      string
+	transport transport
+}
+
+func newClient(ctx context.Context, name string, t transport) (*Client, error) {
+	client := &Client{name: name, transport: t}
+	var initResult struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	err := t.call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "rhone_chat", "version": "1"},
+	}, &initResult)
+	if err != nil {
+		_ = t.close()
+		return nil, fmt.Errorf("initialize mcp server %q: %w", name, err)
+	}
+	if err := t.notify(ctx, "notifications/initialized", nil); err != nil {
+		_ = t.close()
+		return nil, fmt.Errorf("initialize mcp server %q: %w", name, err)
+	}
+	return client, nil
+}
+
+// Connect starts or dials the server described by cfg and performs the MCP
+// handshake.
+func Connect(ctx context.Context, cfg ServerConfig) (*Client, error) {
+	var (
+		t   transport
+		err error
+	)
+	switch {
+	case cfg.Command != "":
+		t, err = newStdioTransport(cfg)
+	case cfg.URL != "":
+		t = newHTTPTransport(cfg)
+	default:
+		err = errors.New("no command or url configured")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect mcp server %q: %w", cfg.Name, err)
+	}
+	return newClient(ctx, cfg.Name, t)
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+// ListTools returns every tool the server advertises, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]ToolInfo, error) {
+	tools := make([]ToolInfo, 0)
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools      []ToolInfo `json:"tools"`
+			NextCursor string     `json:"nextCursor"`
+		}
+		if err := c.transport.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("list tools of mcp server %q: %w", c.name, err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (ToolResult, error) {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	var result ToolResult
+	err := c.transport.call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": arguments,
+	}, &result)
+	if err != nil {
+		return ToolResult{}, fmt.Errorf("call %s on mcp server %q: %w", name, c.name, err)
+	}
+	return result, nil
+}
+
+func (c *Client) Close() error {
+	return c.transport.close()
+}
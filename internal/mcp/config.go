@@ -0,0 +1,47 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ServerConfig describes one MCP server. Exactly one of Command (stdio
+// transport) or URL (streamable HTTP transport) must be set.
+type ServerConfig struct {
+	Name    string
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// LoadConfig reads server definitions from a JSON file in the common
+// {"mcpServers": {"name": {...}}} layout.
+func LoadConfig(path string) ([]ServerConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mcp config: %w", err)
+	}
+	var file struct {
+		Servers map[string]ServerConfig `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parse mcp config: %w", err)
+	}
+
+	servers := make([]ServerConfig, 0, len(file.Servers))
+	for name, server := range file.Servers {
+		server.Name = name
+		if (server.Command == "") == (server.URL == "") {
+			return nil, fmt.Errorf("mcp server %q: set exactly one of command or url", name)
+		}
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+	return servers, nil
+}
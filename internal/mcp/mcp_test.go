@@ -0,0 +1,149 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rhone_chat/internal/ai"
+)
+
+// fakeServer answers the subset of MCP used by the client.
+func fakeServer(t *testing.T, request rpcRequest) *rpcResponse {
+	t.Helper()
+	if request.ID == nil {
+		return nil
+	}
+	response := &rpcResponse{JSONRPC: "2.0", ID: request.ID}
+	var result any
+	switch request.Method {
+	case "initialize":
+		result = map[string]any{"protocolVersion": ProtocolVersion}
+	case "tools/list":
+		result = map[string]any{"tools": []map[string]any{{
+			"name":        "add",
+			"description": "Add two numbers.",
+			"inputSchema": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"a": map[string]any{"type": "number"},
+					"b": map[string]any{"type": "number"},
+				},
+			},
+		}}}
+	case "tools/call":
+		params := request.Params.(map[string]any)
+		args := params["arguments"].(map[string]any)
+		sum := args["a"].(float64) + args["b"].(float64)
+		result = map[string]any{"content": []map[string]any{{"type": "text", "text": formatFloat(sum)}}}
+	default:
+		response.Error = &rpcError{Code: -32601, Message: "method not found"}
+		return response
+	}
+	encoded, _ := json.Marshal(result)
+	response.Result = encoded
+	return response
+}
+
+func formatFloat(value float64) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func TestHTTPTransportRegistersAndCallsTools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var request rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", "session-1")
+		response := fakeServer(t, request)
+		if response == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if request.Method == "tools/call" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			encoded, _ := json.Marshal(response)
+			_, _ = io.WriteString(w, "event: message\ndata: "+string(encoded)+"\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client, err := Connect(context.Background(), ServerConfig{Name: "math", URL: server.URL})
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer client.Close()
+
+	registry := ai.NewToolRegistry()
+	count, err := RegisterTools(context.Background(), client, registry)
+	if err != nil || count != 1 {
+		t.Fatalf("RegisterTools() = %d, %v; want 1, nil", count, err)
+	}
+	tool, ok := registry.Lookup("math__add")
+	if !ok {
+		t.Fatalf("math__add not registered")
+	}
+	if tool.InputSchema.Properties["a"].Type != "number" {
+		t.Fatalf("schema = %+v, want number property a", tool.InputSchema)
+	}
+	output, err := tool.Handler(context.Background(), json.RawMessage(`{"a":2,"b":3}`))
+	if err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+	if output != "5" {
+		t.Fatalf("Handler() = %v, want 5", output)
+	}
+}
+
+func TestStreamTransportRoundTrip(t *testing.T) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	go func() {
+		decoder := json.NewDecoder(serverReader)
+		encoder := json.NewEncoder(serverWriter)
+		for {
+			var request rpcRequest
+			if err := decoder.Decode(&request); err != nil {
+				serverWriter.Close()
+				return
+			}
+			if response := fakeServer(t, request); response != nil {
+				_ = encoder.Encode(response)
+			}
+		}
+	}()
+
+	client, err := newClient(context.Background(), "local", newStreamTransport(clientReader, clientWriter))
+	if err != nil {
+		t.Fatalf("newClient() error = %v", err)
+	}
+	defer client.Close()
+
+	tools, err := client.ListTools(context.Background())
+	if err != nil || len(tools) != 1 || tools[0].Name != "add" {
+		t.Fatalf("ListTools() = %+v, %v", tools, err)
+	}
+	result, err := client.CallTool(context.Background(), "add", json.RawMessage(`{"a":1,"b":1}`))
+	if err != nil || result.Text() != "2" {
+		t.Fatalf("CallTool() = %+v, %v", result, err)
+	}
+}
+
+func TestToolNameSanitizesAndNamespaces(t *testing.T) {
+	if got := ToolName("git hub", "create.issue"); got != "git_hub__create_issue" {
+		t.Fatalf("ToolName() = %q", got)
+	}
+}
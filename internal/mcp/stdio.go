@@ -0,0 +1,159 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+var errTransportClosed = errors.New("mcp transport closed")
+
+// streamTransport speaks newline-delimited JSON-RPC over a reader/writer
+// pair, as used by the stdio transport.
+type streamTransport struct {
+	writeMu sync.Mutex
+	w       io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	err     error
+
+	onClose func() error
+}
+
+func newStreamTransport(r io.Reader, w io.WriteCloser) *streamTransport {
+	t := &streamTransport{w: w, pending: make(map[int64]chan rpcResponse)}
+	go t.readLoop(r)
+	return t
+}
+
+func newStdioTransport(cfg ServerConfig) (*streamTransport, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range cfg.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", cfg.Command, err)
+	}
+	t := newStreamTransport(stdout, stdin)
+	t.onClose = func() error {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil
+	}
+	return t, nil
+}
+
+func (t *streamTransport) readLoop(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var response rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil || response.ID == nil {
+			// Server requests and notifications are not supported; skip them.
+			continue
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[*response.ID]
+		delete(t.pending, *response.ID)
+		t.mu.Unlock()
+		if ok {
+			ch <- response
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = errTransportClosed
+	}
+	t.fail(err)
+}
+
+func (t *streamTransport) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+	for id, ch := range t.pending {
+		close(ch)
+		delete(t.pending, id)
+	}
+}
+
+func (t *streamTransport) write(message rpcRequest) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.w.Write(append(encoded, '\n'))
+	return err
+}
+
+func (t *streamTransport) call(ctx context.Context, method string, params, result any) error {
+	t.mu.Lock()
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return err
+	}
+	t.nextID++
+	id := t.nextID
+	ch := make(chan rpcResponse, 1)
+	t.pending[id] = ch
+	t.mu.Unlock()
+
+	if err := t.write(rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+		return err
+	}
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			t.mu.Lock()
+			err := t.err
+			t.mu.Unlock()
+			return err
+		}
+		return decodeResult(response, result)
+	case <-ctx.Done():
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (t *streamTransport) notify(_ context.Context, method string, params any) error {
+	return t.write(rpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (t *streamTransport) close() error {
+	err := t.w.Close()
+	t.fail(errTransportClosed)
+	if t.onClose != nil {
+		return t.onClose()
+	}
+	return err
+}
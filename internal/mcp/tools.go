@@ -0,0 +1,122 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/vango-go/vai-lite/pkg/core/types"
+
+	"rhone_chat/internal/ai"
+)
+
+// Manager owns the connections to all configured MCP servers.
+type Manager struct {
+	clients []*Client
+}
+
+// Start connects to every server and registers its tools in registry. A
+// server that fails to start is logged and skipped so one broken server does
+// not keep the app from booting.
+func Start(ctx context.Context, servers []ServerConfig, registry *ai.ToolRegistry, logger *slog.Logger) *Manager {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	manager := &Manager{}
+	for _, server := range servers {
+		client, err := Connect(ctx, server)
+		if err != nil {
+			logger.Warn("mcp server unavailable", "server", server.Name, "error", err)
+			continue
+		}
+		registered, err := RegisterTools(ctx, client, registry)
+		if err != nil {
+			logger.Warn("mcp server tools unavailable", "server", server.Name, "error", err)
+			_ = client.Close()
+			continue
+		}
+		logger.Info("mcp server connected", "server", server.Name, "tools", registered)
+		manager.clients = append(manager.clients, client)
+	}
+	return manager
+}
+
+func (m *Manager) Close() error {
+	var errs []error
+	for _, client := range m.clients {
+		errs = append(errs, client.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var unsafeToolName = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// ToolName namespaces a server tool so tools from different servers cannot
+// collide, e.g. "github__create_issue".
+func ToolName(server, tool string) string {
+	name := unsafeToolName.ReplaceAllString(server, "_") + "__" + unsafeToolName.ReplaceAllString(tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// RegisterTools adds the server's tools to registry and returns how many
+// were registered. Invocations run through the runner like any other tool,
+// so they are persisted as tool_calls.
+func RegisterTools(ctx context.Context, client *Client, registry *ai.ToolRegistry) (int, error) {
+	infos, err := client.ListTools(ctx)
+	if err != nil {
+		return 0, err
+	}
+	registered := 0
+	for _, info := range infos {
+		schema := &types.JSONSchema{Type: "object"}
+		if len(info.InputSchema) > 0 {
+			if err := json.Unmarshal(info.InputSchema, schema); err != nil {
+				return registered, fmt.Errorf("tool %s schema: %w", info.Name, err)
+			}
+		}
+		var outputSchema *types.JSONSchema
+		if len(info.OutputSchema) > 0 {
+			outputSchema = &types.JSONSchema{}
+			if err := json.Unmarshal(info.OutputSchema, outputSchema); err != nil {
+				return registered, fmt.Errorf("tool %s output schema: %w", info.Name, err)
+			}
+		}
+		name := ToolName(client.Name(), info.Name)
+		err := registry.Register(ai.Tool{
+			Name:         name,
+			Description:  info.Description,
+			InputSchema:  schema,
+			OutputSchema: outputSchema,
+			Source:       ai.ToolSourceMCP,
+			Handler: func(ctx context.Context, input json.RawMessage) (any, error) {
+				result, err := client.CallTool(ctx, info.Name, input)
+				if err != nil {
+					return nil, err
+				}
+				if result.IsError {
+					return nil, errors.New(result.Text())
+				}
+				if outputSchema != nil {
+					// Tools with an output schema are validated on their
+					// structured content rather than the text rendering.
+					if len(result.StructuredContent) == 0 {
+						return nil, &ai.ToolOutputError{Tool: name, Problems: []string{"missing structuredContent"}}
+					}
+					return result.StructuredContent, nil
+				}
+				return result.Text(), nil
+			},
+		})
+		if err != nil {
+			return registered, err
+		}
+		registered++
+	}
+	return registered, nil
+}
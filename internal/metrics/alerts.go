@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Thresholds are the run saturation levels that raise an alert. Zero
+// disables a check.
+type Thresholds struct {
+	ActiveRuns      int
+	WaitingRuns     int
+	ProviderWaitP95 time.Duration
+}
+
+func (t Thresholds) Enabled() bool {
+	return t.ActiveRuns > 0 || t.WaitingRuns > 0 || t.ProviderWaitP95 > 0
+}
+
+// Alert is a threshold crossing. Resolved alerts report the return below
+// the threshold.
+type Alert struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	Threshold string    `json:"threshold"`
+	Resolved  bool      `json:"resolved"`
+	At        time.Time `json:"at"`
+}
+
+// Alerter checks Runs against Thresholds. Each crossing is logged and, when
+// WebhookURL is set, posted as JSON once; it is not repeated while the
+// metric stays over the threshold.
+type Alerter struct {
+	Runs       *Runs
+	Thresholds Thresholds
+	WebhookURL string
+	Client     *http.Client
+	Logger     *slog.Logger
+
+	breached map[string]bool
+}
+
+// Run checks every interval until ctx is done.
+func (a *Alerter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Check(ctx)
+		}
+	}
+}
+
+// Check evaluates the thresholds once and returns the alerts it raised or
+// resolved.
+func (a *Alerter) Check(ctx context.Context) []Alert {
+	if a.breached == nil {
+		a.breached = map[string]bool{}
+	}
+	snapshot := a.Runs.Snapshot()
+	checks := []struct {
+		name      string
+		over      bool
+		value     string
+		threshold string
+	}{
+		{"active_runs", a.Thresholds.ActiveRuns > 0 && snapshot.Active >= a.Thresholds.ActiveRuns,
+			fmt.Sprint(snapshot.Active), fmt.Sprint(a.Thresholds.ActiveRuns)},
+		{"waiting_runs", a.Thresholds.WaitingRuns > 0 && snapshot.Waiting >= a.Thresholds.WaitingRuns,
+			fmt.Sprint(snapshot.Waiting), fmt.Sprint(a.Thresholds.WaitingRuns)},
+		{"provider_wait_p95", a.Thresholds.ProviderWaitP95 > 0 && snapshot.ProviderWaitP95 >= a.Thresholds.ProviderWaitP95,
+			snapshot.ProviderWaitP95.String(), a.Thresholds.ProviderWaitP95.String()},
+	}
+	var alerts []Alert
+	now := time.Now().UTC()
+	for _, check := range checks {
+		if check.over == a.breached[check.name] {
+			continue
+		}
+		a.breached[check.name] = check.over
+		alert := Alert{Name: check.name, Value: check.value, Threshold: check.threshold, Resolved: !check.over, At: now}
+		alerts = append(alerts, alert)
+		a.notify(ctx, alert)
+	}
+	return alerts
+}
+
+func (a *Alerter) notify(ctx context.Context, alert Alert) {
+	logger := a.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if alert.Resolved {
+		logger.Info("run saturation alert resolved", "alert", alert.Name, "value", alert.Value, "threshold", alert.Threshold)
+	} else {
+		logger.Warn("run saturation alert", "alert", alert.Name, "value", alert.Value, "threshold", alert.Threshold)
+	}
+	if a.WebhookURL == "" {
+		return
+	}
+	if err := a.post(ctx, alert); err != nil {
+		logger.Warn("alert webhook failed", "alert", alert.Name, "error", err)
+	}
+}
+
+func (a *Alerter) post(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunsTracksActiveWaitingAndProviderWait(t *testing.T) {
+	runs := NewRuns()
+	finished := runs.RunStarted()
+	answered := runs.WaitStarted()
+	unanswered := runs.WaitStarted()
+	if snapshot := runs.Snapshot(); snapshot.Active != 1 || snapshot.Waiting != 2 {
+		t.Fatalf("Snapshot() = %+v, want 1 active and 2 waiting", snapshot)
+	}
+	time.Sleep(5 * time.Millisecond)
+	answered(true)
+	answered(true) // only the first call counts
+	unanswered(false)
+	finished()
+	finished()
+
+	snapshot := runs.Snapshot()
+	if snapshot.Active != 0 || snapshot.Waiting != 0 || snapshot.Started != 1 || snapshot.Finished != 1 {
+		t.Fatalf("Snapshot() = %+v, want everything settled", snapshot)
+	}
+	if snapshot.WaitSamples != 1 || snapshot.ProviderWaitP95 < 5*time.Millisecond {
+		t.Fatalf("Snapshot() = %+v, want one provider wait of at least 5ms", snapshot)
+	}
+
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p50, p95 := percentile(sorted, 50), percentile(sorted, 95); p50 != 5 || p95 != 10 {
+		t.Fatalf("percentile() = %d, %d; want 5, 10", p50, p95)
+	}
+}
+
+func TestRunsWritesLatencyHistogramsForPrometheus(t *testing.T) {
+	runs := NewRuns()
+	finished := runs.RunStarted()
+	runs.ObserveRun("openai/gpt-5", 300*time.Millisecond, 3*time.Second, 45)
+	runs.ObserveRun("openai/gpt-5", 0, 0, 0) // nothing known
+	runs.ObserveTool("fetch_url", 700*time.Millisecond)
+	runs.ObserveTool(`say "hi"`, 10*time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	runs.PrometheusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"rhone_runs_active 1\n",
+		"# TYPE rhone_run_first_token_seconds histogram\n",
+		`rhone_run_first_token_seconds_bucket{model="openai/gpt-5",le="0.25"} 0` + "\n",
+		`rhone_run_first_token_seconds_bucket{model="openai/gpt-5",le="0.5"} 1` + "\n",
+		`rhone_run_first_token_seconds_count{model="openai/gpt-5"} 1` + "\n",
+		`rhone_run_stream_seconds_sum{model="openai/gpt-5"} 3` + "\n",
+		`rhone_run_output_tokens_per_second_bucket{model="openai/gpt-5",le="+Inf"} 1` + "\n",
+		`rhone_tool_call_seconds_bucket{tool="fetch_url",le="1"} 1` + "\n",
+		`rhone_tool_call_seconds_count{tool="say \"hi\""} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if content := recorder.Header().Get("Content-Type"); !strings.HasPrefix(content, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q, want the Prometheus text format", content)
+	}
+	finished()
+}
+
+func TestAlerterFiresOncePerCrossingAndResolves(t *testing.T) {
+	var mu sync.Mutex
+	var received []Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		_ = json.NewDecoder(r.Body).Decode(&alert)
+		mu.Lock()
+		received = append(received, alert)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	runs := NewRuns()
+	alerter := &Alerter{Runs: runs, Thresholds: Thresholds{ActiveRuns: 2}, WebhookURL: server.URL}
+	ctx := context.Background()
+
+	first := runs.RunStarted()
+	if alerts := alerter.Check(ctx); len(alerts) != 0 {
+		t.Fatalf("Check() below threshold = %+v, want none", alerts)
+	}
+	second := runs.RunStarted()
+	if alerts := alerter.Check(ctx); len(alerts) != 1 || alerts[0].Name != "active_runs" || alerts[0].Resolved {
+		t.Fatalf("Check() at threshold = %+v, want an active_runs alert", alerts)
+	}
+	if alerts := alerter.Check(ctx); len(alerts) != 0 {
+		t.Fatalf("Check() still over = %+v, want no repeat", alerts)
+	}
+	first()
+	second()
+	if alerts := alerter.Check(ctx); len(alerts) != 1 || !alerts[0].Resolved {
+		t.Fatalf("Check() after recovery = %+v, want a resolved alert", alerts)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0].Value != "2" || received[0].Threshold != "2" || !received[1].Resolved {
+		t.Fatalf("webhook received %+v, want the alert and its resolution", received)
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket upper bounds of the run histograms.
+var (
+	firstTokenBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60}
+	streamBuckets     = []float64{1, 2.5, 5, 10, 20, 40, 80, 160, 320, 600}
+	tokenRateBuckets  = []float64{5, 10, 20, 40, 80, 160, 320}
+	toolBuckets       = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// histogram counts observations under one label value. counts[i] is the
+// number of observations at or below bounds[i]; the +Inf bucket is count.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histograms is a Prometheus histogram with one label.
+type histograms struct {
+	name   string
+	help   string
+	label  string
+	bounds []float64
+	byKey  map[string]*histogram
+}
+
+func newHistograms(name, help, label string, bounds []float64) *histograms {
+	return &histograms{name: name, help: help, label: label, bounds: bounds, byKey: map[string]*histogram{}}
+}
+
+func (h *histograms) observe(key string, value float64) {
+	entry := h.byKey[key]
+	if entry == nil {
+		entry = &histogram{counts: make([]uint64, len(h.bounds))}
+		h.byKey[key] = entry
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			entry.counts[i]++
+		}
+	}
+	entry.sum += value
+	entry.count++
+}
+
+func (h *histograms) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range slices.Sorted(maps.Keys(h.byKey)) {
+		entry := h.byKey[key]
+		label := h.label + `="` + escapeLabel(key) + `"`
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, label, strconv.FormatFloat(bound, 'g', -1, 64), entry.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, label, entry.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, label, strconv.FormatFloat(entry.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, label, entry.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// ObserveRun records the latency of a finished run of model: the wait for
+// its first token, the whole stream, and the rate it streamed output
+// tokens at. Zero values are not known and are skipped.
+func (r *Runs) ObserveRun(model string, firstToken, stream time.Duration, tokensPerSecond float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if firstToken > 0 {
+		r.firstToken.observe(model, firstToken.Seconds())
+	}
+	if stream > 0 {
+		r.stream.observe(model, stream.Seconds())
+	}
+	if tokensPerSecond > 0 {
+		r.tokenRate.observe(model, tokensPerSecond)
+	}
+}
+
+// ObserveTool records how long a call of the named tool took.
+func (r *Runs) ObserveTool(name string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools.observe(name, duration.Seconds())
+}
+
+// WritePrometheus writes the run counts and latency histograms in the
+// Prometheus text format.
+func (r *Runs) WritePrometheus(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	r.mu.Lock()
+	fmt.Fprintf(buffered, "# HELP rhone_runs_active Runs in flight.\n# TYPE rhone_runs_active gauge\nrhone_runs_active %d\n", r.active)
+	fmt.Fprintf(buffered, "# HELP rhone_runs_waiting Runs waiting for a provider's first output.\n# TYPE rhone_runs_waiting gauge\nrhone_runs_waiting %d\n", r.waiting)
+	fmt.Fprintf(buffered, "# HELP rhone_runs_started_total Runs started.\n# TYPE rhone_runs_started_total counter\nrhone_runs_started_total %d\n", r.started)
+	fmt.Fprintf(buffered, "# HELP rhone_runs_finished_total Runs finished.\n# TYPE rhone_runs_finished_total counter\nrhone_runs_finished_total %d\n", r.finished)
+	for _, h := range []*histograms{r.firstToken, r.stream, r.tokenRate, r.tools} {
+		h.write(buffered)
+	}
+	r.mu.Unlock()
+	return buffered.Flush()
+}
+
+// PrometheusHandler serves WritePrometheus for scraping.
+func (r *Runs) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WritePrometheus(w)
+	})
+}
@@ -0,0 +1,133 @@
+// Package metrics tracks run concurrency and latency for operators, serves
+// them to Prometheus, and raises alerts when they cross configured
+// thresholds.
+package metrics
+
+import (
+	"expvar"
+	"slices"
+	"sync"
+	"time"
+)
+
+// waitSamples is how many recent provider waits percentiles are taken over.
+const waitSamples = 256
+
+// Runs counts runs in flight and how long providers take to start
+// answering, and keeps histograms of finished runs' latency. It is safe for
+// concurrent use.
+type Runs struct {
+	mu       sync.Mutex
+	active   int
+	waiting  int
+	started  int64
+	finished int64
+	waits    []time.Duration
+	next     int
+
+	firstToken *histograms
+	stream     *histograms
+	tokenRate  *histograms
+	tools      *histograms
+}
+
+// RunsSnapshot is a point-in-time view of Runs. Waiting counts runs sent to
+// a provider that has not produced any output yet, which is where runs
+// queue up when a provider is saturated.
+type RunsSnapshot struct {
+	Active          int           `json:"active"`
+	Waiting         int           `json:"waiting"`
+	Started         int64         `json:"started"`
+	Finished        int64         `json:"finished"`
+	ProviderWaitP50 time.Duration `json:"provider_wait_p50_ns"`
+	ProviderWaitP95 time.Duration `json:"provider_wait_p95_ns"`
+	WaitSamples     int           `json:"provider_wait_samples"`
+}
+
+func NewRuns() *Runs {
+	return &Runs{
+		waits:      make([]time.Duration, 0, waitSamples),
+		firstToken: newHistograms("rhone_run_first_token_seconds", "Time from sending a run to its first token.", "model", firstTokenBuckets),
+		stream:     newHistograms("rhone_run_stream_seconds", "Time from sending a run to the end of its stream, tool calls included.", "model", streamBuckets),
+		tokenRate:  newHistograms("rhone_run_output_tokens_per_second", "Rate a run streamed output tokens at after the first.", "model", tokenRateBuckets),
+		tools:      newHistograms("rhone_tool_call_seconds", "Duration of tool calls.", "tool", toolBuckets),
+	}
+}
+
+// RunStarted records a run in flight and returns the function that marks
+// it finished.
+func (r *Runs) RunStarted() func() {
+	r.mu.Lock()
+	r.active++
+	r.started++
+	r.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			r.active--
+			r.finished++
+			r.mu.Unlock()
+		})
+	}
+}
+
+// WaitStarted records a request sent to a provider. The returned function
+// is called with true on the first output, which records the wait, or with
+// false when the request ends without output; only the first call counts.
+func (r *Runs) WaitStarted() func(answered bool) {
+	startedAt := time.Now()
+	r.mu.Lock()
+	r.waiting++
+	r.mu.Unlock()
+	var once sync.Once
+	return func(answered bool) {
+		once.Do(func() {
+			wait := time.Since(startedAt)
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.waiting--
+			if !answered {
+				return
+			}
+			if len(r.waits) < waitSamples {
+				r.waits = append(r.waits, wait)
+			} else {
+				r.waits[r.next] = wait
+			}
+			r.next = (r.next + 1) % waitSamples
+		})
+	}
+}
+
+func (r *Runs) Snapshot() RunsSnapshot {
+	r.mu.Lock()
+	snapshot := RunsSnapshot{
+		Active:      r.active,
+		Waiting:     r.waiting,
+		Started:     r.started,
+		Finished:    r.finished,
+		WaitSamples: len(r.waits),
+	}
+	waits := slices.Clone(r.waits)
+	r.mu.Unlock()
+	if len(waits) > 0 {
+		slices.Sort(waits)
+		snapshot.ProviderWaitP50 = percentile(waits, 50)
+		snapshot.ProviderWaitP95 = percentile(waits, 95)
+	}
+	return snapshot
+}
+
+// Publish exposes the snapshot as an expvar, served on /debug/vars.
+func (r *Runs) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return r.Snapshot()
+	}))
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
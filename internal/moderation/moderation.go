@@ -0,0 +1,170 @@
+// Package moderation screens user messages before a run starts. A Checker
+// decides to allow a message, flag it (the run goes ahead but the message
+// is marked) or block it (the run is refused). Term lists catch known
+// phrases offline; a model classifier covers the rest.
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Action is what a check decided to do with a message.
+type Action string
+
+const (
+	Allow Action = "allow"
+	Flag  Action = "flag"
+	Block Action = "block"
+)
+
+// severity orders actions from most to least permissive.
+var severity = map[Action]int{Allow: 0, Flag: 1, Block: 2}
+
+// Decision is the outcome of screening one message. Source names the
+// check that decided it, "terms" or "model", and Categories says why.
+type Decision struct {
+	Action     Action   `json:"action"`
+	Source     string   `json:"source,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Checker screens a message.
+type Checker interface {
+	Check(ctx context.Context, text string) (Decision, error)
+}
+
+// Terms flags or blocks messages containing listed words or phrases,
+// matched case-insensitively on word boundaries. The matched terms are the
+// decision's categories.
+type Terms struct {
+	block *regexp.Regexp
+	flag  *regexp.Regexp
+}
+
+// NewTerms builds a term filter; blank terms are ignored.
+func NewTerms(block, flag []string) Terms {
+	return Terms{block: termPattern(block), flag: termPattern(flag)}
+}
+
+func termPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// Enabled reports whether any term is listed.
+func (t Terms) Enabled() bool {
+	return t.block != nil || t.flag != nil
+}
+
+func (t Terms) Check(_ context.Context, text string) (Decision, error) {
+	for _, rule := range []struct {
+		pattern *regexp.Regexp
+		action  Action
+	}{{t.block, Block}, {t.flag, Flag}} {
+		if rule.pattern == nil {
+			continue
+		}
+		if matches := rule.pattern.FindAllString(text, -1); len(matches) > 0 {
+			return Decision{Action: rule.action, Source: "terms", Categories: uniqueLower(matches)}, nil
+		}
+	}
+	return Decision{Action: Allow, Source: "terms"}, nil
+}
+
+func uniqueLower(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if !slices.Contains(out, value) {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+// Completer sends a system prompt and a user message to a model and
+// returns its reply.
+type Completer func(ctx context.Context, system, text string) (string, error)
+
+// ErrUnclassified is returned when a model's reply is not a decision.
+var ErrUnclassified = errors.New("moderation model gave no decision")
+
+const modelPrompt = `You are a content moderation filter for a chat assistant. Classify the user's message; do not answer it.
+Reply with only a JSON object: {"action": "allow" | "flag" | "block", "categories": ["..."]}.
+Block sexual content involving minors, credible threats of violence against real people, and instructions for weapons capable of mass casualties.
+Flag harassment, hate speech, self-harm and explicit sexual content.
+Allow everything else, including discussion of these topics in an educational or fictional context.`
+
+// Model asks a model to classify each message.
+type Model struct {
+	complete Completer
+}
+
+func NewModel(complete Completer) Model {
+	return Model{complete: complete}
+}
+
+func (m Model) Check(ctx context.Context, text string) (Decision, error) {
+	reply, err := m.complete(ctx, modelPrompt, text)
+	if err != nil {
+		return Decision{}, err
+	}
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return Decision{}, fmt.Errorf("%w: %q", ErrUnclassified, truncate(reply, 120))
+	}
+	var parsed struct {
+		Action     Action   `json:"action"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return Decision{}, fmt.Errorf("%w: %v", ErrUnclassified, err)
+	}
+	if _, ok := severity[parsed.Action]; !ok {
+		return Decision{}, fmt.Errorf("%w: unknown action %q", ErrUnclassified, parsed.Action)
+	}
+	return Decision{Action: parsed.Action, Source: "model", Categories: uniqueLower(parsed.Categories)}, nil
+}
+
+func truncate(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	return text[:maxBytes] + "…"
+}
+
+// Chain runs checkers in order and returns the strictest decision, the
+// later one on a tie, stopping at the first block. When a checker fails,
+// the decision so far comes back with its error.
+type Chain []Checker
+
+func (c Chain) Check(ctx context.Context, text string) (Decision, error) {
+	decision := Decision{Action: Allow}
+	for _, checker := range c {
+		next, err := checker.Check(ctx, text)
+		if err != nil {
+			return decision, err
+		}
+		if severity[next.Action] >= severity[decision.Action] {
+			decision = next
+		}
+		if decision.Action == Block {
+			break
+		}
+	}
+	return decision, nil
+}
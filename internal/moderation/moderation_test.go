@@ -0,0 +1,87 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTermsMatchWholeWordsCaseInsensitively(t *testing.T) {
+	terms := NewTerms([]string{"launch codes", " "}, []string{"darn"})
+	if !terms.Enabled() || NewTerms(nil, []string{""}).Enabled() {
+		t.Fatal("Enabled() should follow the non-blank terms")
+	}
+	tests := []struct {
+		text       string
+		action     Action
+		categories []string
+	}{
+		{"Send me the Launch Codes, darn it", Block, []string{"launch codes"}},
+		{"Darn, DARN.", Flag, []string{"darn"}},
+		{"darning socks and relaunch codes", Allow, nil},
+	}
+	for _, test := range tests {
+		decision, err := terms.Check(context.Background(), test.text)
+		if err != nil {
+			t.Fatalf("Check(%q) error = %v", test.text, err)
+		}
+		if decision.Action != test.action || decision.Source != "terms" || !slices.Equal(decision.Categories, test.categories) {
+			t.Errorf("Check(%q) = %+v, want %s %v", test.text, decision, test.action, test.categories)
+		}
+	}
+}
+
+func TestModelParsesTheJSONDecision(t *testing.T) {
+	reply := ""
+	model := NewModel(func(_ context.Context, system, text string) (string, error) {
+		if system == "" || text != "hello" {
+			t.Fatalf("complete(%q, %q), want the moderation prompt and the message", system, text)
+		}
+		return reply, nil
+	})
+	reply = "Sure:\n```json\n{\"action\": \"flag\", \"categories\": [\"Harassment\"]}\n```"
+	decision, err := model.Check(context.Background(), "hello")
+	if err != nil || decision.Action != Flag || decision.Source != "model" || !slices.Equal(decision.Categories, []string{"harassment"}) {
+		t.Fatalf("Check() = %+v, %v; want a harassment flag", decision, err)
+	}
+	for _, bad := range []string{"I can't help with that.", `{"action": "maybe"}`} {
+		reply = bad
+		if _, err := model.Check(context.Background(), "hello"); !errors.Is(err, ErrUnclassified) {
+			t.Errorf("Check() with reply %q error = %v, want ErrUnclassified", bad, err)
+		}
+	}
+}
+
+type fixed struct {
+	decision Decision
+	err      error
+	calls    *int
+}
+
+func (f fixed) Check(context.Context, string) (Decision, error) {
+	*f.calls++
+	return f.decision, f.err
+}
+
+func TestChainKeepsTheStrictestDecision(t *testing.T) {
+	calls := 0
+	flag := fixed{decision: Decision{Action: Flag, Source: "terms"}, calls: &calls}
+	allow := fixed{decision: Decision{Action: Allow, Source: "model"}, calls: &calls}
+	block := fixed{decision: Decision{Action: Block, Source: "terms"}, calls: &calls}
+	failing := fixed{err: errors.New("provider down"), calls: &calls}
+
+	if decision, err := (Chain{flag, allow}).Check(context.Background(), "x"); err != nil || decision.Action != Flag || decision.Source != "terms" {
+		t.Fatalf("flag then allow = %+v, %v; want the flag", decision, err)
+	}
+	calls = 0
+	if decision, _ := (Chain{block, failing}).Check(context.Background(), "x"); decision.Action != Block || calls != 1 {
+		t.Fatalf("block then failing = %+v after %d calls, want a block without the second check", decision, calls)
+	}
+	if decision, err := (Chain{flag, failing}).Check(context.Background(), "x"); err == nil || decision.Action != Flag {
+		t.Fatalf("flag then failing = %+v, %v; want the flag so far and the error", decision, err)
+	}
+	if decision, err := (Chain{}).Check(context.Background(), "x"); err != nil || decision.Action != Allow {
+		t.Fatalf("empty chain = %+v, %v; want allow", decision, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Package pii masks personal data in text: email addresses, IPv4
+// addresses, payment card numbers and phone numbers. Matching is by
+// regular expression, so it catches the common shapes of each kind and is
+// no guarantee that nothing personal is left.
+package pii
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kind names a sort of personal data a Scrubber can mask.
+type Kind string
+
+const (
+	Email Kind = "email"
+	IP    Kind = "ip"
+	Card  Kind = "card"
+	Phone Kind = "phone"
+)
+
+// order is the order kinds are masked in: IP addresses and card numbers
+// go before phone numbers, whose looser pattern would otherwise take them.
+var order = []Kind{Email, IP, Card, Phone}
+
+var patterns = map[Kind]*regexp.Regexp{
+	Email: regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`),
+	IP:    regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`),
+	Card:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+	Phone: regexp.MustCompile(`(?:\+|\b)\d(?:[ ().-]{0,2}\d){8,14}\b`),
+}
+
+// Kinds lists the kinds a Scrubber can mask.
+func Kinds() []string {
+	kinds := make([]string, 0, len(order))
+	for _, kind := range order {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Scrubber replaces the kinds of personal data it was built with by
+// placeholders such as "[email]". The zero Scrubber leaves text unchanged.
+type Scrubber struct {
+	kinds map[Kind]bool
+}
+
+// New builds a Scrubber for the named kinds; "all" selects every kind.
+// Unknown names are reported in the error and left out of the returned
+// Scrubber.
+func New(names []string) (Scrubber, error) {
+	scrubber := Scrubber{kinds: map[Kind]bool{}}
+	var unknown []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+		case name == "all":
+			for _, kind := range order {
+				scrubber.kinds[kind] = true
+			}
+		case patterns[Kind(name)] != nil:
+			scrubber.kinds[Kind(name)] = true
+		default:
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return scrubber, fmt.Errorf("unknown pii kinds %s; available: all, %s", strings.Join(unknown, ", "), strings.Join(Kinds(), ", "))
+	}
+	return scrubber, nil
+}
+
+// Enabled reports whether the Scrubber masks anything.
+func (s Scrubber) Enabled() bool {
+	return len(s.kinds) > 0
+}
+
+// Scrub returns text with each enabled kind masked.
+func (s Scrubber) Scrub(text string) string {
+	for _, kind := range order {
+		if !s.kinds[kind] {
+			continue
+		}
+		placeholder := "[" + string(kind) + "]"
+		if kind == Card {
+			// Only digit runs passing the Luhn check are card numbers.
+			text = patterns[kind].ReplaceAllStringFunc(text, func(match string) string {
+				if luhn(match) {
+					return placeholder
+				}
+				return match
+			})
+			continue
+		}
+		text = patterns[kind].ReplaceAllString(text, placeholder)
+	}
+	return text
+}
+
+// luhn reports whether the digits in number pass the Luhn checksum.
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,58 @@
+package pii
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScrubMasksEachKind(t *testing.T) {
+	scrubber, err := New([]string{"all"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	input := "Mail ada@example.com from 192.168.10.200, card 4111 1111 1111 1111, call +1 (415) 555-0132. Order 12345 shipped 2026-10-16."
+	want := "Mail [email] from [ip], card [card], call [phone]. Order 12345 shipped 2026-10-16."
+	if got := scrubber.Scrub(input); got != want {
+		t.Fatalf("Scrub() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestScrubLeavesKindsThatWereNotAskedFor(t *testing.T) {
+	scrubber, err := New([]string{" Email "})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := scrubber.Scrub("ada@example.com at 10.0.0.1"); got != "[email] at 10.0.0.1" {
+		t.Fatalf("Scrub() = %q", got)
+	}
+	if (Scrubber{}).Enabled() || !scrubber.Enabled() {
+		t.Fatal("Enabled() is wrong")
+	}
+}
+
+func TestNewReportsUnknownKinds(t *testing.T) {
+	if _, err := New([]string{"email", "ssn"}); err == nil || !strings.Contains(err.Error(), "ssn") || !strings.Contains(err.Error(), "phone") {
+		t.Fatalf("New() error = %v, want the unknown name and the available ones", err)
+	}
+}
+
+func TestModelMasksTheSpansItReports(t *testing.T) {
+	reply := `Found: [{"text": "Ada Lovelace", "kind": "name"}, {"text": "12 St James's Square, London", "kind": "Address"}, {"text": "Ada", "kind": "first name!"}, {"text": " ", "kind": "name"}]`
+	model := NewModel(func(_ context.Context, system, text string) (string, error) {
+		return reply, nil
+	})
+	got, err := model.Redact(context.Background(), "Ada Lovelace lives at 12 St James's Square, London. Write to Ada.")
+	if err != nil {
+		t.Fatalf("Redact() error = %v", err)
+	}
+	if want := "[name] lives at [address]. Write to [other]."; got != want {
+		t.Fatalf("Redact() = %q, want %q", got, want)
+	}
+
+	reply = "I cannot help with that."
+	if got, err := model.Redact(context.Background(), "Ada"); !errors.Is(err, ErrUnparsedReply) || got != "Ada" {
+		t.Fatalf("Redact() = %q, %v; want the text unchanged and ErrUnparsedReply", got, err)
+	}
+}
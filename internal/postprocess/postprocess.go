@@ -0,0 +1,256 @@
+// Package postprocess cleans up completed assistant replies before they are
+// saved. Processors are selected by name (AI_OUTPUT_PROCESSORS) and run in
+// the configured order; each one leaves text it cannot handle unchanged.
+package postprocess
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Processor rewrites a completed reply.
+type Processor interface {
+	Name() string
+	Process(content string) string
+}
+
+// Pipeline runs processors in order.
+type Pipeline []Processor
+
+// Apply returns content after every processor has run.
+func (p Pipeline) Apply(content string) string {
+	for _, processor := range p {
+		content = processor.Process(content)
+	}
+	return content
+}
+
+// Func adapts a function to Processor.
+type Func struct {
+	ProcessorName string
+	Fn            func(content string) string
+}
+
+func (f Func) Name() string                  { return f.ProcessorName }
+func (f Func) Process(content string) string { return f.Fn(content) }
+
+var builtins = map[string]Processor{
+	"trim":                 Func{"trim", strings.TrimSpace},
+	"strip_artifacts":      Func{"strip_artifacts", stripArtifacts},
+	"collapse_blank_lines": Func{"collapse_blank_lines", collapseBlankLines},
+	"close_code_fences":    Func{"close_code_fences", closeCodeFences},
+	"gofmt":                Func{"gofmt", formatCodeBlocks("go", formatGo)},
+	"json":                 Func{"json", formatCodeBlocks("json", formatJSON)},
+}
+
+// Names lists the built-in processors.
+func Names() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds a pipeline from built-in processor names. Unknown names are
+// reported in the error and left out of the returned pipeline.
+func New(names []string) (Pipeline, error) {
+	pipeline := make(Pipeline, 0, len(names))
+	var unknown []string
+	for _, name := range names {
+		processor, ok := builtins[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		pipeline = append(pipeline, processor)
+	}
+	if len(unknown) > 0 {
+		return pipeline, fmt.Errorf("unknown output processors %s; available: %s", strings.Join(unknown, ", "), strings.Join(Names(), ", "))
+	}
+	return pipeline, nil
+}
+
+var (
+	// citationMarker matches file-search citations such as 【4:0†source】
+	// that some providers leave in the text.
+	citationMarker = regexp.MustCompile(`【[^】\n]{0,40}†[^】\n]{0,40}】`)
+	// specialToken matches leaked chat template tokens such as <|im_end|>.
+	specialToken = regexp.MustCompile(`<\|[a-z_]{1,20}\|>`)
+)
+
+// stripArtifacts removes provider artifacts: citation markers, leaked
+// template tokens and zero-width characters. Code blocks are kept as is.
+func stripArtifacts(content string) string {
+	return mapText(content, func(text string) string {
+		text = citationMarker.ReplaceAllString(text, "")
+		text = specialToken.ReplaceAllString(text, "")
+		return strings.NewReplacer("\u200b", "", "\ufeff", "").Replace(text)
+	})
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// collapseBlankLines keeps at most one blank line between paragraphs
+// outside code blocks.
+func collapseBlankLines(content string) string {
+	return mapText(content, func(text string) string {
+		return blankLines.ReplaceAllString(text, "\n\n")
+	})
+}
+
+// closeCodeFences closes a code block the reply left open, which happens
+// when a reply is cut off by the token limit.
+func closeCodeFences(content string) string {
+	blocks := split(content)
+	if len(blocks) == 0 || !blocks[len(blocks)-1].open {
+		return content
+	}
+	fence := blocks[len(blocks)-1].fence
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + fence
+}
+
+// formatCodeBlocks rewrites the body of closed code blocks tagged lang with
+// format, keeping blocks format rejects.
+func formatCodeBlocks(lang string, format func(string) (string, bool)) func(string) string {
+	return func(content string) string {
+		var out strings.Builder
+		for _, block := range split(content) {
+			if !block.code || block.open || !strings.EqualFold(block.lang, lang) {
+				out.WriteString(block.raw)
+				continue
+			}
+			formatted, ok := format(block.body)
+			if !ok {
+				out.WriteString(block.raw)
+				continue
+			}
+			out.WriteString(block.header)
+			out.WriteString(formatted)
+			out.WriteString(block.footer)
+		}
+		return out.String()
+	}
+}
+
+func formatGo(body string) (string, bool) {
+	formatted, err := format.Source([]byte(body))
+	if err != nil {
+		return "", false
+	}
+	return ensureNewline(string(formatted)), true
+}
+
+func formatJSON(body string) (string, bool) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(strings.TrimSpace(body)), "", "  "); err != nil {
+		return "", false
+	}
+	return ensureNewline(out.String()), true
+}
+
+func ensureNewline(text string) string {
+	if strings.HasSuffix(text, "\n") {
+		return text
+	}
+	return text + "\n"
+}
+
+// mapText applies fn to the prose between code blocks.
+func mapText(content string, fn func(string) string) string {
+	var out strings.Builder
+	for _, block := range split(content) {
+		if block.code {
+			out.WriteString(block.raw)
+		} else {
+			out.WriteString(fn(block.raw))
+		}
+	}
+	return out.String()
+}
+
+// block is a run of prose or a fenced code block. raw is the exact source,
+// so joining the raw text of every block gives back the input.
+type block struct {
+	raw  string
+	code bool
+	// open is set for a code block the input never closed.
+	open   bool
+	fence  string
+	lang   string
+	header string
+	body   string
+	footer string
+}
+
+// split cuts markdown into prose and fenced code blocks. Fences are lines
+// starting with ``` or ~~~; a block is closed by a fence line of the same
+// character at least as long as the opening one.
+func split(content string) []block {
+	var blocks []block
+	var prose strings.Builder
+	flushProse := func() {
+		if prose.Len() > 0 {
+			blocks = append(blocks, block{raw: prose.String()})
+			prose.Reset()
+		}
+	}
+	lines := strings.SplitAfter(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		fence, lang, ok := openingFence(lines[i])
+		if !ok {
+			prose.WriteString(lines[i])
+			continue
+		}
+		flushProse()
+		code := block{code: true, open: true, fence: fence, lang: lang, header: lines[i]}
+		var body strings.Builder
+		for i++; i < len(lines); i++ {
+			if closesFence(lines[i], fence) {
+				code.open = false
+				code.footer = lines[i]
+				break
+			}
+			body.WriteString(lines[i])
+		}
+		code.body = body.String()
+		code.raw = code.header + code.body + code.footer
+		blocks = append(blocks, code)
+	}
+	flushProse()
+	return blocks
+}
+
+func openingFence(line string) (fence, lang string, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return "", "", false
+	}
+	for _, char := range []string{"`", "~"} {
+		run := len(trimmed) - len(strings.TrimLeft(trimmed, char))
+		if run < 3 {
+			continue
+		}
+		info := strings.TrimSpace(trimmed[run:])
+		if char == "`" && strings.Contains(info, "`") {
+			return "", "", false
+		}
+		lang, _, _ = strings.Cut(info, " ")
+		return trimmed[:run], lang, true
+	}
+	return "", "", false
+}
+
+func closesFence(line, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
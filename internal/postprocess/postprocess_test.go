@@ -0,0 +1,45 @@
+package postprocess
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPipelineCleansProseAndFormatsCodeBlocks(t *testing.T) {
+	pipeline, err := New([]string{"strip_artifacts", "collapse_blank_lines", "gofmt", "json", "trim"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	input := "\nSee the docs【4:0†source】.\u200b<|im_end|>\n\n\n\nCode:\n" +
+		"```go\nfunc add(a,b int) int {return a+b}\n```\n" +
+		"```go\nthis is not go\n\n\n```\n" +
+		"```json\n{\"a\":[1,2]}\n```\n"
+	want := "See the docs.\n\nCode:\n" +
+		"```go\nfunc add(a, b int) int { return a + b }\n```\n" +
+		"```go\nthis is not go\n\n\n```\n" +
+		"```json\n{\n  \"a\": [\n    1,\n    2\n  ]\n}\n```"
+	if got := pipeline.Apply(input); got != want {
+		t.Fatalf("Apply() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCloseCodeFencesClosesATruncatedBlock(t *testing.T) {
+	pipeline, _ := New([]string{"close_code_fences"})
+	if got := pipeline.Apply("Here:\n~~~~python\nprint(1)"); got != "Here:\n~~~~python\nprint(1)\n~~~~" {
+		t.Fatalf("Apply() = %q, want the block closed", got)
+	}
+	closed := "```\na\n```\ntext"
+	if got := pipeline.Apply(closed); got != closed {
+		t.Fatalf("Apply() = %q, want closed blocks unchanged", got)
+	}
+}
+
+func TestNewReportsUnknownProcessors(t *testing.T) {
+	pipeline, err := New([]string{"trim", "spellcheck"})
+	if err == nil || !strings.Contains(err.Error(), "spellcheck") || !strings.Contains(err.Error(), "gofmt") {
+		t.Fatalf("New() error = %v, want the unknown name and the available ones", err)
+	}
+	if len(pipeline) != 1 || pipeline[0].Name() != "trim" {
+		t.Fatalf("New() pipeline = %v, want the known processors", pipeline)
+	}
+}
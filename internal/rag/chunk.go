@@ -0,0 +1,97 @@
+package rag
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Chunk splits text into pieces of at most size bytes, preferring paragraph
+// and then word boundaries. Each chunk after the first starts with up to
+// overlap bytes from the end of the previous one so a passage split across
+// chunks can still be matched.
+func Chunk(text string, size, overlap int) []string {
+	if size < 1 {
+		return nil
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	var chunks []string
+	var current strings.Builder
+	carried := 0 // bytes of current that repeat the previous chunk
+	flush := func() {
+		chunk := strings.TrimSpace(current.String())
+		fresh := current.Len() > carried
+		current.Reset()
+		carried = 0
+		if chunk == "" || !fresh {
+			return
+		}
+		chunks = append(chunks, chunk)
+		if tail := overlapTail(chunk, overlap); tail != "" {
+			current.WriteString(tail)
+			carried = current.Len()
+		}
+	}
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			continue
+		}
+		if length := len(strings.Join(words, " ")); current.Len() > carried && current.Len()+2+length > size && length <= size {
+			// Start a paragraph that fits in one chunk on a fresh chunk
+			// rather than splitting it.
+			flush()
+		}
+		if current.Len() > carried && current.Len()+2 < size {
+			current.WriteString("\n\n")
+		}
+		for _, word := range words {
+			for len(word) > size {
+				// A word longer than a chunk is cut at a rune boundary
+				// rather than dropped.
+				cut := size
+				for cut > 0 && !utf8.RuneStart(word[cut]) {
+					cut--
+				}
+				flush()
+				current.Reset()
+				carried = 0
+				chunks = append(chunks, word[:cut])
+				word = word[cut:]
+			}
+			if current.Len() > 0 && current.Len()+1+len(word) > size {
+				if current.Len() == carried {
+					// The overlap alone leaves no room; start clean.
+					current.Reset()
+					carried = 0
+				} else {
+					flush()
+					if current.Len()+1+len(word) > size {
+						current.Reset()
+						carried = 0
+					}
+				}
+			}
+			if current.Len() > 0 && !strings.HasSuffix(current.String(), "\n\n") {
+				current.WriteByte(' ')
+			}
+			current.WriteString(word)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// overlapTail returns the trailing words of chunk that fit in limit bytes.
+func overlapTail(chunk string, limit int) string {
+	if limit <= 0 || len(chunk) <= limit {
+		return ""
+	}
+	tail := chunk[len(chunk)-limit:]
+	i := strings.IndexAny(tail, " \n")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(tail[i+1:])
+}
@@ -0,0 +1,176 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Embedder turns texts into vectors. Name identifies the embedding space:
+// vectors from embedders with different names are never compared.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+const (
+	EmbedderAuto   = "auto"
+	EmbedderHash   = "hash"
+	EmbedderOpenAI = "openai"
+
+	DefaultOpenAIModel = "text-embedding-3-small"
+)
+
+// NewEmbedder returns the embedder called name. "auto" uses OpenAI when
+// OPENAI_API_KEY is set and the local hash embedder otherwise; unknown
+// names fall back to the hash embedder.
+func NewEmbedder(name, model string) Embedder {
+	if name == EmbedderAuto {
+		name = EmbedderHash
+		if os.Getenv("OPENAI_API_KEY") != "" {
+			name = EmbedderOpenAI
+		}
+	}
+	if name == EmbedderOpenAI {
+		return NewOpenAIEmbedder(os.Getenv("OPENAI_API_KEY"), model)
+	}
+	return HashEmbedder{}
+}
+
+const hashDimensions = 512
+
+// HashEmbedder is an offline embedder that hashes words and word pairs into
+// a fixed-size vector. It only captures lexical overlap, but needs no
+// provider and is deterministic, so it suits local work and tests.
+type HashEmbedder struct{}
+
+func (HashEmbedder) Name() string {
+	return fmt.Sprintf("hash-%d", hashDimensions)
+}
+
+func (HashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, hashDimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for j, word := range words {
+			addFeature(vector, word, 1)
+			if j > 0 {
+				addFeature(vector, words[j-1]+" "+word, 0.5)
+			}
+		}
+		for k, value := range vector {
+			// Dampen repeated terms so one frequent word does not dominate.
+			if value > 0 {
+				vector[k] = float32(math.Log1p(float64(value)))
+			} else if value < 0 {
+				vector[k] = -float32(math.Log1p(float64(-value)))
+			}
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func addFeature(vector []float32, feature string, weight float32) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(feature))
+	sum := hash.Sum64()
+	if sum&(1<<63) != 0 {
+		weight = -weight
+	}
+	vector[sum%uint64(len(vector))] += weight
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint. The vai
+// client only covers chat completions, so embeddings use plain HTTP.
+type OpenAIEmbedder struct {
+	APIKey  string
+	Model   string
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewOpenAIEmbedder(apiKey, model string) *OpenAIEmbedder {
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
+	return &OpenAIEmbedder{APIKey: apiKey, Model: model, BaseURL: "https://api.openai.com/v1", Client: http.DefaultClient}
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return "openai:" + e.Model
+}
+
+// openAIBatchSize keeps each request well under the endpoint's input limit.
+const openAIBatchSize = 64
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.APIKey == "" {
+		return nil, errors.New("embeddings need OPENAI_API_KEY")
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openAIBatchSize {
+		batch := texts[start:min(start+openAIBatchSize, len(texts))]
+		embedded, err := e.embedBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, embedded...)
+	}
+	return vectors, nil
+}
+
+func (e *OpenAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]any{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.BaseURL, "/")+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embeddings request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embeddings status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	var decoded struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("decode embeddings: %w", err)
+	}
+	if len(decoded.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings returned %d vectors for %d inputs", len(decoded.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range decoded.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings returned index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,195 @@
+// Package rag keeps a per-chat knowledge base: documents are chunked,
+// embedded and stored in SQLite, and the chunks most similar to a query are
+// retrieved by brute-force cosine similarity.
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/db"
+)
+
+type Config struct {
+	// ChunkBytes and ChunkOverlap control how documents are split.
+	ChunkBytes   int
+	ChunkOverlap int
+	// TopK is the most chunks retrieved per query, and MaxBytes caps their
+	// combined size.
+	TopK     int
+	MaxBytes int
+}
+
+func (c Config) withDefaults() Config {
+	if c.ChunkBytes < 1 {
+		c.ChunkBytes = 1200
+	}
+	if c.ChunkOverlap < 0 || c.ChunkOverlap >= c.ChunkBytes {
+		c.ChunkOverlap = c.ChunkBytes / 6
+	}
+	if c.TopK < 1 {
+		c.TopK = 4
+	}
+	if c.MaxBytes < 1 {
+		c.MaxBytes = 6000
+	}
+	return c
+}
+
+// Index stores and searches chat knowledge bases.
+type Index struct {
+	store    *db.Store
+	embedder Embedder
+	cfg      Config
+}
+
+func New(store *db.Store, embedder Embedder, cfg Config) *Index {
+	if embedder == nil {
+		embedder = HashEmbedder{}
+	}
+	return &Index{store: store, embedder: embedder, cfg: cfg.withDefaults()}
+}
+
+var ErrNoText = errors.New("document has no text to index")
+
+// Document describes a document to ingest. Text is its extracted content.
+type Document struct {
+	ChatID    string
+	FileName  string
+	MediaType string
+	SizeBytes int64
+	Text      string
+}
+
+// Ingest chunks and embeds doc and stores it in the chat's knowledge base.
+func (idx *Index) Ingest(ctx context.Context, doc Document) (db.KnowledgeDocument, error) {
+	pieces := Chunk(doc.Text, idx.cfg.ChunkBytes, idx.cfg.ChunkOverlap)
+	if len(pieces) == 0 {
+		return db.KnowledgeDocument{}, ErrNoText
+	}
+	vectors, err := idx.embedder.Embed(ctx, pieces)
+	if err != nil {
+		return db.KnowledgeDocument{}, fmt.Errorf("embed %s: %w", doc.FileName, err)
+	}
+	if len(vectors) != len(pieces) {
+		return db.KnowledgeDocument{}, fmt.Errorf("embed %s: got %d vectors for %d chunks", doc.FileName, len(vectors), len(pieces))
+	}
+
+	document := db.KnowledgeDocument{
+		ID:         uuid.NewString(),
+		ChatID:     doc.ChatID,
+		FileName:   doc.FileName,
+		MediaType:  doc.MediaType,
+		SizeBytes:  doc.SizeBytes,
+		Embedder:   idx.embedder.Name(),
+		ChunkCount: len(pieces),
+		CreatedAt:  time.Now().UTC(),
+	}
+	chunks := make([]db.KnowledgeChunk, len(pieces))
+	for i, piece := range pieces {
+		chunks[i] = db.KnowledgeChunk{
+			ID:         uuid.NewString(),
+			DocumentID: document.ID,
+			ChatID:     doc.ChatID,
+			Ordinal:    i,
+			Content:    piece,
+			Embedding:  EncodeVector(vectors[i]),
+		}
+	}
+	if err := idx.store.InsertKnowledgeDocument(ctx, document, chunks); err != nil {
+		return db.KnowledgeDocument{}, err
+	}
+	return document, nil
+}
+
+// MaxBytes is the most retrieved text Retrieve returns per query.
+func (idx *Index) MaxBytes() int {
+	return idx.cfg.MaxBytes
+}
+
+// Documents lists the chat's knowledge base. Documents embedded by another
+// embedder are listed but not searched until re-uploaded.
+func (idx *Index) Documents(ctx context.Context, chatID string) ([]db.KnowledgeDocument, error) {
+	return idx.store.ListKnowledgeDocuments(ctx, chatID)
+}
+
+// Searchable reports whether document was embedded by this index's
+// embedder.
+func (idx *Index) Searchable(document db.KnowledgeDocument) bool {
+	return document.Embedder == idx.embedder.Name()
+}
+
+func (idx *Index) Delete(ctx context.Context, chatID, documentID string) error {
+	return idx.store.DeleteKnowledgeDocument(ctx, chatID, documentID)
+}
+
+// Result is a retrieved chunk and its similarity to the query.
+type Result struct {
+	DocumentID string
+	FileName   string
+	Ordinal    int
+	Content    string
+	Score      float64
+}
+
+// Retrieve returns up to TopK chunks from the chat's knowledge base most
+// similar to query, best first, within MaxBytes. Chunks with no similarity
+// are never returned.
+func (idx *Index) Retrieve(ctx context.Context, chatID, query string) ([]Result, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	chunks, err := idx.store.ListKnowledgeChunks(ctx, chatID, idx.embedder.Name())
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	vectors, err := idx.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embed query: got %d vectors", len(vectors))
+	}
+
+	results := make([]Result, 0, len(chunks))
+	for _, chunk := range chunks {
+		vector, err := DecodeVector(chunk.Embedding)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %s: %w", chunk.ID, err)
+		}
+		score := Cosine(vectors[0], vector)
+		if score <= 0 {
+			continue
+		}
+		results = append(results, Result{
+			DocumentID: chunk.DocumentID,
+			FileName:   chunk.FileName,
+			Ordinal:    chunk.Ordinal,
+			Content:    chunk.Content,
+			Score:      score,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	selected := results[:0]
+	budget := idx.cfg.MaxBytes
+	for _, result := range results {
+		if len(selected) == idx.cfg.TopK {
+			break
+		}
+		if len(result.Content) > budget {
+			continue
+		}
+		budget -= len(result.Content)
+		selected = append(selected, result)
+	}
+	return selected, nil
+}
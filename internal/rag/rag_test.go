@@ -0,0 +1,124 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+func TestChunkRespectsSizeAndOverlap(t *testing.T) {
+	text := strings.Repeat("alpha beta gamma delta ", 40) + "\n\nomega"
+	chunks := Chunk(text, 100, 20)
+	if len(chunks) < 2 {
+		t.Fatalf("Chunk() = %d chunks, want several", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 100 {
+			t.Fatalf("chunk %d has %d bytes, want at most 100", i, len(chunk))
+		}
+		if i > 0 {
+			if tail := overlapTail(chunks[i-1], 20); tail == "" || !strings.HasPrefix(chunk, tail) {
+				t.Fatalf("chunk %d = %q does not repeat the end of the previous chunk", i, chunk)
+			}
+		}
+	}
+	if last := chunks[len(chunks)-1]; !strings.HasSuffix(last, "omega") {
+		t.Fatalf("last chunk = %q, want it to end with the final paragraph", last)
+	}
+	if chunks := Chunk(strings.Repeat("x", 250), 100, 20); len(chunks) != 3 {
+		t.Fatalf("Chunk(long word) = %q, want it cut into 3 pieces", chunks)
+	}
+}
+
+func TestRetrieveRanksRelevantChunksFirst(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "rag.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "Chat", "model", time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	index := New(store, HashEmbedder{}, Config{ChunkBytes: 120, ChunkOverlap: 0, TopK: 2, MaxBytes: 1000})
+	text := "The billing service retries failed invoices three times before alerting finance.\n\n" +
+		"Our office plants are watered every Tuesday by the facilities team.\n\n" +
+		"Deployments happen on weekdays after the release checklist is signed off."
+	document, err := index.Ingest(ctx, Document{ChatID: "chat-1", FileName: "handbook.txt", MediaType: "text/plain", Text: text})
+	if err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	if document.ChunkCount != 3 || !index.Searchable(document) {
+		t.Fatalf("Ingest() = %+v, want 3 searchable chunks", document)
+	}
+
+	results, err := index.Retrieve(ctx, "chat-1", "How many times are failed invoices retried?")
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if len(results) == 0 || !strings.Contains(results[0].Content, "invoices") || results[0].FileName != "handbook.txt" {
+		t.Fatalf("Retrieve() = %+v, want the billing chunk first", results)
+	}
+	if len(results) > 2 {
+		t.Fatalf("Retrieve() returned %d results, want at most TopK", len(results))
+	}
+
+	if other, err := index.Retrieve(ctx, "chat-2", "invoices"); err != nil || len(other) != 0 {
+		t.Fatalf("Retrieve(other chat) = %+v, %v; want nothing", other, err)
+	}
+	if err := index.Delete(ctx, "chat-1", document.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if results, err := index.Retrieve(ctx, "chat-1", "invoices"); err != nil || len(results) != 0 {
+		t.Fatalf("Retrieve() after Delete = %+v, %v; want nothing", results, err)
+	}
+}
+
+func TestOpenAIEmbedderOrdersVectorsByIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var request struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		data := make([]item, len(request.Input))
+		for i := range request.Input {
+			// Answer in reverse order to check the index is honoured.
+			j := len(request.Input) - 1 - i
+			data[i] = item{Index: j, Embedding: []float32{float32(j), 1}}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer server.Close()
+
+	embedder := NewOpenAIEmbedder("key", "")
+	embedder.BaseURL = server.URL
+	vectors, err := embedder.Embed(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	for i, vector := range vectors {
+		if vector[0] != float32(i) {
+			t.Fatalf("vector %d = %v, want it to match input %d", i, vector, i)
+		}
+	}
+	if embedder.Name() != "openai:"+DefaultOpenAIModel {
+		t.Fatalf("Name() = %q", embedder.Name())
+	}
+}
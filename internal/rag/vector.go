@@ -0,0 +1,60 @@
+package rag
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// EncodeVector packs a vector as little-endian float32s for storage.
+func EncodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+// DecodeVector is the inverse of EncodeVector.
+func DecodeVector(encoded []byte) ([]float32, error) {
+	if len(encoded)%4 != 0 {
+		return nil, fmt.Errorf("vector has %d bytes, not a multiple of 4", len(encoded))
+	}
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector, nil
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 when their lengths
+// differ or either is zero.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func normalize(vector []float32) {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+}
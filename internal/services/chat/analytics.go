@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"context"
+
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/auth"
+)
+
+// Analytics returns the recorder of product events, nil when analytics
+// are off.
+func (s *Service) Analytics() *analytics.Recorder {
+	return s.analytics
+}
+
+// RecordStop records that principal stopped a run of model.
+func (s *Service) RecordStop(ctx context.Context, principal auth.Principal, model string) {
+	s.analytics.Record(ctx, analytics.StopPressed, ownerOf(principal), map[string]any{"model": model})
+}
+
+// recordForChat records an event for the owner of a chat.
+func (s *Service) recordForChat(ctx context.Context, chatID, name string, props map[string]any) {
+	if s.analytics == nil {
+		return
+	}
+	owner := ""
+	if chat, err := s.store.GetChat(ctx, chatID); err == nil {
+		owner = chat.OwnerID.String
+	}
+	s.analytics.Record(ctx, name, owner, props)
+}
+
+// recordModelSwitch records a run that changes its chat's model. The two
+// runs of a comparison leave the model alone.
+func (s *Service) recordModelSwitch(ctx context.Context, run PendingRun) {
+	if s.analytics == nil || run.ComparisonID != "" {
+		return
+	}
+	chat, err := s.store.GetChat(ctx, run.ChatID)
+	if err != nil || chat.Model == run.Model {
+		return
+	}
+	s.analytics.Record(ctx, analytics.ModelSwitched, chat.OwnerID.String, map[string]any{"from": chat.Model, "to": run.Model})
+}
@@ -0,0 +1,304 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/extract"
+)
+
+type Attachment = db.Attachment
+
+// draftMessageID stands in for the ID of a user message that is previewed
+// but not yet stored.
+const draftMessageID = "draft"
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment must be a PNG, JPEG, GIF or WebP image, a PDF or a text file")
+)
+
+// attachmentExtensions maps the accepted media types to the extension used
+// when the file is stored outside SQLite.
+var attachmentExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// maxExtractedTextBytes caps the text stored per document; history
+// injection applies the smaller configured limits on top.
+const maxExtractedTextBytes = 1 << 20
+
+// IsDocumentType reports whether attachments of mediaType are sent to the
+// model as extracted text rather than as images.
+func IsDocumentType(mediaType string) bool {
+	return mediaType == "application/pdf" || mediaType == "text/plain"
+}
+
+// AttachmentMaxBytes is the upload size limit.
+func (s *Service) AttachmentMaxBytes() int {
+	return s.settings().AttachmentMaxBytes
+}
+
+// authorizeChat loads a chat the principal may write to: its own, or any
+// chat for admins. Unowned chats, from before ownership or created without
+// a user, belong to the single user of a server without authentication;
+// once users sign in, only admins reach them.
+func (s *Service) authorizeChat(ctx context.Context, principal auth.Principal, chatID string) (Chat, error) {
+	trimmedChatID := strings.TrimSpace(chatID)
+	if trimmedChatID == "" {
+		return Chat{}, errors.New("chat id is required")
+	}
+	chat, err := s.store.GetChat(ctx, trimmedChatID)
+	if err != nil {
+		return Chat{}, err
+	}
+	if chat.OwnerID.String != ownerOf(principal) && !principal.HasRole("admin") {
+		return Chat{}, ErrChatForbidden
+	}
+	return chat, nil
+}
+
+// UploadAttachment stores an image or document in the chat. Document text is
+// extracted up front. The attachment stays pending until the next user
+// message is sent, which claims it.
+func (s *Service) UploadAttachment(ctx context.Context, principal auth.Principal, chatID, fileName string, data []byte) (Attachment, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if len(data) == 0 {
+		return Attachment{}, errors.New("attachment is empty")
+	}
+	if len(data) > s.settings().AttachmentMaxBytes {
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	extension, ok := attachmentExtensions[mediaType]
+	if !ok {
+		return Attachment{}, ErrAttachmentType
+	}
+	var extractedText string
+	if IsDocumentType(mediaType) {
+		extractedText, err = extract.Text(mediaType, data)
+		if err != nil {
+			return Attachment{}, fmt.Errorf("read %s: %w", attachmentFileName(fileName), err)
+		}
+		extractedText = truncateText(extractedText, maxExtractedTextBytes)
+	}
+
+	attachment := Attachment{
+		ID:            uuid.NewString(),
+		ChatID:        chat.ID,
+		FileName:      attachmentFileName(fileName),
+		MediaType:     mediaType,
+		SizeBytes:     int64(len(data)),
+		ExtractedText: extractedText,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if s.attachments == nil {
+		attachment.Data = data
+	} else {
+		attachment.StoragePath = chat.ID + "/" + attachment.ID + extension
+		if err := s.attachments.Put(ctx, attachment.StoragePath, bytes.NewReader(data), int64(len(data))); err != nil {
+			return Attachment{}, fmt.Errorf("store attachment: %w", err)
+		}
+	}
+	if err := s.store.InsertAttachment(ctx, attachment); err != nil {
+		s.removeAttachments(ctx, attachment.StoragePath)
+		return Attachment{}, err
+	}
+	attachment.Data = nil
+	attachment.ExtractedText = ""
+	return attachment, nil
+}
+
+func attachmentFileName(name string) string {
+	base := strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	if base == "" || base == "." || base == "/" {
+		return "attachment"
+	}
+	return truncateText(base, 200)
+}
+
+// PendingAttachments lists the chat's attachments waiting for the next
+// message.
+func (s *Service) PendingAttachments(ctx context.Context, chatID string) ([]Attachment, error) {
+	if strings.TrimSpace(chatID) == "" {
+		return nil, nil
+	}
+	return s.store.ListPendingAttachments(ctx, chatID)
+}
+
+func (s *Service) RemovePendingAttachment(ctx context.Context, chatID, attachmentID string) error {
+	storagePath, err := s.store.DeletePendingAttachment(ctx, chatID, attachmentID)
+	if err != nil {
+		return err
+	}
+	s.removeAttachments(ctx, storagePath)
+	return nil
+}
+
+// MessageAttachments returns the chat's attachments keyed by the message
+// that claimed them.
+func (s *Service) MessageAttachments(ctx context.Context, chatID string) (map[string][]Attachment, error) {
+	rows, err := s.store.ListChatAttachments(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	byMessage := make(map[string][]Attachment, len(rows))
+	for _, row := range rows {
+		byMessage[row.MessageID.String] = append(byMessage[row.MessageID.String], row)
+	}
+	return byMessage, nil
+}
+
+// messageDocument is the extracted text of a document attachment.
+type messageDocument struct {
+	Name string
+	Text string
+}
+
+// historyAttachments loads the images and document text attached to
+// messageIDs, keyed by message. withDraft adds the chat's pending
+// attachments under draftMessageID.
+func (s *Service) historyAttachments(ctx context.Context, chatID string, messageIDs []string, withDraft bool) (map[string][]ai.Image, map[string][]messageDocument, error) {
+	rows, err := s.store.ListMessageAttachments(ctx, messageIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if withDraft {
+		pending, err := s.store.ListPendingAttachments(ctx, chatID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, attachment := range pending {
+			row, err := s.store.GetAttachment(ctx, attachment.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			row.MessageID = sql.NullString{String: draftMessageID, Valid: true}
+			rows = append(rows, row)
+		}
+	}
+	images := make(map[string][]ai.Image, len(rows))
+	documents := make(map[string][]messageDocument)
+	for _, row := range rows {
+		if IsDocumentType(row.MediaType) {
+			documents[row.MessageID.String] = append(documents[row.MessageID.String], messageDocument{
+				Name: row.FileName,
+				Text: row.ExtractedText,
+			})
+			continue
+		}
+		data := row.Data
+		if row.StoragePath != "" {
+			data, err = s.readAttachment(ctx, row.StoragePath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("read attachment %s: %w", row.ID, err)
+			}
+		}
+		images[row.MessageID.String] = append(images[row.MessageID.String], ai.Image{
+			Name:      row.FileName,
+			MediaType: row.MediaType,
+			Data:      data,
+		})
+	}
+	return images, documents, nil
+}
+
+// injectDocuments appends the text of each message's documents to its
+// content. Each document is capped at AttachmentTextMaxBytes; once the
+// history-wide AttachmentHistoryMaxBytes budget is spent, older documents
+// are replaced by a short note. The newest messages are filled first.
+func (s *Service) injectDocuments(history []AIMessage, messageIDs []string, documents map[string][]messageDocument) {
+	budget := s.settings().AttachmentHistoryMaxBytes
+	for i := len(history) - 1; i >= 0; i-- {
+		docs := documents[messageIDs[i]]
+		if len(docs) == 0 {
+			continue
+		}
+		var content strings.Builder
+		content.WriteString(history[i].Content)
+		for _, doc := range docs {
+			text := truncateText(doc.Text, min(s.settings().AttachmentTextMaxBytes, budget))
+			if text == "" {
+				fmt.Fprintf(&content, "\n\n[Attached document %q omitted: context limit reached]", doc.Name)
+				continue
+			}
+			budget -= len(text)
+			fmt.Fprintf(&content, "\n\n[Attached document %q]\n%s\n[End of document %q]", doc.Name, text, doc.Name)
+		}
+		history[i].Content = content.String()
+	}
+}
+
+// attachmentKey returns the key in the attachment store of an attachment
+// stored at storagePath, and whether the store holds it. Attachments
+// uploaded before the store existed were saved under their path inside
+// AttachmentsDir, which maps onto the same key; any other path is a file
+// read directly.
+func (s *Service) attachmentKey(storagePath string) (string, bool) {
+	if dir := s.settings().AttachmentsDir; dir != "" {
+		if rel, err := filepath.Rel(dir, storagePath); err == nil && filepath.IsLocal(rel) {
+			return filepath.ToSlash(rel), s.attachments != nil
+		}
+	}
+	return storagePath, s.attachments != nil && !filepath.IsAbs(storagePath)
+}
+
+// openAttachment opens the bytes of an attachment stored outside SQLite.
+func (s *Service) openAttachment(ctx context.Context, storagePath string) (io.ReadCloser, error) {
+	key, ok := s.attachmentKey(storagePath)
+	if !ok {
+		return os.Open(storagePath)
+	}
+	return s.attachments.Get(ctx, key)
+}
+
+func (s *Service) readAttachment(ctx context.Context, storagePath string) ([]byte, error) {
+	reader, err := s.openAttachment(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// removeAttachments deletes the bytes of attachments stored outside
+// SQLite. Failures are logged; the rows are already gone.
+func (s *Service) removeAttachments(ctx context.Context, storagePaths ...string) {
+	for _, storagePath := range storagePaths {
+		if storagePath == "" {
+			continue
+		}
+		var err error
+		if key, ok := s.attachmentKey(storagePath); ok {
+			err = s.attachments.Delete(ctx, key)
+		} else if err = os.Remove(storagePath); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			slog.Warn("remove attachment", "path", storagePath, "error", err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package chat
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+// Updates a live run sends its subscribers, in the order they happen.
+// Finished is always last.
+const (
+	RunUpdateQueued       = "queued"
+	RunUpdateText         = "text"
+	RunUpdateReasoning    = "reasoning"
+	RunUpdateToolStarted  = "tool_started"
+	RunUpdateToolFinished = "tool_finished"
+	RunUpdateUsage        = "usage"
+	RunUpdateFinished     = "finished"
+)
+
+// liveRunLinger is how long a finished run stays subscribable, so a page
+// that comes back just after it ended still gets its outcome.
+const liveRunLinger = time.Minute
+
+// LiveRun is a run the service is executing in the background: what it
+// has streamed so far and, once it ended, its outcome.
+type LiveRun struct {
+	RunID              string
+	ChatID             string
+	UserMessageID      string
+	AssistantMessageID string
+	Model              string
+	ComparisonID       string
+	StartedAt          time.Time
+	RunTimeout         time.Duration
+	Content            string
+	Reasoning          string
+	// QueuePosition is the run's place in line while it waits for a
+	// stream slot, 1 being next; 0 once it streams.
+	QueuePosition int
+	// ToolCalls are the run's tool calls so far, by the saved call's ID.
+	ToolCalls []ToolCallUpdate
+	Usage     UsageProgress
+	// Finished is set once the run's outcome is saved.
+	Finished *RunOutcome
+}
+
+// RunOutcome is how a live run ended. Content is the reply as saved,
+// after output processing. Err is set instead when the outcome could not
+// be saved.
+type RunOutcome struct {
+	Status  string
+	Content string
+	Error   string
+	Sources []Source
+	Runs    []RunDetail
+	Err     error
+}
+
+// RunUpdate is one change to a live run. Delta is set on text and
+// reasoning updates, Position on queued ones, Tool on tool updates, Usage
+// on usage updates and Outcome on the finished one.
+type RunUpdate struct {
+	Type               string
+	RunID              string
+	AssistantMessageID string
+	Delta              string
+	Position           int
+	Tool               ToolCallUpdate
+	Usage              UsageProgress
+	Outcome            *RunOutcome
+}
+
+// liveRuns holds the runs started with StartRuns, from when they are
+// stored until liveRunLinger after they finish.
+type liveRuns struct {
+	mu    sync.Mutex
+	byID  map[string]*liveRun
+	added uint64
+}
+
+// liveRun is one run's state and subscribers. Updates are applied and
+// delivered with mu held, so a subscriber sees each update once, after the
+// snapshot it subscribed with.
+type liveRun struct {
+	seq uint64
+
+	mu        sync.Mutex
+	state     LiveRun
+	content   strings.Builder
+	reasoning strings.Builder
+	subs      []*runSubscriber
+}
+
+// runSubscriber is one SubscribeRun. stopped is set without the run's lock,
+// so unsubscribing never waits on a delivery; publish drops it next time.
+type runSubscriber struct {
+	deliver func(RunUpdate)
+	stopped atomic.Bool
+}
+
+func newLiveRuns() *liveRuns {
+	return &liveRuns{byID: map[string]*liveRun{}}
+}
+
+func (l *liveRuns) add(run PendingRun) *liveRun {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.added++
+	live := &liveRun{
+		seq: l.added,
+		state: LiveRun{
+			RunID:              run.RunID,
+			ChatID:             run.ChatID,
+			UserMessageID:      run.UserMessageID,
+			AssistantMessageID: run.AssistantMessageID,
+			Model:              run.Model,
+			ComparisonID:       run.ComparisonID,
+			StartedAt:          time.Now().UTC(),
+			RunTimeout:         run.RunTimeout,
+		},
+	}
+	l.byID[run.RunID] = live
+	return live
+}
+
+func (l *liveRuns) get(runID string) *liveRun {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.byID[runID]
+}
+
+// forget drops a finished run once liveRunLinger has passed.
+func (l *liveRuns) forget(runID string) {
+	time.AfterFunc(liveRunLinger, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.byID, runID)
+	})
+}
+
+func (r *liveRun) publish(update RunUpdate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update.RunID = r.state.RunID
+	update.AssistantMessageID = r.state.AssistantMessageID
+	switch update.Type {
+	case RunUpdateQueued:
+		r.state.QueuePosition = update.Position
+	case RunUpdateText:
+		r.content.WriteString(update.Delta)
+	case RunUpdateReasoning:
+		r.reasoning.WriteString(update.Delta)
+	case RunUpdateToolStarted:
+		r.state.ToolCalls = append(r.state.ToolCalls, update.Tool)
+	case RunUpdateToolFinished:
+		i := slices.IndexFunc(r.state.ToolCalls, func(call ToolCallUpdate) bool { return call.ID == update.Tool.ID })
+		if i < 0 {
+			r.state.ToolCalls = append(r.state.ToolCalls, update.Tool)
+		} else {
+			started := r.state.ToolCalls[i]
+			r.state.ToolCalls[i] = update.Tool
+			r.state.ToolCalls[i].Name = started.Name
+			r.state.ToolCalls[i].Input = started.Input
+		}
+	case RunUpdateUsage:
+		r.state.Usage = update.Usage
+	case RunUpdateFinished:
+		r.state.Finished = update.Outcome
+	}
+	r.subs = slices.DeleteFunc(r.subs, func(sub *runSubscriber) bool { return sub.stopped.Load() })
+	for _, sub := range r.subs {
+		sub.deliver(update)
+	}
+	if update.Type == RunUpdateFinished {
+		r.subs = nil
+	}
+}
+
+// snapshot copies the run's state. It is called with mu held.
+func (r *liveRun) snapshot() LiveRun {
+	state := r.state
+	state.Content = r.content.String()
+	state.Reasoning = r.reasoning.String()
+	state.ToolCalls = slices.Clone(r.state.ToolCalls)
+	return state
+}
+
+// StartRuns stores runs and executes them on goroutines the service owns,
+// so they stream and save their outcome whatever becomes of the page that
+// started them; pages follow them with SubscribeRun. content is the user
+// message; the first run stores it and later ones, like the second run of
+// a comparison, reuse it. StartRuns returns once every run is stored, and
+// an error means none of them stream: runs stored before one failed to be
+// are saved as errors.
+func (s *Service) StartRuns(ctx context.Context, principal auth.Principal, content string, runs ...PendingRun) error {
+	if err := s.CheckRunsQuota(ctx, principal, len(runs)); err != nil {
+		return err
+	}
+	// Each run gets a context of its own, cancelled by CancelRun or a
+	// drain but not by the caller going away. All are tracked before any
+	// is stored, so a drain cannot refuse one halfway through.
+	runCtxs := make([]context.Context, 0, len(runs))
+	releases := make([]func(), 0, len(runs))
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, run := range runs {
+		runCtx, release, err := s.TrackRun(context.WithoutCancel(ctx), run.RunID)
+		if err != nil {
+			releaseAll()
+			return err
+		}
+		runCtxs = append(runCtxs, runCtx)
+		releases = append(releases, release)
+	}
+	for i, run := range runs {
+		if err := s.PersistRunStart(ctx, run, content); err != nil {
+			err = errors.Join(err, s.abandonRuns(context.WithoutCancel(ctx), runs[:i], err))
+			releaseAll()
+			return err
+		}
+	}
+	for i, run := range runs {
+		live := s.live.add(run)
+		go func() {
+			defer releases[i]()
+			live.publish(RunUpdate{Type: RunUpdateFinished, Outcome: s.executeLiveRun(runCtxs[i], run, live)})
+			s.live.forget(run.RunID)
+		}()
+	}
+	return nil
+}
+
+// abandonRuns saves stored runs that will not execute as errors, with
+// cause as their error, so their replies do not stay streaming.
+func (s *Service) abandonRuns(ctx context.Context, runs []PendingRun, cause error) error {
+	var errs []error
+	for _, run := range runs {
+		if _, err := s.CompleteAssistant(ctx, run.AssistantMessageID, "", "error"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.CompleteRun(ctx, run, "error", StreamResult{}, cause.Error()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// executeLiveRun streams a stored run, publishing its progress to live,
+// and saves its outcome.
+func (s *Service) executeLiveRun(ctx context.Context, run PendingRun, live *liveRun) *RunOutcome {
+	saveCtx := context.WithoutCancel(ctx)
+	result, output, streamErr := s.streamRun(ctx, run, StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			live.publish(RunUpdate{Type: RunUpdateText, Delta: delta})
+		},
+		OnThinkingDelta: func(delta string) {
+			live.publish(RunUpdate{Type: RunUpdateReasoning, Delta: delta})
+		},
+		OnToolStart: func(update ToolCallUpdate) {
+			live.publish(RunUpdate{Type: RunUpdateToolStarted, Tool: update})
+		},
+		OnToolResult: func(update ToolCallUpdate) {
+			live.publish(RunUpdate{Type: RunUpdateToolFinished, Tool: update})
+		},
+		OnUsage: func(progress UsageProgress) {
+			live.publish(RunUpdate{Type: RunUpdateUsage, Usage: progress})
+		},
+		OnQueued: func(position int) {
+			live.publish(RunUpdate{Type: RunUpdateQueued, Position: position})
+		},
+	})
+	status, errorText := s.runStatus(ctx, run.Model, streamErr, &result)
+	output, err := s.CompleteAssistant(saveCtx, run.AssistantMessageID, output, status)
+	if err != nil {
+		return &RunOutcome{Err: err}
+	}
+	if err := s.CompleteRun(saveCtx, run, status, result, errorText); err != nil {
+		return &RunOutcome{Err: err}
+	}
+	outcome := &RunOutcome{Status: status, Content: output, Error: errorText}
+	if outcome.Sources, err = s.MessageSources(saveCtx, run.AssistantMessageID); err != nil {
+		return &RunOutcome{Err: err}
+	}
+	if outcome.Runs, err = s.MessageRunDetails(saveCtx, run.AssistantMessageID); err != nil {
+		return &RunOutcome{Err: err}
+	}
+	return outcome
+}
+
+// SubscribeRun delivers the updates of a run started with StartRuns that
+// come after the returned snapshot, until the returned function is
+// called or the run finishes. ok is false when the run is not live in
+// this process. deliver is called on the run's goroutine, one update at a
+// time, and must not block; an update being delivered as the returned
+// function is called may still arrive.
+func (s *Service) SubscribeRun(runID string, deliver func(RunUpdate)) (LiveRun, func(), bool) {
+	live := s.live.get(runID)
+	if live == nil {
+		return LiveRun{}, func() {}, false
+	}
+	live.mu.Lock()
+	defer live.mu.Unlock()
+	snapshot := live.snapshot()
+	if snapshot.Finished != nil {
+		return snapshot, func() {}, true
+	}
+	sub := &runSubscriber{deliver: deliver}
+	live.subs = append(live.subs, sub)
+	return snapshot, func() { sub.stopped.Store(true) }, true
+}
+
+// LiveRuns returns snapshots of the chat's live runs in the order they
+// were started, finished ones included until they are forgotten.
+func (s *Service) LiveRuns(chatID string) []LiveRun {
+	s.live.mu.Lock()
+	var runs []*liveRun
+	for _, live := range s.live.byID {
+		if live.state.ChatID == chatID {
+			runs = append(runs, live)
+		}
+	}
+	s.live.mu.Unlock()
+	slices.SortFunc(runs, func(a, b *liveRun) int { return cmp.Compare(a.seq, b.seq) })
+	snapshots := make([]LiveRun, 0, len(runs))
+	for _, live := range runs {
+		live.mu.Lock()
+		snapshots = append(snapshots, live.snapshot())
+		live.mu.Unlock()
+	}
+	return snapshots
+}
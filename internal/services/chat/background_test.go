@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/config"
+)
+
+func TestStartRunsOutlivesThePageThatStartedThem(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	if _, err := store.CreateChat(context.Background(), "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	// The page's context ends as soon as the run is started, as when the
+	// user switches chats.
+	pageCtx, leave := context.WithCancel(context.Background())
+	run := PendingRun{RunID: "run-1", ChatID: "chat-1", UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: ai.MockModel}
+	if err := service.StartRuns(pageCtx, auth.Principal{}, "Hello there", run); err != nil {
+		t.Fatalf("StartRuns() error = %v", err)
+	}
+	leave()
+	_, unsubscribe, ok := service.SubscribeRun("run-1", func(RunUpdate) {})
+	if !ok {
+		t.Fatalf("SubscribeRun() ok = false for a started run")
+	}
+	unsubscribe()
+
+	// A page that comes back follows the run from its snapshot.
+	updates := make(chan RunUpdate, 1024)
+	snapshot, unsubscribe, ok := service.SubscribeRun("run-1", func(update RunUpdate) {
+		updates <- update
+	})
+	if !ok || snapshot.ChatID != "chat-1" || snapshot.AssistantMessageID != "assistant-1" {
+		t.Fatalf("SubscribeRun() = %+v, %v; want the run's snapshot", snapshot, ok)
+	}
+	defer unsubscribe()
+	content := snapshot.Content
+	outcome := snapshot.Finished
+	for outcome == nil {
+		select {
+		case update := <-updates:
+			switch update.Type {
+			case RunUpdateText:
+				content += update.Delta
+			case RunUpdateFinished:
+				outcome = update.Outcome
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run did not finish")
+		}
+	}
+	if outcome.Err != nil || outcome.Status != "completed" || outcome.Content != content || content == "" {
+		t.Fatalf("outcome = %+v, streamed %q; want the completed reply", outcome, content)
+	}
+	if message, err := store.GetMessage(context.Background(), "assistant-1"); err != nil || message.Content != content || message.Status != "completed" {
+		t.Fatalf("assistant-1 = %+v, %v; want the reply saved", message, err)
+	}
+	live := service.LiveRuns("chat-1")
+	if len(live) != 1 || live[0].Finished == nil || live[0].Content != content {
+		t.Fatalf("LiveRuns() = %+v, want the finished run until it is forgotten", live)
+	}
+}
+
+func TestStartRunsSettlesStoredRunsWhenALaterOneFails(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	earlier := PendingRun{RunID: "run-0", ChatID: "chat-1", UserMessageID: "user-0", AssistantMessageID: "assistant-0", Model: ai.MockModel, IdempotencyKey: "taken"}
+	if err := service.PersistRunStart(ctx, earlier, "Earlier"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+
+	// The comparison's second run reuses a key already stored, so it
+	// fails after the first run is stored.
+	first := PendingRun{RunID: "run-1", ChatID: "chat-1", UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: ai.MockModel, ComparisonID: "run-1"}
+	second := PendingRun{RunID: "run-2", ChatID: "chat-1", UserMessageID: "user-1", AssistantMessageID: "assistant-2", Model: ai.MockModel, ComparisonID: "run-1", ReuseUserMessage: true, IdempotencyKey: "taken"}
+	if err := service.StartRuns(ctx, auth.Principal{}, "Compare these", first, second); !errors.Is(err, ErrRunExists) {
+		t.Fatalf("StartRuns() error = %v, want ErrRunExists", err)
+	}
+
+	if live := service.LiveRuns("chat-1"); len(live) != 0 {
+		t.Fatalf("LiveRuns() = %+v, want none executing", live)
+	}
+	if service.CancelRun("run-1") || service.CancelRun("run-2") {
+		t.Fatalf("CancelRun() found a run StartRuns refused")
+	}
+	run, err := store.GetRun(ctx, "run-1")
+	if err != nil || run.Status != "error" || run.ErrorText == "" || !run.FinishedAt.Valid {
+		t.Fatalf("run-1 = %+v, %v; want it saved as an error", run, err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-1"); err != nil || message.Status != "error" {
+		t.Fatalf("assistant-1 = %+v, %v; want it settled as an error", message, err)
+	}
+}
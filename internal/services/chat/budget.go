@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+// ErrBudgetExhausted is returned when a daily or monthly token or cost
+// budget is used up and no run may start until it resets.
+var ErrBudgetExhausted = errors.New("usage budget exhausted")
+
+// Budget units.
+const (
+	BudgetTokens = "tokens"
+	BudgetUSD    = "usd"
+)
+
+// UsageBudget is one configured budget for a UTC day or month. Global
+// budgets are shared by every user. Runs whose cost is unknown count
+// towards token budgets only.
+type UsageBudget struct {
+	Period    string    `json:"period"`
+	Unit      string    `json:"unit"`
+	Global    bool      `json:"global"`
+	Limit     float64   `json:"limit"`
+	Used      float64   `json:"used"`
+	Remaining float64   `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// BudgetsEnabled reports whether any token or cost budget is configured.
+func (s *Service) BudgetsEnabled() bool {
+	cfg := s.settings()
+	return cfg.BudgetTokensPerDay > 0 || cfg.BudgetTokensPerMonth > 0 || cfg.BudgetUSDPerDay > 0 || cfg.BudgetUSDPerMonth > 0
+}
+
+// usageBudgets reports each configured budget as of now, from the usage
+// rollups of the principal or, for a global scope, of everyone.
+func (s *Service) usageBudgets(ctx context.Context, principal auth.Principal, now time.Time) ([]UsageBudget, error) {
+	cfg := s.settings()
+	global := cfg.BudgetScope == "global"
+	limits := []struct {
+		period string
+		unit   string
+		limit  float64
+	}{
+		{db.UsagePeriodDay, BudgetTokens, float64(cfg.BudgetTokensPerDay)},
+		{db.UsagePeriodDay, BudgetUSD, cfg.BudgetUSDPerDay},
+		{db.UsagePeriodMonth, BudgetTokens, float64(cfg.BudgetTokensPerMonth)},
+		{db.UsagePeriodMonth, BudgetUSD, cfg.BudgetUSDPerMonth},
+	}
+	usage := map[string]db.UsageTotals{}
+	var budgets []UsageBudget
+	for _, limit := range limits {
+		if limit.limit <= 0 {
+			continue
+		}
+		totals, ok := usage[limit.period]
+		if !ok {
+			var err error
+			if global {
+				totals, err = s.store.TotalUsage(ctx, limit.period, now)
+			} else {
+				totals, err = s.store.OwnerUsage(ctx, ownerOf(principal), limit.period, now)
+			}
+			if err != nil {
+				return nil, err
+			}
+			usage[limit.period] = totals
+		}
+		used := totals.CostUSD
+		if limit.unit == BudgetTokens {
+			used = float64(totals.Tokens())
+		}
+		budgets = append(budgets, UsageBudget{
+			Period:    limit.period,
+			Unit:      limit.unit,
+			Global:    global,
+			Limit:     limit.limit,
+			Used:      used,
+			Remaining: max(limit.limit-used, 0),
+			ResetsAt:  budgetResetsAt(limit.period, now),
+		})
+	}
+	return budgets, nil
+}
+
+// budgetResetsAt returns the start of the UTC day or month after now.
+func budgetResetsAt(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == db.UsagePeriodMonth {
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// budgetExhaustedError explains which budget ran out and when it resets.
+func budgetExhaustedError(budget UsageBudget) error {
+	limit := FormatUSD(budget.Limit)
+	if budget.Unit == BudgetTokens {
+		limit = fmt.Sprintf("%.0f tokens", budget.Limit)
+	}
+	scope := "your"
+	if budget.Global {
+		scope = "the shared"
+	}
+	resets := "after " + budget.ResetsAt.Local().Format("15:04")
+	if budget.Period == db.UsagePeriodMonth {
+		resets = "on " + budget.ResetsAt.Local().Format("Jan 2")
+	}
+	return fmt.Errorf("%w: %s budget of %s per %s is used up; try again %s", ErrBudgetExhausted, scope, limit, budget.Period, resets)
+}
+
+// recordRunUsage adds a finished run to the usage rollups budgets are
+// checked against.
+func (s *Service) recordRunUsage(ctx context.Context, run PendingRun, result StreamResult, cost float64, finishedAt time.Time) error {
+	input, output := ai.TokenCounts(result.Usage)
+	return s.store.AddRunUsage(ctx, run.ChatID, finishedAt, input, output, cost)
+}
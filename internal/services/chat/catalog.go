@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// toolUsageWindow is how far back the catalog counts calls.
+const toolUsageWindow = 7 * 24 * time.Hour
+
+// CatalogTool is a registry tool with its schemas and recent usage, for the
+// tool catalog page. Schemas are indented JSON, empty for provider-native
+// tools.
+type CatalogTool struct {
+	Name         string
+	Description  string
+	Source       string
+	Native       bool
+	InputSchema  string
+	OutputSchema string
+	Timeout      time.Duration
+	// Enabled is the tool's state in the chat the catalog was built for;
+	// without a chat it is the default, true.
+	Enabled bool
+	// DisabledChats counts the chats that switched the tool off.
+	DisabledChats int
+	Calls         int
+	Errors        int
+	LastUsedAt    time.Time
+}
+
+// ToolCatalog lists every registered tool with usage over the last
+// toolUsageWindow. When chatID is set, Enabled reflects that chat's toggle.
+func (s *Service) ToolCatalog(ctx context.Context, chatID string) ([]CatalogTool, error) {
+	usage, err := s.store.ToolUsageSince(ctx, time.Now().UTC().Add(-toolUsageWindow))
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := s.store.CountDisabledChatTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]bool{}
+	if chatID != "" {
+		if settings, err = s.store.ChatToolSettings(ctx, chatID); err != nil {
+			return nil, err
+		}
+	}
+
+	registered := s.toolRegistry().Tools()
+	catalog := make([]CatalogTool, 0, len(registered))
+	for _, tool := range registered {
+		enabled, ok := settings[tool.Name]
+		entry := CatalogTool{
+			Name:          tool.Name,
+			Description:   tool.Description,
+			Source:        tool.Source,
+			Native:        tool.IsNative(),
+			InputSchema:   indentJSON(tool.InputSchema),
+			OutputSchema:  indentJSON(tool.OutputSchema),
+			Timeout:       tool.Timeout,
+			Enabled:       !ok || enabled,
+			DisabledChats: disabled[tool.Name],
+		}
+		if stats, ok := usage[tool.Name]; ok {
+			entry.Calls = stats.Calls
+			entry.Errors = stats.Errors
+			entry.LastUsedAt = stats.LastUsedAt
+		}
+		catalog = append(catalog, entry)
+	}
+	return catalog, nil
+}
+
+func indentJSON[T any](value *T) string {
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+// CheckpointResult reports a WAL checkpoint; see db.Store.Checkpoint.
+type CheckpointResult = db.CheckpointResult
+
+// Checkpoint copies the database's WAL into the database file in mode,
+// PASSIVE when empty.
+func (s *Service) Checkpoint(ctx context.Context, mode string) (CheckpointResult, error) {
+	return s.store.Checkpoint(ctx, mode)
+}
+
+// CheckpointJob returns the scheduler job that checkpoints the WAL in
+// mode. A checkpoint kept from completing by readers or writers is logged
+// and tried again on the next tick.
+func (s *Service) CheckpointJob(mode string, logger *slog.Logger) func(ctx context.Context, scheduled time.Time) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, _ time.Time) error {
+		result, err := s.Checkpoint(ctx, mode)
+		if err != nil {
+			return err
+		}
+		if result.Busy {
+			logger.Warn("wal checkpoint did not complete", "mode", result.Mode, "wal_frames", result.WALFrames, "checkpointed_frames", result.Checkpointed)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+)
+
+// maxClonedMessages bounds the messages copied into a duplicated chat.
+const maxClonedMessages = 2000
+
+// CloneOptions says what a duplicated chat takes from the original besides
+// its messages.
+type CloneOptions struct {
+	// KeepSettings copies the model and generation parameters. Without it
+	// the copy starts on the default model with provider defaults. The
+	// system prompt is server-wide, so every copy gets the current one.
+	KeepSettings bool
+}
+
+// CloneChat copies a chat's conversation into a new chat owned by the
+// principal, for reusing a long setup conversation. Replies still
+// streaming and the second model's side of comparisons are left out, and
+// attachments, runs and share links stay with the original.
+func (s *Service) CloneChat(ctx context.Context, principal auth.Principal, chatID string, opts CloneOptions) (Chat, error) {
+	source, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return Chat{}, err
+	}
+	rows, err := s.store.ListMessages(ctx, source.ID, maxClonedMessages)
+	if err != nil {
+		return Chat{}, err
+	}
+	alternates, err := s.comparisonAlternates(ctx, source.ID)
+	if err != nil {
+		return Chat{}, err
+	}
+
+	now := time.Now().UTC()
+	clone := Chat{
+		ID:        uuid.NewString(),
+		Title:     source.Title + " (copy)",
+		Model:     s.DefaultModel(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	clone.OwnerID.String = ownerOf(principal)
+	clone.OwnerID.Valid = clone.OwnerID.String != ""
+	if opts.KeepSettings {
+		if s.IsAllowedModel(source.Model) {
+			clone.Model = source.Model
+		}
+		clone.Temperature = source.Temperature
+		clone.MaxTokens = source.MaxTokens
+		clone.TopP = source.TopP
+		clone.ReasoningEffort = source.ReasoningEffort
+	}
+
+	messages := make([]Message, 0, len(rows))
+	for _, row := range rows {
+		if row.Status == "streaming" || alternates[row.ID] {
+			continue
+		}
+		row.ID = uuid.NewString()
+		row.ChatID = clone.ID
+		messages = append(messages, row)
+	}
+	if err := s.store.CloneChat(ctx, clone, messages); err != nil {
+		return Chat{}, err
+	}
+	s.publishChatCreated(ctx, clone)
+	return clone, nil
+}
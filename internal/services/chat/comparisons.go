@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"rhone_chat/internal/auth"
+)
+
+// Comparison is one prompt answered side by side by two models. ID is the
+// first run's ID; its answer is the one later turns of the chat build on.
+type Comparison struct {
+	ID            string             `json:"id"`
+	ChatID        string             `json:"chat_id"`
+	UserMessageID string             `json:"user_message_id"`
+	Prompt        string             `json:"prompt"`
+	Answers       []ComparisonAnswer `json:"answers"`
+}
+
+// ComparisonAnswer is one model's side of a comparison.
+type ComparisonAnswer struct {
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"`
+	Run       RunDetail `json:"run"`
+}
+
+// ValidateComparison checks that two different allowed models were picked
+// for a comparison.
+func (s *Service) ValidateComparison(model, compareModel string) error {
+	if !s.IsAllowedModel(model) || !s.IsAllowedModel(compareModel) {
+		return fmt.Errorf("compare needs two available models, got %q and %q", model, compareModel)
+	}
+	if model == compareModel {
+		return errors.New("compare needs two different models")
+	}
+	return nil
+}
+
+// Comparisons returns a chat's side-by-side comparisons, oldest first, for
+// evaluating the models against each other.
+func (s *Service) Comparisons(ctx context.Context, principal auth.Principal, chatID string) ([]Comparison, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := s.store.ListComparisonRuns(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	comparisons := []Comparison{}
+	index := map[string]int{}
+	for _, run := range runs {
+		position, ok := index[run.ComparisonID]
+		if !ok {
+			prompt, err := s.store.GetMessage(ctx, run.UserMessageID)
+			if err != nil {
+				return nil, err
+			}
+			position = len(comparisons)
+			index[run.ComparisonID] = position
+			comparisons = append(comparisons, Comparison{
+				ID:            run.ComparisonID,
+				ChatID:        chat.ID,
+				UserMessageID: run.UserMessageID,
+				Prompt:        prompt.Content,
+			})
+		}
+		answer, err := s.store.GetMessage(ctx, run.AssistantMessageID)
+		if err != nil {
+			return nil, err
+		}
+		comparisons[position].Answers = append(comparisons[position].Answers, ComparisonAnswer{
+			MessageID: answer.ID,
+			Content:   answer.Content,
+			Run:       runDetailFromRow(run),
+		})
+	}
+	return comparisons, nil
+}
+
+// comparisonAlternates returns the assistant messages written by the
+// second model of each comparison in a chat. History leaves them out so
+// the conversation carries on from the first model's answer.
+func (s *Service) comparisonAlternates(ctx context.Context, chatID string) (map[string]bool, error) {
+	runs, err := s.store.ListComparisonRuns(ctx, strings.TrimSpace(chatID))
+	if err != nil {
+		return nil, err
+	}
+	alternates := map[string]bool{}
+	for _, run := range runs {
+		if run.ComparisonID != run.ID {
+			alternates[run.AssistantMessageID] = true
+		}
+	}
+	return alternates, nil
+}
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+
+	"rhone_chat/internal/ai"
+)
+
+const (
+	// summaryReserveTokens is held back for the summary of trimmed messages.
+	summaryReserveTokens = 1000
+	// minHistoryTokens keeps some room for the latest message even when the
+	// reserves exceed a small context window.
+	minHistoryTokens = 1024
+)
+
+// ErrContextOverflow is returned when a request does not fit the model's
+// context window even with every older message left out.
+var ErrContextOverflow = errors.New("request does not fit the model's context window")
+
+// ContextUsage is how much of a model's context window a request takes.
+type ContextUsage struct {
+	Model         string `json:"model"`
+	ContextWindow int    `json:"context_window"`
+	// PromptTokens estimates the system prompt and messages sent.
+	PromptTokens int `json:"prompt_tokens"`
+	// ReserveTokens is kept free for the reply.
+	ReserveTokens int `json:"reserve_tokens"`
+}
+
+// Fits reports whether the prompt leaves the reply its reserve.
+func (u ContextUsage) Fits() bool {
+	return u.PromptTokens+u.ReserveTokens <= u.ContextWindow
+}
+
+// Err returns ErrContextOverflow, with the numbers, when the request does
+// not fit.
+func (u ContextUsage) Err() error {
+	if u.Fits() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s takes %d tokens, and this request needs about %d plus %d for the reply; shorten the message or remove attachments",
+		ErrContextOverflow, u.Model, u.ContextWindow, u.PromptTokens, u.ReserveTokens)
+}
+
+// ContextWindow returns model's context window in tokens.
+func (s *Service) ContextWindow(model string) int {
+	if s.runner != nil {
+		return s.runner.ContextWindow(model)
+	}
+	return ai.ContextWindow(model)
+}
+
+// DraftContextUsage estimates the context a draft takes on its own with the
+// system prompt for locale, so the composer can warn before a message too
+// long for model is sent. Chat history is left out: it is trimmed to fit.
+func (s *Service) DraftContextUsage(model, locale, draft string) ContextUsage {
+	return ContextUsage{
+		Model:         model,
+		ContextWindow: s.ContextWindow(model),
+		PromptTokens:  ai.EstimateTokens(s.systemPrompt(locale)) + ai.EstimateMessageTokens(AIMessage{Role: "user", Content: draft}),
+		ReserveTokens: s.settings().ResponseReserveTokens,
+	}
+}
+
+// contextUsage estimates what history takes of the chat model's context
+// window.
+func (s *Service) contextUsage(chat Chat, history []AIMessage) ContextUsage {
+	usage := ContextUsage{
+		Model:         chat.Model,
+		ContextWindow: s.ContextWindow(chat.Model),
+		ReserveTokens: replyReserve(chat, s.settings().ResponseReserveTokens),
+	}
+	for _, message := range history {
+		usage.PromptTokens += ai.EstimateMessageTokens(message)
+	}
+	return usage
+}
+
+// replyReserve is the room kept for a chat's reply: its max tokens setting,
+// or the configured reserve.
+func replyReserve(chat Chat, fallback int) int {
+	if chat.MaxTokens.Valid && chat.MaxTokens.Int64 > 0 {
+		return int(chat.MaxTokens.Int64)
+	}
+	return fallback
+}
+
+// fitContextWindow drops the oldest messages until the history fits the
+// chat model's context window, leaving room for the reply, the summary and
+// knowledge base excerpts. The latest message is always kept, even when it
+// alone is too long; the request's ContextUsage reports that. Dropped
+// messages are appended to dropped, oldest first, so they can be summarized.
+func (s *Service) fitContextWindow(chat Chat, history []AIMessage, messageIDs []string, dropped []droppedMessage) ([]AIMessage, []droppedMessage) {
+	if len(history) < 2 {
+		return history, dropped
+	}
+	reserve := replyReserve(chat, s.settings().ResponseReserveTokens)
+	if s.settings().SummaryEnabled && s.runner != nil {
+		reserve += summaryReserveTokens
+	}
+	// Knowledge excerpts, at a conservative three bytes per token.
+	reserve += s.knowledge.MaxBytes() / 3
+	budget := max(s.ContextWindow(chat.Model)-reserve-ai.EstimateMessageTokens(history[0]), minHistoryTokens)
+
+	start := len(history) - 1
+	used := ai.EstimateMessageTokens(history[start])
+	for start > 1 {
+		cost := ai.EstimateMessageTokens(history[start-1])
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start--
+	}
+	for i := 1; i < start; i++ {
+		dropped = append(dropped, droppedMessage{ID: messageIDs[i], Role: history[i].Role, Content: history[i].Content})
+	}
+	return append(history[:1], history[start:]...), dropped
+}
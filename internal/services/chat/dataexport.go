@@ -0,0 +1,498 @@
+package chat
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/blob"
+	"rhone_chat/internal/db"
+)
+
+// Data export statuses; see db.DataExport.
+const (
+	DataExportPending = "pending"
+	DataExportRunning = "running"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+const (
+	// maxListedDataExports bounds the exports one user lists.
+	maxListedDataExports = 20
+	// dataExportBatch bounds the exports one pass of the job builds.
+	dataExportBatch = 5
+)
+
+// DataExport is a user's request for an archive of their data.
+type DataExport = db.DataExport
+
+// ErrExportInProgress is returned when requesting a data export while one
+// is still being built.
+var ErrExportInProgress = errors.New("a data export is already in progress")
+
+// ErrExportNotReady is returned when downloading a data export that has not
+// been built.
+var ErrExportNotReady = errors.New("data export is not ready")
+
+// RequestDataExport queues an archive of everything the principal has
+// stored: their chats with messages, runs, tool calls and attachments, and
+// their settings. The data exports job builds it.
+func (s *Service) RequestDataExport(ctx context.Context, principal auth.Principal) (DataExport, error) {
+	exports, err := s.store.ListDataExports(ctx, principal.UserID, maxListedDataExports)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, export := range exports {
+		if export.Status == DataExportPending || export.Status == DataExportRunning {
+			return DataExport{}, ErrExportInProgress
+		}
+	}
+	export := DataExport{
+		ID:        uuid.NewString(),
+		UserID:    principal.UserID,
+		Status:    DataExportPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.InsertDataExport(ctx, export); err != nil {
+		return DataExport{}, err
+	}
+	return export, nil
+}
+
+// DataExports returns the principal's recent data exports, newest first.
+func (s *Service) DataExports(ctx context.Context, principal auth.Principal) ([]DataExport, error) {
+	return s.store.ListDataExports(ctx, principal.UserID, maxListedDataExports)
+}
+
+// DataExport returns one of the principal's data exports. Other users'
+// exports are reported as not found.
+func (s *Service) DataExport(ctx context.Context, principal auth.Principal, exportID string) (DataExport, error) {
+	export, err := s.store.GetDataExport(ctx, strings.TrimSpace(exportID))
+	if err != nil {
+		return DataExport{}, err
+	}
+	if export.UserID != principal.UserID {
+		return DataExport{}, db.ErrNotFound
+	}
+	return export, nil
+}
+
+// OpenDataExport opens the archive of one of the principal's ready data
+// exports. The caller closes it.
+func (s *Service) OpenDataExport(ctx context.Context, principal auth.Principal, exportID string) (DataExport, io.ReadCloser, error) {
+	export, err := s.DataExport(ctx, principal, exportID)
+	if err != nil {
+		return DataExport{}, nil, err
+	}
+	if export.Status != DataExportReady {
+		return DataExport{}, nil, ErrExportNotReady
+	}
+	archive, err := s.exports.Get(ctx, dataExportKey(export.ID))
+	if errors.Is(err, blob.ErrNotExist) {
+		return DataExport{}, nil, db.ErrNotFound
+	}
+	if err != nil {
+		return DataExport{}, nil, fmt.Errorf("open data export: %w", err)
+	}
+	return export, archive, nil
+}
+
+func dataExportKey(exportID string) string {
+	return exportID + ".zip"
+}
+
+// DataExportsJob returns the scheduler job that builds requested data
+// exports and deletes expired ones.
+func (s *Service) DataExportsJob(logger *slog.Logger) func(ctx context.Context, scheduled time.Time) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, _ time.Time) error {
+		return s.BuildDataExports(ctx, time.Now().UTC(), logger)
+	}
+}
+
+// BuildDataExports deletes the exports that expired by now, then builds the
+// unfinished ones, oldest first. An export left running by a crash is built
+// again from the start.
+func (s *Service) BuildDataExports(ctx context.Context, now time.Time, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	expired, err := s.store.ExpiredDataExports(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, export := range expired {
+		if err := s.exports.Delete(ctx, dataExportKey(export.ID)); err != nil {
+			logger.Warn("remove expired data export", "export_id", export.ID, "error", err)
+			continue
+		}
+		if err := s.store.DeleteDataExport(ctx, export.ID); err != nil {
+			return err
+		}
+	}
+
+	unfinished, err := s.store.UnfinishedDataExports(ctx, dataExportBatch)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, export := range unfinished {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.store.StartDataExport(ctx, export.ID); err != nil {
+			return err
+		}
+		status, errorText := DataExportReady, ""
+		size, err := s.buildDataExport(ctx, export, now)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Left running, the export is built again after the restart.
+				return ctx.Err()
+			}
+			logger.Error("data export failed", "export_id", export.ID, "user_id", export.UserID, "error", err)
+			status, errorText = DataExportFailed, err.Error()
+			failed++
+		}
+		finishedAt := time.Now().UTC()
+		if err := s.store.FinishDataExport(ctx, export.ID, status, size, errorText, finishedAt, finishedAt.Add(s.settings().DataExportTTL)); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d data exports failed", failed, len(unfinished))
+	}
+	return nil
+}
+
+// buildDataExport writes the export's archive to a file in DataExportDir,
+// stores it and returns its size. The stores only show complete blobs, so
+// a download never sees a partial archive.
+func (s *Service) buildDataExport(ctx context.Context, export DataExport, now time.Time) (int64, error) {
+	dir := s.settings().DataExportDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, fmt.Errorf("create data export dir: %w", err)
+	}
+	// Hidden, so the disk store does not list it.
+	file, err := os.CreateTemp(dir, ".partial-*.zip")
+	if err != nil {
+		return 0, fmt.Errorf("create data export: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	err = s.writeDataExport(ctx, archive, export.UserID, now)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("rewind data export: %w", err)
+	}
+	if err := s.exports.Put(ctx, dataExportKey(export.ID), file, size); err != nil {
+		return 0, fmt.Errorf("store data export: %w", err)
+	}
+	return size, nil
+}
+
+// exportedAccount is account.json in a data export archive. Each chat has a
+// chats/<chat ID>/ directory beside it holding chat.json, messages.json,
+// runs.json (each run with its tool calls) and the chat's files in
+// attachments/.
+type exportedAccount struct {
+	UserID     string            `json:"user_id"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Settings   map[string]string `json:"settings"`
+	ChatIDs    []string          `json:"chat_ids"`
+}
+
+type exportedChat struct {
+	ID              string               `json:"id"`
+	Title           string               `json:"title"`
+	Model           string               `json:"model"`
+	Temperature     *float64             `json:"temperature,omitempty"`
+	MaxTokens       *int64               `json:"max_tokens,omitempty"`
+	TopP            *float64             `json:"top_p,omitempty"`
+	ReasoningEffort string               `json:"reasoning_effort,omitempty"`
+	MaxSpendUSD     *float64             `json:"max_spend_usd,omitempty"`
+	KeepForever     bool                 `json:"keep_forever,omitempty"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+	Attachments     []exportedAttachment `json:"attachments"`
+}
+
+type exportedAttachment struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"message_id,omitempty"`
+	FileName  string    `json:"file_name"`
+	MediaType string    `json:"media_type"`
+	SizeBytes int64     `json:"size_bytes"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedMessage struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Reasoning string    `json:"reasoning,omitempty"`
+	Status    string    `json:"status"`
+	Flag      string    `json:"flag,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type exportedRun struct {
+	ID                 string             `json:"id"`
+	UserMessageID      string             `json:"user_message_id"`
+	AssistantMessageID string             `json:"assistant_message_id"`
+	Model              string             `json:"model"`
+	Status             string             `json:"status"`
+	StopReason         string             `json:"stop_reason,omitempty"`
+	Error              string             `json:"error,omitempty"`
+	InputTokens        int                `json:"input_tokens"`
+	OutputTokens       int                `json:"output_tokens"`
+	CostUSD            *float64           `json:"cost_usd,omitempty"`
+	StartedAt          time.Time          `json:"started_at"`
+	FinishedAt         *time.Time         `json:"finished_at,omitempty"`
+	ToolCalls          []exportedToolCall `json:"tool_calls"`
+}
+
+type exportedToolCall struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Status     string          `json:"status"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+func (s *Service) writeDataExport(ctx context.Context, archive *zip.Writer, userID string, now time.Time) error {
+	settings, err := s.store.ListUserSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	chats, err := s.store.ListOwnedChats(ctx, ownerOf(auth.Principal{UserID: userID}))
+	if err != nil {
+		return err
+	}
+	account := exportedAccount{UserID: userID, ExportedAt: now, Settings: settings, ChatIDs: make([]string, 0, len(chats))}
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.writeExportedChat(ctx, archive, chat); err != nil {
+			return fmt.Errorf("export chat %s: %w", chat.ID, err)
+		}
+		account.ChatIDs = append(account.ChatIDs, chat.ID)
+	}
+	return writeZipJSON(archive, "account.json", account)
+}
+
+func (s *Service) writeExportedChat(ctx context.Context, archive *zip.Writer, chat Chat) error {
+	dir := "chats/" + chat.ID + "/"
+	messages, err := s.store.ListMessages(ctx, chat.ID, maxExportMessages)
+	if err != nil {
+		return err
+	}
+	exportedMessages := make([]exportedMessage, 0, len(messages))
+	for _, message := range messages {
+		exportedMessages = append(exportedMessages, exportedMessage{
+			ID:        message.ID,
+			Role:      message.Role,
+			Content:   message.Content,
+			Reasoning: message.Reasoning,
+			Status:    message.Status,
+			Flag:      message.Flag,
+			CreatedAt: message.CreatedAt,
+			UpdatedAt: message.UpdatedAt,
+		})
+	}
+	if err := writeZipJSON(archive, dir+"messages.json", exportedMessages); err != nil {
+		return err
+	}
+
+	runs, err := s.store.ListRunsForChat(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+	calls, err := s.store.ListChatToolCalls(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+	callsByRun := map[string][]exportedToolCall{}
+	for _, call := range calls {
+		callsByRun[call.RunID] = append(callsByRun[call.RunID], exportedToolCall{
+			ID:         call.ID,
+			Name:       call.Name,
+			Status:     call.Status,
+			Input:      rawJSON(call.InputJSON),
+			Output:     rawJSON(call.OutputJSON),
+			Error:      call.ErrorText,
+			StartedAt:  call.StartedAt,
+			FinishedAt: nullTime(call.FinishedAt.Time, call.FinishedAt.Valid),
+		})
+	}
+	exportedRuns := make([]exportedRun, 0, len(runs))
+	for _, run := range runs {
+		exported := exportedRun{
+			ID:                 run.ID,
+			UserMessageID:      run.UserMessageID,
+			AssistantMessageID: run.AssistantMessageID,
+			Model:              run.Model,
+			Status:             run.Status,
+			StopReason:         run.StopReason,
+			Error:              run.ErrorText,
+			InputTokens:        run.InputTokens,
+			OutputTokens:       run.OutputTokens,
+			StartedAt:          run.StartedAt,
+			FinishedAt:         nullTime(run.FinishedAt.Time, run.FinishedAt.Valid),
+			ToolCalls:          callsByRun[run.ID],
+		}
+		if run.CostUSD.Valid {
+			exported.CostUSD = &run.CostUSD.Float64
+		}
+		if exported.ToolCalls == nil {
+			exported.ToolCalls = []exportedToolCall{}
+		}
+		exportedRuns = append(exportedRuns, exported)
+	}
+	if err := writeZipJSON(archive, dir+"runs.json", exportedRuns); err != nil {
+		return err
+	}
+
+	claimed, err := s.store.ListChatAttachments(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+	pending, err := s.store.ListPendingAttachments(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+	exportedChat := exportedChat{
+		ID:              chat.ID,
+		Title:           chat.Title,
+		Model:           chat.Model,
+		ReasoningEffort: chat.ReasoningEffort.String,
+		KeepForever:     chat.KeepForever,
+		CreatedAt:       chat.CreatedAt,
+		UpdatedAt:       chat.UpdatedAt,
+		Attachments:     []exportedAttachment{},
+	}
+	if chat.Temperature.Valid {
+		exportedChat.Temperature = &chat.Temperature.Float64
+	}
+	if chat.MaxTokens.Valid {
+		exportedChat.MaxTokens = &chat.MaxTokens.Int64
+	}
+	if chat.TopP.Valid {
+		exportedChat.TopP = &chat.TopP.Float64
+	}
+	if chat.MaxSpendUSD.Valid {
+		exportedChat.MaxSpendUSD = &chat.MaxSpendUSD.Float64
+	}
+	for _, attachment := range append(claimed, pending...) {
+		name := dir + "attachments/" + attachment.ID + "-" + exportFileName(attachment.FileName)
+		if err := s.writeExportedAttachment(ctx, archive, name, attachment); err != nil {
+			return fmt.Errorf("attachment %s: %w", attachment.ID, err)
+		}
+		exportedChat.Attachments = append(exportedChat.Attachments, exportedAttachment{
+			ID:        attachment.ID,
+			MessageID: attachment.MessageID.String,
+			FileName:  attachment.FileName,
+			MediaType: attachment.MediaType,
+			SizeBytes: attachment.SizeBytes,
+			Path:      name,
+			CreatedAt: attachment.CreatedAt,
+		})
+	}
+	return writeZipJSON(archive, dir+"chat.json", exportedChat)
+}
+
+// writeExportedAttachment copies an attachment's bytes into the archive
+// from the attachment store, or from the database when it is stored inline.
+func (s *Service) writeExportedAttachment(ctx context.Context, archive *zip.Writer, name string, attachment db.Attachment) error {
+	var source io.Reader
+	if attachment.StoragePath != "" {
+		stored, err := s.openAttachment(ctx, attachment.StoragePath)
+		if err != nil {
+			return err
+		}
+		defer stored.Close()
+		source = stored
+	} else {
+		stored, err := s.store.GetAttachment(ctx, attachment.ID)
+		if err != nil {
+			return err
+		}
+		source = bytes.NewReader(stored.Data)
+	}
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: attachment.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, source)
+	return err
+}
+
+func writeZipJSON(archive *zip.Writer, name string, value any) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// exportFileName reduces an uploaded file name to a safe archive entry name.
+func exportFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// rawJSON passes stored JSON through as is, and quotes anything else as a
+// string.
+func rawJSON(value string) json.RawMessage {
+	if value == "" {
+		return nil
+	}
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+func nullTime(t time.Time, valid bool) *time.Time {
+	if !valid {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/events"
+)
+
+// DataDeletion counts what DeleteAllData removed.
+type DataDeletion = db.UserDataDeletion
+
+// DeleteAllData permanently removes everything the principal has stored:
+// their chats with all messages, runs, tool calls, attachments and
+// embeddings, the audit entries about them, and their settings, drafts,
+// keys and data exports. Runs in flight in their chats are stopped first.
+// The database rows go in one transaction; stored files follow once it
+// commits.
+func (s *Service) DeleteAllData(ctx context.Context, principal auth.Principal) (DataDeletion, error) {
+	if principal.IsZero() {
+		return DataDeletion{}, errors.New("a user is required")
+	}
+	owner := ownerOf(principal)
+	chats, err := s.store.ListOwnedChats(ctx, owner)
+	if err != nil {
+		return DataDeletion{}, err
+	}
+	var storagePaths []string
+	for _, chat := range chats {
+		runs, err := s.store.ListRunsForChat(ctx, chat.ID)
+		if err != nil {
+			return DataDeletion{}, err
+		}
+		for _, run := range runs {
+			if run.Status == "running" {
+				s.stopTrackedRun(ctx, run.ID)
+			}
+		}
+		paths, err := s.store.ListChatStoragePaths(ctx, chat.ID)
+		if err != nil {
+			return DataDeletion{}, err
+		}
+		storagePaths = append(storagePaths, paths...)
+	}
+	exports, err := s.store.ListDataExports(ctx, principal.UserID, -1)
+	if err != nil {
+		return DataDeletion{}, err
+	}
+
+	deleted, err := s.store.DeleteUserData(ctx, principal.UserID, owner)
+	if err != nil {
+		return DataDeletion{}, err
+	}
+	s.removeAttachments(ctx, storagePaths...)
+	for _, export := range exports {
+		if err := s.exports.Delete(ctx, dataExportKey(export.ID)); err != nil {
+			slog.Warn("remove data export", "export_id", export.ID, "error", err)
+		}
+	}
+	for _, chat := range chats {
+		s.events.Publish(events.Event{Type: events.ChatDeleted, ChatID: chat.ID})
+	}
+	slog.Info("user data deleted", "user_id", principal.UserID, "chats", deleted.Chats, "messages", deleted.Messages, "runs", deleted.Runs, "attachments", deleted.Attachments)
+	return deleted, nil
+}
+
+// stopTrackedRun cancels a run in flight in this process and waits briefly
+// for it to save its outcome.
+func (s *Service) stopTrackedRun(ctx context.Context, runID string) {
+	done, ok := s.cancelTracked(runID)
+	if !ok {
+		return
+	}
+	wait, cancel := context.WithTimeout(ctx, stopRunWait)
+	defer cancel()
+	select {
+	case <-done:
+	case <-wait.Done():
+	}
+}
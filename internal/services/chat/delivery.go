@@ -0,0 +1,394 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+var (
+	// ErrInvalidRun is returned for API run requests that can never succeed
+	// as sent.
+	ErrInvalidRun = errors.New("invalid run request")
+	// ErrRunExists is returned when a client resubmits a run ID that was
+	// already accepted; the run's receipt tells it where things stand.
+	ErrRunExists = errors.New("run already exists")
+)
+
+// RunExistsError is ErrRunExists for a message resent with the idempotency
+// key of the run RunID.
+type RunExistsError struct {
+	RunID string
+}
+
+func (e *RunExistsError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrRunExists, e.RunID)
+}
+
+func (e *RunExistsError) Unwrap() error {
+	return ErrRunExists
+}
+
+// maxIdempotencyKey bounds the idempotency keys clients send.
+const maxIdempotencyKey = 255
+
+// Run lifecycle events, in the order an API client sees them. Queued is
+// sent while the run waits for a stream slot, each time its place in line
+// changes; streaming is sent once per text chunk; completed is always last
+// and carries the final status.
+const (
+	RunEventAccepted  = "accepted"
+	RunEventPersisted = "persisted"
+	RunEventQueued    = "queued"
+	RunEventStreaming = "streaming"
+	RunEventCompleted = "completed"
+)
+
+var runIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// RunEvent is one step of a run's lifecycle. ID is "<run_id>:<seq>", stable
+// for the run, so clients can de-duplicate events they have already seen.
+type RunEvent struct {
+	ID                 string    `json:"id"`
+	Seq                int       `json:"seq"`
+	Type               string    `json:"type"`
+	RunID              string    `json:"run_id"`
+	ChatID             string    `json:"chat_id"`
+	UserMessageID      string    `json:"user_message_id"`
+	AssistantMessageID string    `json:"assistant_message_id"`
+	Delta              string    `json:"delta,omitempty"`
+	Content            string    `json:"content,omitempty"`
+	Status             string    `json:"status,omitempty"`
+	Error              string    `json:"error,omitempty"`
+	Sources            []Source  `json:"sources,omitempty"`
+	At                 time.Time `json:"at"`
+	// Structured is the parsed reply of a run sent with a schema, on the
+	// completed event.
+	Structured json.RawMessage `json:"structured,omitempty"`
+	// StopReason is on the completed event: why the model stopped, or for
+	// a failed run the ai.ErrorKind of the failure when it is known.
+	StopReason string `json:"stop_reason,omitempty"`
+	// Position is the run's place in line on queued events, 1 being next.
+	Position int `json:"position,omitempty"`
+}
+
+// APIRunRequest is a message sent through the API. RunID is optional; a
+// client that sets it can safely resend the request after a dropped
+// connection, since a second attempt with the same ID is refused with
+// ErrRunExists instead of starting another run. IdempotencyKey does the
+// same for clients that do not pick run IDs: a second message to the chat
+// with the same key is refused with a RunExistsError naming the first
+// run. Schema, when set, is a JSON schema the reply must match; see
+// ai.ParseOutputSchema.
+type APIRunRequest struct {
+	ChatID         string
+	Content        string
+	Model          string
+	RunID          string
+	IdempotencyKey string
+	Locale         string
+	Schema         json.RawMessage
+}
+
+// RunReceipt is the persisted state of a run, for clients resuming after a
+// dropped stream.
+type RunReceipt struct {
+	RunID              string     `json:"run_id"`
+	ChatID             string     `json:"chat_id"`
+	UserMessageID      string     `json:"user_message_id"`
+	AssistantMessageID string     `json:"assistant_message_id"`
+	Model              string     `json:"model"`
+	IdempotencyKey     string     `json:"idempotency_key,omitempty"`
+	Status             string     `json:"status"`
+	Content            string     `json:"content"`
+	Error              string     `json:"error,omitempty"`
+	Sources            []Source   `json:"sources"`
+	StartedAt          time.Time  `json:"started_at"`
+	FinishedAt         *time.Time `json:"finished_at,omitempty"`
+	// Structured is the parsed reply when the run was sent with a schema and
+	// the reply matched it.
+	Structured json.RawMessage `json:"structured,omitempty"`
+}
+
+// RunReceipt returns the current state of a run in a chat the principal
+// may use.
+func (s *Service) RunReceipt(ctx context.Context, principal auth.Principal, runID string) (RunReceipt, error) {
+	run, err := s.store.GetRun(ctx, strings.TrimSpace(runID))
+	if err != nil {
+		return RunReceipt{}, err
+	}
+	if _, err := s.authorizeChat(ctx, principal, run.ChatID); err != nil {
+		return RunReceipt{}, err
+	}
+	receipt := RunReceipt{
+		RunID:              run.ID,
+		ChatID:             run.ChatID,
+		UserMessageID:      run.UserMessageID,
+		AssistantMessageID: run.AssistantMessageID,
+		Model:              run.Model,
+		IdempotencyKey:     run.IdempotencyKey,
+		Status:             run.Status,
+		Error:              run.ErrorText,
+		StartedAt:          run.StartedAt,
+	}
+	if run.FinishedAt.Valid {
+		finishedAt := run.FinishedAt.Time
+		receipt.FinishedAt = &finishedAt
+	}
+	message, err := s.store.GetMessage(ctx, run.AssistantMessageID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return RunReceipt{}, err
+	}
+	receipt.Content = message.Content
+	if receipt.Sources, err = s.MessageSources(ctx, run.AssistantMessageID); err != nil {
+		return RunReceipt{}, err
+	}
+	structured, err := s.store.MessageStructured(ctx, run.AssistantMessageID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return RunReceipt{}, err
+	}
+	if structured != "" {
+		receipt.Structured = json.RawMessage(structured)
+	}
+	return receipt, nil
+}
+
+// ExecuteRun sends a user message and streams the reply, reporting each
+// lifecycle step to emit. Errors returned before the accepted event mean
+// nothing was stored. Once accepted, the run always ends with a completed
+// event, even if the caller's context is cancelled, and the returned error
+// is only set when the outcome could not be saved.
+func (s *Service) ExecuteRun(ctx context.Context, principal auth.Principal, request APIRunRequest, emit func(RunEvent)) error {
+	content := strings.TrimSpace(request.Content)
+	if content == "" {
+		return fmt.Errorf("%w: content is required", ErrInvalidRun)
+	}
+	if _, err := s.authorizeChat(ctx, principal, request.ChatID); err != nil {
+		return err
+	}
+	model := request.Model
+	if model == "" {
+		model = s.DefaultModel()
+	}
+	if !s.IsAllowedModel(model) {
+		return fmt.Errorf("%w: model %q is not available", ErrInvalidRun, model)
+	}
+	run := PendingRun{
+		RunID:              request.RunID,
+		ChatID:             strings.TrimSpace(request.ChatID),
+		UserMessageID:      uuid.NewString(),
+		AssistantMessageID: uuid.NewString(),
+		Model:              model,
+		Locale:             request.Locale,
+		IdempotencyKey:     strings.TrimSpace(request.IdempotencyKey),
+	}
+	if len(request.Schema) > 0 && string(request.Schema) != "null" {
+		schema, err := ai.ParseOutputSchema(request.Schema)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRun, err)
+		}
+		run.OutputSchema = schema
+	}
+	if run.RunID == "" {
+		run.RunID = uuid.NewString()
+	} else if !runIDPattern.MatchString(run.RunID) {
+		return fmt.Errorf("%w: run id must be 1-64 letters, digits, '-' or '_'", ErrInvalidRun)
+	} else if _, err := s.store.GetRun(ctx, run.RunID); err == nil {
+		return ErrRunExists
+	} else if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	if len(run.IdempotencyKey) > maxIdempotencyKey {
+		return fmt.Errorf("%w: idempotency key must be at most %d bytes", ErrInvalidRun, maxIdempotencyKey)
+	}
+	if run.IdempotencyKey != "" {
+		// Checked again when the run is stored; this refuses most resends
+		// before the accepted event.
+		if existing, err := s.store.GetRunByIdempotencyKey(ctx, run.ChatID, run.IdempotencyKey); err == nil {
+			return &RunExistsError{RunID: existing.ID}
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	}
+	if err := s.CheckRunQuota(ctx, principal); err != nil {
+		return err
+	}
+	if err := s.checkChatSpend(ctx, run.ChatID); err != nil {
+		return err
+	}
+	ctx, release, err := s.TrackRun(ctx, run.RunID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var mu sync.Mutex
+	seq := 0
+	send := func(event RunEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		seq++
+		event.ID = fmt.Sprintf("%s:%d", run.RunID, seq)
+		event.Seq = seq
+		event.RunID = run.RunID
+		event.ChatID = run.ChatID
+		event.UserMessageID = run.UserMessageID
+		event.AssistantMessageID = run.AssistantMessageID
+		event.At = time.Now().UTC()
+		emit(event)
+	}
+	send(RunEvent{Type: RunEventAccepted})
+
+	// The outcome is saved even when the client goes away mid-run.
+	saveCtx := context.WithoutCancel(ctx)
+	if err := s.PersistRunStart(ctx, run, content); err != nil {
+		send(RunEvent{Type: RunEventCompleted, Status: "error", Error: err.Error()})
+		return err
+	}
+	send(RunEvent{Type: RunEventPersisted})
+
+	result, output, streamErr := s.streamRun(ctx, run, StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			send(RunEvent{Type: RunEventStreaming, Delta: delta})
+		},
+		OnQueued: func(position int) {
+			if position > 0 {
+				send(RunEvent{Type: RunEventQueued, Position: position})
+			}
+		},
+	})
+	status, errorText := s.runStatus(ctx, run.Model, streamErr, &result)
+	output, err = s.CompleteAssistant(saveCtx, run.AssistantMessageID, output, status)
+	if err != nil {
+		send(RunEvent{Type: RunEventCompleted, Status: "error", Error: err.Error()})
+		return err
+	}
+	// The reply is checked as saved, after output filters, so the parsed
+	// object never holds text the filters removed. A reply that does not
+	// match stays in the chat, but the run is an error.
+	var structured json.RawMessage
+	if run.OutputSchema != nil && status == "completed" {
+		if structured, err = ai.ParseStructuredOutput(run.OutputSchema, output); err != nil {
+			status = "error"
+			errorText = err.Error()
+		} else if err := s.store.SetMessageStructured(saveCtx, run.AssistantMessageID, string(structured), time.Now().UTC()); err != nil {
+			send(RunEvent{Type: RunEventCompleted, Status: "error", Error: err.Error()})
+			return err
+		}
+	}
+	if err := s.CompleteRun(saveCtx, run, status, result, errorText); err != nil {
+		send(RunEvent{Type: RunEventCompleted, Status: "error", Error: err.Error()})
+		return err
+	}
+	// The receipt lists sources too, so a failed read only drops them here.
+	sources, _ := s.MessageSources(saveCtx, run.AssistantMessageID)
+	send(RunEvent{Type: RunEventCompleted, Status: status, Content: output, Error: errorText, StopReason: result.StopReason, Sources: sources, Structured: structured})
+	return nil
+}
+
+// runStatus says how a stream that returned streamErr ended: the status
+// and error text its run is saved with. A provider failure also sets the
+// result's stop reason to its kind.
+func (s *Service) runStatus(ctx context.Context, model string, streamErr error, result *StreamResult) (string, string) {
+	status := "completed"
+	errorText := ""
+	if streamErr != nil {
+		switch {
+		case s.IsShutdown(ctx):
+			status = "interrupted"
+			errorText = shutdownRunError
+		case s.IsCancellation(streamErr, ctx):
+			status = "cancelled"
+		case s.IsTimeout(streamErr):
+			status = "timed_out"
+			errorText = streamErr.Error()
+		default:
+			status = "error"
+			result.StopReason, errorText = s.StreamFailure(model, streamErr)
+		}
+	}
+	if status == "error" && strings.TrimSpace(errorText) == "" {
+		errorText = fmt.Sprintf("Model %s failed without a provider error message.", model)
+	}
+	return status, errorText
+}
+
+// streamRun runs the model for a persisted run, saving partial output on
+// the configured DB flush interval and tool calls as they start and
+// finish, and returns the full output. callbacks observe the stream; the
+// tool updates they get carry the saved tool call's ID.
+func (s *Service) streamRun(ctx context.Context, run PendingRun, callbacks StreamCallbacks) (StreamResult, string, error) {
+	request, err := s.PrepareRun(ctx, run)
+	if err != nil {
+		return StreamResult{}, "", err
+	}
+	_, _, dbFlushInterval := s.FlushConfig()
+	var output, reasoning strings.Builder
+	lastDBFlush := time.Now().UTC()
+	toolCallRowByExternalID := map[string]string{}
+	flushDB := func() {
+		if time.Since(lastDBFlush) < dbFlushInterval {
+			return
+		}
+		lastDBFlush = time.Now().UTC()
+		_ = s.UpdateAssistantPartial(ctx, run.AssistantMessageID, output.String())
+		if reasoning.Len() > 0 {
+			_ = s.UpdateAssistantPartialReasoning(ctx, run.AssistantMessageID, reasoning.String())
+		}
+	}
+	result, err := s.Stream(ctx, run.Model, request.History, request.StreamOptions(run.RunID, run.RunTimeout), StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			output.WriteString(delta)
+			if callbacks.OnTextDelta != nil {
+				callbacks.OnTextDelta(delta)
+			}
+			flushDB()
+		},
+		OnThinkingDelta: func(delta string) {
+			reasoning.WriteString(delta)
+			if callbacks.OnThinkingDelta != nil {
+				callbacks.OnThinkingDelta(delta)
+			}
+			flushDB()
+		},
+		OnToolStart: func(update ToolCallUpdate) {
+			callID, callErr := s.UpsertToolStart(ctx, run.RunID, update)
+			if callErr == nil && update.ID != "" {
+				toolCallRowByExternalID[update.ID] = callID
+			}
+			if callbacks.OnToolStart != nil {
+				update.ID = callID
+				callbacks.OnToolStart(update)
+			}
+		},
+		OnToolResult: func(update ToolCallUpdate) {
+			callID := toolCallRowByExternalID[update.ID]
+			if callID == "" {
+				callID = uuid.NewString()
+			}
+			_ = s.CompleteTool(ctx, callID, update)
+			if callbacks.OnToolResult != nil {
+				update.ID = callID
+				callbacks.OnToolResult(update)
+			}
+		},
+		OnUsage:  callbacks.OnUsage,
+		OnQueued: callbacks.OnQueued,
+	})
+	if reasoning.Len() > 0 {
+		// Saved with the reply's final content.
+		_ = s.UpdateAssistantPartialReasoning(ctx, run.AssistantMessageID, reasoning.String())
+	}
+	return result, output.String(), err
+}
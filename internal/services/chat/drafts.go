@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+// maxDraftBytes bounds the composer text kept for a chat.
+const maxDraftBytes = 100 << 10
+
+// Draft returns the principal's unsent text in a chat, or "" when there is
+// none.
+func (s *Service) Draft(ctx context.Context, principal auth.Principal, chatID string) (string, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return "", err
+	}
+	content, err := s.store.GetDraft(ctx, chat.ID, principal.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", nil
+	}
+	return content, err
+}
+
+// SaveDraft keeps the principal's unsent text in a chat so it survives
+// switching chats and reloading. Whitespace-only text clears the draft.
+func (s *Service) SaveDraft(ctx context.Context, principal auth.Principal, chatID, content string) error {
+	if len(content) > maxDraftBytes {
+		return fmt.Errorf("draft is %d bytes; the limit is %d", len(content), maxDraftBytes)
+	}
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) == "" {
+		content = ""
+	}
+	return s.store.SaveDraft(ctx, chat.ID, principal.UserID, content, time.Now().UTC())
+}
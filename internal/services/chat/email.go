@@ -0,0 +1,177 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/mail"
+)
+
+const (
+	resultsEmailSetting = "results_email"
+	// unsubscribeKeySetting is the app setting holding the key that signs
+	// unsubscribe links, created when the first one is made.
+	unsubscribeKeySetting = "email_unsubscribe_key"
+	// maxEmailResultBytes bounds the run output quoted in a results email.
+	maxEmailResultBytes = 64 << 10
+)
+
+// ErrEmailDisabled is returned when asking for email while no SMTP server
+// is configured.
+var ErrEmailDisabled = errors.New("email is not configured on this server")
+
+// ErrInvalidUnsubscribe is returned for an unsubscribe link that was not
+// made by this server.
+var ErrInvalidUnsubscribe = errors.New("invalid unsubscribe link")
+
+// EmailEnabled reports whether the server can send email.
+func (s *Service) EmailEnabled() bool {
+	return s.mailer != nil
+}
+
+// SetResultsEmail stores where the principal's scheduled prompt results are
+// emailed. An empty address turns the emails off.
+func (s *Service) SetResultsEmail(ctx context.Context, principal auth.Principal, address string) (Preferences, error) {
+	address = strings.TrimSpace(address)
+	if address != "" {
+		if !s.EmailEnabled() {
+			return Preferences{}, ErrEmailDisabled
+		}
+		parsed, err := netmail.ParseAddress(address)
+		if err != nil {
+			return Preferences{}, fmt.Errorf("invalid email address %q", address)
+		}
+		address = parsed.Address
+	}
+	if err := s.store.SetUserSetting(ctx, principal.UserID, resultsEmailSetting, address, time.Now().UTC()); err != nil {
+		return Preferences{}, err
+	}
+	return s.Preferences(ctx, principal)
+}
+
+// Unsubscribe turns off results emails for the user an unsubscribe link
+// was made for. It needs no session, so the link works from any mail
+// client.
+func (s *Service) Unsubscribe(ctx context.Context, token string) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	userID, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil || len(userID) == 0 {
+		return ErrInvalidUnsubscribe
+	}
+	want, err := s.unsubscribeSignature(ctx, string(userID))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return ErrInvalidUnsubscribe
+	}
+	return s.store.SetUserSetting(ctx, string(userID), resultsEmailSetting, "", time.Now().UTC())
+}
+
+// unsubscribeToken signs userID into the token of their unsubscribe links.
+func (s *Service) unsubscribeToken(ctx context.Context, userID string) (string, error) {
+	signature, err := s.unsubscribeSignature(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." + signature, nil
+}
+
+func (s *Service) unsubscribeSignature(ctx context.Context, userID string) (string, error) {
+	s.unsubscribeKeyMu.Lock()
+	defer s.unsubscribeKeyMu.Unlock()
+	stored, err := s.store.GetSetting(ctx, unsubscribeKeySetting)
+	if errors.Is(err, db.ErrNotFound) {
+		fresh := make([]byte, 32)
+		if _, err := rand.Read(fresh); err != nil {
+			return "", fmt.Errorf("generate unsubscribe key: %w", err)
+		}
+		stored = hex.EncodeToString(fresh)
+		err = s.store.SetSetting(ctx, unsubscribeKeySetting, stored, time.Now().UTC())
+	}
+	if err != nil {
+		return "", err
+	}
+	key, err := hex.DecodeString(stored)
+	if err != nil {
+		return "", fmt.Errorf("decode unsubscribe key: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// emailPromptResult emails the outcome of a scheduled prompt run to its
+// owner when they asked for results by email. A failure is only logged:
+// the run itself already finished.
+func (s *Service) emailPromptResult(ctx context.Context, prompt ScheduledPrompt, chat Chat, userID, status, content, errorText string) {
+	if s.mailer == nil {
+		return
+	}
+	address, err := s.store.GetUserSetting(ctx, userID, resultsEmailSetting)
+	if err != nil || address == "" {
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			slog.Warn("results email not sent", "prompt_id", prompt.ID, "error", err)
+		}
+		return
+	}
+	// Without PublicURL there is nowhere to link to; the setting can still
+	// be turned off in the app.
+	publicURL := s.settings().PublicURL
+	unsubscribePage, unsubscribePost := "", ""
+	if publicURL != "" {
+		token, err := s.unsubscribeToken(ctx, userID)
+		if err != nil {
+			slog.Warn("results email not sent", "prompt_id", prompt.ID, "error", err)
+			return
+		}
+		unsubscribePage = publicURL + "/unsubscribe/" + token
+		unsubscribePost = publicURL + "/api/unsubscribe?token=" + token
+	}
+
+	subject := "Scheduled prompt: " + chat.Title
+	if status != "completed" {
+		subject = "Scheduled prompt " + strings.ReplaceAll(status, "_", " ") + ": " + chat.Title
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "Your scheduled prompt in %q ran (%s).\n\n", chat.Title, DescribePromptSchedule(prompt))
+	fmt.Fprintf(&body, "Prompt:\n%s\n\n", prompt.Prompt)
+	if content = strings.TrimSpace(content); content != "" {
+		fmt.Fprintf(&body, "Reply:\n%s\n\n", truncateText(content, maxEmailResultBytes))
+	}
+	if errorText != "" {
+		fmt.Fprintf(&body, "The run failed: %s\n\n", errorText)
+	}
+	if publicURL != "" {
+		fmt.Fprintf(&body, "Open the chat: %s/\n\n", publicURL)
+	}
+	body.WriteString("-- \nYou get this email because you asked for scheduled prompt results by email.\n")
+	if unsubscribePage != "" {
+		fmt.Fprintf(&body, "Unsubscribe: %s\n", unsubscribePage)
+	} else {
+		body.WriteString("Turn it off in the app's settings.\n")
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := s.mailer.Send(sendCtx, mail.Message{
+		To:             address,
+		Subject:        strings.Join(strings.Fields(subject), " "),
+		Body:           body.String(),
+		UnsubscribeURL: unsubscribePost,
+	}); err != nil {
+		slog.Warn("results email not sent", "prompt_id", prompt.ID, "error", err)
+	}
+}
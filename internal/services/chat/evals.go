@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+type GoldenExample = db.GoldenExample
+type EvalDataset = db.EvalDataset
+
+var (
+	// ErrNotGoldenCandidate is returned when marking anything but a
+	// completed assistant answer as golden.
+	ErrNotGoldenCandidate = errors.New("only completed assistant answers can be marked golden")
+	// ErrNoGoldenExamples is returned when exporting before any answer was
+	// marked golden.
+	ErrNoGoldenExamples = errors.New("no golden examples to export")
+)
+
+// DefaultEvalDataset is the dataset name exports use when none is given.
+const DefaultEvalDataset = "golden"
+
+var evalDatasetName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// EvalExample is one line of an exported dataset: the exact messages a run
+// sent and the answer curated as the expected output.
+type EvalExample struct {
+	ID       string      `json:"id"`
+	Input    []AIMessage `json:"input"`
+	Expected string      `json:"expected"`
+	Model    string      `json:"model"`
+	Note     string      `json:"note,omitempty"`
+	ChatID   string      `json:"chat_id"`
+	RunID    string      `json:"run_id"`
+	MarkedAt time.Time   `json:"marked_at"`
+}
+
+// MarkGolden curates a completed assistant answer as the expected output
+// for the prompt its run sent, taken from the run's request snapshot so
+// later edits to the chat do not change the example. Marking again
+// replaces the note.
+func (s *Service) MarkGolden(ctx context.Context, principal auth.Principal, messageID, note string) (GoldenExample, error) {
+	message, err := s.store.GetMessage(ctx, strings.TrimSpace(messageID))
+	if err != nil {
+		return GoldenExample{}, err
+	}
+	chat, err := s.authorizeChat(ctx, principal, message.ChatID)
+	if err != nil {
+		return GoldenExample{}, err
+	}
+	if message.Role != "assistant" || message.Status != "completed" {
+		return GoldenExample{}, ErrNotGoldenCandidate
+	}
+	run, err := s.store.GetRunByAssistantMessage(ctx, message.ID)
+	if err != nil {
+		return GoldenExample{}, err
+	}
+	request, err := s.RunSnapshot(ctx, run)
+	if err != nil {
+		return GoldenExample{}, err
+	}
+	prompt, err := json.Marshal(request.History)
+	if err != nil {
+		return GoldenExample{}, fmt.Errorf("encode golden prompt: %w", err)
+	}
+	example := GoldenExample{
+		ID:         uuid.NewString(),
+		MessageID:  message.ID,
+		ChatID:     chat.ID,
+		RunID:      run.ID,
+		OwnerID:    chat.OwnerID.String,
+		Model:      run.Model,
+		PromptJSON: string(prompt),
+		Answer:     message.Content,
+		Note:       strings.TrimSpace(note),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.store.SaveGoldenExample(ctx, example); err != nil {
+		return GoldenExample{}, err
+	}
+	return s.store.GetGoldenExample(ctx, message.ID)
+}
+
+// UnmarkGolden removes an answer from the golden set. Datasets already
+// exported keep it.
+func (s *Service) UnmarkGolden(ctx context.Context, principal auth.Principal, messageID string) error {
+	example, err := s.store.GetGoldenExample(ctx, strings.TrimSpace(messageID))
+	if err != nil {
+		return err
+	}
+	if _, err := s.authorizeChat(ctx, principal, example.ChatID); err != nil {
+		return err
+	}
+	return s.store.DeleteGoldenExample(ctx, example.MessageID)
+}
+
+// GoldenMessageIDs returns the chat's golden messages as a set.
+func (s *Service) GoldenMessageIDs(ctx context.Context, chatID string) (map[string]bool, error) {
+	if chatID == "" {
+		return map[string]bool{}, nil
+	}
+	ids, err := s.store.GoldenMessageIDs(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	golden := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		golden[id] = true
+	}
+	return golden, nil
+}
+
+// GoldenExamples returns the principal's golden examples, oldest first.
+func (s *Service) GoldenExamples(ctx context.Context, principal auth.Principal) ([]GoldenExample, error) {
+	return s.store.ListGoldenExamples(ctx, ownerOf(principal))
+}
+
+// ExportEvalDataset freezes the principal's current golden examples as the
+// next version of dataset name and returns it. Versions never change once
+// exported, so an evaluation can cite the exact data it ran on.
+func (s *Service) ExportEvalDataset(ctx context.Context, principal auth.Principal, name string) (EvalDataset, error) {
+	name, err := normalizeEvalDatasetName(name)
+	if err != nil {
+		return EvalDataset{}, err
+	}
+	owner := ownerOf(principal)
+	examples, err := s.store.ListGoldenExamples(ctx, owner)
+	if err != nil {
+		return EvalDataset{}, err
+	}
+	if len(examples) == 0 {
+		return EvalDataset{}, ErrNoGoldenExamples
+	}
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	for _, example := range examples {
+		var input []AIMessage
+		if err := json.Unmarshal([]byte(example.PromptJSON), &input); err != nil {
+			return EvalDataset{}, fmt.Errorf("decode golden prompt %s: %w", example.ID, err)
+		}
+		if err := encoder.Encode(EvalExample{
+			ID:       example.ID,
+			Input:    input,
+			Expected: example.Answer,
+			Model:    example.Model,
+			Note:     example.Note,
+			ChatID:   example.ChatID,
+			RunID:    example.RunID,
+			MarkedAt: example.CreatedAt,
+		}); err != nil {
+			return EvalDataset{}, fmt.Errorf("encode eval example %s: %w", example.ID, err)
+		}
+	}
+	digest := sha256.Sum256(content.Bytes())
+	return s.store.InsertEvalDataset(ctx, EvalDataset{
+		ID:           uuid.NewString(),
+		OwnerID:      owner,
+		Name:         name,
+		ExampleCount: len(examples),
+		SHA256:       hex.EncodeToString(digest[:]),
+		Content:      content.String(),
+		CreatedAt:    time.Now().UTC(),
+	})
+}
+
+// EvalDatasets lists the principal's exported dataset versions, newest
+// first.
+func (s *Service) EvalDatasets(ctx context.Context, principal auth.Principal) ([]EvalDataset, error) {
+	return s.store.ListEvalDatasets(ctx, ownerOf(principal))
+}
+
+// EvalDataset returns one exported version of the principal's dataset, or
+// the latest when version is 0.
+func (s *Service) EvalDataset(ctx context.Context, principal auth.Principal, name string, version int) (EvalDataset, error) {
+	name, err := normalizeEvalDatasetName(name)
+	if err != nil {
+		return EvalDataset{}, err
+	}
+	return s.store.GetEvalDataset(ctx, ownerOf(principal), name, version)
+}
+
+// EvalExamples decodes a dataset's JSON Lines content.
+func EvalExamples(dataset EvalDataset) ([]EvalExample, error) {
+	decoder := json.NewDecoder(strings.NewReader(dataset.Content))
+	var examples []EvalExample
+	for decoder.More() {
+		var example EvalExample
+		if err := decoder.Decode(&example); err != nil {
+			return nil, fmt.Errorf("decode eval dataset %s v%d: %w", dataset.Name, dataset.Version, err)
+		}
+		examples = append(examples, example)
+	}
+	return examples, nil
+}
+
+func normalizeEvalDatasetName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return DefaultEvalDataset, nil
+	}
+	if !evalDatasetName.MatchString(name) {
+		return "", fmt.Errorf("invalid dataset name %q: use up to 64 lowercase letters, digits, '.', '_' or '-'", name)
+	}
+	return name, nil
+}
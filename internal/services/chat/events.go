@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/events"
+)
+
+const (
+	// eventHistory is how many recent events a reconnecting subscriber can
+	// resume from.
+	eventHistory = 1000
+	// budgetWarningRatio is the share of a chat's spend ceiling that raises
+	// a budget warning.
+	budgetWarningRatio = 0.8
+)
+
+// Events returns the service's event log.
+func (s *Service) Events() *events.Bus {
+	return s.events
+}
+
+// publishChatCreated logs a new chat and records it for analytics.
+func (s *Service) publishChatCreated(ctx context.Context, chat Chat) {
+	data := map[string]any{"title": chat.Title, "model": chat.Model}
+	if chat.OwnerID.Valid {
+		data["owner_id"] = chat.OwnerID.String
+	}
+	s.events.Publish(events.Event{Type: events.ChatCreated, ChatID: chat.ID, Data: data})
+	s.analytics.Record(ctx, analytics.ChatCreated, chat.OwnerID.String, map[string]any{"model": chat.Model})
+}
+
+// publishRunFinished logs a run's outcome and records it for analytics.
+func (s *Service) publishRunFinished(ctx context.Context, run PendingRun, status string, result StreamResult, errText string, cost sql.NullFloat64) {
+	data := map[string]any{
+		"model":       run.Model,
+		"status":      status,
+		"stop_reason": result.StopReason,
+		"tool_calls":  result.ToolCallCount,
+		"turns":       result.TurnCount,
+	}
+	if errText != "" {
+		data["error"] = errText
+	}
+	if cost.Valid {
+		data["cost_usd"] = cost.Float64
+	}
+	s.events.Publish(events.Event{Type: events.RunFinished, ChatID: run.ChatID, RunID: run.RunID, Data: data})
+	s.recordForChat(ctx, run.ChatID, analytics.RunCompleted, map[string]any{
+		"model":      run.Model,
+		"status":     status,
+		"turns":      result.TurnCount,
+		"tool_calls": result.ToolCallCount,
+	})
+}
+
+func (s *Service) publishToolExecuted(callID, name, status string) {
+	s.events.Publish(events.Event{Type: events.ToolExecuted, Data: map[string]any{"call_id": callID, "tool": name, "status": status}})
+}
+
+// warnOnBudget publishes a budget warning when a run's cost takes its chat
+// past budgetWarningRatio of its ceiling or past the ceiling itself.
+func (s *Service) warnOnBudget(ctx context.Context, run PendingRun, cost sql.NullFloat64) {
+	if !cost.Valid || cost.Float64 <= 0 {
+		return
+	}
+	spend, err := s.ChatSpend(ctx, run.ChatID)
+	if err != nil || !spend.Limited() {
+		return
+	}
+	before := spend.SpentUSD - cost.Float64
+	warnAt := spend.CeilingUSD * budgetWarningRatio
+	crossed := (before < warnAt && spend.SpentUSD >= warnAt) || (before < spend.CeilingUSD && spend.Exceeded)
+	if !crossed {
+		return
+	}
+	s.events.Publish(events.Event{Type: events.BudgetWarning, ChatID: run.ChatID, RunID: run.RunID, Data: map[string]any{
+		"spent_usd":   spend.SpentUSD,
+		"ceiling_usd": spend.CeilingUSD,
+		"exceeded":    spend.Exceeded,
+	}})
+}
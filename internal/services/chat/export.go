@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+// maxExportMessages bounds the messages one chat export reads.
+const maxExportMessages = 10000
+
+// ChatExport is a chat and its messages as the owner downloads them.
+// Sensitive and hidden messages are left out.
+type ChatExport struct {
+	ID        string            `json:"id"`
+	Title     string            `json:"title"`
+	Model     string            `json:"model"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Messages  []ExportedMessage `json:"messages"`
+}
+
+// ExportedMessage is one message of a ChatExport.
+type ExportedMessage struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportChat returns the principal's chat with its shareable messages in
+// conversation order.
+func (s *Service) ExportChat(ctx context.Context, principal auth.Principal, chatID string) (ChatExport, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return ChatExport{}, err
+	}
+	rows, err := s.ListShareableMessages(ctx, chat.ID, maxExportMessages)
+	if err != nil {
+		return ChatExport{}, err
+	}
+	export := ChatExport{
+		ID:        chat.ID,
+		Title:     chat.Title,
+		Model:     chat.Model,
+		CreatedAt: chat.CreatedAt,
+		UpdatedAt: chat.UpdatedAt,
+		Messages:  make([]ExportedMessage, 0, len(rows)),
+	}
+	for _, row := range rows {
+		export.Messages = append(export.Messages, ExportedMessage{
+			ID:        row.ID,
+			Role:      row.Role,
+			Content:   row.Content,
+			Status:    row.Status,
+			CreatedAt: row.CreatedAt,
+		})
+	}
+	return export, nil
+}
+
+// Markdown renders the export as a Markdown document, a heading per
+// message.
+func (e ChatExport) Markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n\n", e.Title)
+	fmt.Fprintf(&out, "_Model %s, exported from chat %s._\n", e.Model, e.ID)
+	for _, message := range e.Messages {
+		role := message.Role
+		switch role {
+		case "user":
+			role = "User"
+		case "assistant":
+			role = "Assistant"
+		}
+		fmt.Fprintf(&out, "\n## %s, %s\n\n", role, message.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"))
+		content := strings.TrimSpace(message.Content)
+		if content == "" {
+			content = "_(no content)_"
+		}
+		out.WriteString(content + "\n")
+		if message.Status != "" && message.Status != "complete" && message.Status != "completed" {
+			fmt.Fprintf(&out, "\n_Status: %s._\n", strings.ReplaceAll(message.Status, "_", " "))
+		}
+	}
+	return out.String()
+}
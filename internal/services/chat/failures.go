@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/ai"
+)
+
+// StreamFailure describes a stream that failed at the provider. kind is
+// the ai.ErrorKind of err, saved as the run's stop reason, or "" when the
+// failure is not one the runner tells apart; message says what to do about
+// it, followed by the provider's error.
+func (s *Service) StreamFailure(model string, err error) (kind, message string) {
+	kind = ai.ErrorKind(err)
+	detail := strings.TrimSpace(err.Error())
+	var hint string
+	switch kind {
+	case ai.ErrorKindRateLimited:
+		wait := "a minute"
+		if retryAfter := ai.RetryAfter(err); retryAfter > 0 {
+			wait = retryAfter.Round(time.Second).String()
+		}
+		hint = fmt.Sprintf("The provider is rate limiting requests to %s. Retry in %s, or pick another model.", model, wait)
+	case ai.ErrorKindAuth:
+		hint = fmt.Sprintf("The provider rejected the API key for %s. Check your key in settings", model)
+		if env := ai.ProviderKeyEnv(model); env != "" {
+			hint += ", or ask an administrator to check " + env
+		}
+		hint += "."
+	case ai.ErrorKindOverloaded:
+		hint = fmt.Sprintf("The provider of %s is overloaded or unavailable. Retry shortly, or pick another model.", model)
+	case ai.ErrorKindContextTooLong:
+		hint = fmt.Sprintf("This conversation is too long for %s. Start a new chat, or pick a model with a larger context window.", model)
+	case ai.ErrorKindContentFiltered:
+		hint = fmt.Sprintf("The provider's content filter blocked this request to %s. Rephrase the message and send it again.", model)
+	default:
+		return "", detail
+	}
+	return kind, hint + "\n\n" + detail
+}
+
+// RetryableStopReason reports whether a run that failed with stopReason
+// may succeed if it is retried unchanged, as after a rate limit.
+func RetryableStopReason(stopReason string) bool {
+	return ai.RetryableKind(stopReason)
+}
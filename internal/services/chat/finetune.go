@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"rhone_chat/internal/pii"
+)
+
+// ErrInvalidExport is returned for fine-tune export requests that select
+// nothing or name unknown options.
+var ErrInvalidExport = errors.New("invalid export request")
+
+// maxFineTuneMessages bounds the messages read from each exported chat.
+const maxFineTuneMessages = 2000
+
+// FineTuneOptions selects what FineTuneExamples exports.
+type FineTuneOptions struct {
+	// ChatIDs exports each of these chats as one example.
+	ChatIDs []string
+	// Golden exports every answer marked golden, the positively rated
+	// exchanges, as one example each with the prompt its run sent.
+	Golden bool
+	// Scrub names the pii kinds masked in every message, or "all".
+	Scrub []string
+}
+
+// FineTuneExample is one line of an OpenAI-style chat fine-tuning file.
+type FineTuneExample struct {
+	Messages []FineTuneMessage `json:"messages"`
+}
+
+// FineTuneMessage is a message of a fine-tuning example. Images are not
+// carried over.
+type FineTuneMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// FineTuneExamples builds fine-tuning examples from chats and golden
+// answers across all users. Callers check that the principal is an
+// administrator. Chat examples start with the current system prompt and
+// keep only completed answers; sensitive and hidden messages, the second
+// model's side of comparisons and a trailing unanswered prompt are left
+// out, and chats with no answer left are skipped.
+func (s *Service) FineTuneExamples(ctx context.Context, opts FineTuneOptions) ([]FineTuneExample, error) {
+	if len(opts.ChatIDs) == 0 && !opts.Golden {
+		return nil, fmt.Errorf("%w: select chats or golden examples", ErrInvalidExport)
+	}
+	scrubber, err := pii.New(opts.Scrub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+
+	examples := []FineTuneExample{}
+	for _, chatID := range opts.ChatIDs {
+		example, err := s.chatFineTuneExample(ctx, strings.TrimSpace(chatID), scrubber)
+		if err != nil {
+			return nil, err
+		}
+		if len(example.Messages) > 0 {
+			examples = append(examples, example)
+		}
+	}
+	if opts.Golden {
+		golden, err := s.store.ListAllGoldenExamples(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range golden {
+			var prompt []AIMessage
+			if err := json.Unmarshal([]byte(row.PromptJSON), &prompt); err != nil {
+				return nil, fmt.Errorf("decode golden prompt %s: %w", row.ID, err)
+			}
+			example := FineTuneExample{Messages: make([]FineTuneMessage, 0, len(prompt)+1)}
+			for _, message := range prompt {
+				example.Messages = append(example.Messages, FineTuneMessage{Role: message.Role, Content: scrubber.Scrub(message.Content)})
+			}
+			example.Messages = append(example.Messages, FineTuneMessage{Role: "assistant", Content: scrubber.Scrub(row.Answer)})
+			examples = append(examples, example)
+		}
+	}
+	return examples, nil
+}
+
+func (s *Service) chatFineTuneExample(ctx context.Context, chatID string, scrubber pii.Scrubber) (FineTuneExample, error) {
+	chat, err := s.store.GetChat(ctx, chatID)
+	if err != nil {
+		return FineTuneExample{}, err
+	}
+	rows, err := s.ListShareableMessages(ctx, chat.ID, maxFineTuneMessages)
+	if err != nil {
+		return FineTuneExample{}, err
+	}
+	alternates, err := s.comparisonAlternates(ctx, chat.ID)
+	if err != nil {
+		return FineTuneExample{}, err
+	}
+	messages := []FineTuneMessage{{Role: "system", Content: scrubber.Scrub(s.systemPrompt(""))}}
+	answered := 1
+	for _, row := range rows {
+		switch {
+		case row.Role == "user" && strings.TrimSpace(row.Content) != "":
+		case row.Role == "assistant" && row.Status == "completed" && !alternates[row.ID]:
+		default:
+			continue
+		}
+		messages = append(messages, FineTuneMessage{Role: row.Role, Content: scrubber.Scrub(row.Content)})
+		if row.Role == "assistant" {
+			answered = len(messages)
+		}
+	}
+	if answered == 1 {
+		return FineTuneExample{}, nil
+	}
+	return FineTuneExample{Messages: messages[:answered]}, nil
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// flushLatencyWeight is the weight of each new latency sample in the moving
+// average the pacer adapts to.
+const flushLatencyWeight = 0.25
+
+// FlushPacer decides when a streaming run pushes buffered output to the UI.
+// It starts at the configured UIFlushInterval and UIFlushBytes and backs off
+// towards UIFlushMaxInterval and UIFlushMaxBytes when patches take long to
+// reach the session, so slow clients get fewer, larger patches instead of a
+// growing queue. While a patch is still in flight it holds further output
+// back unless the buffer reaches the maximum size. It is safe for
+// concurrent use.
+type FlushPacer struct {
+	mu          sync.Mutex
+	minInterval time.Duration
+	maxInterval time.Duration
+	minBytes    int
+	maxBytes    int
+	latency     time.Duration
+	inFlight    int
+	interval    time.Duration
+	bytes       int
+}
+
+// NewFlushPacer returns a pacer using the configured UI flush bounds.
+func (s *Service) NewFlushPacer() *FlushPacer {
+	cfg := s.settings()
+	return newFlushPacer(cfg.UIFlushInterval, cfg.UIFlushMaxInterval, cfg.UIFlushBytes, cfg.UIFlushMaxBytes)
+}
+
+func newFlushPacer(minInterval, maxInterval time.Duration, minBytes, maxBytes int) *FlushPacer {
+	maxInterval = max(maxInterval, minInterval)
+	maxBytes = max(maxBytes, minBytes)
+	return &FlushPacer{
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		minBytes:    minBytes,
+		maxBytes:    maxBytes,
+		interval:    minInterval,
+		bytes:       minBytes,
+	}
+}
+
+// Due reports whether pending buffered bytes, last flushed sinceLast ago,
+// should be flushed now.
+func (p *FlushPacer) Due(pending int, sinceLast time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pending == 0 {
+		return false
+	}
+	if p.inFlight > 0 && pending < p.maxBytes {
+		return false
+	}
+	return pending >= p.bytes || sinceLast >= p.interval
+}
+
+// Sent records that a patch was handed to the session and returns the
+// function to call once the session applies it.
+func (p *FlushPacer) Sent() func() {
+	sentAt := time.Now()
+	p.mu.Lock()
+	p.inFlight++
+	p.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.observe(time.Since(sentAt))
+		})
+	}
+}
+
+// Limits returns the current flush interval and byte threshold.
+func (p *FlushPacer) Limits() (time.Duration, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval, p.bytes
+}
+
+func (p *FlushPacer) observe(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight = max(p.inFlight-1, 0)
+	if p.latency == 0 {
+		p.latency = latency
+	} else {
+		p.latency = time.Duration(flushLatencyWeight*float64(latency) + (1-flushLatencyWeight)*float64(p.latency))
+	}
+	// Spend at most about half of each interval waiting on the session, and
+	// grow the byte threshold in step so fast generations do not flush on
+	// size alone.
+	p.interval = min(max(2*p.latency, p.minInterval), p.maxInterval)
+	p.bytes = p.minBytes
+	if p.minInterval > 0 {
+		p.bytes = min(int(int64(p.minBytes)*int64(p.interval)/int64(p.minInterval)), p.maxBytes)
+	}
+}
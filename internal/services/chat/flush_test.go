@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushPacerBacksOffForSlowSessions(t *testing.T) {
+	pacer := newFlushPacer(30*time.Millisecond, 300*time.Millisecond, 256, 4096)
+	if !pacer.Due(256, 0) || !pacer.Due(1, 30*time.Millisecond) || pacer.Due(100, 10*time.Millisecond) || pacer.Due(0, time.Second) {
+		t.Fatalf("Due() does not follow the configured thresholds")
+	}
+
+	// While a patch is in flight, output is held back until the buffer hits
+	// the maximum size.
+	applied := pacer.Sent()
+	if pacer.Due(1000, time.Second) {
+		t.Fatalf("Due() = true while a patch is in flight")
+	}
+	if !pacer.Due(4096, 0) {
+		t.Fatalf("Due(max bytes) = false while a patch is in flight, want a forced flush")
+	}
+	time.Sleep(60 * time.Millisecond)
+	applied()
+	applied() // repeated calls count once
+
+	interval, bytes := pacer.Limits()
+	if interval < 120*time.Millisecond || interval > 300*time.Millisecond || bytes <= 256 || bytes > 4096 {
+		t.Fatalf("Limits() = %s, %d; want a longer interval and larger patches", interval, bytes)
+	}
+	if pacer.Due(300, 40*time.Millisecond) {
+		t.Fatalf("Due() = true below the backed-off thresholds")
+	}
+
+	// Fast round trips bring the pacer back towards the configured minimum.
+	for range 20 {
+		pacer.observe(time.Millisecond)
+	}
+	interval, bytes = pacer.Limits()
+	if interval != 30*time.Millisecond || bytes != 256 {
+		t.Fatalf("Limits() after fast patches = %s, %d; want 30ms, 256", interval, bytes)
+	}
+}
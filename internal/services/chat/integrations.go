@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+// IntegrationChat returns the chat holding a conversation in an outside
+// service, such as a Discord channel, creating it for principal with title
+// on first use. A chat deleted in the app is replaced by a new one the next
+// time the conversation continues.
+func (s *Service) IntegrationChat(ctx context.Context, principal auth.Principal, integration, externalID, title string) (Chat, error) {
+	link, err := s.store.GetIntegrationChat(ctx, integration, externalID)
+	if err == nil {
+		return s.authorizeChat(ctx, principal, link.ChatID)
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return Chat{}, err
+	}
+	return s.NewIntegrationChat(ctx, principal, integration, externalID, title)
+}
+
+// NewIntegrationChat starts a new chat for a conversation in an outside
+// service, leaving the one it had, if any, in the app's chat list.
+func (s *Service) NewIntegrationChat(ctx context.Context, principal auth.Principal, integration, externalID, title string) (Chat, error) {
+	title = truncateText(strings.TrimSpace(title), 200)
+	if title == "" {
+		title = "New chat"
+	}
+	now := time.Now().UTC()
+	owner := ownerOf(principal)
+	chat := Chat{
+		ID:        uuid.NewString(),
+		Title:     title,
+		Model:     s.DefaultModel(),
+		OwnerID:   sql.NullString{String: owner, Valid: owner != ""},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.store.CreateIntegrationChat(ctx, chat, db.IntegrationChat{
+		Integration: integration,
+		ExternalID:  externalID,
+		CreatedAt:   now,
+	}); err != nil {
+		return Chat{}, err
+	}
+	s.publishChatCreated(ctx, chat)
+	return chat, nil
+}
@@ -0,0 +1,39 @@
+package chat
+
+import (
+	"strings"
+
+	"rhone_chat/internal/i18n"
+)
+
+// ResolveLocale picks the configured system prompt locale for an
+// Accept-Language header. Only the most preferred language counts, tried
+// exactly and then by its base language so "pt-BR" falls back to "pt": a
+// user who prefers a language without its own prompt gets the default one
+// rather than a prompt in their second choice. It returns "" when no
+// locale-specific prompt applies.
+func (s *Service) ResolveLocale(acceptLanguage string) string {
+	prompts := s.settings().SystemPrompts
+	tags := i18n.ParseAcceptLanguage(acceptLanguage)
+	if len(prompts) == 0 || len(tags) == 0 {
+		return ""
+	}
+	if _, ok := prompts[tags[0]]; ok {
+		return tags[0]
+	}
+	if base, _, found := strings.Cut(tags[0], "-"); found {
+		if _, ok := prompts[base]; ok {
+			return base
+		}
+	}
+	return ""
+}
+
+// systemPrompt returns the prompt for locale, or the default prompt.
+func (s *Service) systemPrompt(locale string) string {
+	cfg := s.settings()
+	if prompt, ok := cfg.SystemPrompts[strings.ToLower(locale)]; ok {
+		return prompt
+	}
+	return cfg.SystemPrompt
+}
@@ -0,0 +1,40 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+// RawMessage is a message's content as stored, before any markdown
+// rendering, for copying to the clipboard.
+type RawMessage struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RawMessage returns a message from a chat the principal may use. A message
+// that is still streaming holds what has been flushed so far.
+func (s *Service) RawMessage(ctx context.Context, principal auth.Principal, messageID string) (RawMessage, error) {
+	message, err := s.store.GetMessage(ctx, strings.TrimSpace(messageID))
+	if err != nil {
+		return RawMessage{}, err
+	}
+	if _, err := s.authorizeChat(ctx, principal, message.ChatID); err != nil {
+		return RawMessage{}, err
+	}
+	return RawMessage{
+		ID:        message.ID,
+		ChatID:    message.ChatID,
+		Role:      message.Role,
+		Status:    message.Status,
+		Content:   message.Content,
+		UpdatedAt: message.UpdatedAt.UTC(),
+	}, nil
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/moderation"
+)
+
+// ErrContentBlocked is returned when moderation refuses a user message.
+var ErrContentBlocked = errors.New("message blocked by moderation")
+
+// ModerationEnabled reports whether user messages are screened before a
+// run starts.
+func (s *Service) ModerationEnabled() bool {
+	cfg := s.settings()
+	return cfg.moderation.Enabled() || cfg.ModerationModel != ""
+}
+
+// moderate screens the user message a run is about to save. It returns
+// the zero Decision when moderation is off or the run reuses a message
+// that was screened when it was first sent. A blocked message is recorded
+// in the audit log against its chat, since no run is saved for it.
+func (s *Service) moderate(ctx context.Context, run PendingRun, content string) (moderation.Decision, error) {
+	if run.ReuseUserMessage {
+		return moderation.Decision{}, nil
+	}
+	cfg := s.settings()
+	var chain moderation.Chain
+	if cfg.moderation.Enabled() {
+		chain = append(chain, cfg.moderation)
+	}
+	if cfg.ModerationModel != "" && s.runner != nil {
+		chain = append(chain, moderation.NewModel(s.completer(cfg.ModerationModel)))
+	}
+	if len(chain) == 0 {
+		return moderation.Decision{}, nil
+	}
+	decision, err := chain.Check(ctx, content)
+	if err != nil {
+		if !cfg.ModerationFailClosed {
+			slog.Warn("moderation check failed; letting the message through", "chat_id", run.ChatID, "run_id", run.RunID, "error", err)
+			if decision.Source == "" {
+				decision.Source = "unavailable"
+			}
+			return decision, nil
+		}
+		decision = moderation.Decision{Action: moderation.Block, Source: "unavailable"}
+		slog.Warn("moderation check failed; refusing the message", "chat_id", run.ChatID, "run_id", run.RunID, "error", err)
+	}
+	if decision.Action != moderation.Block {
+		return decision, nil
+	}
+	if err := s.auditBlockedMessage(ctx, run, decision); err != nil {
+		return moderation.Decision{}, err
+	}
+	if decision.Source == "unavailable" {
+		return moderation.Decision{}, fmt.Errorf("%w: it could not be checked, try again later", ErrContentBlocked)
+	}
+	if len(decision.Categories) > 0 {
+		return moderation.Decision{}, fmt.Errorf("%w: %s", ErrContentBlocked, strings.Join(decision.Categories, ", "))
+	}
+	return moderation.Decision{}, ErrContentBlocked
+}
+
+// completer asks model for a one-off reply without tools, for the
+// moderation and redaction checks.
+func (s *Service) completer(model string) func(ctx context.Context, system, text string) (string, error) {
+	return func(ctx context.Context, system, text string) (string, error) {
+		var reply strings.Builder
+		_, err := s.runner.Stream(ctx, model, []AIMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: text},
+		}, StreamOptions{DisableTools: true}, StreamCallbacks{
+			OnTextDelta: func(delta string) {
+				reply.WriteString(delta)
+			},
+		})
+		return reply.String(), err
+	}
+}
+
+func (s *Service) auditBlockedMessage(ctx context.Context, run PendingRun, decision moderation.Decision) error {
+	chat, err := s.store.GetChat(ctx, run.ChatID)
+	if err != nil {
+		return err
+	}
+	detail, _ := json.Marshal(map[string]any{
+		"run_id":     run.RunID,
+		"model":      run.Model,
+		"moderation": decision,
+	})
+	return s.store.InsertAuditEntry(ctx, db.AuditEntry{
+		ID:         uuid.NewString(),
+		ActorID:    chat.OwnerID.String,
+		Action:     "message.blocked",
+		TargetType: "chat",
+		TargetID:   chat.ID,
+		DetailJSON: string(detail),
+		CreatedAt:  time.Now().UTC(),
+	})
+}
+
+// moderationJSON encodes a decision for the runs table; unscreened
+// messages store nothing.
+func moderationJSON(decision moderation.Decision) string {
+	if decision.Action == "" {
+		return ""
+	}
+	encoded, err := json.Marshal(decision)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
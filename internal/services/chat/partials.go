@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+// partialWriter buffers the output of streaming replies and writes it
+// behind, every buffered message in one transaction per DBFlushInterval, so
+// concurrent runs share one write instead of queueing for the single
+// connection in turn. Only the latest output of each message is kept. Its
+// flusher runs only while something is buffered.
+type partialWriter struct {
+	mu       sync.Mutex
+	pending  map[string]db.PartialMessage
+	flushing bool
+}
+
+// UpdateAssistantPartial buffers the content a streaming reply has so far.
+// It reaches the database on the next flush, or with CompleteAssistant.
+func (s *Service) UpdateAssistantPartial(_ context.Context, assistantMessageID, content string) error {
+	s.bufferPartial(assistantMessageID, func(partial *db.PartialMessage) {
+		partial.Content = sql.NullString{String: content, Valid: true}
+	})
+	return nil
+}
+
+// UpdateAssistantPartialReasoning buffers the reasoning a streaming reply
+// has so far, like UpdateAssistantPartial.
+func (s *Service) UpdateAssistantPartialReasoning(_ context.Context, assistantMessageID, reasoning string) error {
+	s.bufferPartial(assistantMessageID, func(partial *db.PartialMessage) {
+		partial.Reasoning = sql.NullString{String: reasoning, Valid: true}
+	})
+	return nil
+}
+
+func (s *Service) bufferPartial(messageID string, update func(*db.PartialMessage)) {
+	s.partials.mu.Lock()
+	defer s.partials.mu.Unlock()
+	if s.partials.pending == nil {
+		s.partials.pending = map[string]db.PartialMessage{}
+	}
+	partial := s.partials.pending[messageID]
+	partial.ID = messageID
+	update(&partial)
+	s.partials.pending[messageID] = partial
+	if !s.partials.flushing {
+		s.partials.flushing = true
+		go s.writePartialsBehind()
+	}
+}
+
+// takePartial removes and returns what is buffered for messageID.
+func (s *Service) takePartial(messageID string) (db.PartialMessage, bool) {
+	s.partials.mu.Lock()
+	defer s.partials.mu.Unlock()
+	partial, ok := s.partials.pending[messageID]
+	delete(s.partials.pending, messageID)
+	return partial, ok
+}
+
+// writePartialsBehind flushes the buffer every DBFlushInterval until it is
+// empty.
+func (s *Service) writePartialsBehind() {
+	for {
+		time.Sleep(s.settings().DBFlushInterval)
+		if err := s.FlushPartials(context.Background()); err != nil {
+			slog.Warn("partial replies not saved", "error", err)
+		}
+		s.partials.mu.Lock()
+		if len(s.partials.pending) == 0 {
+			s.partials.flushing = false
+			s.partials.mu.Unlock()
+			return
+		}
+		s.partials.mu.Unlock()
+	}
+}
+
+// FlushPartials writes the buffered output of streaming replies now. What
+// fails to save stays buffered for the next flush unless newer output
+// replaced it.
+func (s *Service) FlushPartials(ctx context.Context) error {
+	s.partials.mu.Lock()
+	batch := s.partials.pending
+	s.partials.pending = map[string]db.PartialMessage{}
+	s.partials.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	partials := make([]db.PartialMessage, 0, len(batch))
+	for _, partial := range batch {
+		partials = append(partials, partial)
+	}
+	err := s.store.SavePartialMessages(ctx, partials, time.Now().UTC())
+	if err != nil {
+		s.partials.mu.Lock()
+		for id, partial := range batch {
+			if _, ok := s.partials.pending[id]; !ok {
+				s.partials.pending[id] = partial
+			}
+		}
+		s.partials.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone names resolve on hosts without a zoneinfo database
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/i18n"
+)
+
+const (
+	timezoneSetting = "timezone"
+	themeSetting    = "theme"
+	languageSetting = "language"
+)
+
+// Preferences are a user's display and email settings. Anonymous users
+// share one set.
+type Preferences struct {
+	// Timezone is an IANA name such as "Europe/Paris", or "" to use the
+	// browser's timezone.
+	Timezone string `json:"timezone"`
+	// Theme names one of the configured UI themes, or "" for the default.
+	Theme string `json:"theme"`
+	// Language is a bundled UI language tag such as "fr", or "" to follow
+	// the browser's Accept-Language header.
+	Language string `json:"language"`
+	// ResultsEmail is where scheduled prompt results are emailed, or "" for
+	// no email.
+	ResultsEmail string `json:"results_email"`
+}
+
+// Location returns the preferred timezone, or UTC when none is set.
+func (p Preferences) Location() *time.Location {
+	if p.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// Localizer returns the UI strings in the preferred language, or in
+// fallback, the language negotiated from the browser, when none is set.
+func (p Preferences) Localizer(fallback string) i18n.Localizer {
+	if p.Language != "" {
+		return i18n.For(p.Language)
+	}
+	return i18n.For(fallback)
+}
+
+// Preferences returns the principal's stored preferences.
+func (s *Service) Preferences(ctx context.Context, principal auth.Principal) (Preferences, error) {
+	var prefs Preferences
+	for key, value := range map[string]*string{
+		timezoneSetting:     &prefs.Timezone,
+		themeSetting:        &prefs.Theme,
+		languageSetting:     &prefs.Language,
+		resultsEmailSetting: &prefs.ResultsEmail,
+	} {
+		stored, err := s.store.GetUserSetting(ctx, principal.UserID, key)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return Preferences{}, err
+		}
+		*value = stored
+	}
+	return prefs, nil
+}
+
+// SetTimezone stores the principal's timezone. An empty name goes back to
+// the browser's timezone.
+func (s *Service) SetTimezone(ctx context.Context, principal auth.Principal, name string) (Preferences, error) {
+	name = strings.TrimSpace(name)
+	if name != "" {
+		if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+			return Preferences{}, fmt.Errorf("unknown timezone %q; use an IANA name such as Europe/Paris", name)
+		}
+	}
+	if err := s.store.SetUserSetting(ctx, principal.UserID, timezoneSetting, name, time.Now().UTC()); err != nil {
+		return Preferences{}, err
+	}
+	return s.Preferences(ctx, principal)
+}
+
+// SetTheme stores the principal's UI theme. Callers check the name against
+// the configured themes; an empty name goes back to the default.
+func (s *Service) SetTheme(ctx context.Context, principal auth.Principal, name string) (Preferences, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > 32 {
+		return Preferences{}, fmt.Errorf("theme name %q is too long", name)
+	}
+	if err := s.store.SetUserSetting(ctx, principal.UserID, themeSetting, name, time.Now().UTC()); err != nil {
+		return Preferences{}, err
+	}
+	return s.Preferences(ctx, principal)
+}
+
+// SetLanguage stores the principal's UI language. An empty tag goes back
+// to the browser's language.
+func (s *Service) SetLanguage(ctx context.Context, principal auth.Principal, tag string) (Preferences, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag != "" && !i18n.Supported(tag) {
+		return Preferences{}, fmt.Errorf("unsupported language %q", tag)
+	}
+	if err := s.store.SetUserSetting(ctx, principal.UserID, languageSetting, tag, time.Now().UTC()); err != nil {
+		return Preferences{}, err
+	}
+	return s.Preferences(ctx, principal)
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+)
+
+// RunPreview is the provider request a message would produce and how much
+// of the model's context window its history takes.
+type RunPreview struct {
+	ai.RequestPreview
+	Context ContextUsage `json:"context"`
+}
+
+type PreviewMessage = ai.PreviewMessage
+
+// PreviewRun shows what sending content to a chat would send to model (the
+// default model when empty): the final system prompt, the trimmed history
+// with the draft and any pending attachments, the tools and a token
+// estimate. Nothing is stored and no provider is called.
+func (s *Service) PreviewRun(ctx context.Context, principal auth.Principal, chatID, content, model, locale string) (RunPreview, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return RunPreview{}, fmt.Errorf("%w: content is required", ErrInvalidRun)
+	}
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return RunPreview{}, err
+	}
+	if model == "" {
+		model = s.DefaultModel()
+	}
+	if !s.IsAllowedModel(model) {
+		return RunPreview{}, fmt.Errorf("%w: model %q is not available", ErrInvalidRun, model)
+	}
+	if s.runner == nil {
+		return RunPreview{}, errors.New("no model runner configured")
+	}
+	request, usage, err := s.buildRunRequest(ctx, chat.ID, model, locale, content)
+	if err != nil {
+		return RunPreview{}, err
+	}
+	preview, err := s.runner.Preview(request.Model, request.History, request.StreamOptions("", 0))
+	if err != nil {
+		return RunPreview{}, err
+	}
+	return RunPreview{RequestPreview: preview, Context: usage}, nil
+}
@@ -0,0 +1,229 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/jobs"
+)
+
+type ScheduledPrompt = db.ScheduledPrompt
+
+const (
+	maxScheduledPromptsPerChat = 10
+	maxScheduledPromptBytes    = 4000
+	// dueScheduledPromptBatch bounds how many prompts one scheduler pass
+	// runs, so a backlog after downtime drains over several passes.
+	dueScheduledPromptBatch = 20
+)
+
+// PromptFrequencies are the schedules a prompt can repeat on, in the order
+// the UI offers them.
+var PromptFrequencies = []string{"daily", "weekdays", "mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+var promptWeekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// promptSchedule turns a frequency and a "15:04" time of day into a
+// schedule in the server's time zone.
+func promptSchedule(frequency, timeOfDay string) (jobs.Schedule, error) {
+	at, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return nil, fmt.Errorf("time of day must look like 07:30, got %q", timeOfDay)
+	}
+	days := "*"
+	switch frequency {
+	case "daily":
+	case "weekdays":
+		days = "1-5"
+	default:
+		weekday, ok := promptWeekdays[frequency]
+		if !ok {
+			return nil, fmt.Errorf("unknown frequency %q", frequency)
+		}
+		days = fmt.Sprint(weekday)
+	}
+	return jobs.Parse(fmt.Sprintf("%d %d * * %s", at.Minute(), at.Hour(), days), time.Local)
+}
+
+// PromptFrequencyLabel names a frequency for display, such as "Mondays".
+func PromptFrequencyLabel(frequency string) string {
+	switch frequency {
+	case "daily":
+		return "Daily"
+	case "weekdays":
+		return "Weekdays"
+	}
+	if weekday, ok := promptWeekdays[frequency]; ok {
+		return time.Weekday(weekday).String() + "s"
+	}
+	return frequency
+}
+
+// DescribePromptSchedule is the human form of a prompt's schedule, such as
+// "Weekdays at 07:30".
+func DescribePromptSchedule(prompt ScheduledPrompt) string {
+	return PromptFrequencyLabel(prompt.Frequency) + " at " + prompt.TimeOfDay
+}
+
+// CreateScheduledPrompt schedules prompt to be sent to the chat on the
+// given frequency at timeOfDay, server time. Each run goes through the same
+// pipeline as a message typed into the chat, so quotas and the chat's spend
+// ceiling apply.
+func (s *Service) CreateScheduledPrompt(ctx context.Context, principal auth.Principal, chatID, prompt, frequency, timeOfDay string) (ScheduledPrompt, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return ScheduledPrompt{}, err
+	}
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return ScheduledPrompt{}, errors.New("prompt is required")
+	}
+	if len(prompt) > maxScheduledPromptBytes {
+		return ScheduledPrompt{}, fmt.Errorf("prompt is longer than %d bytes", maxScheduledPromptBytes)
+	}
+	timeOfDay = strings.TrimSpace(timeOfDay)
+	schedule, err := promptSchedule(frequency, timeOfDay)
+	if err != nil {
+		return ScheduledPrompt{}, err
+	}
+	existing, err := s.store.ListScheduledPrompts(ctx, chat.ID)
+	if err != nil {
+		return ScheduledPrompt{}, err
+	}
+	if len(existing) >= maxScheduledPromptsPerChat {
+		return ScheduledPrompt{}, fmt.Errorf("a chat can have at most %d scheduled prompts", maxScheduledPromptsPerChat)
+	}
+	now := time.Now().UTC()
+	scheduled := ScheduledPrompt{
+		ID:        uuid.NewString(),
+		ChatID:    chat.ID,
+		OwnerID:   chat.OwnerID.String,
+		Prompt:    prompt,
+		Frequency: frequency,
+		TimeOfDay: timeOfDay,
+		NextRunAt: schedule.Next(now).UTC(),
+		CreatedAt: now,
+	}
+	if err := s.store.InsertScheduledPrompt(ctx, scheduled); err != nil {
+		return ScheduledPrompt{}, err
+	}
+	return scheduled, nil
+}
+
+func (s *Service) ScheduledPrompts(ctx context.Context, chatID string) ([]ScheduledPrompt, error) {
+	if chatID == "" {
+		return nil, nil
+	}
+	return s.store.ListScheduledPrompts(ctx, chatID)
+}
+
+func (s *Service) DeleteScheduledPrompt(ctx context.Context, principal auth.Principal, promptID string) error {
+	prompt, err := s.store.GetScheduledPrompt(ctx, strings.TrimSpace(promptID))
+	if err != nil {
+		return err
+	}
+	if _, err := s.authorizeChat(ctx, principal, prompt.ChatID); err != nil {
+		return err
+	}
+	return s.store.DeleteScheduledPrompt(ctx, prompt.ID)
+}
+
+// ScheduledPromptsJob returns the scheduler job that sends due prompts.
+func (s *Service) ScheduledPromptsJob(logger *slog.Logger) func(ctx context.Context, scheduled time.Time) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, _ time.Time) error {
+		return s.RunDuePrompts(ctx, time.Now().UTC(), logger)
+	}
+}
+
+// RunDuePrompts sends every prompt due at now into its chat, one at a time.
+// Each occurrence is claimed before it runs, so a prompt missed during
+// downtime runs once rather than once per missed occurrence, and a crash
+// mid-run does not repeat it.
+func (s *Service) RunDuePrompts(ctx context.Context, now time.Time, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	due, err := s.store.DueScheduledPrompts(ctx, now, dueScheduledPromptBatch)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, prompt := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Left unclaimed, the prompt runs after the restart.
+		if s.Draining() {
+			return nil
+		}
+		if err := s.runScheduledPrompt(ctx, prompt, now); err != nil {
+			failed++
+			logger.Warn("scheduled prompt failed", "prompt_id", prompt.ID, "chat_id", prompt.ChatID, "error", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scheduled prompts failed", failed, len(due))
+	}
+	return nil
+}
+
+func (s *Service) runScheduledPrompt(ctx context.Context, prompt ScheduledPrompt, now time.Time) error {
+	schedule, err := promptSchedule(prompt.Frequency, prompt.TimeOfDay)
+	if err != nil {
+		return err
+	}
+	runID := uuid.NewString()
+	claimed, err := s.store.ClaimScheduledPrompt(ctx, prompt.ID, prompt.NextRunAt, schedule.Next(now).UTC(), runID, now)
+	if err != nil || !claimed {
+		return err
+	}
+	chat, err := s.store.GetChat(ctx, prompt.ChatID)
+	if err != nil {
+		return err
+	}
+	principal := auth.Principal{UserID: prompt.OwnerID}
+	if prompt.OwnerID == "" {
+		principal.UserID = auth.AnonymousUserID
+	}
+	model := chat.Model
+	if !s.IsAllowedModel(model) {
+		model = ""
+	}
+
+	status, content, errorText := "error", "", ""
+	runErr := s.ExecuteRun(ctx, principal, APIRunRequest{
+		ChatID:  chat.ID,
+		Content: prompt.Prompt,
+		Model:   model,
+		RunID:   runID,
+	}, func(event RunEvent) {
+		if event.Type == RunEventCompleted {
+			status, content, errorText = event.Status, event.Content, event.Error
+		}
+	})
+	if runErr != nil && errorText == "" {
+		errorText = runErr.Error()
+	}
+	if err := s.store.FinishScheduledPrompt(context.WithoutCancel(ctx), prompt.ID, runID, status, errorText); err != nil {
+		return err
+	}
+	s.emailPromptResult(context.WithoutCancel(ctx), prompt, chat, principal.UserID, status, content, errorText)
+	if runErr != nil {
+		return runErr
+	}
+	if status == "error" {
+		return errors.New(errorText)
+	}
+	return nil
+}
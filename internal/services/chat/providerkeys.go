@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+// ErrProviderKeysDisabled is returned when storing a provider key on a
+// server without an encryption key, or for a user who is not signed in.
+var ErrProviderKeysDisabled = errors.New("provider keys need an encryption key and a signed-in user")
+
+// ProviderKey describes one of a user's stored provider API keys. Hint is
+// the key's last four characters.
+type ProviderKey = db.ProviderKey
+
+// ProviderKeysEnabled reports whether users can store their own provider
+// API keys. Keys are only ever stored encrypted.
+func (s *Service) ProviderKeysEnabled() bool {
+	return s.store.Sealing()
+}
+
+// KeyProviders lists the providers a user can store a key for.
+func (s *Service) KeyProviders() []string {
+	return ai.KeyProviders
+}
+
+// ProviderKeys describes the principal's stored provider API keys.
+func (s *Service) ProviderKeys(ctx context.Context, principal auth.Principal) ([]ProviderKey, error) {
+	owner := ownerOf(principal)
+	if owner == "" {
+		return nil, nil
+	}
+	return s.store.ListProviderKeys(ctx, owner)
+}
+
+// SetProviderKey stores the principal's API key for provider. Runs in the
+// principal's chats then use it instead of the server's key.
+func (s *Service) SetProviderKey(ctx context.Context, principal auth.Principal, provider, key string) ([]ProviderKey, error) {
+	owner := ownerOf(principal)
+	if owner == "" || !s.ProviderKeysEnabled() {
+		return nil, ErrProviderKeysDisabled
+	}
+	if !slices.Contains(ai.KeyProviders, provider) {
+		return nil, fmt.Errorf("unknown provider %q; use one of %s", provider, strings.Join(ai.KeyProviders, ", "))
+	}
+	key = strings.TrimSpace(key)
+	if key == "" || len(key) > 512 || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+		return nil, errors.New("paste the API key as a single line of at most 512 characters")
+	}
+	if err := s.store.SetProviderKey(ctx, owner, provider, key, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return s.store.ListProviderKeys(ctx, owner)
+}
+
+// DeleteProviderKey removes the principal's API key for provider, so runs
+// go back to the server's key.
+func (s *Service) DeleteProviderKey(ctx context.Context, principal auth.Principal, provider string) ([]ProviderKey, error) {
+	owner := ownerOf(principal)
+	if owner == "" {
+		return nil, ErrProviderKeysDisabled
+	}
+	if err := s.store.DeleteProviderKey(ctx, owner, provider); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	return s.store.ListProviderKeys(ctx, owner)
+}
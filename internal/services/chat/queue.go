@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ErrQueueFull is returned for a run that arrives when the run queue
+// already holds config.Config.MaxQueuedRuns runs.
+var ErrQueueFull = errors.New("too many runs are waiting; try again shortly")
+
+// streamLimits are the run queue settings a run is admitted under; zero
+// values are unlimited.
+type streamLimits struct {
+	streams int
+	perUser int
+	queued  int
+}
+
+// runQueue admits provider streams in arrival order, up to a total and a
+// per-user number at once. A waiting run whose user is at their limit
+// does not hold up the runs of other users behind it. Runs with no user
+// only count towards the total.
+type runQueue struct {
+	mu        sync.Mutex
+	streaming int
+	byUser    map[string]int
+	waiting   []*queuedRun
+}
+
+type queuedRun struct {
+	userID string
+	// admitted is closed when the run may stream; moved is signalled when
+	// its place in line changes.
+	admitted chan struct{}
+	moved    chan struct{}
+	done     bool
+}
+
+func newRunQueue() *runQueue {
+	return &runQueue{byUser: map[string]int{}}
+}
+
+// acquire waits until a stream for userID is admitted under limits, and
+// returns the function that frees its slot. While it waits, queued is
+// called with the run's place in line, 1 being next, whenever that
+// changes, and with 0 once a run that waited is admitted. Cancelling ctx
+// gives up the place.
+func (q *runQueue) acquire(ctx context.Context, userID string, limits streamLimits, queued func(position int)) (func(), error) {
+	run := &queuedRun{userID: userID, admitted: make(chan struct{}), moved: make(chan struct{}, 1)}
+	q.mu.Lock()
+	q.waiting = append(q.waiting, run)
+	q.admit(limits)
+	if !run.done && limits.queued > 0 && len(q.waiting) > limits.queued {
+		q.remove(run)
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	q.mu.Unlock()
+
+	release := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.streaming--
+		if run.userID != "" {
+			if q.byUser[run.userID]--; q.byUser[run.userID] == 0 {
+				delete(q.byUser, run.userID)
+			}
+		}
+		q.admit(limits)
+	}
+	position := -1
+	for {
+		select {
+		case <-run.admitted:
+			if position > 0 && queued != nil {
+				queued(0)
+			}
+			return release, nil
+		case <-run.moved:
+		case <-ctx.Done():
+			q.mu.Lock()
+			if run.done {
+				q.mu.Unlock()
+				release()
+				return nil, context.Cause(ctx)
+			}
+			q.remove(run)
+			q.admit(limits)
+			q.mu.Unlock()
+			return nil, context.Cause(ctx)
+		}
+		q.mu.Lock()
+		current := slices.Index(q.waiting, run) + 1
+		q.mu.Unlock()
+		if current > 0 && current != position && queued != nil {
+			position = current
+			queued(position)
+		}
+	}
+}
+
+// admit starts every waiting run the limits allow, in order, and tells
+// the runs still waiting that the line moved. It is called with mu held.
+func (q *runQueue) admit(limits streamLimits) {
+	waiting := q.waiting[:0]
+	for _, run := range q.waiting {
+		full := limits.streams > 0 && q.streaming >= limits.streams
+		userFull := run.userID != "" && limits.perUser > 0 && q.byUser[run.userID] >= limits.perUser
+		if full || userFull {
+			waiting = append(waiting, run)
+			continue
+		}
+		q.streaming++
+		if run.userID != "" {
+			q.byUser[run.userID]++
+		}
+		run.done = true
+		close(run.admitted)
+	}
+	clear(q.waiting[len(waiting):])
+	q.waiting = waiting
+	for _, run := range q.waiting {
+		select {
+		case run.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *runQueue) remove(run *queuedRun) {
+	if i := slices.Index(q.waiting, run); i >= 0 {
+		q.waiting = slices.Delete(q.waiting, i, i+1)
+	}
+}
+
+// queuedRuns counts the runs waiting for a stream slot.
+func (q *runQueue) queuedRuns() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
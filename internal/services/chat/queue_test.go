@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunQueueAdmitsInOrderWithinTotalAndPerUserLimits(t *testing.T) {
+	queue := newRunQueue()
+	limits := streamLimits{streams: 2, perUser: 1, queued: 2}
+	ctx := context.Background()
+
+	releaseAlice, err := queue.acquire(ctx, "alice", limits, nil)
+	if err != nil {
+		t.Fatalf("acquire(alice) error = %v", err)
+	}
+	// Alice is at her limit, so her second run waits while Bob's starts.
+	positions := make(chan int, 8)
+	aliceAgain := make(chan func(), 1)
+	go func() {
+		release, _ := queue.acquire(ctx, "alice", limits, func(position int) { positions <- position })
+		aliceAgain <- release
+	}()
+	if position := <-positions; position != 1 {
+		t.Fatalf("queued position = %d, want 1", position)
+	}
+	releaseBob, err := queue.acquire(ctx, "bob", limits, nil)
+	if err != nil {
+		t.Fatalf("acquire(bob) error = %v, want Bob admitted past Alice's waiting run", err)
+	}
+
+	// Both slots are taken: Carol waits behind Alice, and a fourth run
+	// finds the line full.
+	cancelled, cancel := context.WithCancel(ctx)
+	carol := make(chan error, 1)
+	go func() {
+		_, err := queue.acquire(cancelled, "carol", limits, nil)
+		carol <- err
+	}()
+	waitFor(t, func() bool { return queue.queuedRuns() == 2 })
+	if _, err := queue.acquire(ctx, "dave", limits, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("acquire(dave) error = %v, want ErrQueueFull", err)
+	}
+	cancel()
+	if err := <-carol; !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire(carol) error = %v, want it to give up its place", err)
+	}
+
+	releaseAlice()
+	select {
+	case release := <-aliceAgain:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("Alice's second run was not admitted when her first finished")
+	}
+	if position := <-positions; position != 0 {
+		t.Fatalf("position on admission = %d, want 0", position)
+	}
+	releaseBob()
+	if queue.queuedRuns() != 0 || queue.streaming != 0 || len(queue.byUser) != 0 {
+		t.Fatalf("queue = %+v, want it empty", queue)
+	}
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
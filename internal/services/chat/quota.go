@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+var ErrRateLimited = errors.New("rate limit reached")
+
+// QuotaWindow is one rolling rate limit window.
+type QuotaWindow struct {
+	Name      string    `json:"name"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at,omitzero"`
+}
+
+// QuotaStatus is a user's remaining run allowance and usage budgets. Near
+// is set once any window or budget is within the configured warning
+// threshold, and Exhausted once any is used up.
+type QuotaStatus struct {
+	Limited   bool          `json:"limited"`
+	Near      bool          `json:"near"`
+	Exhausted bool          `json:"exhausted"`
+	Windows   []QuotaWindow `json:"windows"`
+	Budgets   []UsageBudget `json:"budgets"`
+}
+
+// RateLimitsEnabled reports whether any run rate limit is configured.
+func (s *Service) RateLimitsEnabled() bool {
+	cfg := s.settings()
+	return cfg.RateLimitRunsPerHour > 0 || cfg.RateLimitRunsPerDay > 0
+}
+
+// QuotaStatus reports how many runs the principal has left in each
+// configured window, and what is left of each budget. Windows roll: a slot
+// frees up when the oldest run in the window ages out, which is what
+// ResetsAt reports. Budgets reset at the start of the next UTC day or month.
+func (s *Service) QuotaStatus(ctx context.Context, principal auth.Principal) (QuotaStatus, error) {
+	cfg := s.settings()
+	status := QuotaStatus{Windows: []QuotaWindow{}, Budgets: []UsageBudget{}}
+	now := time.Now().UTC()
+	limits := []struct {
+		name   string
+		limit  int
+		window time.Duration
+	}{
+		{"hour", cfg.RateLimitRunsPerHour, time.Hour},
+		{"day", cfg.RateLimitRunsPerDay, 24 * time.Hour},
+	}
+	for _, limit := range limits {
+		if limit.limit <= 0 {
+			continue
+		}
+		used, oldest, err := s.store.CountOwnerRunsSince(ctx, ownerOf(principal), now.Add(-limit.window))
+		if err != nil {
+			return QuotaStatus{}, err
+		}
+		window := QuotaWindow{
+			Name:      limit.name,
+			Limit:     limit.limit,
+			Used:      used,
+			Remaining: max(limit.limit-used, 0),
+		}
+		if used > 0 {
+			window.ResetsAt = oldest.Add(limit.window)
+		}
+		status.Limited = true
+		if window.Remaining == 0 {
+			status.Exhausted = true
+		}
+		if window.Remaining*100 <= limit.limit*cfg.RateLimitWarnPercent {
+			status.Near = true
+		}
+		status.Windows = append(status.Windows, window)
+	}
+	budgets, err := s.usageBudgets(ctx, principal, now)
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	for _, budget := range budgets {
+		status.Limited = true
+		if budget.Remaining == 0 {
+			status.Exhausted = true
+		}
+		if budget.Remaining*100 <= budget.Limit*float64(cfg.RateLimitWarnPercent) {
+			status.Near = true
+		}
+		status.Budgets = append(status.Budgets, budget)
+	}
+	return status, nil
+}
+
+// CheckRunQuota returns ErrRateLimited when the principal may not start
+// another run right now, or ErrBudgetExhausted once a budget is used up.
+// A run may start with any budget left, so the last one can overshoot it.
+func (s *Service) CheckRunQuota(ctx context.Context, principal auth.Principal) error {
+	return s.CheckRunsQuota(ctx, principal, 1)
+}
+
+// CheckRunsQuota is CheckRunQuota for starting several runs at once, as
+// a comparison does.
+func (s *Service) CheckRunsQuota(ctx context.Context, principal auth.Principal, runs int) error {
+	status, err := s.QuotaStatus(ctx, principal)
+	if err != nil {
+		return err
+	}
+	for _, window := range status.Windows {
+		if window.Remaining < runs {
+			return fmt.Errorf("%w: %d runs per %s; try again after %s", ErrRateLimited, window.Limit, window.Name, window.ResetsAt.Local().Format("15:04"))
+		}
+	}
+	for _, budget := range status.Budgets {
+		if budget.Remaining == 0 {
+			return budgetExhaustedError(budget)
+		}
+	}
+	return nil
+}
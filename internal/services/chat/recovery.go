@@ -0,0 +1,22 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+// interruptedRunError is recorded on runs the server lost by restarting.
+const interruptedRunError = "the server restarted before the run finished"
+
+type OrphanReport = db.OrphanReport
+
+// RecoverInterruptedRuns settles runs, assistant messages and tool calls
+// left in flight by a previous process, so they stop showing as streaming
+// and the chat can offer a retry. Interrupted replies keep the text of
+// their last flush unless RecoveryKeepPartial is off. Call it once at
+// startup, before serving.
+func (s *Service) RecoverInterruptedRuns(ctx context.Context) (OrphanReport, error) {
+	return s.store.ReconcileOrphans(ctx, interruptedRunError, s.settings().RecoveryKeepPartial, time.Now().UTC())
+}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/pii"
+)
+
+// originalTTL bounds how long the unredacted text of a user message is
+// kept in memory for the provider request of the run that sent it.
+const originalTTL = 5 * time.Minute
+
+// RedactionEnabled reports whether personal data is masked in stored user
+// messages and tool outputs.
+func (s *Service) RedactionEnabled() bool {
+	cfg := s.settings()
+	return cfg.redact.Enabled() || cfg.RedactModel != ""
+}
+
+// redact masks personal data in text that is about to be stored. The
+// patterns run first; when the model check fails, the pattern-masked text
+// is stored and the failure logged.
+func (s *Service) redact(ctx context.Context, text string) string {
+	cfg := s.settings()
+	redacted := cfg.redact.Scrub(text)
+	if cfg.RedactModel == "" || s.runner == nil {
+		return redacted
+	}
+	masked, err := pii.NewModel(s.completer(cfg.RedactModel)).Redact(ctx, redacted)
+	if err != nil {
+		slog.Warn("pii redaction model failed; storing pattern-masked text only", "model", cfg.RedactModel, "error", err)
+		return redacted
+	}
+	return masked
+}
+
+// originals holds the unredacted text of user messages stored redacted, by
+// message ID, until their run's request is built. Entries expire after
+// originalTTL instead of on first use, since both runs of a comparison
+// send the same message.
+type originals struct {
+	mu      sync.Mutex
+	entries map[string]original
+}
+
+type original struct {
+	redacted string
+	content  string
+	expires  time.Time
+}
+
+func newOriginals() *originals {
+	return &originals{entries: map[string]original{}}
+}
+
+func (o *originals) put(messageID, redacted, content string, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, entry := range o.entries {
+		if now.After(entry.expires) {
+			delete(o.entries, id)
+		}
+	}
+	o.entries[messageID] = original{redacted: redacted, content: content, expires: now.Add(originalTTL)}
+}
+
+func (o *originals) get(messageID string, now time.Time) (original, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, ok := o.entries[messageID]
+	if !ok || now.After(entry.expires) {
+		return original{}, false
+	}
+	return entry, true
+}
+
+// restoreOriginal swaps the redacted text of the run's user message in
+// history for the original. The message is the last user message starting
+// with the redacted text; attached documents may follow it.
+func (s *Service) restoreOriginal(history []AIMessage, userMessageID string) {
+	if userMessageID == "" {
+		return
+	}
+	entry, ok := s.originals.get(userMessageID, time.Now())
+	if !ok {
+		return
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role != "user" {
+			continue
+		}
+		if rest, found := strings.CutPrefix(history[i].Content, entry.redacted); found {
+			history[i].Content = entry.content + rest
+		}
+		return
+	}
+}
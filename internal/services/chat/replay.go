@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/db"
+)
+
+type ReplayRun = db.ReplayRun
+
+// ErrNoRunSnapshot is returned when replaying a run recorded before request
+// snapshots existed.
+var ErrNoRunSnapshot = errors.New("run has no request snapshot")
+
+// RunRequest is the exact input of a run. It is snapshotted when the run
+// starts so the run can be replayed later.
+type RunRequest struct {
+	Model         string           `json:"model"`
+	History       []AIMessage      `json:"history"`
+	Params        GenerationParams `json:"params"`
+	DisabledTools []string         `json:"disabled_tools,omitempty"`
+	// UserID is the chat owner whose provider keys the run is sent with.
+	// It is left out of snapshots, so replays use the server's keys.
+	UserID string `json:"-"`
+	// OutputSchema is the structured output the run asked for, if any.
+	OutputSchema *JSONSchema `json:"output_schema,omitempty"`
+}
+
+// StreamOptions returns the options to stream this request under runID.
+func (r RunRequest) StreamOptions(runID string, timeout time.Duration) StreamOptions {
+	return StreamOptions{
+		RunID:         runID,
+		RunTimeout:    timeout,
+		Params:        r.Params,
+		DisabledTools: r.DisabledTools,
+		UserID:        r.UserID,
+		OutputSchema:  r.OutputSchema,
+	}
+}
+
+// PrepareRun builds the request for a persisted run from the chat's history,
+// parameters and tool settings, and stores it as the run's snapshot. History
+// is rebuilt from mutable messages, so the snapshot is the only record of
+// the prompt once messages are edited or deleted.
+func (s *Service) PrepareRun(ctx context.Context, run PendingRun) (RunRequest, error) {
+	request, usage, err := s.buildRunRequest(ctx, run.ChatID, run.Model, run.Locale, "")
+	if err != nil {
+		return RunRequest{}, err
+	}
+	if err := usage.Err(); err != nil {
+		return RunRequest{}, err
+	}
+	request.OutputSchema = run.OutputSchema
+	if err := s.saveRunSnapshot(ctx, run.RunID, request); err != nil {
+		return RunRequest{}, err
+	}
+	// The snapshot keeps the stored, redacted text; only the provider sees
+	// the original.
+	s.restoreOriginal(request.History, run.UserMessageID)
+	chat, err := s.store.GetChat(ctx, run.ChatID)
+	if err != nil {
+		return RunRequest{}, err
+	}
+	request.UserID = chat.OwnerID.String
+	return request, nil
+}
+
+// buildRunRequest assembles a chat's run request and its context usage;
+// draft is passed to buildHistory.
+func (s *Service) buildRunRequest(ctx context.Context, chatID, model, locale, draft string) (RunRequest, ContextUsage, error) {
+	history, usage, err := s.buildHistory(ctx, chatID, model, locale, draft)
+	if err != nil {
+		return RunRequest{}, ContextUsage{}, err
+	}
+	params, err := s.GenerationParams(ctx, chatID)
+	if err != nil {
+		return RunRequest{}, ContextUsage{}, err
+	}
+	disabledTools, err := s.DisabledTools(ctx, chatID)
+	if err != nil {
+		return RunRequest{}, ContextUsage{}, err
+	}
+	return RunRequest{
+		Model:         model,
+		History:       history,
+		Params:        params,
+		DisabledTools: disabledTools,
+	}, usage, nil
+}
+
+// ReplayEnabled reports whether the developer replay action is available.
+func (s *Service) ReplayEnabled() bool {
+	cfg := s.settings()
+	return cfg.DevMode || cfg.DebugEndpoints
+}
+
+// ReplayRun re-executes the recorded request behind an assistant message
+// against model (the original model when empty) and records the result as a
+// replay linked to the source run. Replays never touch the chat's messages.
+func (s *Service) ReplayRun(ctx context.Context, assistantMessageID, model string) (ReplayRun, error) {
+	if !s.ReplayEnabled() {
+		return ReplayRun{}, errors.New("run replay is only available in development")
+	}
+	source, err := s.store.GetRunByAssistantMessage(ctx, strings.TrimSpace(assistantMessageID))
+	if err != nil {
+		return ReplayRun{}, err
+	}
+	request, err := s.RunSnapshot(ctx, source)
+	if err != nil {
+		return ReplayRun{}, err
+	}
+	if model == "" {
+		model = request.Model
+	}
+	if !s.IsAllowedModel(model) {
+		return ReplayRun{}, fmt.Errorf("unsupported model %q", model)
+	}
+	if s.runner == nil {
+		return ReplayRun{}, errors.New("no model runner configured")
+	}
+
+	replay := ReplayRun{
+		ID:          uuid.NewString(),
+		SourceRunID: source.ID,
+		Model:       model,
+		Status:      "running",
+		StartedAt:   time.Now().UTC(),
+	}
+	if err := s.store.InsertReplayRun(ctx, replay); err != nil {
+		return ReplayRun{}, err
+	}
+
+	var output, reasoning strings.Builder
+	var toolCalls []ToolCallUpdate
+	result, streamErr := s.runner.Stream(ctx, model, request.History, request.StreamOptions(replay.ID, s.RunTimeout()), StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			output.WriteString(delta)
+		},
+		OnThinkingDelta: func(delta string) {
+			reasoning.WriteString(delta)
+		},
+		OnToolResult: func(update ToolCallUpdate) {
+			toolCalls = append(toolCalls, update)
+		},
+	})
+
+	replay.Status = "completed"
+	if streamErr != nil {
+		replay.ErrorText = streamErr.Error()
+		switch {
+		case s.IsCancellation(streamErr, ctx):
+			replay.Status = "cancelled"
+		case s.IsTimeout(streamErr):
+			replay.Status = "timed_out"
+		default:
+			replay.Status = "error"
+		}
+	}
+	replay.Output = output.String()
+	replay.Reasoning = reasoning.String()
+	replay.StopReason = result.StopReason
+	if len(toolCalls) > 0 {
+		encoded, _ := json.Marshal(toolCalls)
+		replay.ToolCallsJSON = string(encoded)
+	}
+	if result.Usage != nil {
+		encoded, _ := json.Marshal(result.Usage)
+		replay.UsageJSON = string(encoded)
+	}
+	replay.FinishedAt.Time = time.Now().UTC()
+	replay.FinishedAt.Valid = true
+	if err := s.store.CompleteReplayRun(context.WithoutCancel(ctx), replay); err != nil {
+		return ReplayRun{}, err
+	}
+	return replay, nil
+}
+
+// ListReplays returns the replays recorded for the run behind an assistant
+// message.
+func (s *Service) ListReplays(ctx context.Context, assistantMessageID string) ([]ReplayRun, error) {
+	source, err := s.store.GetRunByAssistantMessage(ctx, assistantMessageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.ListReplayRuns(ctx, source.ID)
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+// retentionBatch bounds the idle chats the retention job loads at once; it
+// keeps going until none are left.
+const retentionBatch = 100
+
+// RetentionEnabled reports whether the retention job deletes anything, so
+// the UI only offers to keep chats forever when that means something.
+func (s *Service) RetentionEnabled() bool {
+	cfg := s.settings().Config
+	return cfg.RetentionChatIdle > 0 || cfg.RetentionToolPayloads > 0
+}
+
+// SetChatKeepForever exempts the chat from retention, or makes it subject
+// to retention again.
+func (s *Service) SetChatKeepForever(ctx context.Context, principal auth.Principal, chatID string, keep bool) (Chat, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return Chat{}, err
+	}
+	if err := s.store.SetChatKeepForever(ctx, chat.ID, keep); err != nil {
+		return Chat{}, err
+	}
+	chat.KeepForever = keep
+	return chat, nil
+}
+
+// RetentionJob returns the scheduler job that enforces the retention
+// settings.
+func (s *Service) RetentionJob(logger *slog.Logger) func(ctx context.Context, scheduled time.Time) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, _ time.Time) error {
+		return s.EnforceRetention(ctx, time.Now().UTC(), logger)
+	}
+}
+
+// EnforceRetention deletes the chats idle for longer than
+// RetentionChatIdle and clears the payloads of tool calls older than
+// RetentionToolPayloads, sparing chats kept forever. A zero setting keeps
+// what it covers.
+func (s *Service) EnforceRetention(ctx context.Context, now time.Time, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	cfg := s.settings().Config
+	if cfg.RetentionChatIdle > 0 {
+		deleted, err := s.deleteIdleChats(ctx, now.Add(-cfg.RetentionChatIdle))
+		if deleted > 0 {
+			logger.Info("deleted idle chats", "count", deleted, "idle_for", cfg.RetentionChatIdle)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.RetentionToolPayloads > 0 {
+		purged, err := s.store.PurgeToolCallPayloads(ctx, now.Add(-cfg.RetentionToolPayloads))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			logger.Info("purged tool call payloads", "count", purged, "older_than", cfg.RetentionToolPayloads)
+		}
+	}
+	return nil
+}
+
+func (s *Service) deleteIdleChats(ctx context.Context, cutoff time.Time) (int, error) {
+	deleted := 0
+	for {
+		chats, err := s.store.IdleChats(ctx, cutoff, retentionBatch)
+		if err != nil {
+			return deleted, err
+		}
+		for _, chat := range chats {
+			if ctx.Err() != nil {
+				return deleted, ctx.Err()
+			}
+			if err := s.DeleteChat(ctx, chat.ID); err != nil {
+				return deleted, fmt.Errorf("delete idle chat %s: %w", chat.ID, err)
+			}
+			deleted++
+		}
+		if len(chats) < retentionBatch {
+			return deleted, nil
+		}
+	}
+}
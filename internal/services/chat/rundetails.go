@@ -0,0 +1,137 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/db"
+	"rhone_chat/internal/moderation"
+)
+
+// RunDetail is what the runs table recorded about one run of an assistant
+// message. Token counts are zero when the provider reported no usage;
+// CostUSD is nil when the cost is unknown.
+type RunDetail struct {
+	RunID         string        `json:"run_id"`
+	Model         string        `json:"model"`
+	Status        string        `json:"status"`
+	StopReason    string        `json:"stop_reason,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	ToolCallCount int           `json:"tool_call_count"`
+	TurnCount     int           `json:"turn_count"`
+	InputTokens   int           `json:"input_tokens"`
+	OutputTokens  int           `json:"output_tokens"`
+	CostUSD       *float64      `json:"cost_usd,omitempty"`
+	StartedAt     time.Time     `json:"started_at"`
+	Duration      time.Duration `json:"duration_ns"`
+	// FirstToken, Stream and OutputTokensPerSecond are zero when unknown,
+	// as for runs from before they were recorded.
+	FirstToken            time.Duration `json:"first_token_ns,omitempty"`
+	Stream                time.Duration `json:"stream_ns,omitempty"`
+	OutputTokensPerSecond float64       `json:"output_tokens_per_second,omitempty"`
+	Tools                 []ToolLatency `json:"tool_durations,omitempty"`
+	// ComparisonID is set when the run was one side of a comparison.
+	ComparisonID string `json:"comparison_id,omitempty"`
+	// Moderation is the decision on the user message, nil when it was not
+	// screened.
+	Moderation *moderation.Decision `json:"moderation,omitempty"`
+}
+
+// ChatRunDetails returns the runs of each assistant message in a chat,
+// oldest first, keyed by message ID. A retried message has one run per
+// attempt.
+func (s *Service) ChatRunDetails(ctx context.Context, chatID string) (map[string][]RunDetail, error) {
+	runs, err := s.store.ListRunsForChat(ctx, strings.TrimSpace(chatID))
+	if err != nil {
+		return nil, err
+	}
+	details := make(map[string][]RunDetail)
+	for _, run := range runs {
+		details[run.AssistantMessageID] = append(details[run.AssistantMessageID], runDetailFromRow(run))
+	}
+	return details, nil
+}
+
+// MessageRunDetails returns the runs of one assistant message, oldest
+// first.
+func (s *Service) MessageRunDetails(ctx context.Context, messageID string) ([]RunDetail, error) {
+	message, err := s.store.GetMessage(ctx, strings.TrimSpace(messageID))
+	if err != nil {
+		return nil, err
+	}
+	details, err := s.ChatRunDetails(ctx, message.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	return details[message.ID], nil
+}
+
+func runDetailFromRow(run db.Run) RunDetail {
+	detail := RunDetail{
+		RunID:         run.ID,
+		Model:         run.Model,
+		Status:        run.Status,
+		StopReason:    run.StopReason,
+		Error:         run.ErrorText,
+		ToolCallCount: run.ToolCallCount,
+		TurnCount:     run.TurnCount,
+		StartedAt:     run.StartedAt.UTC(),
+		ComparisonID:  run.ComparisonID,
+		InputTokens:   run.InputTokens,
+		OutputTokens:  run.OutputTokens,
+	}
+	if run.ModerationJSON != "" {
+		var decision moderation.Decision
+		if json.Unmarshal([]byte(run.ModerationJSON), &decision) == nil {
+			detail.Moderation = &decision
+		}
+	}
+	if run.CostUSD.Valid {
+		cost := run.CostUSD.Float64
+		detail.CostUSD = &cost
+	}
+	if run.FinishedAt.Valid {
+		detail.Duration = run.FinishedAt.Time.Sub(run.StartedAt)
+	}
+	latency := ai.Latency{FirstToken: run.Latency.FirstToken, Stream: run.Latency.Stream}
+	if run.Latency.ToolsJSON != "" {
+		_ = json.Unmarshal([]byte(run.Latency.ToolsJSON), &latency.Tools)
+	}
+	detail.FirstToken = latency.FirstToken
+	detail.Stream = latency.Stream
+	detail.OutputTokensPerSecond = latency.TokensPerSecond(run.OutputTokens)
+	detail.Tools = latency.Tools
+	return detail
+}
+
+// ToolLatency is how long one tool call of a run took.
+type ToolLatency = ai.ToolLatency
+
+// runLatency is the row form of a stream's latency.
+func runLatency(latency ai.Latency) db.RunLatency {
+	row := db.RunLatency{FirstToken: latency.FirstToken, Stream: latency.Stream}
+	if len(latency.Tools) > 0 {
+		if encoded, err := json.Marshal(latency.Tools); err == nil {
+			row.ToolsJSON = string(encoded)
+		}
+	}
+	return row
+}
+
+// observeLatency adds a finished run to the latency histograms. Only
+// completed runs count towards the run histograms, since a cancelled or
+// failed stream says little about how fast the model answers; every tool
+// call that finished counts.
+func (s *Service) observeLatency(model, status string, result StreamResult) {
+	for _, tool := range result.Latency.Tools {
+		s.metrics.ObserveTool(tool.Name, tool.Duration)
+	}
+	if status != "completed" {
+		return
+	}
+	_, output := ai.TokenCounts(result.Usage)
+	s.metrics.ObserveRun(model, result.Latency.FirstToken, result.Latency.Stream, result.Latency.TokensPerSecond(output))
+}
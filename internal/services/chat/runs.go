@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+var (
+	// ErrRunFinished is returned when cancelling a run that already ended.
+	ErrRunFinished = errors.New("run already finished")
+	// ErrShuttingDown is returned for runs started once the server began
+	// draining, and is the cancel cause of runs the drain cut short.
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+const (
+	// stopRunWait bounds how long StopRun waits for a cancelled run to
+	// record its outcome.
+	stopRunWait = 10 * time.Second
+	// drainSaveWait bounds how long Drain waits for the runs it cut short
+	// to save their partial output.
+	drainSaveWait = 5 * time.Second
+	// shutdownRunError is recorded on runs cut short by a drain.
+	shutdownRunError = "the server shut down before the run finished"
+)
+
+// runRegistry tracks the cancel function of every run in flight in this
+// process, so a stop request reaches the provider stream rather than only
+// the UI.
+type runRegistry struct {
+	mu       sync.Mutex
+	runs     map[string]trackedRun
+	draining bool
+}
+
+type trackedRun struct {
+	cancel context.CancelCauseFunc
+	// done is closed once the run has released itself, after its outcome
+	// was saved.
+	done chan struct{}
+}
+
+func newRunRegistry() *runRegistry {
+	return &runRegistry{runs: make(map[string]trackedRun)}
+}
+
+// TrackRun returns a context for runID that CancelRun cancels, and a
+// release function the run must call when it finishes. Once the service is
+// draining it refuses new runs with ErrShuttingDown.
+func (s *Service) TrackRun(ctx context.Context, runID string) (context.Context, func(), error) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	s.runs.mu.Lock()
+	if s.runs.draining {
+		s.runs.mu.Unlock()
+		cancel(nil)
+		return nil, nil, ErrShuttingDown
+	}
+	s.runs.runs[runID] = trackedRun{cancel: cancel, done: done}
+	s.runs.mu.Unlock()
+	finished := s.metrics.RunStarted()
+	return runCtx, func() {
+		s.runs.mu.Lock()
+		delete(s.runs.runs, runID)
+		s.runs.mu.Unlock()
+		cancel(nil)
+		close(done)
+		finished()
+	}, nil
+}
+
+// CancelRun stops a run in flight. The run itself records the "cancelled"
+// status as it unwinds. It reports whether the run was found.
+func (s *Service) CancelRun(runID string) bool {
+	_, ok := s.cancelTracked(runID)
+	return ok
+}
+
+func (s *Service) cancelTracked(runID string) (<-chan struct{}, bool) {
+	s.runs.mu.Lock()
+	run, ok := s.runs.runs[runID]
+	s.runs.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	run.cancel(nil)
+	return run.done, true
+}
+
+// StopRun cancels a run on behalf of principal, whichever client started
+// it: a browser tab, the API or a scheduled prompt. A run in flight is
+// cancelled and StopRun waits briefly for it to save its outcome. A run
+// still recorded as running with nothing executing it is marked cancelled
+// directly. Runs that already ended return ErrRunFinished with their
+// receipt.
+func (s *Service) StopRun(ctx context.Context, principal auth.Principal, runID string) (RunReceipt, error) {
+	runID = strings.TrimSpace(runID)
+	receipt, err := s.RunReceipt(ctx, principal, runID)
+	if err != nil {
+		return RunReceipt{}, err
+	}
+	if receipt.Status != "running" {
+		return receipt, ErrRunFinished
+	}
+	s.RecordStop(ctx, principal, receipt.Model)
+	if done, ok := s.cancelTracked(runID); ok {
+		wait, cancel := context.WithTimeout(ctx, stopRunWait)
+		defer cancel()
+		select {
+		case <-done:
+		case <-wait.Done():
+		}
+	} else if _, err := s.store.CancelStaleRun(ctx, runID, time.Now().UTC()); err != nil {
+		return RunReceipt{}, err
+	}
+	return s.RunReceipt(ctx, principal, runID)
+}
+
+// DrainReport counts the runs a drain found in flight and how many of them
+// it had to cut short.
+type DrainReport struct {
+	InFlight    int
+	Interrupted int
+	// Unsaved counts interrupted runs that had not saved their outcome
+	// when Drain gave up waiting; startup recovery settles them.
+	Unsaved int
+}
+
+// Drain stops the service accepting runs and gives the runs in flight up
+// to window to finish. Runs still going after that are cancelled with
+// ErrShuttingDown, which makes them save their partial output and finish
+// as "interrupted" so they can be retried.
+func (s *Service) Drain(window time.Duration) DrainReport {
+	s.runs.mu.Lock()
+	s.runs.draining = true
+	inFlight := make([]trackedRun, 0, len(s.runs.runs))
+	for _, run := range s.runs.runs {
+		inFlight = append(inFlight, run)
+	}
+	s.runs.mu.Unlock()
+
+	report := DrainReport{InFlight: len(inFlight)}
+	waitAll := func(runs []trackedRun, timeout time.Duration) []trackedRun {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for i, run := range runs {
+			select {
+			case <-run.done:
+			case <-timer.C:
+				var pending []trackedRun
+				for _, run := range runs[i:] {
+					select {
+					case <-run.done:
+					default:
+						pending = append(pending, run)
+					}
+				}
+				return pending
+			}
+		}
+		return nil
+	}
+	remaining := waitAll(inFlight, window)
+	report.Interrupted = len(remaining)
+	for _, run := range remaining {
+		run.cancel(ErrShuttingDown)
+	}
+	report.Unsaved = len(waitAll(remaining, drainSaveWait))
+	// Recovery at the next start keeps what unsaved runs had streamed.
+	_ = s.FlushPartials(context.Background())
+	return report
+}
+
+// Draining reports whether the service has stopped accepting runs.
+func (s *Service) Draining() bool {
+	s.runs.mu.Lock()
+	defer s.runs.mu.Unlock()
+	return s.runs.draining
+}
+
+// IsShutdown reports whether a run's context was cancelled by Drain.
+func (s *Service) IsShutdown(ctx context.Context) bool {
+	return ctx != nil && errors.Is(context.Cause(ctx), ErrShuttingDown)
+}
+
+// ActiveRuns returns how many runs are in flight in this process.
+func (s *Service) ActiveRuns() int {
+	s.runs.mu.Lock()
+	defer s.runs.mu.Unlock()
+	return len(s.runs.runs)
+}
@@ -3,31 +3,109 @@ package chat
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
 	"rhone_chat/internal/ai"
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/blob"
 	"rhone_chat/internal/config"
 	"rhone_chat/internal/db"
+	"rhone_chat/internal/events"
+	"rhone_chat/internal/mail"
+	"rhone_chat/internal/metrics"
+	"rhone_chat/internal/moderation"
+	"rhone_chat/internal/pii"
+	"rhone_chat/internal/postprocess"
+	"rhone_chat/internal/rag"
 )
 
+// ErrChatForbidden is returned when a user acts on a chat owned by someone
+// else.
+var ErrChatForbidden = errors.New("chat belongs to another user")
+
 type Service struct {
-	store  *db.Store
-	runner *ai.Runner
-	cfg    config.Config
+	store     *db.Store
+	runner    *ai.Runner
+	knowledge *rag.Index
+	runs      *runRegistry
+	metrics   *metrics.Runs
+	events    *events.Bus
+	analytics *analytics.Recorder
+	// mailer is nil when email is not configured.
+	mailer mail.Sender
+	// attachments is nil when attachments are stored inline in SQLite.
+	attachments      blob.Store
+	exports          blob.Store
+	unsubscribeKeyMu sync.Mutex
+	originals        *originals
+	current          atomic.Pointer[settings]
+	partials         partialWriter
+	queue            *runQueue
+	live             *liveRuns
+}
+
+// settings is the configuration a Service reads on each call. Reload
+// swaps it whole, so a call that reads it once sees one consistent
+// version.
+type settings struct {
+	config.Config
+	output     postprocess.Pipeline
+	moderation moderation.Terms
+	redact     pii.Scrubber
+}
+
+func newSettings(cfg config.Config) *settings {
+	// Unknown processor names and pii kinds are skipped here; the server
+	// refuses to start or reload with them.
+	output, _ := postprocess.New(cfg.OutputProcessors)
+	terms := moderation.NewTerms(cfg.ModerationBlockTerms, cfg.ModerationFlagTerms)
+	redact, _ := pii.New(cfg.RedactPII)
+	return &settings{Config: cfg, output: output, moderation: terms, redact: redact}
+}
+
+// settings returns the current configuration.
+func (s *Service) settings() *settings {
+	return s.current.Load()
+}
+
+// Reload applies the config.Reloadable settings of cfg to the running
+// service and returns the names of changed settings that need a restart.
+func (s *Service) Reload(cfg config.Config) []string {
+	reloaded, restart := config.Reload(s.settings().Config, cfg)
+	s.current.Store(newSettings(reloaded))
+	return restart
 }
 
 type Chat = db.Chat
 type Message = db.Message
 type ToolCall = db.ToolCall
 
+const (
+	MessageFlagSensitive = db.MessageFlagSensitive
+	MessageFlagHidden    = db.MessageFlagHidden
+)
+
 type AIMessage = ai.Message
 type StreamCallbacks = ai.StreamCallbacks
+type StreamOptions = ai.StreamOptions
+type GenerationParams = ai.GenerationParams
+type ChatParams = db.ChatParams
 type StreamResult = ai.StreamResult
 type ToolCallUpdate = ai.ToolCallUpdate
+type UsageProgress = ai.UsageProgress
+type JSONSchema = ai.JSONSchema
 
 type PendingRun struct {
 	RunID              string
@@ -35,39 +113,231 @@ type PendingRun struct {
 	UserMessageID      string
 	AssistantMessageID string
 	Model              string
+	// ReuseUserMessage retries against an already persisted user message
+	// instead of inserting a new one.
+	ReuseUserMessage bool
+	// Locale selects a locale-specific system prompt; see ResolveLocale.
+	Locale string
+	// ComparisonID ties the two runs of a side-by-side comparison together;
+	// both carry the first run's ID.
+	ComparisonID string
+	// OutputSchema asks for a reply that is a JSON object matching it.
+	OutputSchema *JSONSchema
+	// IdempotencyKey is the key the client sent the message with. A second
+	// run of the chat with the same key is refused with a RunExistsError
+	// before anything is stored, so a resent message is not saved twice.
+	IdempotencyKey string
+	// RunTimeout bounds the run in place of the configured run timeout
+	// when > 0, as for a retry of a timed-out run.
+	RunTimeout time.Duration
 }
 
 func NewService(store *db.Store, runner *ai.Runner, cfg config.Config) *Service {
-	return &Service{store: store, runner: runner, cfg: cfg}
+	knowledge := rag.New(store, rag.NewEmbedder(cfg.RAGEmbedder, cfg.RAGEmbeddingModel), rag.Config{
+		ChunkBytes:   cfg.RAGChunkBytes,
+		ChunkOverlap: cfg.RAGChunkOverlap,
+		TopK:         cfg.RAGTopK,
+		MaxBytes:     cfg.RAGMaxBytes,
+	})
+	service := &Service{store: store, runner: runner, knowledge: knowledge, runs: newRunRegistry(), metrics: metrics.NewRuns(), events: events.NewBus(eventHistory), originals: newOriginals(), queue: newRunQueue(), live: newLiveRuns()}
+	if cfg.AnalyticsEnabled {
+		service.analytics = analytics.New(store)
+	}
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom != "" {
+		// An invalid address leaves email off here; the server refuses to
+		// start with one.
+		if mailer, err := mail.NewSMTP(mail.Config{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.SMTPFrom}); err == nil {
+			service.mailer = mailer
+		}
+	}
+	service.attachments, service.exports = newBlobStores(cfg)
+	service.current.Store(newSettings(cfg))
+	return service
 }
 
+// newBlobStores opens where attachments and data exports are kept: the
+// configured directories, or the S3 bucket.
+func newBlobStores(cfg config.Config) (attachments, exports blob.Store) {
+	if cfg.BlobStore == "s3" {
+		// Invalid S3 settings fall back to local disk here; the server
+		// refuses to start with them.
+		bucket, err := blob.NewS3(blob.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			Prefix:          cfg.S3Prefix,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+		if err == nil {
+			return bucket.Sub("attachments/"), bucket.Sub("exports/")
+		}
+	}
+	if cfg.AttachmentsDir != "" {
+		attachments = blob.NewDisk(cfg.AttachmentsDir)
+	}
+	return attachments, blob.NewDisk(cfg.DataExportDir)
+}
+
+// DefaultModel returns the configured default model, or the first usable
+// model when the default's provider has no API key or it is not offered.
 func (s *Service) DefaultModel() string {
-	return s.cfg.DefaultModel
+	cfg := s.settings()
+	if s.runner == nil || (s.runner.ProviderConfigured(cfg.DefaultModel) && offered(cfg.Models, cfg.DefaultModel)) {
+		return cfg.DefaultModel
+	}
+	if models := s.AllowedModels(); len(models) > 0 {
+		return models[0]
+	}
+	return cfg.DefaultModel
 }
 
+// AllowedModels returns the models that can be sent right now, limited to
+// the configured Models when it is set.
 func (s *Service) AllowedModels() []string {
-	return ai.AllowedModels
+	models := ai.AllowedModels
+	if s.runner != nil {
+		models = s.runner.Models()
+	}
+	limit := s.settings().Models
+	if len(limit) == 0 {
+		return models
+	}
+	allowed := make([]string, 0, len(models))
+	for _, model := range models {
+		if offered(limit, model) {
+			allowed = append(allowed, model)
+		}
+	}
+	return allowed
+}
+
+// offered reports whether the Models setting limit lets model be offered.
+func offered(limit []string, model string) bool {
+	return len(limit) == 0 || slices.Contains(limit, model)
+}
+
+// SetupStatus describes missing provider configuration for the setup
+// banner. NeedsSetup is set when no real provider is usable.
+type SetupStatus struct {
+	NeedsSetup  bool
+	MissingKeys []string
+	MockModel   string
+}
+
+func (s *Service) SetupStatus() SetupStatus {
+	if s.runner == nil {
+		return SetupStatus{}
+	}
+	status := SetupStatus{NeedsSetup: true, MissingKeys: s.runner.MissingProviderKeys()}
+	for _, model := range s.runner.Models() {
+		if model == ai.MockModel {
+			status.MockModel = model
+		} else {
+			status.NeedsSetup = false
+		}
+	}
+	return status
+}
+
+func (s *Service) ReasoningEfforts() []string {
+	return ai.ReasoningEfforts
 }
 
 func (s *Service) IsAllowedModel(model string) bool {
-	return ai.IsAllowedModel(model)
+	if !offered(s.settings().Models, model) {
+		return false
+	}
+	if s.runner == nil {
+		return ai.IsAllowedModel(model)
+	}
+	return s.runner.IsAllowedModel(model)
 }
 
-func (s *Service) ListOrCreateChats(ctx context.Context, limit int) ([]Chat, error) {
-	chatList, err := s.store.ListChats(ctx, limit)
-	if err != nil {
-		return nil, err
+// ownerOf returns the owner id recorded for chats created by principal. The
+// anonymous single user owns nothing so single-user installs keep sharing
+// every chat.
+func ownerOf(principal auth.Principal) string {
+	if principal.IsZero() || principal.UserID == auth.AnonymousUserID {
+		return ""
 	}
-	if len(chatList) > 0 {
-		return chatList, nil
+	return principal.UserID
+}
+
+// ErrInvalidCursor is returned for a chat list cursor that no page
+// returned.
+var ErrInvalidCursor = errors.New("invalid chat cursor")
+
+// ChatPage is one page of a user's chats, most recently updated first.
+// NextCursor continues the listing when HasMore is set.
+type ChatPage struct {
+	Chats      []Chat
+	NextCursor string
+	HasMore    bool
+}
+
+// ListOrCreateChats returns the first page of the principal's chats,
+// creating a chat when there are none yet.
+func (s *Service) ListOrCreateChats(ctx context.Context, principal auth.Principal, limit int) (ChatPage, error) {
+	page, err := s.ListChats(ctx, principal, "", limit)
+	if err != nil || len(page.Chats) > 0 {
+		return page, err
 	}
 	newChatID := uuid.NewString()
 	now := time.Now().UTC()
-	created, err := s.store.CreateChat(ctx, newChatID, "New chat", s.cfg.DefaultModel, now)
+	created, err := s.store.CreateOwnedChat(ctx, newChatID, "New chat", s.DefaultModel(), ownerOf(principal), now)
 	if err != nil {
-		return nil, err
+		return ChatPage{}, err
 	}
-	return []Chat{created}, nil
+	return ChatPage{Chats: []Chat{created}}, nil
+}
+
+// ListChats returns up to limit of the principal's chats after cursor, the
+// NextCursor of a previous page, or from the start when cursor is empty.
+// Paging by the last chat's update time and ID keeps pages from
+// overlapping when chats are updated between loads.
+func (s *Service) ListChats(ctx context.Context, principal auth.Principal, cursor string, limit int) (ChatPage, error) {
+	if limit < 1 {
+		limit = 50
+	}
+	after, err := decodeChatCursor(cursor)
+	if err != nil {
+		return ChatPage{}, err
+	}
+	// One extra row tells whether another page follows.
+	chatList, err := s.store.ListChats(ctx, ownerOf(principal), principal.HasRole("admin"), after, limit+1)
+	if err != nil {
+		return ChatPage{}, err
+	}
+	page := ChatPage{Chats: chatList}
+	if len(chatList) > limit {
+		page.Chats = chatList[:limit]
+		page.HasMore = true
+		page.NextCursor = encodeChatCursor(db.CursorAfter(page.Chats[limit-1]))
+	}
+	return page, nil
+}
+
+func encodeChatCursor(cursor db.ChatCursor) string {
+	raw := cursor.UpdatedAt.UTC().Format(time.RFC3339Nano) + "|" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChatCursor(cursor string) (db.ChatCursor, error) {
+	if cursor == "" {
+		return db.ChatCursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return db.ChatCursor{}, ErrInvalidCursor
+	}
+	updated, id, ok := strings.Cut(string(raw), "|")
+	updatedAt, err := time.Parse(time.RFC3339Nano, updated)
+	if !ok || id == "" || err != nil {
+		return db.ChatCursor{}, ErrInvalidCursor
+	}
+	return db.ChatCursor{UpdatedAt: updatedAt, ID: id}, nil
 }
 
 func (s *Service) ListMessages(ctx context.Context, chatID string, limit int) ([]Message, error) {
@@ -77,12 +347,190 @@ func (s *Service) ListMessages(ctx context.Context, chatID string, limit int) ([
 	return s.store.ListMessages(ctx, chatID, limit)
 }
 
-func (s *Service) CreateChat(ctx context.Context, model string) (Chat, error) {
-	if !ai.IsAllowedModel(model) {
-		model = s.cfg.DefaultModel
+// ChatMessages returns up to limit messages of a chat the principal may use,
+// in conversation order.
+func (s *Service) ChatMessages(ctx context.Context, principal auth.Principal, chatID string, limit int) ([]Message, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.ListMessages(ctx, chat.ID, limit)
+}
+
+// ListShareableMessages returns the messages of a chat that may leave the
+// owner's view, dropping sensitive and hidden ones. Exports and shared views
+// must read through it.
+func (s *Service) ListShareableMessages(ctx context.Context, chatID string, limit int) ([]Message, error) {
+	rows, err := s.ListMessages(ctx, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	shareable := make([]Message, 0, len(rows))
+	for _, row := range rows {
+		if row.Shareable() {
+			shareable = append(shareable, row)
+		}
+	}
+	return shareable, nil
+}
+
+// FlagMessage sets or clears (empty flag) the content flag of a message.
+func (s *Service) FlagMessage(ctx context.Context, messageID, flag string) error {
+	trimmedMessageID := strings.TrimSpace(messageID)
+	if trimmedMessageID == "" {
+		return errors.New("message id is required")
+	}
+	switch flag {
+	case "", db.MessageFlagSensitive, db.MessageFlagHidden:
+	default:
+		return fmt.Errorf("unknown message flag %q", flag)
+	}
+	return s.store.SetMessageFlag(ctx, trimmedMessageID, flag, time.Now().UTC())
+}
+
+// ChatTool is a registry tool with its enablement in one chat.
+type ChatTool struct {
+	Name        string
+	Description string
+	Enabled     bool
+}
+
+func (s *Service) toolRegistry() *ai.ToolRegistry {
+	if s.runner == nil {
+		return ai.NewToolRegistry()
+	}
+	return s.runner.Tools()
+}
+
+// ChatTools lists every available tool and whether the chat has it enabled.
+func (s *Service) ChatTools(ctx context.Context, chatID string) ([]ChatTool, error) {
+	settings, err := s.store.ChatToolSettings(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	registered := s.toolRegistry().Tools()
+	tools := make([]ChatTool, 0, len(registered))
+	for _, tool := range registered {
+		enabled, ok := settings[tool.Name]
+		tools = append(tools, ChatTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Enabled:     !ok || enabled,
+		})
+	}
+	return tools, nil
+}
+
+func (s *Service) SetChatToolEnabled(ctx context.Context, chatID, toolName string, enabled bool) error {
+	trimmedChatID := strings.TrimSpace(chatID)
+	if trimmedChatID == "" {
+		return errors.New("chat id is required")
+	}
+	if _, ok := s.toolRegistry().Lookup(toolName); !ok {
+		return fmt.Errorf("unknown tool %q", toolName)
+	}
+	return s.store.SetChatTool(ctx, trimmedChatID, toolName, enabled, time.Now().UTC())
+}
+
+// DisabledTools returns the tools switched off for a chat, for
+// StreamOptions.DisabledTools.
+func (s *Service) DisabledTools(ctx context.Context, chatID string) ([]string, error) {
+	settings, err := s.store.ChatToolSettings(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	disabled := make([]string, 0, len(settings))
+	for name, enabled := range settings {
+		if !enabled {
+			disabled = append(disabled, name)
+		}
+	}
+	return disabled, nil
+}
+
+// SearchChat finds messages in a single chat containing query. It searches the
+// store rather than the loaded page so matches in unloaded history are found.
+func (s *Service) SearchChat(ctx context.Context, chatID, query string) ([]Message, error) {
+	trimmedQuery := strings.TrimSpace(query)
+	if chatID == "" || trimmedQuery == "" {
+		return nil, nil
+	}
+	if len(trimmedQuery) > 200 {
+		return nil, errors.New("search query is too long")
+	}
+	return s.store.SearchMessages(ctx, chatID, trimmedQuery, 200)
+}
+
+func (s *Service) CreateChat(ctx context.Context, principal auth.Principal, model string) (Chat, error) {
+	if !slices.Contains(s.AllowedModels(), model) {
+		model = s.DefaultModel()
 	}
 	now := time.Now().UTC()
-	return s.store.CreateChat(ctx, uuid.NewString(), "New chat", model, now)
+	chat, err := s.store.CreateOwnedChat(ctx, uuid.NewString(), "New chat", model, ownerOf(principal), now)
+	if err != nil {
+		return Chat{}, err
+	}
+	s.publishChatCreated(ctx, chat)
+	return chat, nil
+}
+
+// TransferChat hands a chat, with its runs and messages, to another user and
+// records the transfer in the audit log. Only the current owner or an admin
+// may transfer an owned chat. Chats from before ownership have no owner;
+// only an admin may hand one out, audited as a claim rather than a
+// transfer. There are no workspaces yet, so chats only move between users.
+func (s *Service) TransferChat(ctx context.Context, actor auth.Principal, chatID, toUserID string) error {
+	trimmedChatID := strings.TrimSpace(chatID)
+	if trimmedChatID == "" {
+		return errors.New("chat id is required")
+	}
+	target := strings.TrimSpace(toUserID)
+	if target == "" {
+		return errors.New("target user is required")
+	}
+	if len(target) > 200 {
+		return errors.New("target user is too long")
+	}
+	if ownerOf(actor) == "" {
+		return errors.New("chat transfer requires multi-user mode")
+	}
+	if target == auth.AnonymousUserID {
+		return errors.New("cannot transfer a chat to the anonymous user")
+	}
+
+	chat, err := s.store.GetChat(ctx, trimmedChatID)
+	if err != nil {
+		return err
+	}
+	action := "chat.transfer"
+	switch {
+	case !chat.OwnerID.Valid:
+		if !actor.HasRole("admin") {
+			return ErrChatForbidden
+		}
+		action = "chat.claim"
+	case chat.OwnerID.String != actor.UserID && !actor.HasRole("admin"):
+		return ErrChatForbidden
+	case chat.OwnerID.String == target:
+		return nil
+	}
+
+	detail, err := json.Marshal(map[string]string{
+		"from": chat.OwnerID.String,
+		"to":   target,
+	})
+	if err != nil {
+		return err
+	}
+	return s.store.TransferChat(ctx, chat.ID, target, db.AuditEntry{
+		ID:         uuid.NewString(),
+		ActorID:    actor.UserID,
+		Action:     action,
+		TargetType: "chat",
+		TargetID:   chat.ID,
+		DetailJSON: string(detail),
+		CreatedAt:  time.Now().UTC(),
+	})
 }
 
 func (s *Service) RenameChat(ctx context.Context, chatID, title string) error {
@@ -100,29 +548,150 @@ func (s *Service) RenameChat(ctx context.Context, chatID, title string) error {
 	return s.store.RenameChat(ctx, trimmedChatID, trimmedTitle, time.Now().UTC())
 }
 
+// ChatParamsInput is the text form of ChatParams as entered by a user.
+type ChatParamsInput struct {
+	Temperature     string
+	MaxTokens       string
+	TopP            string
+	ReasoningEffort string
+}
+
+// ParseChatParams validates generation parameters entered as text. Blank
+// fields reset the parameter to the provider default.
+func ParseChatParams(input ChatParamsInput) (ChatParams, error) {
+	var params ChatParams
+	if value := strings.TrimSpace(input.Temperature); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 2 {
+			return ChatParams{}, errors.New("temperature must be a number between 0 and 2")
+		}
+		params.Temperature = sql.NullFloat64{Float64: parsed, Valid: true}
+	}
+	if value := strings.TrimSpace(input.MaxTokens); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > 200000 {
+			return ChatParams{}, errors.New("max tokens must be a whole number between 1 and 200000")
+		}
+		params.MaxTokens = sql.NullInt64{Int64: parsed, Valid: true}
+	}
+	if value := strings.TrimSpace(input.TopP); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			return ChatParams{}, errors.New("top_p must be a number greater than 0 and at most 1")
+		}
+		params.TopP = sql.NullFloat64{Float64: parsed, Valid: true}
+	}
+	if value := strings.ToLower(strings.TrimSpace(input.ReasoningEffort)); value != "" {
+		if !ai.IsReasoningEffort(value) {
+			return ChatParams{}, errors.New("reasoning effort must be low, medium or high")
+		}
+		params.ReasoningEffort = sql.NullString{String: value, Valid: true}
+	}
+	return params, nil
+}
+
+func (s *Service) UpdateChatParams(ctx context.Context, chatID string, params ChatParams) error {
+	trimmedChatID := strings.TrimSpace(chatID)
+	if trimmedChatID == "" {
+		return errors.New("chat id is required")
+	}
+	return s.store.UpdateChatParams(ctx, trimmedChatID, params, time.Now().UTC())
+}
+
+// GenerationParams loads the chat's stored parameters for a run request.
+func (s *Service) GenerationParams(ctx context.Context, chatID string) (GenerationParams, error) {
+	chat, err := s.store.GetChat(ctx, chatID)
+	if err != nil {
+		return GenerationParams{}, err
+	}
+	return generationParamsFor(chat), nil
+}
+
+func generationParamsFor(chat Chat) GenerationParams {
+	var params GenerationParams
+	if chat.Temperature.Valid {
+		temperature := chat.Temperature.Float64
+		params.Temperature = &temperature
+	}
+	if chat.TopP.Valid {
+		topP := chat.TopP.Float64
+		params.TopP = &topP
+	}
+	if chat.MaxTokens.Valid {
+		params.MaxTokens = int(chat.MaxTokens.Int64)
+	}
+	if chat.ReasoningEffort.Valid {
+		params.ReasoningEffort = chat.ReasoningEffort.String
+	}
+	return params
+}
+
 func (s *Service) DeleteChat(ctx context.Context, chatID string) error {
 	trimmedChatID := strings.TrimSpace(chatID)
 	if trimmedChatID == "" {
 		return errors.New("chat id is required")
 	}
-	return s.store.DeleteChat(ctx, trimmedChatID)
+	storagePaths, err := s.store.ListChatStoragePaths(ctx, trimmedChatID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.DeleteChat(ctx, trimmedChatID); err != nil {
+		return err
+	}
+	s.removeAttachments(ctx, storagePaths...)
+	s.events.Publish(events.Event{Type: events.ChatDeleted, ChatID: trimmedChatID})
+	return nil
 }
 
 func (s *Service) PersistRunStart(ctx context.Context, run PendingRun, userMessageContent string) error {
+	if err := s.checkChatSpend(ctx, run.ChatID); err != nil {
+		return err
+	}
+	decision, err := s.moderate(ctx, run, userMessageContent)
+	if err != nil {
+		return err
+	}
+	userFlag := ""
+	if decision.Action == moderation.Flag {
+		userFlag = MessageFlagSensitive
+	}
 	now := time.Now().UTC()
-	err := s.store.Transaction(ctx, func(tx *sql.Tx) error {
-		if txErr := db.InsertMessageTx(ctx, tx, db.Message{
-			ID:        run.UserMessageID,
-			ChatID:    run.ChatID,
-			Role:      "user",
-			Content:   userMessageContent,
-			Status:    "complete",
-			CreatedAt: now,
-			UpdatedAt: now,
-		}); txErr != nil {
-			return txErr
+	if !run.ReuseUserMessage && s.RedactionEnabled() {
+		if redacted := s.redact(ctx, userMessageContent); redacted != userMessageContent {
+			s.originals.put(run.UserMessageID, redacted, userMessageContent, now)
+			userMessageContent = redacted
+		}
+	}
+	err = s.store.Transaction(ctx, func(tx *sql.Tx) error {
+		// Writes are serialized, so a key found missing here stays missing
+		// until this run is stored with it.
+		if run.IdempotencyKey != "" {
+			existingID, txErr := db.RunIDByIdempotencyKeyTx(ctx, tx, run.ChatID, run.IdempotencyKey)
+			if txErr == nil {
+				return &RunExistsError{RunID: existingID}
+			}
+			if !errors.Is(txErr, db.ErrNotFound) {
+				return txErr
+			}
 		}
-		if txErr := db.InsertMessageTx(ctx, tx, db.Message{
+		if !run.ReuseUserMessage {
+			if txErr := s.store.InsertMessageTx(ctx, tx, db.Message{
+				ID:        run.UserMessageID,
+				ChatID:    run.ChatID,
+				Role:      "user",
+				Content:   userMessageContent,
+				Status:    "complete",
+				Flag:      userFlag,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}); txErr != nil {
+				return txErr
+			}
+			if txErr := db.ClaimAttachmentsTx(ctx, tx, run.ChatID, run.UserMessageID); txErr != nil {
+				return txErr
+			}
+		}
+		if txErr := s.store.InsertMessageTx(ctx, tx, db.Message{
 			ID:        run.AssistantMessageID,
 			ChatID:    run.ChatID,
 			Role:      "assistant",
@@ -133,6 +702,9 @@ func (s *Service) PersistRunStart(ctx context.Context, run PendingRun, userMessa
 		}); txErr != nil {
 			return txErr
 		}
+		if txErr := db.DeleteMessageSourcesTx(ctx, tx, run.AssistantMessageID); txErr != nil {
+			return txErr
+		}
 		if txErr := db.UpsertRunStartTx(ctx, tx, db.Run{
 			ID:                 run.RunID,
 			ChatID:             run.ChatID,
@@ -140,6 +712,9 @@ func (s *Service) PersistRunStart(ctx context.Context, run PendingRun, userMessa
 			AssistantMessageID: run.AssistantMessageID,
 			Model:              run.Model,
 			Status:             "running",
+			ComparisonID:       run.ComparisonID,
+			ModerationJSON:     moderationJSON(decision),
+			IdempotencyKey:     run.IdempotencyKey,
 			StartedAt:          now,
 		}); txErr != nil {
 			return txErr
@@ -152,16 +727,52 @@ func (s *Service) PersistRunStart(ctx context.Context, run PendingRun, userMessa
 	if err != nil {
 		return err
 	}
+	s.events.Publish(events.Event{Type: events.RunStarted, ChatID: run.ChatID, RunID: run.RunID, Data: map[string]any{"model": run.Model}})
+	s.recordModelSwitch(ctx, run)
 	return s.store.UpdateChatModel(ctx, run.ChatID, run.Model, now)
 }
 
-func (s *Service) BuildHistory(ctx context.Context, chatID string) ([]AIMessage, error) {
-	rows, err := s.store.ListMessages(ctx, chatID, 800)
+// BuildHistory assembles the model input for the chat: the system prompt for
+// locale, a summary of older messages, the most recent messages with their
+// attachments that fit the chat model's context window, and any knowledge
+// base excerpts. It returns an ErrContextOverflow error when the latest
+// message does not fit even on its own.
+func (s *Service) BuildHistory(ctx context.Context, chatID, locale string) ([]AIMessage, error) {
+	history, usage, err := s.buildHistory(ctx, chatID, "", locale, "")
 	if err != nil {
 		return nil, err
 	}
-	history := make([]AIMessage, 0, s.cfg.MaxHistory+1)
-	history = append(history, AIMessage{Role: "system", Content: s.cfg.SystemPrompt})
+	if err := usage.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// buildHistory is BuildHistory for model, the chat's own when empty, with
+// draft, when set, as an unsent user message at the end, carrying the chat's
+// pending attachments. It also returns the history's estimated context usage
+// and leaves refusing a request that does not fit to the caller.
+func (s *Service) buildHistory(ctx context.Context, chatID, model, locale, draft string) ([]AIMessage, ContextUsage, error) {
+	chat, err := s.store.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, ContextUsage{}, err
+	}
+	if model != "" {
+		chat.Model = model
+	}
+	rows, err := s.store.ListMessages(ctx, chatID, 800)
+	if err != nil {
+		return nil, ContextUsage{}, err
+	}
+	alternates, err := s.comparisonAlternates(ctx, chatID)
+	if err != nil {
+		return nil, ContextUsage{}, err
+	}
+	maxHistory := s.settings().MaxHistory
+	history := make([]AIMessage, 0, maxHistory+1)
+	history = append(history, AIMessage{Role: "system", Content: s.systemPrompt(locale)})
+	messageIDs := make([]string, 0, maxHistory+1)
+	messageIDs = append(messageIDs, "")
 	for _, row := range rows {
 		if row.Role != "user" && row.Role != "assistant" {
 			continue
@@ -169,27 +780,141 @@ func (s *Service) BuildHistory(ctx context.Context, chatID string) ([]AIMessage,
 		if row.Role == "assistant" && strings.TrimSpace(row.Content) == "" {
 			continue
 		}
+		if row.Role == "assistant" && (row.Status == "timed_out" || row.Status == "interrupted") {
+			continue
+		}
+		// A reply still streaming is the other side of a comparison started
+		// alongside this run, and the second model's answers are left out
+		// so the chat carries on from the first model's.
+		if row.Role == "assistant" && (row.Status == "streaming" || alternates[row.ID]) {
+			continue
+		}
 		history = append(history, AIMessage{Role: row.Role, Content: row.Content})
+		messageIDs = append(messageIDs, row.ID)
+	}
+	if draft != "" {
+		history = append(history, AIMessage{Role: "user", Content: draft})
+		messageIDs = append(messageIDs, draftMessageID)
+	}
+	var dropped []droppedMessage
+	if len(history) > maxHistory+1 {
+		start := len(history) - maxHistory
+		for i := 1; i < start; i++ {
+			dropped = append(dropped, droppedMessage{ID: messageIDs[i], Role: history[i].Role, Content: history[i].Content})
+		}
+		history = append(history[:1], history[start:]...)
+		messageIDs = append(messageIDs[:1], messageIDs[start:]...)
+	}
+	images, documents, err := s.historyAttachments(ctx, chatID, messageIDs[1:], draft != "")
+	if err != nil {
+		return nil, ContextUsage{}, err
+	}
+	for i, id := range messageIDs {
+		history[i].Images = images[id]
+	}
+	s.injectDocuments(history, messageIDs, documents)
+	history, dropped = s.fitContextWindow(chat, history, messageIDs, dropped)
+	if summary := s.summarizeDropped(ctx, chatID, dropped); summary != "" {
+		history = slices.Insert(history, 1, AIMessage{
+			Role:    "system",
+			Content: "Summary of the earlier part of this conversation, which is no longer shown in full:\n" + summary,
+		})
+	}
+	s.injectKnowledge(ctx, chatID, history)
+	return history, s.contextUsage(chat, history), nil
+}
+
+// Stream runs the model once the run queue has a stream slot for it,
+// telling callbacks.OnQueued while it waits, and records how long the
+// provider takes to produce its first output in the run metrics.
+func (s *Service) Stream(ctx context.Context, model string, history []AIMessage, options StreamOptions, callbacks StreamCallbacks) (StreamResult, error) {
+	cfg := s.settings()
+	limits := streamLimits{streams: cfg.MaxConcurrentStreams, perUser: cfg.MaxStreamsPerUser, queued: cfg.MaxQueuedRuns}
+	release, err := s.queue.acquire(ctx, options.UserID, limits, callbacks.OnQueued)
+	if err != nil {
+		return StreamResult{}, err
+	}
+	defer release()
+	answered := s.metrics.WaitStarted()
+	defer answered(false)
+	wrapped := callbacks
+	wrapped.OnTextDelta = func(delta string) {
+		answered(true)
+		if callbacks.OnTextDelta != nil {
+			callbacks.OnTextDelta(delta)
+		}
+	}
+	wrapped.OnThinkingDelta = func(delta string) {
+		answered(true)
+		if callbacks.OnThinkingDelta != nil {
+			callbacks.OnThinkingDelta(delta)
+		}
 	}
-	if len(history) <= s.cfg.MaxHistory+1 {
-		return history, nil
+	wrapped.OnToolStart = func(update ToolCallUpdate) {
+		answered(true)
+		if callbacks.OnToolStart != nil {
+			callbacks.OnToolStart(update)
+		}
 	}
-	trimmed := make([]AIMessage, 0, s.cfg.MaxHistory+1)
-	trimmed = append(trimmed, history[0])
-	trimmed = append(trimmed, history[len(history)-s.cfg.MaxHistory:]...)
-	return trimmed, nil
+	return s.runner.Stream(ctx, model, history, options, wrapped)
 }
 
-func (s *Service) Stream(ctx context.Context, model string, history []AIMessage, callbacks StreamCallbacks) (StreamResult, error) {
-	return s.runner.Stream(ctx, model, history, callbacks)
+// RunMetrics returns the run concurrency metrics of this process.
+func (s *Service) RunMetrics() *metrics.Runs {
+	return s.metrics
 }
 
-func (s *Service) UpdateAssistantPartial(ctx context.Context, assistantMessageID, content string) error {
-	return s.store.UpdateMessageContent(ctx, assistantMessageID, content, "streaming", time.Now().UTC())
+// RunTimeout returns the default wall-clock budget for a run.
+func (s *Service) RunTimeout() time.Duration {
+	return s.settings().RunTimeout
 }
 
-func (s *Service) CompleteAssistant(ctx context.Context, assistantMessageID, content, status string) error {
-	return s.store.UpdateMessageContent(ctx, assistantMessageID, content, status, time.Now().UTC())
+// RetryRunTimeout returns the budget for retrying a run that timed out after
+// previous, doubling it up to the configured maximum.
+func (s *Service) RetryRunTimeout(previous time.Duration) time.Duration {
+	cfg := s.settings()
+	if previous <= 0 {
+		previous = cfg.RunTimeout
+	}
+	next := previous * 2
+	if cfg.MaxRunTimeout > 0 && next > cfg.MaxRunTimeout {
+		next = cfg.MaxRunTimeout
+	}
+	return next
+}
+
+// UpdateAssistantReasoning saves a reply's reasoning now, replacing any
+// buffered by UpdateAssistantPartialReasoning.
+func (s *Service) UpdateAssistantReasoning(ctx context.Context, assistantMessageID, reasoning string) error {
+	s.partials.mu.Lock()
+	if partial, ok := s.partials.pending[assistantMessageID]; ok {
+		partial.Reasoning = sql.NullString{}
+		s.partials.pending[assistantMessageID] = partial
+	}
+	s.partials.mu.Unlock()
+	return s.store.UpdateMessageReasoning(ctx, assistantMessageID, reasoning, time.Now().UTC())
+}
+
+// CompleteAssistant saves the final content and status of a reply and
+// returns the content as saved. Completed replies go through the configured
+// output processors first. Buffered partial content is dropped; buffered
+// reasoning is saved after the status, so a partial flush already under
+// way cannot overwrite it.
+func (s *Service) CompleteAssistant(ctx context.Context, assistantMessageID, content, status string) (string, error) {
+	if status == "completed" {
+		content = s.settings().output.Apply(content)
+	}
+	partial, _ := s.takePartial(assistantMessageID)
+	now := time.Now().UTC()
+	if err := s.store.UpdateMessageContent(ctx, assistantMessageID, content, status, now); err != nil {
+		return "", err
+	}
+	if partial.Reasoning.Valid {
+		if err := s.store.UpdateMessageReasoning(ctx, assistantMessageID, partial.Reasoning.String, now); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
 }
 
 func (s *Service) UpsertToolStart(ctx context.Context, runID string, update ToolCallUpdate) (string, error) {
@@ -211,14 +936,39 @@ func (s *Service) CompleteTool(ctx context.Context, callID string, update ToolCa
 	if status == "" {
 		status = "completed"
 	}
-	return s.store.CompleteToolCall(ctx, callID, status, truncateText(update.Output, 4000), truncateText(update.ErrText, 2000), time.Now().UTC())
+	now := time.Now().UTC()
+	output := update.Output
+	if s.RedactionEnabled() {
+		output = s.redact(ctx, output)
+	}
+	if err := s.store.CompleteToolCall(ctx, callID, status, truncateText(output, 4000), truncateText(update.ErrText, 2000), now); err != nil {
+		return err
+	}
+	s.publishToolExecuted(callID, update.Name, status)
+	if status != "completed" {
+		return nil
+	}
+	return s.recordToolSources(ctx, callID, output, now)
 }
 
 func (s *Service) CompleteRun(ctx context.Context, run PendingRun, status string, result StreamResult, errText string) error {
-	if err := s.store.CompleteRun(ctx, run.RunID, status, result.StopReason, errText, result.ToolCallCount, result.TurnCount, result.Usage, time.Now().UTC()); err != nil {
+	// A fallback model that answered for run.Model is priced as itself.
+	model := run.Model
+	if result.Model != "" {
+		model = result.Model
+	}
+	cost := runCost(model, result)
+	now := time.Now().UTC()
+	if err := s.store.CompleteRun(ctx, run.RunID, status, result.StopReason, errText, result.ToolCallCount, result.TurnCount, result.Usage, cost, runLatency(result.Latency), now); err != nil {
 		return err
 	}
-	return s.store.TouchChat(ctx, run.ChatID, time.Now().UTC())
+	s.observeLatency(model, status, result)
+	if err := s.recordRunUsage(ctx, run, result, cost.Float64, now); err != nil {
+		return err
+	}
+	s.publishRunFinished(ctx, run, status, result, errText, cost)
+	s.warnOnBudget(ctx, run, cost)
+	return s.store.TouchChat(ctx, run.ChatID, now)
 }
 
 func (s *Service) IsCancellation(err error, ctx context.Context) bool {
@@ -231,8 +981,13 @@ func (s *Service) IsCancellation(err error, ctx context.Context) bool {
 	return false
 }
 
+func (s *Service) IsTimeout(err error) bool {
+	return errors.Is(err, ai.ErrRunTimeout)
+}
+
 func (s *Service) FlushConfig() (time.Duration, int, time.Duration) {
-	return s.cfg.UIFlushInterval, s.cfg.UIFlushBytes, s.cfg.DBFlushInterval
+	cfg := s.settings()
+	return cfg.UIFlushInterval, cfg.UIFlushBytes, cfg.DBFlushInterval
 }
 
 func truncateText(value string, maxBytes int) string {
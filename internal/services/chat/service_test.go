@@ -1,14 +1,30 @@
 package chat
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/analytics"
+	"rhone_chat/internal/auth"
 	"rhone_chat/internal/config"
 	"rhone_chat/internal/db"
+	"rhone_chat/internal/mail"
+	"rhone_chat/internal/moderation"
 )
 
 func TestRenameChatTrimsAndPersists(t *testing.T) {
@@ -76,6 +92,2399 @@ func TestDeleteChatMissingReturnsNotFound(t *testing.T) {
 	}
 }
 
+func TestSearchChatMatchesWithinChatOnly(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	for _, chatID := range []string{"chat-1", "chat-2"} {
+		if _, err := store.CreateChat(ctx, chatID, "A chat", config.DefaultModel, now); err != nil {
+			t.Fatalf("CreateChat() error = %v", err)
+		}
+	}
+	rows := []db.Message{
+		{ID: "m1", ChatID: "chat-1", Role: "user", Content: "Where is 100% of the Rust code?", Status: "complete", CreatedAt: now, UpdatedAt: now},
+		{ID: "m2", ChatID: "chat-1", Role: "assistant", Content: "rust lives in src/", Status: "complete", CreatedAt: now.Add(time.Second), UpdatedAt: now},
+		{ID: "m3", ChatID: "chat-1", Role: "user", Content: "unrelated", Status: "complete", CreatedAt: now.Add(2 * time.Second), UpdatedAt: now},
+		{ID: "m4", ChatID: "chat-2", Role: "user", Content: "rust elsewhere", Status: "complete", CreatedAt: now, UpdatedAt: now},
+	}
+	for _, row := range rows {
+		if err := store.InsertMessage(ctx, row); err != nil {
+			t.Fatalf("InsertMessage() error = %v", err)
+		}
+	}
+
+	matches, err := service.SearchChat(ctx, "chat-1", "  RUST ")
+	if err != nil {
+		t.Fatalf("SearchChat() error = %v", err)
+	}
+	if len(matches) != 2 || matches[0].ID != "m1" || matches[1].ID != "m2" {
+		t.Fatalf("SearchChat() = %+v, want m1, m2", matches)
+	}
+
+	matches, err = service.SearchChat(ctx, "chat-1", "100%")
+	if err != nil {
+		t.Fatalf("SearchChat() error = %v", err)
+	}
+	if len(matches) != 1 || matches[0].ID != "m1" {
+		t.Fatalf("SearchChat(100%%) = %+v, want m1", matches)
+	}
+}
+
+func TestUpdateChatParamsRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	params, err := ParseChatParams(ChatParamsInput{Temperature: " 0.4 ", MaxTokens: "1024", ReasoningEffort: "High"})
+	if err != nil {
+		t.Fatalf("ParseChatParams() error = %v", err)
+	}
+	if err := service.UpdateChatParams(ctx, "chat-1", params); err != nil {
+		t.Fatalf("UpdateChatParams() error = %v", err)
+	}
+
+	got, err := service.GenerationParams(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("GenerationParams() error = %v", err)
+	}
+	if got.Temperature == nil || *got.Temperature != 0.4 {
+		t.Fatalf("Temperature = %v, want 0.4", got.Temperature)
+	}
+	if got.MaxTokens != 1024 {
+		t.Fatalf("MaxTokens = %d, want 1024", got.MaxTokens)
+	}
+	if got.TopP != nil {
+		t.Fatalf("TopP = %v, want nil", *got.TopP)
+	}
+	if got.ReasoningEffort != "high" {
+		t.Fatalf("ReasoningEffort = %q, want high", got.ReasoningEffort)
+	}
+}
+
+func TestParseChatParamsRejectsOutOfRange(t *testing.T) {
+	cases := []ChatParamsInput{
+		{Temperature: "2.5"},
+		{MaxTokens: "0"},
+		{TopP: "1.5"},
+		{Temperature: "warm"},
+		{ReasoningEffort: "extreme"},
+	}
+	for _, tc := range cases {
+		if _, err := ParseChatParams(tc); err == nil {
+			t.Fatalf("ParseChatParams(%+v) expected error", tc)
+		}
+	}
+}
+
+func TestRetryRunTimeoutDoublesUpToMax(t *testing.T) {
+	service := NewService(nil, nil, config.Config{
+		RunTimeout:    90 * time.Second,
+		MaxRunTimeout: 240 * time.Second,
+	})
+
+	if got := service.RetryRunTimeout(0); got != 180*time.Second {
+		t.Fatalf("RetryRunTimeout(0) = %s, want 3m0s", got)
+	}
+	if got := service.RetryRunTimeout(180 * time.Second); got != 240*time.Second {
+		t.Fatalf("RetryRunTimeout(3m) = %s, want 4m0s", got)
+	}
+}
+
+func TestTransferChatMovesOwnershipAndAudits(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	bob := auth.Principal{UserID: "bob"}
+
+	created, err := service.CreateChat(ctx, alice, config.DefaultModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if err := service.TransferChat(ctx, bob, created.ID, "bob"); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("TransferChat() by non-owner error = %v, want ErrChatForbidden", err)
+	}
+	if err := service.TransferChat(ctx, alice, created.ID, " bob "); err != nil {
+		t.Fatalf("TransferChat() error = %v", err)
+	}
+
+	bobChats, err := store.ListChats(ctx, "bob", false, db.ChatCursor{}, 10)
+	if err != nil {
+		t.Fatalf("ListChats() error = %v", err)
+	}
+	if len(bobChats) != 1 || bobChats[0].ID != created.ID {
+		t.Fatalf("bob chats = %+v, want transferred chat", bobChats)
+	}
+	aliceChats, err := store.ListChats(ctx, "alice", false, db.ChatCursor{}, 10)
+	if err != nil {
+		t.Fatalf("ListChats() error = %v", err)
+	}
+	if len(aliceChats) != 0 {
+		t.Fatalf("alice chats = %+v, want none", aliceChats)
+	}
+
+	entries, err := store.ListAuditEntries(ctx, "chat", created.ID, 10)
+	if err != nil {
+		t.Fatalf("ListAuditEntries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].ActorID != "alice" || entries[0].Action != "chat.transfer" {
+		t.Fatalf("audit entries = %+v, want one transfer by alice", entries)
+	}
+}
+
+func TestTransferChatRequiresMultiUserMode(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	anonymous := auth.Principal{UserID: auth.AnonymousUserID}
+
+	created, err := service.CreateChat(context.Background(), anonymous, config.DefaultModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if created.OwnerID.Valid {
+		t.Fatalf("anonymous chat OwnerID = %q, want null", created.OwnerID.String)
+	}
+	if err := service.TransferChat(context.Background(), anonymous, created.ID, "bob"); err == nil {
+		t.Fatalf("TransferChat() expected error in single-user mode")
+	}
+}
+
+func TestUnownedChatsAreAdminOnlyOnceUsersSignIn(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	bob := auth.Principal{UserID: "bob"}
+
+	if _, err := store.CreateChat(ctx, "legacy", "Before ownership", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.CreateChat(ctx, alice, config.DefaultModel); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	for _, user := range []auth.Principal{alice, bob} {
+		page, err := service.ListChats(ctx, user, "", 10)
+		if err != nil {
+			t.Fatalf("ListChats(%s) error = %v", user.UserID, err)
+		}
+		for _, chat := range page.Chats {
+			if chat.ID == "legacy" {
+				t.Fatalf("ListChats(%s) lists the unowned chat", user.UserID)
+			}
+		}
+		if _, err := service.Draft(ctx, user, "legacy"); !errors.Is(err, ErrChatForbidden) {
+			t.Fatalf("Draft(%s) error = %v, want ErrChatForbidden", user.UserID, err)
+		}
+		if err := service.SaveDraft(ctx, user, "legacy", "Hello"); !errors.Is(err, ErrChatForbidden) {
+			t.Fatalf("SaveDraft(%s) error = %v, want ErrChatForbidden", user.UserID, err)
+		}
+	}
+
+	admin := auth.Principal{UserID: "root", Roles: []string{"admin"}}
+	page, err := service.ListChats(ctx, admin, "", 10)
+	if err != nil || len(page.Chats) != 1 || page.Chats[0].ID != "legacy" {
+		t.Fatalf("ListChats(admin) = %+v, %v; want the unowned chat", page.Chats, err)
+	}
+	if _, err := service.Draft(ctx, admin, "legacy"); err != nil {
+		t.Fatalf("Draft(admin) error = %v", err)
+	}
+	if _, err := service.Draft(ctx, auth.Principal{UserID: auth.AnonymousUserID}, "legacy"); err != nil {
+		t.Fatalf("Draft(anonymous) error = %v; the single user owns unowned chats", err)
+	}
+}
+
+func TestTransferChatLetsOnlyAdminsClaimUnownedChats(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+
+	if _, err := store.CreateChat(ctx, "legacy", "Before ownership", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if err := service.TransferChat(ctx, auth.Principal{UserID: "bob"}, "legacy", "bob"); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("TransferChat() by non-admin error = %v, want ErrChatForbidden", err)
+	}
+	admin := auth.Principal{UserID: "root", Roles: []string{"admin"}}
+	if err := service.TransferChat(ctx, admin, "legacy", "bob"); err != nil {
+		t.Fatalf("TransferChat() by admin error = %v", err)
+	}
+	entries, err := store.ListAuditEntries(ctx, "chat", "legacy", 10)
+	if err != nil {
+		t.Fatalf("ListAuditEntries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].ActorID != "root" || entries[0].Action != "chat.claim" {
+		t.Fatalf("audit entries = %+v, want one claim by root", entries)
+	}
+}
+
+func TestUpdateAssistantReasoningPersistsSeparately(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, now); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if err := store.InsertMessage(ctx, db.Message{ID: "m1", ChatID: "chat-1", Role: "assistant", Content: "Answer", Status: "complete", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("InsertMessage() error = %v", err)
+	}
+	if err := service.UpdateAssistantReasoning(ctx, "m1", "Weighing options"); err != nil {
+		t.Fatalf("UpdateAssistantReasoning() error = %v", err)
+	}
+
+	messages, err := service.ListMessages(ctx, "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 1 || messages[0].Reasoning != "Weighing options" || messages[0].Content != "Answer" {
+		t.Fatalf("messages = %+v, want reasoning stored beside content", messages)
+	}
+
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	for _, message := range history {
+		if strings.Contains(message.Content, "Weighing options") {
+			t.Fatalf("history %+v leaks reasoning", history)
+		}
+	}
+}
+
+func TestFlagMessageExcludesFromShareableOnly(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, now); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	for index, id := range []string{"m1", "m2"} {
+		at := now.Add(time.Duration(index) * time.Second)
+		if err := store.InsertMessage(ctx, db.Message{ID: id, ChatID: "chat-1", Role: "user", Content: "text " + id, Status: "complete", CreatedAt: at, UpdatedAt: at}); err != nil {
+			t.Fatalf("InsertMessage() error = %v", err)
+		}
+	}
+	if err := service.FlagMessage(ctx, "m1", db.MessageFlagHidden); err != nil {
+		t.Fatalf("FlagMessage() error = %v", err)
+	}
+	if err := service.FlagMessage(ctx, "m2", "secret"); err == nil {
+		t.Fatalf("FlagMessage() expected error for unknown flag")
+	}
+
+	all, err := service.ListMessages(ctx, "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(all) != 2 || all[0].Flag != db.MessageFlagHidden {
+		t.Fatalf("messages = %+v, want both with m1 hidden", all)
+	}
+	shareable, err := service.ListShareableMessages(ctx, "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListShareableMessages() error = %v", err)
+	}
+	if len(shareable) != 1 || shareable[0].ID != "m2" {
+		t.Fatalf("shareable = %+v, want only m2", shareable)
+	}
+}
+
+func TestChatToolsTogglePerChat(t *testing.T) {
+	store := newTestStore(t)
+	registry := ai.NewToolRegistry()
+	for _, name := range []string{"fetch_url", "web_search"} {
+		if err := registry.Register(ai.Tool{Name: name, Handler: func(context.Context, json.RawMessage) (any, error) { return nil, nil }}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{Tools: registry}), config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, chatID := range []string{"chat-1", "chat-2"} {
+		if _, err := store.CreateChat(ctx, chatID, "A chat", config.DefaultModel, now); err != nil {
+			t.Fatalf("CreateChat() error = %v", err)
+		}
+	}
+
+	if err := service.SetChatToolEnabled(ctx, "chat-1", "web_search", false); err != nil {
+		t.Fatalf("SetChatToolEnabled() error = %v", err)
+	}
+	if err := service.SetChatToolEnabled(ctx, "chat-1", "missing", false); err == nil {
+		t.Fatalf("SetChatToolEnabled() expected error for unknown tool")
+	}
+
+	tools, err := service.ChatTools(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("ChatTools() error = %v", err)
+	}
+	if len(tools) != 2 || !tools[0].Enabled || tools[1].Enabled {
+		t.Fatalf("chat-1 tools = %+v, want fetch_url on and web_search off", tools)
+	}
+	disabled, err := service.DisabledTools(ctx, "chat-1")
+	if err != nil || len(disabled) != 1 || disabled[0] != "web_search" {
+		t.Fatalf("DisabledTools(chat-1) = %v, %v", disabled, err)
+	}
+	disabled, err = service.DisabledTools(ctx, "chat-2")
+	if err != nil || len(disabled) != 0 {
+		t.Fatalf("DisabledTools(chat-2) = %v, %v; want none", disabled, err)
+	}
+}
+
+func TestToolCatalogReportsSourceSchemaAndUsage(t *testing.T) {
+	store := newTestStore(t)
+	registry := ai.DefaultToolRegistry()
+	lookup := ai.NewTool("lookup", "Look something up.", func(context.Context, struct {
+		Query string `json:"query"`
+	}) (any, error) {
+		return nil, nil
+	})
+	lookup.Source = ai.ToolSourceMCP
+	if err := registry.Register(lookup); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{Tools: registry}), config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	err := service.PersistRunStart(ctx, PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}, "Look it up")
+	if err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	for _, status := range []string{"completed", "error"} {
+		callID, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: "call-" + status, Name: "lookup", Input: "{}"})
+		if err != nil {
+			t.Fatalf("UpsertToolStart() error = %v", err)
+		}
+		if err := service.CompleteTool(ctx, callID, ToolCallUpdate{Name: "lookup", Status: status}); err != nil {
+			t.Fatalf("CompleteTool() error = %v", err)
+		}
+	}
+	if err := service.SetChatToolEnabled(ctx, "chat-1", "lookup", false); err != nil {
+		t.Fatalf("SetChatToolEnabled() error = %v", err)
+	}
+
+	catalog, err := service.ToolCatalog(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("ToolCatalog() error = %v", err)
+	}
+	if len(catalog) != 2 || catalog[0].Name != "lookup" || catalog[1].Name != "web_search" {
+		t.Fatalf("ToolCatalog() = %+v, want lookup and web_search", catalog)
+	}
+	tool := catalog[0]
+	if tool.Source != ai.ToolSourceMCP || tool.Enabled || tool.DisabledChats != 1 {
+		t.Fatalf("lookup = %+v, want an mcp tool disabled in one chat", tool)
+	}
+	if tool.Calls != 2 || tool.Errors != 1 || tool.LastUsedAt.IsZero() {
+		t.Fatalf("lookup usage = %+v, want 2 calls with 1 error", tool)
+	}
+	if !strings.Contains(tool.InputSchema, `"query"`) {
+		t.Fatalf("lookup schema = %s, want the query property", tool.InputSchema)
+	}
+	if search := catalog[1]; search.Source != ai.ToolSourceBuiltin || !search.Native || search.InputSchema != "" || !search.Enabled || search.Calls != 0 {
+		t.Fatalf("web_search = %+v, want an unused native builtin", search)
+	}
+}
+
+func TestRecoverInterruptedRunsReconcilesOrphanedState(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:        config.DefaultModel,
+		MaxHistory:          30,
+		SystemPrompt:        "You are helpful.",
+		RecoveryKeepPartial: true,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	start := func(n int) {
+		t.Helper()
+		err := service.PersistRunStart(ctx, PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             "chat-1",
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              config.DefaultModel,
+		}, "Hello")
+		if err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+	}
+	// run-1 died mid-stream with a tool call running; run-2 died after its
+	// reply was saved but before the run was.
+	start(1)
+	if err := service.UpdateAssistantPartial(ctx, "assistant-1", "Half a repl"); err != nil {
+		t.Fatalf("UpdateAssistantPartial() error = %v", err)
+	}
+	if err := service.FlushPartials(ctx); err != nil {
+		t.Fatalf("FlushPartials() error = %v", err)
+	}
+	if _, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: "call-1", Name: "fetch_url"}); err != nil {
+		t.Fatalf("UpsertToolStart() error = %v", err)
+	}
+	start(2)
+	if _, err := service.CompleteAssistant(ctx, "assistant-2", "A full reply", "completed"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+
+	report, err := service.RecoverInterruptedRuns(ctx)
+	if err != nil {
+		t.Fatalf("RecoverInterruptedRuns() error = %v", err)
+	}
+	want := OrphanReport{RunsReconciled: 1, RunsInterrupted: 1, MessagesInterrupted: 1, ToolCallsInterrupted: 1}
+	if report != want {
+		t.Fatalf("RecoverInterruptedRuns() = %+v, want %+v", report, want)
+	}
+	if run, err := store.GetRun(ctx, "run-1"); err != nil || run.Status != "interrupted" || !run.FinishedAt.Valid {
+		t.Fatalf("run-1 = %+v, %v; want it interrupted and finished", run, err)
+	}
+	if run, err := store.GetRun(ctx, "run-2"); err != nil || run.Status != "completed" || !run.FinishedAt.Valid {
+		t.Fatalf("run-2 = %+v, %v; want the reply's completed status", run, err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-1"); err != nil || message.Status != "interrupted" || message.Content != "Half a repl" {
+		t.Fatalf("assistant-1 = %+v, %v; want interrupted with its partial content", message, err)
+	}
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	for _, message := range history {
+		if message.Content == "Half a repl" {
+			t.Fatalf("BuildHistory() = %+v, want the interrupted reply left out", history)
+		}
+	}
+	if report, err := service.RecoverInterruptedRuns(ctx); err != nil || report.Changed() {
+		t.Fatalf("second RecoverInterruptedRuns() = %+v, %v; want nothing left", report, err)
+	}
+
+	// Without RecoveryKeepPartial the cut-off text is dropped.
+	service.settings().RecoveryKeepPartial = false
+	start(3)
+	if err := service.UpdateAssistantPartial(ctx, "assistant-3", "Half again"); err != nil {
+		t.Fatalf("UpdateAssistantPartial() error = %v", err)
+	}
+	if err := service.FlushPartials(ctx); err != nil {
+		t.Fatalf("FlushPartials() error = %v", err)
+	}
+	if report, err := service.RecoverInterruptedRuns(ctx); err != nil || report.PartialsCleared != 1 {
+		t.Fatalf("RecoverInterruptedRuns() = %+v, %v; want the partial cleared", report, err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-3"); err != nil || message.Status != "interrupted" || message.Content != "" {
+		t.Fatalf("assistant-3 = %+v, %v; want interrupted and empty", message, err)
+	}
+}
+
+func TestPartialRepliesAreWrittenBehindInOneBatch(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel, DBFlushInterval: time.Hour})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	for n := 1; n <= 2; n++ {
+		run := PendingRun{RunID: fmt.Sprintf("run-%d", n), ChatID: "chat-1", UserMessageID: fmt.Sprintf("user-%d", n), AssistantMessageID: fmt.Sprintf("assistant-%d", n), Model: config.DefaultModel}
+		if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+	}
+
+	// Only the latest output of each reply is kept until the flush.
+	_ = service.UpdateAssistantPartial(ctx, "assistant-1", "One")
+	_ = service.UpdateAssistantPartial(ctx, "assistant-1", "One, two")
+	_ = service.UpdateAssistantPartialReasoning(ctx, "assistant-1", "Counting")
+	_ = service.UpdateAssistantPartial(ctx, "assistant-2", "Other")
+	if message, err := store.GetMessage(ctx, "assistant-1"); err != nil || message.Content != "" {
+		t.Fatalf("assistant-1 before the flush = %+v, %v; want nothing written yet", message, err)
+	}
+	if err := service.FlushPartials(ctx); err != nil {
+		t.Fatalf("FlushPartials() error = %v", err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-1"); err != nil || message.Content != "One, two" || message.Reasoning != "Counting" || message.Status != "streaming" {
+		t.Fatalf("assistant-1 = %+v, %v; want its latest partial output", message, err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-2"); err != nil || message.Content != "Other" {
+		t.Fatalf("assistant-2 = %+v, %v; want its partial content", message, err)
+	}
+
+	// Completing a reply saves its buffered reasoning, and output buffered
+	// after it cannot undo the final content.
+	_ = service.UpdateAssistantPartialReasoning(ctx, "assistant-2", "Thinking it over")
+	if _, err := service.CompleteAssistant(ctx, "assistant-2", "Other, finished", "completed"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+	_ = service.UpdateAssistantPartial(ctx, "assistant-2", "Other, fin")
+	if err := service.FlushPartials(ctx); err != nil {
+		t.Fatalf("FlushPartials() error = %v", err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-2"); err != nil || message.Content != "Other, finished" || message.Reasoning != "Thinking it over" || message.Status != "completed" {
+		t.Fatalf("assistant-2 = %+v, %v; want the final content and reasoning", message, err)
+	}
+}
+
+func TestCancelRunStopsTheStreamAndRecordsCancelled(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	var last RunEvent
+	streamed := 0
+	err := service.ExecuteRun(ctx, auth.Principal{}, APIRunRequest{ChatID: "chat-1", Content: "Tell me a long story", RunID: "run-1"}, func(event RunEvent) {
+		if event.Type == RunEventStreaming {
+			streamed++
+			if streamed == 1 && !service.CancelRun("run-1") {
+				t.Errorf("CancelRun() = false for a run in flight")
+			}
+		}
+		last = event
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if last.Type != RunEventCompleted || last.Status != "cancelled" {
+		t.Fatalf("last event = %+v, want a cancelled completion", last)
+	}
+	if streamed > 2 {
+		t.Fatalf("streamed %d chunks after cancelling, want the stream stopped", streamed)
+	}
+	run, err := store.GetRun(ctx, "run-1")
+	if err != nil || run.Status != "cancelled" || !run.FinishedAt.Valid {
+		t.Fatalf("run = %+v, %v; want it saved as cancelled", run, err)
+	}
+	if service.ActiveRuns() != 0 || service.CancelRun("run-1") {
+		t.Fatalf("run still registered after it finished")
+	}
+}
+
+func TestStopRunCancelsFromAnotherClientAndSettlesStaleRuns(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	streaming := make(chan struct{}, 1)
+	var last RunEvent
+	executed := make(chan error, 1)
+	go func() {
+		executed <- service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Tell me a long story", RunID: "run-1"}, func(event RunEvent) {
+			if event.Type == RunEventStreaming {
+				select {
+				case streaming <- struct{}{}:
+				default:
+				}
+			}
+			last = event
+		})
+	}()
+	<-streaming
+	receipt, err := service.StopRun(ctx, principal, "run-1")
+	if err != nil || receipt.Status != "cancelled" || receipt.FinishedAt == nil {
+		t.Fatalf("StopRun() = %+v, %v; want the run saved as cancelled", receipt, err)
+	}
+	if err := <-executed; err != nil || last.Status != "cancelled" {
+		t.Fatalf("ExecuteRun() = %v, last event %+v; want a cancelled completion", err, last)
+	}
+	if _, err := service.StopRun(ctx, principal, "run-1"); !errors.Is(err, ErrRunFinished) {
+		t.Fatalf("StopRun() again error = %v, want ErrRunFinished", err)
+	}
+
+	// A run recorded as running that nothing executes, e.g. started by a
+	// client whose process went away, is cancelled in the store.
+	stale := PendingRun{RunID: "run-2", ChatID: "chat-1", UserMessageID: "user-2", AssistantMessageID: "assistant-2", Model: ai.MockModel}
+	if err := service.PersistRunStart(ctx, stale, "Hello?"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	receipt, err = service.StopRun(ctx, principal, "run-2")
+	if err != nil || receipt.Status != "cancelled" {
+		t.Fatalf("StopRun(stale) = %+v, %v; want cancelled", receipt, err)
+	}
+	if message, err := store.GetMessage(ctx, "assistant-2"); err != nil || message.Status != "cancelled" {
+		t.Fatalf("assistant-2 = %+v, %v; want cancelled", message, err)
+	}
+}
+
+func TestDrainInterruptsRunsThatOutlastTheWindow(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	streaming := make(chan struct{}, 1)
+	executed := make(chan error, 1)
+	go func() {
+		executed <- service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Tell me a long story", RunID: "run-1"}, func(event RunEvent) {
+			if event.Type == RunEventStreaming {
+				select {
+				case streaming <- struct{}{}:
+				default:
+				}
+			}
+		})
+	}()
+	<-streaming
+	report := service.Drain(10 * time.Millisecond)
+	if report != (DrainReport{InFlight: 1, Interrupted: 1}) {
+		t.Fatalf("Drain() = %+v, want one run interrupted and saved", report)
+	}
+	if err := <-executed; err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	receipt, err := service.RunReceipt(ctx, principal, "run-1")
+	if err != nil || receipt.Status != "interrupted" || receipt.Error != shutdownRunError {
+		t.Fatalf("RunReceipt() = %+v, %v; want the run interrupted by the shutdown", receipt, err)
+	}
+	if receipt.Content == "" {
+		t.Fatal("interrupted run lost its partial content")
+	}
+
+	if !service.Draining() {
+		t.Fatal("Draining() = false after Drain")
+	}
+	err = service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Hello?", RunID: "run-2"}, func(RunEvent) {})
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("ExecuteRun() while draining error = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestRunDetailsListEachAttemptOfAReply(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	var assistantID string
+	if err := service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Hello", RunID: "run-1"}, func(event RunEvent) {
+		if event.AssistantMessageID != "" {
+			assistantID = event.AssistantMessageID
+		}
+	}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+
+	details, err := service.ChatRunDetails(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("ChatRunDetails() error = %v", err)
+	}
+	runs := details[assistantID]
+	if len(runs) != 1 || runs[0].RunID != "run-1" || runs[0].Status != "completed" || runs[0].Model != ai.MockModel || runs[0].Duration <= 0 {
+		t.Fatalf("ChatRunDetails()[%s] = %+v, want the completed run", assistantID, runs)
+	}
+	if runs[0].FirstToken < 15*time.Millisecond || runs[0].Stream < runs[0].FirstToken || runs[0].Stream > runs[0].Duration {
+		t.Fatalf("ChatRunDetails()[%s] latency = %s to first token, %s stream; want both recorded", assistantID, runs[0].FirstToken, runs[0].Stream)
+	}
+	var scrape strings.Builder
+	if err := service.RunMetrics().WritePrometheus(&scrape); err != nil || !strings.Contains(scrape.String(), `rhone_run_first_token_seconds_count{model="`+ai.MockModel+`"} 1`) {
+		t.Fatalf("WritePrometheus() = %v, want the run in the first token histogram:\n%s", err, scrape.String())
+	}
+	single, err := service.MessageRunDetails(ctx, assistantID)
+	if err != nil || len(single) != 1 || single[0].RunID != "run-1" {
+		t.Fatalf("MessageRunDetails() = %+v, %v; want run-1", single, err)
+	}
+}
+
+func TestCompletedRepliesRunThroughTheOutputProcessors(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:     ai.MockModel,
+		MaxHistory:       10,
+		OutputProcessors: []string{"strip_artifacts"},
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	var last RunEvent
+	err := service.ExecuteRun(ctx, auth.Principal{}, APIRunRequest{ChatID: "chat-1", Content: "Echo<|im_end|> this", RunID: "run-1"}, func(event RunEvent) {
+		last = event
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if !strings.HasSuffix(last.Content, "> Echo this") {
+		t.Fatalf("completed content = %q, want the artifact stripped", last.Content)
+	}
+	receipt, err := service.RunReceipt(ctx, auth.Principal{}, "run-1")
+	if err != nil || receipt.Content != last.Content {
+		t.Fatalf("receipt content = %q, %v; want the processed reply saved", receipt.Content, err)
+	}
+
+	// Cancelled and failed replies are saved as they streamed.
+	if _, err := service.CompleteAssistant(ctx, receipt.AssistantMessageID, "Cut<|im_end|>", "cancelled"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+	if message, err := store.GetMessage(ctx, receipt.AssistantMessageID); err != nil || message.Content != "Cut<|im_end|>" {
+		t.Fatalf("cancelled message = %+v, %v; want it unprocessed", message, err)
+	}
+}
+
+func TestReloadSwapsTunableSettingsAndReportsTheRest(t *testing.T) {
+	store := newTestStore(t)
+	cfg := config.Config{
+		DatabasePath: "first.sqlite",
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	}
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), cfg)
+
+	next := cfg
+	next.DatabasePath = "second.sqlite"
+	next.SystemPrompt = "You are terse."
+	next.Models = []string{"oai-resp/gpt-5-mini"}
+	next.RateLimitRunsPerHour = 5
+	next.OutputProcessors = []string{"trim"}
+	restart := service.Reload(next)
+	if len(restart) != 1 || restart[0] != "DatabasePath" {
+		t.Fatalf("Reload() restart = %v, want [DatabasePath]", restart)
+	}
+	if service.settings().DatabasePath != "first.sqlite" {
+		t.Fatalf("DatabasePath = %q, want the startup value kept", service.settings().DatabasePath)
+	}
+	if prompt := service.systemPrompt(""); prompt != "You are terse." {
+		t.Fatalf("systemPrompt() = %q, want the reloaded prompt", prompt)
+	}
+	if !service.RateLimitsEnabled() {
+		t.Fatal("RateLimitsEnabled() = false, want the reloaded limit")
+	}
+	if service.IsAllowedModel(ai.MockModel) || len(service.AllowedModels()) != 0 {
+		t.Fatalf("AllowedModels() = %v, want the mock model no longer offered", service.AllowedModels())
+	}
+	if got := service.settings().output.Apply("  hi  "); got != "hi" {
+		t.Fatalf("output.Apply() = %q, want the reloaded processors", got)
+	}
+}
+
+func TestScheduledPromptsRunThroughTheRunPipelineOncePerOccurrence(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		SystemPrompt: "You are helpful.",
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "News", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.CreateScheduledPrompt(ctx, principal, "chat-1", "Summarize", "hourly", "07:00"); err == nil {
+		t.Fatal("CreateScheduledPrompt(unknown frequency) error = nil")
+	}
+	if _, err := service.CreateScheduledPrompt(ctx, principal, "chat-1", "Summarize", "daily", "7am"); err == nil {
+		t.Fatal("CreateScheduledPrompt(bad time) error = nil")
+	}
+	prompt, err := service.CreateScheduledPrompt(ctx, principal, "chat-1", "Summarize overnight news", "weekdays", "07:30")
+	if err != nil {
+		t.Fatalf("CreateScheduledPrompt() error = %v", err)
+	}
+	if DescribePromptSchedule(prompt) != "Weekdays at 07:30" || !prompt.NextRunAt.After(time.Now()) {
+		t.Fatalf("prompt = %+v, want a future weekday run", prompt)
+	}
+	if weekday := prompt.NextRunAt.In(time.Local).Weekday(); weekday == time.Saturday || weekday == time.Sunday {
+		t.Fatalf("NextRunAt = %s falls on a weekend", prompt.NextRunAt)
+	}
+
+	// Two days later, several occurrences were missed; the prompt runs once.
+	later := prompt.NextRunAt.Add(48 * time.Hour)
+	if err := service.RunDuePrompts(ctx, later, nil); err != nil {
+		t.Fatalf("RunDuePrompts() error = %v", err)
+	}
+	if err := service.RunDuePrompts(ctx, later, nil); err != nil {
+		t.Fatalf("RunDuePrompts() again error = %v", err)
+	}
+	messages, err := store.ListMessages(ctx, "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %+v, want one prompt and its answer", messages)
+	}
+	for _, message := range messages {
+		if message.Role == "user" && message.Content != "Summarize overnight news" || message.Role == "assistant" && message.Status != "completed" {
+			t.Fatalf("messages = %+v, want the prompt and its completed answer", messages)
+		}
+	}
+	prompts, err := service.ScheduledPrompts(ctx, "chat-1")
+	if err != nil || len(prompts) != 1 {
+		t.Fatalf("ScheduledPrompts() = %+v, %v; want one prompt", prompts, err)
+	}
+	if ran := prompts[0]; ran.LastStatus != "completed" || ran.LastRunID == "" || !ran.NextRunAt.After(later) {
+		t.Fatalf("prompt after run = %+v, want completed with the next run after %s", ran, later)
+	}
+	if err := service.DeleteScheduledPrompt(ctx, principal, prompt.ID); err != nil {
+		t.Fatalf("DeleteScheduledPrompt() error = %v", err)
+	}
+}
+
+func TestSetupStatusOffersMockModelWithoutProviderKeys(t *testing.T) {
+	for _, env := range []string{"OPENAI_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY", "ANTHROPIC_API_KEY"} {
+		t.Setenv(env, "")
+	}
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{}), config.Config{DefaultModel: config.DefaultModel})
+
+	status := service.SetupStatus()
+	if !status.NeedsSetup || status.MockModel != ai.MockModel || len(status.MissingKeys) == 0 {
+		t.Fatalf("SetupStatus() = %+v, want setup needed with the mock model offered", status)
+	}
+	if model := service.DefaultModel(); model != ai.MockModel {
+		t.Fatalf("DefaultModel() = %q, want %q", model, ai.MockModel)
+	}
+	chat, err := service.CreateChat(context.Background(), auth.Principal{}, config.DefaultModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if chat.Model != ai.MockModel {
+		t.Fatalf("CreateChat() model = %q, want the mock model", chat.Model)
+	}
+}
+
+func TestUploadedImageIsClaimedByNextUserMessage(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:       config.DefaultModel,
+		MaxHistory:         30,
+		SystemPrompt:       "You are helpful.",
+		AttachmentMaxBytes: 1024,
+		AttachmentsDir:     t.TempDir(),
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+
+	if _, err := service.UploadAttachment(ctx, auth.Principal{}, "chat-1", "archive.zip", []byte("PK\x03\x04\x14\x00\x00\x00")); !errors.Is(err, ErrAttachmentType) {
+		t.Fatalf("UploadAttachment(zip) error = %v, want ErrAttachmentType", err)
+	}
+	if _, err := service.UploadAttachment(ctx, auth.Principal{}, "chat-1", "big.png", append(png, make([]byte, 1024)...)); !errors.Is(err, ErrAttachmentTooLarge) {
+		t.Fatalf("UploadAttachment(big) error = %v, want ErrAttachmentTooLarge", err)
+	}
+	uploaded, err := service.UploadAttachment(ctx, auth.Principal{}, "chat-1", "../../shot.png", png)
+	if err != nil {
+		t.Fatalf("UploadAttachment() error = %v", err)
+	}
+	if uploaded.FileName != "shot.png" || uploaded.MediaType != "image/png" {
+		t.Fatalf("uploaded = %+v, want sanitized png", uploaded)
+	}
+	pending, err := service.PendingAttachments(ctx, "chat-1")
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("PendingAttachments() = %v, %v; want one", pending, err)
+	}
+
+	err = service.PersistRunStart(ctx, PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}, "What is in this picture?")
+	if err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	if pending, _ := service.PendingAttachments(ctx, "chat-1"); len(pending) != 0 {
+		t.Fatalf("PendingAttachments() after send = %v, want none", pending)
+	}
+
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	var images []ai.Image
+	for _, message := range history {
+		if message.Role == "user" {
+			images = message.Images
+		}
+	}
+	if len(images) != 1 || images[0].MediaType != "image/png" || len(images[0].Data) != len(png) {
+		t.Fatalf("user message images = %+v, want the uploaded png", images)
+	}
+
+	if err := service.DeleteChat(ctx, "chat-1"); err != nil {
+		t.Fatalf("DeleteChat() error = %v", err)
+	}
+	if _, err := os.Stat(uploaded.StoragePath); !os.IsNotExist(err) {
+		t.Fatalf("attachment file still present after DeleteChat: %v", err)
+	}
+}
+
+func TestPrepareRunSnapshotsRequestForReplay(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel: config.DefaultModel,
+		MaxHistory:   30,
+		SystemPrompt: "You are helpful.",
+		DevMode:      true,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}
+	if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	if _, err := service.ReplayRun(ctx, "assistant-1", ""); !errors.Is(err, ErrNoRunSnapshot) {
+		t.Fatalf("ReplayRun() before snapshot error = %v, want ErrNoRunSnapshot", err)
+	}
+
+	request, err := service.PrepareRun(ctx, run)
+	if err != nil {
+		t.Fatalf("PrepareRun() error = %v", err)
+	}
+	if err := store.UpdateMessageContent(ctx, "user-1", "Edited later", "complete", time.Now().UTC()); err != nil {
+		t.Fatalf("UpdateMessageContent() error = %v", err)
+	}
+	source, err := store.GetRunByAssistantMessage(ctx, "assistant-1")
+	if err != nil {
+		t.Fatalf("GetRunByAssistantMessage() error = %v", err)
+	}
+	stored, err := service.RunSnapshot(ctx, source)
+	if err != nil {
+		t.Fatalf("RunSnapshot() error = %v", err)
+	}
+	if stored.Model != request.Model || len(stored.History) != len(request.History) || stored.History[len(stored.History)-1].Content != "Hello" {
+		t.Fatalf("snapshot = %+v, want the pre-edit request %+v", stored, request)
+	}
+
+	if _, err := service.ReplayRun(ctx, "assistant-1", "unknown/model"); err == nil || !strings.Contains(err.Error(), "unsupported model") {
+		t.Fatalf("ReplayRun(unknown model) error = %v, want unsupported model", err)
+	}
+
+	snapshot, err := store.GetRunSnapshot(ctx, "run-1")
+	if err != nil {
+		t.Fatalf("GetRunSnapshot() error = %v", err)
+	}
+	snapshot.SHA256 = strings.Repeat("0", 64)
+	if err := store.SaveRunSnapshot(ctx, snapshot); err != nil {
+		t.Fatalf("SaveRunSnapshot() error = %v", err)
+	}
+	if _, err := service.RunSnapshot(ctx, source); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("RunSnapshot() with bad hash error = %v, want ErrSnapshotCorrupt", err)
+	}
+}
+
+func TestGoldenExamplesExportAsVersionedDatasets(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}
+	if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	if _, err := service.PrepareRun(ctx, run); err != nil {
+		t.Fatalf("PrepareRun() error = %v", err)
+	}
+	if _, err := service.MarkGolden(ctx, principal, "assistant-1", ""); !errors.Is(err, ErrNotGoldenCandidate) {
+		t.Fatalf("MarkGolden(streaming) error = %v, want ErrNotGoldenCandidate", err)
+	}
+	if _, err := service.CompleteAssistant(ctx, "assistant-1", "Hi there", "completed"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+	if _, err := service.ExportEvalDataset(ctx, principal, ""); !errors.Is(err, ErrNoGoldenExamples) {
+		t.Fatalf("ExportEvalDataset(empty) error = %v, want ErrNoGoldenExamples", err)
+	}
+
+	if _, err := service.MarkGolden(ctx, principal, "assistant-1", "first"); err != nil {
+		t.Fatalf("MarkGolden() error = %v", err)
+	}
+	example, err := service.MarkGolden(ctx, principal, "assistant-1", " concise greeting ")
+	if err != nil {
+		t.Fatalf("MarkGolden() again error = %v", err)
+	}
+	if example.Note != "concise greeting" || example.RunID != "run-1" {
+		t.Fatalf("example = %+v, want the updated note on run-1", example)
+	}
+	if golden, err := service.GoldenMessageIDs(ctx, "chat-1"); err != nil || !golden["assistant-1"] || len(golden) != 1 {
+		t.Fatalf("GoldenMessageIDs() = %v, %v; want assistant-1 only", golden, err)
+	}
+
+	first, err := service.ExportEvalDataset(ctx, principal, "Greetings")
+	if err != nil {
+		t.Fatalf("ExportEvalDataset() error = %v", err)
+	}
+	if first.Name != "greetings" || first.Version != 1 || first.ExampleCount != 1 || len(first.SHA256) != 64 {
+		t.Fatalf("first export = %+v, want greetings v1 with one example", first)
+	}
+	if err := service.UnmarkGolden(ctx, principal, "assistant-1"); err != nil {
+		t.Fatalf("UnmarkGolden() error = %v", err)
+	}
+	if examples, err := service.GoldenExamples(ctx, principal); err != nil || len(examples) != 0 {
+		t.Fatalf("GoldenExamples() after unmark = %+v, %v; want none", examples, err)
+	}
+
+	stored, err := service.EvalDataset(ctx, principal, "greetings", 1)
+	if err != nil {
+		t.Fatalf("EvalDataset() error = %v", err)
+	}
+	examples, err := EvalExamples(stored)
+	if err != nil {
+		t.Fatalf("EvalExamples() error = %v", err)
+	}
+	if len(examples) != 1 || examples[0].Expected != "Hi there" || examples[0].Note != "concise greeting" ||
+		examples[0].Input[len(examples[0].Input)-1].Content != "Hello" {
+		t.Fatalf("exported examples = %+v, want the frozen prompt and answer", examples)
+	}
+	if _, err := service.ExportEvalDataset(ctx, principal, "../escape"); err == nil {
+		t.Fatal("ExportEvalDataset(invalid name) error = nil")
+	}
+}
+
+func TestChatSpendCeilingRefusesRunsOnceReached(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "Demo", "anthropic/claude-haiku-4-5", time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.SetChatSpendCeiling(ctx, principal, "chat-1", "-1"); err == nil {
+		t.Fatal("SetChatSpendCeiling(-1) error = nil")
+	}
+	spend, err := service.SetChatSpendCeiling(ctx, principal, "chat-1", "$1.50")
+	if err != nil {
+		t.Fatalf("SetChatSpendCeiling() error = %v", err)
+	}
+	if !spend.Limited() || spend.CeilingUSD != 1.5 || spend.SpentUSD != 0 || spend.Exceeded {
+		t.Fatalf("spend = %+v, want a fresh $1.50 ceiling", spend)
+	}
+
+	run := PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              "anthropic/claude-haiku-4-5",
+	}
+	if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	// One million input tokens at $1 and 100k output tokens at $5.
+	usage := ai.Usage{InputTokens: 1_000_000, OutputTokens: 100_000}
+	latency := ai.Latency{FirstToken: time.Second, Stream: 101 * time.Second, Tools: []ai.ToolLatency{{ID: "call-1", Name: "fetch_url", Duration: 2 * time.Second}}}
+	if err := service.CompleteRun(ctx, run, "completed", StreamResult{StopReason: "end_turn", Usage: usage, Latency: latency}, ""); err != nil {
+		t.Fatalf("CompleteRun() error = %v", err)
+	}
+	if details, err := service.MessageRunDetails(ctx, "assistant-1"); err != nil || len(details) != 1 || details[0].FirstToken != time.Second || details[0].OutputTokensPerSecond != 1000 || !slices.Equal(details[0].Tools, latency.Tools) {
+		t.Fatalf("MessageRunDetails() = %+v, %v; want the stored latency and 1000 tokens/s", details, err)
+	}
+	if stored, err := store.GetRun(ctx, "run-1"); err != nil || stored.InputTokens != 1_000_000 || stored.OutputTokens != 100_000 {
+		t.Fatalf("GetRun() = %d in / %d out, %v; want the usage in its columns", stored.InputTokens, stored.OutputTokens, err)
+	}
+	spend, err = service.ChatSpend(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("ChatSpend() error = %v", err)
+	}
+	if math.Abs(spend.SpentUSD-1.5) > 1e-9 || !spend.Exceeded {
+		t.Fatalf("spend = %+v, want $1.50 spent and the ceiling reached", spend)
+	}
+
+	next := PendingRun{RunID: "run-2", ChatID: "chat-1", UserMessageID: "user-2", AssistantMessageID: "assistant-2", Model: run.Model}
+	if err := service.PersistRunStart(ctx, next, "Again"); !errors.Is(err, ErrSpendCeilingReached) {
+		t.Fatalf("PersistRunStart() over ceiling error = %v, want ErrSpendCeilingReached", err)
+	}
+	if _, err := service.SetChatSpendCeiling(ctx, principal, "chat-1", ""); err != nil {
+		t.Fatalf("SetChatSpendCeiling(clear) error = %v", err)
+	}
+	if err := service.PersistRunStart(ctx, next, "Again"); err != nil {
+		t.Fatalf("PersistRunStart() without ceiling error = %v", err)
+	}
+}
+
+func TestDocumentTextIsInjectedWithinLimits(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:              config.DefaultModel,
+		MaxHistory:                30,
+		SystemPrompt:              "You are helpful.",
+		AttachmentMaxBytes:        1 << 20,
+		AttachmentTextMaxBytes:    40,
+		AttachmentHistoryMaxBytes: 40,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	send := func(n int, document string) {
+		t.Helper()
+		if _, err := service.UploadAttachment(ctx, auth.Principal{}, "chat-1", fmt.Sprintf("doc%d.txt", n), []byte(document)); err != nil {
+			t.Fatalf("UploadAttachment() error = %v", err)
+		}
+		err := service.PersistRunStart(ctx, PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             "chat-1",
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              config.DefaultModel,
+		}, fmt.Sprintf("Question %d", n))
+		if err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	send(1, "The first document is older.")
+	send(2, "Quarterly revenue grew 12 percent, driven by enterprise renewals.")
+
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	contents := map[string]string{}
+	for _, message := range history {
+		if message.Role == "user" {
+			contents[strings.SplitN(message.Content, "\n", 2)[0]] = message.Content
+		}
+	}
+	latest := contents["Question 2"]
+	if !strings.Contains(latest, `[Attached document "doc2.txt"]`) || !strings.Contains(latest, "Quarterly revenue") || strings.Contains(latest, "enterprise renewals") {
+		t.Fatalf("latest message = %q, want doc2 text truncated to the limit", latest)
+	}
+	if older := contents["Question 1"]; !strings.Contains(older, `"doc1.txt" omitted`) {
+		t.Fatalf("older message = %q, want doc1 omitted once the budget is spent", older)
+	}
+}
+
+func TestComparisonsTieTwoRunsToOneUserMessage(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	other := "gemini/gemini-3-flash-preview"
+	if err := service.ValidateComparison(config.DefaultModel, config.DefaultModel); err == nil {
+		t.Fatal("ValidateComparison(same model) error = nil, want an error")
+	}
+	if err := service.ValidateComparison(config.DefaultModel, other); err != nil {
+		t.Fatalf("ValidateComparison() error = %v", err)
+	}
+	runs := []PendingRun{
+		{RunID: "run-1", ChatID: "chat-1", UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel, ComparisonID: "run-1"},
+		{RunID: "run-2", ChatID: "chat-1", UserMessageID: "user-1", AssistantMessageID: "assistant-2", Model: other, ComparisonID: "run-1", ReuseUserMessage: true},
+	}
+	for i, run := range runs {
+		if err := service.PersistRunStart(ctx, run, "Which is bigger?"); err != nil {
+			t.Fatalf("PersistRunStart(%s) error = %v", run.RunID, err)
+		}
+		if _, err := service.CompleteAssistant(ctx, run.AssistantMessageID, fmt.Sprintf("Answer %d", i+1), "completed"); err != nil {
+			t.Fatalf("CompleteAssistant() error = %v", err)
+		}
+	}
+
+	comparisons, err := service.Comparisons(ctx, auth.Principal{}, "chat-1")
+	if err != nil {
+		t.Fatalf("Comparisons() error = %v", err)
+	}
+	if len(comparisons) != 1 || len(comparisons[0].Answers) != 2 {
+		t.Fatalf("Comparisons() = %+v, want one comparison with two answers", comparisons)
+	}
+	comparison := comparisons[0]
+	if comparison.ID != "run-1" || comparison.UserMessageID != "user-1" || comparison.Prompt != "Which is bigger?" {
+		t.Fatalf("comparison = %+v, want run-1 on user-1", comparison)
+	}
+	if first, second := comparison.Answers[0], comparison.Answers[1]; first.Content != "Answer 1" || first.Run.Model != config.DefaultModel || second.Content != "Answer 2" || second.Run.Model != other {
+		t.Fatalf("answers = %+v, want each model's reply in run order", comparison.Answers)
+	}
+
+	// Later turns carry on from the first model's answer only.
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	var users, answers []string
+	for _, message := range history {
+		switch message.Role {
+		case "user":
+			users = append(users, message.Content)
+		case "assistant":
+			answers = append(answers, message.Content)
+		}
+	}
+	if len(users) != 1 || len(answers) != 1 || answers[0] != "Answer 1" {
+		t.Fatalf("BuildHistory() = %+v, want the prompt once and only the first answer", history)
+	}
+}
+
+func TestShareLinksShowAReadOnlyChatUntilRevoked(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel, ShareSigningKey: "share-key"})
+	ctx := context.Background()
+	owner := auth.Principal{UserID: "user-1"}
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "Trip plans", config.DefaultModel, "user-1", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	for i, prompt := range []string{"Where to?", "My passport number is 123"} {
+		run := PendingRun{RunID: fmt.Sprintf("run-%d", i), ChatID: chat.ID, UserMessageID: fmt.Sprintf("user-%d", i), AssistantMessageID: fmt.Sprintf("assistant-%d", i), Model: config.DefaultModel}
+		if err := service.PersistRunStart(ctx, run, prompt); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		if _, err := service.CompleteAssistant(ctx, run.AssistantMessageID, "Lisbon", "completed"); err != nil {
+			t.Fatalf("CompleteAssistant() error = %v", err)
+		}
+	}
+	if err := service.FlagMessage(ctx, "user-1", MessageFlagSensitive); err != nil {
+		t.Fatalf("FlagMessage() error = %v", err)
+	}
+
+	if _, err := service.CreateShareLink(ctx, auth.Principal{UserID: "user-2"}, chat.ID); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("CreateShareLink() as another user error = %v, want ErrChatForbidden", err)
+	}
+	link, err := service.CreateShareLink(ctx, owner, chat.ID)
+	if err != nil {
+		t.Fatalf("CreateShareLink() error = %v", err)
+	}
+	if !strings.HasPrefix(link.Token, link.ID+".") {
+		t.Fatalf("Token = %q, want the link id and a signature", link.Token)
+	}
+	shared, err := service.SharedChat(ctx, link.Token)
+	if err != nil {
+		t.Fatalf("SharedChat() error = %v", err)
+	}
+	if shared.Title != "Trip plans" || len(shared.Messages) != 3 {
+		t.Fatalf("SharedChat() = %+v, want the title and three messages", shared)
+	}
+	for _, message := range shared.Messages {
+		if message.ID == "user-1" {
+			t.Fatalf("SharedChat() = %+v, want the sensitive message left out", shared.Messages)
+		}
+	}
+	if _, err := service.SharedChat(ctx, link.ID+".forged"); !errors.Is(err, ErrShareLinkInvalid) {
+		t.Fatalf("SharedChat(forged) error = %v, want ErrShareLinkInvalid", err)
+	}
+	other := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel, ShareSigningKey: "other-key"})
+	if _, err := other.SharedChat(ctx, link.Token); !errors.Is(err, ErrShareLinkInvalid) {
+		t.Fatalf("SharedChat() under another key error = %v, want ErrShareLinkInvalid", err)
+	}
+
+	if links, err := service.ShareLinks(ctx, owner, chat.ID); err != nil || len(links) != 1 || links[0].Token != link.Token {
+		t.Fatalf("ShareLinks() = %+v, %v; want the new link", links, err)
+	}
+	if err := service.RevokeShareLink(ctx, owner, chat.ID, link.ID); err != nil {
+		t.Fatalf("RevokeShareLink() error = %v", err)
+	}
+	if _, err := service.SharedChat(ctx, link.Token); !errors.Is(err, ErrShareLinkInvalid) {
+		t.Fatalf("SharedChat() after revoking error = %v, want ErrShareLinkInvalid", err)
+	}
+	if links, err := service.ShareLinks(ctx, owner, chat.ID); err != nil || len(links) != 0 {
+		t.Fatalf("ShareLinks() after revoking = %+v, %v; want none", links, err)
+	}
+
+	disabled := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	if _, err := disabled.CreateShareLink(ctx, owner, chat.ID); !errors.Is(err, ErrSharingDisabled) {
+		t.Fatalf("CreateShareLink() without a key error = %v, want ErrSharingDisabled", err)
+	}
+}
+
+func TestCloneChatCopiesTheConversationAndOptionallyItsSettings(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	owner := auth.Principal{UserID: "user-1"}
+	other := "gemini/gemini-3-flash-preview"
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "Setup", other, "user-1", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	params := ChatParams{Temperature: sql.NullFloat64{Float64: 0.2, Valid: true}}
+	if err := service.UpdateChatParams(ctx, chat.ID, params); err != nil {
+		t.Fatalf("UpdateChatParams() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: other}
+	if err := service.PersistRunStart(ctx, run, "You are my editor."); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	if _, err := service.CompleteAssistant(ctx, "assistant-1", "Understood.", "completed"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+	if err := service.FlagMessage(ctx, "user-1", MessageFlagSensitive); err != nil {
+		t.Fatalf("FlagMessage() error = %v", err)
+	}
+	// A reply still streaming is not copied.
+	if err := service.PersistRunStart(ctx, PendingRun{RunID: "run-2", ChatID: chat.ID, UserMessageID: "user-2", AssistantMessageID: "assistant-2", Model: other}, "Next"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+
+	if _, err := service.CloneChat(ctx, auth.Principal{UserID: "user-2"}, chat.ID, CloneOptions{}); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("CloneChat() as another user error = %v, want ErrChatForbidden", err)
+	}
+	clone, err := service.CloneChat(ctx, owner, chat.ID, CloneOptions{KeepSettings: true})
+	if err != nil {
+		t.Fatalf("CloneChat() error = %v", err)
+	}
+	if clone.ID == chat.ID || clone.Title != "Setup (copy)" || clone.Model != other || clone.OwnerID.String != "user-1" {
+		t.Fatalf("CloneChat() = %+v, want an owned copy on the original model", clone)
+	}
+	stored, err := store.GetChat(ctx, clone.ID)
+	if err != nil || !stored.Temperature.Valid || stored.Temperature.Float64 != 0.2 {
+		t.Fatalf("clone = %+v, %v; want the temperature copied", stored, err)
+	}
+	messages, err := store.ListMessages(ctx, clone.ID, 0)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 3 || messages[0].Content != "You are my editor." || messages[0].Flag != MessageFlagSensitive || messages[1].Content != "Understood." || messages[2].Content != "Next" {
+		t.Fatalf("cloned messages = %+v, want both turns and the last prompt with flags kept", messages)
+	}
+	if messages[0].ID == "user-1" {
+		t.Fatal("cloned message kept the original id")
+	}
+
+	plain, err := service.CloneChat(ctx, owner, chat.ID, CloneOptions{})
+	if err != nil {
+		t.Fatalf("CloneChat() error = %v", err)
+	}
+	stored, err = store.GetChat(ctx, plain.ID)
+	if err != nil || stored.Model != config.DefaultModel || stored.Temperature.Valid {
+		t.Fatalf("plain clone = %+v, %v; want the default model and no params", stored, err)
+	}
+}
+
+func TestBudgetsRefuseRunsOnceUsedUp(t *testing.T) {
+	store := newTestStore(t)
+	cfg := config.Config{
+		DefaultModel:         config.DefaultModel,
+		MaxHistory:           30,
+		RateLimitWarnPercent: 20,
+		BudgetScope:          "user",
+		BudgetTokensPerDay:   1000,
+		BudgetUSDPerMonth:    5,
+	}
+	service := NewService(store, nil, cfg)
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	bob := auth.Principal{UserID: "bob"}
+	chat, err := service.CreateChat(ctx, alice, "anthropic/claude-haiku-4-5")
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := func(n int, usage ai.Usage) {
+		t.Helper()
+		pending := PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             chat.ID,
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              chat.Model,
+		}
+		if err := service.PersistRunStart(ctx, pending, "Hello"); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		if err := service.CompleteRun(ctx, pending, "completed", StreamResult{StopReason: "end_turn", Usage: usage}, ""); err != nil {
+			t.Fatalf("CompleteRun() error = %v", err)
+		}
+	}
+
+	run(1, ai.Usage{InputTokens: 600, OutputTokens: 250})
+	status, err := service.QuotaStatus(ctx, alice)
+	if err != nil {
+		t.Fatalf("QuotaStatus() error = %v", err)
+	}
+	if !status.Limited || !status.Near || status.Exhausted || len(status.Budgets) != 2 {
+		t.Fatalf("QuotaStatus() = %+v, want two budgets and a warning", status)
+	}
+	tokens, cost := status.Budgets[0], status.Budgets[1]
+	if tokens.Unit != BudgetTokens || tokens.Period != "day" || tokens.Used != 850 || tokens.Remaining != 150 || !tokens.ResetsAt.After(time.Now()) {
+		t.Fatalf("token budget = %+v, want 150 of 1000 left today", tokens)
+	}
+	// 600 input tokens at $1/M and 250 output tokens at $5/M.
+	if cost.Unit != BudgetUSD || cost.Period != "month" || math.Abs(cost.Used-0.00185) > 1e-9 {
+		t.Fatalf("cost budget = %+v, want $0.00185 used this month", cost)
+	}
+	if err := service.CheckRunQuota(ctx, alice); err != nil {
+		t.Fatalf("CheckRunQuota() with budget left error = %v", err)
+	}
+
+	run(2, ai.Usage{InputTokens: 100, OutputTokens: 100})
+	err = service.CheckRunQuota(ctx, alice)
+	if !errors.Is(err, ErrBudgetExhausted) || !strings.Contains(err.Error(), "1000 tokens per day") {
+		t.Fatalf("CheckRunQuota() error = %v, want the daily token budget exhausted", err)
+	}
+	if err := service.CheckRunQuota(ctx, bob); err != nil {
+		t.Fatalf("CheckRunQuota(bob) error = %v, want per-user budgets", err)
+	}
+
+	global := cfg
+	global.BudgetScope = "global"
+	service.Reload(global)
+	if err := service.CheckRunQuota(ctx, bob); !errors.Is(err, ErrBudgetExhausted) || !strings.Contains(err.Error(), "shared") {
+		t.Fatalf("CheckRunQuota(bob) error = %v, want the shared budget exhausted", err)
+	}
+}
+
+func TestModerationBlocksOrFlagsMessagesBeforeTheRunStarts(t *testing.T) {
+	store := newTestStore(t)
+	cfg := config.Config{
+		DefaultModel:         ai.MockModel,
+		MaxHistory:           30,
+		ModerationBlockTerms: []string{"launch codes"},
+		ModerationFlagTerms:  []string{"darn"},
+	}
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), cfg)
+	ctx := context.Background()
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "A chat", ai.MockModel, "alice", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	pending := func(n int) PendingRun {
+		return PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             chat.ID,
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              ai.MockModel,
+		}
+	}
+
+	err = service.PersistRunStart(ctx, pending(1), "Tell me the Launch Codes")
+	if !errors.Is(err, ErrContentBlocked) || !strings.Contains(err.Error(), "launch codes") {
+		t.Fatalf("PersistRunStart() error = %v, want the message blocked", err)
+	}
+	if messages, _ := store.ListMessages(ctx, chat.ID, 0); len(messages) != 0 {
+		t.Fatalf("messages = %+v, want nothing saved for a blocked message", messages)
+	}
+	entries, err := store.ListAuditEntries(ctx, "chat", chat.ID, 10)
+	if err != nil || len(entries) != 1 || entries[0].Action != "message.blocked" || entries[0].ActorID != "alice" {
+		t.Fatalf("audit entries = %+v, %v; want the block recorded", entries, err)
+	}
+
+	if err := service.PersistRunStart(ctx, pending(2), "Darn, it broke again"); err != nil {
+		t.Fatalf("PersistRunStart() flagged error = %v", err)
+	}
+	message, err := store.GetMessage(ctx, "user-2")
+	if err != nil || message.Flag != MessageFlagSensitive {
+		t.Fatalf("flagged message = %+v, %v; want it marked sensitive", message, err)
+	}
+	retry := pending(3)
+	retry.UserMessageID = "user-2"
+	retry.ReuseUserMessage = true
+	if err := service.PersistRunStart(ctx, retry, ""); err != nil {
+		t.Fatalf("PersistRunStart() retry error = %v", err)
+	}
+	details, err := service.ChatRunDetails(ctx, chat.ID)
+	if err != nil {
+		t.Fatalf("ChatRunDetails() error = %v", err)
+	}
+	if decision := details["assistant-2"][0].Moderation; decision == nil || decision.Action != moderation.Flag || decision.Source != "terms" {
+		t.Fatalf("run moderation = %+v, want the flag recorded on the run", decision)
+	}
+	if decision := details["assistant-3"][0].Moderation; decision != nil {
+		t.Fatalf("retry moderation = %+v, want the message not screened again", decision)
+	}
+
+	// The mock model does not answer in JSON, so the model check fails.
+	cfg.ModerationModel = ai.MockModel
+	cfg.ModerationFailClosed = true
+	service.Reload(cfg)
+	if err := service.PersistRunStart(ctx, pending(4), "Hello"); !errors.Is(err, ErrContentBlocked) {
+		t.Fatalf("PersistRunStart() fail-closed error = %v, want ErrContentBlocked", err)
+	}
+	cfg.ModerationFailClosed = false
+	service.Reload(cfg)
+	if err := service.PersistRunStart(ctx, pending(5), "Hello"); err != nil {
+		t.Fatalf("PersistRunStart() fail-open error = %v", err)
+	}
+}
+
+func TestRedactionMasksStoredTextButSendsTheOriginal(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel, MaxHistory: 30, RedactPII: []string{"all"}})
+	ctx := context.Background()
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "A chat", config.DefaultModel, "user-1", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel}
+	if err := service.PersistRunStart(ctx, run, "Mail ada@example.com about it"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	message, err := store.GetMessage(ctx, "user-1")
+	if err != nil || message.Content != "Mail [email] about it" {
+		t.Fatalf("stored message = %+v, %v; want the address masked", message, err)
+	}
+
+	request, err := service.PrepareRun(ctx, run)
+	if err != nil {
+		t.Fatalf("PrepareRun() error = %v", err)
+	}
+	if got := request.History[len(request.History)-1].Content; got != "Mail ada@example.com about it" {
+		t.Fatalf("request prompt = %q, want the original for the provider", got)
+	}
+	source, err := store.GetRunByAssistantMessage(ctx, "assistant-1")
+	if err != nil {
+		t.Fatalf("GetRunByAssistantMessage() error = %v", err)
+	}
+	snapshot, err := service.RunSnapshot(ctx, source)
+	if err != nil || snapshot.History[len(snapshot.History)-1].Content != "Mail [email] about it" {
+		t.Fatalf("snapshot = %+v, %v; want the redacted prompt", snapshot.History, err)
+	}
+
+	callID, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: "call-1", Name: "lookup", Input: "{}"})
+	if err != nil {
+		t.Fatalf("UpsertToolStart() error = %v", err)
+	}
+	if err := service.CompleteTool(ctx, callID, ToolCallUpdate{ID: "call-1", Output: `{"contact":"bob@example.com"}`}); err != nil {
+		t.Fatalf("CompleteTool() error = %v", err)
+	}
+	call, _, err := store.GetToolCall(ctx, callID)
+	if err != nil || call.OutputJSON != `{"contact":"[email]"}` {
+		t.Fatalf("stored tool call = %+v, %v; want the output masked", call, err)
+	}
+}
+
+func TestEncryptionSealsConversationsAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sqlite")
+	store, err := db.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	ctx := context.Background()
+	chat, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if err := store.InsertMessage(ctx, db.Message{ID: "before", ChatID: chat.ID, Role: "user", Content: "Written in the clear", Status: "complete", CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC()}); err != nil {
+		t.Fatalf("InsertMessage() error = %v", err)
+	}
+
+	codec, err := db.CodecFromKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", "")
+	if err != nil {
+		t.Fatalf("CodecFromKey() error = %v", err)
+	}
+	store.SetCodec(codec)
+	if sealed, err := store.SealPlaintext(ctx); err != nil || sealed != 1 {
+		t.Fatalf("SealPlaintext() = %d, %v; want the earlier message sealed", sealed, err)
+	}
+	service := newTestService(store)
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel}
+	if err := service.PersistRunStart(ctx, run, "The secret plan"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	callID, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: "call-1", Name: "lookup", Input: `{"q":"secret input"}`})
+	if err != nil {
+		t.Fatalf("UpsertToolStart() error = %v", err)
+	}
+	if err := service.CompleteTool(ctx, callID, ToolCallUpdate{ID: "call-1", Output: `{"a":"secret output"}`}); err != nil {
+		t.Fatalf("CompleteTool() error = %v", err)
+	}
+	if err := service.CompleteRun(ctx, run, "error", StreamResult{}, "secret failure"); err != nil {
+		t.Fatalf("CompleteRun() error = %v", err)
+	}
+
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer raw.Close()
+	for _, query := range []string{
+		`SELECT content FROM messages WHERE id IN ('before', 'user-1')`,
+		`SELECT input_json || output_json FROM tool_calls`,
+		`SELECT error_text FROM runs`,
+	} {
+		rows, err := raw.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			if strings.Contains(value, "secret") || strings.Contains(value, "clear") {
+				t.Errorf("%s = %q, want it sealed", query, value)
+			}
+		}
+		rows.Close()
+	}
+
+	found, err := service.SearchChat(ctx, chat.ID, "SECRET")
+	if err != nil || len(found) != 1 || found[0].Content != "The secret plan" {
+		t.Fatalf("SearchChat() = %+v, %v; want the opened message", found, err)
+	}
+	call, _, err := store.GetToolCall(ctx, callID)
+	if err != nil || call.InputJSON != `{"q":"secret input"}` || call.OutputJSON != `{"a":"secret output"}` {
+		t.Fatalf("GetToolCall() = %+v, %v", call, err)
+	}
+	stored, err := store.GetRun(ctx, "run-1")
+	if err != nil || stored.ErrorText != "secret failure" {
+		t.Fatalf("GetRun() = %+v, %v", stored, err)
+	}
+	store.SetCodec(nil)
+	if _, err := store.GetMessage(ctx, "user-1"); !errors.Is(err, db.ErrSealed) {
+		t.Fatalf("GetMessage() without the key error = %v, want ErrSealed", err)
+	}
+}
+
+func TestEncryptionLeavesNoPlaintextInDerivedColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sqlite")
+	store, err := db.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	ctx := context.Background()
+	service := newTestService(store)
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	// Each value is written once in the clear, for SealPlaintext to seal,
+	// and once with the key set.
+	write := func(suffix string) {
+		t.Helper()
+		now := time.Now().UTC()
+		run := PendingRun{RunID: "run-" + suffix, ChatID: "chat-1", UserMessageID: "user-" + suffix, AssistantMessageID: "assistant-" + suffix, Model: config.DefaultModel}
+		if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		request := RunRequest{Model: config.DefaultModel, History: []AIMessage{{Role: "user", Content: "secret history " + suffix}}}
+		if err := service.saveRunSnapshot(ctx, run.RunID, request); err != nil {
+			t.Fatalf("saveRunSnapshot() error = %v", err)
+		}
+		if err := store.SaveChatSummary(ctx, db.ChatSummary{ChatID: "chat-1", ThroughMessageID: run.UserMessageID, Content: "secret summary", Model: config.DefaultModel, UpdatedAt: now}); err != nil {
+			t.Fatalf("SaveChatSummary() error = %v", err)
+		}
+		replay := db.ReplayRun{ID: "replay-" + suffix, SourceRunID: run.RunID, Model: config.DefaultModel, Status: "running", StartedAt: now}
+		if err := store.InsertReplayRun(ctx, replay); err != nil {
+			t.Fatalf("InsertReplayRun() error = %v", err)
+		}
+		replay.Status, replay.Output, replay.Reasoning, replay.ToolCallsJSON, replay.ErrorText = "error", "secret output", "secret reasoning", `[{"input":"secret"}]`, "secret failure"
+		if err := store.CompleteReplayRun(ctx, replay); err != nil {
+			t.Fatalf("CompleteReplayRun() error = %v", err)
+		}
+		document := db.KnowledgeDocument{ID: "doc-" + suffix, ChatID: "chat-1", FileName: "notes.txt", Embedder: "test", CreatedAt: now}
+		if err := store.InsertKnowledgeDocument(ctx, document, []db.KnowledgeChunk{{ID: "chunk-" + suffix, Content: "secret chunk", Embedding: []byte{1}}}); err != nil {
+			t.Fatalf("InsertKnowledgeDocument() error = %v", err)
+		}
+		if err := store.SaveDraft(ctx, "chat-1", "user-"+suffix, "secret draft", now); err != nil {
+			t.Fatalf("SaveDraft() error = %v", err)
+		}
+		golden := db.GoldenExample{ID: "golden-" + suffix, MessageID: run.AssistantMessageID, ChatID: "chat-1", RunID: run.RunID, Model: config.DefaultModel, PromptJSON: `{"prompt":"secret prompt"}`, Answer: "secret answer", CreatedAt: now}
+		if err := store.SaveGoldenExample(ctx, golden); err != nil {
+			t.Fatalf("SaveGoldenExample() error = %v", err)
+		}
+		if _, err := store.InsertEvalDataset(ctx, db.EvalDataset{ID: "dataset-" + suffix, Name: "default", ExampleCount: 1, SHA256: "abc", Content: `{"answer":"secret answer"}`, CreatedAt: now}); err != nil {
+			t.Fatalf("InsertEvalDataset() error = %v", err)
+		}
+		attachment := db.Attachment{ID: "attachment-" + suffix, ChatID: "chat-1", FileName: "notes.txt", MediaType: "text/plain", SizeBytes: 12, Data: []byte("secret bytes"), ExtractedText: "secret text", CreatedAt: now}
+		if err := store.InsertAttachment(ctx, attachment); err != nil {
+			t.Fatalf("InsertAttachment() error = %v", err)
+		}
+		prompt := db.ScheduledPrompt{ID: "prompt-" + suffix, ChatID: "chat-1", Prompt: "secret schedule", Frequency: "daily", TimeOfDay: "09:00", NextRunAt: now.Add(time.Hour), CreatedAt: now}
+		if err := store.InsertScheduledPrompt(ctx, prompt); err != nil {
+			t.Fatalf("InsertScheduledPrompt() error = %v", err)
+		}
+	}
+	write("before")
+
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.ExecContext(ctx, `UPDATE runs SET request_json = '{"history":"secret legacy"}' WHERE id = 'run-before'`); err != nil {
+		t.Fatalf("set legacy request_json: %v", err)
+	}
+
+	codec, err := db.CodecFromKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", "")
+	if err != nil {
+		t.Fatalf("CodecFromKey() error = %v", err)
+	}
+	store.SetCodec(codec)
+	if _, err := store.SealPlaintext(ctx); err != nil {
+		t.Fatalf("SealPlaintext() error = %v", err)
+	}
+	write("after")
+
+	for _, column := range []string{
+		"runs.request_json",
+		"run_snapshots.data",
+		"chat_summaries.content",
+		"replay_runs.output",
+		"replay_runs.reasoning",
+		"replay_runs.tool_calls_json",
+		"replay_runs.error_text",
+		"knowledge_chunks.content",
+		"drafts.content",
+		"golden_examples.prompt_json",
+		"golden_examples.answer",
+		"eval_datasets.content",
+		"attachments.extracted_text",
+		"attachments.data",
+		"scheduled_prompts.prompt",
+	} {
+		table, name, _ := strings.Cut(column, ".")
+		rows, err := raw.QueryContext(ctx, `SELECT CAST(`+name+` AS TEXT) FROM `+table+` WHERE `+name+` IS NOT NULL`)
+		if err != nil {
+			t.Fatalf("%s: %v", column, err)
+		}
+		count := 0
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				t.Fatalf("%s: %v", column, err)
+			}
+			count++
+			if !strings.HasPrefix(value, "enc:v1:") || strings.Contains(value, "secret") {
+				t.Errorf("%s = %q, want it sealed", column, value)
+			}
+		}
+		rows.Close()
+		if count == 0 {
+			t.Errorf("%s has no values to check", column)
+		}
+	}
+
+	for _, suffix := range []string{"before", "after"} {
+		run, err := store.GetRun(ctx, "run-"+suffix)
+		if err != nil {
+			t.Fatalf("GetRun() error = %v", err)
+		}
+		request, err := service.RunSnapshot(ctx, run)
+		if err != nil || request.History[0].Content != "secret history "+suffix {
+			t.Fatalf("RunSnapshot(%s) = %+v, %v; want the opened history", suffix, request.History, err)
+		}
+		replays, err := store.ListReplayRuns(ctx, run.ID)
+		if err != nil || len(replays) != 1 || replays[0].Output != "secret output" || replays[0].ErrorText != "secret failure" {
+			t.Fatalf("ListReplayRuns(%s) = %+v, %v", suffix, replays, err)
+		}
+		if draft, err := store.GetDraft(ctx, "chat-1", "user-"+suffix); err != nil || draft != "secret draft" {
+			t.Fatalf("GetDraft(%s) = %q, %v", suffix, draft, err)
+		}
+		golden, err := store.GetGoldenExample(ctx, "assistant-"+suffix)
+		if err != nil || golden.PromptJSON != `{"prompt":"secret prompt"}` || golden.Answer != "secret answer" {
+			t.Fatalf("GetGoldenExample(%s) = %+v, %v", suffix, golden, err)
+		}
+		attachment, err := store.GetAttachment(ctx, "attachment-"+suffix)
+		if err != nil || string(attachment.Data) != "secret bytes" || attachment.ExtractedText != "secret text" {
+			t.Fatalf("GetAttachment(%s) = %+v, %v", suffix, attachment, err)
+		}
+		if prompt, err := store.GetScheduledPrompt(ctx, "prompt-"+suffix); err != nil || prompt.Prompt != "secret schedule" {
+			t.Fatalf("GetScheduledPrompt(%s) = %+v, %v", suffix, prompt, err)
+		}
+	}
+	for _, version := range []int{1, 2} {
+		dataset, err := store.GetEvalDataset(ctx, "", "default", version)
+		if err != nil || dataset.Content != `{"answer":"secret answer"}` {
+			t.Fatalf("GetEvalDataset(%d) = %+v, %v", version, dataset, err)
+		}
+	}
+	legacy, err := store.GetRun(ctx, "run-before")
+	if err != nil || legacy.RequestJSON != `{"history":"secret legacy"}` {
+		t.Fatalf("GetRun() request_json = %q, %v", legacy.RequestJSON, err)
+	}
+	summary, err := store.GetChatSummary(ctx, "chat-1")
+	if err != nil || summary.Content != "secret summary" {
+		t.Fatalf("GetChatSummary() = %+v, %v", summary, err)
+	}
+	chunks, err := store.ListKnowledgeChunks(ctx, "chat-1", "test")
+	if err != nil || len(chunks) != 2 || chunks[0].Content != "secret chunk" || chunks[1].Content != "secret chunk" {
+		t.Fatalf("ListKnowledgeChunks() = %+v, %v", chunks, err)
+	}
+}
+
+func TestProviderKeysAreStoredSealedAndSentForTheChatOwner(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	ada := auth.Principal{UserID: "ada"}
+	if _, err := service.SetProviderKey(ctx, ada, "anthropic", "sk-ant-1234"); !errors.Is(err, ErrProviderKeysDisabled) {
+		t.Fatalf("SetProviderKey() without an encryption key error = %v, want ErrProviderKeysDisabled", err)
+	}
+
+	codec, err := db.NewAESGCM([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewAESGCM() error = %v", err)
+	}
+	store.SetCodec(codec)
+	if _, err := service.SetProviderKey(ctx, ada, "nope", "sk-1234"); err == nil {
+		t.Fatal("SetProviderKey() for an unknown provider succeeded")
+	}
+	if _, err := service.SetProviderKey(ctx, auth.Principal{UserID: auth.AnonymousUserID}, "anthropic", "sk-ant-1234"); !errors.Is(err, ErrProviderKeysDisabled) {
+		t.Fatalf("SetProviderKey() as the anonymous user error = %v, want ErrProviderKeysDisabled", err)
+	}
+	keys, err := service.SetProviderKey(ctx, ada, "anthropic", " sk-ant-secret-9876 ")
+	if err != nil || len(keys) != 1 || keys[0].Provider != "anthropic" || keys[0].Hint != "9876" {
+		t.Fatalf("SetProviderKey() = %+v, %v; want the key listed by its last four characters", keys, err)
+	}
+	if stored, err := store.ProviderKeys(ctx, "ada"); err != nil || stored["anthropic"] != "sk-ant-secret-9876" {
+		t.Fatalf("ProviderKeys() = %v, %v", stored, err)
+	}
+
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "A chat", config.DefaultModel, "ada", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel}
+	if err := service.PersistRunStart(ctx, run, "Hello"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	request, err := service.PrepareRun(ctx, run)
+	if err != nil || request.StreamOptions("run-1", 0).UserID != "ada" {
+		t.Fatalf("PrepareRun() = %+v, %v; want the run sent with the owner's keys", request, err)
+	}
+
+	if keys, err := service.DeleteProviderKey(ctx, ada, "anthropic"); err != nil || len(keys) != 0 {
+		t.Fatalf("DeleteProviderKey() = %+v, %v", keys, err)
+	}
+}
+
+func TestExecuteRunChecksStructuredOutput(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	schema := json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`)
+
+	err := service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Hi", Schema: json.RawMessage(`{"type":"object","pattern":"x"}`)}, func(RunEvent) {})
+	if !errors.Is(err, ErrInvalidRun) {
+		t.Fatalf("ExecuteRun() with an unsupported schema error = %v, want ErrInvalidRun", err)
+	}
+
+	var last RunEvent
+	// The mock model echoes JSON back when asked for structured output.
+	if err := service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: `{"city": "Lyon"}`, RunID: "run-1", Schema: schema}, func(event RunEvent) {
+		last = event
+	}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if last.Status != "completed" || string(last.Structured) != `{"city":"Lyon"}` {
+		t.Fatalf("last event = %+v, want the parsed object", last)
+	}
+	receipt, err := service.RunReceipt(ctx, principal, "run-1")
+	if err != nil || string(receipt.Structured) != `{"city":"Lyon"}` {
+		t.Fatalf("RunReceipt() = %+v, %v; want the stored object", receipt, err)
+	}
+	run, err := store.GetRun(ctx, "run-1")
+	if err != nil {
+		t.Fatalf("GetRun() error = %v", err)
+	}
+	snapshot, err := service.RunSnapshot(ctx, run)
+	if err != nil || snapshot.OutputSchema == nil || snapshot.OutputSchema.Required[0] != "city" {
+		t.Fatalf("RunSnapshot() = %+v, %v; want the schema recorded for replays", snapshot, err)
+	}
+
+	if err := service.ExecuteRun(ctx, principal, APIRunRequest{ChatID: "chat-1", Content: "Where am I?", RunID: "run-2", Schema: schema}, func(event RunEvent) {
+		last = event
+	}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if last.Status != "error" || !strings.Contains(last.Error, "not valid JSON") || last.Structured != nil || last.Content == "" {
+		t.Fatalf("last event = %+v, want an error that keeps the reply", last)
+	}
+	receipt, err = service.RunReceipt(ctx, principal, "run-2")
+	if err != nil || receipt.Status != "error" || receipt.Structured != nil {
+		t.Fatalf("RunReceipt() = %+v, %v; want the error without an object", receipt, err)
+	}
+}
+
+func TestWebhookToolsAreStoredAndOfferedToTheModel(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	ctx := context.Background()
+
+	if _, err := service.SaveWebhookTool(ctx, WebhookToolInput{Name: "web_search", URL: "https://tools.example.com/search"}); !errors.Is(err, ErrToolExists) {
+		t.Fatalf("SaveWebhookTool() over a built-in error = %v, want ErrToolExists", err)
+	}
+	if _, err := service.SaveWebhookTool(ctx, WebhookToolInput{Name: "weather", URL: "tools.example.com"}); !errors.Is(err, ErrInvalidTool) {
+		t.Fatalf("SaveWebhookTool() with a relative URL error = %v, want ErrInvalidTool", err)
+	}
+	saved, err := service.SaveWebhookTool(ctx, WebhookToolInput{
+		Name:        "weather",
+		Description: "Current weather for a city.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}}}`),
+		URL:         "https://tools.example.com/weather",
+		AuthHeader:  "X-Api-Key: secret",
+	})
+	if err != nil {
+		t.Fatalf("SaveWebhookTool() error = %v", err)
+	}
+	if saved.AuthHeaderName != "X-Api-Key" || saved.CreatedAt.IsZero() {
+		t.Fatalf("SaveWebhookTool() = %+v", saved)
+	}
+	if _, err := service.SaveWebhookTool(ctx, WebhookToolInput{Name: "weather", Description: "Weather by city.", URL: "https://tools.example.com/v2/weather"}); err != nil {
+		t.Fatalf("SaveWebhookTool() replacing error = %v", err)
+	}
+	catalog, err := service.ToolCatalog(ctx, "")
+	if err != nil {
+		t.Fatalf("ToolCatalog() error = %v", err)
+	}
+	found := false
+	for _, tool := range catalog {
+		found = found || (tool.Name == "weather" && tool.Source == ai.ToolSourceWebhook && tool.Description == "Weather by city.")
+	}
+	if !found {
+		t.Fatalf("ToolCatalog() = %+v, want the replaced webhook tool", catalog)
+	}
+
+	restarted := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel})
+	if err := restarted.LoadWebhookTools(ctx); err != nil {
+		t.Fatalf("LoadWebhookTools() error = %v", err)
+	}
+	if _, ok := restarted.toolRegistry().Lookup("weather"); !ok {
+		t.Fatal("LoadWebhookTools() did not register the stored tool")
+	}
+	if err := restarted.DeleteWebhookTool(ctx, "weather"); err != nil {
+		t.Fatalf("DeleteWebhookTool() error = %v", err)
+	}
+	if _, ok := restarted.toolRegistry().Lookup("weather"); ok {
+		t.Fatal("DeleteWebhookTool() left the tool registered")
+	}
+	if tools, err := restarted.WebhookTools(ctx); err != nil || len(tools) != 0 {
+		t.Fatalf("WebhookTools() = %+v, %v; want none", tools, err)
+	}
+	if err := restarted.DeleteWebhookTool(ctx, "weather"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("DeleteWebhookTool() twice error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestProductEventsAreRecordedAnonymously(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, AnalyticsEnabled: true})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice@example.com"}
+
+	chat, err := service.CreateChat(ctx, alice, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: "oai-resp/gpt-5-mini"}
+	if err := service.PersistRunStart(ctx, run, "My secret question"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	service.RecordStop(ctx, alice, ai.MockModel)
+	if err := service.CompleteRun(ctx, run, "cancelled", StreamResult{TurnCount: 1}, ""); err != nil {
+		t.Fatalf("CompleteRun() error = %v", err)
+	}
+
+	events, err := service.Analytics().Events(ctx, analytics.Query{})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Name)
+		if event.Actor == "" || event.Actor != events[0].Actor {
+			t.Fatalf("event %s actor = %q, want alice's hash on every event", event.Name, event.Actor)
+		}
+	}
+	want := []string{analytics.RunCompleted, analytics.StopPressed, analytics.ModelSwitched, analytics.ChatCreated}
+	if !slices.Equal(names, want) {
+		t.Fatalf("events = %v, want %v", names, want)
+	}
+	if switched := events[2].Properties; switched["from"] != ai.MockModel || switched["to"] != "oai-resp/gpt-5-mini" {
+		t.Fatalf("model_switched properties = %v", switched)
+	}
+	encoded, _ := json.Marshal(events)
+	for _, private := range []string{"alice", chat.ID, "run-1", "secret", "New chat"} {
+		if strings.Contains(string(encoded), private) {
+			t.Fatalf("events %s contain %q", encoded, private)
+		}
+	}
+}
+
+// fakeMailer records the email it is asked to send.
+type fakeMailer struct {
+	sent []mail.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, message mail.Message) error {
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+func TestScheduledPromptResultsAreEmailedUntilUnsubscribed(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+		PublicURL:    "https://chat.example.com",
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: "alice"}
+	if _, err := service.SetResultsEmail(ctx, principal, "alice@example.com"); !errors.Is(err, ErrEmailDisabled) {
+		t.Fatalf("SetResultsEmail() without SMTP error = %v, want ErrEmailDisabled", err)
+	}
+	mailer := &fakeMailer{}
+	service.mailer = mailer
+	if _, err := service.SetResultsEmail(ctx, principal, "not an address"); err == nil {
+		t.Fatal("SetResultsEmail(invalid) error = nil")
+	}
+	prefs, err := service.SetResultsEmail(ctx, principal, " Alice <alice@example.com> ")
+	if err != nil || prefs.ResultsEmail != "alice@example.com" {
+		t.Fatalf("SetResultsEmail() = %+v, %v", prefs, err)
+	}
+
+	chat, err := service.CreateChat(ctx, principal, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	prompt, err := service.CreateScheduledPrompt(ctx, principal, chat.ID, "Summarize overnight news", "daily", "07:30")
+	if err != nil {
+		t.Fatalf("CreateScheduledPrompt() error = %v", err)
+	}
+	next := prompt.NextRunAt.Add(time.Minute)
+	if err := service.RunDuePrompts(ctx, next, nil); err != nil {
+		t.Fatalf("RunDuePrompts() error = %v", err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent = %+v, want one email", mailer.sent)
+	}
+	sent := mailer.sent[0]
+	if sent.To != "alice@example.com" || sent.Subject != "Scheduled prompt: "+chat.Title || !strings.Contains(sent.Body, "Summarize overnight news") {
+		t.Fatalf("email = %+v", sent)
+	}
+	token, ok := strings.CutPrefix(sent.UnsubscribeURL, "https://chat.example.com/api/unsubscribe?token=")
+	if !ok || !strings.Contains(sent.Body, "https://chat.example.com/unsubscribe/"+token) {
+		t.Fatalf("UnsubscribeURL = %q, want the same token linked from the body", sent.UnsubscribeURL)
+	}
+
+	if err := service.Unsubscribe(ctx, token+"x"); !errors.Is(err, ErrInvalidUnsubscribe) {
+		t.Fatalf("Unsubscribe(tampered) error = %v, want ErrInvalidUnsubscribe", err)
+	}
+	if err := service.Unsubscribe(ctx, token); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if prefs, err := service.Preferences(ctx, principal); err != nil || prefs.ResultsEmail != "" {
+		t.Fatalf("Preferences() after unsubscribe = %+v, %v", prefs, err)
+	}
+	prompts, _ := service.ScheduledPrompts(ctx, chat.ID)
+	if err := service.RunDuePrompts(ctx, prompts[0].NextRunAt.Add(time.Minute), nil); err != nil {
+		t.Fatalf("RunDuePrompts() error = %v", err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent = %+v, want no email after unsubscribing", mailer.sent)
+	}
+}
+
+func TestDataExportArchivesTheUsersDataUntilItExpires(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:       ai.MockModel,
+		MaxHistory:         10,
+		DataExportDir:      t.TempDir(),
+		DataExportTTL:      time.Hour,
+		AttachmentMaxBytes: 1 << 20,
+	})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	chat, err := service.CreateChat(ctx, alice, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.UploadAttachment(ctx, alice, chat.ID, "notes.txt", []byte("quarterly notes")); err != nil {
+		t.Fatalf("UploadAttachment() error = %v", err)
+	}
+	if err := service.ExecuteRun(ctx, alice, APIRunRequest{ChatID: chat.ID, Content: "Hello there", RunID: "run-1"}, func(RunEvent) {}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if err := store.SetUserSetting(ctx, "alice", timezoneSetting, "Europe/Paris", time.Now().UTC()); err != nil {
+		t.Fatalf("SetUserSetting() error = %v", err)
+	}
+	if _, err := service.CreateChat(ctx, auth.Principal{UserID: "bob"}, ai.MockModel); err != nil {
+		t.Fatalf("CreateChat(bob) error = %v", err)
+	}
+
+	export, err := service.RequestDataExport(ctx, alice)
+	if err != nil || export.Status != DataExportPending {
+		t.Fatalf("RequestDataExport() = %+v, %v", export, err)
+	}
+	if _, err := service.RequestDataExport(ctx, alice); !errors.Is(err, ErrExportInProgress) {
+		t.Fatalf("RequestDataExport() again error = %v, want ErrExportInProgress", err)
+	}
+	if _, _, err := service.OpenDataExport(ctx, alice, export.ID); !errors.Is(err, ErrExportNotReady) {
+		t.Fatalf("OpenDataExport() before the job error = %v, want ErrExportNotReady", err)
+	}
+	now := time.Now().UTC()
+	if err := service.BuildDataExports(ctx, now, nil); err != nil {
+		t.Fatalf("BuildDataExports() error = %v", err)
+	}
+	if _, err := service.DataExport(ctx, auth.Principal{UserID: "bob"}, export.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("DataExport() of another user's export error = %v, want ErrNotFound", err)
+	}
+	export, file, err := service.OpenDataExport(ctx, alice, export.ID)
+	if err != nil {
+		t.Fatalf("OpenDataExport() error = %v", err)
+	}
+	defer file.Close()
+	if export.Status != DataExportReady || export.SizeBytes == 0 {
+		t.Fatalf("export = %+v, want ready with a size", export)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || int64(len(data)) != export.SizeBytes {
+		t.Fatalf("read data export = %d bytes, %v; want %d", len(data), err, export.SizeBytes)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), export.SizeBytes)
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	entries := map[string]string{}
+	for _, entry := range archive.File {
+		reader, err := entry.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", entry.Name, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		entries[entry.Name] = string(data)
+	}
+	dir := "chats/" + chat.ID + "/"
+	if !strings.Contains(entries["account.json"], `"timezone": "Europe/Paris"`) || !strings.Contains(entries["account.json"], chat.ID) {
+		t.Fatalf("account.json = %s", entries["account.json"])
+	}
+	if len(entries) != 5 || !strings.Contains(entries[dir+"messages.json"], "Hello there") || !strings.Contains(entries[dir+"runs.json"], `"id": "run-1"`) {
+		t.Fatalf("entries = %v, want alice's one chat", slices.Sorted(maps.Keys(entries)))
+	}
+	var exported exportedChat
+	if err := json.Unmarshal([]byte(entries[dir+"chat.json"]), &exported); err != nil || len(exported.Attachments) != 1 {
+		t.Fatalf("chat.json = %s, %v", entries[dir+"chat.json"], err)
+	}
+	if entries[exported.Attachments[0].Path] != "quarterly notes" {
+		t.Fatalf("attachment %s = %q", exported.Attachments[0].Path, entries[exported.Attachments[0].Path])
+	}
+
+	if err := service.BuildDataExports(ctx, now.Add(2*time.Hour), nil); err != nil {
+		t.Fatalf("BuildDataExports() after expiry error = %v", err)
+	}
+	if _, err := service.DataExport(ctx, alice, export.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("DataExport() after expiry error = %v, want ErrNotFound", err)
+	}
+	if _, err := service.exports.Get(ctx, dataExportKey(export.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("archive after expiry: %v, want removed", err)
+	}
+}
+
+func TestDeleteAllDataRemovesOnlyTheUsersData(t *testing.T) {
+	store := newTestStore(t)
+	attachmentsDir := t.TempDir()
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:       ai.MockModel,
+		MaxHistory:         10,
+		AttachmentsDir:     attachmentsDir,
+		AttachmentMaxBytes: 1 << 20,
+		DataExportDir:      t.TempDir(),
+		DataExportTTL:      time.Hour,
+	})
+	ctx := context.Background()
+	alice, bob := auth.Principal{UserID: "alice"}, auth.Principal{UserID: "bob"}
+	chat, err := service.CreateChat(ctx, alice, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.UploadAttachment(ctx, alice, chat.ID, "notes.txt", []byte("quarterly notes")); err != nil {
+		t.Fatalf("UploadAttachment() error = %v", err)
+	}
+	if err := service.ExecuteRun(ctx, alice, APIRunRequest{ChatID: chat.ID, Content: "Hello there", RunID: "run-1"}, func(RunEvent) {}); err != nil {
+		t.Fatalf("ExecuteRun() error = %v", err)
+	}
+	if _, err := service.SetTimezone(ctx, alice, "Europe/Paris"); err != nil {
+		t.Fatalf("SetTimezone() error = %v", err)
+	}
+	export, err := service.RequestDataExport(ctx, alice)
+	if err != nil {
+		t.Fatalf("RequestDataExport() error = %v", err)
+	}
+	if err := service.BuildDataExports(ctx, time.Now().UTC(), nil); err != nil {
+		t.Fatalf("BuildDataExports() error = %v", err)
+	}
+	bobChat, err := service.CreateChat(ctx, bob, ai.MockModel)
+	if err != nil {
+		t.Fatalf("CreateChat(bob) error = %v", err)
+	}
+	if _, err := service.SetTimezone(ctx, bob, "Europe/Madrid"); err != nil {
+		t.Fatalf("SetTimezone(bob) error = %v", err)
+	}
+
+	deleted, err := service.DeleteAllData(ctx, alice)
+	if err != nil {
+		t.Fatalf("DeleteAllData() error = %v", err)
+	}
+	if deleted.Chats != 1 || deleted.Messages != 2 || deleted.Runs != 1 || deleted.Attachments != 1 {
+		t.Fatalf("deleted = %+v, want alice's chat, two messages, a run and an attachment", deleted)
+	}
+	if _, err := store.GetChat(ctx, chat.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetChat() after deletion error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetRun(ctx, "run-1"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetRun() after deletion error = %v, want ErrNotFound", err)
+	}
+	if prefs, err := service.Preferences(ctx, alice); err != nil || prefs.Timezone == "Europe/Paris" {
+		t.Fatalf("Preferences() after deletion = %+v, %v", prefs, err)
+	}
+	if _, err := service.DataExport(ctx, alice, export.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("DataExport() after deletion error = %v, want ErrNotFound", err)
+	}
+	if _, err := service.exports.Get(ctx, dataExportKey(export.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("export archive after deletion: %v, want removed", err)
+	}
+	if files, _ := os.ReadDir(attachmentsDir); len(files) != 0 {
+		t.Fatalf("attachments dir = %v, want empty", files)
+	}
+
+	if _, err := store.GetChat(ctx, bobChat.ID); err != nil {
+		t.Fatalf("GetChat(bob) error = %v", err)
+	}
+	if prefs, err := service.Preferences(ctx, bob); err != nil || prefs.Timezone != "Europe/Madrid" {
+		t.Fatalf("Preferences(bob) = %+v, %v", prefs, err)
+	}
+}
+
+func TestRetentionDeletesIdleChatsAndPurgesToolPayloads(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:          config.DefaultModel,
+		RetentionChatIdle:     30 * 24 * time.Hour,
+		RetentionToolPayloads: 7 * 24 * time.Hour,
+	})
+	ctx := context.Background()
+	owner := auth.Principal{UserID: "user-1"}
+	now := time.Now().UTC()
+	idle, err := store.CreateOwnedChat(ctx, "chat-idle", "Idle", config.DefaultModel, "user-1", now.Add(-40*24*time.Hour))
+	if err != nil {
+		t.Fatalf("CreateOwnedChat(idle) error = %v", err)
+	}
+	kept, err := store.CreateOwnedChat(ctx, "chat-kept", "Kept", config.DefaultModel, "user-1", now.Add(-40*24*time.Hour))
+	if err != nil {
+		t.Fatalf("CreateOwnedChat(kept) error = %v", err)
+	}
+	if _, err := service.SetChatKeepForever(ctx, owner, kept.ID, true); err != nil {
+		t.Fatalf("SetChatKeepForever() error = %v", err)
+	}
+	active, err := store.CreateOwnedChat(ctx, "chat-active", "Active", config.DefaultModel, "user-1", now)
+	if err != nil {
+		t.Fatalf("CreateOwnedChat(active) error = %v", err)
+	}
+	for _, chatID := range []string{active.ID, kept.ID} {
+		run := PendingRun{RunID: "run-" + chatID, ChatID: chatID, UserMessageID: "user-" + chatID, AssistantMessageID: "assistant-" + chatID, Model: config.DefaultModel}
+		if err := service.PersistRunStart(ctx, run, "Look it up"); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		callID, err := service.UpsertToolStart(ctx, run.RunID, ToolCallUpdate{ID: "call-" + chatID, Name: "fetch_url", Input: `{"url":"https://example.com"}`})
+		if err != nil {
+			t.Fatalf("UpsertToolStart() error = %v", err)
+		}
+		if err := service.CompleteTool(ctx, callID, ToolCallUpdate{ID: "call-" + chatID, Output: `{"type":"text","text":"page"}`}); err != nil {
+			t.Fatalf("CompleteTool() error = %v", err)
+		}
+	}
+
+	// Ten days on, the active chat's tool call is past the payload window
+	// but the chat itself is not yet idle for long enough.
+	if err := service.EnforceRetention(ctx, now.Add(10*24*time.Hour), nil); err != nil {
+		t.Fatalf("EnforceRetention() error = %v", err)
+	}
+	if _, err := store.GetChat(ctx, idle.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetChat(idle) error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.GetChat(ctx, kept.ID); err != nil {
+		t.Fatalf("GetChat(kept) error = %v", err)
+	}
+	calls, err := store.ListChatToolCalls(ctx, active.ID)
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("ListChatToolCalls(active) = %+v, %v", calls, err)
+	}
+	if calls[0].InputJSON != "" || calls[0].OutputJSON != "" || calls[0].Name != "fetch_url" {
+		t.Fatalf("active tool call = %+v, want its payloads cleared", calls[0])
+	}
+	calls, err = store.ListChatToolCalls(ctx, kept.ID)
+	if err != nil || len(calls) != 1 || calls[0].InputJSON == "" || calls[0].OutputJSON == "" {
+		t.Fatalf("ListChatToolCalls(kept) = %+v, %v, want payloads kept", calls, err)
+	}
+}
+
+func TestStoreReadsWhileAWriteIsInProgress(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	chat, err := store.CreateChat(ctx, "chat-1", "Committed", config.DefaultModel, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+
+	// Hold the writer the way a long streaming transaction would, and read
+	// from inside it.
+	err = store.Transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `UPDATE chats SET title = 'Uncommitted' WHERE id = ?`, chat.ID); err != nil {
+			return err
+		}
+		readCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		chats, err := store.ListChats(readCtx, "", false, db.ChatCursor{}, 10)
+		if err != nil || len(chats) != 1 || chats[0].Title != "Committed" {
+			return fmt.Errorf("ListChats() during a write = %+v, %v, want the committed chat", chats, err)
+		}
+		if _, err := store.ListMessages(readCtx, chat.ID, 10); err != nil {
+			return fmt.Errorf("ListMessages() during a write: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func newTestStore(t *testing.T) *db.Store {
 	t.Helper()
 	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
@@ -95,3 +2504,686 @@ func newTestService(store *db.Store) *Service {
 		SystemPrompt: "You are helpful.",
 	})
 }
+
+func TestKnowledgeBaseChunksAreRetrievedIntoSystemPrompt(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:       config.DefaultModel,
+		MaxHistory:         30,
+		SystemPrompt:       "You are helpful.",
+		AttachmentMaxBytes: 1 << 20,
+		RAGEmbedder:        "hash",
+		RAGChunkBytes:      100,
+		RAGTopK:            1,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	handbook := "Expense reports are due on the fifth business day of each month.\n\n" +
+		"The staging database is refreshed from production every Sunday night."
+	document, err := service.UploadKnowledge(ctx, auth.Principal{}, "chat-1", "handbook.txt", []byte(handbook))
+	if err != nil {
+		t.Fatalf("UploadKnowledge() error = %v", err)
+	}
+	if _, err := service.UploadKnowledge(ctx, auth.Principal{}, "chat-1", "image.png", []byte("\x89PNG\r\n\x1a\n")); !errors.Is(err, ErrKnowledgeType) {
+		t.Fatalf("UploadKnowledge(png) error = %v, want ErrKnowledgeType", err)
+	}
+
+	err = service.PersistRunStart(ctx, PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}, "When is the staging database refreshed?")
+	if err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	system := history[0].Content
+	if !strings.Contains(system, `[Source "handbook.txt", part 2]`) || !strings.Contains(system, "every Sunday night") || strings.Contains(system, "Expense reports") {
+		t.Fatalf("system prompt = %q, want only the staging chunk", system)
+	}
+
+	if err := service.RemoveKnowledge(ctx, auth.Principal{}, "chat-1", document.ID); err != nil {
+		t.Fatalf("RemoveKnowledge() error = %v", err)
+	}
+	history, err = service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	if history[0].Content != "You are helpful." {
+		t.Fatalf("system prompt after removal = %q", history[0].Content)
+	}
+}
+
+func TestSystemPromptFollowsLanguagePreference(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel: config.DefaultModel,
+		MaxHistory:   30,
+		SystemPrompt: "You are helpful.",
+		SystemPrompts: map[string]string{
+			"fr":    "Tu es un assistant utile.",
+			"pt-br": "Você é um assistente útil.",
+		},
+	})
+	cases := map[string]string{
+		"fr-CA,fr;q=0.9,en;q=0.8":  "fr",
+		"en-US,pt-BR;q=0.9":        "",
+		"de;q=0.5, pt-BR;q=0.8, *": "pt-br",
+		"fr;q=0, en":               "",
+		"":                         "",
+	}
+	for header, want := range cases {
+		if got := service.ResolveLocale(header); got != want {
+			t.Errorf("ResolveLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	for locale, want := range map[string]string{"fr": "Tu es un assistant utile.", "": "You are helpful.", "es": "You are helpful."} {
+		history, err := service.BuildHistory(ctx, "chat-1", locale)
+		if err != nil {
+			t.Fatalf("BuildHistory() error = %v", err)
+		}
+		if history[0].Content != want {
+			t.Errorf("BuildHistory(locale %q) system prompt = %q, want %q", locale, history[0].Content, want)
+		}
+	}
+}
+
+func TestHistoryOverflowIsSummarizedIncrementally(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:   config.DefaultModel,
+		MaxHistory:     4,
+		SystemPrompt:   "You are helpful.",
+		SummaryEnabled: true,
+		SummaryModel:   ai.MockModel,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	turn := func(n int) {
+		t.Helper()
+		run := PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             "chat-1",
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              config.DefaultModel,
+		}
+		if err := service.PersistRunStart(ctx, run, fmt.Sprintf("Question %d", n)); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		if _, err := service.CompleteAssistant(ctx, run.AssistantMessageID, fmt.Sprintf("Answer %d", n), "completed"); err != nil {
+			t.Fatalf("CompleteAssistant() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	for n := 1; n <= 3; n++ {
+		turn(n)
+	}
+
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	if len(history) != 6 || history[1].Role != "system" || !strings.Contains(history[1].Content, "Question 1") {
+		t.Fatalf("history = %+v, want the system prompt, a summary of turn 1 and the last 4 messages", history)
+	}
+	first, err := store.GetChatSummary(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("GetChatSummary() error = %v", err)
+	}
+	if _, err := service.BuildHistory(ctx, "chat-1", ""); err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	if again, err := store.GetChatSummary(ctx, "chat-1"); err != nil || !again.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Fatalf("GetChatSummary() = %+v, %v; want the cached summary reused", again, err)
+	}
+
+	turn(4)
+	history, err = service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	summary := history[1].Content
+	if !strings.Contains(summary, "Previous summary") || !strings.Contains(summary, "Question 2") || strings.Contains(summary, "Question 3") {
+		t.Fatalf("summary = %q, want the previous summary extended with turn 2 only", summary)
+	}
+}
+
+func TestQuotaStatusWarnsBeforeRateLimit(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:         config.DefaultModel,
+		MaxHistory:           30,
+		RateLimitRunsPerHour: 3,
+		RateLimitWarnPercent: 40,
+	})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	chat, err := service.CreateChat(ctx, alice, config.DefaultModel)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := func(n int) {
+		t.Helper()
+		err := service.PersistRunStart(ctx, PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             chat.ID,
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              config.DefaultModel,
+		}, "Hello")
+		if err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+	}
+
+	run(1)
+	status, err := service.QuotaStatus(ctx, alice)
+	if err != nil {
+		t.Fatalf("QuotaStatus() error = %v", err)
+	}
+	if !status.Limited || status.Near || len(status.Windows) != 1 || status.Windows[0].Remaining != 2 || status.Windows[0].ResetsAt.IsZero() {
+		t.Fatalf("QuotaStatus() after 1 run = %+v, want 2 remaining and no warning", status)
+	}
+	run(2)
+	if status, _ := service.QuotaStatus(ctx, alice); !status.Near || status.Exhausted {
+		t.Fatalf("QuotaStatus() after 2 runs = %+v, want a warning", status)
+	}
+	if err := service.CheckRunQuota(ctx, alice); err != nil {
+		t.Fatalf("CheckRunQuota() with 1 left error = %v", err)
+	}
+	run(3)
+	if err := service.CheckRunQuota(ctx, alice); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("CheckRunQuota() error = %v, want ErrRateLimited", err)
+	}
+	if err := service.CheckRunQuota(ctx, auth.Principal{UserID: "bob"}); err != nil {
+		t.Fatalf("CheckRunQuota(bob) error = %v, want other users unaffected", err)
+	}
+}
+
+func TestHistoryIsTrimmedToModelContextWindow(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{
+		DefaultModel:          config.DefaultModel,
+		MaxHistory:            30,
+		SystemPrompt:          "You are helpful.",
+		ResponseReserveTokens: 2048,
+	})
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	send := func(n int, content string, answered bool) {
+		t.Helper()
+		run := PendingRun{
+			RunID:              fmt.Sprintf("run-%d", n),
+			ChatID:             "chat-1",
+			UserMessageID:      fmt.Sprintf("user-%d", n),
+			AssistantMessageID: fmt.Sprintf("assistant-%d", n),
+			Model:              ai.MockModel,
+		}
+		if err := service.PersistRunStart(ctx, run, content); err != nil {
+			t.Fatalf("PersistRunStart() error = %v", err)
+		}
+		if !answered {
+			return
+		}
+		if _, err := service.CompleteAssistant(ctx, run.AssistantMessageID, fmt.Sprintf("Answer %d", n), "completed"); err != nil {
+			t.Fatalf("CompleteAssistant() error = %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	for n := 1; n <= 3; n++ {
+		send(n, fmt.Sprintf("Question %d ", n)+strings.Repeat("lorem ipsum ", 500), true)
+	}
+	budget := ai.ContextWindow(ai.MockModel) - 2048
+
+	history, err := service.BuildHistory(ctx, "chat-1", "")
+	if err != nil {
+		t.Fatalf("BuildHistory() error = %v", err)
+	}
+	total := 0
+	for _, message := range history {
+		total += ai.EstimateMessageTokens(message)
+		if strings.HasPrefix(message.Content, "Question 1 ") {
+			t.Fatalf("history kept the oldest long message; want it trimmed")
+		}
+	}
+	if total > budget || len(history) < 3 {
+		t.Fatalf("history has %d messages using %d tokens, want recent messages within %d", len(history), total, budget)
+	}
+
+	// History is built for a run whose reply has not started yet. A message
+	// too long on its own is refused rather than cut.
+	draft := "Question 4 " + strings.Repeat("x", 80000)
+	if usage := service.DraftContextUsage(ai.MockModel, "", draft); usage.Fits() || usage.ContextWindow != ai.ContextWindow(ai.MockModel) {
+		t.Fatalf("DraftContextUsage() = %+v, want the draft over the window", usage)
+	}
+	send(4, draft, false)
+	if _, err := service.BuildHistory(ctx, "chat-1", ""); !errors.Is(err, ErrContextOverflow) || !strings.Contains(err.Error(), "8192 tokens") {
+		t.Fatalf("BuildHistory() error = %v, want ErrContextOverflow with the window", err)
+	}
+	if _, err := service.PrepareRun(ctx, PendingRun{RunID: "run-4", ChatID: "chat-1", UserMessageID: "user-4", Model: ai.MockModel}); !errors.Is(err, ErrContextOverflow) {
+		t.Fatalf("PrepareRun() error = %v, want ErrContextOverflow", err)
+	}
+}
+
+func TestToolSourcesArePersistedPerAssistantMessage(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	run := PendingRun{
+		RunID:              "run-1",
+		ChatID:             "chat-1",
+		UserMessageID:      "user-1",
+		AssistantMessageID: "assistant-1",
+		Model:              config.DefaultModel,
+	}
+	if err := service.PersistRunStart(ctx, run, "What changed?"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	outputs := map[string]string{
+		"call-fetch":  `{"type":"text","text":"URL: https://example.com/changelog\nTitle: Changelog\n\nv2 shipped"}`,
+		"call-search": `{"type":"web_search_tool_result","content":[{"type":"web_search_result","url":"https://news.example/v2","title":"v2 is out"},{"type":"web_search_result","url":"https://example.com/changelog","title":"Changelog"}]}`,
+		"call-failed": `{"type":"text","text":"URL: https://broken.example\n\n"}`,
+	}
+	for _, id := range []string{"call-fetch", "call-search", "call-failed"} {
+		callID, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: id, Name: "fetch_url", Input: "{}"})
+		if err != nil {
+			t.Fatalf("UpsertToolStart() error = %v", err)
+		}
+		update := ToolCallUpdate{ID: id, Output: outputs[id]}
+		if id == "call-failed" {
+			update.Status = "error"
+		}
+		if err := service.CompleteTool(ctx, callID, update); err != nil {
+			t.Fatalf("CompleteTool() error = %v", err)
+		}
+	}
+	if _, err := service.CompleteAssistant(ctx, "assistant-1", "v2 shipped.", "completed"); err != nil {
+		t.Fatalf("CompleteAssistant() error = %v", err)
+	}
+	if err := service.CompleteRun(ctx, run, "completed", StreamResult{}, ""); err != nil {
+		t.Fatalf("CompleteRun() error = %v", err)
+	}
+
+	receipt, err := service.RunReceipt(ctx, principal, "run-1")
+	if err != nil {
+		t.Fatalf("RunReceipt() error = %v", err)
+	}
+	sources := receipt.Sources
+	if len(sources) != 2 || sources[0].URL != "https://example.com/changelog" || sources[0].ToolCallID != "call-fetch" ||
+		sources[1].Title != "v2 is out" || sources[1].RetrievedAt.IsZero() {
+		t.Fatalf("receipt sources = %+v, want the changelog then the news page", sources)
+	}
+	bySource, err := service.ChatSources(ctx, "chat-1")
+	if err != nil || len(bySource) != 1 || len(bySource["assistant-1"]) != 2 {
+		t.Fatalf("ChatSources() = %+v, %v; want two sources on assistant-1", bySource, err)
+	}
+	found, err := service.SearchChat(ctx, "chat-1", "news.example")
+	if err != nil || len(found) != 1 || found[0].ID != "assistant-1" {
+		t.Fatalf("SearchChat(source url) = %+v, %v; want assistant-1", found, err)
+	}
+}
+
+func TestToolCallDetailReturnsTheStoredCallPrettyPrinted(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	owner := auth.Principal{UserID: "user-1"}
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "A chat", config.DefaultModel, "user-1", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel}
+	if err := service.PersistRunStart(ctx, run, "Look it up"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	callID, err := service.UpsertToolStart(ctx, "run-1", ToolCallUpdate{ID: "call-1", Name: "fetch_url", Input: `{"url":"https://example.com"}`})
+	if err != nil {
+		t.Fatalf("UpsertToolStart() error = %v", err)
+	}
+	output := `{"type":"text","text":"` + strings.Repeat("x", 1000) + `"}`
+	if err := service.CompleteTool(ctx, callID, ToolCallUpdate{ID: "call-1", Output: output}); err != nil {
+		t.Fatalf("CompleteTool() error = %v", err)
+	}
+
+	detail, err := service.ToolCallDetail(ctx, owner, callID)
+	if err != nil {
+		t.Fatalf("ToolCallDetail() error = %v", err)
+	}
+	if detail.Name != "fetch_url" || detail.Status != "completed" || detail.ChatID != chat.ID || detail.FinishedAt == nil {
+		t.Fatalf("ToolCallDetail() = %+v", detail)
+	}
+	if detail.Input != "{\n  \"url\": \"https://example.com\"\n}" {
+		t.Fatalf("Input = %q, want it indented", detail.Input)
+	}
+	if !strings.Contains(detail.Output, strings.Repeat("x", 1000)) {
+		t.Fatalf("Output = %q, want the whole stored output", detail.Output)
+	}
+	if _, err := service.ToolCallDetail(ctx, auth.Principal{UserID: "user-2"}, callID); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("ToolCallDetail() as another user error = %v, want ErrChatForbidden", err)
+	}
+	if PrettyJSON(`{"cut": "sho`) != `{"cut": "sho` {
+		t.Fatal("PrettyJSON() changed truncated JSON")
+	}
+}
+
+func TestRawMessageReturnsStoredMarkdownToTheChatOwner(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	owner := auth.Principal{UserID: "user-1"}
+	chat, err := store.CreateOwnedChat(ctx, "chat-1", "A chat", config.DefaultModel, "user-1", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+	run := PendingRun{RunID: "run-1", ChatID: chat.ID, UserMessageID: "user-1", AssistantMessageID: "assistant-1", Model: config.DefaultModel}
+	if err := service.PersistRunStart(ctx, run, "Show me"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	content := "Here:\n\n```go\nfmt.Println(\"<hi>\")\n```\n"
+	if err := service.UpdateAssistantPartial(ctx, "assistant-1", content); err != nil {
+		t.Fatalf("UpdateAssistantPartial() error = %v", err)
+	}
+	if err := service.FlushPartials(ctx); err != nil {
+		t.Fatalf("FlushPartials() error = %v", err)
+	}
+
+	raw, err := service.RawMessage(ctx, owner, " assistant-1 ")
+	if err != nil {
+		t.Fatalf("RawMessage() error = %v", err)
+	}
+	if raw.Content != content || raw.Role != "assistant" || raw.ChatID != chat.ID {
+		t.Fatalf("RawMessage() = %+v", raw)
+	}
+	if _, err := service.RawMessage(ctx, auth.Principal{UserID: "user-2"}, "assistant-1"); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("RawMessage() as another user error = %v, want ErrChatForbidden", err)
+	}
+	if _, err := service.RawMessage(ctx, owner, "missing"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("RawMessage() for a missing message error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPreviewRunShowsTheRequestWithoutStoringAnything(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel:              ai.MockModel,
+		MaxHistory:                30,
+		SystemPrompt:              "You are helpful.",
+		AttachmentMaxBytes:        1024,
+		AttachmentTextMaxBytes:    1024,
+		AttachmentHistoryMaxBytes: 4096,
+		AttachmentsDir:            t.TempDir(),
+	})
+	ctx := context.Background()
+	principal := auth.Principal{UserID: auth.AnonymousUserID}
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", ai.MockModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.UploadAttachment(ctx, principal, "chat-1", "notes.txt", []byte("the launch is on friday")); err != nil {
+		t.Fatalf("UploadAttachment() error = %v", err)
+	}
+
+	preview, err := service.PreviewRun(ctx, principal, "chat-1", "When is the launch?", "", "")
+	if err != nil {
+		t.Fatalf("PreviewRun() error = %v", err)
+	}
+	if preview.Model != ai.MockModel || !strings.Contains(preview.System, "You are helpful.") {
+		t.Fatalf("PreviewRun() = %+v, want the mock model and the system prompt", preview)
+	}
+	if len(preview.Messages) != 1 || !strings.Contains(preview.Messages[0].Content, "When is the launch?") ||
+		!strings.Contains(preview.Messages[0].Content, "the launch is on friday") {
+		t.Fatalf("PreviewRun() messages = %+v, want the draft with its pending document", preview.Messages)
+	}
+	if preview.EstimatedInputTokens <= preview.Messages[0].EstimatedTokens || !strings.Contains(string(preview.Request), "When is the launch?") {
+		t.Fatalf("PreviewRun() tokens = %d, request = %s", preview.EstimatedInputTokens, preview.Request)
+	}
+
+	if rows, err := store.ListMessages(ctx, "chat-1", 10); err != nil || len(rows) != 0 {
+		t.Fatalf("ListMessages() = %+v, %v; want nothing stored", rows, err)
+	}
+	if pending, _ := service.PendingAttachments(ctx, "chat-1"); len(pending) != 1 {
+		t.Fatalf("PendingAttachments() = %+v, want the upload still pending", pending)
+	}
+	if _, err := service.PreviewRun(ctx, principal, "chat-1", "  ", "", ""); err == nil {
+		t.Fatal("PreviewRun() of an empty draft succeeded")
+	}
+}
+
+func TestPreferencesAreValidatedAndStoredPerUser(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+
+	prefs, err := service.SetTimezone(ctx, alice, " Europe/Paris ")
+	if err != nil || prefs.Timezone != "Europe/Paris" {
+		t.Fatalf("SetTimezone() = %+v, %v; want Europe/Paris", prefs, err)
+	}
+	if prefs.Location().String() != "Europe/Paris" {
+		t.Fatalf("Location() = %s, want Europe/Paris", prefs.Location())
+	}
+	if other, err := service.Preferences(ctx, auth.Principal{UserID: "bob"}); err != nil || other.Timezone != "" || other.Location() != time.UTC {
+		t.Fatalf("Preferences() for another user = %+v, %v; want the defaults", other, err)
+	}
+	if _, err := service.SetTimezone(ctx, alice, "Mars/Olympus"); err == nil {
+		t.Fatal("SetTimezone() accepted an unknown timezone")
+	}
+	if prefs, err := service.SetTheme(ctx, alice, "acme"); err != nil || prefs.Theme != "acme" || prefs.Timezone != "Europe/Paris" {
+		t.Fatalf("SetTheme() = %+v, %v; want acme alongside the timezone", prefs, err)
+	}
+	if prefs, err := service.SetTimezone(ctx, alice, ""); err != nil || prefs.Timezone != "" {
+		t.Fatalf("SetTimezone(\"\") = %+v, %v; want it cleared", prefs, err)
+	}
+	if prefs, err := service.SetLanguage(ctx, alice, "FR"); err != nil || prefs.Language != "fr" || prefs.Localizer("en").Lang() != "fr" {
+		t.Fatalf("SetLanguage() = %+v, %v; want fr", prefs, err)
+	}
+	if _, err := service.SetLanguage(ctx, alice, "tlh"); err == nil {
+		t.Fatal("SetLanguage() accepted a language without a bundle")
+	}
+	if prefs, err := service.SetLanguage(ctx, alice, ""); err != nil || prefs.Localizer("es").Lang() != "es" {
+		t.Fatalf("SetLanguage(\"\") = %+v, %v; want the browser's language", prefs, err)
+	}
+}
+
+func TestDraftsAreKeptPerChatAndUser(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	now := time.Now().UTC()
+	for _, chatID := range []string{"chat-1", "chat-2"} {
+		if _, err := store.CreateOwnedChat(ctx, chatID, "A chat", config.DefaultModel, "alice", now); err != nil {
+			t.Fatalf("CreateOwnedChat() error = %v", err)
+		}
+	}
+
+	if err := service.SaveDraft(ctx, alice, "chat-1", "half a thought"); err != nil {
+		t.Fatalf("SaveDraft() error = %v", err)
+	}
+	if err := service.SaveDraft(ctx, alice, "chat-1", "half a thought, finished"); err != nil {
+		t.Fatalf("SaveDraft() error = %v", err)
+	}
+	if draft, err := service.Draft(ctx, alice, "chat-1"); err != nil || draft != "half a thought, finished" {
+		t.Fatalf("Draft(chat-1) = %q, %v; want the latest text", draft, err)
+	}
+	if draft, err := service.Draft(ctx, alice, "chat-2"); err != nil || draft != "" {
+		t.Fatalf("Draft(chat-2) = %q, %v; want none", draft, err)
+	}
+	if err := service.SaveDraft(ctx, auth.Principal{UserID: "bob"}, "chat-1", "mine now"); !errors.Is(err, ErrChatForbidden) {
+		t.Fatalf("SaveDraft() by another user error = %v, want ErrChatForbidden", err)
+	}
+	if err := service.SaveDraft(ctx, alice, "chat-1", strings.Repeat("x", maxDraftBytes+1)); err == nil {
+		t.Fatal("SaveDraft() accepted an oversized draft")
+	}
+
+	if err := service.SaveDraft(ctx, alice, "chat-1", "  \n"); err != nil {
+		t.Fatalf("SaveDraft(blank) error = %v", err)
+	}
+	if _, err := store.GetDraft(ctx, "chat-1", "alice"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("GetDraft() after clearing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListChatsPagesWithoutOverlapWhileChatsChange(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, nil, config.Config{DefaultModel: config.DefaultModel})
+	ctx := context.Background()
+	alice := auth.Principal{UserID: "alice"}
+	base := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	// chat-3 and chat-4 share an update time; the ID breaks the tie.
+	for index, at := range []time.Time{base, base.Add(time.Minute), base.Add(2 * time.Minute), base.Add(3 * time.Minute), base.Add(3 * time.Minute)} {
+		if _, err := store.CreateOwnedChat(ctx, fmt.Sprintf("chat-%d", index), "A chat", config.DefaultModel, "alice", at); err != nil {
+			t.Fatalf("CreateOwnedChat() error = %v", err)
+		}
+	}
+	if _, err := store.CreateOwnedChat(ctx, "bobs", "Not yours", config.DefaultModel, "bob", base.Add(time.Hour)); err != nil {
+		t.Fatalf("CreateOwnedChat() error = %v", err)
+	}
+
+	first, err := service.ListChats(ctx, alice, "", 2)
+	if err != nil {
+		t.Fatalf("ListChats() error = %v", err)
+	}
+	if !first.HasMore || first.NextCursor == "" || len(first.Chats) != 2 || first.Chats[0].ID != "chat-4" || first.Chats[1].ID != "chat-3" {
+		t.Fatalf("first page = %+v", first)
+	}
+	// Renaming bumps chat-0 to the top; it must not reappear in later pages.
+	if err := service.RenameChat(ctx, "chat-0", "Bumped"); err != nil {
+		t.Fatalf("RenameChat() error = %v", err)
+	}
+	var ids []string
+	for cursor := first.NextCursor; ; {
+		page, err := service.ListChats(ctx, alice, cursor, 2)
+		if err != nil {
+			t.Fatalf("ListChats() error = %v", err)
+		}
+		for _, chat := range page.Chats {
+			ids = append(ids, chat.ID)
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if !slices.Equal(ids, []string{"chat-2", "chat-1"}) {
+		t.Fatalf("later pages = %v, want chat-2, chat-1", ids)
+	}
+	if _, err := service.ListChats(ctx, alice, "not a cursor", 2); err == nil {
+		t.Fatal("ListChats() accepted a malformed cursor")
+	}
+}
+
+func TestStreamFailureSaysWhatToDoAboutProviderErrors(t *testing.T) {
+	service := newTestService(newTestStore(t))
+	err := fmt.Errorf("run: %w", &ai.StreamError{Model: "anthropic/claude-sonnet-4-5", Stage: "process", Kind: ai.ErrAuth, Err: errors.New("authentication_error: invalid x-api-key")})
+	kind, message := service.StreamFailure("anthropic/claude-sonnet-4-5", err)
+	if kind != ai.ErrorKindAuth || !strings.Contains(message, "ANTHROPIC_API_KEY") || !strings.HasSuffix(message, err.Error()) {
+		t.Fatalf("StreamFailure() = %q, %q; want an auth failure naming the key and keeping the provider error", kind, message)
+	}
+	if RetryableStopReason(kind) {
+		t.Fatal("RetryableStopReason(auth) = true, want a rejected key not retried")
+	}
+
+	kind, message = service.StreamFailure("openai/gpt-5", &ai.StreamError{Kind: ai.ErrRateLimited, RetryAfter: 20 * time.Second, Err: errors.New("429")})
+	if kind != ai.ErrorKindRateLimited || !strings.Contains(message, "Retry in 20s") || !RetryableStopReason(kind) {
+		t.Fatalf("StreamFailure(rate limited) = %q, %q", kind, message)
+	}
+	if kind, message := service.StreamFailure("openai/gpt-5", errors.New("connection reset")); kind != "" || message != "connection reset" {
+		t.Fatalf("StreamFailure(unclassified) = %q, %q; want the error as is", kind, message)
+	}
+}
+
+func TestPersistRunStartRefusesAResentIdempotencyKey(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	if _, err := store.CreateChat(ctx, "chat-1", "A chat", config.DefaultModel, time.Now().UTC()); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	send := func(n string) error {
+		return service.PersistRunStart(ctx, PendingRun{
+			RunID:              "run-" + n,
+			ChatID:             "chat-1",
+			UserMessageID:      "user-" + n,
+			AssistantMessageID: "assistant-" + n,
+			Model:              config.DefaultModel,
+			IdempotencyKey:     "key-1",
+		}, "Hello")
+	}
+	if err := send("1"); err != nil {
+		t.Fatalf("PersistRunStart() error = %v", err)
+	}
+	var exists *RunExistsError
+	if err := send("2"); !errors.As(err, &exists) || exists.RunID != "run-1" || !errors.Is(err, ErrRunExists) {
+		t.Fatalf("PersistRunStart(same key) = %v, want a RunExistsError for run-1", err)
+	}
+	messages, err := store.ListMessages(ctx, "chat-1", 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want the first send's user message and reply only", len(messages))
+	}
+}
+
+func TestRegenerateTitleRenamesTheChatAfterItsConversation(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{
+		DefaultModel: ai.MockModel,
+		MaxHistory:   10,
+	})
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if _, err := store.CreateChat(ctx, "chat-1", "New chat", ai.MockModel, now); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	if _, err := service.RegenerateTitle(ctx, auth.Principal{}, "chat-1"); !errors.Is(err, ErrNothingToTitle) {
+		t.Fatalf("RegenerateTitle() of an empty chat error = %v, want ErrNothingToTitle", err)
+	}
+	if err := store.InsertMessage(ctx, db.Message{ID: "m1", ChatID: "chat-1", Role: "user", Content: "Plan a weekend in Lisbon", Status: "complete", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("InsertMessage() error = %v", err)
+	}
+
+	// The mock model's first line stands in for a title.
+	title, err := service.RegenerateTitle(ctx, auth.Principal{}, "chat-1")
+	if want := "This reply comes from the offline mock model; nothing was sent to a provider"; err != nil || title != want {
+		t.Fatalf("RegenerateTitle() = %q, %v; want %q", title, err, want)
+	}
+	if chat, err := store.GetChat(ctx, "chat-1"); err != nil || chat.Title != title {
+		t.Fatalf("chat = %+v, %v; want it renamed to %q", chat, err, title)
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	for reply, want := range map[string]string{
+		"Weekend in Lisbon":                  "Weekend in Lisbon",
+		"  \"Weekend in Lisbon.\"  \nEnjoy!": "Weekend in Lisbon",
+		"Title: **Lisbon trip**":             "Lisbon trip",
+		"«Voyage à Lisbonne»":                "Voyage à Lisbonne",
+		"\n\n":                               "",
+	} {
+		if got := cleanTitle(reply); got != want {
+			t.Errorf("cleanTitle(%q) = %q, want %q", reply, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/db"
+)
+
+var (
+	// ErrSharingDisabled is returned when no share signing key is
+	// configured.
+	ErrSharingDisabled = errors.New("chat sharing is not enabled")
+	// ErrShareLinkInvalid is returned for share tokens that are malformed,
+	// forged or revoked, without saying which.
+	ErrShareLinkInvalid = errors.New("share link is invalid or has been revoked")
+)
+
+// maxSharedMessages bounds the messages a share link shows.
+const maxSharedMessages = 500
+
+// ShareLink is a public, read-only link to a chat. Token is the signed
+// value that goes in the /share/{token} URL.
+type ShareLink struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SharedChat is what a share link shows: the chat's title and the
+// messages that may leave the owner's view.
+type SharedChat struct {
+	Title    string
+	Messages []Message
+}
+
+// SharingEnabled reports whether a share signing key is configured.
+func (s *Service) SharingEnabled() bool {
+	return s.settings().ShareSigningKey != ""
+}
+
+// CreateShareLink makes a new public link to a chat.
+func (s *Service) CreateShareLink(ctx context.Context, principal auth.Principal, chatID string) (ShareLink, error) {
+	if !s.SharingEnabled() {
+		return ShareLink{}, ErrSharingDisabled
+	}
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link := db.ShareLink{
+		ID:        uuid.NewString(),
+		ChatID:    chat.ID,
+		CreatedBy: principal.UserID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.CreateShareLink(ctx, link); err != nil {
+		return ShareLink{}, err
+	}
+	return s.shareLink(link), nil
+}
+
+// ShareLinks returns a chat's links that still work, oldest first.
+func (s *Service) ShareLinks(ctx context.Context, principal auth.Principal, chatID string) ([]ShareLink, error) {
+	if !s.SharingEnabled() {
+		return []ShareLink{}, nil
+	}
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.store.ListShareLinks(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]ShareLink, 0, len(rows))
+	for _, row := range rows {
+		links = append(links, s.shareLink(row))
+	}
+	return links, nil
+}
+
+// RevokeShareLink stops one of a chat's links from working.
+func (s *Service) RevokeShareLink(ctx context.Context, principal auth.Principal, chatID, linkID string) error {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return err
+	}
+	return s.store.RevokeShareLink(ctx, chat.ID, strings.TrimSpace(linkID), time.Now().UTC())
+}
+
+// SharedChat returns the chat a share token points at. Anyone holding the
+// token may read it, so sensitive and hidden messages are left out along
+// with replies that have no text.
+func (s *Service) SharedChat(ctx context.Context, token string) (SharedChat, error) {
+	if !s.SharingEnabled() {
+		return SharedChat{}, ErrSharingDisabled
+	}
+	linkID, ok := s.verifyShareToken(strings.TrimSpace(token))
+	if !ok {
+		return SharedChat{}, ErrShareLinkInvalid
+	}
+	link, err := s.store.GetShareLink(ctx, linkID)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && link.RevokedAt.Valid) {
+		return SharedChat{}, ErrShareLinkInvalid
+	}
+	if err != nil {
+		return SharedChat{}, err
+	}
+	chat, err := s.store.GetChat(ctx, link.ChatID)
+	if err != nil {
+		return SharedChat{}, err
+	}
+	rows, err := s.ListShareableMessages(ctx, chat.ID, maxSharedMessages)
+	if err != nil {
+		return SharedChat{}, err
+	}
+	shared := SharedChat{Title: chat.Title, Messages: make([]Message, 0, len(rows))}
+	for _, row := range rows {
+		if strings.TrimSpace(row.Content) == "" {
+			continue
+		}
+		shared.Messages = append(shared.Messages, row)
+	}
+	return shared, nil
+}
+
+func (s *Service) shareLink(row db.ShareLink) ShareLink {
+	return ShareLink{ID: row.ID, ChatID: row.ChatID, Token: s.signShareToken(row.ID), CreatedAt: row.CreatedAt}
+}
+
+// signShareToken returns the token for a link: its id and an HMAC of the
+// id under the share signing key, so tokens cannot be guessed from ids.
+func (s *Service) signShareToken(linkID string) string {
+	mac := hmac.New(sha256.New, []byte(s.settings().ShareSigningKey))
+	mac.Write([]byte(linkID))
+	return linkID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) verifyShareToken(token string) (string, bool) {
+	linkID, _, found := strings.Cut(token, ".")
+	if !found || linkID == "" {
+		return "", false
+	}
+	return linkID, hmac.Equal([]byte(token), []byte(s.signShareToken(linkID)))
+}
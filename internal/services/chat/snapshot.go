@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+const snapshotEncodingGzip = "gzip+json"
+
+// ErrSnapshotCorrupt is returned when a stored snapshot no longer matches
+// its recorded hash.
+var ErrSnapshotCorrupt = errors.New("run snapshot does not match its hash")
+
+// saveRunSnapshot stores request as the run's compressed, hashed snapshot.
+func (s *Service) saveRunSnapshot(ctx context.Context, runID string, request RunRequest) error {
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encode run snapshot: %w", err)
+	}
+	digest := sha256.Sum256(encoded)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(encoded); err != nil {
+		return fmt.Errorf("compress run snapshot: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("compress run snapshot: %w", err)
+	}
+	return s.store.SaveRunSnapshot(ctx, db.RunSnapshot{
+		RunID:     runID,
+		SHA256:    hex.EncodeToString(digest[:]),
+		Encoding:  snapshotEncodingGzip,
+		Data:      compressed.Bytes(),
+		SizeBytes: int64(len(encoded)),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// RunSnapshot returns the exact request a run sent to the model, checking
+// it against the stored hash. Runs recorded before compressed snapshots
+// fall back to the legacy runs.request_json column.
+func (s *Service) RunSnapshot(ctx context.Context, run db.Run) (RunRequest, error) {
+	var encoded []byte
+	snapshot, err := s.store.GetRunSnapshot(ctx, run.ID)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		if run.RequestJSON == "" {
+			return RunRequest{}, ErrNoRunSnapshot
+		}
+		encoded = []byte(run.RequestJSON)
+	case err != nil:
+		return RunRequest{}, err
+	default:
+		if snapshot.Encoding != snapshotEncodingGzip {
+			return RunRequest{}, fmt.Errorf("unknown run snapshot encoding %q", snapshot.Encoding)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(snapshot.Data))
+		if err != nil {
+			return RunRequest{}, fmt.Errorf("decompress run snapshot: %w", err)
+		}
+		encoded, err = io.ReadAll(reader)
+		if err != nil {
+			return RunRequest{}, fmt.Errorf("decompress run snapshot: %w", err)
+		}
+		digest := sha256.Sum256(encoded)
+		if hex.EncodeToString(digest[:]) != snapshot.SHA256 {
+			return RunRequest{}, ErrSnapshotCorrupt
+		}
+	}
+	var request RunRequest
+	if err := json.Unmarshal(encoded, &request); err != nil {
+		return RunRequest{}, fmt.Errorf("decode run snapshot: %w", err)
+	}
+	return request, nil
+}
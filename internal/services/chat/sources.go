@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/db"
+)
+
+// Source is a web page an assistant message drew on. ToolCallID is the
+// provider's ID for the tool call that read or found it.
+type Source struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	ToolCallID  string    `json:"tool_call_id,omitempty"`
+	RetrievedAt time.Time `json:"retrieved_at"`
+}
+
+// recordToolSources stores the pages a completed tool call read or found
+// against the assistant message of its run. output is the full tool result,
+// before it is truncated for storage.
+func (s *Service) recordToolSources(ctx context.Context, callID, output string, retrievedAt time.Time) error {
+	found := ai.ToolSources(output)
+	if len(found) == 0 {
+		return nil
+	}
+	sources := make([]db.MessageSource, 0, len(found))
+	for _, source := range found {
+		sources = append(sources, db.MessageSource{URL: source.URL, Title: truncateText(source.Title, 300)})
+	}
+	return s.store.AddToolCallSources(ctx, callID, sources, retrievedAt)
+}
+
+// MessageSources lists the sources of one message in retrieval order.
+func (s *Service) MessageSources(ctx context.Context, messageID string) ([]Source, error) {
+	rows, err := s.store.ListMessageSources(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Source, 0, len(rows))
+	for _, row := range rows {
+		sources = append(sources, sourceFromRow(row))
+	}
+	return sources, nil
+}
+
+// ChatSources returns the sources of each message in a chat, keyed by
+// message ID. Messages without sources are absent.
+func (s *Service) ChatSources(ctx context.Context, chatID string) (map[string][]Source, error) {
+	rows, err := s.store.ListChatSources(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string][]Source)
+	for _, row := range rows {
+		sources[row.MessageID] = append(sources[row.MessageID], sourceFromRow(row))
+	}
+	return sources, nil
+}
+
+func sourceFromRow(row db.MessageSource) Source {
+	return Source{URL: row.URL, Title: row.Title, ToolCallID: row.ToolCallID, RetrievedAt: row.RetrievedAt.UTC()}
+}
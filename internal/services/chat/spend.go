@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+)
+
+// ErrSpendCeilingReached is returned when a chat has spent its ceiling and
+// may not start another run.
+var ErrSpendCeilingReached = errors.New("chat spend ceiling reached")
+
+// ChatSpend is what a chat's runs have cost so far against its ceiling.
+// CeilingUSD is zero when the chat has no ceiling.
+type ChatSpend struct {
+	SpentUSD   float64
+	CeilingUSD float64
+	Exceeded   bool
+}
+
+func (s ChatSpend) Limited() bool {
+	return s.CeilingUSD > 0
+}
+
+// ChatSpend reports a chat's spend and ceiling. Spend counts the runs whose
+// cost is known: reported by the provider or priced from the model's list
+// price.
+func (s *Service) ChatSpend(ctx context.Context, chatID string) (ChatSpend, error) {
+	chat, err := s.store.GetChat(ctx, strings.TrimSpace(chatID))
+	if err != nil {
+		return ChatSpend{}, err
+	}
+	spent, err := s.store.ChatSpendUSD(ctx, chat.ID)
+	if err != nil {
+		return ChatSpend{}, err
+	}
+	spend := ChatSpend{SpentUSD: spent}
+	if chat.MaxSpendUSD.Valid {
+		spend.CeilingUSD = chat.MaxSpendUSD.Float64
+		spend.Exceeded = spent >= spend.CeilingUSD
+	}
+	return spend, nil
+}
+
+// SetChatSpendCeiling sets the chat's ceiling from a dollar amount such as
+// "5" or "$2.50"; blank removes it.
+func (s *Service) SetChatSpendCeiling(ctx context.Context, principal auth.Principal, chatID, input string) (ChatSpend, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return ChatSpend{}, err
+	}
+	ceiling, err := parseSpendCeiling(input)
+	if err != nil {
+		return ChatSpend{}, err
+	}
+	if err := s.store.SetChatMaxSpend(ctx, chat.ID, ceiling, time.Now().UTC()); err != nil {
+		return ChatSpend{}, err
+	}
+	return s.ChatSpend(ctx, chat.ID)
+}
+
+// checkChatSpend returns ErrSpendCeilingReached once the chat has spent its
+// ceiling.
+func (s *Service) checkChatSpend(ctx context.Context, chatID string) error {
+	spend, err := s.ChatSpend(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if spend.Exceeded {
+		return fmt.Errorf("%w: spent %s of %s", ErrSpendCeilingReached, FormatUSD(spend.SpentUSD), FormatUSD(spend.CeilingUSD))
+	}
+	return nil
+}
+
+// runCost prices a finished run, or returns null when its cost is unknown.
+func runCost(model string, result StreamResult) sql.NullFloat64 {
+	cost, ok := ai.CostUSD(model, result.Usage)
+	return sql.NullFloat64{Float64: cost, Valid: ok}
+}
+
+func parseSpendCeiling(input string) (sql.NullFloat64, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(input), "$")
+	if trimmed == "" {
+		return sql.NullFloat64{}, nil
+	}
+	value, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || value <= 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return sql.NullFloat64{}, fmt.Errorf("spend ceiling must be a positive dollar amount, got %q", input)
+	}
+	return sql.NullFloat64{Float64: value, Valid: true}, nil
+}
+
+// FormatUSD formats a dollar amount with cents, or with four decimals below
+// a dollar where single runs usually land.
+func FormatUSD(value float64) string {
+	if value != 0 && math.Abs(value) < 1 {
+		return fmt.Sprintf("$%.4f", value)
+	}
+	return fmt.Sprintf("$%.2f", value)
+}
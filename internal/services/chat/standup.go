@@ -0,0 +1,284 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"rhone_chat/internal/db"
+)
+
+// StandupConfig controls the daily standup digest.
+type StandupConfig struct {
+	// Hour is the local hour (0-23) at which the previous day is summarized.
+	Hour     int
+	Location *time.Location
+	// ChatIDs limits the digests to these chats; empty covers every chat.
+	// Each owner's digest only ever covers chats they own.
+	ChatIDs []string
+	Model   string
+}
+
+const (
+	standupChatSetting  = "standup.digest_chat_id"
+	standupChatTitle    = "Daily standup"
+	standupMessageBytes = 2000
+	standupMaxBytes     = 60000
+)
+
+const standupPrompt = `You write a short daily standup digest from chat transcripts.
+Group the summary by chat. For each chat list what was worked on, decisions made and open questions, in a few bullets.
+Finish with a short "Follow-ups" list. Do not invent anything that is not in the transcripts.`
+
+// Next returns the first standup time after after, making the config a
+// schedule for the jobs scheduler.
+func (cfg StandupConfig) Next(after time.Time) time.Time {
+	return nextStandupTime(after, cfg.Hour, cfg.Location)
+}
+
+func (cfg StandupConfig) String() string {
+	location := cfg.Location
+	if location == nil {
+		location = time.Local
+	}
+	return fmt.Sprintf("daily at %02d:00 %s", cfg.Hour, location)
+}
+
+// StandupJob returns the scheduled job that summarizes the day before each
+// scheduled run.
+func (s *Service) StandupJob(cfg StandupConfig, logger *slog.Logger) func(ctx context.Context, scheduled time.Time) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, scheduled time.Time) error {
+		digests, err := s.RunStandup(ctx, cfg, scheduled.AddDate(0, 0, -1))
+		for _, digest := range digests {
+			logger.Info("standup digest written", "chat_id", digest.ID, "owner_id", digest.OwnerID.String)
+		}
+		return err
+	}
+}
+
+// RunStandup summarizes the activity of day (a calendar day in
+// cfg.Location) into one digest chat per chat owner, each covering only
+// that owner's chats, and returns the digests written.
+func (s *Service) RunStandup(ctx context.Context, cfg StandupConfig, day time.Time) ([]Chat, error) {
+	location := cfg.Location
+	if location == nil {
+		location = time.Local
+	}
+	model := cfg.Model
+	if !s.IsAllowedModel(model) {
+		model = s.settings().DefaultModel
+	}
+	day = day.In(location)
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+
+	owners, err := s.store.ListChatOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var wanted map[string]bool
+	if len(cfg.ChatIDs) > 0 {
+		wanted = make(map[string]bool, len(cfg.ChatIDs))
+		for _, id := range cfg.ChatIDs {
+			wanted[id] = true
+		}
+	}
+	var digests []Chat
+	var errs []error
+	for _, owner := range owners {
+		digest, err := s.runOwnerStandup(ctx, owner, wanted, model, start)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if digest.ID != "" {
+			digests = append(digests, digest)
+		}
+	}
+	return digests, errors.Join(errs...)
+}
+
+// runOwnerStandup writes one owner's digest from the chats they own, limited
+// to wanted when it is set. Owners without such chats get no digest.
+func (s *Service) runOwnerStandup(ctx context.Context, owner string, wanted map[string]bool, model string, start time.Time) (Chat, error) {
+	owned, err := s.store.ListOwnedChats(ctx, owner)
+	if err != nil {
+		return Chat{}, err
+	}
+	digestID, err := s.standupChatID(ctx, owner)
+	if err != nil {
+		return Chat{}, err
+	}
+	var chatIDs []string
+	titles := make(map[string]string, len(owned))
+	for _, chat := range owned {
+		if chat.ID == digestID || (wanted != nil && !wanted[chat.ID]) {
+			continue
+		}
+		chatIDs = append(chatIDs, chat.ID)
+		titles[chat.ID] = chat.Title
+	}
+	if len(chatIDs) == 0 {
+		return Chat{}, nil
+	}
+
+	digest, err := s.standupChat(ctx, owner, digestID, model)
+	if err != nil {
+		return Chat{}, err
+	}
+	activity, err := s.store.ListActivity(ctx, chatIDs, digest.ID, start, start.AddDate(0, 0, 1), 0)
+	if err != nil {
+		return Chat{}, err
+	}
+
+	run := PendingRun{
+		RunID:              uuid.NewString(),
+		ChatID:             digest.ID,
+		UserMessageID:      uuid.NewString(),
+		AssistantMessageID: uuid.NewString(),
+		Model:              model,
+	}
+	request := "Standup for " + start.Format("Monday, January 2, 2006")
+	if err := s.PersistRunStart(ctx, run, request); err != nil {
+		return Chat{}, err
+	}
+
+	transcript := buildStandupTranscript(activity, titles, standupMaxBytes)
+	if transcript == "" {
+		content := "No chat activity on " + start.Format("January 2") + "."
+		if _, err := s.CompleteAssistant(ctx, run.AssistantMessageID, content, "completed"); err != nil {
+			return Chat{}, err
+		}
+		return digest, s.CompleteRun(ctx, run, "completed", StreamResult{StopReason: "no_activity"}, "")
+	}
+
+	var content strings.Builder
+	result, streamErr := s.Stream(ctx, model, []AIMessage{
+		{Role: "system", Content: standupPrompt},
+		{Role: "user", Content: request + "\n\n" + transcript},
+	}, StreamOptions{RunID: run.RunID, DisableTools: true}, StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			content.WriteString(delta)
+		},
+	})
+	status := "completed"
+	errText := ""
+	if streamErr != nil {
+		status = "error"
+		result.StopReason, errText = s.StreamFailure(model, streamErr)
+	}
+	if _, err := s.CompleteAssistant(ctx, run.AssistantMessageID, content.String(), status); err != nil {
+		return Chat{}, err
+	}
+	if err := s.CompleteRun(ctx, run, status, result, errText); err != nil {
+		return Chat{}, err
+	}
+	if streamErr != nil {
+		return digest, streamErr
+	}
+	return digest, nil
+}
+
+// standupChatKey is the setting holding an owner's digest chat ID. Unowned
+// chats keep the key used before digests were split by owner.
+func standupChatKey(owner string) string {
+	if owner == "" {
+		return standupChatSetting
+	}
+	return standupChatSetting + ":" + owner
+}
+
+// standupChatID returns the owner's recorded digest chat ID, or "" before
+// the first digest.
+func (s *Service) standupChatID(ctx context.Context, owner string) (string, error) {
+	chatID, err := s.store.GetSetting(ctx, standupChatKey(owner))
+	if errors.Is(err, db.ErrNotFound) {
+		return "", nil
+	}
+	return chatID, err
+}
+
+// standupChat returns the owner's digest chat, creating it, owned by them,
+// on first use or when the recorded one was deleted.
+func (s *Service) standupChat(ctx context.Context, owner, chatID, model string) (Chat, error) {
+	if chatID != "" {
+		chat, err := s.store.GetChat(ctx, chatID)
+		if err == nil && chat.OwnerID.String == owner {
+			return chat, nil
+		}
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return Chat{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	chat, err := s.store.CreateOwnedChat(ctx, uuid.NewString(), standupChatTitle, model, owner, now)
+	if err != nil {
+		return Chat{}, err
+	}
+	s.publishChatCreated(ctx, chat)
+	if err := s.store.SetSetting(ctx, standupChatKey(owner), chat.ID, now); err != nil {
+		return Chat{}, err
+	}
+	return chat, nil
+}
+
+// buildStandupTranscript renders activity grouped by chat, skipping flagged
+// messages and stopping once maxBytes is reached. Messages must be ordered
+// by chat.
+func buildStandupTranscript(messages []Message, titles map[string]string, maxBytes int) string {
+	var out strings.Builder
+	currentChat := ""
+	omitted := 0
+	for _, message := range messages {
+		if !message.Shareable() {
+			continue
+		}
+		var entry strings.Builder
+		if message.ChatID != currentChat {
+			title := titles[message.ChatID]
+			if title == "" {
+				title = "Untitled chat"
+			}
+			fmt.Fprintf(&entry, "\n## %s\n", title)
+		}
+		role := "User"
+		if message.Role == "assistant" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&entry, "%s: %s\n", role, truncateText(strings.TrimSpace(message.Content), standupMessageBytes))
+		if out.Len()+entry.Len() > maxBytes {
+			omitted++
+			continue
+		}
+		currentChat = message.ChatID
+		out.WriteString(entry.String())
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&out, "\n(%d more messages omitted)\n", omitted)
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// nextStandupTime returns the first occurrence of hour:00 in location after
+// now.
+func nextStandupTime(now time.Time, hour int, location *time.Location) time.Time {
+	if location == nil {
+		location = time.Local
+	}
+	if hour < 0 || hour > 23 {
+		hour = 8
+	}
+	local := now.In(location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, 0, 0, 0, location)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/auth"
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+)
+
+func TestNextStandupTime(t *testing.T) {
+	location := time.FixedZone("test", 2*60*60)
+	before := time.Date(2026, 3, 10, 7, 30, 0, 0, location)
+	if got := nextStandupTime(before, 8, location); !got.Equal(time.Date(2026, 3, 10, 8, 0, 0, 0, location)) {
+		t.Fatalf("nextStandupTime(before) = %s", got)
+	}
+	after := time.Date(2026, 3, 10, 8, 0, 0, 0, location)
+	if got := nextStandupTime(after, 8, location); !got.Equal(time.Date(2026, 3, 11, 8, 0, 0, 0, location)) {
+		t.Fatalf("nextStandupTime(after) = %s", got)
+	}
+}
+
+func TestBuildStandupTranscriptGroupsByChatAndSkipsFlagged(t *testing.T) {
+	messages := []Message{
+		{ChatID: "a", Role: "user", Content: "Plan the release"},
+		{ChatID: "a", Role: "assistant", Content: "Here is a plan"},
+		{ChatID: "b", Role: "user", Content: "secret", Flag: db.MessageFlagSensitive},
+		{ChatID: "b", Role: "user", Content: "Fix the bug"},
+	}
+	transcript := buildStandupTranscript(messages, map[string]string{"a": "Release", "b": "Bugs"}, 10000)
+	want := "## Release\nUser: Plan the release\nAssistant: Here is a plan\n\n## Bugs\nUser: Fix the bug"
+	if transcript != want {
+		t.Fatalf("transcript = %q, want %q", transcript, want)
+	}
+	truncated := buildStandupTranscript(messages, map[string]string{"a": "Release", "b": "Bugs"}, 45)
+	if strings.Contains(truncated, "Fix the bug") || !strings.Contains(truncated, "(2 more messages omitted)") {
+		t.Fatalf("truncated transcript = %q", truncated)
+	}
+}
+
+func TestRunStandupWithoutActivityWritesDigestChat(t *testing.T) {
+	store := newTestStore(t)
+	service := newTestService(store)
+	ctx := context.Background()
+	location := time.UTC
+	day := time.Date(2026, 3, 9, 12, 0, 0, 0, location)
+
+	if _, err := store.CreateChat(ctx, "chat-1", "Work", config.DefaultModel, day); err != nil {
+		t.Fatalf("CreateChat() error = %v", err)
+	}
+	outside := day.AddDate(0, 0, 1)
+	if err := store.InsertMessage(ctx, db.Message{ID: "m1", ChatID: "chat-1", Role: "user", Content: "tomorrow", Status: "complete", CreatedAt: outside, UpdatedAt: outside}); err != nil {
+		t.Fatalf("InsertMessage() error = %v", err)
+	}
+
+	digests, err := service.RunStandup(ctx, StandupConfig{Location: location}, day)
+	if err != nil || len(digests) != 1 {
+		t.Fatalf("RunStandup() = %v, %v; want one digest", digests, err)
+	}
+	again, err := service.RunStandup(ctx, StandupConfig{Location: location}, day)
+	if err != nil || len(again) != 1 {
+		t.Fatalf("RunStandup() second = %v, %v; want one digest", again, err)
+	}
+	digest := digests[0]
+	if again[0].ID != digest.ID {
+		t.Fatalf("digest chat changed from %s to %s", digest.ID, again[0].ID)
+	}
+
+	messages, err := store.ListMessages(ctx, digest.ID, 10)
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("len(messages) = %d, want two standups of two messages", len(messages))
+	}
+	for _, message := range messages {
+		if message.Role == "assistant" && !strings.HasPrefix(message.Content, "No chat activity on March 9") {
+			t.Fatalf("assistant message = %q, want no-activity note", message.Content)
+		}
+	}
+}
+
+func TestRunStandupWritesOneDigestPerOwner(t *testing.T) {
+	store := newTestStore(t)
+	service := NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+	ctx := context.Background()
+	day := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	for _, owner := range []string{"alice", "bob"} {
+		chatID := owner + "-chat"
+		if _, err := store.CreateOwnedChat(ctx, chatID, owner+" project", ai.MockModel, owner, day); err != nil {
+			t.Fatalf("CreateOwnedChat(%s) error = %v", owner, err)
+		}
+		if err := store.InsertMessage(ctx, db.Message{ID: owner + "-m1", ChatID: chatID, Role: "user", Content: owner + " private notes", Status: "complete", CreatedAt: day, UpdatedAt: day}); err != nil {
+			t.Fatalf("InsertMessage(%s) error = %v", owner, err)
+		}
+	}
+
+	digests, err := service.RunStandup(ctx, StandupConfig{Location: time.UTC}, day)
+	if err != nil {
+		t.Fatalf("RunStandup() error = %v", err)
+	}
+	if len(digests) != 2 {
+		t.Fatalf("len(digests) = %d, want one per owner", len(digests))
+	}
+	for _, digest := range digests {
+		owner := digest.OwnerID.String
+		other := map[string]string{"alice": "bob", "bob": "alice"}[owner]
+		if other == "" {
+			t.Fatalf("digest %s owner = %q, want alice or bob", digest.ID, owner)
+		}
+		messages, err := store.ListMessages(ctx, digest.ID, 10)
+		if err != nil {
+			t.Fatalf("ListMessages() error = %v", err)
+		}
+		var text strings.Builder
+		for _, message := range messages {
+			text.WriteString(message.Content)
+		}
+		if !strings.Contains(text.String(), owner+" private notes") {
+			t.Fatalf("%s digest = %q, want their own activity", owner, text.String())
+		}
+		if strings.Contains(text.String(), other) {
+			t.Fatalf("%s digest = %q, leaks %s's chats", owner, text.String(), other)
+		}
+		visible, err := service.ListChats(ctx, auth.Principal{UserID: other}, "", 10)
+		if err != nil {
+			t.Fatalf("ListChats(%s) error = %v", other, err)
+		}
+		for _, chat := range visible.Chats {
+			if chat.ID == digest.ID {
+				t.Fatalf("%s can see %s's digest", other, owner)
+			}
+		}
+	}
+}
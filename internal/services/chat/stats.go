@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/metrics"
+)
+
+// AdminStats summarizes the server for administrators: the runs started
+// since Since by model, the database size and the runs in flight in this
+// process, the health of the server's provider keys when a provider has
+// several, and the circuit breakers of the models run in this process.
+type AdminStats struct {
+	Since         time.Time          `json:"since"`
+	Runs          RunStats           `json:"runs"`
+	Models        []ModelStats       `json:"models"`
+	DatabaseBytes int64              `json:"database_bytes"`
+	Live          LiveRunStats       `json:"live"`
+	ProviderKeys  []ai.KeyHealth     `json:"provider_keys,omitempty"`
+	Breakers      []ai.BreakerHealth `json:"breakers,omitempty"`
+}
+
+// RunStats counts runs by outcome. Failed counts errors and timeouts;
+// ErrorRate is Failed over the runs that finished.
+type RunStats struct {
+	Total        int64   `json:"total"`
+	Running      int64   `json:"running"`
+	Completed    int64   `json:"completed"`
+	Failed       int64   `json:"failed"`
+	Cancelled    int64   `json:"cancelled"`
+	Interrupted  int64   `json:"interrupted"`
+	ErrorRate    float64 `json:"error_rate"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// ModelStats is RunStats for one model.
+type ModelStats struct {
+	Model string `json:"model"`
+	RunStats
+}
+
+// LiveRunStats describes the runs executing in this process right now.
+// QueuedRuns counts the runs waiting for a stream slot.
+type LiveRunStats struct {
+	ActiveStreams int                  `json:"active_streams"`
+	QueuedRuns    int                  `json:"queued_runs"`
+	Draining      bool                 `json:"draining"`
+	Metrics       metrics.RunsSnapshot `json:"metrics"`
+}
+
+// AdminStats reports run statistics for the runs started at or after since.
+// Callers check that the principal is an administrator.
+func (s *Service) AdminStats(ctx context.Context, since time.Time) (AdminStats, error) {
+	byModel, err := s.store.RunStatsByModel(ctx, since)
+	if err != nil {
+		return AdminStats{}, err
+	}
+	size, err := s.store.SizeBytes(ctx)
+	if err != nil {
+		return AdminStats{}, err
+	}
+	stats := AdminStats{
+		Since:         since,
+		Models:        make([]ModelStats, 0, len(byModel)),
+		DatabaseBytes: size,
+		Live: LiveRunStats{
+			ActiveStreams: s.ActiveRuns(),
+			QueuedRuns:    s.queue.queuedRuns(),
+			Draining:      s.Draining(),
+			Metrics:       s.metrics.Snapshot(),
+		},
+	}
+	for _, row := range byModel {
+		model := RunStats{
+			Total:        row.Runs,
+			Running:      row.Running,
+			Completed:    row.Completed,
+			Failed:       row.Errors + row.TimedOut,
+			Cancelled:    row.Cancelled,
+			Interrupted:  row.Interrupted,
+			InputTokens:  row.InputTokens,
+			OutputTokens: row.OutputTokens,
+			CostUSD:      row.CostUSD,
+		}
+		model.ErrorRate = errorRate(model)
+		stats.Models = append(stats.Models, ModelStats{Model: row.Model, RunStats: model})
+
+		stats.Runs.Total += model.Total
+		stats.Runs.Running += model.Running
+		stats.Runs.Completed += model.Completed
+		stats.Runs.Failed += model.Failed
+		stats.Runs.Cancelled += model.Cancelled
+		stats.Runs.Interrupted += model.Interrupted
+		stats.Runs.InputTokens += model.InputTokens
+		stats.Runs.OutputTokens += model.OutputTokens
+		stats.Runs.CostUSD += model.CostUSD
+	}
+	stats.Runs.ErrorRate = errorRate(stats.Runs)
+	if s.runner != nil {
+		stats.ProviderKeys = s.runner.KeyHealth()
+		stats.Breakers = s.runner.Breakers()
+	}
+	return stats, nil
+}
+
+func errorRate(stats RunStats) float64 {
+	finished := stats.Total - stats.Running
+	if finished <= 0 {
+		return 0
+	}
+	return float64(stats.Failed) / float64(finished)
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/db"
+)
+
+const (
+	summaryMessageBytes = 2000
+	summaryMaxBytes     = 60000
+)
+
+const summaryPrompt = `You maintain a running summary of the earlier part of a conversation that no longer fits in the model's context.
+Merge the previous summary, if any, with the new messages into one concise summary.
+Keep facts, decisions, names, numbers, open questions and anything the user asked to remember. Drop pleasantries.
+Write plain prose or short bullets, at most about 300 words. Do not invent anything.`
+
+// droppedMessage is a message that fell out of the history window.
+type droppedMessage struct {
+	ID      string
+	Role    string
+	Content string
+}
+
+// summarizeDropped returns a summary of the messages trimmed from the start
+// of the history, reusing and extending the stored summary. It returns ""
+// when summarization is disabled or fails, in which case the messages are
+// simply dropped.
+func (s *Service) summarizeDropped(ctx context.Context, chatID string, dropped []droppedMessage) string {
+	if len(dropped) == 0 || !s.settings().SummaryEnabled || s.runner == nil {
+		return ""
+	}
+	through := dropped[len(dropped)-1].ID
+	previous, err := s.store.GetChatSummary(ctx, chatID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		slog.Warn("load chat summary failed", "chat_id", chatID, "error", err)
+		return ""
+	}
+	if previous.ThroughMessageID == through {
+		return previous.Content
+	}
+
+	// Only summarize what the stored summary does not cover yet. When its
+	// cutoff is gone (e.g. messages were deleted) start over.
+	pending := dropped
+	previousContent := ""
+	for i, message := range dropped {
+		if message.ID == previous.ThroughMessageID {
+			pending = dropped[i+1:]
+			previousContent = previous.Content
+			break
+		}
+	}
+
+	model := s.settings().SummaryModel
+	if !s.IsAllowedModel(model) {
+		model = s.DefaultModel()
+	}
+	var content strings.Builder
+	_, err = s.runner.Stream(ctx, model, []AIMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: buildSummaryRequest(previousContent, pending)},
+	}, StreamOptions{DisableTools: true}, StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			content.WriteString(delta)
+		},
+	})
+	summary := strings.TrimSpace(content.String())
+	if err != nil || summary == "" {
+		slog.Warn("chat summary failed", "chat_id", chatID, "model", model, "error", err)
+		return previousContent
+	}
+	if err := s.store.SaveChatSummary(ctx, db.ChatSummary{
+		ChatID:           chatID,
+		ThroughMessageID: through,
+		Content:          summary,
+		Model:            model,
+		UpdatedAt:        time.Now().UTC(),
+	}); err != nil {
+		slog.Warn("save chat summary failed", "chat_id", chatID, "error", err)
+	}
+	return summary
+}
+
+// buildSummaryRequest renders the previous summary and the new messages,
+// keeping the newest messages when the transcript exceeds summaryMaxBytes.
+func buildSummaryRequest(previous string, messages []droppedMessage) string {
+	lines := make([]string, 0, len(messages))
+	size := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		line := fmt.Sprintf("%s: %s", messages[i].Role, truncateText(strings.TrimSpace(messages[i].Content), summaryMessageBytes))
+		if size+len(line) > summaryMaxBytes {
+			break
+		}
+		size += len(line)
+		lines = append(lines, line)
+	}
+	var request strings.Builder
+	if previous != "" {
+		request.WriteString("Previous summary:\n")
+		request.WriteString(previous)
+		request.WriteString("\n\n")
+	}
+	request.WriteString("New messages:\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		request.WriteString("\n")
+		request.WriteString(lines[i])
+	}
+	return request.String()
+}
@@ -0,0 +1,109 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"rhone_chat/internal/auth"
+)
+
+const (
+	titleMessageBytes = 1000
+	titleMaxBytes     = 12000
+	// maxTitleBytes is the longest title RenameChat accepts.
+	maxTitleBytes = 200
+)
+
+const titlePrompt = `You name conversations. Reply with a short title for the conversation below: at most six words, in the language of the conversation, saying what it is about now rather than how it began.
+Reply with the title only, without quotes, a "Title:" label or a trailing period.`
+
+// ErrNothingToTitle is returned when regenerating the title of a chat that
+// has no messages to name it from.
+var ErrNothingToTitle = errors.New("chat has no messages to title")
+
+// RegenerateTitle names a chat the principal may use after its
+// conversation as it stands, with the title model, and renames it. The
+// newest messages are kept when the conversation is long, so a chat whose
+// topic drifted is named for where it ended up. Hidden and sensitive
+// messages are left out, since the title shows wherever the chat is shared.
+func (s *Service) RegenerateTitle(ctx context.Context, principal auth.Principal, chatID string) (string, error) {
+	chat, err := s.authorizeChat(ctx, principal, chatID)
+	if err != nil {
+		return "", err
+	}
+	messages, err := s.ListShareableMessages(ctx, chat.ID, 500)
+	if err != nil {
+		return "", err
+	}
+	transcript := buildTitleRequest(messages)
+	if transcript == "" {
+		return "", ErrNothingToTitle
+	}
+
+	model := s.settings().TitleModel
+	if !s.IsAllowedModel(model) {
+		model = s.DefaultModel()
+	}
+	var content strings.Builder
+	if _, err := s.runner.Stream(ctx, model, []AIMessage{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: transcript},
+	}, StreamOptions{DisableTools: true}, StreamCallbacks{
+		OnTextDelta: func(delta string) {
+			content.WriteString(delta)
+		},
+	}); err != nil {
+		return "", err
+	}
+	title := cleanTitle(content.String())
+	if title == "" {
+		return "", fmt.Errorf("model %s returned no title", model)
+	}
+	if err := s.RenameChat(ctx, chat.ID, title); err != nil {
+		return "", err
+	}
+	return title, nil
+}
+
+// buildTitleRequest renders the conversation for the title model, keeping
+// the newest messages when it exceeds titleMaxBytes.
+func buildTitleRequest(messages []Message) string {
+	lines := make([]string, 0, len(messages))
+	size := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := messages[i]
+		content := strings.TrimSpace(message.Content)
+		if content == "" || (message.Role != "user" && message.Role != "assistant") {
+			continue
+		}
+		line := fmt.Sprintf("%s: %s", message.Role, truncateText(content, titleMessageBytes))
+		if size+len(line) > titleMaxBytes {
+			break
+		}
+		size += len(line)
+		lines = append(lines, line)
+	}
+	var request strings.Builder
+	for i := len(lines) - 1; i >= 0; i-- {
+		if request.Len() > 0 {
+			request.WriteString("\n\n")
+		}
+		request.WriteString(lines[i])
+	}
+	return request.String()
+}
+
+// cleanTitle reduces a model's reply to the title it holds: its first
+// line, without a label, quotes or a trailing period.
+func cleanTitle(reply string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(reply), "\n")
+	title = strings.TrimSpace(title)
+	if label, rest, ok := strings.Cut(title, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "title") {
+		title = rest
+	}
+	title = strings.Trim(strings.TrimSpace(title), "\"'`*“”‘’«» ")
+	title = strings.TrimSuffix(title, ".")
+	return truncateText(strings.TrimSpace(title), maxTitleBytes)
+}
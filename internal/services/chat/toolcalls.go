@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/auth"
+)
+
+// ToolCallDetail is a tool call as stored: the first 4000 bytes of its
+// input and output, rather than the preview streamed to the UI. Input and
+// Output are pretty-printed when they are JSON.
+type ToolCallDetail struct {
+	ID         string     `json:"id"`
+	RunID      string     `json:"run_id"`
+	ChatID     string     `json:"chat_id"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Input      string     `json:"input"`
+	Output     string     `json:"output"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ToolCallDetail returns a stored tool call from a chat the principal may
+// use.
+func (s *Service) ToolCallDetail(ctx context.Context, principal auth.Principal, callID string) (ToolCallDetail, error) {
+	call, chatID, err := s.store.GetToolCall(ctx, strings.TrimSpace(callID))
+	if err != nil {
+		return ToolCallDetail{}, err
+	}
+	if _, err := s.authorizeChat(ctx, principal, chatID); err != nil {
+		return ToolCallDetail{}, err
+	}
+	detail := ToolCallDetail{
+		ID:        call.ID,
+		RunID:     call.RunID,
+		ChatID:    chatID,
+		Name:      call.Name,
+		Status:    call.Status,
+		Input:     PrettyJSON(call.InputJSON),
+		Output:    PrettyJSON(call.OutputJSON),
+		Error:     call.ErrorText,
+		StartedAt: call.StartedAt.UTC(),
+	}
+	if call.FinishedAt.Valid {
+		finishedAt := call.FinishedAt.Time.UTC()
+		detail.FinishedAt = &finishedAt
+	}
+	return detail, nil
+}
+
+// PrettyJSON indents text that is a JSON document and returns anything
+// else, such as JSON cut short by truncation, unchanged.
+func PrettyJSON(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return text
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(trimmed), "", "  "); err != nil {
+		return text
+	}
+	return out.String()
+}
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/db"
+)
+
+var (
+	// ErrInvalidTool is returned for a webhook tool definition that cannot
+	// be registered.
+	ErrInvalidTool = errors.New("invalid tool")
+	// ErrToolExists is returned when a webhook tool would replace a built-in,
+	// configured or MCP tool of the same name.
+	ErrToolExists = errors.New("a tool with that name already exists")
+)
+
+// WebhookToolInput defines a webhook tool; see ai.WebhookTool.
+type WebhookToolInput struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+	URL         string          `json:"url"`
+	AuthHeader  string          `json:"auth_header"`
+}
+
+// WebhookToolInfo describes a webhook tool to administrators. The auth
+// header's value is never shown, only its name.
+type WebhookToolInfo struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	InputSchema    json.RawMessage `json:"input_schema,omitempty"`
+	URL            string          `json:"url"`
+	AuthHeaderName string          `json:"auth_header_name,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// LoadWebhookTools registers the stored webhook tools with the runner. A
+// tool that cannot be registered, such as one whose name a configured tool
+// took since, is skipped and reported in the returned error.
+func (s *Service) LoadWebhookTools(ctx context.Context) error {
+	stored, err := s.store.ListWebhookTools(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, tool := range stored {
+		if err := s.registerWebhookTool(tool); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WebhookTools lists the stored webhook tools.
+func (s *Service) WebhookTools(ctx context.Context) ([]WebhookToolInfo, error) {
+	stored, err := s.store.ListWebhookTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tools := make([]WebhookToolInfo, 0, len(stored))
+	for _, tool := range stored {
+		tools = append(tools, webhookToolInfo(tool))
+	}
+	return tools, nil
+}
+
+// SaveWebhookTool stores a webhook tool, or replaces the one of the same
+// name, and offers it from the next run on.
+func (s *Service) SaveWebhookTool(ctx context.Context, input WebhookToolInput) (WebhookToolInfo, error) {
+	now := time.Now().UTC()
+	tool := db.WebhookTool{
+		Name:        strings.TrimSpace(input.Name),
+		Description: strings.TrimSpace(input.Description),
+		URL:         strings.TrimSpace(input.URL),
+		AuthHeader:  strings.TrimSpace(input.AuthHeader),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if len(input.InputSchema) > 0 && string(input.InputSchema) != "null" {
+		tool.InputSchemaJSON = string(input.InputSchema)
+	}
+	if _, err := s.webhookTool(tool); err != nil {
+		return WebhookToolInfo{}, err
+	}
+	if existing, ok := s.toolRegistry().Lookup(tool.Name); ok && existing.Source != ai.ToolSourceWebhook {
+		return WebhookToolInfo{}, fmt.Errorf("%w: %s", ErrToolExists, tool.Name)
+	}
+	if err := s.store.SaveWebhookTool(ctx, tool); err != nil {
+		return WebhookToolInfo{}, err
+	}
+	s.toolRegistry().Unregister(tool.Name)
+	if err := s.registerWebhookTool(tool); err != nil {
+		return WebhookToolInfo{}, err
+	}
+	// A replaced tool keeps its creation time, which only the store knows.
+	saved, err := s.WebhookTools(ctx)
+	if err != nil {
+		return WebhookToolInfo{}, err
+	}
+	for _, info := range saved {
+		if info.Name == tool.Name {
+			return info, nil
+		}
+	}
+	return webhookToolInfo(tool), nil
+}
+
+// DeleteWebhookTool removes a webhook tool. Runs already in flight keep it
+// until they finish.
+func (s *Service) DeleteWebhookTool(ctx context.Context, name string) error {
+	name = strings.TrimSpace(name)
+	if err := s.store.DeleteWebhookTool(ctx, name); err != nil {
+		return err
+	}
+	if existing, ok := s.toolRegistry().Lookup(name); ok && existing.Source == ai.ToolSourceWebhook {
+		s.toolRegistry().Unregister(name)
+	}
+	return nil
+}
+
+func (s *Service) webhookTool(tool db.WebhookTool) (ai.Tool, error) {
+	built, err := ai.NewWebhookTool(ai.WebhookTool{
+		Name:        tool.Name,
+		Description: tool.Description,
+		InputSchema: json.RawMessage(tool.InputSchemaJSON),
+		URL:         tool.URL,
+		AuthHeader:  tool.AuthHeader,
+	})
+	if err != nil {
+		return ai.Tool{}, fmt.Errorf("%w: %v", ErrInvalidTool, err)
+	}
+	return built, nil
+}
+
+func (s *Service) registerWebhookTool(tool db.WebhookTool) error {
+	built, err := s.webhookTool(tool)
+	if err != nil {
+		return err
+	}
+	if err := s.toolRegistry().Register(built); err != nil {
+		return fmt.Errorf("%w: %v", ErrToolExists, err)
+	}
+	return nil
+}
+
+func webhookToolInfo(tool db.WebhookTool) WebhookToolInfo {
+	info := WebhookToolInfo{
+		Name:        tool.Name,
+		Description: tool.Description,
+		URL:         tool.URL,
+		CreatedAt:   tool.CreatedAt,
+		UpdatedAt:   tool.UpdatedAt,
+	}
+	if tool.InputSchemaJSON != "" {
+		info.InputSchema = json.RawMessage(tool.InputSchemaJSON)
+	}
+	if name, _, ok := strings.Cut(tool.AuthHeader, ":"); ok {
+		info.AuthHeaderName = strings.TrimSpace(name)
+	}
+	return info
+}
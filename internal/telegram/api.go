@@ -0,0 +1,176 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxMessageRunes keeps messages under Telegram's limit of 4096
+// characters, which it counts in UTF-16 units, with room for the few
+// characters that take two.
+const maxMessageRunes = 4000
+
+// maxRateLimitWait bounds how long a call waits out a rate limit before
+// giving up.
+const maxRateLimitWait = 10 * time.Second
+
+// apiError is an error the Bot API answered with.
+type apiError struct {
+	Method      string
+	Code        int
+	Description string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("telegram %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// apiClient calls the Bot API as the bot.
+type apiClient struct {
+	// baseURL ends with the bot's token, so it is never logged.
+	baseURL string
+	client  *http.Client
+}
+
+// call invokes method with params, waiting out rate limits a few times,
+// and decodes the result into out when it is not nil.
+func (c *apiClient) call(ctx context.Context, method string, params, out any) error {
+	if params == nil {
+		params = map[string]any{}
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+method, bytes.NewReader(encoded))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			// The error holds the URL, and with it the token.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("telegram %s: request failed", method)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("telegram %s: %w", method, err)
+		}
+		var envelope struct {
+			OK          bool            `json:"ok"`
+			Result      json.RawMessage `json:"result"`
+			ErrorCode   int             `json:"error_code"`
+			Description string          `json:"description"`
+			Parameters  struct {
+				RetryAfter int `json:"retry_after"`
+			} `json:"parameters"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return fmt.Errorf("telegram %s: %s: %s", method, resp.Status, strings.TrimSpace(string(data[:min(len(data), 300)])))
+		}
+		if envelope.ErrorCode == http.StatusTooManyRequests && attempt < 3 {
+			wait := min(time.Duration(envelope.Parameters.RetryAfter)*time.Second, maxRateLimitWait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		if !envelope.OK {
+			return &apiError{Method: method, Code: envelope.ErrorCode, Description: envelope.Description}
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(envelope.Result, out)
+	}
+}
+
+// reply is the bot's answer to a message, spread over as many Telegram
+// messages as its length needs. Updating it edits the messages that changed
+// and sends the ones that are new.
+type reply struct {
+	api     *apiClient
+	chatID  int64
+	replyTo int64
+	ids     []int64
+	sent    []string
+}
+
+func (r *reply) update(ctx context.Context, text string) error {
+	chunks := splitMessage(strings.TrimSpace(text), maxMessageRunes)
+	for i, chunk := range chunks {
+		if i < len(r.ids) {
+			if r.sent[i] == chunk {
+				continue
+			}
+			if err := r.api.call(ctx, "editMessageText", map[string]any{
+				"chat_id":    r.chatID,
+				"message_id": r.ids[i],
+				"text":       chunk,
+			}, nil); err != nil {
+				return err
+			}
+			r.sent[i] = chunk
+			continue
+		}
+		params := map[string]any{"chat_id": r.chatID, "text": chunk}
+		if i == 0 && r.replyTo != 0 {
+			params["reply_parameters"] = map[string]any{"message_id": r.replyTo, "allow_sending_without_reply": true}
+		}
+		var sent struct {
+			MessageID int64 `json:"message_id"`
+		}
+		if err := r.api.call(ctx, "sendMessage", params, &sent); err != nil {
+			return err
+		}
+		r.ids = append(r.ids, sent.MessageID)
+		r.sent = append(r.sent, chunk)
+	}
+	// Output filters can leave the final reply shorter than the streamed
+	// text.
+	for len(r.ids) > len(chunks) && len(chunks) > 0 {
+		last := len(r.ids) - 1
+		if err := r.api.call(ctx, "deleteMessage", map[string]any{"chat_id": r.chatID, "message_id": r.ids[last]}, nil); err != nil {
+			return err
+		}
+		r.ids, r.sent = r.ids[:last], r.sent[:last]
+	}
+	return nil
+}
+
+// splitMessage cuts text into pieces of at most limit characters, breaking
+// at the last line break of each piece when one falls in its second half.
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	for text != "" {
+		if utf8.RuneCountInString(text) <= limit {
+			chunks = append(chunks, text)
+			break
+		}
+		cut := 0
+		for i := 0; i < limit; i++ {
+			_, size := utf8.DecodeRuneInString(text[cut:])
+			cut += size
+		}
+		if newline := strings.LastIndexByte(text[:cut], '\n'); newline >= cut/2 {
+			cut = newline + 1
+		}
+		chunks = append(chunks, strings.TrimRight(text[:cut], "\n"))
+		text = text[cut:]
+	}
+	return chunks
+}
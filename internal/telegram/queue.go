@@ -0,0 +1,46 @@
+package telegram
+
+import "sync"
+
+// queue runs the work queued under each key one piece after another, in
+// the order it was queued, while different keys run side by side.
+type queue struct {
+	mu      sync.Mutex
+	pending map[string][]func()
+	wg      sync.WaitGroup
+}
+
+func (q *queue) add(key string, fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.wg.Add(1)
+	if waiting, busy := q.pending[key]; busy {
+		q.pending[key] = append(waiting, fn)
+		return
+	}
+	if q.pending == nil {
+		q.pending = map[string][]func(){}
+	}
+	q.pending[key] = nil
+	go q.drain(key, fn)
+}
+
+func (q *queue) drain(key string, fn func()) {
+	for fn != nil {
+		fn()
+		q.wg.Done()
+		q.mu.Lock()
+		if waiting := q.pending[key]; len(waiting) > 0 {
+			fn, q.pending[key] = waiting[0], waiting[1:]
+		} else {
+			delete(q.pending, key)
+			fn = nil
+		}
+		q.mu.Unlock()
+	}
+}
+
+// wait returns once all queued work is done.
+func (q *queue) wait() {
+	q.wg.Wait()
+}
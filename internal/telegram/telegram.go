@@ -0,0 +1,440 @@
+// Package telegram runs a Telegram bot backed by the chat service. Each
+// Telegram chat, private or group, is mapped to its own chat in the app, so
+// the conversation and its history are the same on both sides, and replies
+// stream into Telegram by editing the bot's message as tokens arrive.
+//
+// The bot either long-polls for updates or, when Config.WebhookURL is set,
+// registers a webhook served by Handler.
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rhone_chat/internal/auth"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+const (
+	// Integration names the bot's chats among the integration chats.
+	Integration = "telegram"
+
+	DefaultAPIURL       = "https://api.telegram.org"
+	DefaultUserID       = "telegram"
+	DefaultEditInterval = time.Second
+
+	// pollTimeout is how long one getUpdates call waits for updates.
+	pollTimeout = 30 * time.Second
+	// secretHeader carries the webhook's secret token on each update.
+	secretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+const helpText = "Send me a message and I'll answer with the same assistant, and the same history, as the app. In groups, mention me or reply to me. /new starts a new conversation."
+
+// Config configures the bot.
+type Config struct {
+	// Token is the bot token from @BotFather.
+	Token string
+	// UserID owns the bot's chats; their runs count against its quota.
+	UserID string
+	// AllowedUsers limits the bot to these Telegram user IDs or usernames;
+	// empty lets anyone who finds the bot use it.
+	AllowedUsers []string
+	// WebhookURL, when set, is where Telegram posts updates instead of the
+	// bot polling for them. It must reach Handler.
+	WebhookURL string
+	// EditInterval is the least time between two edits of a streaming
+	// reply, which keeps the bot under Telegram's rate limits.
+	EditInterval time.Duration
+	// APIURL defaults to Telegram's.
+	APIURL     string
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Bot answers Telegram messages in the app chat of their Telegram chat.
+type Bot struct {
+	chats     *chatsvc.Service
+	api       *apiClient
+	principal auth.Principal
+	cfg       Config
+	logger    *slog.Logger
+	// secret authenticates webhook requests; it is new on every start.
+	secret string
+	// answers runs each chat's answers in order, so every run sees the
+	// previous one's reply.
+	answers queue
+
+	mu sync.Mutex
+	// ctx is Run's context, which webhook updates are answered under; nil
+	// until the bot is running.
+	ctx      context.Context
+	username string
+	// mention matches "@username" in any case.
+	mention *regexp.Regexp
+}
+
+// New returns a bot answering in chats of chats.
+func New(chats *chatsvc.Service, cfg Config) *Bot {
+	if cfg.UserID == "" {
+		cfg.UserID = DefaultUserID
+	}
+	if cfg.EditInterval <= 0 {
+		cfg.EditInterval = DefaultEditInterval
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = DefaultAPIURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: pollTimeout + 15*time.Second}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	secret := make([]byte, 24)
+	_, _ = rand.Read(secret)
+	return &Bot{
+		chats:     chats,
+		api:       &apiClient{baseURL: strings.TrimRight(cfg.APIURL, "/") + "/bot" + cfg.Token, client: cfg.HTTPClient},
+		principal: auth.Principal{UserID: cfg.UserID, Name: "Telegram"},
+		cfg:       cfg,
+		logger:    cfg.Logger,
+		secret:    hex.EncodeToString(secret),
+	}
+}
+
+// Run answers messages until ctx is done: it registers the webhook and
+// waits when one is configured, or polls for updates otherwise. It returns
+// after the answers in progress are sent, or early when Telegram rejects the
+// token.
+func (b *Bot) Run(ctx context.Context) error {
+	defer b.answers.wait()
+	me, err := retry(ctx, b.logger, func() (user, error) {
+		var me user
+		return me, b.api.call(ctx, "getMe", nil, &me)
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.ctx, b.username = ctx, me.Username
+	b.mention = regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(me.Username) + `\b`)
+	b.mu.Unlock()
+	b.logger.Info("telegram bot connected", "username", me.Username, "webhook", b.cfg.WebhookURL != "")
+
+	if b.cfg.WebhookURL != "" {
+		if _, err := retry(ctx, b.logger, func() (bool, error) {
+			return true, b.api.call(ctx, "setWebhook", map[string]any{
+				"url":             b.cfg.WebhookURL,
+				"secret_token":    b.secret,
+				"allowed_updates": []string{"message"},
+			}, nil)
+		}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	// Telegram refuses getUpdates while a webhook is set.
+	if _, err := retry(ctx, b.logger, func() (bool, error) {
+		return true, b.api.call(ctx, "deleteWebhook", nil, nil)
+	}); err != nil {
+		return err
+	}
+	var offset int64
+	for {
+		updates, err := retry(ctx, b.logger, func() ([]update, error) {
+			var updates []update
+			return updates, b.api.call(ctx, "getUpdates", map[string]any{
+				"offset":          offset,
+				"timeout":         int(pollTimeout / time.Second),
+				"allowed_updates": []string{"message"},
+			}, &updates)
+		})
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		for _, update := range updates {
+			offset = max(offset, update.ID+1)
+			b.receive(ctx, update)
+		}
+	}
+}
+
+// retry calls fn until it succeeds, backing off after failures. It stops
+// with ctx, returning a nil error, and on errors retrying cannot fix, such
+// as an invalid token.
+func retry[T any](ctx context.Context, logger *slog.Logger, fn func() (T, error)) (T, error) {
+	backoff := time.Second
+	for {
+		value, err := fn()
+		if err == nil || ctx.Err() != nil {
+			return value, nil
+		}
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusNotFound) {
+			return value, fmt.Errorf("telegram rejected the bot token: %w", err)
+		}
+		logger.Warn("telegram request failed", "error", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return value, nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// Handler receives webhook updates. Requests without the secret registered
+// with the webhook are refused.
+func (b *Bot) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(b.secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		b.mu.Lock()
+		ctx := b.ctx
+		b.mu.Unlock()
+		if ctx == nil || ctx.Err() != nil {
+			http.Error(w, "bot is not running", http.StatusServiceUnavailable)
+			return
+		}
+		var update update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+		b.receive(ctx, update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+type user struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+}
+
+type update struct {
+	ID      int64    `json:"update_id"`
+	Message *message `json:"message"`
+}
+
+// message is the part of a Telegram message the bot reads.
+type message struct {
+	ID   int64 `json:"message_id"`
+	Chat struct {
+		ID int64 `json:"id"`
+		// Type is "private", "group", "supergroup" or "channel".
+		Type  string `json:"type"`
+		Title string `json:"title"`
+	} `json:"chat"`
+	From    *user  `json:"from"`
+	Text    string `json:"text"`
+	ReplyTo *struct {
+		From *user `json:"from"`
+	} `json:"reply_to_message"`
+}
+
+// receive queues an answer to the message of an update when it is meant
+// for the bot: every private message, and group messages that
+// mention the bot or reply to it.
+func (b *Bot) receive(ctx context.Context, update update) {
+	m := update.Message
+	if m == nil || m.From == nil || m.From.IsBot || strings.TrimSpace(m.Text) == "" {
+		return
+	}
+	b.mu.Lock()
+	username, mention := b.username, b.mention
+	b.mu.Unlock()
+	text := strings.TrimSpace(m.Text)
+	command, addressed := "", false
+	if strings.HasPrefix(text, "/") {
+		name, _, _ := strings.Cut(text, " ")
+		name, target, hasTarget := strings.Cut(name, "@")
+		if hasTarget && !strings.EqualFold(target, username) {
+			return
+		}
+		command, addressed = strings.ToLower(name), true
+	}
+	if m.Chat.Type != "private" && !addressed {
+		repliesToBot := m.ReplyTo != nil && m.ReplyTo.From != nil && strings.EqualFold(m.ReplyTo.From.Username, username)
+		if !repliesToBot && !mention.MatchString(text) {
+			return
+		}
+	}
+	prompt := strings.TrimSpace(mention.ReplaceAllString(text, ""))
+	b.answers.add(strconv.FormatInt(m.Chat.ID, 10), func() {
+		b.answer(ctx, m, command, prompt)
+	})
+}
+
+// answer handles a command, or runs prompt in the app chat of m's Telegram
+// chat and streams the reply back. The reply is finished even when ctx ends
+// mid-run, since the run itself saves its outcome.
+func (b *Bot) answer(ctx context.Context, m *message, command, prompt string) {
+	logger := b.logger.With("telegram_chat_id", m.Chat.ID, "message_id", m.ID)
+	reply := &reply{api: b.api, chatID: m.Chat.ID, replyTo: m.ID}
+	finish := func(text string) {
+		finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+		defer cancel()
+		if err := reply.update(finishCtx, text); err != nil {
+			logger.Warn("telegram reply not sent", "error", err)
+		}
+	}
+	if !b.allowed(m.From) {
+		logger.Info("telegram message from a user who is not allowed", "user_id", m.From.ID)
+		finish("Sorry, you are not allowed to use this bot.")
+		return
+	}
+
+	externalID := strconv.FormatInt(m.Chat.ID, 10)
+	switch command {
+	case "":
+	case "/start", "/help":
+		finish(helpText)
+		return
+	case "/new":
+		if _, err := b.chats.NewIntegrationChat(ctx, b.principal, Integration, externalID, title(m)); err != nil {
+			logger.Warn("telegram chat not created", "error", err)
+			finish("Sorry, I couldn't start a new conversation.")
+			return
+		}
+		finish("Started a new conversation.")
+		return
+	default:
+		// Other commands are sent to the model as they are.
+	}
+
+	chat, err := b.chats.IntegrationChat(ctx, b.principal, Integration, externalID, title(m))
+	if err != nil {
+		logger.Warn("telegram chat not found", "error", err)
+		finish("Sorry, I can't answer right now.")
+		return
+	}
+	if err := b.api.call(ctx, "sendChatAction", map[string]any{"chat_id": m.Chat.ID, "action": "typing"}, nil); err != nil {
+		logger.Debug("telegram typing indicator not sent", "error", err)
+	}
+
+	// Edits run beside the stream so a slow Telegram never holds up the
+	// model; each one sends the latest text.
+	var (
+		textMu  sync.Mutex
+		text    strings.Builder
+		changed = make(chan struct{}, 1)
+		done    = make(chan struct{})
+		edited  = make(chan struct{})
+	)
+	go func() {
+		defer close(edited)
+		for {
+			select {
+			case <-done:
+				return
+			case <-changed:
+			}
+			textMu.Lock()
+			partial := text.String()
+			textMu.Unlock()
+			if err := reply.update(ctx, partial); err != nil {
+				logger.Debug("telegram reply not edited", "error", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(b.cfg.EditInterval):
+			}
+		}
+	}()
+
+	var completed chatsvc.RunEvent
+	runErr := b.chats.ExecuteRun(ctx, b.principal, chatsvc.APIRunRequest{ChatID: chat.ID, Content: prompt}, func(event chatsvc.RunEvent) {
+		switch event.Type {
+		case chatsvc.RunEventStreaming:
+			textMu.Lock()
+			text.WriteString(event.Delta)
+			textMu.Unlock()
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		case chatsvc.RunEventCompleted:
+			completed = event
+		}
+	})
+	close(done)
+	<-edited
+
+	if completed.Type == "" {
+		logger.Warn("telegram message not answered", "chat_id", chat.ID, "error", runErr)
+		finish("Sorry, I can't answer right now: " + runErr.Error())
+		return
+	}
+	finish(replyText(completed))
+}
+
+// replyText is the final text of a run's reply, with the reason when the
+// run did not complete.
+func replyText(event chatsvc.RunEvent) string {
+	content := strings.TrimSpace(event.Content)
+	note := ""
+	switch event.Status {
+	case "completed":
+		if content == "" {
+			return "(empty reply)"
+		}
+		return content
+	case "error":
+		note = "Reply failed: " + event.Error
+	default:
+		note = "Reply " + strings.ReplaceAll(event.Status, "_", " ") + "."
+	}
+	if content == "" {
+		return note
+	}
+	return content + "\n\n" + note
+}
+
+func (b *Bot) allowed(from *user) bool {
+	if len(b.cfg.AllowedUsers) == 0 {
+		return true
+	}
+	id := strconv.FormatInt(from.ID, 10)
+	return slices.ContainsFunc(b.cfg.AllowedUsers, func(allowed string) bool {
+		allowed = strings.TrimPrefix(allowed, "@")
+		return allowed == id || (from.Username != "" && strings.EqualFold(allowed, from.Username))
+	})
+}
+
+// title names the app chat of m's Telegram chat.
+func title(m *message) string {
+	if m.Chat.Type != "private" && m.Chat.Title != "" {
+		return "Telegram: " + m.Chat.Title
+	}
+	name := m.From.FirstName
+	if m.From.Username != "" {
+		name = "@" + m.From.Username
+	}
+	return "Telegram chat with " + name
+}
@@ -0,0 +1,202 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rhone_chat/internal/ai"
+	"rhone_chat/internal/config"
+	"rhone_chat/internal/db"
+	chatsvc "rhone_chat/internal/services/chat"
+)
+
+// fakeBotAPI hands out scripted updates once and records the messages the
+// bot sends and edits.
+type fakeBotAPI struct {
+	updates []map[string]any
+
+	mu       sync.Mutex
+	polled   bool
+	nextID   int64
+	messages map[int64]string
+	replyTo  map[int64]int64
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bottoken-1/")
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 401, "description": "Unauthorized"})
+		return
+	}
+	var params struct {
+		MessageID int64  `json:"message_id"`
+		Text      string `json:"text"`
+		Reply     struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"reply_parameters"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&params)
+	var result any = true
+	f.mu.Lock()
+	switch method {
+	case "getMe":
+		result = map[string]any{"id": 99, "is_bot": true, "username": "RhoneBot"}
+	case "getUpdates":
+		if f.polled {
+			f.mu.Unlock()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+			}
+			f.mu.Lock()
+			result = []any{}
+		} else {
+			f.polled = true
+			result = f.updates
+		}
+	case "sendMessage":
+		f.nextID++
+		f.messages[f.nextID] = params.Text
+		f.replyTo[f.nextID] = params.Reply.MessageID
+		result = map[string]any{"message_id": f.nextID}
+	case "editMessageText":
+		f.messages[params.MessageID] = params.Text
+	}
+	f.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func (f *fakeBotAPI) replies() map[int64]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	replies := map[int64]string{}
+	for id, text := range f.messages {
+		replies[f.replyTo[id]] = text
+	}
+	return replies
+}
+
+func textUpdate(id int64, chat map[string]any, text string) map[string]any {
+	return map[string]any{
+		"update_id": id,
+		"message": map[string]any{
+			"message_id": id * 10,
+			"chat":       chat,
+			"from":       map[string]any{"id": 7, "username": "alice", "first_name": "Alice"},
+			"text":       text,
+		},
+	}
+}
+
+// decodeMessage returns the message of a textUpdate as the bot reads it.
+func decodeMessage(t *testing.T, chat map[string]any, text string) *message {
+	t.Helper()
+	encoded, _ := json.Marshal(textUpdate(1, chat, text))
+	var decoded update
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("decode update: %v", err)
+	}
+	return decoded.Message
+}
+
+func TestBotMapsTelegramChatsToChats(t *testing.T) {
+	store, err := db.OpenSQLite(filepath.Join(t.TempDir(), "chat.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	defer store.Close()
+	service := chatsvc.NewService(store, ai.NewRunner(ai.RunnerConfig{MockModel: true}), config.Config{DefaultModel: ai.MockModel, MaxHistory: 10})
+
+	private := map[string]any{"id": 7, "type": "private"}
+	group := map[string]any{"id": -100, "type": "group", "title": "Team"}
+	fake := &fakeBotAPI{
+		messages: map[int64]string{},
+		replyTo:  map[int64]int64{},
+		updates: []map[string]any{
+			textUpdate(1, private, "first question"),
+			textUpdate(2, private, "second question"),
+			textUpdate(3, group, "not for the bot"),
+			textUpdate(4, group, "@rhonebot group question"),
+			textUpdate(5, group, "/new@OtherBot"),
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	bot := New(service, Config{Token: "token-1", EditInterval: 20 * time.Millisecond, APIURL: server.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- bot.Run(ctx) }()
+
+	want := map[int64]string{10: "first question", 20: "second question", 40: "group question"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		replies := fake.replies()
+		done := len(replies) == len(want)
+		for id, said := range want {
+			if !strings.HasSuffix(replies[id], "> "+said) {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replies = %v, want an answer to each of %v", replies, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	ctx = context.Background()
+	chat, err := service.IntegrationChat(ctx, bot.principal, Integration, "7", "")
+	if err != nil || chat.Title != "Telegram chat with @alice" {
+		t.Fatalf("IntegrationChat(7) = %+v, %v", chat, err)
+	}
+	messages, err := service.ListMessages(ctx, chat.ID, 10)
+	if err != nil || len(messages) != 4 || messages[0].Content != "first question" || messages[2].Content != "second question" {
+		t.Fatalf("private chat messages = %+v, %v, want both questions in order", messages, err)
+	}
+	teamChat, err := service.IntegrationChat(ctx, bot.principal, Integration, "-100", "")
+	if err != nil || teamChat.Title != "Telegram: Team" {
+		t.Fatalf("IntegrationChat(-100) = %+v, %v", teamChat, err)
+	}
+
+	// /new starts over in a new chat; the old one stays in the app.
+	bot.answer(ctx, decodeMessage(t, private, "/new"), "/new", "")
+	fresh, err := service.IntegrationChat(ctx, bot.principal, Integration, "7", "")
+	if err != nil || fresh.ID == chat.ID {
+		t.Fatalf("IntegrationChat(7) after /new = %+v, %v, want a new chat", fresh, err)
+	}
+}
+
+func TestBotRefusesUsersNotAllowedAndUnsignedWebhooks(t *testing.T) {
+	fake := &fakeBotAPI{messages: map[int64]string{}, replyTo: map[int64]int64{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	bot := New(nil, Config{Token: "token-1", APIURL: server.URL, AllowedUsers: []string{"@bob", "42"}})
+
+	bot.answer(context.Background(), decodeMessage(t, map[string]any{"id": 7, "type": "private"}, "hello"), "", "hello")
+	if replies := fake.replies(); !strings.Contains(replies[10], "not allowed") {
+		t.Fatalf("replies = %v, want a refusal", replies)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(`{"update_id":1}`))
+	request.Header.Set(secretHeader, "wrong")
+	recorder := httptest.NewRecorder()
+	bot.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("webhook with a wrong secret = %d, want 403", recorder.Code)
+	}
+}
@@ -0,0 +1,311 @@
+// Package theme holds the UI palettes as data: the Tailwind classes each
+// part of the chat page uses, plus CSS custom properties set on the page
+// root. Operators restyle the UI from a JSON file instead of the source.
+package theme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Palette is the class list for each part of the chat page. Overrides may
+// only use classes present in the compiled stylesheet; colors that vary per
+// deployment go through the CSS variables in Theme.Vars instead.
+type Palette struct {
+	AppRoot          string `json:"app_root"`
+	Sidebar          string `json:"sidebar"`
+	SidebarSection   string `json:"sidebar_section"`
+	NewChatButton    string `json:"new_chat_button"`
+	ChatButtonBase   string `json:"chat_button_base"`
+	ChatButtonIdle   string `json:"chat_button_idle"`
+	ChatButtonActive string `json:"chat_button_active"`
+	ChatActionButton string `json:"chat_action_button"`
+	ChatDangerButton string `json:"chat_danger_button"`
+	ChatInput        string `json:"chat_input"`
+	ChatSaveButton   string `json:"chat_save_button"`
+	ChatMeta         string `json:"chat_meta"`
+	Header           string `json:"header"`
+	HeaderTitle      string `json:"header_title"`
+	ModelSelect      string `json:"model_select"`
+	ThemeToggle      string `json:"theme_toggle"`
+	StopButton       string `json:"stop_button"`
+	ErrorText        string `json:"error_text"`
+	ChatBody         string `json:"chat_body"`
+	AssistantBubble  string `json:"assistant_bubble"`
+	UserBubble       string `json:"user_bubble"`
+	ThinkingText     string `json:"thinking_text"`
+	StatusText       string `json:"status_text"`
+	TimerText        string `json:"timer_text"`
+	RetryButton      string `json:"retry_button"`
+	FindBar          string `json:"find_bar"`
+	FindMatch        string `json:"find_match"`
+	FindActive       string `json:"find_active"`
+	RoleText         string `json:"role_text"`
+	ToolCard         string `json:"tool_card"`
+	ToolText         string `json:"tool_text"`
+	ToolErrorText    string `json:"tool_error_text"`
+	Composer         string `json:"composer"`
+	Input            string `json:"input"`
+	SendButton       string `json:"send_button"`
+}
+
+// Theme is a named palette. Mode is "dark" or "light" and picks the
+// markdown colors. Vars are CSS custom properties set on the page root; the
+// built-in palettes read --rc-accent, --rc-accent-hover and --rc-user-border.
+type Theme struct {
+	Name    string
+	Label   string
+	Mode    string
+	Palette Palette
+	Vars    map[string]string
+}
+
+// Style renders Vars as an inline style attribute, sorted by name.
+func (t Theme) Style() string {
+	names := make([]string, 0, len(t.Vars))
+	for name := range t.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+": "+t.Vars[name])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Set is the themes a user may pick from. Dark and light are always present.
+type Set struct {
+	themes  map[string]Theme
+	names   []string
+	Default string
+}
+
+// Defaults returns the built-in dark and light themes.
+func Defaults() *Set {
+	return &Set{
+		themes: map[string]Theme{
+			"dark":  {Name: "dark", Label: "Dark", Mode: "dark", Palette: darkPalette},
+			"light": {Name: "light", Label: "Light", Mode: "light", Palette: lightPalette},
+		},
+		names:   []string{"dark", "light"},
+		Default: "dark",
+	}
+}
+
+// Get returns the named theme, or the default one when name is unknown.
+func (s *Set) Get(name string) Theme {
+	if theme, ok := s.themes[name]; ok {
+		return theme
+	}
+	return s.themes[s.Default]
+}
+
+// Has reports whether name is a theme in the set.
+func (s *Set) Has(name string) bool {
+	_, ok := s.themes[name]
+	return ok
+}
+
+// Themes returns every theme, the built-in ones first and the rest by name.
+func (s *Set) Themes() []Theme {
+	themes := make([]Theme, 0, len(s.names))
+	for _, name := range s.names {
+		themes = append(themes, s.themes[name])
+	}
+	return themes
+}
+
+// themeFile is the JSON layout of a theme file:
+//
+//	{"default": "acme", "themes": {"acme": {"label": "Acme", "extends": "light",
+//	  "palette": {"send_button": "bg-rose-600 text-white"}, "vars": {"--rc-accent": "#e11d48"}}}}
+//
+// A theme named dark or light changes the built-in one. Other themes start
+// from the one they extend, dark unless set, and override the palette
+// fields they list.
+type themeFile struct {
+	Default string `json:"default"`
+	Themes  map[string]struct {
+		Label   string            `json:"label"`
+		Extends string            `json:"extends"`
+		Palette json.RawMessage   `json:"palette"`
+		Vars    map[string]string `json:"vars"`
+	} `json:"themes"`
+}
+
+var (
+	varName    = regexp.MustCompile(`^--[A-Za-z0-9-]+$`)
+	themeName  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+	unsafeVars = ";{}<>\"\\\n\r"
+)
+
+// LoadFile reads a theme file over the built-in themes.
+func LoadFile(path string) (*Set, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read theme file: %w", err)
+	}
+	return Parse(raw)
+}
+
+// Parse reads a theme file's contents over the built-in themes.
+func Parse(raw []byte) (*Set, error) {
+	var file themeFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parse theme file: %w", err)
+	}
+	set := Defaults()
+	// Built-in themes first so custom themes extend the customized ones.
+	names := make([]string, 0, len(file.Themes))
+	for name := range file.Themes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if builtIn(names[i]) != builtIn(names[j]) {
+			return builtIn(names[i])
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		entry := file.Themes[name]
+		if !themeName.MatchString(name) {
+			return nil, fmt.Errorf("theme %q: names are lowercase letters, digits and dashes", name)
+		}
+		base := name
+		if !builtIn(name) {
+			base = entry.Extends
+			if base == "" {
+				base = "dark"
+			}
+			if !set.Has(base) || !builtIn(base) {
+				return nil, fmt.Errorf("theme %q extends %q; extend dark or light", name, base)
+			}
+		} else if entry.Extends != "" {
+			return nil, fmt.Errorf("theme %q is built in and cannot extend another", name)
+		}
+		theme := set.themes[base]
+		theme.Name = name
+		if entry.Label != "" {
+			theme.Label = entry.Label
+		} else if !builtIn(name) {
+			theme.Label = name
+		}
+		if len(entry.Palette) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(entry.Palette))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&theme.Palette); err != nil {
+				return nil, fmt.Errorf("theme %q palette: %w", name, err)
+			}
+		}
+		vars := make(map[string]string, len(theme.Vars)+len(entry.Vars))
+		for key, value := range theme.Vars {
+			vars[key] = value
+		}
+		for key, value := range entry.Vars {
+			value = strings.TrimSpace(value)
+			if !varName.MatchString(key) || value == "" || strings.ContainsAny(value, unsafeVars) {
+				return nil, fmt.Errorf("theme %q: invalid CSS variable %s: %q", name, key, value)
+			}
+			vars[key] = value
+		}
+		theme.Vars = vars
+		set.themes[name] = theme
+		if !slices.Contains(set.names, name) {
+			set.names = append(set.names, name)
+		}
+	}
+	if file.Default != "" {
+		if !set.Has(file.Default) {
+			return nil, fmt.Errorf("default theme %q is not defined", file.Default)
+		}
+		set.Default = file.Default
+	}
+	return set, nil
+}
+
+func builtIn(name string) bool {
+	return name == "dark" || name == "light"
+}
+
+var lightPalette = Palette{
+	AppRoot:          "bg-slate-100 text-slate-900",
+	Sidebar:          "border-r border-slate-300 bg-slate-50",
+	SidebarSection:   "border-b border-slate-300",
+	NewChatButton:    "bg-slate-800 text-white hover:bg-slate-700",
+	ChatButtonBase:   "w-full text-left rounded-md px-3 py-2 text-sm transition-colors border",
+	ChatButtonIdle:   "bg-white border-slate-300 hover:bg-slate-100",
+	ChatButtonActive: "bg-blue-100 border-blue-400",
+	ChatActionButton: "border border-slate-300 bg-white text-slate-700 hover:bg-slate-100",
+	ChatDangerButton: "border border-red-300 bg-white text-red-700 hover:bg-red-100",
+	ChatInput:        "bg-white border border-slate-300 text-slate-900",
+	ChatSaveButton:   "border border-blue-300 bg-[var(--rc-accent,#2563eb)] text-white hover:bg-[var(--rc-accent-hover,#1d4ed8)]",
+	ChatMeta:         "text-slate-500",
+	Header:           "border-b border-slate-300 bg-white",
+	HeaderTitle:      "text-slate-700",
+	ModelSelect:      "bg-white border border-slate-300 text-slate-900",
+	ThemeToggle:      "border-slate-300 text-slate-700 hover:bg-slate-100",
+	StopButton:       "border-red-300 text-red-700 hover:bg-red-100",
+	ErrorText:        "text-red-700",
+	ChatBody:         "bg-white",
+	AssistantBubble:  "bg-transparent border-transparent text-slate-900",
+	UserBubble:       "bg-slate-200 border-[var(--rc-user-border,#2445FF)] text-slate-900",
+	ThinkingText:     "text-slate-600",
+	StatusText:       "text-slate-500",
+	TimerText:        "text-slate-500",
+	RetryButton:      "border border-amber-400 bg-white text-amber-700 hover:bg-amber-50",
+	FindBar:          "border-b border-slate-300 bg-slate-50",
+	FindMatch:        "ring-1 ring-amber-300",
+	FindActive:       "ring-2 ring-amber-500",
+	RoleText:         "text-slate-600",
+	ToolCard:         "border-slate-300 bg-slate-100",
+	ToolText:         "text-slate-700",
+	ToolErrorText:    "text-red-700",
+	Composer:         "border-t border-slate-300 bg-white",
+	Input:            "bg-white border border-slate-300 text-slate-900 placeholder:text-slate-500",
+	SendButton:       "bg-[var(--rc-accent,#2563eb)] text-white hover:bg-[var(--rc-accent-hover,#1d4ed8)]",
+}
+
+var darkPalette = Palette{
+	AppRoot:          "bg-[#0b1320] text-white",
+	Sidebar:          "border-r border-white/10 bg-black",
+	SidebarSection:   "border-b border-white/10",
+	NewChatButton:    "bg-zinc-900 hover:bg-zinc-800 text-white",
+	ChatButtonBase:   "w-full text-left rounded-md px-3 py-2 text-sm transition-colors border border-transparent",
+	ChatButtonIdle:   "bg-zinc-950 hover:bg-zinc-900",
+	ChatButtonActive: "bg-zinc-900 border-white/20",
+	ChatActionButton: "border border-white/20 bg-zinc-950 text-white/90 hover:bg-zinc-900",
+	ChatDangerButton: "border border-red-500/40 bg-zinc-950 text-red-200 hover:bg-red-500/10",
+	ChatInput:        "bg-zinc-950 border border-white/20 text-white",
+	ChatSaveButton:   "border border-blue-400/50 bg-[var(--rc-accent,#2457d6)] text-white hover:bg-[var(--rc-accent-hover,#2e63e0)]",
+	ChatMeta:         "text-white/60",
+	Header:           "border-b border-white/10 bg-black",
+	HeaderTitle:      "text-white/80",
+	ModelSelect:      "bg-zinc-950 border border-white/20 text-white",
+	ThemeToggle:      "border-white/30 text-white hover:bg-white/10",
+	StopButton:       "border-red-400/40 text-red-200 hover:bg-red-400/10",
+	ErrorText:        "text-red-300",
+	ChatBody:         "bg-black",
+	AssistantBubble:  "bg-transparent border-transparent text-white",
+	UserBubble:       "bg-zinc-900 border-[var(--rc-user-border,#2445FF)] text-white",
+	ThinkingText:     "text-white/70",
+	StatusText:       "text-white/50",
+	TimerText:        "text-white/60",
+	RetryButton:      "border border-amber-400/50 bg-zinc-950 text-amber-200 hover:bg-amber-400/10",
+	FindBar:          "border-b border-white/10 bg-black",
+	FindMatch:        "ring-1 ring-amber-300/40",
+	FindActive:       "ring-2 ring-amber-300",
+	RoleText:         "text-white/60",
+	ToolCard:         "border-white/10 bg-black/20",
+	ToolText:         "text-white/70",
+	ToolErrorText:    "text-red-200",
+	Composer:         "border-t border-white/10 bg-black",
+	Input:            "bg-zinc-950 border border-white/20 text-white placeholder:text-white/60",
+	SendButton:       "bg-[var(--rc-accent,#2457d6)] text-white hover:bg-[var(--rc-accent-hover,#2e63e0)]",
+}
@@ -0,0 +1,63 @@
+package theme
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCustomizesBuiltInsAndAddsThemes(t *testing.T) {
+	set, err := Parse([]byte(`{
+		"default": "acme",
+		"themes": {
+			"acme": {"label": "Acme", "extends": "light", "palette": {"send_button": "bg-rose-600 text-white"}},
+			"light": {"vars": {"--rc-accent": "#e11d48"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	acme := set.Get("acme")
+	if acme.Label != "Acme" || acme.Mode != "light" || acme.Palette.SendButton != "bg-rose-600 text-white" {
+		t.Fatalf("acme = %+v", acme)
+	}
+	if acme.Palette.AppRoot != lightPalette.AppRoot {
+		t.Fatalf("acme AppRoot = %q, want the light one", acme.Palette.AppRoot)
+	}
+	// Custom themes extend the customized built-in.
+	if acme.Style() != "--rc-accent: #e11d48" || set.Get("light").Style() != "--rc-accent: #e11d48" {
+		t.Fatalf("Style() = %q, %q", acme.Style(), set.Get("light").Style())
+	}
+	if set.Default != "acme" || set.Get("missing").Name != "acme" {
+		t.Fatalf("Default = %q, Get(missing) = %q; want acme", set.Default, set.Get("missing").Name)
+	}
+	var names []string
+	for _, theme := range set.Themes() {
+		names = append(names, theme.Name)
+	}
+	if strings.Join(names, ",") != "dark,light,acme" {
+		t.Fatalf("Themes() = %v, want dark, light, acme", names)
+	}
+}
+
+func TestParseRejectsInvalidThemes(t *testing.T) {
+	for name, raw := range map[string]string{
+		"unknown palette field": `{"themes": {"acme": {"palette": {"send_buton": "x"}}}}`,
+		"style injection":       `{"themes": {"acme": {"vars": {"--rc-accent": "red; background: url(x)"}}}}`,
+		"bad variable name":     `{"themes": {"acme": {"vars": {"color": "red"}}}}`,
+		"extends a custom one":  `{"themes": {"a": {}, "b": {"extends": "a"}}}`,
+		"unknown default":       `{"default": "acme", "themes": {}}`,
+		"bad theme name":        `{"themes": {"Acme Corp": {}}}`,
+	} {
+		if _, err := Parse([]byte(raw)); err == nil {
+			t.Errorf("Parse(%s) accepted %s", name, raw)
+		}
+	}
+}
+
+func TestDefaultsOfferDarkAndLight(t *testing.T) {
+	set := Defaults()
+	if set.Get("").Name != "dark" || set.Get("light").Mode != "light" || set.Get("light").Style() != "" {
+		t.Fatalf("Defaults() = %+v", set.Themes())
+	}
+}
@@ -0,0 +1,84 @@
+const ACCEPT = "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain,.md,.csv,.json,.txt";
+
+function notifySession(sinkId) {
+  const sink = document.getElementById(sinkId);
+  if (!sink) {
+    return;
+  }
+  sink.value = String(Date.now());
+  sink.dispatchEvent(new Event("input", { bubbles: true }));
+}
+
+const defaultLabels = {
+  "upload.attach_label": "Attach file",
+  "upload.attach_title": "Attach an image, PDF or text file",
+  "upload.uploading": "Uploading…",
+  "upload.failed": "Upload failed",
+};
+
+function label(props, key) {
+  return props?.labels?.[key] ?? defaultLabels[key];
+}
+
+async function upload(props, file, status) {
+  const body = new FormData();
+  body.append("chat_id", props.chatId);
+  body.append("file", file);
+  status.textContent = label(props, "upload.uploading");
+  try {
+    const response = await fetch(props.endpoint, { method: "POST", body, credentials: "same-origin" });
+    if (!response.ok) {
+      status.textContent = (await response.text()).trim() || label(props, "upload.failed");
+      return;
+    }
+    status.textContent = "";
+    notifySession(props.sinkId);
+  } catch (err) {
+    status.textContent = label(props, "upload.failed");
+  }
+}
+
+export function mount(el, props) {
+  let current = props;
+  const input = document.createElement("input");
+  input.type = "file";
+  input.accept = props.accept || ACCEPT;
+  input.multiple = true;
+  input.hidden = true;
+
+  const button = document.createElement("button");
+  button.type = "button";
+  button.className = "rounded-md px-3 py-2 text-sm";
+
+  const status = document.createElement("span");
+  status.className = "text-xs";
+  status.setAttribute("role", "status");
+
+  button.addEventListener("click", () => input.click());
+  input.addEventListener("change", async () => {
+    const files = Array.from(input.files || []);
+    input.value = "";
+    for (const file of files) {
+      await upload(current, file, status);
+    }
+  });
+
+  const render = () => {
+    button.textContent = current?.label || "📎";
+    button.setAttribute("aria-label", current?.label || label(current, "upload.attach_label"));
+    button.title = current?.title || label(current, "upload.attach_title");
+    button.disabled = Boolean(current?.disabled) || !current?.chatId;
+  };
+  el.replaceChildren(button, input, status);
+  render();
+
+  return {
+    update(nextProps) {
+      current = nextProps;
+      render();
+    },
+    destroy() {
+      el.replaceChildren();
+    },
+  };
+}
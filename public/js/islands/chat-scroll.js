@@ -0,0 +1,92 @@
+// Keeps the message list pinned to the bottom while a reply streams in,
+// unless the user has scrolled up to read. Then a "Jump to latest" pill
+// appears until they return to the bottom. ChatRoot bumps the revision
+// prop on every streamed dispatch and passes the pill's label in the UI
+// language.
+
+const PIN_THRESHOLD_PX = 48;
+
+export function mount(el, props) {
+  let current = props;
+  let container = null;
+  let pinned = true;
+  let frame = 0;
+
+  const pill = document.createElement("button");
+  pill.type = "button";
+  pill.className = "chat-scroll-pill";
+  pill.textContent = props?.labels?.["message.jump_to_latest"] ?? "Jump to latest ↓";
+  pill.hidden = true;
+  el.replaceChildren(pill);
+
+  function atBottom() {
+    return container.scrollHeight - container.scrollTop - container.clientHeight <= PIN_THRESHOLD_PX;
+  }
+
+  function scrollToBottom(behavior) {
+    cancelAnimationFrame(frame);
+    // Wait for the patch that carried these props to reach the DOM.
+    frame = requestAnimationFrame(() => {
+      if (container) {
+        container.scrollTo({ top: container.scrollHeight, behavior });
+      }
+    });
+  }
+
+  function onScroll() {
+    pinned = atBottom();
+    if (pinned) {
+      pill.hidden = true;
+    }
+  }
+
+  function attach() {
+    const next = document.getElementById(current?.containerId);
+    if (next === container) {
+      return;
+    }
+    container?.removeEventListener("scroll", onScroll);
+    container = next;
+    container?.addEventListener("scroll", onScroll, { passive: true });
+  }
+
+  pill.addEventListener("click", () => {
+    pinned = true;
+    pill.hidden = true;
+    scrollToBottom("smooth");
+  });
+
+  attach();
+  scrollToBottom("auto");
+
+  return {
+    update(nextProps) {
+      const previous = current;
+      current = nextProps;
+      pill.textContent = current?.labels?.["message.jump_to_latest"] ?? pill.textContent;
+      attach();
+      if (!container) {
+        return;
+      }
+      // A different chat, or a message the user just sent, starts at the
+      // bottom again.
+      const restart = previous?.chatId !== current?.chatId || previous?.followKey !== current?.followKey;
+      if (restart) {
+        pinned = true;
+      } else if (previous?.revision === current?.revision) {
+        return;
+      }
+      if (pinned) {
+        pill.hidden = true;
+        scrollToBottom("auto");
+      } else {
+        pill.hidden = false;
+      }
+    },
+    destroy() {
+      cancelAnimationFrame(frame);
+      container?.removeEventListener("scroll", onScroll);
+      el.replaceChildren();
+    },
+  };
+}
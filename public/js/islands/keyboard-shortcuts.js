@@ -0,0 +1,94 @@
+// Keyboard shortcuts for the chat page. Focus changes stay in the browser;
+// anything that needs the session (send, stop, recall the last message,
+// rename the chat) is written to a hidden sink input as JSON, which ChatRoot
+// handles.
+
+function notifySession(sinkId, shortcut) {
+  const sink = document.getElementById(sinkId);
+  if (!sink) {
+    return;
+  }
+  sink.value = JSON.stringify({ ...shortcut, at: Date.now() });
+  sink.dispatchEvent(new Event("input", { bubbles: true }));
+}
+
+function focusField(id) {
+  const field = document.getElementById(id);
+  if (!field) {
+    return;
+  }
+  field.focus();
+  if (typeof field.select === "function") {
+    field.select();
+  }
+}
+
+// focusWhenShown focuses a field the session is about to render, giving it
+// about half a second to appear.
+function focusWhenShown(id, frames = 30) {
+  const field = document.getElementById(id);
+  if (field) {
+    focusField(id);
+    return;
+  }
+  if (frames > 0) {
+    requestAnimationFrame(() => focusWhenShown(id, frames - 1));
+  }
+}
+
+function plainKey(event) {
+  return !event.shiftKey && !event.ctrlKey && !event.altKey && !event.metaKey;
+}
+
+export function mount(el, props) {
+  let current = props;
+
+  function onKeyDown(event) {
+    if (event.defaultPrevented || event.isComposing) {
+      return;
+    }
+    const target = event.target;
+    const inComposer = target instanceof HTMLElement && target.id === current?.composerId;
+
+    if ((event.ctrlKey || event.metaKey) && !event.altKey && event.key.toLowerCase() === "k") {
+      event.preventDefault();
+      focusField(current?.findId);
+      return;
+    }
+    if (event.key === "Escape" && current?.running) {
+      event.preventDefault();
+      notifySession(current.sinkId, { action: "stop" });
+      return;
+    }
+    if (event.key === "F2" && plainKey(event) && !current?.running) {
+      event.preventDefault();
+      notifySession(current.sinkId, { action: "rename" });
+      focusWhenShown(current.renameId);
+      return;
+    }
+    if (!inComposer) {
+      return;
+    }
+    if (event.key === "Enter" && plainKey(event)) {
+      event.preventDefault();
+      if (!current?.running && target.value.trim() !== "") {
+        notifySession(current.sinkId, { action: "send", text: target.value });
+      }
+      return;
+    }
+    if (event.key === "ArrowUp" && plainKey(event) && target.value === "") {
+      event.preventDefault();
+      notifySession(current.sinkId, { action: "edit-last" });
+    }
+  }
+
+  document.addEventListener("keydown", onKeyDown);
+  return {
+    update(nextProps) {
+      current = nextProps;
+    },
+    destroy() {
+      document.removeEventListener("keydown", onKeyDown);
+    },
+  };
+}
@@ -0,0 +1,48 @@
+// Sentinel at the end of a paged list: when it scrolls into view inside the
+// root element, it asks the session for the next page through a hidden sink
+// input. The cursor prop changes with every page, which re-arms it.
+
+function notifySession(sinkId) {
+  const sink = document.getElementById(sinkId);
+  if (!sink) {
+    return;
+  }
+  sink.value = String(Date.now());
+  sink.dispatchEvent(new Event("input", { bubbles: true }));
+}
+
+export function mount(el, props) {
+  let current = props;
+  let observer = null;
+
+  function observe() {
+    observer?.disconnect();
+    if (typeof IntersectionObserver === "undefined") {
+      return;
+    }
+    observer = new IntersectionObserver(
+      (entries) => {
+        if (entries.some((entry) => entry.isIntersecting)) {
+          observer.disconnect();
+          notifySession(current?.sinkId);
+        }
+      },
+      { root: document.getElementById(current?.rootId), rootMargin: "200px 0px" },
+    );
+    observer.observe(el);
+  }
+
+  observe();
+  return {
+    update(nextProps) {
+      const previous = current;
+      current = nextProps;
+      if (previous?.cursor !== current?.cursor) {
+        observe();
+      }
+    },
+    destroy() {
+      observer?.disconnect();
+    },
+  };
+}
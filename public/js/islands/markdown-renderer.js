@@ -263,21 +263,136 @@ function applyTheme(el, theme) {
   el.dataset.mdTheme = mode;
 }
 
+const copiedResetMs = 1500;
+
+async function writeClipboard(text) {
+  if (navigator.clipboard && window.isSecureContext) {
+    await navigator.clipboard.writeText(text);
+    return;
+  }
+  const scratch = document.createElement("textarea");
+  scratch.value = text;
+  scratch.setAttribute("readonly", "");
+  scratch.style.position = "fixed";
+  scratch.style.opacity = "0";
+  document.body.appendChild(scratch);
+  scratch.select();
+  try {
+    if (!document.execCommand("copy")) {
+      throw new Error("copy command was rejected");
+    }
+  } finally {
+    scratch.remove();
+  }
+}
+
+// rawMarkdown asks the server for the stored message, since the markdown
+// prop may lag behind what was persisted. It falls back to the prop.
+async function rawMarkdown(props) {
+  const fallback = typeof props?.markdown === "string" ? props.markdown : "";
+  if (!props?.rawEndpoint) {
+    return fallback;
+  }
+  try {
+    const response = await fetch(props.rawEndpoint, { credentials: "same-origin" });
+    if (!response.ok) {
+      return fallback;
+    }
+    const message = await response.json();
+    return typeof message?.content === "string" ? message.content : fallback;
+  } catch {
+    return fallback;
+  }
+}
+
+const defaultLabels = {
+  "message.copy": "Copy",
+  "message.copy_message": "Copy message",
+  "message.copy_message_label": "Copy message as markdown",
+  "message.copy_code_label": "Copy code",
+  "message.copied": "Copied",
+  "message.copy_failed": "Copy failed",
+};
+
+function label(props, key) {
+  return props?.labels?.[key] ?? defaultLabels[key];
+}
+
+function copyButton(text, ariaLabel, target) {
+  const button = document.createElement("button");
+  button.type = "button";
+  button.className = "md-copy-button";
+  button.dataset.copy = target;
+  button.textContent = text;
+  button.setAttribute("aria-label", ariaLabel);
+  return button;
+}
+
+function addCopyButtons(el, props) {
+  for (const pre of el.querySelectorAll("pre")) {
+    pre.classList.add("md-code-block");
+    pre.appendChild(copyButton(label(props, "message.copy"), label(props, "message.copy_code_label"), "code"));
+  }
+  if (props?.copyable) {
+    const toolbar = document.createElement("div");
+    toolbar.className = "md-message-toolbar";
+    toolbar.appendChild(
+      copyButton(label(props, "message.copy_message"), label(props, "message.copy_message_label"), "message"),
+    );
+    el.appendChild(toolbar);
+  }
+}
+
+function flashButton(button, label) {
+  const original = button.dataset.label || button.textContent;
+  button.dataset.label = original;
+  button.textContent = label;
+  clearTimeout(Number(button.dataset.resetTimer));
+  button.dataset.resetTimer = String(
+    setTimeout(() => {
+      button.textContent = original;
+    }, copiedResetMs),
+  );
+}
+
 function render(el, props) {
   const markdown = typeof props?.markdown === "string" ? props.markdown : "";
   applyTheme(el, props?.theme);
   el.classList.add("md-renderer");
   el.innerHTML = renderMarkdown(markdown);
   applySyntaxHighlighting(el);
+  addCopyButtons(el, props);
 }
 
 export function mount(el, props) {
+  let current = props;
+  async function onClick(event) {
+    const button = event.target.closest?.("button.md-copy-button");
+    if (!button || !el.contains(button)) {
+      return;
+    }
+    event.preventDefault();
+    try {
+      if (button.dataset.copy === "message") {
+        await writeClipboard(await rawMarkdown(current));
+      } else {
+        await writeClipboard(button.closest("pre")?.querySelector("code")?.textContent ?? "");
+      }
+      flashButton(button, label(current, "message.copied"));
+    } catch {
+      flashButton(button, label(current, "message.copy_failed"));
+    }
+  }
+
+  el.addEventListener("click", onClick);
   render(el, props);
   return {
     update(nextProps) {
+      current = nextProps;
       render(el, nextProps);
     },
     destroy() {
+      el.removeEventListener("click", onClick);
       el.innerHTML = "";
     },
   };
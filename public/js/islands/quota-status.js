@@ -0,0 +1,117 @@
+const defaultLabels = {
+  "quota.exhausted": "Message limit reached.",
+  "quota.exhausted_until": "Message limit reached. More available at %s.",
+  "quota.left_hour": "%d of %d messages left this hour.",
+  "quota.left_hour_one": "%d of %d message left this hour.",
+  "quota.left_day": "%d of %d messages left this day.",
+  "quota.left_day_one": "%d of %d message left this day.",
+  "quota.budget_tokens_day": "%s of %s tokens left today.",
+  "quota.budget_tokens_month": "%s of %s tokens left this month.",
+  "quota.budget_usd_day": "%s of %s left today.",
+  "quota.budget_usd_month": "%s of %s left this month.",
+  "quota.budget_exhausted_day": "Daily usage budget reached. More available at %s.",
+  "quota.budget_exhausted_month": "Monthly usage budget reached. More available on %s.",
+};
+
+// format fills a bundle string's %d and %s verbs in order.
+function format(labels, key, ...args) {
+  const template = labels?.[key] ?? defaultLabels[key] ?? key;
+  let next = 0;
+  return template.replace(/%[ds]/g, () => String(args[next++] ?? ""));
+}
+
+function formatReset(value) {
+  const resetsAt = value ? new Date(value) : null;
+  if (!resetsAt || Number.isNaN(resetsAt.getTime())) {
+    return "";
+  }
+  return resetsAt.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+}
+
+function formatResetDate(value) {
+  const resetsAt = value ? new Date(value) : null;
+  if (!resetsAt || Number.isNaN(resetsAt.getTime())) {
+    return "";
+  }
+  return resetsAt.toLocaleDateString([], { month: "short", day: "numeric" });
+}
+
+function formatAmount(budget, value) {
+  if (budget.unit === "usd") {
+    return `$${value.toFixed(2)}`;
+  }
+  return Math.floor(value).toLocaleString();
+}
+
+function windowText(labels, window) {
+  const reset = formatReset(window.resets_at);
+  if (window.remaining === 0) {
+    return reset ? format(labels, "quota.exhausted_until", reset) : format(labels, "quota.exhausted");
+  }
+  const key = `quota.left_${window.name}${window.remaining === 1 ? "_one" : ""}`;
+  return format(labels, key, window.remaining, window.limit);
+}
+
+function budgetText(labels, budget) {
+  if (budget.remaining <= 0) {
+    const reset = budget.period === "month" ? formatResetDate(budget.resets_at) : formatReset(budget.resets_at);
+    return format(labels, `quota.budget_exhausted_${budget.period}`, reset);
+  }
+  return format(labels, `quota.budget_${budget.unit}_${budget.period}`, formatAmount(budget, budget.remaining), formatAmount(budget, budget.limit));
+}
+
+// tightest picks the window or budget with the smallest share left.
+function tightest(entries) {
+  return entries.slice().sort((a, b) => a.remaining / a.limit - b.remaining / b.limit)[0];
+}
+
+// Run windows only show once they are nearly used up; budgets always show
+// what is left of the tightest one.
+function render(el, status, labels) {
+  const windows = (status?.windows || []).map((window) => ({ ...window, text: () => windowText(labels, window) }));
+  const budgets = (status?.budgets || []).map((budget) => ({ ...budget, text: () => budgetText(labels, budget) }));
+  let entry = null;
+  if (status?.limited && status?.near) {
+    entry = tightest([...windows, ...budgets]);
+  } else if (status?.limited) {
+    entry = tightest(budgets);
+  }
+  if (!entry) {
+    el.textContent = "";
+    el.hidden = true;
+    return;
+  }
+  el.textContent = entry.text();
+  el.dataset.quotaState = entry.remaining <= 0 ? "exhausted" : status.near ? "near" : "ok";
+  el.hidden = false;
+}
+
+async function refresh(el, props) {
+  try {
+    const response = await fetch(props.endpoint, { credentials: "same-origin" });
+    if (!response.ok) {
+      return;
+    }
+    render(el, await response.json(), props.labels);
+  } catch (err) {
+    // Quota feedback is advisory; the server still enforces the limits.
+  }
+}
+
+export function mount(el, props) {
+  let current = props;
+  el.setAttribute("role", "status");
+  refresh(el, current);
+  return {
+    update(nextProps) {
+      const changed = nextProps?.refreshKey !== current?.refreshKey;
+      current = nextProps;
+      if (changed) {
+        refresh(el, current);
+      }
+    },
+    destroy() {
+      el.textContent = "";
+    },
+  };
+}
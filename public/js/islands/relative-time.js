@@ -0,0 +1,106 @@
+// Keeps a message timestamp's "5m ago" text current, with the absolute time
+// in the user's timezone as a tooltip. Matches i18n's RelativeTime, which
+// renders the placeholder, using the labels and language it passes in.
+
+const TICK_MS = 30_000;
+const MINUTE = 60_000;
+const HOUR = 60 * MINUTE;
+const DAY = 24 * HOUR;
+
+const mounted = new Map();
+let timer = 0;
+
+function zoneOf(timezone) {
+  return typeof timezone === "string" && timezone !== "" ? timezone : undefined;
+}
+
+const defaultLabels = {
+  "time.just_now": "just now",
+  "time.minutes_ago": "%dm ago",
+  "time.hours_ago": "%dh ago",
+  "time.days_ago": "%dd ago",
+};
+
+function label(props, key, count) {
+  return (props?.labels?.[key] ?? defaultLabels[key]).replace("%d", String(count));
+}
+
+function relative(props, at, now, timeZone) {
+  const lang = props?.lang || "en-US";
+  const elapsed = now - at;
+  if (elapsed < MINUTE) {
+    return label(props, "time.just_now");
+  }
+  if (elapsed < HOUR) {
+    return label(props, "time.minutes_ago", Math.floor(elapsed / MINUTE));
+  }
+  if (elapsed < DAY) {
+    return label(props, "time.hours_ago", Math.floor(elapsed / HOUR));
+  }
+  if (elapsed < 7 * DAY) {
+    return label(props, "time.days_ago", Math.floor(elapsed / DAY));
+  }
+  const year = (date) => new Intl.DateTimeFormat(lang, { timeZone, year: "numeric" }).format(date);
+  const options = { timeZone, month: "short", day: "numeric" };
+  if (year(new Date(at)) !== year(new Date(now))) {
+    options.year = "numeric";
+  }
+  return new Intl.DateTimeFormat(lang, options).format(new Date(at));
+}
+
+function absolute(lang, at, timeZone) {
+  return new Intl.DateTimeFormat(lang, {
+    timeZone,
+    weekday: "short",
+    year: "numeric",
+    month: "short",
+    day: "numeric",
+    hour: "2-digit",
+    minute: "2-digit",
+    second: "2-digit",
+    timeZoneName: "short",
+  }).format(new Date(at));
+}
+
+function render(el, props) {
+  const at = Number(props?.at);
+  if (!Number.isFinite(at) || at <= 0) {
+    el.textContent = "";
+    return;
+  }
+  let timeZone = zoneOf(props?.timezone);
+  try {
+    el.title = absolute(props?.lang, at, timeZone);
+  } catch {
+    timeZone = undefined;
+    el.title = absolute(props?.lang, at, timeZone);
+  }
+  el.textContent = relative(props, at, Date.now(), timeZone);
+}
+
+function tick() {
+  for (const [el, props] of mounted) {
+    render(el, props);
+  }
+}
+
+export function mount(el, props) {
+  mounted.set(el, props);
+  render(el, props);
+  if (!timer) {
+    timer = setInterval(tick, TICK_MS);
+  }
+  return {
+    update(nextProps) {
+      mounted.set(el, nextProps);
+      render(el, nextProps);
+    },
+    destroy() {
+      mounted.delete(el);
+      if (mounted.size === 0) {
+        clearInterval(timer);
+        timer = 0;
+      }
+    },
+  };
+}
@@ -0,0 +1,41 @@
+function formatSeconds(totalSeconds) {
+  const seconds = Math.max(0, Math.floor(totalSeconds));
+  const minutes = Math.floor(seconds / 60);
+  const rest = seconds % 60;
+  if (minutes === 0) {
+    return `${rest}s`;
+  }
+  return `${minutes}m ${String(rest).padStart(2, "0")}s`;
+}
+
+function render(el, props) {
+  const startedAt = Number(props?.startedAt) || Date.now();
+  const timeoutMs = Number(props?.timeoutMs) || 0;
+  const elapsedMs = Date.now() - startedAt;
+  const elapsed = formatSeconds(elapsedMs / 1000);
+  if (timeoutMs <= 0) {
+    el.textContent = elapsed;
+    return;
+  }
+  const remainingMs = timeoutMs - elapsedMs;
+  const template = props?.labels?.["run.time_left"] ?? "%s · %s left";
+  const parts = [elapsed, formatSeconds(remainingMs / 1000)];
+  el.textContent = template.replace(/%s/g, () => parts.shift() ?? "");
+  el.dataset.runTimerState = remainingMs <= timeoutMs * 0.15 ? "warning" : "normal";
+}
+
+export function mount(el, props) {
+  let current = props;
+  render(el, current);
+  const timer = window.setInterval(() => render(el, current), 1000);
+  return {
+    update(nextProps) {
+      current = nextProps;
+      render(el, current);
+    },
+    destroy() {
+      window.clearInterval(timer);
+      el.textContent = "";
+    },
+  };
+}
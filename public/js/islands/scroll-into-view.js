@@ -0,0 +1,20 @@
+function scrollTo(props) {
+  const targetId = typeof props?.targetId === "string" ? props.targetId : "";
+  if (!targetId) {
+    return;
+  }
+  const target = document.getElementById(targetId);
+  if (target) {
+    target.scrollIntoView({ behavior: "smooth", block: "center" });
+  }
+}
+
+export function mount(el, props) {
+  scrollTo(props);
+  return {
+    update(nextProps) {
+      scrollTo(nextProps);
+    },
+    destroy() {},
+  };
+}